@@ -0,0 +1,218 @@
+// Package archive provides implementations of farm.Archiver, which durably
+// record inserted tuples outside of Redis.
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+var (
+	// ErrQueueFull is returned by Archive when the tuples can't be queued,
+	// because the archiver doesn't keep up with the inserts.
+	ErrQueueFull = errors.New("archive queue full")
+
+	// ErrClosed is returned by Archive after Close.
+	ErrClosed = errors.New("archive closed")
+)
+
+// NDJSON is a farm.Archiver that writes tuples to an io.Writer as
+// newline-delimited JSON, one KeyScoreMember object per line, in the same
+// format as the roshi-server insert API. Archive only queues the tuples; a
+// background goroutine writes them, buffered, and flushes them in batches
+// when the buffer is full, on every flush interval, and on Close. The output
+// is suitable for shipping to long-term storage, like S3.
+//
+// Write errors are sticky: once writing failed, Archive returns the error,
+// and drops tuples, until Reopen succeeds.
+type NDJSON struct {
+	mtx    sync.Mutex
+	err    error
+	queue  chan []common.KeyScoreMember
+	reopen chan chan error
+	quit   chan chan error
+
+	// Owned by the loop.
+	w    *bufio.Writer
+	enc  *json.Encoder
+	file io.WriteCloser                 // nil unless opened by NewNDJSONFile
+	open func() (io.WriteCloser, error) // nil unless opened by NewNDJSONFile
+}
+
+// NewNDJSON returns a new NDJSON archiver writing to w. Up to queueSize calls
+// to Archive are queued for writing. Buffered tuples are flushed at least
+// every flushInterval. Callers must Close the archiver to write remaining
+// tuples.
+func NewNDJSON(w io.Writer, flushInterval time.Duration, queueSize int) *NDJSON {
+	a := &NDJSON{
+		queue:  make(chan []common.KeyScoreMember, queueSize),
+		reopen: make(chan chan error),
+		quit:   make(chan chan error),
+	}
+	a.reset(w)
+	go a.loop(flushInterval)
+	return a
+}
+
+// NewNDJSONFile is like NewNDJSON, but appends to the file at path, which is
+// created if necessary. Reopen closes and reopens the file, so that it can be
+// rotated. Close closes the file.
+func NewNDJSONFile(path string, flushInterval time.Duration, queueSize int) (*NDJSON, error) {
+	open := func() (io.WriteCloser, error) {
+		return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	}
+	f, err := open()
+	if err != nil {
+		return nil, err
+	}
+	a := NewNDJSON(f, flushInterval, queueSize)
+	a.file, a.open = f, open
+	return a, nil
+}
+
+// Archive implements farm.Archiver. It queues the tuples for writing, and
+// returns ErrQueueFull if the queue is full, or the write error if writing
+// failed before.
+func (a *NDJSON) Archive(tuples []common.KeyScoreMember) error {
+	if err := a.error(); err != nil {
+		return err
+	}
+	select {
+	case a.queue <- tuples:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Reopen writes the queued tuples and flushes them. If the archiver writes
+// to a file, the file is then closed and reopened, e.g. after it has been
+// rotated, which clears a sticky write error. Reopen must not be called
+// after Close.
+func (a *NDJSON) Reopen() error {
+	c := make(chan error)
+	a.reopen <- c
+	return <-c
+}
+
+// Close stops the background writer, writes the queued tuples, and flushes
+// them. It doesn't close the underlying io.Writer, unless the archiver was
+// opened by NewNDJSONFile.
+func (a *NDJSON) Close() error {
+	c := make(chan error)
+	a.quit <- c
+	return <-c
+}
+
+func (a *NDJSON) loop(flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case tuples := <-a.queue:
+			a.write(tuples)
+		case <-ticker.C:
+			a.flush()
+		case c := <-a.reopen:
+			c <- a.reopenFile()
+		case c := <-a.quit:
+			a.drain()
+			err := a.flush()
+			if a.file != nil {
+				if closeErr := a.file.Close(); err == nil {
+					err = closeErr
+				}
+			}
+			a.fail(ErrClosed)
+			c <- err
+			return
+		}
+	}
+}
+
+// drain writes the queued tuples.
+func (a *NDJSON) drain() {
+	for {
+		select {
+		case tuples := <-a.queue:
+			a.write(tuples)
+		default:
+			return
+		}
+	}
+}
+
+func (a *NDJSON) write(tuples []common.KeyScoreMember) {
+	if a.error() != nil {
+		return // dropped
+	}
+	for _, tuple := range tuples {
+		if err := a.enc.Encode(tuple); err != nil {
+			a.fail(err) // bufio.Writer errors are sticky
+			return
+		}
+	}
+}
+
+func (a *NDJSON) flush() error {
+	if err := a.error(); err != nil {
+		return err
+	}
+	if err := a.w.Flush(); err != nil {
+		a.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (a *NDJSON) reopenFile() error {
+	a.drain()
+	flushErr := a.flush()
+	if a.open == nil {
+		return flushErr
+	}
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	f, err := a.open()
+	if err != nil {
+		err = fmt.Errorf("reopen: %s", err)
+		a.fail(err)
+		return err
+	}
+	a.file = f
+	a.reset(f)
+	a.mtx.Lock()
+	a.err = nil
+	a.mtx.Unlock()
+	return nil
+}
+
+// reset makes the archiver write to w, discarding any buffered output.
+func (a *NDJSON) reset(w io.Writer) {
+	a.w = bufio.NewWriter(w)
+	a.enc = json.NewEncoder(a.w)
+}
+
+func (a *NDJSON) error() error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.err
+}
+
+func (a *NDJSON) fail(err error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+}
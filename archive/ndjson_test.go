@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestNDJSON(t *testing.T) {
+	var (
+		buf = bytes.Buffer{}
+		a   = NewNDJSON(&buf, time.Hour, 10)
+	)
+
+	tuples := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1.5, Member: "bar"},
+		common.KeyScoreMember{Key: "foo", Score: 2.5, Member: "baz"},
+	}
+	if err := a.Archive(tuples[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Archive(tuples[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		dec      = json.NewDecoder(&buf)
+		archived = []common.KeyScoreMember{}
+	)
+	for dec.More() {
+		var tuple common.KeyScoreMember
+		if err := dec.Decode(&tuple); err != nil {
+			t.Fatal(err)
+		}
+		archived = append(archived, tuple)
+	}
+	if !reflect.DeepEqual(tuples, archived) {
+		t.Errorf("expected %v, got %v", tuples, archived)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestNDJSONErrorsAreSticky(t *testing.T) {
+	a := NewNDJSON(failingWriter{}, time.Hour, 10)
+	defer a.Close()
+
+	tuples := []common.KeyScoreMember{common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"}}
+	if err := a.Archive(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reopen(); err == nil {
+		t.Fatal("expected the flush to fail, got no error")
+	}
+	if err := a.Archive(tuples); err == nil || err.Error() != "disk full" {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+}
+
+func TestNDJSONQueueFull(t *testing.T) {
+	a := NewNDJSON(ioutil.Discard, time.Hour, 0)
+	defer a.Close()
+
+	// Without a queue, Archive only succeeds while the writer is waiting.
+	tuples := []common.KeyScoreMember{common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"}}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		err := a.Archive(tuples)
+		if err == nil {
+			break
+		}
+		if err != ErrQueueFull {
+			t.Fatalf("expected %v, got %v", ErrQueueFull, err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestNDJSONFileReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "archive.ndjson")
+	a, err := NewNDJSONFile(path, time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuples := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"},
+		common.KeyScoreMember{Key: "foo", Score: 2, Member: "baz"},
+	}
+
+	// Rotate the file, as logrotate would, between two tuples.
+	if err := a.Archive(tuples[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Archive(tuples[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{path + ".1", path} {
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tuple common.KeyScoreMember
+		if err := json.Unmarshal(buf, &tuple); err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := tuples[i], tuple; expected != got {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+	if err := a.Archive(tuples); err != ErrClosed {
+		t.Errorf("expected %v after Close, got %v", ErrClosed, err)
+	}
+}
@@ -0,0 +1,26 @@
+// Package audit records write operations, and who requested them, for
+// compliance purposes.
+package audit
+
+import (
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Entry records a single operation.
+type Entry struct {
+	Time      time.Time               `json:"time"`
+	Operation string                  `json:"operation"` // e.g. "delete"
+	Requester string                  `json:"requester"`
+	RequestID string                  `json:"request_id,omitempty"`
+	Tuples    []common.KeyScoreMember `json:"tuples"`
+	Code      int                     `json:"code"`            // HTTP status code of the response
+	Error     string                  `json:"error,omitempty"` // if the operation failed
+}
+
+// Logger durably records audit entries. Implementations must be safe for
+// concurrent use.
+type Logger interface {
+	Log(Entry) error
+}
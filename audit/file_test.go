@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	l, err := NewFile(path, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	e := Entry{
+		Time:      time.Now(),
+		Operation: "delete",
+		Requester: "alice",
+		Tuples:    []common.KeyScoreMember{common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"}},
+		Code:      200,
+	}
+	for i := 0; i < 4; i++ {
+		if err := l.Log(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matches, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) < 2 {
+		t.Fatalf("expected rotated files, got %v", matches)
+	}
+
+	total := 0
+	for _, match := range matches {
+		f, err := os.Open(match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var got Entry
+			if err := json.Unmarshal(s.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Requester != e.Requester || len(got.Tuples) != 1 {
+				t.Errorf("%s: unexpected entry %+v", match, got)
+			}
+			total++
+		}
+		f.Close()
+	}
+	if expected := 4; total != expected {
+		t.Errorf("expected %d entries, got %d", expected, total)
+	}
+}
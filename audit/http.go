@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrQueueFull is returned by HTTP.Log when the entry can't be queued,
+// because the sink doesn't keep up.
+var ErrQueueFull = errors.New("audit queue full")
+
+// HTTP is a Logger that POSTs each entry as a JSON object to a URL, e.g. a
+// log collector. Any response other than 2xx is an error. Entries are queued,
+// and POSTed in the background, so that a slow sink doesn't delay the
+// responses to the requests being audited.
+type HTTP struct {
+	url    string
+	client *http.Client
+	queue  chan Entry
+	done   chan struct{}
+}
+
+// NewHTTP returns a new HTTP Logger, which POSTs to url with the timeout.
+// Up to queueSize entries wait to be POSTed; further entries are dropped.
+// Callers must Close the logger to POST the queued entries.
+func NewHTTP(url string, timeout time.Duration, queueSize int) *HTTP {
+	l := &HTTP{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan Entry, queueSize),
+		done:   make(chan struct{}),
+	}
+	go l.loop()
+	return l
+}
+
+// Log implements Logger. It queues the entry, and returns ErrQueueFull if
+// the queue is full. Errors POSTing the entry are logged.
+func (l *HTTP) Log(e Entry) error {
+	select {
+	case l.queue <- e:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close POSTs the queued entries, and stops the logger. Log must not be
+// called after Close.
+func (l *HTTP) Close() error {
+	close(l.queue)
+	<-l.done
+	return nil
+}
+
+func (l *HTTP) loop() {
+	defer close(l.done)
+	for e := range l.queue {
+		if err := l.post(e); err != nil {
+			log.Printf("audit: %s %s by %s: %s", e.Operation, e.RequestID, e.Requester, err)
+		}
+	}
+}
+
+func (l *HTTP) post(e Entry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := l.client.Post(l.url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit sink returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
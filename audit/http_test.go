@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTP(t *testing.T) {
+	var (
+		received = make(chan Entry)
+		release  = make(chan struct{})
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // a slow sink
+		var e Entry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		received <- e
+	}))
+	defer server.Close()
+
+	// Log doesn't wait for the sink. The first entry is being POSTed, the
+	// second is queued, and the third is dropped.
+	l := NewHTTP(server.URL, time.Second, 1)
+	for i, requester := range []string{"alice", "bob", "carol"} {
+		err := l.Log(Entry{Operation: "delete", Requester: requester})
+		if i < 2 && err != nil {
+			t.Fatalf("%s: %s", requester, err)
+		}
+		if i == 2 && err != ErrQueueFull {
+			t.Fatalf("%s: expected %v, got %v", requester, ErrQueueFull, err)
+		}
+		if i == 0 {
+			for len(l.queue) > 0 {
+				time.Sleep(time.Millisecond) // until it's being POSTed
+			}
+		}
+	}
+
+	close(release)
+	for _, expected := range []string{"alice", "bob"} {
+		if got := (<-received).Requester; expected != got {
+			t.Errorf("expected %s, got %s", expected, got)
+		}
+	}
+	l.Close()
+}
@@ -0,0 +1,107 @@
+// Package backfill provides a priority queue of keys in a Redis instance
+// shared by roshi-servers and roshi-walkers. Servers push the keys in which
+// reads detected divergences, and walkers repair them ahead of the rest of
+// the keyspace.
+package backfill
+
+import (
+	"log"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// maxPendingBatches bounds the batches of keys buffered by a Queue before
+// they're pushed.
+const maxPendingBatches = 1000
+
+// popScript removes and returns up to ARGV[1] of the keys with the highest
+// priority from the queue in KEYS[1], atomically, so that cooperating walkers
+// never pop the same key.
+var popScript = redis.NewScript(1, `
+	local keys = redis.call('ZREVRANGE', KEYS[1], 0, tonumber(ARGV[1]) - 1)
+	if #keys > 0 then
+		redis.call('ZREM', KEYS[1], unpack(keys))
+	end
+	return keys
+`)
+
+// Queue is a farm.Backfiller, and the source of the keys to repair first for
+// walkers. The queue is a sorted set, in which the priority of a key is the
+// number of times it was pushed, i.e. how many reads found it divergent since
+// it was last popped. Beyond the max number of keys, those with the lowest
+// priority are dropped.
+type Queue struct {
+	pool    *redis.Pool
+	key     string
+	maxKeys int
+	pending chan []string
+	done    chan struct{}
+}
+
+// New returns a new Queue in the sorted set at key, on the Redis instance at
+// address, holding at most maxKeys keys, which must be positive. Callers
+// pushing keys must Close the Queue to push the remaining ones.
+func New(address, key string, timeout time.Duration, maxKeys int) *Queue {
+	q := &Queue{
+		pool: &redis.Pool{
+			MaxIdle: 2,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialTimeout("tcp", address, timeout, timeout, timeout)
+			},
+		},
+		key:     key,
+		maxKeys: maxKeys,
+		pending: make(chan []string, maxPendingBatches),
+		done:    make(chan struct{}),
+	}
+	go q.loop()
+	return q
+}
+
+// Backfill implements farm.Backfiller. It never blocks: the keys are pushed
+// in the background, and dropped if too many are buffered already.
+func (q *Queue) Backfill(keys []string) {
+	if len(keys) <= 0 {
+		return
+	}
+	select {
+	case q.pending <- keys:
+	default:
+		log.Printf("backfill: buffer full; %d key(s) dropped", len(keys))
+	}
+}
+
+// Pop removes and returns up to n keys, from the highest priority down. An
+// empty queue returns no keys, and no error.
+func (q *Queue) Pop(n int) ([]string, error) {
+	conn := q.pool.Get()
+	defer conn.Close()
+	return redis.Strings(popScript.Do(conn, q.key, n))
+}
+
+// Close pushes the buffered keys, and releases the connections to Redis.
+func (q *Queue) Close() {
+	close(q.pending)
+	<-q.done
+	q.pool.Close()
+}
+
+func (q *Queue) loop() {
+	defer close(q.done)
+	for keys := range q.pending {
+		if err := q.push(keys); err != nil {
+			log.Printf("backfill: pushing %d key(s): %s", len(keys), err)
+		}
+	}
+}
+
+func (q *Queue) push(keys []string) error {
+	conn := q.pool.Get()
+	defer conn.Close()
+	for _, key := range keys {
+		conn.Send("ZINCRBY", q.key, 1, key)
+	}
+	_, err := conn.Do("ZREMRANGEBYRANK", q.key, 0, -(q.maxKeys + 1))
+	return err
+}
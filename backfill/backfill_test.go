@@ -0,0 +1,57 @@
+package backfill
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	address := strings.Split(addresses, ",")[0]
+	q := New(address, "roshi:test:backfill", time.Second, 3)
+	conn := q.pool.Get()
+	_, err := conn.Do("DEL", q.key)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys found divergent more often come first; the least divergent key
+	// beyond the max is dropped.
+	q.Backfill([]string{"a", "b"})
+	q.Backfill([]string{"b", "c"})
+	q.Backfill([]string{"b", "c", "d"})
+	q.Close()
+
+	q = New(address, "roshi:test:backfill", time.Second, 3)
+	defer q.Close()
+	keys, err := q.Pop(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []string{"b", "c"}, keys; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	keys, err = q.Pop(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, len(keys); expected != got {
+		t.Errorf("expected %d key, got %v", expected, keys)
+	}
+	keys, err = q.Pop(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 0, len(keys); expected != got {
+		t.Errorf("expected no keys, got %v", keys)
+	}
+}
@@ -0,0 +1,207 @@
+// Package backup implements the backup, restore, and sync commands of roshi.
+// They copy the raw state of a farm, the inserts and deletes sets of every
+// key, rather than the merged view returned by a select, so that deletes
+// survive the copy: to a file, from a file, and to another farm.
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// entry is a line of a backup: the inserts or the deletes of a key. It has
+// the operation and tuples fields of the writes in a roshi-simulate log, so a
+// backup can be replayed by roshi-simulate. Like in the roshi-server API,
+// keys and members are base64-encoded.
+type entry struct {
+	Operation string                  `json:"operation"` // insert or delete
+	Tuples    []common.KeyScoreMember `json:"tuples"`
+}
+
+// inspector reads the raw state of a key from every cluster of a farm.
+type inspector interface {
+	Inspect(key string, limit int) ([]cluster.KeyState, error)
+}
+
+// writer applies the entries of a backup.
+type writer interface {
+	cluster.Inserter
+	cluster.Deleter
+}
+
+// copyKeys reads the raw state of every key of the clusters, up to limit
+// members of each set, and passes its inserts and deletes, merged across
+// clusters, to emit. Keys are scanned from each cluster in turn, batchSize
+// at a time, and wait is called before each batch. A key which is in an
+// earlier cluster was copied when that cluster was scanned, and is skipped.
+// Like the walker, copyKeys only finds keys with inserts. A key which can't
+// be read from every cluster is logged and counted as failed; an error of
+// emit stops the copy.
+func copyKeys(
+	clusters []cluster.Cluster,
+	insp inspector,
+	batchSize, limit int,
+	wait func(n int64),
+	emit func(entry) error,
+) (copied, failed int, err error) {
+	for index, c := range clusters {
+		log.Printf("copying the keys of cluster %d (%d/%d)", index, index+1, len(clusters))
+		for batch := range c.Keys(batchSize) {
+			wait(int64(len(batch)))
+			for _, key := range batch {
+				states, err := insp.Inspect(key, limit)
+				if err != nil {
+					return copied, failed, err
+				}
+				if copiedBefore(states, index) {
+					continue
+				}
+				inserts, deletes, err := merge(key, states, limit)
+				if err != nil {
+					log.Printf("key %q: %s", key, err)
+					failed++
+					continue
+				}
+				for _, e := range []entry{{"insert", inserts}, {"delete", deletes}} {
+					if len(e.Tuples) == 0 {
+						continue
+					}
+					if err := emit(e); err != nil {
+						return copied, failed, err
+					}
+				}
+				copied++
+			}
+		}
+	}
+	return copied, failed, nil
+}
+
+// copiedBefore returns true if the key of the states has inserts in a
+// cluster before index, and so was copied when that cluster was scanned.
+func copiedBefore(states []cluster.KeyState, index int) bool {
+	for _, state := range states[:index] {
+		if state.InsertCount > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// merge returns the union of the inserts, and of the deletes, of the states
+// of a key, with the highest score of each member, highest first. It fails
+// if any state couldn't be read. Sets larger than the limit are logged, as
+// only the members with the highest scores are copied.
+func merge(key string, states []cluster.KeyState, limit int) (inserts, deletes []common.KeyScoreMember, err error) {
+	var (
+		insertScores = map[string]common.KeyScoreMember{}
+		deleteScores = map[string]common.KeyScoreMember{}
+	)
+	for i, state := range states {
+		if state.Err != nil {
+			return nil, nil, fmt.Errorf("cluster %d: %s", i, state.Err)
+		}
+		if state.InsertCount > limit || state.DeleteCount > limit {
+			log.Printf("key %q: cluster %d: copying only %d of %d insert(s) and %d of %d delete(s)",
+				key, i, len(state.Inserts), state.InsertCount, len(state.Deletes), state.DeleteCount)
+		}
+		union(insertScores, state.Inserts)
+		union(deleteScores, state.Deletes)
+	}
+	return sorted(insertScores), sorted(deleteScores), nil
+}
+
+func union(scores map[string]common.KeyScoreMember, tuples []common.KeyScoreMember) {
+	for _, tuple := range tuples {
+		if prev, ok := scores[tuple.Member]; !ok || tuple.Score > prev.Score {
+			scores[tuple.Member] = tuple
+		}
+	}
+}
+
+func sorted(scores map[string]common.KeyScoreMember) []common.KeyScoreMember {
+	tuples := make([]common.KeyScoreMember, 0, len(scores))
+	for _, tuple := range scores {
+		tuples = append(tuples, tuple)
+	}
+	sort.Slice(tuples, func(i, j int) bool { return tuples[i].Before(tuples[j]) })
+	return tuples
+}
+
+// restore applies the entries read from r to w, and returns the number of
+// tuples applied. Lines of a single tuple, as written by the archivers of
+// roshi-server -archive.file, are inserts; they're batched, up to batchSize
+// at a time. Inserts and deletes are otherwise applied an entry at a time,
+// in order, and wait is called before each write.
+func restore(r io.Reader, w writer, batchSize int, wait func(n int64)) (int, error) {
+	var (
+		dec     = json.NewDecoder(r)
+		applied int
+		pending []common.KeyScoreMember
+	)
+	write := func(e entry) error {
+		if len(e.Tuples) == 0 {
+			return nil
+		}
+		wait(int64(len(e.Tuples)))
+		var err error
+		switch e.Operation {
+		case "insert":
+			err = w.Insert(e.Tuples)
+		case "delete":
+			err = w.Delete(e.Tuples)
+		}
+		if err != nil {
+			return fmt.Errorf("%s of %d tuple(s): %s", e.Operation, len(e.Tuples), err)
+		}
+		applied += len(e.Tuples)
+		return nil
+	}
+	flush := func() error {
+		err := write(entry{"insert", pending})
+		pending = nil
+		return err
+	}
+
+	for line := 1; dec.More(); line++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return applied, fmt.Errorf("line %d: %s", line, err)
+		}
+		var e entry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return applied, fmt.Errorf("line %d: %s", line, err)
+		}
+		if e.Operation == "" {
+			var tuple common.KeyScoreMember
+			if err := json.Unmarshal(raw, &tuple); err != nil {
+				return applied, fmt.Errorf("line %d: %s", line, err)
+			}
+			if pending = append(pending, tuple); len(pending) >= batchSize {
+				if err := flush(); err != nil {
+					return applied, fmt.Errorf("line %d: %s", line, err)
+				}
+			}
+			continue
+		}
+		if err := flush(); err != nil {
+			return applied, fmt.Errorf("line %d: %s", line, err)
+		}
+		switch e.Operation = strings.ToLower(e.Operation); e.Operation {
+		case "insert", "delete":
+		default:
+			return applied, fmt.Errorf("line %d: unknown operation %q", line, e.Operation)
+		}
+		if err := write(e); err != nil {
+			return applied, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	return applied, flush()
+}
@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+// fixedInspector reports fixed states of each key.
+type fixedInspector map[string][]cluster.KeyState
+
+func (i fixedInspector) Inspect(key string, limit int) ([]cluster.KeyState, error) {
+	return i[key], nil
+}
+
+func TestCopyKeys(t *testing.T) {
+	var (
+		first, second = clustertest.New(), clustertest.New()
+		clusters      = []cluster.Cluster{first, second}
+		insp          = fixedInspector{
+			"a": {
+				{InsertCount: 1, Inserts: []common.KeyScoreMember{{Key: "a", Score: 1, Member: "x"}}, DeleteCount: 1, Deletes: []common.KeyScoreMember{{Key: "a", Score: 2, Member: "y"}}},
+				{InsertCount: 2, Inserts: []common.KeyScoreMember{{Key: "a", Score: 3, Member: "x"}, {Key: "a", Score: 1, Member: "y"}}},
+			},
+			"b": {
+				{},
+				{InsertCount: 1, Inserts: []common.KeyScoreMember{{Key: "b", Score: 1, Member: "z"}}},
+			},
+			"c": {
+				{},
+				{Err: errors.New("unavailable")},
+			},
+		}
+	)
+	// Keys are scanned from the insert sets of the clusters.
+	first.Insert([]common.KeyScoreMember{{Key: "a", Score: 1, Member: "x"}})
+	second.Insert([]common.KeyScoreMember{{Key: "a", Score: 3, Member: "x"}, {Key: "b", Score: 1, Member: "z"}, {Key: "c", Score: 1, Member: "z"}})
+
+	var entries []entry
+	copied, failed, err := copyKeys(clusters, insp, 10, 100, func(int64) {}, func(e entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 || failed != 1 {
+		t.Errorf("expected 2 key(s) copied and 1 failed, got %d and %d", copied, failed)
+	}
+
+	// Each key is copied once, merged across clusters, with its deletes.
+	byKey := map[string][]entry{}
+	for _, e := range entries {
+		byKey[e.Tuples[0].Key] = append(byKey[e.Tuples[0].Key], e)
+	}
+	expected := map[string][]entry{
+		"a": {
+			{"insert", []common.KeyScoreMember{{Key: "a", Score: 3, Member: "x"}, {Key: "a", Score: 1, Member: "y"}}},
+			{"delete", []common.KeyScoreMember{{Key: "a", Score: 2, Member: "y"}}},
+		},
+		"b": {
+			{"insert", []common.KeyScoreMember{{Key: "b", Score: 1, Member: "z"}}},
+		},
+	}
+	if !reflect.DeepEqual(expected, byKey) {
+		t.Errorf("expected %v, got %v", expected, byKey)
+	}
+
+	// An error of emit stops the copy.
+	if _, _, err := copyKeys(clusters, insp, 10, 100, func(int64) {}, func(entry) error {
+		return errors.New("disk full")
+	}); err == nil {
+		t.Errorf("expected an error of emit to stop the copy")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, v := range []interface{}{
+		entry{"insert", []common.KeyScoreMember{{Key: "a", Score: 3, Member: "x"}, {Key: "a", Score: 1, Member: "y"}}},
+		entry{"delete", []common.KeyScoreMember{{Key: "a", Score: 2, Member: "y"}}},
+		// Archived inserts are a tuple per line.
+		common.KeyScoreMember{Key: "b", Score: 1, Member: "z"},
+		common.KeyScoreMember{Key: "b", Score: 2, Member: "w"},
+		common.KeyScoreMember{Key: "b", Score: 3, Member: "v"},
+	} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := clustertest.New()
+	applied, err := restore(&buf, c, 2, func(int64) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 6, applied; expected != got {
+		t.Errorf("expected %d tuple(s) applied, got %d", expected, got)
+	}
+	if expected, got := 3, c.CallCount(clustertest.Insert); expected != got {
+		t.Errorf("expected %d insert(s), batching archived tuples, got %d", expected, got)
+	}
+
+	got := map[string][]common.KeyScoreMember{}
+	for e := range c.SelectOffset([]string{"a", "b"}, 0, 10) {
+		got[e.Key] = e.KeyScoreMembers
+	}
+	if expected := map[string][]common.KeyScoreMember{
+		"a": {{Key: "a", Score: 3, Member: "x"}},
+		"b": {{Key: "b", Score: 3, Member: "v"}, {Key: "b", Score: 2, Member: "w"}, {Key: "b", Score: 1, Member: "z"}},
+	}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Unknown operations are errors.
+	if _, err := restore(strings.NewReader(`{"operation":"select"}`), c, 2, func(int64) {}); err == nil {
+		t.Errorf("expected an error for an unknown operation")
+	}
+}
@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/tsenart/tb"
+
+	"github.com/soundcloud/roshi/cli"
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/farm"
+)
+
+// RunBackup runs roshi backup, as the named command, with the command-line
+// arguments, which don't include the name. It writes the raw state of every
+// key of the farm to a file, as newline-delimited JSON.
+func RunBackup(name string, args []string) {
+	var (
+		fs         = cli.NewFlagSet(name)
+		redisFlags = cli.RedisFlags(fs, 2)
+		copyFlags  = newCopyFlags(fs)
+		file       = fs.String("file", "-", "File to write the backup to (- for stdout)")
+	)
+	if err := cli.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	log.SetFlags(log.Lmicroseconds)
+	copyFlags.validate()
+
+	var w io.Writer = os.Stdout
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+
+	src := copyFlags.farm(redisFlags)
+	copied, failed, err := copyKeys(src.clusters, src.farm, *copyFlags.batchSize, *copyFlags.maxSize, copyFlags.waiter(), func(e entry) error {
+		return enc.Encode(e)
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		log.Fatalf("backup failed after %d key(s): %s", copied, err)
+	}
+	log.Printf("backed up %d key(s)", copied)
+	if failed > 0 {
+		log.Fatalf("%d key(s) couldn't be read, and weren't backed up", failed)
+	}
+}
+
+// RunRestore runs roshi restore, as the named command, with the command-line
+// arguments, which don't include the name. It writes a backup, or the output
+// of roshi serve -archive.file, to the farm.
+func RunRestore(name string, args []string) {
+	var (
+		fs         = cli.NewFlagSet(name)
+		redisFlags = cli.RedisFlags(fs, 2)
+		copyFlags  = newCopyFlags(fs)
+		file       = fs.String("file", "-", "File to read the backup from (- for stdin)")
+	)
+	if err := cli.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Lmicroseconds)
+	copyFlags.validate()
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dst := copyFlags.farm(redisFlags)
+	applied, err := restore(bufio.NewReader(r), dst.farm, *copyFlags.batchSize, copyFlags.waiter())
+	if err != nil {
+		log.Fatalf("restore failed after %d tuple(s): %s", applied, err)
+	}
+	log.Printf("restored %d tuple(s)", applied)
+}
+
+// RunSync runs roshi sync, as the named command, with the command-line
+// arguments, which don't include the name. It writes the raw state of every
+// key of a source farm to the farm, e.g. to migrate it to new instances.
+// Since writes are merged, syncing repeatedly, or while the farm is written
+// to, is safe.
+func RunSync(name string, args []string) {
+	var (
+		fs              = cli.NewFlagSet(name)
+		redisFlags      = cli.RedisFlags(fs, 2)
+		copyFlags       = newCopyFlags(fs)
+		sourceInstances = fs.String("source.redis.instances", "", "Semicolon-separated list of comma-separated lists of Redis instances of the farm to sync from; the other -redis.* flags apply to both farms")
+	)
+	if err := cli.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Lmicroseconds)
+	copyFlags.validate()
+	if *sourceInstances == "" {
+		log.Fatal("-source.redis.instances is required")
+	}
+
+	sourceFlags := *redisFlags
+	sourceFlags.Instances = *sourceInstances
+	var (
+		src = copyFlags.farm(&sourceFlags)
+		dst = copyFlags.farm(redisFlags)
+	)
+	copied, failed, err := copyKeys(src.clusters, src.farm, *copyFlags.batchSize, *copyFlags.maxSize, copyFlags.waiter(), func(e entry) error {
+		if e.Operation == "delete" {
+			return dst.farm.Delete(e.Tuples)
+		}
+		return dst.farm.Insert(e.Tuples)
+	})
+	if err != nil {
+		log.Fatalf("sync failed after %d key(s): %s", copied, err)
+	}
+	log.Printf("synced %d key(s)", copied)
+	if failed > 0 {
+		log.Fatalf("%d key(s) couldn't be read, and weren't synced", failed)
+	}
+}
+
+// copyFlags are the flags the commands have in common.
+type copyFlags struct {
+	maxSize          *int
+	batchSize        *int
+	maxKeysPerSecond *int64
+}
+
+func newCopyFlags(fs *flag.FlagSet) copyFlags {
+	return copyFlags{
+		maxSize:          fs.Int("max.size", 10000, "Maximum number of events per key; should match roshi-server"),
+		batchSize:        fs.Int("batch.size", 100, "Keys to read, or tuples to write, at a time"),
+		maxKeysPerSecond: fs.Int64("max.keys.per.second", 1000, "Max keys read, or tuples written, per second (0 for no limit)"),
+	}
+}
+
+func (f copyFlags) validate() {
+	if *f.maxSize <= 0 || *f.batchSize <= 0 {
+		log.Fatal("max size and batch size should be positive")
+	}
+	if *f.maxKeysPerSecond < 0 {
+		log.Fatal("max keys per second should not be negative")
+	}
+}
+
+// waiter returns the rate limit of the flags.
+func (f copyFlags) waiter() func(n int64) {
+	if *f.maxKeysPerSecond == 0 {
+		return func(int64) {}
+	}
+	bucket := tb.NewBucket(*f.maxKeysPerSecond, 0)
+	return func(n int64) { bucket.Wait(n) }
+}
+
+// farmClusters is a farm, with its clusters, which the commands scan.
+type farmClusters struct {
+	farm     *farm.Farm
+	clusters []cluster.Cluster
+}
+
+// farm builds the farm of the Redis flags. It writes to every cluster, and
+// doesn't repair, as the commands read the raw state of each cluster.
+func (f copyFlags) farm(redisFlags *cli.Redis) farmClusters {
+	clusters, err := redisFlags.Clusters(*f.maxSize, 0, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return farmClusters{
+		farm:     farm.New(clusters, len(clusters), farm.SendAllReadAll, farm.NoRepairs, nil),
+		clusters: clusters,
+	}
+}
@@ -0,0 +1,314 @@
+// Package bench implements roshi-bench, which generates synthetic workloads
+// against a farm or a roshi-server and reports throughput and latency
+// percentiles. It runs as the bench command of roshi.
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cli"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+// Run runs roshi-bench, as the named command, with the command-line
+// arguments, which don't include the name.
+func Run(name string, args []string) {
+	var (
+		fs              = cli.NewFlagSet(name)
+		redisFlags      = cli.RedisFlags(fs, 10) // bench a farm directly
+		maxSize         = fs.Int("max.size", 10000, "Maximum number of events per key")
+		httpTargetURL   = fs.String("http.target", "", "roshi-server base URL, e.g. http://localhost:6302 (bench the HTTP API)")
+		duration        = fs.Duration("duration", 10*time.Second, "How long to run the workload")
+		concurrency     = fs.Int("concurrency", 8, "Number of concurrent workers")
+		keys            = fs.Int("keys", 10000, "Size of the keyspace")
+		keyDistribution = fs.String("key.distribution", "uniform", "Key distribution: uniform, zipf")
+		zipfS           = fs.Float64("zipf.s", 1.1, "Zipf s parameter, must be > 1 (zipf distribution only)")
+		readRatio       = fs.Float64("read.ratio", 0.9, "Fraction of operations that are Selects, between 0 and 1")
+		writeBatchSize  = fs.Int("write.batch.size", 10, "Tuples per Insert")
+		readBatchSize   = fs.Int("read.batch.size", 10, "Keys per Select")
+		readLimit       = fs.Int("read.limit", 10, "Limit per Select")
+		memberSize      = fs.Int("member.size", 32, "Member size in bytes")
+	)
+	if err := cli.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Lmicroseconds)
+
+	if *readRatio < 0 || *readRatio > 1 {
+		log.Fatalf("read ratio %f out of range", *readRatio)
+	}
+	if *keys <= 0 || *concurrency <= 0 || *memberSize <= 0 {
+		log.Fatal("keys, concurrency, and member size must be positive")
+	}
+
+	var t target
+	switch {
+	case redisFlags.Instances != "" && *httpTargetURL != "":
+		log.Fatal("specify either -redis.instances or -http.target, not both")
+	case redisFlags.Instances != "":
+		clusters, err := redisFlags.Clusters(*maxSize, 0, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		t = farm.New(clusters, len(clusters)/2+1, farm.SendAllReadAll, farm.NoRepairs, nil)
+		log.Printf("benching farm of %d cluster(s)", len(clusters))
+	case *httpTargetURL != "":
+		t = httpTarget{strings.TrimRight(*httpTargetURL, "/"), &http.Client{}}
+		log.Printf("benching %s", *httpTargetURL)
+	default:
+		log.Fatal("specify -redis.instances or -http.target")
+	}
+
+	var pick func(*rand.Rand) func() int
+	switch strings.ToLower(*keyDistribution) {
+	case "uniform":
+		pick = func(r *rand.Rand) func() int { return func() int { return r.Intn(*keys) } }
+	case "zipf":
+		if *zipfS <= 1 {
+			log.Fatalf("zipf s parameter must be > 1")
+		}
+		pick = func(r *rand.Rand) func() int {
+			z := rand.NewZipf(r, *zipfS, 1, uint64(*keys-1))
+			return func() int { return int(z.Uint64()) }
+		}
+	default:
+		log.Fatalf("unknown key distribution %q", *keyDistribution)
+	}
+
+	var (
+		deadline = time.Now().Add(*duration)
+		results  = make(chan workerResult, *concurrency)
+		wg       = sync.WaitGroup{}
+	)
+	wg.Add(*concurrency)
+	for i := 0; i < *concurrency; i++ {
+		go func(seed int64) {
+			defer wg.Done()
+			w := worker{
+				t:              t,
+				r:              rand.New(rand.NewSource(seed)),
+				readRatio:      *readRatio,
+				writeBatchSize: *writeBatchSize,
+				readBatchSize:  *readBatchSize,
+				readLimit:      *readLimit,
+				memberSize:     *memberSize,
+			}
+			w.pick = pick(w.r)
+			results <- w.run(deadline)
+		}(time.Now().UnixNano() + int64(i))
+	}
+	wg.Wait()
+	close(results)
+
+	var total workerResult
+	for result := range results {
+		total.merge(result)
+	}
+	total.report(os.Stdout, *duration)
+}
+
+// target is the subset of the farm API exercised by the benchmark. It's
+// satisfied by farm.Farm directly, and by httpTarget over the HTTP API.
+type target interface {
+	Insert([]common.KeyScoreMember) error
+	SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error)
+}
+
+type httpTarget struct {
+	url    string
+	client *http.Client
+}
+
+func (t httpTarget) Insert(tuples []common.KeyScoreMember) error {
+	body, err := json.Marshal(tuples)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.url+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	return t.do(req, nil)
+}
+
+func (t httpTarget) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	byteKeys := make([][]byte, len(keys))
+	for i := range keys {
+		byteKeys[i] = []byte(keys[i])
+	}
+	body, err := json.Marshal(byteKeys)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/?offset=%d&limit=%d", t.url, offset, limit), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := t.do(req, &response); err != nil {
+		return nil, err
+	}
+	return response.Records, nil
+}
+
+func (t httpTarget) do(req *http.Request, v interface{}) error {
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(buf)))
+	}
+	if v == nil {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type worker struct {
+	t              target
+	r              *rand.Rand
+	pick           func() int
+	readRatio      float64
+	writeBatchSize int
+	readBatchSize  int
+	readLimit      int
+	memberSize     int
+}
+
+func (w worker) run(deadline time.Time) workerResult {
+	var result workerResult
+	for time.Now().Before(deadline) {
+		if w.r.Float64() < w.readRatio {
+			keys := make([]string, w.readBatchSize)
+			for i := range keys {
+				keys[i] = w.key()
+			}
+			began := time.Now()
+			_, err := w.t.SelectOffset(keys, 0, w.readLimit)
+			result.reads.observe(time.Since(began), len(keys), err)
+			continue
+		}
+
+		tuples := make([]common.KeyScoreMember, w.writeBatchSize)
+		for i := range tuples {
+			tuples[i] = common.KeyScoreMember{
+				Key:    w.key(),
+				Score:  float64(time.Now().UnixNano()),
+				Member: w.member(),
+			}
+		}
+		began := time.Now()
+		err := w.t.Insert(tuples)
+		result.writes.observe(time.Since(began), len(tuples), err)
+	}
+	return result
+}
+
+func (w worker) key() string {
+	return fmt.Sprintf("bench:%d", w.pick())
+}
+
+func (w worker) member() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, w.memberSize)
+	for i := range b {
+		b[i] = alphabet[w.r.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+type workerResult struct {
+	reads  opStats
+	writes opStats
+}
+
+func (r *workerResult) merge(other workerResult) {
+	r.reads.merge(other.reads)
+	r.writes.merge(other.writes)
+}
+
+func (r workerResult) report(w io.Writer, d time.Duration) {
+	r.reads.report(w, "select", "keys", d)
+	r.writes.report(w, "insert", "tuples", d)
+}
+
+type opStats struct {
+	durations []time.Duration
+	records   int
+	errors    int
+}
+
+func (s *opStats) observe(d time.Duration, records int, err error) {
+	if err != nil {
+		s.errors++
+		return
+	}
+	s.durations = append(s.durations, d)
+	s.records += records
+}
+
+func (s *opStats) merge(other opStats) {
+	s.durations = append(s.durations, other.durations...)
+	s.records += other.records
+	s.errors += other.errors
+}
+
+func (s opStats) report(w io.Writer, name, unit string, d time.Duration) {
+	if len(s.durations) <= 0 && s.errors <= 0 {
+		fmt.Fprintf(w, "%s: no operations\n", name)
+		return
+	}
+	sort.Sort(durations(s.durations))
+	seconds := d.Seconds()
+	fmt.Fprintf(w, "%s: %d ok, %d error(s), %.1f ops/sec, %.1f %s/sec\n",
+		name, len(s.durations), s.errors, float64(len(s.durations))/seconds, float64(s.records)/seconds, unit)
+	if len(s.durations) <= 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s: p50 %s, p90 %s, p99 %s, p999 %s, max %s\n",
+		name,
+		percentile(s.durations, 0.50),
+		percentile(s.durations, 0.90),
+		percentile(s.durations, 0.99),
+		percentile(s.durations, 0.999),
+		s.durations[len(s.durations)-1],
+	)
+}
+
+// percentile expects a sorted, nonempty slice.
+func percentile(a []time.Duration, p float64) time.Duration {
+	i := int(float64(len(a))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(a) {
+		i = len(a) - 1
+	}
+	return a[i]
+}
+
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
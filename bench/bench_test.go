@@ -0,0 +1,114 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+func TestWorker(t *testing.T) {
+	var (
+		fake = clustertest.New()
+		w    = worker{
+			t:              farm.New([]cluster.Cluster{fake}, 1, farm.SendAllReadAll, farm.NoRepairs, nil),
+			r:              rand.New(rand.NewSource(1)),
+			pick:           func() int { return 7 },
+			readRatio:      0.5,
+			writeBatchSize: 3,
+			readBatchSize:  2,
+			readLimit:      10,
+			memberSize:     4,
+		}
+		result = w.run(time.Now().Add(20 * time.Millisecond))
+	)
+	if result.reads.errors != 0 || result.writes.errors != 0 {
+		t.Fatalf("expected no errors, got %d and %d", result.reads.errors, result.writes.errors)
+	}
+	if len(result.reads.durations) <= 0 || len(result.writes.durations) <= 0 {
+		t.Fatalf("expected reads and writes, got %d and %d", len(result.reads.durations), len(result.writes.durations))
+	}
+	if expected, got := 2*len(result.reads.durations), result.reads.records; expected != got {
+		t.Errorf("expected %d keys read, got %d", expected, got)
+	}
+	if expected, got := 3*len(result.writes.durations), result.writes.records; expected != got {
+		t.Errorf("expected %d tuples written, got %d", expected, got)
+	}
+
+	// Every write went to the one picked key, with members of the size.
+	e := <-fake.SelectOffset([]string{"bench:7"}, 0, 1)
+	if len(e.KeyScoreMembers) != 1 || len(e.KeyScoreMembers[0].Member) != 4 {
+		t.Errorf("expected a member of 4 bytes in bench:7, got %v", e.KeyScoreMembers)
+	}
+}
+
+func TestHTTPTarget(t *testing.T) {
+	var inserted []common.KeyScoreMember
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			json.NewDecoder(r.Body).Decode(&inserted)
+		case "GET":
+			if r.URL.Query().Get("limit") != "5" {
+				http.Error(w, "bad limit", http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"records": map[string][]common.KeyScoreMember{"foo": inserted},
+			})
+		}
+	}))
+	defer server.Close()
+
+	target := httpTarget{server.URL, &http.Client{}}
+	tuples := []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}}
+	if err := target.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	records, err := target.SelectOffset([]string{"foo"}, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{"foo": tuples}, records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if _, err := target.SelectOffset([]string{"foo"}, 0, 6); err == nil || !strings.Contains(err.Error(), "HTTP 400") {
+		t.Errorf("expected an HTTP 400 error, got %v", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	var s opStats
+	for i := 1; i <= 100; i++ {
+		s.observe(time.Duration(i)*time.Millisecond, 2, nil)
+	}
+	s.observe(time.Second, 2, errors.New("failtown"))
+	if expected, got := 50*time.Millisecond, percentile(s.durations, 0.5); expected != got {
+		t.Errorf("expected p50 %s, got %s", expected, got)
+	}
+
+	var buf bytes.Buffer
+	s.report(&buf, "insert", "tuples", 10*time.Second)
+	expected := "insert: 100 ok, 1 error(s), 10.0 ops/sec, 20.0 tuples/sec\n" +
+		"insert: p50 50ms, p90 90ms, p99 99ms, p999 100ms, max 100ms\n"
+	if got := buf.String(); expected != got {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	buf.Reset()
+	opStats{}.report(&buf, "select", "keys", time.Second)
+	if expected, got := "select: no operations\n", buf.String(); expected != got {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
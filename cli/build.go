@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build metadata, set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/soundcloud/roshi/cli.Commit=$(git rev-parse --short HEAD)"
+//
+// The Makefiles of the commands set Commit and BuildDate.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown" // RFC 3339
+)
+
+// Build describes the binary of a command.
+type Build struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// CurrentBuild returns the Build of the running binary.
+func CurrentBuild() Build {
+	return Build{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+func (b Build) String() string {
+	return fmt.Sprintf("version %s, commit %s, built %s with %s", b.Version, b.Commit, b.BuildDate, b.GoVersion)
+}
@@ -0,0 +1,73 @@
+// Package cli provides what the commands of the roshi binary share: flag
+// parsing with config files, and the flags and setup of Redis clusters and
+// instrumentation.
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// NewFlagSet returns an empty flag set for the named command, with a -config
+// flag, which exits on errors. Commands define their flags on it, and pass
+// it to Parse.
+func NewFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.String("config", "", "Read flags not given on the command line from this file, one per line as name=value (blank to disable)")
+	return fs
+}
+
+// Parse parses the command-line arguments, which don't include the command
+// name. Flags which aren't given on the command line are then set from the
+// -config file, if any, so that the command line overrides the file.
+func Parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	config := fs.Lookup("config")
+	if config == nil || config.Value.String() == "" {
+		return nil
+	}
+	f, err := os.Open(config.Value.String())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return LoadConfig(fs, f)
+}
+
+// LoadConfig sets the flags of the flag set which haven't been set yet from
+// the config. Each line of a config sets a flag, as name=value or name value,
+// with or without leading dashes; blank lines and lines starting with # are
+// ignored. Unknown flags are errors.
+func LoadConfig(fs *flag.FlagSet, r io.Reader) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value := text, "true" // a bare name sets a boolean flag
+		if i := strings.IndexAny(text, "= \t"); i >= 0 {
+			name, value = text[:i], strings.TrimLeft(text[i:], "= \t")
+		}
+		name = strings.TrimLeft(name, "-")
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config line %d: unknown flag %q", line, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config line %d: %s: %s", line, name, err)
+		}
+	}
+	return s.Err()
+}
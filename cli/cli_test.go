@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roshi-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "roshi.conf")
+	if err := ioutil.WriteFile(config, []byte(`
+# Redis
+redis.instances = a:6379, b:6379; c:6379
+-redis.mcpi=20
+redis.hash fnva
+max.size 500
+verbose
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewFlagSet("test")
+	var (
+		redis   = RedisFlags(fs, 10)
+		maxSize = fs.Int("max.size", 10000, "")
+		verbose = fs.Bool("verbose", false, "")
+	)
+	if err := Parse(fs, []string{"-config", config, "-max.size=100", "-redis.read.timeout=1s"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "a:6379, b:6379; c:6379", redis.Instances; expected != got {
+		t.Errorf("instances: expected %q, got %q", expected, got)
+	}
+	if expected, got := 20, redis.MCPI; expected != got {
+		t.Errorf("mcpi: expected %d, got %d", expected, got)
+	}
+	if expected, got := time.Second, redis.ReadTimeout; expected != got {
+		t.Errorf("read timeout: expected %s, got %s", expected, got)
+	}
+	if expected, got := 3*time.Second, redis.WriteTimeout; expected != got {
+		t.Errorf("write timeout: expected default %s, got %s", expected, got)
+	}
+	if expected, got := 100, *maxSize; expected != got {
+		t.Errorf("max size: expected the command line's %d, got %d", expected, got)
+	}
+	if !*verbose {
+		t.Errorf("verbose: expected true, got false")
+	}
+	if hash, err := redis.HashFunc(); err != nil || hash == nil {
+		t.Errorf("hash: expected fnva, got %v", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		"redis.instances a:6379\nbogus 1\n",
+		"redis.mcpi many\n",
+	} {
+		fs := NewFlagSet("test")
+		RedisFlags(fs, 10)
+		if err := Parse(fs, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadConfig(fs, strings.NewReader(config)); err == nil {
+			t.Errorf("%q: expected error, got none", config)
+		}
+	}
+
+	redis := &Redis{Hash: "md5"}
+	if _, err := redis.HashFunc(); err == nil {
+		t.Errorf("md5: expected error, got none")
+	}
+}
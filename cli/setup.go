@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/farm"
+	"github.com/soundcloud/roshi/instrumentation"
+	"github.com/soundcloud/roshi/instrumentation/logging"
+	"github.com/soundcloud/roshi/instrumentation/multi"
+	"github.com/soundcloud/roshi/instrumentation/plaintext"
+	"github.com/soundcloud/roshi/instrumentation/prometheus"
+	"github.com/soundcloud/roshi/instrumentation/statsd"
+	"github.com/soundcloud/roshi/pool"
+
+	"github.com/peterbourgon/g2s"
+)
+
+// Redis holds the flags which describe the Redis instances of a farm, and
+// how to connect to them.
+type Redis struct {
+	Instances      string
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	MCPI           int
+	Hash           string
+	Dialect        string
+	Multiplex      bool
+}
+
+// RedisFlags defines the -redis.* flags on the flag set, with the given
+// default max connections per instance.
+func RedisFlags(fs *flag.FlagSet, mcpi int) *Redis {
+	r := &Redis{}
+	fs.StringVar(&r.Instances, "redis.instances", "", "Semicolon-separated list of comma-separated lists of Redis instances (host:port or Unix socket path), each optionally suffixed with =weight")
+	fs.DurationVar(&r.ConnectTimeout, "redis.connect.timeout", 3*time.Second, "Redis connect timeout")
+	fs.DurationVar(&r.ReadTimeout, "redis.read.timeout", 3*time.Second, "Redis read timeout")
+	fs.DurationVar(&r.WriteTimeout, "redis.write.timeout", 3*time.Second, "Redis write timeout")
+	fs.IntVar(&r.MCPI, "redis.mcpi", mcpi, "Max connections per Redis instance")
+	fs.StringVar(&r.Hash, "redis.hash", "murmur3", "Redis hash function: murmur3, fnv, fnva")
+	fs.StringVar(&r.Dialect, "redis.dialect", "redis", "Server of the Redis instances, to work around its differences from Redis: redis, keydb, dragonfly")
+	fs.BoolVar(&r.Multiplex, "redis.multiplex", false, "Share a single connection per Redis instance between all requests, instead of a pool of -redis.mcpi connections")
+	return r
+}
+
+// HashFunc returns the hash function named by -redis.hash.
+func (r *Redis) HashFunc() (func(string) uint32, error) {
+	switch strings.ToLower(r.Hash) {
+	case "murmur3":
+		return pool.Murmur3, nil
+	case "fnv":
+		return pool.FNV, nil
+	case "fnva":
+		return pool.FNVa, nil
+	default:
+		return nil, fmt.Errorf("unknown hash %q", r.Hash)
+	}
+}
+
+// RedisDialect returns the dialect named by -redis.dialect.
+func (r *Redis) RedisDialect() (cluster.Dialect, error) {
+	return cluster.ParseDialect(r.Dialect)
+}
+
+// Clusters connects to the clusters of -redis.instances, like
+// farm.ParseFarmString. Options are passed to every cluster, after the
+// compatibility option of -redis.dialect, and the Multiplexed option, if
+// -redis.multiplex is set.
+func (r *Redis) Clusters(
+	maxSize int,
+	selectGap time.Duration,
+	instr instrumentation.Instrumentation,
+	options ...cluster.Option,
+) ([]cluster.Cluster, error) {
+	hash, err := r.HashFunc()
+	if err != nil {
+		return nil, err
+	}
+	dialect, err := r.RedisDialect()
+	if err != nil {
+		return nil, err
+	}
+	builtin := []cluster.Option{cluster.Compatibility(dialect)}
+	if r.Multiplex {
+		builtin = append(builtin, cluster.Multiplexed())
+	}
+	options = append(builtin, options...)
+	return farm.ParseFarmString(
+		r.Instances,
+		r.ConnectTimeout, r.ReadTimeout, r.WriteTimeout,
+		r.MCPI,
+		hash,
+		maxSize,
+		selectGap,
+		instr,
+		options...,
+	)
+}
+
+// Instrumentation holds the flags which select and configure the
+// instrumentation backends.
+type Instrumentation struct {
+	Backends                string
+	StatsdAddress           string
+	StatsdSampleRate        float64
+	StatsdBucketPrefix      string
+	PrometheusNamespace     string
+	PrometheusMaxSummaryAge time.Duration
+	PrometheusRuntime       bool
+	LoggingInterval         time.Duration
+}
+
+// InstrumentationFlags defines the -instrumentation, -statsd.*,
+// -prometheus.*, and -logging.* flags on the flag set, with the given default Prometheus
+// namespace.
+func InstrumentationFlags(fs *flag.FlagSet, namespace string) *Instrumentation {
+	i := &Instrumentation{}
+	fs.StringVar(&i.Backends, "instrumentation", "statsd,prometheus", "Comma-separated list of instrumentation backends: statsd, prometheus, plaintext, logging")
+	fs.StringVar(&i.StatsdAddress, "statsd.address", "", "Statsd address (blank to disable)")
+	fs.Float64Var(&i.StatsdSampleRate, "statsd.sample.rate", 0.1, "Statsd sample rate for normal metrics")
+	fs.StringVar(&i.StatsdBucketPrefix, "statsd.bucket.prefix", "myservice.", "Statsd bucket key prefix, including trailing period")
+	fs.StringVar(&i.PrometheusNamespace, "prometheus.namespace", namespace, "Prometheus key namespace, excluding trailing punctuation")
+	fs.DurationVar(&i.PrometheusMaxSummaryAge, "prometheus.max.summary.age", 10*time.Second, "Prometheus max age for instantaneous histogram data")
+	fs.BoolVar(&i.PrometheusRuntime, "prometheus.runtime", false, "Also export Go runtime and process metrics (go_*, process_*)")
+	fs.DurationVar(&i.LoggingInterval, "logging.interval", time.Minute, "Interval of the metrics summaries logged by the logging backend")
+	return i
+}
+
+// Build sets up the instrumentation backends, several of which may be active
+// at once, and returns them combined, along with their InstrumentationV2
+// counterparts, e.g. for per-key-prefix metrics. Prometheus metrics are
+// served at /metrics on the mux.
+func (i *Instrumentation) Build(mux *http.ServeMux) (instrumentation.Instrumentation, instrumentation.InstrumentationV2, error) {
+	var (
+		instrs   = []instrumentation.Instrumentation{}
+		instrsV2 = []instrumentation.InstrumentationV2{}
+	)
+	for _, backend := range strings.Split(i.Backends, ",") {
+		switch strings.ToLower(strings.TrimSpace(backend)) {
+		case "statsd":
+			statter := g2s.Noop()
+			if i.StatsdAddress != "" {
+				var err error
+				statter, err = g2s.Dial("udp", i.StatsdAddress)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			instrs = append(instrs, statsd.New(statter, float32(i.StatsdSampleRate), i.StatsdBucketPrefix))
+			instrsV2 = append(instrsV2, statsd.NewV2(statter, float32(i.StatsdSampleRate), i.StatsdBucketPrefix))
+		case "prometheus":
+			prometheusInstr := prometheus.New(i.PrometheusNamespace, i.PrometheusMaxSummaryAge)
+			prometheusInstr.Install("/metrics", mux)
+			if i.PrometheusRuntime {
+				prometheus.RegisterRuntimeCollector()
+			}
+			instrs = append(instrs, prometheusInstr)
+			instrsV2 = append(instrsV2, prometheus.NewV2(i.PrometheusNamespace, i.PrometheusMaxSummaryAge))
+		case "plaintext":
+			instrs = append(instrs, plaintext.New(os.Stderr))
+			instrsV2 = append(instrsV2, plaintext.NewV2(os.Stderr))
+		case "logging":
+			if i.LoggingInterval <= 0 {
+				return nil, nil, fmt.Errorf("invalid logging interval %s", i.LoggingInterval)
+			}
+			l := logging.New(os.Stderr, i.LoggingInterval)
+			instrs = append(instrs, instrumentation.V1(l))
+			instrsV2 = append(instrsV2, l)
+		case "":
+			continue
+		default:
+			return nil, nil, fmt.Errorf("unknown instrumentation backend %q", backend)
+		}
+		log.Printf("using %s instrumentation", strings.TrimSpace(backend))
+	}
+	return multi.New(instrs...), multi.NewV2(instrsV2...), nil
+}
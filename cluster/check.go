@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// InstanceCheck is the outcome of checking a single Redis instance.
+type InstanceCheck struct {
+	Address string
+	Err     error // nil if the instance is healthy
+}
+
+// Check dials every Redis instance in the cluster, verifies it responds to
+// PING, and is of the dialect declared via Compatibility, and loads every Lua
+// script, verifying its digest. It returns one
+// InstanceCheck per instance, in order. Check only works on Clusters returned
+// by New.
+func Check(c Cluster) ([]InstanceCheck, error) {
+	concrete, ok := c.(*cluster)
+	if !ok {
+		return []InstanceCheck{}, fmt.Errorf("can't check a %T", c)
+	}
+
+	checks := make([]InstanceCheck, concrete.pool.Size())
+	for i := range checks {
+		checks[i] = InstanceCheck{
+			Address: concrete.pool.ID(i),
+			Err: concrete.pool.WithIndex(i, func(conn redis.Conn) error {
+				if _, err := conn.Do("PING"); err != nil {
+					return err
+				}
+				if err := checkDialect(conn, concrete.dialect); err != nil {
+					return err
+				}
+				for _, s := range scripts {
+					if err := s.Load(conn); err != nil {
+						return fmt.Errorf("loading %s script: %s", s.name, err)
+					}
+				}
+				return nil
+			}),
+		}
+	}
+	return checks, nil
+}
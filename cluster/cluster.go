@@ -3,6 +3,7 @@
 package cluster
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -42,6 +43,7 @@ type Inserter interface {
 // Selecter defines the methods to retrieve elements from a sorted set.
 type Selecter interface {
 	SelectOffset(keys []string, offset, limit int) <-chan Element
+	SelectOffsetAscending(keys []string, offset, limit int) <-chan Element
 	SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan Element
 }
 
@@ -71,6 +73,39 @@ type Scanner interface {
 	Keys(batchSize int) <-chan []string
 }
 
+// InstanceScanner is implemented by Clusters which can scan the keyspace of
+// each of their Redis instances independently. Each instance owns a range of
+// the hash slots of the cluster's pool, so instances are natural units for
+// partitioning a walk of the keyspace. Clusters returned by New implement
+// InstanceScanner. InstanceKeys stops scanning, and closes the returned
+// channel, once stop is closed.
+type InstanceScanner interface {
+	Instances() int
+	InstanceKeys(index, batchSize int, stop <-chan struct{}) <-chan []string
+}
+
+// PrefixDeleter is implemented by Clusters which can delete every member of a
+// key which starts with a prefix, e.g. to clean up a class of malformed
+// members. Clusters returned by New implement PrefixDeleter.
+type PrefixDeleter interface {
+	DeletePrefix(key, prefix string) ([]common.KeyScoreMember, error)
+}
+
+// Sampler is implemented by Clusters which can select every stride-th
+// element of keys, from the highest score to the lowest, starting at the
+// offset, e.g. for previews of large keys. Up to limit elements are
+// returned per key. Clusters returned by New implement Sampler.
+type Sampler interface {
+	SelectStride(keys []string, offset, stride, limit int) <-chan Element
+}
+
+// PatternScanner is implemented by Clusters which can scan only the keys
+// matching a glob-style pattern, as understood by the Redis SCAN command.
+// Clusters returned by New implement PatternScanner.
+type PatternScanner interface {
+	KeysMatching(pattern string, batchSize int) <-chan []string
+}
+
 const (
 	insertSuffix = "+"
 	deleteSuffix = "-"
@@ -101,10 +136,155 @@ var (
 		redis.call('ZREM', remKey, ARGV[2])
 		local n = redis.call('ZADD', addKey, ARGV[1], ARGV[2])
 		redis.call('ZREMRANGEBYRANK', addKey, 0, -(maxSize+1))
+
+		local emptyKeyTTL = tonumber(ARGV[4])
+		if emptyKeyTTL > 0 then
+			if tonumber(redis.call('ZCARD', KEYS[1] .. 'INSERTSUFFIX')) == 0 then
+				redis.call('EXPIRE', KEYS[1] .. 'DELETESUFFIX', emptyKeyTTL)
+			else
+				redis.call('PERSIST', KEYS[1] .. 'DELETESUFFIX')
+			end
+		end
 		return n
 	`
-	insertScript *redis.Script
-	deleteScript *redis.Script
+	insertScript *script
+	deleteScript *script
+
+	// rangeScript performs keyset pagination over the inserts set in
+	// KEYS[1]. It returns up to ARGV[5] member-score pairs, in descending
+	// order, strictly after the start cursor (ARGV[1] score, ARGV[2] member)
+	// and strictly before the stop cursor (ARGV[3] score, ARGV[4] member).
+	// An unlimited number of members may share the cursor scores, so the
+	// script pages through them on the instance rather than returning them
+	// to the client to be discarded. Members are compared bytewise, the way
+	// Redis orders members with equal scores; Lua's own string comparison is
+	// locale-dependent. Scores are returned as the strings Redis provides, to
+	// avoid any loss of precision.
+	//
+	// To bound the time the instance is blocked, at most ARGV[7] elements are
+	// scanned per call, starting ARGV[6] elements into those with a score up
+	// to the start score. The reply is the member-score pairs, followed by a
+	// continuation if the scan stopped early: the start cursor of the next
+	// call, which is the last returned element, or the start cursor if none
+	// was returned, and how many elements with its score were scanned, which
+	// is the offset of the next call.
+	rangeScript = newScript("range", 1, `
+		local startScore = tonumber(ARGV[1])
+		local startMember = ARGV[2]
+		local stopScore = tonumber(ARGV[3])
+		local stopMember = ARGV[4]
+		local limit = tonumber(ARGV[5])
+		local offset = tonumber(ARGV[6])
+		local budget = tonumber(ARGV[7])
+
+		local function less(a, b)
+			local n = math.min(#a, #b)
+			for i = 1, n do
+				local x, y = string.byte(a, i), string.byte(b, i)
+				if x ~= y then
+					return x < y
+				end
+			end
+			return #a < #b
+		end
+
+		local results = {}
+		local count = 0
+		local lastScore, lastMember = ARGV[1], startMember
+		local tied = offset
+		local pageSize = math.max(limit, 10)
+		while count < limit do
+			if budget <= 0 then
+				return {results, {lastScore, lastMember, tied}}
+			end
+			local page = redis.call('ZREVRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[3], 'WITHSCORES', 'LIMIT', offset, math.min(pageSize, budget))
+			local n = #page / 2
+			for i = 1, #page, 2 do
+				local member = page[i]
+				local score = tonumber(page[i+1])
+				local pastStart = score < startScore or (score == startScore and less(member, startMember))
+				local beforeStop = score > stopScore or (score == stopScore and less(stopMember, member))
+				if not beforeStop then
+					return {results}
+				end
+				if pastStart then
+					results[#results+1] = member
+					results[#results+1] = page[i+1]
+					count = count + 1
+					if count >= limit then
+						return {results}
+					end
+					if score ~= tonumber(lastScore) then
+						tied = 0
+					end
+					lastScore, lastMember = page[i+1], member
+				end
+				tied = tied + 1
+			end
+			if n < math.min(pageSize, budget) then
+				break
+			end
+			offset = offset + n
+			budget = budget - n
+		end
+		return {results}
+	`)
+
+	// rangeScanLimit is how many elements the rangeScript scans per call.
+	rangeScanLimit = 1000
+
+	// strideScript returns up to ARGV[3] member-score pairs of the inserts
+	// set in KEYS[1], in descending order, at the ranks ARGV[1], ARGV[1] +
+	// ARGV[2], ARGV[1] + 2*ARGV[2], and so on. Each rank costs one ZREVRANGE,
+	// so only the sampled elements are read.
+	strideScript = newScript("stride", 1, `
+		local offset = tonumber(ARGV[1])
+		local stride = tonumber(ARGV[2])
+		local limit = tonumber(ARGV[3])
+
+		local results = {}
+		for i = 0, limit - 1 do
+			local rank = offset + i * stride
+			local element = redis.call('ZREVRANGE', KEYS[1], rank, rank, 'WITHSCORES')
+			if #element == 0 then
+				break
+			end
+			results[#results+1] = element[1]
+			results[#results+1] = element[2]
+		end
+		return results
+	`)
+
+	// deletePrefixScript moves every member of the inserts set in KEYS[1]
+	// which starts with the prefix ARGV[1] to the deletes set, with the
+	// score it had, and returns the moved member-score pairs. ZRANGEBYLEX
+	// only orders members with equal scores, so the whole set is scanned.
+	// ARGV[2] is the empty key TTL, as for the insert and delete scripts.
+	deletePrefixScript = newScript("delete-prefix", 1, strings.NewReplacer(
+		"INSERTSUFFIX", insertSuffix,
+		"DELETESUFFIX", deleteSuffix,
+	).Replace(`
+		local insertKey = KEYS[1] .. 'INSERTSUFFIX'
+		local deleteKey = KEYS[1] .. 'DELETESUFFIX'
+		local prefix = ARGV[1]
+
+		local deleted = {}
+		local members = redis.call('ZRANGE', insertKey, 0, -1, 'WITHSCORES')
+		for i = 1, #members, 2 do
+			if string.sub(members[i], 1, #prefix) == prefix then
+				redis.call('ZREM', insertKey, members[i])
+				redis.call('ZADD', deleteKey, members[i+1], members[i])
+				deleted[#deleted+1] = members[i]
+				deleted[#deleted+1] = members[i+1]
+			end
+		end
+
+		local emptyKeyTTL = tonumber(ARGV[2])
+		if #deleted > 0 and emptyKeyTTL > 0 and tonumber(redis.call('ZCARD', insertKey)) == 0 then
+			redis.call('EXPIRE', deleteKey, emptyKeyTTL)
+		end
+		return deleted
+	`))
 )
 
 func init() {
@@ -113,12 +293,12 @@ func init() {
 		"DELETESUFFIX", deleteSuffix,
 	).Replace(genericScript)
 
-	insertScript = redis.NewScript(1, strings.NewReplacer(
+	insertScript = newScript("insert", 1, strings.NewReplacer(
 		"REMSUFFIX", deleteSuffix, // Insert script does ZREM from deletes key
 		"ADDSUFFIX", insertSuffix, // and ZADD to inserts key
 	).Replace(genericScript))
 
-	deleteScript = redis.NewScript(1, strings.NewReplacer(
+	deleteScript = newScript("delete", 1, strings.NewReplacer(
 		"REMSUFFIX", insertSuffix, // Delete script does ZREM from inserts key
 		"ADDSUFFIX", deleteSuffix, // and ZADD to deletes key
 	).Replace(genericScript))
@@ -127,55 +307,146 @@ func init() {
 // cluster implements the Cluster interface on a concrete Redis cluster.
 type cluster struct {
 	pool            *pool.Pool
+	readPool        *pool.Pool     // same as pool, unless configured by ReadPool
+	track           func([]string) // invalidation of tracked keys, nil unless configured by Tracking
+	multiplex       bool
 	maxSize         int
+	maxSizes        []maxSizeOverride // overrides, longest prefix first
+	emptyKeyTTL     int               // seconds
+	pipelineSize    int               // tuples, 0 for unlimited
 	selectGap       time.Duration
+	dialect         Dialect
 	instrumentation instrumentation.Instrumentation
 }
 
+// Option configures optional behavior of a Cluster. Options are passed to
+// New.
+type Option func(*cluster)
+
+// EmptyKeyTTL causes keys whose inserts set becomes empty, i.e. keys which
+// only contain deletes, to expire after the grace period. Any subsequent
+// insert to the key cancels the expiry. Note that once a key expires, the
+// record of its deletes is lost, and a late insert with an older score than
+// a delete will be accepted. Choose a grace period longer than any write
+// might reasonably be delayed. Zero or a negative grace period means keys
+// never expire. Grace periods are rounded up to the nearest second.
+func EmptyKeyTTL(grace time.Duration) Option {
+	return func(c *cluster) {
+		c.emptyKeyTTL = 0
+		if grace > 0 {
+			c.emptyKeyTTL = int((grace + time.Second - 1) / time.Second)
+		}
+	}
+}
+
+// PipelineSize limits the number of tuples written to a Redis instance in a
+// single pipeline. Larger batches for an instance are written in chunks of
+// at most size tuples, each with its own connection from the pool, so other
+// requests to the instance can be interleaved between them, rather than
+// waiting for the whole batch. Instances are still written concurrently.
+// Zero or a negative size means batches are never split.
+func PipelineSize(size int) Option {
+	return func(c *cluster) { c.pipelineSize = size }
+}
+
+// Tracking enables client-side caching of the cluster's keys, via the client
+// tracking of Redis 6 and later; see Pool.Track. invalidate is called with
+// the keys which were modified since they were read from the cluster, or
+// with nil keys whenever every key may have been modified. Keys are given as
+// passed to the Cluster, without the suffixes of the underlying Redis keys.
+func Tracking(invalidate func(keys []string)) Option {
+	return func(c *cluster) {
+		c.track = func(redisKeys []string) {
+			if redisKeys == nil {
+				invalidate(nil)
+				return
+			}
+			var (
+				keys = make([]string, 0, len(redisKeys))
+				seen = map[string]bool{}
+			)
+			for _, redisKey := range redisKeys {
+				key := redisKey
+				switch {
+				case strings.HasSuffix(key, insertSuffix):
+					key = strings.TrimSuffix(key, insertSuffix)
+				case strings.HasSuffix(key, deleteSuffix):
+					key = strings.TrimSuffix(key, deleteSuffix)
+				}
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+			invalidate(keys)
+		}
+	}
+}
+
+// ReadPool causes Selects, Scores, and keyspace scans to be served by their
+// own connections to the instances of the cluster, sized and timed out as
+// passed, rather than by those of the pool passed to New, which then only
+// serve writes. Long-running range reads, or a burst of them, can then no
+// longer exhaust the connections needed by latency-critical inserts.
+func ReadPool(connectTimeout, readTimeout, writeTimeout time.Duration, maxConnectionsPerInstance int) Option {
+	return func(c *cluster) {
+		c.readPool = c.pool.Derive(connectTimeout, readTimeout, writeTimeout, maxConnectionsPerInstance)
+	}
+}
+
+// Multiplexed causes the pool passed to New, and the pool of ReadPool, if
+// any, to share a single connection per instance between all requests; see
+// Pool.Multiplex. It suits deployments where so many processes connect to
+// the same instances that their connection pools burden Redis.
+func Multiplexed() Option {
+	return func(c *cluster) { c.multiplex = true }
+}
+
 // New creates and returns a new Cluster backed by a concrete Redis cluster.
 // maxSize for each key will be enforced at write time. selectGap specifies a
 // wait period between pipeline calls to individual connections within a pool
 // when performing a Select with multiple keys. Instrumentation may be nil.
-func New(pool *pool.Pool, maxSize int, selectGap time.Duration, instr instrumentation.Instrumentation) Cluster {
+// Options may be passed to enable optional behavior.
+func New(pool *pool.Pool, maxSize int, selectGap time.Duration, instr instrumentation.Instrumentation, options ...Option) Cluster {
 	if instr == nil {
 		instr = instrumentation.NopInstrumentation{}
 	}
-	return &cluster{
+	pool.Instrument(instr)
+	c := &cluster{
 		pool:            pool,
+		readPool:        pool,
 		maxSize:         maxSize,
 		selectGap:       selectGap,
 		instrumentation: instr,
 	}
-}
-
-// Insert efficiently performs ZADDs for each of the passed tuples.
-func (c *cluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
-	// Bucketize
-	m := map[int][]common.KeyScoreMember{}
-	for _, tuple := range keyScoreMembers {
-		index := c.pool.Index(tuple.Key)
-		m[index] = append(m[index], tuple)
+	for _, option := range options {
+		option(c)
 	}
-
-	// Scatter
-	errChan := make(chan error, len(m))
-	for index, keyScoreMembers := range m {
-		go func(index int, keyScoreMembers []common.KeyScoreMember) {
-
-			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
-				return pipelineInsert(conn, keyScoreMembers, c.maxSize)
-			})
-
-		}(index, keyScoreMembers)
+	if c.readPool != pool {
+		c.readPool.Instrument(instr)
 	}
-
-	// Gather
-	for _ = range m {
-		if err := <-errChan; err != nil {
-			return err
+	if c.multiplex {
+		pool.Multiplex()
+		if c.readPool != pool {
+			c.readPool.Multiplex()
 		}
 	}
-	return nil
+	if c.track != nil && !c.dialect.Tracking() {
+		log.Printf("cluster: client tracking isn't supported by %s; not tracking keys", c.dialect)
+		c.track = nil
+	}
+	if c.track != nil {
+		pool.Track(c.track)
+		if c.readPool != pool {
+			c.readPool.Track(c.track)
+		}
+	}
+	return c
+}
+
+// Insert efficiently performs ZADDs for each of the passed tuples.
+func (c *cluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
+	return c.write(keyScoreMembers, pipelineInsert)
 }
 
 // SelectOffset efficiently performs ZREVRANGEs for each of the passed keys
@@ -183,18 +454,37 @@ func (c *cluster) Insert(keyScoreMembers []common.KeyScoreMember) error {
 // as they become available.
 func (c *cluster) SelectOffset(keys []string, offset, limit int) <-chan Element {
 	return c.selectCommon(keys, func(conn redis.Conn, myKeys []string) (map[string][]common.KeyScoreMember, error) {
-		return pipelineRange(conn, myKeys, offset, limit)
+		return pipelineRange(conn, myKeys, offset, limit, false)
+	})
+}
+
+// SelectOffsetAscending is like SelectOffset, but uses ZRANGE, so elements
+// are ordered from the lowest score to the highest, i.e. oldest first.
+func (c *cluster) SelectOffsetAscending(keys []string, offset, limit int) <-chan Element {
+	return c.selectCommon(keys, func(conn redis.Conn, myKeys []string) (map[string][]common.KeyScoreMember, error) {
+		return pipelineRange(conn, myKeys, offset, limit, true)
 	})
 }
 
 // SelectRange uses ZREVRANGEBYSCORE to do a cursor-based select, similar to
-// SelectOffset.
+// SelectOffset. The start and stop cursors are applied within a Lua script on
+// each Redis instance, so at most limit elements are returned per key. Deep
+// pages should prefer SelectRange over SelectOffset, which must skip over
+// offset elements for every request.
 func (c *cluster) SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan Element {
 	return c.selectCommon(keys, func(conn redis.Conn, myKeys []string) (map[string][]common.KeyScoreMember, error) {
 		return pipelineRangeByScore(conn, myKeys, start, stop, limit)
 	})
 }
 
+// SelectStride implements Sampler. It samples each key on its instance, via
+// a Lua script.
+func (c *cluster) SelectStride(keys []string, offset, stride, limit int) <-chan Element {
+	return c.selectCommon(keys, func(conn redis.Conn, myKeys []string) (map[string][]common.KeyScoreMember, error) {
+		return pipelineStride(conn, myKeys, offset, stride, limit)
+	})
+}
+
 func (c *cluster) selectCommon(
 	keys []string,
 	fn func(redis.Conn, []string) (map[string][]common.KeyScoreMember, error),
@@ -222,7 +512,7 @@ func (c *cluster) selectCommon(
 				// minimize our time with the redis.Conn.
 				var elements []Element
 				var result map[string][]common.KeyScoreMember
-				if err := c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
+				if err := c.readPool.WithIndex(index, func(conn redis.Conn) (err error) {
 					result, err = fn(conn, keys)
 					return
 				}); err != nil {
@@ -247,6 +537,18 @@ func (c *cluster) selectCommon(
 
 // Delete efficiently performs ZREMs for each of the passed tuples.
 func (c *cluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
+	return c.write(keyScoreMembers, pipelineDelete)
+}
+
+// write scatters the tuples to their instances, and writes them with the
+// pipeline function, in chunks of at most pipelineSize tuples per instance.
+// Tuples are grouped by instance across the whole batch, whatever their
+// keys, so a batch costs one round trip per instance (and chunk), rather
+// than one per key.
+func (c *cluster) write(
+	keyScoreMembers []common.KeyScoreMember,
+	pipeline func(redis.Conn, []common.KeyScoreMember, func(string) int, int) error,
+) error {
 	// Bucketize
 	m := map[int][]common.KeyScoreMember{}
 	for _, keyScoreMember := range keyScoreMembers {
@@ -258,10 +560,15 @@ func (c *cluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
 	errChan := make(chan error, len(m))
 	for index, keyScoreMembers := range m {
 		go func(index int, keyScoreMembers []common.KeyScoreMember) {
-			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
-				return pipelineDelete(conn, keyScoreMembers, c.maxSize)
-			})
-
+			for _, batch := range chunk(keyScoreMembers, c.pipelineSize) {
+				if err := c.pool.WithIndex(index, func(conn redis.Conn) error {
+					return pipeline(conn, batch, c.maxSizeOf, c.emptyKeyTTL)
+				}); err != nil {
+					errChan <- err
+					return
+				}
+			}
+			errChan <- nil
 		}(index, keyScoreMembers)
 	}
 
@@ -274,6 +581,19 @@ func (c *cluster) Delete(keyScoreMembers []common.KeyScoreMember) error {
 	return nil
 }
 
+// chunk splits the tuples into consecutive chunks of at most size tuples.
+func chunk(keyScoreMembers []common.KeyScoreMember, size int) [][]common.KeyScoreMember {
+	if size <= 0 || len(keyScoreMembers) <= size {
+		return [][]common.KeyScoreMember{keyScoreMembers}
+	}
+	chunks := make([][]common.KeyScoreMember, 0, (len(keyScoreMembers)+size-1)/size)
+	for len(keyScoreMembers) > size {
+		chunks = append(chunks, keyScoreMembers[:size])
+		keyScoreMembers = keyScoreMembers[size:]
+	}
+	return append(chunks, keyScoreMembers)
+}
+
 // Score returns the presence statistics of each passed key-member.
 // That is, whether the key-member exists in this cluster, if it's in
 // an insert set, and its score.
@@ -294,7 +614,7 @@ func (c *cluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]Pre
 	for index, keyMembers := range m {
 		go func(index int, keyMembers []common.KeyMember) {
 			var presenceMap map[common.KeyMember]Presence
-			err := c.pool.WithIndex(index, func(conn redis.Conn) (err error) {
+			err := c.readPool.WithIndex(index, func(conn redis.Conn) (err error) {
 				presenceMap, err = pipelineScore(conn, keyMembers)
 				return
 			})
@@ -319,6 +639,38 @@ func (c *cluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]Pre
 	return presenceMap, nil
 }
 
+// DeletePrefix implements PrefixDeleter. Matching members are deleted with
+// the score they had, atomically, and returned in ascending order of score.
+// The prefix must not be empty.
+func (c *cluster) DeletePrefix(key, prefix string) ([]common.KeyScoreMember, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("empty prefix")
+	}
+	var deleted []common.KeyScoreMember
+	if err := c.pool.WithIndex(c.pool.Index(key), func(conn redis.Conn) error {
+		values, err := redis.Values(deletePrefixScript.Do(conn, key, prefix, c.emptyKeyTTL))
+		if err != nil {
+			return err
+		}
+		deleted = make([]common.KeyScoreMember, 0, len(values)/2)
+		for i := 0; i+1 < len(values); i += 2 {
+			member, err := redis.String(values[i], nil)
+			if err != nil {
+				return err
+			}
+			score, err := redis.Float64(values[i+1], nil)
+			if err != nil {
+				return err
+			}
+			deleted = append(deleted, common.KeyScoreMember{Key: key, Score: score, Member: member})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
 // Presence represents the state of a given key-member in a cluster.
 type Presence struct {
 	Present  bool
@@ -342,85 +694,158 @@ func (c *cluster) Keys(batchSize int) <-chan []string {
 		}()
 
 		for _, index := range rand.Perm(c.pool.Size()) {
-			log.Printf("cluster: scanning keyspace of %q (batch size %d)", c.pool.ID(index), batchSize)
-			cursor := 0
-			batch := make([]string, 0, batchSize)
-			for {
-				if err := c.pool.WithIndex(index, func(conn redis.Conn) error {
-					values, err := redis.Values(conn.Do("SCAN", cursor, "COUNT", fmt.Sprint(batchSize)))
-					if err != nil {
-						return err
-					}
-
-					if n := len(values); n != 2 {
-						return fmt.Errorf("received %d values from Redis, expected exactly 2", n)
-					}
+			c.scanInstance(index, batchSize, "", ch, &sent, nil)
+		}
+	}()
+	return ch
+}
 
-					newCursor, err := redis.Int(values[0], nil)
-					if err != nil {
-						return err
-					}
+// Instances implements InstanceScanner.
+func (c *cluster) Instances() int {
+	return c.pool.Size()
+}
 
-					keys, err := redis.Strings(values[1], nil)
-					if err != nil {
-						return err
-					}
+// InstanceKeys implements InstanceScanner.
+func (c *cluster) InstanceKeys(index, batchSize int, stop <-chan struct{}) <-chan []string {
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		var sent uint64
+		c.scanInstance(index, batchSize, "", ch, &sent, stop)
+	}()
+	return ch
+}
 
-					for _, key := range keys {
-						// Only emit keys with insertSuffix - but strip the suffix.
-						l := len(key) - len(insertSuffix)
-						if key[l:] == insertSuffix {
-							batch = append(batch, key[:l])
-							if len(batch) >= batchSize {
-								atomic.AddUint64(&sent, uint64(len(batch)))
-								ch <- batch
-								batch = make([]string, 0, batchSize)
-							}
-						}
-					}
-					cursor = newCursor
-					return nil
-				}); err == nil && cursor == 0 {
-					log.Printf("cluster: Keys on %q is complete", c.pool.ID(index))
-					break // No error, and cursor back at 0: this instance is done.
-				} else if err != nil {
-					log.Printf("cluster: during Keys on %q: %s", c.pool.ID(index), err)
-					time.Sleep(1 * time.Second) // and retry
-				}
-			}
-			if len(batch) > 0 {
-				ch <- batch
-			}
+// KeysMatching implements PatternScanner.
+func (c *cluster) KeysMatching(pattern string, batchSize int) <-chan []string {
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		var sent uint64
+		for _, index := range rand.Perm(c.pool.Size()) {
+			c.scanInstance(index, batchSize, pattern, ch, &sent, nil)
 		}
 	}()
 	return ch
 }
 
-func pipelineInsert(conn redis.Conn, keyScoreMembers []common.KeyScoreMember, maxSize int) error {
-	for _, tuple := range keyScoreMembers {
-		if err := insertScript.Send(
-			conn,
-			tuple.Key,
-			tuple.Score,
-			tuple.Member,
-			maxSize,
-		); err != nil {
-			return err
-		}
+// scanInstance SCANs the keyspace of the Redis instance at index, and sends
+// keys in batches to ch. If pattern isn't empty, only keys matching it are
+// sent. Errors are retried until the scan completes, or until stop, if not
+// nil, is closed.
+func (c *cluster) scanInstance(index, batchSize int, pattern string, ch chan<- []string, sent *uint64, stop <-chan struct{}) {
+	log.Printf("cluster: scanning keyspace of %q (batch size %d)", c.pool.ID(index), batchSize)
+	args := []interface{}{"COUNT", fmt.Sprint(batchSize)}
+	if pattern != "" {
+		args = append(args, "MATCH", pattern+insertSuffix)
 	}
+	cursor := "0" // opaque: Dragonfly's cursors may not fit an int
+	batch := make([]string, 0, batchSize)
+	for {
+		select {
+		case <-stop:
+			log.Printf("cluster: Keys on %q stopped", c.pool.ID(index))
+			return
+		default:
+		}
+		if err := c.readPool.WithIndex(index, func(conn redis.Conn) error {
+			values, err := redis.Values(conn.Do("SCAN", append([]interface{}{cursor}, args...)...))
+			if err != nil {
+				return err
+			}
 
-	if err := conn.Flush(); err != nil {
-		return err
-	}
+			if n := len(values); n != 2 {
+				return fmt.Errorf("received %d values from Redis, expected exactly 2", n)
+			}
 
-	for _ = range keyScoreMembers {
-		// TODO actually count inserts
-		if _, err := conn.Receive(); err != nil {
-			return err
+			newCursor, err := redis.String(values[0], nil)
+			if err != nil {
+				return err
+			}
+
+			keys, err := redis.Strings(values[1], nil)
+			if err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				// Only emit keys with insertSuffix - but strip the suffix.
+				l := len(key) - len(insertSuffix)
+				if key[l:] == insertSuffix {
+					batch = append(batch, key[:l])
+					if len(batch) >= batchSize {
+						atomic.AddUint64(sent, uint64(len(batch)))
+						select {
+						case ch <- batch:
+						case <-stop:
+							return errScanStopped
+						}
+						batch = make([]string, 0, batchSize)
+					}
+				}
+			}
+			cursor = newCursor
+			return nil
+		}); err == nil && cursor == "0" {
+			log.Printf("cluster: Keys on %q is complete", c.pool.ID(index))
+			break // No error, and cursor back at 0: this instance is done.
+		} else if err == errScanStopped {
+			log.Printf("cluster: Keys on %q stopped", c.pool.ID(index))
+			return
+		} else if err != nil {
+			log.Printf("cluster: during Keys on %q: %s", c.pool.ID(index), err)
+			time.Sleep(1 * time.Second) // and retry
+		}
+	}
+	if len(batch) > 0 {
+		select {
+		case ch <- batch:
+		case <-stop:
 		}
 	}
+}
 
-	return nil
+// errScanStopped is returned within scanInstance when the scan is stopped.
+var errScanStopped = errors.New("scan stopped")
+
+func pipelineInsert(conn redis.Conn, keyScoreMembers []common.KeyScoreMember, maxSize func(string) int, emptyKeyTTL int) error {
+	return pipelineWrite(conn, insertScript, keyScoreMembers, maxSize, emptyKeyTTL)
+}
+
+// pipelineWrite sends the write script for each tuple, and waits for every
+// reply. It's equivalent to calling Send on the script for each tuple, but
+// avoids most of the allocations per tuple: the argument slice, and the
+// boxed arguments which are the same for every tuple, are reused, as the
+// connection encodes the arguments before Send returns. The max size of each
+// key is only boxed again when it differs from that of the previous tuple.
+// If the instance doesn't have the script, it's loaded, and the tuples are
+// written again.
+func pipelineWrite(conn redis.Conn, s *script, keyScoreMembers []common.KeyScoreMember, maxSize func(string) int, emptyKeyTTL int) error {
+	args := []interface{}{
+		s.hash,
+		s.keyCount,
+		nil, // key
+		nil, // score
+		nil, // member
+		nil, // max size
+		emptyKeyTTL,
+	}
+	return s.reloading(conn, func() error {
+		var (
+			p    = pool.NewPipeline(conn)
+			last = -1
+		)
+		for _, tuple := range keyScoreMembers {
+			args[2], args[3], args[4] = tuple.Key, tuple.Score, tuple.Member
+			if size := maxSize(tuple.Key); size != last {
+				args[5], last = size, size
+			}
+			p.Queue("EVALSHA", args...)
+		}
+		// TODO actually count writes
+		_, err := p.Exec()
+		return err
+	})
 }
 
 // Element combines a submitted key with its selected score-members. If there
@@ -456,30 +881,34 @@ func successElements(m map[string][]common.KeyScoreMember) []Element {
 	return elements
 }
 
-func pipelineRange(conn redis.Conn, keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+func pipelineRange(conn redis.Conn, keys []string, offset, limit int, ascending bool) (map[string][]common.KeyScoreMember, error) {
 	if limit < 0 {
 		return map[string][]common.KeyScoreMember{}, fmt.Errorf("negative limit is invalid for offset-based select")
 	}
+	command := "ZREVRANGE"
+	if ascending {
+		command = "ZRANGE"
+	}
+	p := pool.NewPipeline(conn)
 	for _, key := range keys {
-		if err := conn.Send(
-			"ZREVRANGE",
+		p.Queue(
+			command,
 			key+insertSuffix,
 			offset,
 			offset+limit-1,
 			"WITHSCORES",
-		); err != nil {
-			return map[string][]common.KeyScoreMember{}, err
-		}
+		)
 	}
 
-	if err := conn.Flush(); err != nil {
+	replies, err := p.Exec()
+	if err != nil {
 		return map[string][]common.KeyScoreMember{}, err
 	}
 
 	m := make(map[string][]common.KeyScoreMember, len(keys))
 
-	for _, key := range keys {
-		values, err := redis.Values(conn.Receive())
+	for i, key := range keys {
+		values, err := redis.Values(replies[i], nil)
 		if err != nil {
 			return map[string][]common.KeyScoreMember{}, err
 		}
@@ -509,188 +938,144 @@ func pipelineRangeByScore(conn redis.Conn, keys []string, start, stop common.Cur
 		return map[string][]common.KeyScoreMember{}, fmt.Errorf("negative limit is invalid for cursor-based select")
 	}
 
-	// pastStart returns true when the score+member are "past" the cursor
-	// (smaller score, larger lexicographically) and can therefore be included
-	// in the resultset.
-	pastStart := func(score float64, member string) bool {
-		if score < start.Score {
-			return true
-		}
-		if score == start.Score && member < start.Member {
-			return true
-		}
-		return false
-	}
-
-	// beforeStop returns true as long as the score+member are "before" the
-	// stop (larger score, smaller lexicographically) and can therefore
-	// be included in the resultset.
-	beforeStop := func(score float64, member string) bool {
-		if score > stop.Score {
-			return true
-		}
-		if score == stop.Score && member > stop.Member {
-			return true
-		}
-		return false
+	// The boundaries are evaluated within the range script, on the Redis
+	// instance, so that we receive at most limit elements per key. Each call
+	// scans a bounded number of elements, so keys with many elements before
+	// the start cursor, e.g. sharing its score, take several round trips.
+	type page struct {
+		start  common.Cursor
+		offset int
 	}
-
-	// An unlimited number of members may exist at cursor.Score. Luckily,
-	// they're in lexicographically stable order. Walk the elements we get
-	// back. For as long as element.Score == cursor.Score, and a
-	// lexicographical comparison of (element.Score, cursor.Score) < 0,
-	// discard the element. As soon as that condition fails, break the loop,
-	// and collect elements. If we run out of elements before collecting the
-	// user-requested limit, double the limit and try again, up to N times.
-
 	var (
-		startScoreStr = fmt.Sprint(start.Score)
-		keysToSelect  = keys  // start with all
-		selectLimit   = limit // double every time
-		maxAttempts   = 4     // up to this many times (TODO could be paramaterized)
-		results       = make(map[string][]common.KeyScoreMember, len(keys))
+		m       = make(map[string][]common.KeyScoreMember, len(keys))
+		pending = make(map[string]page, len(keys))
+		stopStr = fmt.Sprint(stop.Score)
 	)
+	for _, key := range keys {
+		m[key] = []common.KeyScoreMember{}
+		pending[key] = page{start: start}
+	}
 
-	for attempt := 0; len(keysToSelect) > 0 && attempt < maxAttempts; attempt++ {
-		for _, key := range keysToSelect {
-			if err := conn.Send(
-				"ZREVRANGEBYSCORE",
-				key+insertSuffix,
-				startScoreStr, // max
-				"-inf",        // min
-				"WITHSCORES",
-				"LIMIT",
-				0,
-				selectLimit,
-			); err != nil {
-				return map[string][]common.KeyScoreMember{}, err
+	for len(pending) > 0 {
+		queued := make([]string, 0, len(pending))
+		var replies []interface{}
+		if err := rangeScript.reloading(conn, func() (err error) {
+			p := pool.NewPipeline(conn)
+			for key, pg := range pending {
+				queued = append(queued, key)
+				rangeScript.Queue(
+					p,
+					key+insertSuffix,
+					fmt.Sprint(pg.start.Score),
+					pg.start.Member,
+					stopStr,
+					stop.Member,
+					limit-len(m[key]),
+					pg.offset,
+					rangeScanLimit,
+				)
 			}
-		}
-
-		if err := conn.Flush(); err != nil {
+			replies, err = p.Exec()
+			return err
+		}); err != nil {
 			return map[string][]common.KeyScoreMember{}, err
 		}
 
-		m := make(map[string][]common.KeyScoreMember, len(keys))
-		for _, key := range keysToSelect {
-			values, err := redis.Values(conn.Receive())
+		for i, key := range queued {
+			reply, err := redis.Values(replies[i], nil)
 			if err != nil {
 				return map[string][]common.KeyScoreMember{}, err
 			}
+			var values, continuation []interface{}
+			if values, err = redis.Values(reply[0], nil); err != nil {
+				return map[string][]common.KeyScoreMember{}, err
+			}
 
-			var (
-				collected = 0
-				validated = make([]common.KeyScoreMember, 0, len(values))
-				hitStop   = false
-				ksm       = common.KeyScoreMember{Key: key}
-			)
-
-			for len(values) > 0 && !hitStop {
+			ksm := common.KeyScoreMember{Key: key}
+			for len(values) > 0 {
 				if values, err = redis.Scan(values, &ksm.Member, &ksm.Score); err != nil {
 					return map[string][]common.KeyScoreMember{}, err
 				}
-
-				collected++
-
-				if !pastStart(ksm.Score, ksm.Member) {
-					continue // this element is behind or at our start point
-				}
-				if !beforeStop(ksm.Score, ksm.Member) {
-					hitStop = true
-					continue // this element is at or beyond our stop point
-				}
-
-				validated = append(validated, ksm)
+				m[key] = append(m[key], ksm)
 			}
 
-			// At this point, we know if we can use these elements, or need to
-			// go back for more.
-			var (
-				haveEnoughElements = len(validated) >= limit
-				exhaustedElements  = collected < selectLimit
-			)
-			if haveEnoughElements || exhaustedElements || hitStop {
-				if len(validated) > limit {
-					validated = validated[:limit]
-				}
-				m[key] = validated
+			if len(reply) < 2 {
+				delete(pending, key)
+				continue
 			}
-		}
-
-		retryKeys := make([]string, 0, len(keysToSelect))
-
-		for _, key := range keysToSelect {
-			if a, ok := m[key]; ok {
-				results[key] = a // use it
-			} else {
-				retryKeys = append(retryKeys, key) // try again
+			if continuation, err = redis.Values(reply[1], nil); err != nil {
+				return map[string][]common.KeyScoreMember{}, err
 			}
-		}
-
-		keysToSelect = retryKeys
-
-		if selectLimit < 10 {
-			selectLimit = 25
-		} else if selectLimit < 100 {
-			selectLimit *= 2
-		} else {
-			selectLimit += 50
+			var next page
+			if _, err = redis.Scan(continuation, &next.start.Score, &next.start.Member, &next.offset); err != nil {
+				return map[string][]common.KeyScoreMember{}, err
+			}
+			pending[key] = next
 		}
 	}
 
-	if n := len(keysToSelect); n > 0 {
-		return map[string][]common.KeyScoreMember{}, fmt.Errorf("%d key(s) failed to yield enough elements (original limit %d)", n, limit)
-	}
-
-	return results, nil
+	return m, nil
 }
 
-func pipelineDelete(conn redis.Conn, keyScoreMembers []common.KeyScoreMember, maxSize int) error {
-	for _, keyScoreMember := range keyScoreMembers {
-		if err := deleteScript.Send(
-			conn,
-			keyScoreMember.Key,
-			keyScoreMember.Score,
-			keyScoreMember.Member,
-			maxSize,
-		); err != nil {
-			return err
-		}
+func pipelineStride(conn redis.Conn, keys []string, offset, stride, limit int) (map[string][]common.KeyScoreMember, error) {
+	if offset < 0 || stride < 1 || limit < 0 {
+		return map[string][]common.KeyScoreMember{}, fmt.Errorf("invalid offset %d, stride %d, or limit %d", offset, stride, limit)
 	}
 
-	if err := conn.Flush(); err != nil {
+	var replies []interface{}
+	if err := strideScript.reloading(conn, func() (err error) {
+		p := pool.NewPipeline(conn)
+		for _, key := range keys {
+			strideScript.Queue(p, key+insertSuffix, offset, stride, limit)
+		}
+		replies, err = p.Exec()
 		return err
+	}); err != nil {
+		return map[string][]common.KeyScoreMember{}, err
 	}
 
-	for _ = range keyScoreMembers {
-		// TODO actually count deletes
-		if _, err := conn.Receive(); err != nil {
-			return err
+	m := make(map[string][]common.KeyScoreMember, len(keys))
+	for i, key := range keys {
+		values, err := redis.Values(replies[i], nil)
+		if err != nil {
+			return map[string][]common.KeyScoreMember{}, err
+		}
+
+		var (
+			ksm             = common.KeyScoreMember{Key: key}
+			keyScoreMembers = make([]common.KeyScoreMember, 0, len(values)/2)
+		)
+		for len(values) > 0 {
+			if values, err = redis.Scan(values, &ksm.Member, &ksm.Score); err != nil {
+				return map[string][]common.KeyScoreMember{}, err
+			}
+			keyScoreMembers = append(keyScoreMembers, ksm)
 		}
+		m[key] = keyScoreMembers
 	}
 
-	return nil
+	return m, nil
+}
+
+func pipelineDelete(conn redis.Conn, keyScoreMembers []common.KeyScoreMember, maxSize func(string) int, emptyKeyTTL int) error {
+	return pipelineWrite(conn, deleteScript, keyScoreMembers, maxSize, emptyKeyTTL)
 }
 
 func pipelineScore(conn redis.Conn, keyMembers []common.KeyMember) (map[common.KeyMember]Presence, error) {
+	p := pool.NewPipeline(conn)
 	for _, keyMember := range keyMembers {
-		if err := conn.Send("ZSCORE", keyMember.Key+insertSuffix, keyMember.Member); err != nil {
-			return map[common.KeyMember]Presence{}, err
-		}
-		if err := conn.Send("ZSCORE", keyMember.Key+deleteSuffix, keyMember.Member); err != nil {
-			return map[common.KeyMember]Presence{}, err
-		}
+		p.Queue("ZSCORE", keyMember.Key+insertSuffix, keyMember.Member)
+		p.Queue("ZSCORE", keyMember.Key+deleteSuffix, keyMember.Member)
 	}
-	if err := conn.Flush(); err != nil {
+	replies, err := p.Exec()
+	if err != nil {
 		return map[common.KeyMember]Presence{}, err
 	}
 
 	m := map[common.KeyMember]Presence{}
 	for i := 0; i < len(keyMembers); i++ {
-		insertReply, insertErr := conn.Receive()
-		insertValue, insertErr := redis.Float64(insertReply, insertErr)
-		deleteReply, deleteErr := conn.Receive()
-		deleteValue, deleteErr := redis.Float64(deleteReply, deleteErr)
+		insertValue, insertErr := redis.Float64(replies[2*i], nil)
+		deleteValue, deleteErr := redis.Float64(replies[2*i+1], nil)
 		switch {
 		case insertErr == nil && deleteErr == redis.ErrNil:
 			m[keyMembers[i]] = Presence{
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -13,6 +15,7 @@ import (
 	"github.com/garyburd/redigo/redis"
 
 	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
 	"github.com/soundcloud/roshi/common"
 	"github.com/soundcloud/roshi/pool"
 )
@@ -390,7 +393,7 @@ func TestSelectRange(t *testing.T) {
 		t.Fatalf("key %q: expected \n\t%+v, got \n\t%+v", e.Key, expected, got)
 	}
 	if _, ok := <-ch; ok {
-		t.Fatalf("key %q: expected 1 element on the channel, got multiple")
+		t.Fatalf("key %q: expected 1 element on the channel, got multiple", e.Key)
 	}
 
 	// Top of the list.
@@ -414,7 +417,7 @@ func TestSelectRange(t *testing.T) {
 		t.Fatalf("key %q: expected \n\t%+v, got \n\t%+v", e.Key, expected, got)
 	}
 	if _, ok := <-ch; ok {
-		t.Fatalf("key %q: expected 1 element on the channel, got multiple")
+		t.Fatalf("key %q: expected 1 element on the channel, got multiple", e.Key)
 	}
 
 	// Restricted limit.
@@ -432,7 +435,7 @@ func TestSelectRange(t *testing.T) {
 		t.Fatalf("key %q: expected \n\t%+v, got \n\t%+v", e.Key, expected, got)
 	}
 	if _, ok := <-ch; ok {
-		t.Fatalf("key %q: expected 1 element on the channel, got multiple")
+		t.Fatalf("key %q: expected 1 element on the channel, got multiple", e.Key)
 	}
 
 	// Multiple keys, top of the list, all elements.
@@ -535,7 +538,7 @@ func TestSelectRange(t *testing.T) {
 		t.Fatalf("key %q: expected \n\t%+v, got \n\t%+v", e.Key, expected, got)
 	}
 	if _, ok := <-ch; ok {
-		t.Fatalf("key %q: expected 1 element on the channel, got multiple")
+		t.Fatalf("key %q: expected 1 element on the channel, got multiple", e.Key)
 	}
 
 	// Middle of the list, using the stopcursor.
@@ -552,7 +555,42 @@ func TestSelectRange(t *testing.T) {
 		t.Fatalf("key %q: expected \n\t%+v, got \n\t%+v", e.Key, expected, got)
 	}
 	if _, ok := <-ch; ok {
-		t.Fatalf("key %q: expected 1 element on the channel, got multiple")
+		t.Fatalf("key %q: expected 1 element on the channel, got multiple", e.Key)
+	}
+}
+
+func TestSelectRangeManyTies(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// More members share the cursor score than the range script scans per
+	// call, so the select has to continue where each call stopped.
+	const n = 2500
+	c := integrationCluster(t, addresses, n+1)
+	tuples := make([]common.KeyScoreMember, 0, n+1)
+	for i := 0; i < n; i++ {
+		tuples = append(tuples, common.KeyScoreMember{Key: "foo", Score: 2, Member: fmt.Sprintf("m%04d", i)})
+	}
+	tuples = append(tuples, common.KeyScoreMember{Key: "foo", Score: 1, Member: "last"})
+	if err := c.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := c.SelectRange([]string{"foo"}, common.Cursor{Score: 2, Member: "m0002"}, common.Cursor{}, 5)
+	expected := []common.KeyScoreMember{
+		{"foo", 2, "m0001"},
+		{"foo", 2, "m0000"},
+		{"foo", 1, "last"},
+	}
+	e := <-ch
+	if e.Error != nil {
+		t.Fatalf("key %q: %s", e.Key, e.Error)
+	}
+	if got := e.KeyScoreMembers; !reflect.DeepEqual(expected, got) {
+		t.Fatalf("key %q: expected \n\t%+v, got \n\t%+v", e.Key, expected, got)
 	}
 }
 
@@ -589,7 +627,189 @@ func TestCursorRetries(t *testing.T) {
 	}
 }
 
-func integrationCluster(t *testing.T, addresses string, maxSize int) cluster.Cluster {
+func TestEmptyKeyTTL(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// Use a single instance, so we can inspect the physical keys directly.
+	address := strings.Split(addresses, ",")[0]
+	c := integrationCluster(t, address, 1000, cluster.EmptyKeyTTL(time.Hour))
+	conn, err := redis.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ttl := func(key string) int {
+		n, err := redis.Int(conn.Do("TTL", key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// A key with only deletes should expire.
+	if err := c.Insert([]common.KeyScoreMember{{"foo", 1, "alpha"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{{"foo", 2, "alpha"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := ttl("foo-"); n <= 0 || n > 3600 {
+		t.Errorf("after delete: expected TTL in (0, 3600], got %d", n)
+	}
+
+	// A subsequent insert should cancel the expiry.
+	if err := c.Insert([]common.KeyScoreMember{{"foo", 3, "beta"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := ttl("foo-"); n != -1 {
+		t.Errorf("after insert: expected no TTL (-1), got %d", n)
+	}
+	if n := ttl("foo+"); n != -1 {
+		t.Errorf("after insert: expected no TTL (-1) on inserts key, got %d", n)
+	}
+}
+
+func TestSelectOffsetAscending(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{"foo", 50, "alpha"},
+		{"foo", 99, "beta"},
+		{"foo", 11, "delta"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-c.SelectOffsetAscending([]string{"foo"}, 1, 2)
+	if e.Error != nil {
+		t.Fatal(e.Error)
+	}
+	if expected, got := []common.KeyScoreMember{
+		{"foo", 50, "alpha"},
+		{"foo", 99, "beta"},
+	}, e.KeyScoreMembers; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestKeysMatching(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{"timeline:1", 1, "alpha"},
+		{"timeline:2", 1, "alpha"},
+		{"notifications:1", 1, "alpha"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	for batch := range c.(cluster.PatternScanner).KeysMatching("timeline:*", 10) {
+		keys = append(keys, batch...)
+	}
+	sort.Strings(keys)
+	if expected, got := []string{"timeline:1", "timeline:2"}, keys; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	checks, err := cluster.Check(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := len(strings.Split(addresses, ",")), len(checks); expected != got {
+		t.Fatalf("expected %d check(s), got %d", expected, got)
+	}
+	for _, check := range checks {
+		if check.Err != nil {
+			t.Errorf("%s: %s", check.Address, check.Err)
+		}
+	}
+}
+
+func TestScriptVersions(t *testing.T) {
+	var names []string
+	for _, version := range cluster.ScriptVersions() {
+		if len(version.SHA1) != 40 {
+			t.Errorf("%s: invalid SHA1 %q", version.Name, version.SHA1)
+		}
+		names = append(names, version.Name)
+	}
+	if expected, got := []string{"delete", "delete-if", "delete-prefix", "digest", "insert", "mark-converged", "range", "stride", "touch"}, names; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestLoadScripts(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if _, err := cluster.LoadScripts(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process records a different version of the insert script.
+	address := strings.Split(addresses, ",")[0]
+	conn, err := redis.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Do("HSET", "roshi:scripts", "insert", "0123456789abcdef0123456789abcdef01234567"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := cluster.LoadScripts(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("%s: %s", result.Address, result.Err)
+		}
+		var expected []cluster.ScriptVersion
+		if result.Address == address {
+			expected = []cluster.ScriptVersion{{Name: "insert", SHA1: "0123456789abcdef0123456789abcdef01234567"}}
+		}
+		if !reflect.DeepEqual(expected, result.Drift) {
+			t.Errorf("%s: expected drift %v, got %v", result.Address, expected, result.Drift)
+		}
+	}
+
+	// Our versions were recorded again.
+	if results, _ = cluster.LoadScripts(c); len(results[0].Drift) > 0 {
+		t.Errorf("%s: expected no drift, got %v", results[0].Address, results[0].Drift)
+	}
+}
+
+func integrationCluster(t *testing.T, addresses string, maxSize int, options ...cluster.Option) cluster.Cluster {
 	p := pool.New(
 		strings.Split(addresses, ","),
 		1*time.Second, // connect timeout
@@ -609,5 +829,505 @@ func integrationCluster(t *testing.T, addresses string, maxSize int) cluster.Clu
 		})
 	}
 
-	return cluster.New(p, maxSize, 0, nil)
+	return cluster.New(p, maxSize, 0, nil, options...)
+}
+
+func TestConvergence(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// The scripts must converge to the state specified by the history, in
+	// whatever order, and however often, its writes are applied.
+	for seed := int64(0); seed < 20; seed++ {
+		var (
+			r        = rand.New(rand.NewSource(seed))
+			h        = clustertest.RandomHistory(r, 30, 3, 4)
+			expected = h.Expected()
+		)
+		for name, variant := range map[string]clustertest.History{
+			"in order": h,
+			"permuted": h.Permuted(r),
+			"repeated": h.Repeated(r).Permuted(r),
+		} {
+			c := integrationCluster(t, addresses, 1000)
+			if err := variant.Apply(c); err != nil {
+				t.Fatalf("seed %d, %s: %s", seed, name, err)
+			}
+			got, err := h.State(c)
+			if err != nil {
+				t.Fatalf("seed %d, %s: %s", seed, name, err)
+			}
+			if !reflect.DeepEqual(expected, got) {
+				t.Errorf("seed %d, %s: history %v: expected\n %v, got\n %v", seed, name, variant, expected, got)
+			}
+		}
+	}
+}
+
+func TestPipelineSize(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	// Batches split into pipelines must be written in full, and in order.
+	for _, size := range []int{1, 3, 100} {
+		var (
+			r = rand.New(rand.NewSource(int64(size)))
+			h = clustertest.RandomHistory(r, 200, 10, 10)
+			c = integrationCluster(t, addresses, 1000, cluster.PipelineSize(size))
+		)
+		if err := h.Apply(c); err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		got, err := h.State(c)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if expected := h.Expected(); !reflect.DeepEqual(expected, got) {
+			t.Errorf("size %d: expected\n %v, got\n %v", size, expected, got)
+		}
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{"foo", 3, "bad:1"},
+		{"foo", 1, "bad:2"},
+		{"foo", 2, "good"},
+		{"foo", 4, "ba"},
+		{"bar", 1, "bad:3"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := c.(cluster.PrefixDeleter).DeletePrefix("foo", "bad:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []common.KeyScoreMember{
+		{"foo", 1, "bad:2"},
+		{"foo", 3, "bad:1"},
+	}, deleted; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	m, err := c.Score([]common.KeyMember{{"foo", "bad:1"}, {"foo", "good"}, {"foo", "ba"}, {"bar", "bad:3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for keyMember, expected := range map[common.KeyMember]cluster.Presence{
+		{"foo", "bad:1"}: {Present: true, Inserted: false, Score: 3},
+		{"foo", "good"}:  {Present: true, Inserted: true, Score: 2},
+		{"foo", "ba"}:    {Present: true, Inserted: true, Score: 4},
+		{"bar", "bad:3"}: {Present: true, Inserted: true, Score: 1},
+	} {
+		if got := m[keyMember]; expected != got {
+			t.Errorf("%v: expected %+v, got %+v", keyMember, expected, got)
+		}
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{"foo", 5, "a"},
+		{"foo", 8, "b"},
+		{"foo", 5, "c"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := c.(cluster.GuardedDeleter).DeleteIf([]cluster.GuardedDelete{
+		{common.KeyScoreMember{"foo", 10, "a"}, 5}, // guard holds
+		{common.KeyScoreMember{"foo", 10, "b"}, 5}, // re-inserted since
+		{common.KeyScoreMember{"foo", 4, "c"}, 5},  // loses to the insert
+		{common.KeyScoreMember{"foo", 10, "d"}, 5}, // never inserted
+		{common.KeyScoreMember{"bar", 10, "a"}, 5}, // other key
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{true, false, false, false, false}, applied; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	m, err := c.Score([]common.KeyMember{{"foo", "a"}, {"foo", "b"}, {"foo", "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for keyMember, expected := range map[common.KeyMember]cluster.Presence{
+		{"foo", "a"}: {Present: true, Inserted: false, Score: 10},
+		{"foo", "b"}: {Present: true, Inserted: true, Score: 8},
+		{"foo", "c"}: {Present: true, Inserted: true, Score: 5},
+	} {
+		if got := m[keyMember]; expected != got {
+			t.Errorf("%v: expected %+v, got %+v", keyMember, expected, got)
+		}
+	}
+}
+
+func TestTouch(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{"foo", 5, "a"},
+		{"foo", 8, "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{{"foo", 5, "c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := c.(cluster.Toucher).Touch([]common.KeyScoreMember{
+		{"foo", 10, "a"}, // moved
+		{"foo", 7, "b"},  // already newer
+		{"foo", 10, "c"}, // deleted
+		{"foo", 10, "d"}, // never inserted
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{true, false, false, false}, applied; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	m, err := c.Score([]common.KeyMember{{"foo", "a"}, {"foo", "b"}, {"foo", "c"}, {"foo", "d"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for keyMember, expected := range map[common.KeyMember]cluster.Presence{
+		{"foo", "a"}: {Present: true, Inserted: true, Score: 10},
+		{"foo", "b"}: {Present: true, Inserted: true, Score: 8},
+		{"foo", "c"}: {Present: true, Inserted: false, Score: 5},
+		{"foo", "d"}: {Present: false},
+	} {
+		if got := m[keyMember]; expected != got {
+			t.Errorf("%v: expected %+v, got %+v", keyMember, expected, got)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	addresses := []string{"127.0.0.1:6379", "127.0.0.1:6380", "127.0.0.1:6381"}
+	p := pool.New(addresses, time.Second, time.Second, time.Second, 1, pool.Murmur3)
+	c := cluster.New(p, 1000, 0, nil)
+
+	for _, key := range []string{"foo", "bar", "baz", ""} {
+		location, err := cluster.Locate(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := p.Index(key), location.Index; expected != got {
+			t.Errorf("%q: expected index %d, got %d", key, expected, got)
+		}
+		if expected, got := addresses[location.Index], location.Address; expected != got {
+			t.Errorf("%q: expected address %q, got %q", key, expected, got)
+		}
+	}
+
+	if _, err := cluster.Locate(&clustertest.Fake{}, "foo"); err == nil {
+		t.Errorf("expected an error locating a key in a fake cluster")
+	}
+}
+
+func TestConvergenceTracker(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000).(cluster.ConvergenceTracker)
+	if got, err := c.Converged(); err != nil || !got.IsZero() {
+		t.Fatalf("expected no convergence time, got %s (%v)", got, err)
+	}
+
+	now := time.Now()
+	for _, tm := range []time.Time{now, now.Add(-time.Hour)} { // never moves backward
+		if err := c.MarkConverged(tm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := c.Converged(); err != nil || !got.Equal(now) {
+		t.Errorf("expected %s, got %s (%v)", now, got, err)
+	}
+
+	// The convergence key isn't scanned.
+	for batch := range c.(cluster.Scanner).Keys(10) {
+		t.Errorf("expected no keys, got %v", batch)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{"foo", 1, "a"},
+		{"foo", 2, "b"},
+		{"foo", 3, "c"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{
+		{"foo", 4, "b"},
+		{"foo", 5, "d"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := cluster.Inspect(c, "foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Err != nil {
+		t.Fatal(state.Err)
+	}
+	if expected, got := 2, state.InsertCount; expected != got {
+		t.Errorf("expected %d inserts, got %d", expected, got)
+	}
+	if expected, got := 2, state.DeleteCount; expected != got {
+		t.Errorf("expected %d deletes, got %d", expected, got)
+	}
+	if expected, got := []common.KeyScoreMember{{"foo", 3, "c"}}, state.Inserts; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected inserts %v, got %v", expected, got)
+	}
+	if expected, got := []common.KeyScoreMember{{"foo", 5, "d"}}, state.Deletes; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected deletes %v, got %v", expected, got)
+	}
+
+	if _, err := cluster.Inspect(c, "foo", 0); err == nil {
+		t.Errorf("expected error for limit 0")
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{"foo", 1, "a"},
+		{"foo", 2, "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := cluster.MemoryUsage(c, []string{"foo", "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 2, len(usage); expected != got {
+		t.Fatalf("expected %d key(s), got %d", expected, got)
+	}
+	for _, u := range usage {
+		if u.Err != nil {
+			t.Fatalf("%s: %s", u.Key, u.Err)
+		}
+	}
+	if usage[0].InsertBytes <= 0 || usage[0].DeleteBytes != 0 {
+		t.Errorf("foo: expected only insert bytes, got %+v", usage[0])
+	}
+	if usage[1].Bytes() != 0 {
+		t.Errorf("bar: expected no bytes, got %+v", usage[1])
+	}
+}
+
+func TestDigest(t *testing.T) {
+	a := []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}, {Key: "foo", Score: 1.5, Member: "a"}}
+	if cluster.Digest(a) != cluster.Digest(append([]common.KeyScoreMember{}, a...)) {
+		t.Errorf("expected equal pages to have equal digests")
+	}
+	for _, b := range [][]common.KeyScoreMember{
+		{},
+		a[:1],
+		{a[1], a[0]},
+		{a[0], {Key: "foo", Score: 1.5000001, Member: "a"}},
+		{a[0], {Key: "foo", Score: 1.5, Member: "a1"}},
+	} {
+		if cluster.Digest(a) == cluster.Digest(b) {
+			t.Errorf("%v: expected a different digest than %v", b, a)
+		}
+	}
+}
+
+func TestDigestOffset(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+
+	c := integrationCluster(t, addresses, 1000)
+	if err := c.Insert([]common.KeyScoreMember{
+		{"foo", 1, "a"},
+		{"foo", 2.5, "b"},
+		{"foo", 1e15, "c"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ascending := range []bool{false, true} {
+		selectFn := c.SelectOffset
+		if ascending {
+			selectFn = c.SelectOffsetAscending
+		}
+		pages := map[string][]common.KeyScoreMember{}
+		for e := range selectFn([]string{"foo", "bar"}, 1, 2) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			pages[e.Key] = e.KeyScoreMembers
+		}
+		for e := range c.(cluster.Digester).DigestOffset([]string{"foo", "bar"}, 1, 2, ascending) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			if expected := cluster.Digest(pages[e.Key]); expected != e.Digest {
+				t.Errorf("ascending %v: %s: expected digest %s, got %s", ascending, e.Key, expected, e.Digest)
+			}
+		}
+	}
+}
+
+func TestParseDialect(t *testing.T) {
+	for name, expected := range map[string]cluster.Dialect{
+		"":          cluster.DialectRedis,
+		"redis":     cluster.DialectRedis,
+		"KeyDB":     cluster.DialectKeyDB,
+		"dragonfly": cluster.DialectDragonfly,
+	} {
+		d, err := cluster.ParseDialect(name)
+		if err != nil {
+			t.Errorf("%q: %s", name, err)
+			continue
+		}
+		if d != expected {
+			t.Errorf("%q: expected %s, got %s", name, expected, d)
+		}
+	}
+	if _, err := cluster.ParseDialect("memcached"); err == nil {
+		t.Errorf("expected error, got none")
+	}
+}
+
+func TestParseMaxSizeOverrides(t *testing.T) {
+	overrides, err := cluster.ParseMaxSizeOverrides("vip:=10000, a=b=5,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]int{"vip:": 10000, "a=b": 5}; !reflect.DeepEqual(expected, overrides) {
+		t.Errorf("expected %v, got %v", expected, overrides)
+	}
+	for _, s := range []string{"vip:", "=5", "vip:=0", "vip:=many", "a=1,a=2"} {
+		if _, err := cluster.ParseMaxSizeOverrides(s); err == nil {
+			t.Errorf("%q: expected error, got none", s)
+		}
+	}
+}
+
+// TestDialect runs the scripts, pipelines, and scans against the instances
+// of TEST_REDIS_ADDRESSES as the dialect of TEST_REDIS_DIALECT, e.g. to
+// qualify KeyDB or Dragonfly as replacements of Redis.
+func TestDialect(t *testing.T) {
+	addresses := os.Getenv("TEST_REDIS_ADDRESSES")
+	if addresses == "" {
+		t.Logf("To run this test, set the TEST_REDIS_ADDRESSES environment variable")
+		return
+	}
+	d, err := cluster.ParseDialect(os.Getenv("TEST_REDIS_DIALECT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := integrationCluster(t, addresses, 1000, cluster.Compatibility(d), cluster.PipelineSize(7))
+	checks, err := cluster.Check(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range checks {
+		if check.Err != nil {
+			t.Fatalf("%s: %s: %s", d, check.Address, check.Err)
+		}
+	}
+
+	// Enough keys that the scan takes several round trips, written in
+	// several pipelines.
+	var (
+		tuples   = []common.KeyScoreMember{}
+		expected = []string{}
+	)
+	for i := 0; i < 250; i++ {
+		key := fmt.Sprintf("timeline:%03d", i)
+		tuples = append(tuples,
+			common.KeyScoreMember{Key: key, Score: 1, Member: "a"},
+			common.KeyScoreMember{Key: key, Score: 2, Member: "b"},
+		)
+		expected = append(expected, key)
+	}
+	if err := c.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete([]common.KeyScoreMember{{Key: "timeline:000", Score: 3, Member: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for e := range c.SelectRange([]string{"timeline:000", "timeline:001"}, common.Cursor{Score: math.MaxFloat64}, common.Cursor{Score: 0}, 10) {
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		want := []common.KeyScoreMember{{Key: e.Key, Score: 2, Member: "b"}, {Key: e.Key, Score: 1, Member: "a"}}
+		if e.Key == "timeline:000" {
+			want = want[:1]
+		}
+		if !reflect.DeepEqual(want, e.KeyScoreMembers) {
+			t.Errorf("%s: %s: expected %v, got %v", d, e.Key, want, e.KeyScoreMembers)
+		}
+	}
+
+	// Scans may return a key more than once.
+	seen := map[string]bool{}
+	keys := []string{}
+	for batch := range c.(cluster.PatternScanner).KeysMatching("timeline:*", 10) {
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(expected, keys) {
+		t.Errorf("%s: expected %d key(s), got %d", d, len(expected), len(keys))
+	}
 }
@@ -0,0 +1,567 @@
+// Package clustertest provides a scriptable, in-memory cluster.Cluster, for
+// testing code built on top of clusters, like farms and their read
+// strategies, deterministically and without Redis.
+package clustertest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// Method identifies a method of the cluster.Cluster interface.
+type Method string
+
+// Methods of the cluster.Cluster interface, which may be scripted.
+const (
+	Insert                Method = "Insert"
+	SelectOffset          Method = "SelectOffset"
+	SelectOffsetAscending Method = "SelectOffsetAscending"
+	SelectRange           Method = "SelectRange"
+	SelectStride          Method = "SelectStride"
+	DigestOffset          Method = "DigestOffset"
+	Delete                Method = "Delete"
+	Score                 Method = "Score"
+	Keys                  Method = "Keys"
+	DeletePrefix          Method = "DeletePrefix"
+	DeleteIf              Method = "DeleteIf"
+	Touch                 Method = "Touch"
+	MarkConverged         Method = "MarkConverged"
+	Converged             Method = "Converged"
+	Count                 Method = "Count"
+	SampleKeys            Method = "SampleKeys"
+)
+
+// Call records a single invocation of a method of a Fake. Only the fields
+// relevant to the method are set.
+type Call struct {
+	Method     Method
+	Keys       []string                // Selects, DigestOffset, Count
+	Tuples     []common.KeyScoreMember // Insert, Delete
+	KeyMembers []common.KeyMember      // Score
+	Prefix     string                  // DeletePrefix, with the key in Keys
+}
+
+// Fake implements cluster.Cluster in memory, with the same CRDT semantics as
+// a real cluster: a write only takes effect if its score is newer than the
+// scores already stored for the key-member, in either the insert or the
+// delete set, except that an insert may be repeated with the same score, and
+// a delete wins over an insert with the same score. The max size of keys
+// isn't enforced.
+//
+// Each method may be scripted to fail or to be delayed, and Selects may be
+// scripted to return fixed responses for individual keys, regardless of what
+// was written. Every call is recorded. Fake is safe for concurrent use.
+type Fake struct {
+	mu        sync.Mutex
+	inserts   map[string]map[string]float64 // key: member: score
+	deletes   map[string]map[string]float64 // key: member: score
+	errs      map[Method]error
+	delays    map[Method]time.Duration
+	responses map[string][]common.KeyScoreMember
+	keyErrs   map[string]error
+	calls     []Call
+	converged time.Time
+}
+
+// New returns an empty Fake.
+func New() *Fake {
+	return &Fake{
+		inserts:   map[string]map[string]float64{},
+		deletes:   map[string]map[string]float64{},
+		errs:      map[Method]error{},
+		delays:    map[Method]time.Duration{},
+		responses: map[string][]common.KeyScoreMember{},
+		keyErrs:   map[string]error{},
+	}
+}
+
+// FailWith causes every subsequent call to the method to fail with err.
+// Selects fail every requested key. Scans return no keys. A nil err makes
+// the method succeed again.
+func (f *Fake) FailWith(m Method, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, m)
+		return
+	}
+	f.errs[m] = err
+}
+
+// Delay causes every subsequent call to the method to take at least d. For
+// Selects and scans, the call returns immediately, and the delay applies to
+// the results on the channel, as with a real cluster.
+func (f *Fake) Delay(m Method, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delays[m] = d
+}
+
+// Respond causes every subsequent Select of the key to return the given
+// tuples, subject to offset, limit, and cursors, regardless of what was
+// written. Give the tuples in descending order, as a real cluster would
+// store them; they're not sorted. A nil slice restores normal behavior for
+// the key.
+func (f *Fake) Respond(key string, tuples []common.KeyScoreMember) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if tuples == nil {
+		delete(f.responses, key)
+		return
+	}
+	f.responses[key] = tuples
+}
+
+// FailKey causes every subsequent Select of the key to return an element
+// with err, while other keys in the same Select succeed. A nil err makes the
+// key succeed again.
+func (f *Fake) FailKey(key string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.keyErrs, key)
+		return
+	}
+	f.keyErrs[key] = err
+}
+
+// Calls returns every call made to the Fake so far, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := make([]Call, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
+
+// CallCount returns how many times the method has been called.
+func (f *Fake) CallCount(m Method) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, call := range f.calls {
+		if call.Method == m {
+			n++
+		}
+	}
+	return n
+}
+
+// record records the call, and returns the scripted delay and error for its
+// method. Callers must not hold the lock.
+func (f *Fake) record(call Call) (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+	return f.delays[call.Method], f.errs[call.Method]
+}
+
+// Insert implements cluster.Inserter.
+func (f *Fake) Insert(tuples []common.KeyScoreMember) error {
+	return f.write(Insert, tuples, f.inserts, f.deletes)
+}
+
+// Delete implements cluster.Deleter.
+func (f *Fake) Delete(tuples []common.KeyScoreMember) error {
+	return f.write(Delete, tuples, f.deletes, f.inserts)
+}
+
+func (f *Fake) write(m Method, tuples []common.KeyScoreMember, add, rem map[string]map[string]float64) error {
+	delay, err := f.record(Call{Method: m, Tuples: tuples})
+	time.Sleep(delay)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, tuple := range tuples {
+		// As in the scripts of a real cluster, a write may not be older than
+		// the insert, nor as old as the delete, of the key-member. So with
+		// equal scores, a delete wins, whichever is written first.
+		if score, ok := f.inserts[tuple.Key][tuple.Member]; ok && tuple.Score < score {
+			continue
+		}
+		if score, ok := f.deletes[tuple.Key][tuple.Member]; ok && tuple.Score <= score {
+			continue
+		}
+		delete(rem[tuple.Key], tuple.Member)
+		if _, ok := add[tuple.Key]; !ok {
+			add[tuple.Key] = map[string]float64{}
+		}
+		add[tuple.Key][tuple.Member] = tuple.Score
+	}
+	return nil
+}
+
+// DeletePrefix implements cluster.PrefixDeleter.
+func (f *Fake) DeletePrefix(key, prefix string) ([]common.KeyScoreMember, error) {
+	delay, err := f.record(Call{Method: DeletePrefix, Keys: []string{key}, Prefix: prefix})
+	time.Sleep(delay)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deleted := []common.KeyScoreMember{}
+	for member, score := range f.inserts[key] {
+		if !strings.HasPrefix(member, prefix) {
+			continue
+		}
+		delete(f.inserts[key], member)
+		if _, ok := f.deletes[key]; !ok {
+			f.deletes[key] = map[string]float64{}
+		}
+		f.deletes[key][member] = score
+		deleted = append(deleted, common.KeyScoreMember{Key: key, Score: score, Member: member})
+	}
+	sort.Sort(sort.Reverse(descending(deleted)))
+	return deleted, nil
+}
+
+// DeleteIf implements cluster.GuardedDeleter.
+func (f *Fake) DeleteIf(deletes []cluster.GuardedDelete) ([]bool, error) {
+	tuples := make([]common.KeyScoreMember, len(deletes))
+	for i, d := range deletes {
+		tuples[i] = d.KeyScoreMember
+	}
+	delay, err := f.record(Call{Method: DeleteIf, Tuples: tuples})
+	time.Sleep(delay)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	applied := make([]bool, len(deletes))
+	for i, d := range deletes {
+		score, ok := f.inserts[d.Key][d.Member]
+		if !ok || score != d.Expected || d.Score < score {
+			continue
+		}
+		delete(f.inserts[d.Key], d.Member)
+		if _, ok := f.deletes[d.Key]; !ok {
+			f.deletes[d.Key] = map[string]float64{}
+		}
+		f.deletes[d.Key][d.Member] = d.Score
+		applied[i] = true
+	}
+	return applied, nil
+}
+
+// Touch implements cluster.Toucher.
+func (f *Fake) Touch(tuples []common.KeyScoreMember) ([]bool, error) {
+	delay, err := f.record(Call{Method: Touch, Tuples: tuples})
+	time.Sleep(delay)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	applied := make([]bool, len(tuples))
+	for i, tuple := range tuples {
+		score, ok := f.inserts[tuple.Key][tuple.Member]
+		if !ok || tuple.Score <= score {
+			continue
+		}
+		f.inserts[tuple.Key][tuple.Member] = tuple.Score
+		applied[i] = true
+	}
+	return applied, nil
+}
+
+// SelectOffset implements cluster.Selecter.
+func (f *Fake) SelectOffset(keys []string, offset, limit int) <-chan cluster.Element {
+	return f.selectKeys(SelectOffset, keys, func(a []common.KeyScoreMember) []common.KeyScoreMember {
+		return page(a, offset, limit)
+	})
+}
+
+// SelectOffsetAscending implements cluster.Selecter.
+func (f *Fake) SelectOffsetAscending(keys []string, offset, limit int) <-chan cluster.Element {
+	return f.selectKeys(SelectOffsetAscending, keys, ascendingPage(offset, limit))
+}
+
+// ascendingPage returns the window function of SelectOffsetAscending.
+func ascendingPage(offset, limit int) func([]common.KeyScoreMember) []common.KeyScoreMember {
+	return func(a []common.KeyScoreMember) []common.KeyScoreMember {
+		for i, j := 0, len(a)-1; i < j; i, j = i+1, j-1 {
+			a[i], a[j] = a[j], a[i]
+		}
+		return page(a, offset, limit)
+	}
+}
+
+// SelectRange implements cluster.Selecter.
+func (f *Fake) SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan cluster.Element {
+	return f.selectKeys(SelectRange, keys, func(a []common.KeyScoreMember) []common.KeyScoreMember {
+		var (
+			after = func(x common.KeyScoreMember) bool {
+				return x.Score < start.Score || (x.Score == start.Score && x.Member < start.Member)
+			}
+			before = func(x common.KeyScoreMember) bool {
+				return x.Score > stop.Score || (x.Score == stop.Score && x.Member > stop.Member)
+			}
+			result = []common.KeyScoreMember{}
+		)
+		for _, x := range a {
+			if after(x) && before(x) {
+				result = append(result, x)
+			}
+		}
+		return page(result, 0, limit)
+	})
+}
+
+// SelectStride implements cluster.Sampler.
+func (f *Fake) SelectStride(keys []string, offset, stride, limit int) <-chan cluster.Element {
+	return f.selectKeys(SelectStride, keys, func(a []common.KeyScoreMember) []common.KeyScoreMember {
+		result := []common.KeyScoreMember{}
+		for i := offset; stride > 0 && i < len(a) && len(result) < limit; i += stride {
+			result = append(result, a[i])
+		}
+		return result
+	})
+}
+
+// DigestOffset implements cluster.Digester, with the cluster.Digest of what
+// SelectOffset, or SelectOffsetAscending, would return, including scripted
+// responses and errors.
+func (f *Fake) DigestOffset(keys []string, offset, limit int, ascending bool) <-chan cluster.DigestElement {
+	window := func(a []common.KeyScoreMember) []common.KeyScoreMember { return page(a, offset, limit) }
+	if ascending {
+		window = ascendingPage(offset, limit)
+	}
+	delay, elements := f.snapshot(DigestOffset, keys, window)
+	ch := make(chan cluster.DigestElement)
+	go func() {
+		defer close(ch)
+		time.Sleep(delay)
+		for _, e := range elements {
+			ch <- cluster.DigestElement{Key: e.Key, Digest: cluster.Digest(e.KeyScoreMembers), Error: e.Error}
+		}
+	}()
+	return ch
+}
+
+// selectKeys emits one element per key. Tuples are passed to the window
+// function in descending order, like ZREVRANGE.
+func (f *Fake) selectKeys(m Method, keys []string, window func([]common.KeyScoreMember) []common.KeyScoreMember) <-chan cluster.Element {
+	delay, elements := f.snapshot(m, keys, window)
+	ch := make(chan cluster.Element)
+	go func() {
+		defer close(ch)
+		time.Sleep(delay)
+		for _, e := range elements {
+			ch <- e
+		}
+	}()
+	return ch
+}
+
+// snapshot records the call, and returns its delay, and the element of each
+// key.
+func (f *Fake) snapshot(m Method, keys []string, window func([]common.KeyScoreMember) []common.KeyScoreMember) (time.Duration, []cluster.Element) {
+	delay, err := f.record(Call{Method: m, Keys: keys})
+
+	// Snapshot the results now, so that writes made after the call don't
+	// leak into them.
+	elements := make([]cluster.Element, len(keys))
+	f.mu.Lock()
+	for i, key := range keys {
+		switch keyErr, scripted := f.keyErrs[key], f.responses[key]; {
+		case err != nil:
+			elements[i] = cluster.Element{Key: key, KeyScoreMembers: []common.KeyScoreMember{}, Error: err}
+		case keyErr != nil:
+			elements[i] = cluster.Element{Key: key, KeyScoreMembers: []common.KeyScoreMember{}, Error: keyErr}
+		case scripted != nil:
+			a := make([]common.KeyScoreMember, len(scripted))
+			copy(a, scripted)
+			elements[i] = cluster.Element{Key: key, KeyScoreMembers: window(a)}
+		default:
+			elements[i] = cluster.Element{Key: key, KeyScoreMembers: window(f.sorted(key))}
+		}
+	}
+	f.mu.Unlock()
+	return delay, elements
+}
+
+// sorted returns the inserted tuples of the key, in descending order of
+// score, and members with equal scores in descending bytewise order, like
+// ZREVRANGE. Callers must hold the lock.
+func (f *Fake) sorted(key string) []common.KeyScoreMember {
+	a := make([]common.KeyScoreMember, 0, len(f.inserts[key]))
+	for member, score := range f.inserts[key] {
+		a = append(a, common.KeyScoreMember{Key: key, Score: score, Member: member})
+	}
+	sort.Sort(descending(a))
+	return a
+}
+
+type descending []common.KeyScoreMember
+
+func (a descending) Len() int           { return len(a) }
+func (a descending) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a descending) Less(i, j int) bool { return a[i].Before(a[j]) }
+
+func page(a []common.KeyScoreMember, offset, limit int) []common.KeyScoreMember {
+	if offset >= len(a) {
+		return []common.KeyScoreMember{}
+	}
+	a = a[offset:]
+	if limit >= 0 && limit < len(a) {
+		a = a[:limit]
+	}
+	return a
+}
+
+// Score implements cluster.Scorer.
+func (f *Fake) Score(keyMembers []common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
+	delay, err := f.record(Call{Method: Score, KeyMembers: keyMembers})
+	time.Sleep(delay)
+	if err != nil {
+		return map[common.KeyMember]cluster.Presence{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := map[common.KeyMember]cluster.Presence{}
+	for _, keyMember := range keyMembers {
+		if score, ok := f.inserts[keyMember.Key][keyMember.Member]; ok {
+			m[keyMember] = cluster.Presence{Present: true, Inserted: true, Score: score}
+		} else if score, ok := f.deletes[keyMember.Key][keyMember.Member]; ok {
+			m[keyMember] = cluster.Presence{Present: true, Inserted: false, Score: score}
+		} else {
+			m[keyMember] = cluster.Presence{Present: false}
+		}
+	}
+	return m, nil
+}
+
+// Count implements cluster.Counter. Scripted Select responses aren't
+// counted; the count is of what was written.
+func (f *Fake) Count(keys []string) (map[string]int, error) {
+	delay, err := f.record(Call{Method: Count, Keys: keys})
+	time.Sleep(delay)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		counts[key] = len(f.inserts[key])
+	}
+	return counts, nil
+}
+
+// SampleKeys implements cluster.KeyspaceSampler. Its estimates are exact,
+// and the sample is of the keys with inserts, in no particular order.
+func (f *Fake) SampleKeys(n int) (cluster.KeyspaceSample, error) {
+	delay, err := f.record(Call{Method: SampleKeys})
+	time.Sleep(delay)
+	if err != nil {
+		return cluster.KeyspaceSample{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sample := cluster.KeyspaceSample{Keys: []string{}}
+	for key, members := range f.inserts {
+		if len(members) <= 0 {
+			continue
+		}
+		sample.Estimate++
+		sample.RedisKeys++
+		if len(sample.Keys) < n {
+			sample.Keys = append(sample.Keys, key)
+		}
+	}
+	for _, members := range f.deletes {
+		if len(members) > 0 {
+			sample.RedisKeys++
+		}
+	}
+	return sample, nil
+}
+
+// Keys implements cluster.Scanner. Like the clusters returned by
+// cluster.New, which only scan the insert sets, keys with only deletes are
+// left out. The order of keys is unspecified.
+func (f *Fake) Keys(batchSize int) <-chan []string {
+	delay, err := f.record(Call{Method: Keys})
+
+	keys := []string{}
+	if err == nil {
+		f.mu.Lock()
+		for key, members := range f.inserts {
+			if len(members) > 0 {
+				keys = append(keys, key)
+			}
+		}
+		f.mu.Unlock()
+	}
+
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		time.Sleep(delay)
+		for len(keys) > 0 {
+			n := batchSize
+			if n < 1 || n > len(keys) {
+				n = len(keys)
+			}
+			ch <- keys[:n]
+			keys = keys[n:]
+		}
+	}()
+	return ch
+}
+
+// MarkConverged implements cluster.ConvergenceTracker. Like a real cluster,
+// it never moves the convergence time backward.
+func (f *Fake) MarkConverged(t time.Time) error {
+	delay, err := f.record(Call{Method: MarkConverged})
+	time.Sleep(delay)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t.After(f.converged) {
+		f.converged = t
+	}
+	return nil
+}
+
+// Converged implements cluster.ConvergenceTracker.
+func (f *Fake) Converged() (time.Time, error) {
+	delay, err := f.record(Call{Method: Converged})
+	time.Sleep(delay)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.converged, nil
+}
+
+var (
+	_ cluster.Cluster            = &Fake{}
+	_ cluster.PrefixDeleter      = &Fake{}
+	_ cluster.Sampler            = &Fake{}
+	_ cluster.Digester           = &Fake{}
+	_ cluster.ConvergenceTracker = &Fake{}
+	_ cluster.Counter            = &Fake{}
+	_ cluster.KeyspaceSampler    = &Fake{}
+)
@@ -0,0 +1,154 @@
+package clustertest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestFakeSemantics(t *testing.T) {
+	f := New()
+	if err := f.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 3, Member: "c"},
+		{Key: "foo", Score: 5, Member: "d"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Delete([]common.KeyScoreMember{
+		{Key: "foo", Score: 4, Member: "b"}, // newer, applied
+		{Key: "foo", Score: 2, Member: "c"}, // older, ignored
+		{Key: "foo", Score: 5, Member: "d"}, // same score, applied over the insert
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 4, Member: "b"}, // same score as delete, ignored
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testCase := range []struct {
+		ch       <-chan cluster.Element
+		expected []common.KeyScoreMember
+	}{
+		{
+			ch: f.SelectOffset([]string{"foo"}, 0, 10),
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 3, Member: "c"},
+				{Key: "foo", Score: 1, Member: "a"},
+			},
+		},
+		{
+			ch: f.SelectOffsetAscending([]string{"foo"}, 1, 10),
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 3, Member: "c"},
+			},
+		},
+		{
+			ch: f.SelectRange([]string{"foo"}, common.Cursor{Score: 3, Member: "c"}, common.Cursor{Score: 0}, 10),
+			expected: []common.KeyScoreMember{
+				{Key: "foo", Score: 1, Member: "a"},
+			},
+		},
+	} {
+		e := <-testCase.ch
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		if expected, got := testCase.expected, e.KeyScoreMembers; !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	}
+
+	m, err := f.Score([]common.KeyMember{{Key: "foo", Member: "b"}, {Key: "bar", Member: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := (cluster.Presence{Present: true, Inserted: false, Score: 4}), m[common.KeyMember{Key: "foo", Member: "b"}]; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if expected, got := (cluster.Presence{}), m[common.KeyMember{Key: "bar", Member: "a"}]; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestFakeScripting(t *testing.T) {
+	var (
+		f        = New()
+		scripted = []common.KeyScoreMember{{Key: "foo", Score: 9, Member: "z"}}
+		errFoo   = errors.New("foo failed")
+		errAll   = errors.New("everything failed")
+	)
+	f.Respond("foo", scripted)
+	f.FailKey("bar", errFoo)
+	f.Delay(SelectOffset, 10*time.Millisecond)
+
+	began := time.Now()
+	got := map[string]cluster.Element{}
+	for e := range f.SelectOffset([]string{"foo", "bar"}, 0, 10) {
+		got[e.Key] = e
+	}
+	if took := time.Since(began); took < 10*time.Millisecond {
+		t.Errorf("Select took %s, expected a delay", took)
+	}
+	if expected, got := scripted, got["foo"].KeyScoreMembers; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if expected, got := errFoo, got["bar"].Error; expected != got {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	f.FailWith(Insert, errAll)
+	if expected, got := errAll, f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}); expected != got {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	f.FailWith(Insert, nil)
+	if err := f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}); err != nil {
+		t.Error(err)
+	}
+
+	if expected, got := []Method{SelectOffset, Insert, Insert}, methods(f.Calls()); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if expected, got := 2, f.CallCount(Insert); expected != got {
+		t.Errorf("expected %d, got %d", expected, got)
+	}
+}
+
+func methods(calls []Call) []Method {
+	a := make([]Method, len(calls))
+	for i, call := range calls {
+		a[i] = call.Method
+	}
+	return a
+}
+
+func TestFakeKeys(t *testing.T) {
+	f := New()
+	if err := f.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "bar", Score: 1, Member: "a"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Delete([]common.KeyScoreMember{
+		{Key: "bar", Score: 2, Member: "a"}, // bar is left with only deletes
+		{Key: "baz", Score: 2, Member: "a"}, // baz has only deletes
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	for batch := range f.Keys(1) {
+		keys = append(keys, batch...)
+	}
+	if expected, got := []string{"foo"}, keys; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
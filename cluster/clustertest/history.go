@@ -0,0 +1,195 @@
+package clustertest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// Op is a single write of a tuple, either an Insert or a Delete.
+type Op struct {
+	Method Method
+	Tuple  common.KeyScoreMember
+}
+
+// History is a sequence of writes, for property tests of the CRDT semantics
+// of clusters and farms. Any order of the same writes, with any repetitions,
+// and in any batches, must converge to the same state: the state returned by
+// Expected.
+type History []Op
+
+// RandomHistory returns a history of n writes to the given number of keys
+// and members per key. Scores are small integers, so that writes of the same
+// key-member with equal scores are frequent, including an insert and a
+// delete with the same score, of which the delete wins.
+func RandomHistory(r *rand.Rand, n, keys, members int) History {
+	h := make(History, n)
+	for i := range h {
+		var (
+			method = Insert
+			score  = r.Intn(n/2 + 1)
+		)
+		if r.Intn(3) == 0 {
+			method = Delete
+		}
+		h[i] = Op{
+			Method: method,
+			Tuple: common.KeyScoreMember{
+				Key:    fmt.Sprintf("key%d", r.Intn(keys)),
+				Score:  float64(score),
+				Member: fmt.Sprintf("member%d", r.Intn(members)),
+			},
+		}
+	}
+	return h
+}
+
+// Permuted returns the writes of the history in random order.
+func (h History) Permuted(r *rand.Rand) History {
+	p := make(History, len(h))
+	for i, j := range r.Perm(len(h)) {
+		p[i] = h[j]
+	}
+	return p
+}
+
+// Repeated returns the history with random writes repeated, at random
+// positions, as with retries.
+func (h History) Repeated(r *rand.Rand) History {
+	p := append(History{}, h...)
+	for _, op := range h {
+		if r.Intn(2) == 0 {
+			i := r.Intn(len(p) + 1)
+			p = append(p[:i], append(History{op}, p[i:]...)...)
+		}
+	}
+	return p
+}
+
+// Only returns the writes of the history with the method.
+func (h History) Only(m Method) History {
+	p := History{}
+	for _, op := range h {
+		if op.Method == m {
+			p = append(p, op)
+		}
+	}
+	return p
+}
+
+// Scatter distributes the writes of the history over n histories, as seen
+// by n clusters after partial failures. Each write is in at least one of
+// them, so together they still converge to the state of the history.
+func (h History) Scatter(r *rand.Rand, n int) []History {
+	scattered := make([]History, n)
+	for _, op := range h {
+		first := r.Intn(n)
+		for i := range scattered {
+			if i == first || r.Intn(2) == 0 {
+				scattered[i] = append(scattered[i], op)
+			}
+		}
+	}
+	return scattered
+}
+
+// Apply writes the history to the cluster. Consecutive writes with the same
+// method are batched in a single call.
+func (h History) Apply(c interface {
+	cluster.Inserter
+	cluster.Deleter
+}) error {
+	for len(h) > 0 {
+		n := 1
+		for n < len(h) && h[n].Method == h[0].Method {
+			n++
+		}
+		tuples := make([]common.KeyScoreMember, n)
+		for i, op := range h[:n] {
+			tuples[i] = op.Tuple
+		}
+		var err error
+		switch h[0].Method {
+		case Insert:
+			err = c.Insert(tuples)
+		case Delete:
+			err = c.Delete(tuples)
+		default:
+			err = fmt.Errorf("invalid method %q in history", h[0].Method)
+		}
+		if err != nil {
+			return err
+		}
+		h = h[n:]
+	}
+	return nil
+}
+
+// Expected returns the state the history converges to, per key, in the order
+// of SelectOffset, as specified by the last-writer-wins semantics: each
+// key-member takes its highest score in the history, and it's deleted if a
+// delete has that score. Keys without inserted members are omitted.
+func (h History) Expected() map[string][]common.KeyScoreMember {
+	type state struct {
+		score   float64
+		deleted bool
+	}
+	winners := map[common.KeyMember]state{}
+	for _, op := range h {
+		km := common.KeyMember{Key: op.Tuple.Key, Member: op.Tuple.Member}
+		current, ok := winners[km]
+		switch {
+		case !ok || op.Tuple.Score > current.score:
+			winners[km] = state{op.Tuple.Score, op.Method == Delete}
+		case op.Tuple.Score == current.score && op.Method == Delete:
+			winners[km] = state{op.Tuple.Score, true}
+		}
+	}
+
+	expected := map[string][]common.KeyScoreMember{}
+	for km, winner := range winners {
+		if winner.deleted {
+			continue
+		}
+		expected[km.Key] = append(expected[km.Key], common.KeyScoreMember{Key: km.Key, Score: winner.score, Member: km.Member})
+	}
+	for _, tuples := range expected {
+		sort.Sort(descending(tuples))
+	}
+	return expected
+}
+
+// Keys returns the distinct keys written by the history, in sorted order.
+func (h History) Keys() []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, op := range h {
+		if !seen[op.Tuple.Key] {
+			seen[op.Tuple.Key] = true
+			keys = append(keys, op.Tuple.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// State returns the inserted tuples of the keys written by the history, as
+// read from the cluster, in the form of Expected, for comparison.
+func (h History) State(c cluster.Selecter) (map[string][]common.KeyScoreMember, error) {
+	var (
+		state = map[string][]common.KeyScoreMember{}
+		err   error
+	)
+	for e := range c.SelectOffset(h.Keys(), 0, len(h)+1) {
+		if e.Error != nil {
+			err = fmt.Errorf("%s: %s", e.Key, e.Error)
+		}
+		if len(e.KeyScoreMembers) > 0 {
+			state[e.Key] = e.KeyScoreMembers
+		}
+	}
+	return state, err
+}
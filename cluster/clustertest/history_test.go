@@ -0,0 +1,48 @@
+package clustertest
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestFakeConvergence(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		var (
+			r        = rand.New(rand.NewSource(seed))
+			h        = RandomHistory(r, 30, 3, 4)
+			expected = h.Expected()
+		)
+		for name, variant := range map[string]History{
+			"in order":  h,
+			"permuted":  h.Permuted(r),
+			"repeated":  h.Repeated(r),
+			"both":      h.Repeated(r).Permuted(r),
+			"reapplied": append(h.Permuted(r), h...),
+		} {
+			f := New()
+			if err := variant.Apply(f); err != nil {
+				t.Fatalf("seed %d, %s: %s", seed, name, err)
+			}
+			got, err := h.State(f)
+			if err != nil {
+				t.Fatalf("seed %d, %s: %s", seed, name, err)
+			}
+			if !reflect.DeepEqual(expected, got) {
+				t.Errorf("seed %d, %s: history %v: expected\n %v, got\n %v", seed, name, variant, expected, got)
+			}
+		}
+	}
+}
+
+func TestScatter(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	h := RandomHistory(r, 30, 3, 4)
+	union := History{}
+	for _, scattered := range h.Scatter(r, 3) {
+		union = append(union, scattered...)
+	}
+	if expected, got := h.Expected(), union.Expected(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected\n %v, got\n %v", expected, got)
+	}
+}
@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// convergedKey holds the time a Redis instance was last known to have
+// converged, in Unix nanoseconds. It has neither the insert nor the delete
+// suffix, so it never collides with the sets, and isn't scanned.
+const convergedKey = "roshi:converged"
+
+// markConvergedScript only ever moves the convergence time forward, so that
+// a walk which started earlier, but completed later, doesn't set it back.
+var markConvergedScript = newScript("mark-converged", 1, `
+	local current = redis.call('GET', KEYS[1])
+	if not current or tonumber(current) < tonumber(ARGV[1]) then
+		redis.call('SET', KEYS[1], ARGV[1])
+	end
+	return 1
+`)
+
+// ConvergenceTracker is implemented by Clusters which can record the time
+// they were last known to have converged with the other clusters of a farm,
+// i.e. when a walk which completed without skipping any key started.
+// Clusters returned by New implement ConvergenceTracker.
+type ConvergenceTracker interface {
+	MarkConverged(t time.Time) error
+	Converged() (time.Time, error)
+}
+
+// MarkConverged implements ConvergenceTracker. It records the time on every
+// instance, unless a later time is recorded already.
+func (c *cluster) MarkConverged(t time.Time) error {
+	for index := 0; index < c.pool.Size(); index++ {
+		if err := c.pool.WithIndex(index, func(conn redis.Conn) error {
+			_, err := markConvergedScript.Do(conn, convergedKey, t.UnixNano())
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Converged implements ConvergenceTracker. It returns the earliest time
+// recorded by any instance, or the zero time if any instance has none.
+func (c *cluster) Converged() (time.Time, error) {
+	var earliest time.Time
+	for index := 0; index < c.pool.Size(); index++ {
+		var t time.Time
+		if err := c.pool.WithIndex(index, func(conn redis.Conn) error {
+			nanos, err := redis.Int64(conn.Do("GET", convergedKey))
+			if err == redis.ErrNil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			t = time.Unix(0, nanos)
+			return nil
+		}); err != nil {
+			return time.Time{}, err
+		}
+		if t.IsZero() {
+			return time.Time{}, nil
+		}
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest, nil
+}
@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/pool"
+)
+
+// Counter is implemented by Clusters which can count the members of keys,
+// i.e. the size of their inserts sets, without reading them. Deleted
+// members aren't counted. Clusters returned by New implement Counter.
+type Counter interface {
+	Count(keys []string) (map[string]int, error)
+}
+
+// Count implements Counter. Every key is counted, with 0 for keys which
+// don't exist. Instances are read concurrently, in a single round trip
+// each; if any fails, the first error is returned.
+func (c *cluster) Count(keys []string) (map[string]int, error) {
+	// Bucketize
+	m := map[int][]string{}
+	for _, key := range keys {
+		index := c.pool.Index(key)
+		m[index] = append(m[index], key)
+	}
+
+	// Scatter
+	type result struct {
+		counts map[string]int
+		err    error
+	}
+	results := make(chan result, len(m))
+	for index, keys := range m {
+		go func(index int, keys []string) {
+			var counts map[string]int
+			err := c.readPool.WithIndex(index, func(conn redis.Conn) (err error) {
+				counts, err = pipelineCount(conn, keys)
+				return err
+			})
+			results <- result{counts, err}
+		}(index, keys)
+	}
+
+	// Gather
+	var (
+		counts   = make(map[string]int, len(keys))
+		firstErr error
+	)
+	for _ = range m {
+		r := <-results
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+		for key, n := range r.counts {
+			counts[key] = n
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return counts, nil
+}
+
+func pipelineCount(conn redis.Conn, keys []string) (map[string]int, error) {
+	p := pool.NewPipeline(conn)
+	for _, key := range keys {
+		p.Queue("ZCARD", key+insertSuffix)
+	}
+	replies, err := p.Exec()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(keys))
+	for i, key := range keys {
+		if counts[key], err = redis.Int(replies[i], nil); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
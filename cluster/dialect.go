@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Dialect identifies the server implementing the Redis protocol on the
+// instances of a cluster. KeyDB and Dragonfly are mostly drop-in Redis
+// replacements, but differ in ways which matter to Roshi.
+type Dialect int
+
+const (
+	// DialectRedis is stock Redis, and the default.
+	DialectRedis Dialect = iota
+
+	// DialectKeyDB is KeyDB, a multithreaded fork of Redis. It behaves like
+	// Redis, as far as Roshi is concerned.
+	DialectKeyDB
+
+	// DialectDragonfly is Dragonfly. Its Lua scripts may only access the
+	// keys they declare, unless the server runs with
+	// --default_lua_flags=allow-undeclared-keys, which Roshi's scripts need,
+	// as they derive the names of the inserts and deletes sets from a single
+	// declared key. It doesn't support the REDIRECT mode of client tracking.
+	DialectDragonfly
+)
+
+// ParseDialect returns the dialect with the name: redis, keydb, or
+// dragonfly. A blank name is redis.
+func ParseDialect(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "", "redis":
+		return DialectRedis, nil
+	case "keydb":
+		return DialectKeyDB, nil
+	case "dragonfly":
+		return DialectDragonfly, nil
+	default:
+		return DialectRedis, fmt.Errorf("unknown Redis dialect %q (must be redis, keydb, or dragonfly)", name)
+	}
+}
+
+func (d Dialect) String() string {
+	switch d {
+	case DialectKeyDB:
+		return "keydb"
+	case DialectDragonfly:
+		return "dragonfly"
+	default:
+		return "redis"
+	}
+}
+
+// Tracking returns whether the dialect supports client tracking as used by
+// the Tracking option.
+func (d Dialect) Tracking() bool {
+	return d != DialectDragonfly
+}
+
+// Compatibility declares the dialect of the instances of the cluster, which
+// Check verifies, and works around its differences from Redis. The Tracking
+// option is ignored, with a log message, for dialects which don't support
+// it; callers should refuse that combination, as caches relying on the
+// invalidations would go stale.
+func Compatibility(d Dialect) Option {
+	return func(c *cluster) { c.dialect = d }
+}
+
+// checkDialect verifies that the instance behind the connection is of the
+// dialect, and configured the way Roshi needs.
+func checkDialect(conn redis.Conn, d Dialect) error {
+	info, err := redis.String(conn.Do("INFO", "server"))
+	if err != nil {
+		return err
+	}
+	dragonfly := strings.Contains(info, "dragonfly_version:")
+	switch {
+	case dragonfly && d != DialectDragonfly:
+		return fmt.Errorf("instance is Dragonfly, not %s", d)
+	case !dragonfly && d == DialectDragonfly:
+		return fmt.Errorf("instance isn't Dragonfly")
+	case !dragonfly:
+		return nil
+	}
+
+	values, err := redis.Strings(conn.Do("CONFIG", "GET", "default_lua_flags"))
+	if err != nil {
+		return err
+	}
+	if len(values) < 2 || !strings.Contains(values[1], "allow-undeclared-keys") {
+		return fmt.Errorf("Dragonfly must run with --default_lua_flags=allow-undeclared-keys")
+	}
+	return nil
+}
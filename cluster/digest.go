@@ -0,0 +1,127 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/pool"
+)
+
+// Digester is implemented by Clusters which can return a digest of the
+// elements an offset-based select would return, rather than the elements,
+// so that replicas can be compared without transferring whole pages. See
+// Digest. Clusters returned by New implement Digester.
+type Digester interface {
+	DigestOffset(keys []string, offset, limit int, ascending bool) <-chan DigestElement
+}
+
+// DigestElement is the digest of the page of a key, or the error reading it.
+type DigestElement struct {
+	Key    string
+	Digest string
+	Error  error
+}
+
+// Digest returns the digest of a page of elements, as computed by the
+// DigestOffset of Clusters returned by New: the hex SHA-1 of each member,
+// prefixed with its length, and followed by its score, formatted like %.17g,
+// and a newline, in order. Equal pages have equal digests. Scores which C
+// and Go format differently, like infinities, make digests differ, which
+// merely costs a full read.
+func Digest(keyScoreMembers []common.KeyScoreMember) string {
+	h := sha1.New()
+	for _, ksm := range keyScoreMembers {
+		fmt.Fprintf(h, "%d:%s%s\n", len(ksm.Member), ksm.Member, strconv.FormatFloat(ksm.Score, 'g', 17, 64))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digestScript returns the Digest of the elements of the inserts set in
+// KEYS[1] from rank ARGV[1] to ARGV[2], by ZRANGE if ARGV[3] is "asc", else
+// by ZREVRANGE, so only the digest leaves the instance.
+var digestScript = newScript("digest", 1, `
+	local command = 'ZREVRANGE'
+	if ARGV[3] == 'asc' then
+		command = 'ZRANGE'
+	end
+	local page = redis.call(command, KEYS[1], ARGV[1], ARGV[2], 'WITHSCORES')
+	local parts = {}
+	for i = 1, #page, 2 do
+		parts[#parts+1] = #page[i] .. ':' .. page[i] .. string.format('%.17g', tonumber(page[i+1])) .. '\n'
+	end
+	return redis.sha1hex(table.concat(parts))
+`)
+
+// DigestOffset implements Digester. The digest of each key is computed on
+// its instance, via a Lua script.
+func (c *cluster) DigestOffset(keys []string, offset, limit int, ascending bool) <-chan DigestElement {
+	out := make(chan DigestElement)
+	go func() {
+		defer close(out)
+		m := map[int][]string{}
+		for _, key := range keys {
+			index := c.pool.Index(key)
+			m[index] = append(m[index], key)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(len(m))
+		for index, keys := range m {
+			go func(index int, keys []string) {
+				defer wg.Done()
+				var digests []string
+				err := c.readPool.WithIndex(index, func(conn redis.Conn) (err error) {
+					digests, err = pipelineDigest(conn, keys, offset, limit, ascending)
+					return err
+				})
+				for i, key := range keys {
+					if err != nil {
+						out <- DigestElement{Key: key, Error: err}
+						continue
+					}
+					out <- DigestElement{Key: key, Digest: digests[i]}
+				}
+			}(index, keys)
+		}
+		wg.Wait()
+	}()
+	return out
+}
+
+func pipelineDigest(conn redis.Conn, keys []string, offset, limit int, ascending bool) ([]string, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("negative limit is invalid for offset-based select")
+	}
+	order := "desc"
+	if ascending {
+		order = "asc"
+	}
+
+	var replies []interface{}
+	if err := digestScript.reloading(conn, func() (err error) {
+		p := pool.NewPipeline(conn)
+		for _, key := range keys {
+			digestScript.Queue(p, key+insertSuffix, offset, offset+limit-1, order)
+		}
+		replies, err = p.Exec()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	digests := make([]string, len(keys))
+	for i := range keys {
+		digest, err := redis.String(replies[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = digest
+	}
+	return digests, nil
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/pool"
+)
+
+// GuardedDelete is a delete of a key-member which only applies if the member
+// is inserted with exactly the Expected score, i.e. if it's still the
+// version of the member the deleter has seen.
+type GuardedDelete struct {
+	common.KeyScoreMember
+	Expected float64
+}
+
+// GuardedDeleter is implemented by Clusters which can delete members on the
+// condition that they still have an expected score, atomically. A delete
+// whose guard fails is a no-op, as is a delete which loses to the stored
+// score, like any other write. Clusters returned by New implement
+// GuardedDeleter.
+type GuardedDeleter interface {
+	DeleteIf(deletes []GuardedDelete) ([]bool, error)
+}
+
+// guardedDeleteScript deletes the member ARGV[3] of KEYS[1] with the score
+// ARGV[2], like the delete script, if the member is in the inserts set with
+// the score ARGV[1]. It returns 1 if the delete was applied, 0 otherwise.
+// ARGV[4] and ARGV[5] are the max size and the empty key TTL, as for the
+// delete script.
+var guardedDeleteScript = newScript("delete-if", 1, strings.NewReplacer(
+	"INSERTSUFFIX", insertSuffix,
+	"DELETESUFFIX", deleteSuffix,
+).Replace(`
+	local insertKey = KEYS[1] .. 'INSERTSUFFIX'
+	local deleteKey = KEYS[1] .. 'DELETESUFFIX'
+	local expected = tonumber(ARGV[1])
+	local score = tonumber(ARGV[2])
+	local member = ARGV[3]
+	local maxSize = tonumber(ARGV[4])
+
+	local insertTs = redis.call('ZSCORE', insertKey, member)
+	if not insertTs or tonumber(insertTs) ~= expected or score < tonumber(insertTs) then
+		return 0
+	end
+
+	redis.call('ZREM', insertKey, member)
+	redis.call('ZADD', deleteKey, score, member)
+	redis.call('ZREMRANGEBYRANK', deleteKey, 0, -(maxSize+1))
+
+	local emptyKeyTTL = tonumber(ARGV[5])
+	if emptyKeyTTL > 0 and tonumber(redis.call('ZCARD', insertKey)) == 0 then
+		redis.call('EXPIRE', deleteKey, emptyKeyTTL)
+	end
+	return 1
+`))
+
+// DeleteIf implements GuardedDeleter. It reports, for each delete, whether
+// it was applied. Instances are written concurrently; if any fails, the
+// first error is returned, and the deletes of the other instances may or
+// may not have been applied.
+func (c *cluster) DeleteIf(deletes []GuardedDelete) ([]bool, error) {
+	// Bucketize, by index into deletes.
+	m := map[int][]int{}
+	for i, d := range deletes {
+		index := c.pool.Index(d.Key)
+		m[index] = append(m[index], i)
+	}
+
+	// Scatter. Each delete is only ever written by one goroutine.
+	var (
+		applied = make([]bool, len(deletes))
+		errChan = make(chan error, len(m))
+	)
+	for index, indices := range m {
+		go func(index int, indices []int) {
+			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
+				return pipelineDeleteIf(conn, deletes, indices, applied, c.maxSizeOf, c.emptyKeyTTL)
+			})
+		}(index, indices)
+	}
+
+	// Gather every response, so that no goroutine writes to applied after
+	// we return.
+	var firstErr error
+	for _ = range m {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return applied, nil
+}
+
+// pipelineDeleteIf sends the guarded delete script for the deletes at the
+// indices, and records whether each was applied.
+func pipelineDeleteIf(conn redis.Conn, deletes []GuardedDelete, indices []int, applied []bool, maxSize func(string) int, emptyKeyTTL int) error {
+	return guardedDeleteScript.reloading(conn, func() error {
+		p := pool.NewPipeline(conn)
+		for _, i := range indices {
+			d := deletes[i]
+			guardedDeleteScript.Queue(p, d.Key, d.Expected, d.Score, d.Member, maxSize(d.Key), emptyKeyTTL)
+		}
+		replies, err := p.Exec()
+		if err != nil {
+			return err
+		}
+		for j, i := range indices {
+			n, err := redis.Int(replies[j], nil)
+			if err != nil {
+				return err
+			}
+			applied[i] = n == 1
+		}
+		return nil
+	})
+}
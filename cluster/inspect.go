@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/pool"
+)
+
+// KeyState is the raw state of a key on the Redis instance of a cluster which
+// stores it: its inserts and deletes sets, with their scores, as opposed to
+// the merged view returned by a select.
+type KeyState struct {
+	Address     string                  `json:"address"`
+	InsertCount int                     `json:"insert_count"`
+	DeleteCount int                     `json:"delete_count"`
+	Inserts     []common.KeyScoreMember `json:"inserts"` // highest scores first, up to the limit
+	Deletes     []common.KeyScoreMember `json:"deletes"` // likewise
+	DeletesTTL  float64                 `json:"deletes_ttl_seconds,omitempty"`
+	Err         error                   `json:"-"` // nil if the key was read
+}
+
+// Inspect reads the inserts and deletes sets of the key from the instance
+// which stores it, up to limit members of each, from the highest score down,
+// along with the size of each set, and how long until the deletes set
+// expires, if it does; see EmptyKeyTTL. It's meant for diagnosing divergence
+// and tombstones, without access to the instances. Inspect only works on
+// Clusters returned by New.
+func Inspect(c Cluster, key string, limit int) (KeyState, error) {
+	concrete, ok := c.(*cluster)
+	if !ok {
+		return KeyState{}, fmt.Errorf("can't inspect keys in a %T", c)
+	}
+	if limit < 1 {
+		return KeyState{}, fmt.Errorf("invalid limit %d (must be at least 1)", limit)
+	}
+
+	index := concrete.pool.Index(key)
+	var state KeyState
+	err := concrete.readPool.WithIndex(index, func(conn redis.Conn) (err error) {
+		state, err = pipelineInspect(conn, key, limit)
+		return err
+	})
+	state.Address, state.Err = concrete.pool.ID(index), err
+	return state, nil
+}
+
+func pipelineInspect(conn redis.Conn, key string, limit int) (KeyState, error) {
+	p := pool.NewPipeline(conn)
+	for _, suffix := range []string{insertSuffix, deleteSuffix} {
+		p.Queue("ZCARD", key+suffix)
+		p.Queue("ZREVRANGE", key+suffix, 0, limit-1, "WITHSCORES")
+	}
+	p.Queue("PTTL", key+deleteSuffix)
+	replies, err := p.Exec()
+	if err != nil {
+		return KeyState{}, err
+	}
+
+	var (
+		state  KeyState
+		counts = []*int{&state.InsertCount, &state.DeleteCount}
+		sets   = []*[]common.KeyScoreMember{&state.Inserts, &state.Deletes}
+	)
+	for i, set := range sets {
+		if *counts[i], err = redis.Int(replies[2*i], nil); err != nil {
+			return KeyState{}, err
+		}
+		values, err := redis.Values(replies[2*i+1], nil)
+		if err != nil {
+			return KeyState{}, err
+		}
+		*set = make([]common.KeyScoreMember, 0, len(values)/2)
+		ksm := common.KeyScoreMember{Key: key}
+		for len(values) > 0 {
+			if values, err = redis.Scan(values, &ksm.Member, &ksm.Score); err != nil {
+				return KeyState{}, err
+			}
+			*set = append(*set, ksm)
+		}
+	}
+	ttl, err := redis.Int64(replies[4], nil)
+	if err != nil {
+		return KeyState{}, err
+	}
+	if ttl > 0 {
+		state.DeletesTTL = float64(ttl) / 1000
+	}
+	return state, nil
+}
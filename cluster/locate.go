@@ -0,0 +1,24 @@
+package cluster
+
+import (
+	"fmt"
+)
+
+// Location identifies the Redis instance of a cluster which stores a key.
+type Location struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+}
+
+// Locate returns the Redis instance which the key maps to, as computed by the
+// cluster's pool. It doesn't talk to Redis. Locate only works on Clusters
+// returned by New.
+func Locate(c Cluster, key string) (Location, error) {
+	concrete, ok := c.(*cluster)
+	if !ok {
+		return Location{}, fmt.Errorf("can't locate keys in a %T", c)
+	}
+
+	index := concrete.pool.Index(key)
+	return Location{Index: index, Address: concrete.pool.ID(index)}, nil
+}
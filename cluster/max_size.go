@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxSizeOverrides overrides the max size passed to New for keys with any of
+// the prefixes, e.g. to keep longer timelines for some users than for the
+// rest. Overrides map key prefixes to max sizes; a key with several of the
+// prefixes gets the max size of the longest. As with the max size passed to
+// New, keys are only trimmed as they're written, so lowering the max size of
+// a prefix trims its keys on their next write, and raising it doesn't bring
+// back members which were already trimmed.
+func MaxSizeOverrides(overrides map[string]int) Option {
+	return func(c *cluster) {
+		c.maxSizes = make([]maxSizeOverride, 0, len(overrides))
+		for prefix, maxSize := range overrides {
+			c.maxSizes = append(c.maxSizes, maxSizeOverride{prefix, maxSize})
+		}
+		sort.Sort(byPrefixLength(c.maxSizes))
+	}
+}
+
+// ParseMaxSizeOverrides parses overrides for MaxSizeOverrides, as
+// comma-separated prefix=size pairs, e.g. "vip:=10000, staff:=5000". Sizes
+// must be positive. A blank string has no overrides.
+func ParseMaxSizeOverrides(s string) (map[string]int, error) {
+	overrides := map[string]int{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid max size override %q (must be prefix=size)", pair)
+		}
+		prefix := pair[:i]
+		maxSize, err := strconv.Atoi(strings.TrimSpace(pair[i+1:]))
+		if err != nil || maxSize <= 0 {
+			return nil, fmt.Errorf("invalid max size in %q (must be a positive integer)", pair)
+		}
+		if _, ok := overrides[prefix]; ok {
+			return nil, fmt.Errorf("duplicate max size override for prefix %q", prefix)
+		}
+		overrides[prefix] = maxSize
+	}
+	return overrides, nil
+}
+
+type maxSizeOverride struct {
+	prefix  string
+	maxSize int
+}
+
+// byPrefixLength orders overrides longest prefix first, so the first which
+// matches a key is the most specific.
+type byPrefixLength []maxSizeOverride
+
+func (a byPrefixLength) Len() int           { return len(a) }
+func (a byPrefixLength) Less(i, j int) bool { return len(a[i].prefix) > len(a[j].prefix) }
+func (a byPrefixLength) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// maxSizeOf returns the max size of the key.
+func (c *cluster) maxSizeOf(key string) int {
+	for _, override := range c.maxSizes {
+		if strings.HasPrefix(key, override.prefix) {
+			return override.maxSize
+		}
+	}
+	return c.maxSize
+}
@@ -0,0 +1,81 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/pool"
+)
+
+// KeyMemory is the memory used by a key on the Redis instance of a cluster
+// which stores it, as estimated by MEMORY USAGE, in bytes. A set which
+// doesn't exist uses none.
+type KeyMemory struct {
+	Key         string `json:"key"`
+	Address     string `json:"address"`
+	InsertBytes int64  `json:"insert_bytes"`
+	DeleteBytes int64  `json:"delete_bytes"`
+	Err         error  `json:"-"` // nil if the key was read
+}
+
+// Bytes returns the memory used by both sets of the key.
+func (m KeyMemory) Bytes() int64 {
+	return m.InsertBytes + m.DeleteBytes
+}
+
+// MemoryUsage estimates the memory used by the inserts and deletes sets of
+// each key, in the order of the keys, with one pipeline per instance. Redis
+// samples members of large sets, so the estimates are approximate. It
+// requires Redis 4.0 or later, and only works on Clusters returned by New.
+// Instances which can't be read report an error in the usage of their keys,
+// rather than failing the whole estimate.
+func MemoryUsage(c Cluster, keys []string) ([]KeyMemory, error) {
+	concrete, ok := c.(*cluster)
+	if !ok {
+		return nil, fmt.Errorf("can't estimate memory in a %T", c)
+	}
+
+	usage := make([]KeyMemory, len(keys))
+	byIndex := map[int][]int{} // instance index: key indices
+	for i, key := range keys {
+		index := concrete.pool.Index(key)
+		byIndex[index] = append(byIndex[index], i)
+		usage[i] = KeyMemory{Key: key, Address: concrete.pool.ID(index)}
+	}
+	for index, positions := range byIndex {
+		err := concrete.readPool.WithIndex(index, func(conn redis.Conn) error {
+			return pipelineMemoryUsage(conn, usage, positions)
+		})
+		if err != nil {
+			for _, i := range positions {
+				usage[i].Err = err
+			}
+		}
+	}
+	return usage, nil
+}
+
+func pipelineMemoryUsage(conn redis.Conn, usage []KeyMemory, positions []int) error {
+	p := pool.NewPipeline(conn)
+	for _, i := range positions {
+		p.Queue("MEMORY", "USAGE", usage[i].Key+insertSuffix)
+		p.Queue("MEMORY", "USAGE", usage[i].Key+deleteSuffix)
+	}
+	replies, err := p.Exec()
+	if err != nil {
+		return err
+	}
+	for j, i := range positions {
+		for k, bytes := range []*int64{&usage[i].InsertBytes, &usage[i].DeleteBytes} {
+			reply := replies[2*j+k]
+			if reply == nil {
+				continue // no such set
+			}
+			if *bytes, err = redis.Int64(reply, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
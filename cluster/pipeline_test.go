@@ -0,0 +1,278 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/pool"
+)
+
+// replyConn is a net.Conn which records what's written to it, and replies
+// to every command with an integer.
+type replyConn struct {
+	net.Conn // nil; only the methods below are used
+	written  bytes.Buffer
+	record   bool
+}
+
+func (c *replyConn) Read(p []byte) (int, error) {
+	const reply = ":1\r\n"
+	n := 0
+	for n+len(reply) <= len(p) {
+		n += copy(p[n:], reply)
+	}
+	return n, nil
+}
+
+func (c *replyConn) Write(p []byte) (int, error) {
+	if c.record {
+		c.written.Write(p)
+	}
+	return len(p), nil
+}
+
+func (c *replyConn) Close() error                       { return nil }
+func (c *replyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestPipelineWriteEncoding(t *testing.T) {
+	tuples := []common.KeyScoreMember{
+		{Key: "foo", Score: 1.5, Member: "bar"},
+		{Key: "baz", Score: 1e21, Member: "qux"},
+	}
+	for name, testCase := range map[string]struct {
+		pipeline func(redis.Conn, []common.KeyScoreMember, func(string) int, int) error
+		script   *script
+	}{
+		"insert": {pipelineInsert, insertScript},
+		"delete": {pipelineDelete, deleteScript},
+	} {
+		// The pipeline must send exactly what script.Queue would.
+		expected := &replyConn{record: true}
+		p := pool.NewPipeline(redis.NewConn(expected, time.Second, time.Second))
+		for _, tuple := range tuples {
+			testCase.script.Queue(p, tuple.Key, tuple.Score, tuple.Member, 100, 60)
+		}
+		p.Exec()
+
+		got := &replyConn{record: true}
+		if err := testCase.pipeline(redis.NewConn(got, time.Second, time.Second), tuples, maxSizeOf(100), 60); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !bytes.Equal(expected.written.Bytes(), got.written.Bytes()) {
+			t.Errorf("%s: expected\n%q\ngot\n%q", name, expected.written.String(), got.written.String())
+		}
+	}
+}
+
+// maxSizeOf returns the max size function of keys without overrides.
+func maxSizeOf(maxSize int) func(string) int {
+	return func(string) int { return maxSize }
+}
+
+func TestMaxSizeOverrides(t *testing.T) {
+	c := &cluster{maxSize: 100}
+	MaxSizeOverrides(map[string]int{"vip:": 10000, "vip:staff:": 50, "x": 7})(c)
+	for key, expected := range map[string]int{
+		"user:1":        100,
+		"vip:1":         10000,
+		"vip:staff:1":   50,
+		"xy":            7,
+		"timeline:vip:": 100,
+	} {
+		if got := c.maxSizeOf(key); expected != got {
+			t.Errorf("%q: expected %d, got %d", key, expected, got)
+		}
+	}
+
+	// Each tuple is written with the max size of its key.
+	tuples := []common.KeyScoreMember{
+		{Key: "user:1", Score: 1, Member: "a"},
+		{Key: "vip:1", Score: 1, Member: "b"},
+		{Key: "vip:2", Score: 1, Member: "c"},
+		{Key: "user:2", Score: 1, Member: "d"},
+	}
+	expected := &replyConn{record: true}
+	p := pool.NewPipeline(redis.NewConn(expected, time.Second, time.Second))
+	for _, tuple := range tuples {
+		insertScript.Queue(p, tuple.Key, tuple.Score, tuple.Member, c.maxSizeOf(tuple.Key), 0)
+	}
+	p.Exec()
+	got := &replyConn{record: true}
+	if err := pipelineInsert(redis.NewConn(got, time.Second, time.Second), tuples, c.maxSizeOf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected.written.Bytes(), got.written.Bytes()) {
+		t.Errorf("expected\n%q\ngot\n%q", expected.written.String(), got.written.String())
+	}
+}
+
+// noScriptConn is a redis.Conn to an instance which doesn't have any script
+// until it's loaded. Invocations reply with 1.
+type noScriptConn struct {
+	redis.Conn // nil; only the methods below are used
+	loaded     map[string]bool
+	pending    []string // hashes of the invocations sent
+	evals      int
+	loads      int
+}
+
+func (c *noScriptConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "SCRIPT" {
+		c.loads++
+		sum := sha1.Sum([]byte(args[1].(string)))
+		hash := hex.EncodeToString(sum[:])
+		c.loaded[hash] = true
+		return []byte(hash), nil
+	}
+	c.Send(cmd, args...)
+	return c.Receive()
+}
+
+func (c *noScriptConn) Send(cmd string, args ...interface{}) error {
+	c.pending = append(c.pending, args[0].(string))
+	return nil
+}
+
+func (c *noScriptConn) Flush() error { return nil }
+
+func (c *noScriptConn) Receive() (interface{}, error) {
+	hash := c.pending[0]
+	c.pending = c.pending[1:]
+	if !c.loaded[hash] {
+		return nil, redis.Error("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	c.evals++
+	return int64(1), nil
+}
+
+func TestScriptReloading(t *testing.T) {
+	tuples := []common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "bar"},
+		{Key: "baz", Score: 2, Member: "qux"},
+	}
+	conn := &noScriptConn{loaded: map[string]bool{}}
+
+	// The script is loaded once, and the pipeline is sent again.
+	if err := pipelineInsert(conn, tuples, maxSizeOf(100), 0); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, conn.loads; expected != got {
+		t.Errorf("expected %d load(s), got %d", expected, got)
+	}
+	if expected, got := len(tuples), conn.evals; expected != got {
+		t.Errorf("expected %d invocation(s), got %d", expected, got)
+	}
+	if len(conn.pending) > 0 {
+		t.Errorf("%d reply(s) not received", len(conn.pending))
+	}
+
+	// Once it's loaded, it's not loaded again.
+	if err := pipelineInsert(conn, tuples, maxSizeOf(100), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertScript.Do(conn, "foo", 1, "bar", 100, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, conn.loads; expected != got {
+		t.Errorf("expected %d load(s), got %d", expected, got)
+	}
+}
+
+func BenchmarkPipelineInsert(b *testing.B) {
+	benchmarkPipeline(b, pipelineInsert)
+}
+
+func BenchmarkPipelineDelete(b *testing.B) {
+	benchmarkPipeline(b, pipelineDelete)
+}
+
+func benchmarkPipeline(b *testing.B, pipeline func(redis.Conn, []common.KeyScoreMember, func(string) int, int) error) {
+	tuples := make([]common.KeyScoreMember, 100)
+	for i := range tuples {
+		tuples[i] = common.KeyScoreMember{
+			Key:    fmt.Sprintf("key%d", i%10),
+			Score:  float64(1400000000 + i),
+			Member: fmt.Sprintf("member%d", i),
+		}
+	}
+	conn := redis.NewConn(&replyConn{}, time.Second, time.Second)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := pipeline(conn, tuples, maxSizeOf(1000), 3600); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// rangeConn is a redis.Conn which replies to each range script invocation
+// with the next of its replies, and records the arguments.
+type rangeConn struct {
+	redis.Conn // nil; only the methods below are used
+	replies    []interface{}
+	pending    int
+	calls      [][]interface{}
+}
+
+func (c *rangeConn) Send(cmd string, args ...interface{}) error {
+	c.calls = append(c.calls, args)
+	c.pending++
+	return nil
+}
+
+func (c *rangeConn) Flush() error { return nil }
+
+func (c *rangeConn) Receive() (interface{}, error) {
+	c.pending--
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	return reply, nil
+}
+
+func TestPipelineRangeByScoreContinues(t *testing.T) {
+	conn := &rangeConn{replies: []interface{}{
+		// The scan stopped early, after skipping 3 elements with the start
+		// score, and returning beta.
+		[]interface{}{
+			[]interface{}{[]byte("beta"), []byte("5")},
+			[]interface{}{[]byte("5"), []byte("beta"), int64(4)},
+		},
+		[]interface{}{
+			[]interface{}{[]byte("alpha"), []byte("5"), []byte("delta"), []byte("4")},
+		},
+	}}
+	start := common.Cursor{Score: 5, Member: "gamma"}
+	m, err := pipelineRangeByScore(conn, []string{"foo"}, start, common.Cursor{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []common.KeyScoreMember{
+		{Key: "foo", Score: 5, Member: "beta"},
+		{Key: "foo", Score: 5, Member: "alpha"},
+		{Key: "foo", Score: 4, Member: "delta"},
+	}
+	if got := m["foo"]; fmt.Sprint(expected) != fmt.Sprint(got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// The second call continues after beta, past the 4 scanned elements with
+	// its score, for the remaining limit.
+	if len(conn.calls) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(conn.calls))
+	}
+	if expected, got := fmt.Sprint([]interface{}{"5", "beta", "0", "", 9, 4, rangeScanLimit}), fmt.Sprint(conn.calls[1][3:]); expected != got {
+		t.Errorf("expected arguments %s, got %s", expected, got)
+	}
+}
@@ -0,0 +1,119 @@
+package cluster
+
+import (
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/pool"
+)
+
+// KeyspaceSampler is implemented by Clusters which can sample their keyspace
+// at random, and estimate its size, without scanning it, e.g. to estimate the
+// cost of a walk. Clusters returned by New implement KeyspaceSampler.
+type KeyspaceSampler interface {
+	SampleKeys(n int) (KeyspaceSample, error)
+}
+
+// KeyspaceSample is a random sample of the keys of a cluster, as Keys emits
+// them, with estimates of the size of its keyspace.
+type KeyspaceSample struct {
+	Keys      []string // distinct, up to n
+	Estimate  int      // keys which Keys would emit
+	RedisKeys int      // keys of any kind, e.g. deletes sets, in every instance
+}
+
+// SampleKeys implements KeyspaceSampler. Every instance is sampled alike,
+// with RANDOMKEY, in a single round trip along with its DBSIZE. The keys
+// Keys would emit are estimated per instance, as its DBSIZE scaled by the
+// fraction of its sampled keys which Keys would emit. Samples are drawn with
+// replacement, so fewer than n keys may be returned, even from a large
+// keyspace. If any instance fails, the first error is returned.
+func (c *cluster) SampleKeys(n int) (KeyspaceSample, error) {
+	var (
+		instances = c.pool.Size()
+		perIndex  = (n + instances - 1) / instances
+	)
+
+	// Scatter
+	type result struct {
+		keys         []string
+		size, walked int
+		err          error
+	}
+	results := make(chan result, instances)
+	for index := 0; index < instances; index++ {
+		go func(index int) {
+			var r result
+			r.err = c.readPool.WithIndex(index, func(conn redis.Conn) (err error) {
+				r.keys, r.size, r.walked, err = pipelineSampleKeys(conn, perIndex)
+				return err
+			})
+			results <- r
+		}(index)
+	}
+
+	// Gather
+	var (
+		sample   = KeyspaceSample{Keys: []string{}}
+		seen     = map[string]bool{}
+		firstErr error
+	)
+	for i := 0; i < instances; i++ {
+		r := <-results
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+		sample.RedisKeys += r.size
+		sample.Estimate += r.walked
+		for _, key := range r.keys {
+			if !seen[key] && len(sample.Keys) < n {
+				seen[key] = true
+				sample.Keys = append(sample.Keys, key)
+			}
+		}
+	}
+	if firstErr != nil {
+		return KeyspaceSample{}, firstErr
+	}
+	return sample, nil
+}
+
+// pipelineSampleKeys returns the keys with insertSuffix among n random keys
+// of the instance, without the suffix, the DBSIZE of the instance, and the
+// estimated number of keys with insertSuffix in it.
+func pipelineSampleKeys(conn redis.Conn, n int) ([]string, int, int, error) {
+	p := pool.NewPipeline(conn)
+	p.Queue("DBSIZE")
+	for i := 0; i < n; i++ {
+		p.Queue("RANDOMKEY")
+	}
+	replies, err := p.Exec()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	size, err := redis.Int(replies[0], nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var (
+		keys    = []string{}
+		sampled = 0
+	)
+	for _, reply := range replies[1:] {
+		if reply == nil {
+			break // the instance is empty
+		}
+		key, err := redis.String(reply, nil)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		sampled++
+		if l := len(key) - len(insertSuffix); l >= 0 && key[l:] == insertSuffix {
+			keys = append(keys, key[:l])
+		}
+	}
+	if sampled <= 0 {
+		return keys, size, 0, nil
+	}
+	return keys, size, size * len(keys) / sampled, nil
+}
@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/pool"
+)
+
+// scriptsKey holds the SHA1 digest of each script, by name, as last loaded
+// onto a Redis instance by any process. Like convergedKey, it has neither
+// suffix, so it isn't scanned.
+const scriptsKey = "roshi:scripts"
+
+// script is a Lua script run on the Redis instances. It's always invoked by
+// its SHA1 digest, via EVALSHA, so its source is only sent to an instance
+// when it's loaded, rather than with every invocation.
+type script struct {
+	name     string
+	keyCount int
+	src      string
+	hash     string
+}
+
+// scripts are all the scripts created by newScript, i.e. every script the
+// package runs.
+var scripts []*script
+
+func newScript(name string, keyCount int, src string) *script {
+	sum := sha1.Sum([]byte(src))
+	s := &script{
+		name:     name,
+		keyCount: keyCount,
+		src:      src,
+		hash:     hex.EncodeToString(sum[:]),
+	}
+	scripts = append(scripts, s)
+	return s
+}
+
+func (s *script) args(keysAndArgs []interface{}) []interface{} {
+	args := make([]interface{}, 2+len(keysAndArgs))
+	args[0], args[1] = s.hash, s.keyCount
+	copy(args[2:], keysAndArgs)
+	return args
+}
+
+// Queue adds an invocation of the script to the pipeline. If the instance
+// doesn't have the script, the reply is a NOSCRIPT error; see reloading.
+func (s *script) Queue(p *pool.Pipeline, keysAndArgs ...interface{}) {
+	p.Queue("EVALSHA", s.args(keysAndArgs)...)
+}
+
+// Do invokes the script, and loads it first if the instance doesn't have it.
+func (s *script) Do(conn redis.Conn, keysAndArgs ...interface{}) (interface{}, error) {
+	var reply interface{}
+	err := s.reloading(conn, func() (err error) {
+		reply, err = conn.Do("EVALSHA", s.args(keysAndArgs)...)
+		return err
+	})
+	return reply, err
+}
+
+// Load loads the script onto the instance, and verifies that the instance
+// computes the same SHA1 digest as we do, so that EVALSHA runs this source.
+func (s *script) Load(conn redis.Conn) error {
+	hash, err := redis.String(conn.Do("SCRIPT", "LOAD", s.src))
+	if err != nil {
+		return err
+	}
+	if hash != s.hash {
+		return fmt.Errorf("%s script loaded as %s, expected %s", s.name, hash, s.hash)
+	}
+	return nil
+}
+
+// reloading calls do, which invokes the script. If the instance doesn't have
+// the script, e.g. after a restart or a SCRIPT FLUSH, the script is loaded,
+// and do is called once more. That's safe, as every script is idempotent.
+// If do sends a pipeline, it must receive every reply, even after an error,
+// so that the connection may be reused, as pool.Pipeline's Exec does.
+func (s *script) reloading(conn redis.Conn, do func() error) error {
+	err := do()
+	if e, ok := err.(redis.Error); !ok || !strings.HasPrefix(string(e), "NOSCRIPT ") {
+		return err
+	}
+	if err := s.Load(conn); err != nil {
+		return err
+	}
+	return do()
+}
+
+// ScriptVersion identifies a Lua script run on the Redis instances by its
+// name and the SHA1 digest of its source. Different digests of the same
+// script mean different semantics.
+type ScriptVersion struct {
+	Name string `json:"name"`
+	SHA1 string `json:"sha1"`
+}
+
+// ScriptVersions returns the versions of every script run by the package,
+// ordered by name.
+func ScriptVersions() []ScriptVersion {
+	versions := make([]ScriptVersion, len(scripts))
+	for i, s := range scripts {
+		versions[i] = ScriptVersion{Name: s.name, SHA1: s.hash}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Name < versions[j].Name })
+	return versions
+}
+
+// InstanceScripts is the outcome of loading the scripts onto a single Redis
+// instance.
+type InstanceScripts struct {
+	Address string          `json:"address"`
+	Drift   []ScriptVersion `json:"drift,omitempty"` // as last loaded by another process, if different
+	Err     error           `json:"-"`               // nil if the scripts were loaded
+}
+
+// LoadScripts loads every script onto every Redis instance in the cluster,
+// so that no write or read has to load them on demand, and verifies that the
+// instances compute the expected digests. The versions loaded are recorded
+// on each instance. Versions recorded by other processes which differ from
+// ours are reported as drift: those processes, e.g. other versions of Roshi,
+// apply different semantics to the same data. LoadScripts returns one
+// InstanceScripts per instance, in order. It only works on Clusters returned
+// by New.
+func LoadScripts(c Cluster) ([]InstanceScripts, error) {
+	concrete, ok := c.(*cluster)
+	if !ok {
+		return []InstanceScripts{}, fmt.Errorf("can't load scripts onto a %T", c)
+	}
+
+	results := make([]InstanceScripts, concrete.pool.Size())
+	for i := range results {
+		results[i].Address = concrete.pool.ID(i)
+		results[i].Err = concrete.pool.WithIndex(i, func(conn redis.Conn) error {
+			pairs, err := redis.Strings(conn.Do("HGETALL", scriptsKey))
+			if err != nil {
+				return err
+			}
+			recorded := map[string]string{} // name: hash
+			for j := 0; j+1 < len(pairs); j += 2 {
+				recorded[pairs[j]] = pairs[j+1]
+			}
+			args := []interface{}{scriptsKey}
+			for _, s := range scripts {
+				if err := s.Load(conn); err != nil {
+					return err
+				}
+				if hash, ok := recorded[s.name]; ok && hash != s.hash {
+					results[i].Drift = append(results[i].Drift, ScriptVersion{Name: s.name, SHA1: hash})
+				}
+				args = append(args, s.name, s.hash)
+			}
+			_, err = conn.Do("HMSET", args...)
+			return err
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/pool"
+)
+
+// Toucher is implemented by Clusters which can move members to a new score,
+// on the condition that they're inserted with a lower score, atomically.
+// Unlike an insert, a touch never adds a member, nor undoes a delete, so a
+// member can be bumped without knowing whether it's still there, e.g. to the
+// top of a timeline. Clusters returned by New implement Toucher.
+type Toucher interface {
+	Touch(tuples []common.KeyScoreMember) ([]bool, error)
+}
+
+// touchScript moves the member ARGV[2] of KEYS[1] to the score ARGV[1], if
+// the member is in the inserts set with a lower score. It returns 1 if the
+// touch was applied, 0 otherwise. The size of the set doesn't change, so
+// it's never trimmed.
+var touchScript = newScript("touch", 1, strings.NewReplacer(
+	"INSERTSUFFIX", insertSuffix,
+).Replace(`
+	local insertKey = KEYS[1] .. 'INSERTSUFFIX'
+	local score = tonumber(ARGV[1])
+	local member = ARGV[2]
+
+	local insertTs = redis.call('ZSCORE', insertKey, member)
+	if not insertTs or score <= tonumber(insertTs) then
+		return 0
+	end
+
+	redis.call('ZADD', insertKey, score, member)
+	return 1
+`))
+
+// Touch implements Toucher. It reports, for each tuple, whether its member
+// was moved to its score. Instances are written concurrently; if any fails,
+// the first error is returned, and the touches of the other instances may
+// or may not have been applied.
+func (c *cluster) Touch(tuples []common.KeyScoreMember) ([]bool, error) {
+	// Bucketize, by index into tuples.
+	m := map[int][]int{}
+	for i, tuple := range tuples {
+		index := c.pool.Index(tuple.Key)
+		m[index] = append(m[index], i)
+	}
+
+	// Scatter. Each tuple is only ever written by one goroutine.
+	var (
+		applied = make([]bool, len(tuples))
+		errChan = make(chan error, len(m))
+	)
+	for index, indices := range m {
+		go func(index int, indices []int) {
+			errChan <- c.pool.WithIndex(index, func(conn redis.Conn) error {
+				return pipelineTouch(conn, tuples, indices, applied)
+			})
+		}(index, indices)
+	}
+
+	// Gather every response, so that no goroutine writes to applied after
+	// we return.
+	var firstErr error
+	for _ = range m {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return applied, nil
+}
+
+// pipelineTouch sends the touch script for the tuples at the indices, and
+// records whether each was applied.
+func pipelineTouch(conn redis.Conn, tuples []common.KeyScoreMember, indices []int, applied []bool) error {
+	return touchScript.reloading(conn, func() error {
+		p := pool.NewPipeline(conn)
+		for _, i := range indices {
+			tuple := tuples[i]
+			touchScript.Queue(p, tuple.Key, tuple.Score, tuple.Member)
+		}
+		replies, err := p.Exec()
+		if err != nil {
+			return err
+		}
+		for j, i := range indices {
+			n, err := redis.Int(replies[j], nil)
+			if err != nil {
+				return err
+			}
+			applied[i] = n == 1
+		}
+		return nil
+	})
+}
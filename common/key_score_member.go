@@ -26,6 +26,24 @@ func (ksm KeyScoreMember) Cursor() Cursor {
 	}
 }
 
+// Before reports whether ksm comes before other in the order every Select
+// returns tuples in: by descending score, and equal scores by descending
+// member, compared bytewise, like ZREVRANGE. Tuples of different keys with
+// the same score and member are ordered by descending key. Ascending Selects
+// return the reverse order.
+//
+// The order is total, so the tuples of a key come back in the same order
+// from every cluster, and from every read, whichever clusters answered it.
+func (ksm KeyScoreMember) Before(other KeyScoreMember) bool {
+	if ksm.Score != other.Score {
+		return ksm.Score > other.Score
+	}
+	if ksm.Member != other.Member {
+		return ksm.Member > other.Member
+	}
+	return ksm.Key > other.Key
+}
+
 // jsonKeyScoreMember is used internally by MarshalJSON and UnmarshalJSON.
 type jsonKeyScoreMember struct {
 	Key    []byte  `json:"key"`
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"sort"
 	"testing"
 )
 
@@ -11,3 +12,29 @@ func TestMarshal(t *testing.T) {
 func TestUnmarshal(t *testing.T) {
 	// TODO
 }
+
+func TestBefore(t *testing.T) {
+	tuples := []KeyScoreMember{
+		{Key: "a", Score: 1, Member: "x"},
+		{Key: "a", Score: 2, Member: "a"},
+		{Key: "b", Score: 1, Member: "x"},
+		{Key: "a", Score: 1, Member: "\xff"},
+		{Key: "a", Score: 1, Member: "xy"},
+	}
+	sort.Slice(tuples, func(i, j int) bool { return tuples[i].Before(tuples[j]) })
+	expected := []KeyScoreMember{
+		{Key: "a", Score: 2, Member: "a"},
+		{Key: "a", Score: 1, Member: "\xff"}, // bytewise, not by rune
+		{Key: "a", Score: 1, Member: "xy"},
+		{Key: "b", Score: 1, Member: "x"},
+		{Key: "a", Score: 1, Member: "x"},
+	}
+	for i := range expected {
+		if tuples[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, tuples)
+		}
+	}
+	if tuples[0].Before(tuples[0]) {
+		t.Errorf("a tuple can't come before itself")
+	}
+}
@@ -0,0 +1,83 @@
+package farm
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// Amplification is the read amplification of a read strategy: how many
+// key-score-member tuples it retrieved from the clusters, against how many
+// it returned to clients. Strategies which read from more than one cluster
+// retrieve every tuple once per cluster, and every strategy retrieves up to
+// the limit from each cluster, so overfetch shows as a ratio above 1.
+type Amplification struct {
+	Strategy  string `json:"strategy"`
+	Retrieved uint64 `json:"retrieved"`
+	Returned  uint64 `json:"returned"`
+}
+
+// Ratio returns the tuples retrieved per tuple returned, or 0 if none were
+// returned.
+func (a Amplification) Ratio() float64 {
+	if a.Returned <= 0 {
+		return 0
+	}
+	return float64(a.Retrieved) / float64(a.Returned)
+}
+
+// readAmplification accumulates the read amplification of each strategy
+// over the lifetime of the farm. It's shared by all views of the farm, and
+// safe for concurrent use.
+type readAmplification struct {
+	mtx        sync.Mutex
+	strategies map[string]*Amplification
+}
+
+func newReadAmplification() *readAmplification {
+	return &readAmplification{strategies: map[string]*Amplification{}}
+}
+
+// observe records the tuples retrieved and returned by a Select, and reports
+// its ratio to instrumentation, if it returned any tuples.
+func (r *readAmplification) observe(strategy string, retrieved, returned int, instr instrumentation.SelectInstrumentation) {
+	r.add(strategy, retrieved, returned)
+	if returned > 0 {
+		instr.SelectReadAmplification(strategy, float64(retrieved)/float64(returned))
+	}
+}
+
+// add records tuples without reporting a ratio, e.g. for tuples retrieved
+// after the Select returned.
+func (r *readAmplification) add(strategy string, retrieved, returned int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	a, ok := r.strategies[strategy]
+	if !ok {
+		a = &Amplification{Strategy: strategy}
+		r.strategies[strategy] = a
+	}
+	a.Retrieved += uint64(retrieved)
+	a.Returned += uint64(returned)
+}
+
+// ReadAmplification returns the read amplification of each strategy which
+// served Selects since the farm was created, ordered by strategy. Tuples
+// retrieved by lingering strategies after a Select returned are included.
+func (f *Farm) ReadAmplification() []Amplification {
+	f.amplification.mtx.Lock()
+	defer f.amplification.mtx.Unlock()
+	amplifications := make([]Amplification, 0, len(f.amplification.strategies))
+	for _, a := range f.amplification.strategies {
+		amplifications = append(amplifications, *a)
+	}
+	sort.Sort(byStrategy(amplifications))
+	return amplifications
+}
+
+type byStrategy []Amplification
+
+func (a byStrategy) Len() int           { return len(a) }
+func (a byStrategy) Less(i, j int) bool { return a[i].Strategy < a[j].Strategy }
+func (a byStrategy) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
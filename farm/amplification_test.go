@@ -0,0 +1,80 @@
+package farm
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+type amplificationInstrumentation struct {
+	instrumentation.NopInstrumentation
+	mtx    sync.Mutex
+	ratios map[string][]float64
+}
+
+func (i *amplificationInstrumentation) SelectReadAmplification(strategy string, ratio float64) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.ratios[strategy] = append(i.ratios[strategy], ratio)
+}
+
+func TestReadAmplification(t *testing.T) {
+	var (
+		clusters = []cluster.Cluster{clustertest.New(), clustertest.New(), clustertest.New()}
+		instr    = &amplificationInstrumentation{ratios: map[string][]float64{}}
+		farm     = New(clusters, 3, SendAllReadAll, NoRepairs, instr)
+	)
+	if err := farm.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := farm.ReadAmplification(); len(got) != 0 {
+		t.Fatalf("expected no amplification before any Select, got %+v", got)
+	}
+
+	// Every cluster returns both tuples, which merge into two. The view
+	// without repairs shares the counters.
+	if _, err := farm.SelectOffset([]string{"foo"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := farm.WithoutRepairs().SelectOffset([]string{"foo", "bar"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Amplification{{Strategy: "SendAllReadAll", Retrieved: 12, Returned: 4}}
+	var got []Amplification
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if got = farm.ReadAmplification(); reflect.DeepEqual(expected, got) {
+			break
+		}
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+	if expected, got := 3.0, got[0].Ratio(); expected != got {
+		t.Errorf("expected ratio %f, got %f", expected, got)
+	}
+
+	instr.mtx.Lock()
+	defer instr.mtx.Unlock()
+	if expected, got := []float64{3, 3}, instr.ratios["SendAllReadAll"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected ratios %v, got %v", expected, got)
+	}
+}
+
+func TestAmplificationRatio(t *testing.T) {
+	if got := (Amplification{Retrieved: 5}).Ratio(); got != 0 {
+		t.Errorf("nothing returned: expected ratio 0, got %f", got)
+	}
+	if expected, got := 2.5, (Amplification{Retrieved: 5, Returned: 2}).Ratio(); expected != got {
+		t.Errorf("expected ratio %f, got %f", expected, got)
+	}
+}
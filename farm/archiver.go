@@ -0,0 +1,58 @@
+package farm
+
+import (
+	"log"
+	"sync"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Archiver durably records tuples which have been successfully inserted into
+// the farm. Roshi sets are capped at a maximum size, so old elements are
+// eventually evicted; an Archiver keeps a record of them elsewhere, e.g. in
+// long-term storage. See package archive for implementations.
+type Archiver interface {
+	Archive(tuples []common.KeyScoreMember) error
+}
+
+// ArchiveInserts causes the tuples of every successful Insert to be passed to
+// the Archiver, after the write quorum has been reached. Archive errors don't
+// cause the Insert to fail, as the tuples have already been written. They're
+// logged once, when archiving starts failing, and again when it recovers.
+func ArchiveInserts(a Archiver) Option {
+	return func(f *Farm) { f.archiver = &archiving{Archiver: a} }
+}
+
+// archiving passes tuples to an Archiver, and logs the changes of its
+// outcome.
+type archiving struct {
+	Archiver
+	mtx    sync.Mutex
+	failed string // the last error, or blank if the last call succeeded
+}
+
+func (f *Farm) archive(tuples []common.KeyScoreMember) {
+	if f.archiver == nil {
+		return
+	}
+	f.archiver.archive(tuples)
+}
+
+func (a *archiving) archive(tuples []common.KeyScoreMember) {
+	var failed string
+	err := a.Archive(tuples)
+	if err != nil {
+		failed = err.Error()
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	switch {
+	case failed == a.failed:
+	case err != nil:
+		log.Printf("archive: %d tuple(s): %s (logged once until it changes)", len(tuples), err)
+	default:
+		log.Printf("archive: recovered from %s", a.failed)
+	}
+	a.failed = failed
+}
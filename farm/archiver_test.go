@@ -0,0 +1,71 @@
+package farm
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+type mockArchiver struct{ archived []common.KeyScoreMember }
+
+func (a *mockArchiver) Archive(tuples []common.KeyScoreMember) error {
+	a.archived = append(a.archived, tuples...)
+	return errors.New("archive errors are only logged")
+}
+
+func TestArchiveInserts(t *testing.T) {
+	var (
+		archiver = &mockArchiver{}
+		clusters = newMockClusters(3)
+		farm     = New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil, ArchiveInserts(archiver))
+		tuples   = []common.KeyScoreMember{common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"}}
+	)
+
+	if err := farm.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if err := farm.Delete(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tuples, archiver.archived) {
+		t.Errorf("expected %v, got %v", tuples, archiver.archived)
+	}
+
+	// Failed inserts aren't archived.
+	failing := New(newFailingMockClusters(1), 1, SendAllReadAll, NoRepairs, nil, ArchiveInserts(archiver))
+	if err := failing.Insert(tuples); err == nil {
+		t.Fatal("expected error, got none")
+	}
+	if n := len(archiver.archived); n != 1 {
+		t.Errorf("expected 1 archived tuple, got %d", n)
+	}
+}
+
+type toggleArchiver struct{ err error }
+
+func (a *toggleArchiver) Archive([]common.KeyScoreMember) error { return a.err }
+
+func TestArchiveErrorsLoggedOnce(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var (
+		archiver = &toggleArchiver{}
+		a        = &archiving{Archiver: archiver}
+		tuples   = []common.KeyScoreMember{common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"}}
+	)
+	for _, err := range []error{nil, errors.New("full"), errors.New("full"), errors.New("broken"), nil, nil} {
+		archiver.err = err
+		a.archive(tuples)
+	}
+	if expected, got := 3, strings.Count(buf.String(), "\n"); expected != got {
+		t.Errorf("expected %d lines logged, got %d: %q", expected, got, buf.String())
+	}
+}
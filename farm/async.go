@@ -0,0 +1,116 @@
+package farm
+
+import (
+	"sync"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Default bounds of asynchronous inserts, without the AsyncInserts option.
+const (
+	defaultAsyncWorkers = 64
+	defaultAsyncQueue   = 1024
+)
+
+// AsyncInserts bounds the inserts sent by InsertAsync: at most workers are
+// sent at once, each by a long-lived worker, and at most queue more wait for
+// a worker. Further InsertAsync calls resolve right away with ErrOverloaded,
+// so that producers outpacing the clusters get backpressure, rather than
+// piling up goroutines. By default, there are 64 workers, and a queue of
+// 1024 inserts. There's at least 1 worker.
+func AsyncInserts(workers, queue int) Option {
+	return func(f *Farm) { f.async = newAsyncInserts(workers, queue) }
+}
+
+// asyncInserts runs the inserts of InsertAsync with a bounded number of
+// goroutines, started on first use, which run for the lifetime of the
+// process. It's safe for concurrent use.
+type asyncInserts struct {
+	once    sync.Once
+	workers int
+	tasks   chan func() // with capacity for the queue
+}
+
+func newAsyncInserts(workers, queue int) *asyncInserts {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &asyncInserts{workers: workers, tasks: make(chan func(), queue)}
+}
+
+// submit hands the task to an idle worker, or queues it. It returns false if
+// every worker is busy, and the queue is full.
+func (a *asyncInserts) submit(task func()) bool {
+	a.once.Do(func() {
+		for i := 0; i < a.workers; i++ {
+			go a.work()
+		}
+	})
+	select {
+	case a.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *asyncInserts) work() {
+	for task := range a.tasks {
+		task()
+	}
+}
+
+// PendingWrite is the handle of an asynchronous write, returned by
+// InsertAsync. It resolves once the write reached quorum, or failed.
+type PendingWrite struct {
+	done   chan struct{}
+	result WriteResult
+	err    error
+}
+
+// Done returns a channel which is closed once the write is resolved, for use
+// in selects.
+func (p *PendingWrite) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait blocks until the write is resolved, and returns its outcome, as
+// InsertVerbose would, except that the WriteResult only reflects the
+// clusters which responded until quorum was reached, as with Insert.
+func (p *PendingWrite) Wait() (WriteResult, error) {
+	<-p.done
+	return p.result, p.err
+}
+
+// resolve sets the outcome of the write, and releases its waiters.
+func (p *PendingWrite) resolve(result WriteResult, err error) *PendingWrite {
+	p.result, p.err = result, err
+	close(p.done)
+	return p
+}
+
+// InsertAsync is like Insert, but returns right away, with a handle which
+// resolves when the write reaches quorum, or fails. Producers can pipeline
+// many writes this way, and collect their outcomes later, without tying up
+// a goroutine of their own per write. Writes are sent by a bounded pool of
+// workers; see AsyncInserts. Writes which are rejected before any request is
+// made to the clusters, e.g. for their score skew, or because the workers
+// and their queue are full, resolve immediately.
+//
+// Pipelined writes are sent independently, so two writes of the same
+// key-member may be applied in either order. As with any write, the higher
+// score wins regardless.
+func (f *Farm) InsertAsync(tuples []common.KeyScoreMember) *PendingWrite {
+	p := &PendingWrite{done: make(chan struct{})}
+	tuples, err := f.checkInsert(tuples)
+	if err != nil {
+		return p.resolve(WriteResult{Required: f.writeQuorum, Acknowledged: []int{}, Failed: map[int]error{}}, err)
+	}
+	if !f.async.submit(func() { p.resolve(f.insert(tuples, false)) }) {
+		return p.resolve(WriteResult{Required: f.writeQuorum, Acknowledged: []int{}, Failed: map[int]error{}}, ErrOverloaded)
+	}
+	return p
+}
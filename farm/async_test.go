@@ -0,0 +1,106 @@
+package farm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestInsertAsync(t *testing.T) {
+	var (
+		fakes    = []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+		clusters = []cluster.Cluster{fakes[0], fakes[1], fakes[2]}
+		farm     = New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+		pending  = make([]*PendingWrite, 10)
+	)
+	for i := range pending {
+		pending[i] = farm.InsertAsync([]common.KeyScoreMember{{Key: "foo", Score: float64(i), Member: string(rune('a' + i))}})
+	}
+	for i, p := range pending {
+		result, err := p.Wait()
+		if err != nil {
+			t.Fatalf("write %d: %s", i, err)
+		}
+		if !result.Quorum || len(result.Acknowledged) < 2 {
+			t.Errorf("write %d: expected quorum, got %+v", i, result)
+		}
+		select {
+		case <-p.Done():
+		default:
+			t.Errorf("write %d: resolved, but not done", i)
+		}
+	}
+	selected, err := farm.SelectOffset([]string{"foo"}, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := len(pending), len(selected["foo"]); expected != got {
+		t.Errorf("expected %d members, got %d", expected, got)
+	}
+
+	// Quorum failures resolve with a QuorumError.
+	fakes[0].FailWith(clustertest.Insert, errors.New("unavailable"))
+	fakes[1].FailWith(clustertest.Insert, errors.New("unavailable"))
+	select {
+	case <-farm.InsertAsync([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}).Done():
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the write to resolve")
+	}
+	if _, err := farm.InsertAsync([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}).Wait(); err == nil {
+		t.Errorf("expected a quorum error, got none")
+	} else if _, ok := err.(QuorumError); !ok {
+		t.Errorf("expected a quorum error, got %v", err)
+	}
+
+	// Rejected writes resolve right away.
+	skewed := New([]cluster.Cluster{clustertest.New()}, 1, SendAllReadAll, NoRepairs, nil, MaxScoreSkew(time.Minute, time.Second, false))
+	p := skewed.InsertAsync([]common.KeyScoreMember{{Key: "foo", Score: float64(time.Now().Add(time.Hour).Unix()), Member: "a"}})
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("expected a rejected write to resolve right away")
+	}
+	if _, err := p.Wait(); err == nil {
+		t.Errorf("expected a score skew error, got none")
+	}
+}
+
+func TestInsertAsyncOverloaded(t *testing.T) {
+	var (
+		fake = clustertest.New()
+		farm = New([]cluster.Cluster{fake}, 1, SendAllReadAll, NoRepairs, nil, AsyncInserts(1, 1))
+	)
+	fake.Delay(clustertest.Insert, 50*time.Millisecond)
+
+	// One write is sent, one waits for the worker, and the rest are rejected
+	// right away.
+	pending := make([]*PendingWrite, 4)
+	for i := range pending {
+		pending[i] = farm.InsertAsync([]common.KeyScoreMember{{Key: "foo", Score: float64(i), Member: string(rune('a' + i))}})
+		time.Sleep(5 * time.Millisecond) // for the worker to pick up the first
+	}
+	for i, p := range pending[2:] {
+		select {
+		case <-p.Done():
+		default:
+			t.Fatalf("write %d: expected to resolve right away", i+2)
+		}
+		if _, err := p.Wait(); err != ErrOverloaded {
+			t.Errorf("write %d: expected %v, got %v", i+2, ErrOverloaded, err)
+		}
+	}
+	for i, p := range pending[:2] {
+		if _, err := p.Wait(); err != nil {
+			t.Errorf("write %d: %s", i, err)
+		}
+	}
+
+	// Once the workers catch up, writes are accepted again.
+	if _, err := farm.InsertAsync([]common.KeyScoreMember{{Key: "foo", Score: 9, Member: "z"}}).Wait(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
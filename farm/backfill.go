@@ -0,0 +1,44 @@
+package farm
+
+import (
+	"github.com/soundcloud/roshi/common"
+)
+
+// Backfiller is told about the keys in which Selects detected divergences, so
+// that a walker may repair them ahead of the rest of the keyspace. Backfill
+// must not block, as it's called from the read path. See package backfill for
+// an implementation.
+type Backfiller interface {
+	Backfill(keys []string)
+}
+
+// BackfillDivergences causes the keys of every read repair request to be
+// passed to the Backfiller, before the request is handed to the repair
+// strategy, which may drop it. Divergences detected by the Selecters returned
+// by WithoutRepairs are passed, too, as nothing else repairs them until the
+// walker gets to them.
+func BackfillDivergences(b Backfiller) Option {
+	return func(f *Farm) { f.backfiller = b }
+}
+
+// backfilled wraps the repair strategy, so that it passes the keys of each
+// repair request to the Backfiller first, if one is configured.
+func (f *Farm) backfilled(repair coreRepairStrategy) coreRepairStrategy {
+	if f.backfiller == nil {
+		return repair
+	}
+	return func(kms []common.KeyMember) {
+		var (
+			keys = []string{}
+			seen = map[string]bool{}
+		)
+		for _, km := range kms {
+			if !seen[km.Key] {
+				seen[km.Key] = true
+				keys = append(keys, km.Key)
+			}
+		}
+		f.backfiller.Backfill(keys)
+		repair(kms)
+	}
+}
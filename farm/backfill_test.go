@@ -0,0 +1,71 @@
+package farm
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+type mockBackfiller struct {
+	sync.Mutex
+	keys []string
+}
+
+func (b *mockBackfiller) Backfill(keys []string) {
+	b.Lock()
+	defer b.Unlock()
+	b.keys = append(b.keys, keys...)
+}
+
+func (b *mockBackfiller) backfilled() []string {
+	b.Lock()
+	defer b.Unlock()
+	return append([]string{}, b.keys...)
+}
+
+func TestBackfillDivergences(t *testing.T) {
+	var (
+		backfiller = &mockBackfiller{}
+		clusters   = newMockClusters(3)
+		repairs    = int32(0)
+		farm       = New(clusters, len(clusters), SendAllReadAll, MockRepairs(&repairs), nil, BackfillDivergences(backfiller))
+	)
+	farm.Insert([]common.KeyScoreMember{
+		testingKeyScoreMember,
+		{Key: testingKeyScoreMember.Key, Score: 1, Member: "other"},
+	})
+
+	// Without divergences, nothing is backfilled.
+	if _, err := farm.SelectOffset([]string{"key"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if got := backfiller.backfilled(); len(got) != 0 {
+		t.Fatalf("expected no backfilled keys, got %v", got)
+	}
+
+	// A key with two divergent members is backfilled once, and repaired.
+	clusters[0].Delete([]common.KeyScoreMember{testingKeyScoreMember, {Key: "key", Score: 2, Member: "other"}})
+	if _, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []string{"key"}, backfiller.backfilled(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if expected, got := 2, int(atomic.LoadInt32(&repairs)); expected != got {
+		t.Fatalf("expected %d repairs, got %d", expected, got)
+	}
+
+	// Repair-exempt reads backfill, too.
+	if _, err := farm.WithoutRepairs().SelectOffset([]string{"key"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []string{"key", "key"}, backfiller.backfilled(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if expected, got := 2, int(atomic.LoadInt32(&repairs)); expected != got {
+		t.Fatalf("expected %d repairs, got %d", expected, got)
+	}
+}
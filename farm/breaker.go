@@ -0,0 +1,110 @@
+package farm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// ErrBreakerOpen is the error of a write to a cluster whose circuit breaker
+// is open. The write isn't sent to the cluster.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// BreakUnhealthyClusters is like TrackClusterHealth, and additionally keeps
+// a circuit breaker per cluster, driven by the health registry. The breaker
+// of a cluster opens when an operation against it fails while its average
+// error rate is at least maxErrorRate. While it's open, for the cooldown,
+// writes to the cluster fail with ErrBreakerOpen without being sent, and
+// reads skip it, unless every cluster they'd be sent to is open. Once the
+// cooldown is over, operations are sent to the cluster again, and the first
+// one that fails while the error rate is still high opens the breaker again.
+// See ValidBreaker.
+func BreakUnhealthyClusters(alpha, maxErrorRate float64, cooldown time.Duration) Option {
+	return func(f *Farm) {
+		TrackClusterHealth(alpha)(f)
+		f.health.maxErrorRate, f.health.cooldown = maxErrorRate, cooldown
+	}
+}
+
+// ValidBreaker returns an error if maxErrorRate and cooldown aren't valid
+// for BreakUnhealthyClusters.
+func ValidBreaker(maxErrorRate float64, cooldown time.Duration) error {
+	if maxErrorRate <= 0 || maxErrorRate > 1 {
+		return fmt.Errorf("circuit breaker error rate %v must be in (0, 1]", maxErrorRate)
+	}
+	if cooldown <= 0 {
+		return fmt.Errorf("circuit breaker cooldown %s must be positive", cooldown)
+	}
+	return nil
+}
+
+// trip opens the breaker of the cluster, if there's a breaker, its error
+// rate is high enough, and it isn't open already. It returns true if the
+// breaker was opened. Callers must hold the lock.
+func (h *clusterHealth) trip(index int) bool {
+	if h.maxErrorRate <= 0 || h.errorRate[index] < h.maxErrorRate {
+		return false
+	}
+	now := time.Now()
+	if h.isOpen(index, now) {
+		return false
+	}
+	h.openUntil[index] = now.Add(h.cooldown)
+	return true
+}
+
+// isOpen returns true if the breaker of the cluster is open at the time.
+// Callers must hold the lock.
+func (h *clusterHealth) isOpen(index int, now time.Time) bool {
+	return now.Before(h.openUntil[index])
+}
+
+// allow returns false if the breaker of the cluster is open.
+func (h *clusterHealth) allow(c cluster.Cluster) bool {
+	if h.maxErrorRate <= 0 {
+		return true
+	}
+	index := h.index(c)
+	if index < 0 {
+		return true
+	}
+	h.Lock()
+	defer h.Unlock()
+	return !h.isOpen(index, time.Now())
+}
+
+// closedLocked returns the positions of the candidates whose breaker is
+// closed at the time. Callers must hold the lock.
+func (h *clusterHealth) closedLocked(candidates []cluster.Cluster, now time.Time) []int {
+	closed := []int{}
+	for i, c := range candidates {
+		if index := h.index(c); index < 0 || !h.isOpen(index, now) {
+			closed = append(closed, i)
+		}
+	}
+	return closed
+}
+
+// broken returns, per cluster, whether its breaker is open, or nil if none
+// is, or if every one is, so that reads are still attempted.
+func (f *Farm) broken(clusters []cluster.Cluster) []bool {
+	if f.health == nil || f.health.maxErrorRate <= 0 {
+		return nil
+	}
+	f.health.Lock()
+	closed := f.health.closedLocked(clusters, time.Now())
+	f.health.Unlock()
+	if len(closed) <= 0 || len(closed) == len(clusters) {
+		return nil
+	}
+	broken := make([]bool, len(clusters))
+	for i := range broken {
+		broken[i] = true
+	}
+	for _, i := range closed {
+		broken[i] = false
+	}
+	return broken
+}
@@ -0,0 +1,98 @@
+package farm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestBreakUnhealthyClusters(t *testing.T) {
+	var (
+		fakes  = []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+		tuples = []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}
+	)
+	fakes[0].FailWith(clustertest.Insert, errors.New("unavailable"))
+	f := New(
+		[]cluster.Cluster{fakes[0], fakes[1], fakes[2]},
+		2,
+		SendAllReadAll,
+		NoRepairs,
+		nil,
+		BreakUnhealthyClusters(1, 0.5, 50*time.Millisecond),
+	)
+
+	// The failed insert opens the breaker of cluster 0.
+	if err := f.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !f.Health()[0].BreakerOpen {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout: the breaker of cluster 0 didn't open, %+v", f.Health())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if health := f.Health(); health[1].BreakerOpen || health[2].BreakerOpen {
+		t.Fatalf("expected only the breaker of cluster 0 to be open, got %+v", health)
+	}
+
+	// While it's open, writes fail without being sent, and reads skip it.
+	result, err := f.InsertVerbose(tuples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Failed[0]; got != ErrBreakerOpen {
+		t.Errorf("expected cluster 0 to fail with %v, got %v", ErrBreakerOpen, got)
+	}
+	if expected, got := 1, fakes[0].CallCount(clustertest.Insert); expected != got {
+		t.Errorf("expected %d insert sent to cluster 0, got %d", expected, got)
+	}
+	if _, err := f.SelectOffset([]string{"foo"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if got := fakes[0].CallCount(clustertest.SelectOffset); got != 0 {
+		t.Errorf("expected no select sent to cluster 0, got %d", got)
+	}
+	if got := fakes[1].CallCount(clustertest.SelectOffset); got != 1 {
+		t.Errorf("expected a select sent to cluster 1, got %d", got)
+	}
+
+	// After the cooldown, the cluster gets traffic again.
+	time.Sleep(50 * time.Millisecond)
+	if f.Health()[0].BreakerOpen {
+		t.Fatalf("expected the breaker of cluster 0 to be closed after the cooldown")
+	}
+	fakes[0].FailWith(clustertest.Insert, nil)
+	if err := f.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for fakes[0].CallCount(clustertest.Insert) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout: the insert wasn't sent to cluster 0")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestValidBreaker(t *testing.T) {
+	for _, tc := range []struct {
+		rate     float64
+		cooldown time.Duration
+		valid    bool
+	}{
+		{0.5, time.Second, true},
+		{1, time.Second, true},
+		{0, time.Second, false},
+		{1.5, time.Second, false},
+		{0.5, 0, false},
+	} {
+		if err := ValidBreaker(tc.rate, tc.cooldown); (err == nil) != tc.valid {
+			t.Errorf("%v, %s: expected valid %v, got %v", tc.rate, tc.cooldown, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package farm
+
+import (
+	"sort"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Buckets describes a family of time-bucketed keys, like metric:2024-06-01,
+// metric:2024-06-02, and so on. Each key of the family holds the tuples of
+// one period of time, which keeps keys small, and lets old periods expire as
+// a whole.
+type Buckets struct {
+	Period time.Duration // the time span of each key, e.g. 24 * time.Hour
+	Layout string        // the time layout of the key suffix, e.g. "2006-01-02"
+}
+
+// Key returns the key of the family holding the time t: the family name, a
+// colon, and the start of the period, in UTC, formatted with the layout.
+func (b Buckets) Key(family string, t time.Time) string {
+	return family + ":" + t.UTC().Truncate(b.Period).Format(b.Layout)
+}
+
+// Keys returns the keys of the family covering the time span from..to,
+// newest first.
+func (b Buckets) Keys(family string, from, to time.Time) []string {
+	keys := []string{}
+	for t := to.UTC().Truncate(b.Period); !t.Before(from.UTC().Truncate(b.Period)); t = t.Add(-b.Period) {
+		keys = append(keys, b.Key(family, t))
+	}
+	return keys
+}
+
+// SelectBuckets selects the keys of the family covering the time span
+// from..to as a single, logical key. The tuples of every key are merged,
+// ordered by descending score, and paginated with offset and limit. Each
+// tuple keeps the key it was selected from.
+func SelectBuckets(s Selecter, b Buckets, family string, from, to time.Time, offset, limit int) ([]common.KeyScoreMember, error) {
+	results, err := s.SelectOffset(b.Keys(family, from, to), 0, offset+limit)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := []common.KeyScoreMember{}
+	for _, tuples := range results {
+		merged = append(merged, tuples...)
+	}
+	sort.Sort(byScoreDescending(merged))
+
+	if len(merged) < offset {
+		return []common.KeyScoreMember{}, nil
+	}
+	merged = merged[offset:]
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged, nil
+}
+
+// byScoreDescending orders tuples by descending score. Ties are broken by
+// descending member, then key, so the order is stable across requests.
+type byScoreDescending []common.KeyScoreMember
+
+func (a byScoreDescending) Len() int           { return len(a) }
+func (a byScoreDescending) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byScoreDescending) Less(i, j int) bool { return a[i].Before(a[j]) }
@@ -0,0 +1,59 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestBucketsKeys(t *testing.T) {
+	var (
+		b    = Buckets{Period: 24 * time.Hour, Layout: "2006-01-02"}
+		from = time.Date(2024, 5, 30, 18, 0, 0, 0, time.UTC)
+		to   = time.Date(2024, 6, 1, 6, 0, 0, 0, time.UTC)
+	)
+	if expected, got := "metric:2024-06-01", b.Key("metric", to); expected != got {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if expected, got := []string{
+		"metric:2024-06-01",
+		"metric:2024-05-31",
+		"metric:2024-05-30",
+	}, b.Keys("metric", from, to); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if got := b.Keys("metric", to, from); len(got) != 0 {
+		t.Errorf("expected no keys for an inverted span, got %v", got)
+	}
+}
+
+func TestSelectBuckets(t *testing.T) {
+	clusters := newMockClusters(2)
+	farm := New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+
+	var (
+		b   = Buckets{Period: 24 * time.Hour, Layout: "2006-01-02"}
+		day = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	)
+	farm.Insert([]common.KeyScoreMember{
+		{Key: "metric:2024-06-01", Score: 5, Member: "e"},
+		{Key: "metric:2024-06-01", Score: 3, Member: "c"},
+		{Key: "metric:2024-05-31", Score: 4, Member: "d"},
+		{Key: "metric:2024-05-31", Score: 2, Member: "b"},
+		{Key: "metric:2024-05-30", Score: 1, Member: "a"},
+	})
+
+	got, err := SelectBuckets(farm, b, "metric", day.Add(-24*time.Hour), day, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []common.KeyScoreMember{
+		{Key: "metric:2024-05-31", Score: 4, Member: "d"},
+		{Key: "metric:2024-06-01", Score: 3, Member: "c"},
+		{Key: "metric:2024-05-31", Score: 2, Member: "b"},
+	}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
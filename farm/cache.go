@@ -0,0 +1,164 @@
+package farm
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// Cache holds the SelectOffset results of the most recently read keys in
+// memory, so that very hot keys may be served without a round-trip to the
+// clusters. Entries are invalidated by the clusters when their keys are
+// modified, by any client; pass Invalidate to cluster.Tracking for every
+// cluster of the farm, and the Cache to the farm with ClientSideCache.
+//
+// A Select which is served from the Cache doesn't detect divergences between
+// the clusters, so it doesn't issue read repairs.
+type Cache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element // of *cacheEntry
+	lru     *list.List               // most recently used first
+	pending map[string]uint64        // key: token of the miss which may fill it
+	token   uint64
+}
+
+type cacheEntry struct {
+	key           string
+	offset, limit int
+	tuples        []common.KeyScoreMember
+}
+
+// NewCache returns an empty Cache, which holds up to maxKeys keys. When it's
+// full, the least recently used key is evicted.
+func NewCache(maxKeys int) *Cache {
+	return &Cache{
+		max:     maxKeys,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		pending: map[string]uint64{},
+	}
+}
+
+// ClientSideCache causes the SelectOffset results of the farm to be served
+// from, and stored in, the Cache. Writes via the farm invalidate the keys
+// they touch before they return, so a client reads its own writes.
+func ClientSideCache(c *Cache) Option {
+	return func(f *Farm) { f.cache = c }
+}
+
+// Invalidate removes the keys from the Cache. Nil keys remove every key.
+// Invalidate is safe for concurrent use; pass it to cluster.Tracking.
+func (c *Cache) Invalidate(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if keys == nil {
+		c.entries = map[string]*list.Element{}
+		c.lru.Init()
+		c.pending = map[string]uint64{}
+		return
+	}
+	for _, key := range keys {
+		if e, ok := c.entries[key]; ok {
+			c.lru.Remove(e)
+			delete(c.entries, key)
+		}
+		delete(c.pending, key) // a concurrent miss may have read a stale value
+	}
+}
+
+// selectOffset serves the keys from the Cache where possible, and the rest
+// from the Selecter, storing their results, unless they may be partial.
+func (c *Cache) selectOffset(s Selecter, keys []string, offset, limit int, instr instrumentation.SelectInstrumentation, complete func() bool) (map[string][]common.KeyScoreMember, error) {
+	result, misses, tokens := c.lookup(keys, offset, limit)
+	instr.SelectCacheHits(len(keys) - len(misses))
+	instr.SelectCacheMisses(len(misses))
+	if len(misses) <= 0 {
+		return result, nil
+	}
+
+	selected, err := s.SelectOffset(misses, offset, limit)
+	storable := selected
+	if !complete() {
+		storable = nil // release the tokens, but store nothing
+	}
+	c.store(storable, tokens, offset, limit) // releases the tokens, even on error
+	if err != nil {
+		return nil, err
+	}
+	for key, tuples := range selected {
+		result[key] = tuples
+	}
+	return result, nil
+}
+
+// lookup returns copies of the cached results of the keys, and the keys
+// which missed. Each miss is registered as pending, with a token that's
+// required to store its result. If the key is invalidated in the meantime,
+// the token is revoked, and the possibly stale result isn't stored.
+func (c *Cache) lookup(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, []string, map[string]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		result = make(map[string][]common.KeyScoreMember, len(keys))
+		misses = []string{}
+		tokens = map[string]uint64{}
+	)
+	for _, key := range keys {
+		if e, ok := c.entries[key]; ok {
+			if entry := e.Value.(*cacheEntry); entry.offset == offset && entry.limit == limit {
+				c.lru.MoveToFront(e)
+				result[key] = append(make([]common.KeyScoreMember, 0, len(entry.tuples)), entry.tuples...)
+				continue
+			}
+		}
+		if _, ok := tokens[key]; ok {
+			continue // duplicate key
+		}
+		c.token++
+		c.pending[key], tokens[key] = c.token, c.token
+		misses = append(misses, key)
+	}
+	return result, misses, tokens
+}
+
+// store caches the selected results of the keys whose tokens are still
+// valid, and releases all the tokens.
+func (c *Cache) store(selected map[string][]common.KeyScoreMember, tokens map[string]uint64, offset, limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, token := range tokens {
+		if c.pending[key] != token {
+			continue // invalidated, or taken over by a later miss
+		}
+		delete(c.pending, key)
+		tuples, ok := selected[key]
+		if !ok || c.max <= 0 {
+			continue
+		}
+		tuples = append(make([]common.KeyScoreMember, 0, len(tuples)), tuples...)
+		if e, ok := c.entries[key]; ok {
+			*e.Value.(*cacheEntry) = cacheEntry{key, offset, limit, tuples}
+			c.lru.MoveToFront(e)
+			continue
+		}
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key, offset, limit, tuples})
+		for c.lru.Len() > c.max {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+}
+
+// keysOf returns the keys of the tuples, which may contain duplicates. It
+// never returns nil, which would invalidate every key.
+func keysOf(tuples []common.KeyScoreMember) []string {
+	keys := make([]string, 0, len(tuples))
+	for _, tuple := range tuples {
+		keys = append(keys, tuple.Key)
+	}
+	return keys
+}
@@ -0,0 +1,113 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestClientSideCache(t *testing.T) {
+	var (
+		fake  = clustertest.New()
+		cache = NewCache(1)
+		farm  = New([]cluster.Cluster{fake}, 1, SendAllReadAll, NoRepairs, nil, ClientSideCache(cache))
+		foo   = common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"}
+		bar   = common.KeyScoreMember{Key: "bar", Score: 1, Member: "b"}
+	)
+	if err := fake.Insert([]common.KeyScoreMember{foo, bar}); err != nil {
+		t.Fatal(err)
+	}
+
+	selects := 0
+	check := func(key string, expected []common.KeyScoreMember, expectedSelects int) {
+		got, err := farm.SelectOffset([]string{key}, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got[key]) {
+			t.Errorf("%s: expected %v, got %v", key, expected, got[key])
+		}
+		selects += expectedSelects
+		if expected, got := selects, fake.CallCount(clustertest.SelectOffset); expected != got {
+			t.Errorf("%s: expected %d Select(s) of the cluster, got %d", key, expected, got)
+		}
+	}
+
+	check("foo", []common.KeyScoreMember{foo}, 1) // miss
+	check("foo", []common.KeyScoreMember{foo}, 0) // hit
+
+	// Another client writes, and the cluster invalidates the key.
+	foo2 := common.KeyScoreMember{Key: "foo", Score: 2, Member: "a"}
+	fake.Insert([]common.KeyScoreMember{foo2})
+	check("foo", []common.KeyScoreMember{foo}, 0) // not yet invalidated
+	cache.Invalidate([]string{"foo"})
+	check("foo", []common.KeyScoreMember{foo2}, 1)
+
+	// Writes via the farm invalidate immediately.
+	foo3 := common.KeyScoreMember{Key: "foo", Score: 3, Member: "a"}
+	if err := farm.Insert([]common.KeyScoreMember{foo3}); err != nil {
+		t.Fatal(err)
+	}
+	check("foo", []common.KeyScoreMember{foo3}, 1)
+
+	// The least recently used key is evicted.
+	check("bar", []common.KeyScoreMember{bar}, 1)
+	check("bar", []common.KeyScoreMember{bar}, 0)
+	check("foo", []common.KeyScoreMember{foo3}, 1)
+
+	// Nil keys invalidate everything.
+	cache.Invalidate(nil)
+	check("foo", []common.KeyScoreMember{foo3}, 1)
+}
+
+func TestCacheInvalidatedWhenEveryClusterApplied(t *testing.T) {
+	var (
+		fast, slow = clustertest.New(), clustertest.New()
+		cache      = NewCache(10)
+		farm       = New([]cluster.Cluster{fast, slow}, 1, SendOneReadOne, NoRepairs, nil, ClientSideCache(cache))
+		foo        = common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"}
+	)
+	slow.Delay(clustertest.Insert, 50*time.Millisecond)
+	if err := farm.Insert([]common.KeyScoreMember{foo}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A read before the slow cluster applies the write may cache its state
+	// from before it.
+	_, _, tokens := cache.lookup([]string{"foo"}, 0, 10)
+	cache.store(map[string][]common.KeyScoreMember{"foo": {}}, tokens, 0, 10)
+	if _, misses, _ := cache.lookup([]string{"foo"}, 0, 10); len(misses) != 0 {
+		t.Fatalf("expected a hit, got a miss")
+	}
+
+	// Once it has, the key is invalidated again.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, misses, _ := cache.lookup([]string{"foo"}, 0, 10); len(misses) == 1 {
+			return
+		}
+	}
+	t.Errorf("expected a miss once every cluster applied the write, got a hit")
+}
+
+func TestCacheInvalidatedDuringMiss(t *testing.T) {
+	cache := NewCache(10)
+	_, misses, tokens := cache.lookup([]string{"foo"}, 0, 10)
+	if expected, got := []string{"foo"}, misses; !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected misses %v, got %v", expected, got)
+	}
+
+	// The key is modified while its Select is in flight, so the result may
+	// be stale, and mustn't be stored.
+	cache.Invalidate([]string{"foo"})
+	cache.store(map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"}},
+	}, tokens, 0, 10)
+
+	if _, misses, _ := cache.lookup([]string{"foo"}, 0, 10); len(misses) != 1 {
+		t.Errorf("expected a miss, got a hit")
+	}
+}
@@ -0,0 +1,290 @@
+package farm
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// TrackClusterHealth causes the farm to keep a health registry of its
+// clusters. Every Select and every write against a cluster updates an
+// exponentially-weighted moving average of its latency and error rate, with
+// the given smoothing factor alpha, which must be in (0, 1]; see
+// ValidHealthAlpha. Larger values of alpha react faster to changes. The averages are reported via
+// instrumentation, and by Health.
+func TrackClusterHealth(alpha float64) Option {
+	return func(f *Farm) {
+		if f.health != nil {
+			f.health.alpha = alpha // shared with other options
+			return
+		}
+		f.health = newClusterHealth(f.clusters, alpha, f.instrumentation)
+	}
+}
+
+// PreferHealthyClusters is like TrackClusterHealth, and additionally causes
+// read strategies that send to a subset of clusters (SendOneReadOne, and
+// SendVarReadFirstLinger when it may not send to all) to prefer the
+// currently fastest and healthiest clusters, rather than a random one.
+func PreferHealthyClusters(alpha float64) Option {
+	return func(f *Farm) {
+		TrackClusterHealth(alpha)(f)
+		f.preferHealthy = true
+	}
+}
+
+// ValidHealthAlpha returns an error if alpha isn't a valid smoothing factor
+// for TrackClusterHealth and PreferHealthyClusters.
+func ValidHealthAlpha(alpha float64) error {
+	if alpha <= 0 || alpha > 1 {
+		return fmt.Errorf("cluster health smoothing factor %v must be in (0, 1]", alpha)
+	}
+	return nil
+}
+
+// ClusterHealth is the state of a cluster in the health registry of a farm.
+type ClusterHealth struct {
+	Index       int           `json:"index"`
+	Observed    bool          `json:"observed"`     // false until the first operation completes
+	Latency     time.Duration `json:"latency"`      // moving average
+	ErrorRate   float64       `json:"error_rate"`   // moving average, 0..1
+	Cost        time.Duration `json:"cost"`         // the health score; lower is healthier
+	Maintenance bool          `json:"maintenance"`  // see Farm.SetMaintenance
+	BreakerOpen bool          `json:"breaker_open"` // see BreakUnhealthyClusters
+}
+
+// Health returns the state of every cluster in the health registry, in
+// order, or nil if cluster health isn't tracked and no cluster is in
+// maintenance. The Cost of a cluster is the single score by which clusters
+// are compared: its average latency, scaled up by the likelihood of an
+// error.
+func (f *Farm) Health() []ClusterHealth {
+	var (
+		maintenance   = f.Maintenance()
+		inMaintenance = false
+	)
+	for _, on := range maintenance {
+		inMaintenance = inMaintenance || on
+	}
+	var health []ClusterHealth
+	switch {
+	case f.health != nil:
+		health = f.health.snapshot()
+	case inMaintenance:
+		health = make([]ClusterHealth, len(f.clusters))
+		for index := range health {
+			health[index].Index = index
+		}
+	default:
+		return nil
+	}
+	for index := range health {
+		health[index].Maintenance = maintenance[index]
+	}
+	return health
+}
+
+// clusterHealth is the health registry of a farm. It tracks moving averages
+// of the latency and error rate of the operations against each cluster. It's
+// safe for concurrent use.
+type clusterHealth struct {
+	sync.Mutex
+	clusters  []cluster.Cluster
+	alpha     float64
+	latency   []float64 // nanoseconds
+	errorRate []float64 // 0..1
+	observed  []bool
+	instr     instrumentation.SelectInstrumentation
+
+	// Circuit breaker, if any; see BreakUnhealthyClusters.
+	maxErrorRate float64 // 0 for no breaker
+	cooldown     time.Duration
+	openUntil    []time.Time
+}
+
+func newClusterHealth(clusters []cluster.Cluster, alpha float64, instr instrumentation.SelectInstrumentation) *clusterHealth {
+	return &clusterHealth{
+		clusters:  clusters,
+		alpha:     alpha,
+		latency:   make([]float64, len(clusters)),
+		errorRate: make([]float64, len(clusters)),
+		observed:  make([]bool, len(clusters)),
+		instr:     instr,
+		openUntil: make([]time.Time, len(clusters)),
+	}
+}
+
+// observe records the outcome of a single operation against the cluster.
+func (h *clusterHealth) observe(c cluster.Cluster, d time.Duration, failed bool) {
+	index := h.index(c)
+	if index < 0 {
+		return
+	}
+
+	errorValue := 0.0
+	if failed {
+		errorValue = 1.0
+	}
+
+	h.Lock()
+	if !h.observed[index] {
+		h.latency[index] = float64(d.Nanoseconds())
+		h.errorRate[index] = errorValue
+		h.observed[index] = true
+	} else {
+		h.latency[index] += h.alpha * (float64(d.Nanoseconds()) - h.latency[index])
+		h.errorRate[index] += h.alpha * (errorValue - h.errorRate[index])
+	}
+	latency, errorRate := time.Duration(h.latency[index]), h.errorRate[index]
+	tripped := failed && h.trip(index)
+	h.Unlock()
+
+	if tripped {
+		log.Printf("cluster %d: error rate %.2f, circuit breaker open for %s", index, errorRate, h.cooldown)
+	}
+
+	go h.instr.SelectClusterHealth(index, latency, errorRate)
+}
+
+// healthExploreRate is the share of choices made by best at random, so that
+// the averages of clusters which aren't chosen otherwise, e.g. after they
+// recovered from a slow period, are kept up to date.
+const healthExploreRate = 0.05
+
+// best returns the index of the candidate cluster to use, by the power of
+// two choices: of two random candidates, the one with the lower cost.
+// Picking the cheaper of two, rather than the cheapest of all, spreads the
+// load across clusters with similar costs, instead of herding every read
+// onto whichever cluster was cheapest last. Clusters which have never been
+// observed are preferred, so that every cluster is measured at least once.
+func (h *clusterHealth) best(candidates []cluster.Cluster) int {
+	h.Lock()
+	defer h.Unlock()
+
+	for i, c := range candidates {
+		if index := h.index(c); index >= 0 && !h.observed[index] {
+			return i
+		}
+	}
+
+	// Clusters with an open circuit breaker are only chosen if every
+	// candidate's is open.
+	choices := h.closedLocked(candidates, time.Now())
+	if len(choices) <= 0 {
+		for i := range candidates {
+			choices = append(choices, i)
+		}
+	}
+	if len(choices) < 2 || rand.Float64() < healthExploreRate {
+		return choices[rand.Intn(len(choices))]
+	}
+
+	a, b := rand.Intn(len(choices)), rand.Intn(len(choices)-1)
+	if b >= a {
+		b++
+	}
+	a, b = choices[a], choices[b]
+	indexA, indexB := h.index(candidates[a]), h.index(candidates[b])
+	if indexA < 0 || indexB < 0 {
+		return a
+	}
+	if h.cost(indexB) < h.cost(indexA) {
+		return b
+	}
+	return a
+}
+
+// cost approximates the expected latency of a successful operation against
+// the cluster: its average latency, scaled up by the likelihood of an error.
+// Callers must hold the lock.
+func (h *clusterHealth) cost(index int) float64 {
+	successRate := 1 - h.errorRate[index]
+	if successRate < 0.01 {
+		successRate = 0.01
+	}
+	return h.latency[index] / successRate
+}
+
+// snapshot returns the current state of every cluster.
+func (h *clusterHealth) snapshot() []ClusterHealth {
+	h.Lock()
+	defer h.Unlock()
+
+	var (
+		health = make([]ClusterHealth, len(h.clusters))
+		now    = time.Now()
+	)
+	for index := range h.clusters {
+		health[index] = ClusterHealth{Index: index, Observed: h.observed[index], BreakerOpen: h.isOpen(index, now)}
+		if h.observed[index] {
+			health[index].Latency = time.Duration(h.latency[index])
+			health[index].ErrorRate = h.errorRate[index]
+			health[index].Cost = time.Duration(h.cost(index))
+		}
+	}
+	return health
+}
+
+func (h *clusterHealth) index(c cluster.Cluster) int {
+	return clusterIndex(h.clusters, c)
+}
+
+// pick returns the index of the cluster to use for a read that's sent to a
+// single cluster. Clusters with an open circuit breaker are avoided.
+func (f *Farm) pick() int {
+	if f.preferHealthy {
+		return f.health.best(f.clusters)
+	}
+	if f.health != nil && f.health.maxErrorRate > 0 {
+		f.health.Lock()
+		closed := f.health.closedLocked(f.clusters, time.Now())
+		f.health.Unlock()
+		if len(closed) > 0 {
+			return closed[rand.Intn(len(closed))]
+		}
+	}
+	return rand.Intn(len(f.clusters))
+}
+
+// observing wraps a Select function, so that the latency and outcome of each
+// invocation are recorded in the health registry, and in the trace of the
+// op, if any, and the keys it returned in the reads of a Fallback view. If
+// none is kept, fn is returned unmodified.
+func (f *Farm) observing(fn func(cluster.Cluster) <-chan cluster.Element) func(cluster.Cluster) <-chan cluster.Element {
+	if reads := f.reads; reads != nil {
+		unrecorded := fn
+		fn = func(c cluster.Cluster) <-chan cluster.Element { return reads.observe(unrecorded(c)) }
+	}
+	if f.health == nil && f.trace == nil {
+		return fn
+	}
+	return func(c cluster.Cluster) <-chan cluster.Element {
+		var (
+			began = time.Now()
+			src   = fn(c)
+			dst   = make(chan cluster.Element)
+		)
+		go func() {
+			defer close(dst)
+			var failed error
+			for e := range src {
+				if e.Error != nil && failed == nil {
+					failed = e.Error
+				}
+				dst <- e
+			}
+			if f.health != nil {
+				f.health.observe(c, time.Since(began), failed != nil)
+			}
+			if f.trace != nil {
+				f.trace.observe(c, time.Since(began), failed)
+			}
+		}()
+		return dst
+	}
+}
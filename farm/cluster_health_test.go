@@ -0,0 +1,108 @@
+package farm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// choices returns how often best chose each candidate in n choices.
+func choices(h *clusterHealth, candidates []cluster.Cluster, n int) []int {
+	counts := make([]int, len(candidates))
+	for i := 0; i < n; i++ {
+		counts[h.best(candidates)]++
+	}
+	return counts
+}
+
+func TestClusterHealth(t *testing.T) {
+	clusters := newMockClusters(3)
+	h := newClusterHealth(clusters, 0.5, instrumentation.NopInstrumentation{})
+
+	// Unobserved clusters are always preferred.
+	h.observe(clusters[0], 50*time.Millisecond, false)
+	h.observe(clusters[1], 10*time.Millisecond, false)
+	if best := h.best(clusters); best != 2 {
+		t.Fatalf("expected unobserved cluster 2 to be best, got %d", best)
+	}
+
+	// Of two clusters, the cheaper one is chosen, but for exploration. A fast
+	// but failing cluster loses to a slower, healthy one.
+	h.observe(clusters[2], 1*time.Millisecond, true)
+	h.observe(clusters[2], 1*time.Millisecond, true)
+	pair := clusters[1:]
+	if counts := choices(h, pair, 1000); counts[0] < 900 {
+		t.Fatalf("expected cluster 1 to be chosen mostly, got %v", counts)
+	} else if counts[1] == 0 {
+		t.Fatalf("expected cluster 2 to be explored, got %v", counts)
+	}
+
+	// Latency is smoothed: cluster 1 needs to be consistently slow before
+	// cluster 0 is preferred.
+	pair = clusters[:2]
+	h.observe(clusters[1], 80*time.Millisecond, false)
+	if counts := choices(h, pair, 1000); counts[1] < 900 {
+		t.Fatalf("expected cluster 1 (avg 45ms) to still be chosen mostly, got %v", counts)
+	}
+	h.observe(clusters[1], 80*time.Millisecond, false)
+	if counts := choices(h, pair, 1000); counts[0] < 900 {
+		t.Fatalf("expected cluster 0 (avg 50ms) to be chosen mostly, got %v", counts)
+	}
+
+	// Of all three, the costliest is only chosen for exploration, and the
+	// others share the rest.
+	counts := choices(h, clusters, 3000)
+	if counts[2] > 300 || counts[0] < 1000 || counts[1] < 500 {
+		t.Fatalf("expected clusters 0 and 1 to share most choices, got %v", counts)
+	}
+}
+
+func TestValidHealthAlpha(t *testing.T) {
+	for alpha, valid := range map[float64]bool{-1: false, 0: false, 0.5: true, 1: true, 1.5: false} {
+		if err := ValidHealthAlpha(alpha); valid != (err == nil) {
+			t.Errorf("%v: expected valid %v, got %v", alpha, valid, err)
+		}
+	}
+}
+
+func TestClusterHealthWrites(t *testing.T) {
+	clusters := []cluster.Cluster{newMockCluster(), newFailingMockCluster(), newMockCluster()}
+	farm := New(clusters, 2, SendAllReadAll, NoRepairs, nil, TrackClusterHealth(1))
+
+	if health := farm.Health(); len(health) != len(clusters) || health[0].Observed {
+		t.Fatalf("expected %d unobserved cluster(s), got %+v", len(clusters), health)
+	}
+
+	// Every cluster's write is observed, even after the quorum is reached.
+	farm.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}})
+	var health []ClusterHealth
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if health = farm.Health(); health[0].Observed && health[1].Observed && health[2].Observed {
+			break
+		}
+	}
+	for index, expected := range []float64{0, 1, 0} {
+		if !health[index].Observed {
+			t.Errorf("cluster %d: expected to be observed", index)
+			continue
+		}
+		if got := health[index].ErrorRate; expected != got {
+			t.Errorf("cluster %d: expected error rate %.1f, got %.1f", index, expected, got)
+		}
+	}
+	// Latencies vary, so compare each cost to the cluster's own latency.
+	if health[0].Cost != health[0].Latency {
+		t.Errorf("expected healthy cluster to cost its latency %v, got %v", health[0].Latency, health[0].Cost)
+	}
+	if !(health[1].Cost > health[1].Latency) {
+		t.Errorf("expected failing cluster to cost more than its latency %v, got %v", health[1].Latency, health[1].Cost)
+	}
+
+	// Without the option, there's no registry.
+	if health := New(clusters, 2, SendAllReadAll, NoRepairs, nil).Health(); health != nil {
+		t.Errorf("expected no health without TrackClusterHealth, got %+v", health)
+	}
+}
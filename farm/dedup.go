@@ -0,0 +1,56 @@
+package farm
+
+import (
+	"github.com/soundcloud/roshi/common"
+)
+
+// DedupWindow drops inserts of members which are already inserted with a
+// score less than window lower than the score of the insert. At-least-once
+// pipelines may deliver the same event several times, with slightly
+// different scores, e.g. timestamps taken at each delivery; with
+// DedupWindow, such duplicates leave the member untouched, rather than
+// bumping its score and being propagated by read repair.
+//
+// Each insert first reads the newest scores of its members from every
+// cluster, and the duplicates are dropped before the insert is sent to any
+// of them, so every cluster gets the same writes. Read repairs are never
+// dropped, as they don't go through the window. Deletes, and inserts of
+// members whose newest score is a delete, are unaffected. If no cluster
+// responds to the read, nothing is dropped. Zero or a negative window
+// disables the rule.
+func DedupWindow(window float64) Option {
+	return func(f *Farm) {
+		f.dedupWindow = 0
+		if window > 0 {
+			f.dedupWindow = window
+		}
+	}
+}
+
+// dedup returns the tuples without those within the DedupWindow of the
+// newest score of their member. The passed slice is never modified.
+func (f *Farm) dedup(tuples []common.KeyScoreMember) []common.KeyScoreMember {
+	if f.dedupWindow <= 0 || len(tuples) <= 0 {
+		return tuples
+	}
+	keyMembers := make([]common.KeyMember, len(tuples))
+	for i, tuple := range tuples {
+		keyMembers[i] = common.KeyMember{Key: tuple.Key, Member: tuple.Member}
+	}
+	keyMembers = f.splits.splitKeyMembers(keyMembers)
+	newest, err := f.newest(keyMembers)
+	if err != nil {
+		f.logf("DedupWindow: %s; not deduplicating %d tuple(s)", err, len(tuples))
+		return tuples
+	}
+
+	deduped := make([]common.KeyScoreMember, 0, len(tuples))
+	for i, tuple := range tuples {
+		presence, ok := newest[keyMembers[i]]
+		if ok && presence.Inserted && tuple.Score >= presence.Score && tuple.Score-presence.Score < f.dedupWindow {
+			continue
+		}
+		deduped = append(deduped, tuple)
+	}
+	return deduped
+}
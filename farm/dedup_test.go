@@ -0,0 +1,71 @@
+package farm
+
+import (
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestDedupWindow(t *testing.T) {
+	var (
+		fakes = []*clustertest.Fake{clustertest.New(), clustertest.New()}
+		f     = New([]cluster.Cluster{fakes[0], fakes[1]}, 2, SendAllReadAll, NoRepairs, nil, DedupWindow(10))
+		km    = common.KeyMember{Key: "foo", Member: "alpha"}
+	)
+	for i, step := range []struct {
+		write    func([]common.KeyScoreMember) error
+		score    float64
+		expected cluster.Presence
+	}{
+		{f.Insert, 100, cluster.Presence{Present: true, Inserted: true, Score: 100}},
+		{f.Insert, 100, cluster.Presence{Present: true, Inserted: true, Score: 100}},  // duplicate
+		{f.Insert, 109, cluster.Presence{Present: true, Inserted: true, Score: 100}},  // within the window
+		{f.Insert, 110, cluster.Presence{Present: true, Inserted: true, Score: 110}},  // outside the window
+		{f.Delete, 111, cluster.Presence{Present: true, Inserted: false, Score: 111}}, // deletes aren't deduplicated
+		{f.Insert, 112, cluster.Presence{Present: true, Inserted: true, Score: 112}},  // nor inserts after deletes
+	} {
+		if err := step.write([]common.KeyScoreMember{{Key: km.Key, Score: step.score, Member: km.Member}}); err != nil {
+			t.Fatal(err)
+		}
+		for j, fake := range fakes {
+			presence, err := fake.Score([]common.KeyMember{km})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := presence[km]; step.expected != got {
+				t.Errorf("step %d: cluster %d: expected %+v, got %+v", i+1, j, step.expected, got)
+			}
+		}
+	}
+}
+
+func TestDedupWindowConvergence(t *testing.T) {
+	// The clusters diverged by less than the window, e.g. because one of
+	// them missed a write. Read repairs aren't deduplicated, so they still
+	// converge, and later duplicates are dropped by every cluster alike.
+	var (
+		fakes = []*clustertest.Fake{clustertest.New(), clustertest.New()}
+		f     = New([]cluster.Cluster{fakes[0], fakes[1]}, 2, SendAllReadAll, AllRepairs, nil, DedupWindow(10))
+		km    = common.KeyMember{Key: "foo", Member: "alpha"}
+	)
+	fakes[0].Insert([]common.KeyScoreMember{{Key: km.Key, Score: 100, Member: km.Member}})
+	fakes[1].Insert([]common.KeyScoreMember{{Key: km.Key, Score: 105, Member: km.Member}})
+
+	if _, err := f.SelectOffset([]string{km.Key}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Insert([]common.KeyScoreMember{{Key: km.Key, Score: 109, Member: km.Member}}); err != nil {
+		t.Fatal(err)
+	}
+	for i, fake := range fakes {
+		presence, err := fake.Score([]common.KeyMember{km})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := (cluster.Presence{Present: true, Inserted: true, Score: 105}), presence[km]; expected != got {
+			t.Errorf("cluster %d: expected %+v, got %+v", i, expected, got)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package farm
+
+import (
+	"fmt"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// DeletePrefix deletes every member of the key which starts with the prefix,
+// e.g. to clean up a class of malformed members written by a buggy producer.
+// Each cluster which implements cluster.PrefixDeleter deletes the matching
+// members it has, atomically. The union of those members, each with its
+// highest score, is then deleted from every cluster, so that clusters which
+// lacked or missed any of them get the deletes, too. The tuples are deleted
+// as found, without the write transform, and returned.
+//
+// The members of a split key are deleted from each of its subkeys. Members
+// inserted concurrently may or may not be deleted. The error of the
+// Delete is returned; clusters failing to delete by prefix are only logged,
+// unless they all fail.
+func (f *Farm) DeletePrefix(key, prefix string) ([]common.KeyScoreMember, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("empty prefix")
+	}
+
+	type response struct {
+		deleted []common.KeyScoreMember
+		err     error
+	}
+	responses := make(chan response, len(f.clusters))
+	for i, c := range f.clusters {
+		go func(i int, c cluster.Cluster) {
+			d, ok := c.(cluster.PrefixDeleter)
+			if !ok {
+				responses <- response{err: fmt.Errorf("cluster %d: deleting by prefix not supported", i)}
+				return
+			}
+			var deleted []common.KeyScoreMember
+			for _, physical := range f.splits.physical(key) {
+				d, err := d.DeletePrefix(physical, prefix)
+				if err != nil {
+					responses <- response{err: fmt.Errorf("cluster %d: %s", i, err)}
+					return
+				}
+				deleted = append(deleted, d...)
+			}
+			responses <- response{deleted: deleted}
+		}(i, c)
+	}
+
+	var (
+		scores = map[string]float64{} // member: highest score
+		failed = 0
+		err    error
+	)
+	for _ = range f.clusters {
+		r := <-responses
+		if r.err != nil {
+			f.logf("DeletePrefix %q %q: %s", key, prefix, r.err)
+			failed, err = failed+1, r.err
+			continue
+		}
+		for _, tuple := range r.deleted {
+			if score, ok := scores[tuple.Member]; !ok || tuple.Score > score {
+				scores[tuple.Member] = tuple.Score
+			}
+		}
+	}
+	if failed >= len(f.clusters) {
+		return nil, err
+	}
+
+	deleted := make([]common.KeyScoreMember, 0, len(scores))
+	for member, score := range scores {
+		deleted = append(deleted, common.KeyScoreMember{Key: key, Score: score, Member: member})
+	}
+	if len(deleted) <= 0 {
+		return deleted, nil
+	}
+	return deleted, f.deleteDirect(deleted)
+}
+
+// deleteDirect deletes the tuples from every cluster, as they are: unlike
+// Delete, without the write transform, which might rewrite the members into
+// ones that were never inserted.
+func (f *Farm) deleteDirect(tuples []common.KeyScoreMember) error {
+	_, err := f.write(
+		f.splits.split(tuples),
+		func(c cluster.Cluster, a []common.KeyScoreMember) error { return c.Delete(a) },
+		deleteInstrumentation{f.instrumentation},
+		false,
+	)
+	if err == nil {
+		f.notify(OpDelete, tuples)
+	}
+	return err
+}
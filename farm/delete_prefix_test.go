@@ -0,0 +1,105 @@
+package farm
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	fakes := []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+	for i, tuples := range [][]common.KeyScoreMember{
+		{{Key: "foo", Score: 2, Member: "bad:1"}, {Key: "foo", Score: 2, Member: "bad:2"}, {Key: "foo", Score: 3, Member: "good"}},
+		{{Key: "foo", Score: 1, Member: "bad:1"}, {Key: "foo", Score: 3, Member: "good"}},
+		{{Key: "foo", Score: 5, Member: "bad:2"}, {Key: "bar", Score: 1, Member: "bad:3"}},
+	} {
+		if err := fakes[i].Insert(tuples); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fakes[1].FailWith(clustertest.DeletePrefix, errors.New("unavailable"))
+
+	f := New([]cluster.Cluster{fakes[0], fakes[1], fakes[2]}, 3, SendAllReadAll, NoRepairs, nil)
+	deleted, err := f.DeletePrefix("foo", "bad:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(keyScoreMembers(deleted))
+	if expected, got := []common.KeyScoreMember{
+		{Key: "foo", Score: 5, Member: "bad:2"},
+		{Key: "foo", Score: 2, Member: "bad:1"},
+	}, deleted; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Every cluster has the deletes, even the one which failed to delete by
+	// prefix, and other members and keys are untouched.
+	for i, fake := range fakes {
+		presence, err := fake.Score([]common.KeyMember{
+			{Key: "foo", Member: "bad:1"},
+			{Key: "foo", Member: "bad:2"},
+			{Key: "foo", Member: "good"},
+			{Key: "bar", Member: "bad:3"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for keyMember, expected := range map[common.KeyMember]cluster.Presence{
+			{Key: "foo", Member: "bad:1"}: {Present: true, Inserted: false, Score: 2},
+			{Key: "foo", Member: "bad:2"}: {Present: true, Inserted: false, Score: 5},
+		} {
+			if got := presence[keyMember]; expected != got {
+				t.Errorf("cluster %d: %v: expected %+v, got %+v", i, keyMember, expected, got)
+			}
+		}
+		if i < 2 && !presence[common.KeyMember{Key: "foo", Member: "good"}].Inserted {
+			t.Errorf("cluster %d: good member was deleted", i)
+		}
+		if i == 2 && !presence[common.KeyMember{Key: "bar", Member: "bad:3"}].Inserted {
+			t.Errorf("cluster %d: member of another key was deleted", i)
+		}
+	}
+
+	for _, fake := range fakes {
+		fake.FailWith(clustertest.DeletePrefix, errors.New("unavailable"))
+	}
+	if _, err := f.DeletePrefix("foo", "bad:"); err == nil {
+		t.Errorf("expected error when every cluster fails, got none")
+	}
+}
+
+func TestDeletePrefixTransform(t *testing.T) {
+	fakes := []*clustertest.Fake{clustertest.New(), clustertest.New()}
+	for i, fake := range fakes {
+		if err := fake.Insert([]common.KeyScoreMember{{Key: "foo", Score: float64(2 - i), Member: "bad:1"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fakes[1].FailWith(clustertest.DeletePrefix, errors.New("unavailable"))
+
+	// The members found are deleted as they are, not as the write transform
+	// would rewrite them.
+	f := New(
+		[]cluster.Cluster{fakes[0], fakes[1]},
+		2,
+		SendAllReadAll,
+		NoRepairs,
+		nil,
+		WriteTransform(RenameMemberPrefix("bad:", "v2:")),
+	)
+	if _, err := f.DeletePrefix("foo", "bad:"); err != nil {
+		t.Fatal(err)
+	}
+	presence, err := fakes[1].Score([]common.KeyMember{{Key: "foo", Member: "bad:1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := (cluster.Presence{Present: true, Inserted: false, Score: 2}), presence[common.KeyMember{Key: "foo", Member: "bad:1"}]; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
@@ -0,0 +1,178 @@
+package farm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// SendAllReadDigests is a ReadStrategy that reads the data of offset-based
+// selects from a single cluster, chosen like SendOneReadOne, and only a
+// digest of the same page from every other cluster, via cluster.Digester.
+// Clusters whose digest disagrees with the data, and those which don't
+// implement cluster.Digester, are read in full, and the responses are
+// merged, and repaired, like with SendAllReadAll. Keys the first cluster
+// failed to return are read in full from every other cluster.
+//
+// It's as resilient to stale data as SendAllReadAll, but while the clusters
+// agree, only one of them transfers the page, which cuts bandwidth for large
+// limits. Disagreements cost a second round trip. SelectRange has no digest,
+// and is read like SendAllReadAll.
+func SendAllReadDigests(farm *Farm) Selecter { return sendAllReadDigests{farm} }
+
+type sendAllReadDigests struct{ *Farm }
+
+// SelectOffset implements farm.Selecter.
+func (s sendAllReadDigests) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return s.read(keys, offset, limit, false)
+}
+
+// SelectOffsetAscending implements farm.Selecter.
+func (s sendAllReadDigests) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return s.read(keys, offset, limit, true)
+}
+
+// SelectRange implements farm.Selecter.
+func (s sendAllReadDigests) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	return sendAllReadAll{s.Farm}.SelectRange(keys, start, stop, limit)
+}
+
+func (s sendAllReadDigests) read(keys []string, offset, limit int, ascending bool) (map[string][]common.KeyScoreMember, error) {
+	began := time.Now()
+	go func() {
+		s.Farm.instrumentation.SelectCall()
+		s.Farm.instrumentation.SelectKeys(len(keys))
+		s.Farm.instrumentation.SelectSendTo(len(s.Farm.clusters))
+	}()
+	defer func() { go s.Farm.instrumentation.SelectDuration(time.Since(began)) }()
+
+	selectFn := func(keys []string) func(cluster.Cluster) <-chan cluster.Element {
+		return s.Farm.observing(func(c cluster.Cluster) <-chan cluster.Element {
+			if ascending {
+				return c.SelectOffsetAscending(keys, offset, limit)
+			}
+			return c.SelectOffset(keys, offset, limit)
+		})
+	}
+
+	// Read the data from the first cluster, and the digests, or the data of
+	// those which can't digest, from the others. As with SendAllReadAll,
+	// error elements aren't included in the responses.
+	var (
+		firstResponseDuration time.Duration
+
+		blockingBegan = time.Now()
+		first         = s.Farm.pick()
+		data          = map[string][]common.KeyScoreMember{} // of the first cluster
+		digests       = make([]map[string]string, len(s.Farm.clusters))
+		responses     = map[string][][]common.KeyScoreMember{}
+		retrieved     = 0
+		mu            sync.Mutex
+		wg            sync.WaitGroup
+	)
+	gather := func(c cluster.Cluster, keys []string, isFirst bool) {
+		defer wg.Done()
+		for e := range selectFn(keys)(c) {
+			mu.Lock()
+			if e.Error != nil {
+				s.Farm.logf("SendAllReadDigests partial error: %s", e.Error)
+				go s.Farm.instrumentation.SelectPartialError()
+			} else {
+				if firstResponseDuration == 0 {
+					firstResponseDuration = time.Since(blockingBegan)
+				}
+				if isFirst {
+					data[e.Key] = e.KeyScoreMembers
+				}
+				responses[e.Key] = append(responses[e.Key], e.KeyScoreMembers)
+				retrieved += len(e.KeyScoreMembers)
+			}
+			mu.Unlock()
+		}
+	}
+	for i, c := range s.Farm.clusters {
+		wg.Add(1)
+		d, ok := c.(cluster.Digester)
+		if i == first || !ok {
+			go gather(c, keys, i == first)
+			continue
+		}
+		digests[i] = map[string]string{}
+		go func(i int, d cluster.Digester) {
+			defer wg.Done()
+			for e := range d.DigestOffset(keys, offset, limit, ascending) {
+				if e.Error != nil {
+					s.Farm.logf("SendAllReadDigests partial error: %s", e.Error)
+					go s.Farm.instrumentation.SelectPartialError()
+					continue
+				}
+				mu.Lock()
+				digests[i][e.Key] = e.Digest
+				mu.Unlock()
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	// Read the keys whose digests disagree with the data in full. Clusters
+	// which failed to digest a key aren't asked again.
+	mismatches := 0
+	for i, c := range s.Farm.clusters {
+		if digests[i] == nil {
+			continue
+		}
+		disagreeing := []string{}
+		for key, digest := range digests[i] {
+			if page, ok := data[key]; !ok || cluster.Digest(page) != digest {
+				disagreeing = append(disagreeing, key)
+			}
+		}
+		if len(disagreeing) <= 0 {
+			continue
+		}
+		mismatches += len(disagreeing)
+		wg.Add(1)
+		go gather(c, disagreeing, false)
+	}
+	wg.Wait()
+	blockingDuration := time.Since(blockingBegan)
+	if mismatches > 0 {
+		go s.Farm.instrumentation.SelectDigestMismatch(mismatches)
+	}
+
+	if len(responses) <= 0 && len(keys) > 0 {
+		return map[string][]common.KeyScoreMember{}, fmt.Errorf("complete failure")
+	}
+
+	// Compute union and difference sets for each key. Clusters whose digest
+	// agreed have the same page as the first cluster, so they don't change
+	// either.
+	var (
+		response = map[string][]common.KeyScoreMember{}
+		repairs  = keyMemberSet{}
+		returned = 0
+	)
+	for key, tupleLists := range responses {
+		union, difference := merge(tupleLists, limit, ascending)
+		response[key] = union
+		returned += len(union)
+		repairs.addMany(difference)
+	}
+	if len(repairs) > 0 {
+		s.Farm.instrumentation.SelectRepairNeeded(len(repairs))
+		s.Farm.repairStrategy(repairs.slice())
+	}
+
+	go func() {
+		s.Farm.instrumentation.SelectFirstResponseDuration(firstResponseDuration)
+		s.Farm.instrumentation.SelectBlockingDuration(blockingDuration)
+		s.Farm.instrumentation.SelectOverheadDuration(time.Since(began) - blockingDuration)
+		s.Farm.instrumentation.SelectRetrieved(retrieved)
+		s.Farm.instrumentation.SelectReturned(returned)
+		s.Farm.amplification.observe("SendAllReadDigests", retrieved, returned, s.Farm.instrumentation)
+	}()
+	return response, nil
+}
@@ -0,0 +1,62 @@
+package farm
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSendAllReadDigests(t *testing.T) {
+	var (
+		fakes    = []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+		clusters = []cluster.Cluster{fakes[0], fakes[1], fakes[2]}
+		repairs  int32
+		farm     = New(clusters, 3, SendAllReadDigests, MockRepairs(&repairs), nil)
+	)
+	if err := farm.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// While the clusters agree, one is read, and the others only digested.
+	selected, err := farm.SelectOffset([]string{"foo"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}, {Key: "foo", Score: 1, Member: "a"}}; !reflect.DeepEqual(expected, selected["foo"]) {
+		t.Errorf("expected %v, got %v", expected, selected["foo"])
+	}
+	selects, digests := 0, 0
+	for _, fake := range fakes {
+		selects += fake.CallCount(clustertest.SelectOffset)
+		digests += fake.CallCount(clustertest.DigestOffset)
+	}
+	if selects != 1 || digests != 2 {
+		t.Errorf("expected 1 select and 2 digests, got %d and %d", selects, digests)
+	}
+	if n := atomic.LoadInt32(&repairs); n != 0 {
+		t.Errorf("expected no repairs, got %d", n)
+	}
+
+	// A diverged cluster is read in full, merged, and repaired, whichever
+	// cluster is read first.
+	if err := fakes[2].Insert([]common.KeyScoreMember{{Key: "foo", Score: 3, Member: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	selected, err = farm.SelectOffsetAscending([]string{"foo"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}, {Key: "foo", Score: 2, Member: "b"}, {Key: "foo", Score: 3, Member: "c"}}; !reflect.DeepEqual(expected, selected["foo"]) {
+		t.Errorf("expected %v, got %v", expected, selected["foo"])
+	}
+	if n := atomic.LoadInt32(&repairs); n != 1 {
+		t.Errorf("expected 1 repair, got %d", n)
+	}
+}
@@ -0,0 +1,29 @@
+package farm
+
+import (
+	"errors"
+
+	"github.com/soundcloud/roshi/pool"
+)
+
+// Retryable reports whether an operation which failed with err may succeed
+// if it's retried, perhaps after a delay: the clusters were unavailable,
+// slow, overloaded, stale, or behind an open circuit breaker, rather than
+// the request being invalid. Errors are found with errors.As, so they may be
+// wrapped, e.g. by a QuorumError.
+// Unclassified errors aren't considered retryable.
+func Retryable(err error) bool {
+	var (
+		quorum    QuorumError
+		exhausted pool.ExhaustedError
+		timeout   pool.TimeoutError
+	)
+	switch {
+	case errors.Is(err, ErrOverloaded), errors.Is(err, ErrStale), errors.Is(err, ErrBreakerOpen), errors.Is(err, ErrNoReadQuorum):
+		return true
+	case errors.As(err, &quorum), errors.As(err, &exhausted), errors.As(err, &timeout):
+		return true
+	default:
+		return false
+	}
+}
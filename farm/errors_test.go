@@ -0,0 +1,40 @@
+package farm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/pool"
+)
+
+func TestRetryable(t *testing.T) {
+	timeout := pool.TimeoutError{Address: "localhost:6379", Err: errors.New("i/o timeout")}
+	quorum := QuorumError{Result: WriteResult{Required: 2, Acknowledged: []int{0}, Failed: map[int]error{1: timeout}}}
+
+	for _, testCase := range []struct {
+		err       error
+		retryable bool
+	}{
+		{ErrOverloaded, true},
+		{fmt.Errorf("select: %w", ErrStale), true},
+		{fmt.Errorf("guard: %w", ErrNoReadQuorum), true},
+		{quorum, true},
+		{timeout, true},
+		{pool.ExhaustedError{Address: "localhost:6379"}, true},
+		{MemberTooLargeError{Count: 1, Largest: 8, Max: 4}, false},
+		{ScoreSkewError{Count: 1, Latest: 2e9, Limit: 1e9, Max: time.Minute}, false},
+		{errors.New("ERR syntax error"), false},
+	} {
+		if expected, got := testCase.retryable, Retryable(testCase.err); expected != got {
+			t.Errorf("%v: expected %v, got %v", testCase.err, expected, got)
+		}
+	}
+
+	// The errors of the clusters are found in a QuorumError.
+	var found pool.TimeoutError
+	if !errors.As(quorum, &found) || found != timeout {
+		t.Errorf("expected to find %v in %v", timeout, quorum)
+	}
+}
@@ -0,0 +1,142 @@
+package farm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// errNoReadStrategies is returned by the Selects of a Fallback without
+// strategies.
+var errNoReadStrategies = errors.New("no read strategies to fall back on")
+
+// Fallback is a ReadStrategy which reads with the first of the strategies,
+// and reads the keys that failed with the next one, and so on, until every
+// key was read. The strategies are typically ordered from cheapest to most
+// resilient, e.g. SendOneReadOne, then SendAllReadAll: most reads then cost
+// a single cluster read, and the keys which hit a failing cluster are served
+// by the others, rather than coming back empty. A key failed if no cluster
+// returned it without an error. Keys which fail with every strategy are
+// returned empty, as partial results, unless every strategy failed
+// outright; then the error lists the error of each strategy, in order. A
+// Fallback without strategies fails every Select.
+func Fallback(strategies ...ReadStrategy) ReadStrategy {
+	return func(farm *Farm) Selecter {
+		return fallback{farm: farm, strategies: strategies}
+	}
+}
+
+type fallback struct {
+	farm       *Farm
+	strategies []ReadStrategy
+}
+
+// SelectOffset implements farm.Selecter.
+func (f fallback) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return f.read(keys, func(s Selecter, keys []string) (map[string][]common.KeyScoreMember, error) {
+		return s.SelectOffset(keys, offset, limit)
+	})
+}
+
+// SelectOffsetAscending implements farm.Selecter.
+func (f fallback) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return f.read(keys, func(s Selecter, keys []string) (map[string][]common.KeyScoreMember, error) {
+		return s.SelectOffsetAscending(keys, offset, limit)
+	})
+}
+
+// SelectRange implements farm.Selecter.
+func (f fallback) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	return f.read(keys, func(s Selecter, keys []string) (map[string][]common.KeyScoreMember, error) {
+		return s.SelectRange(keys, start, stop, limit)
+	})
+}
+
+func (f fallback) read(keys []string, fn func(Selecter, []string) (map[string][]common.KeyScoreMember, error)) (map[string][]common.KeyScoreMember, error) {
+	if len(f.strategies) <= 0 {
+		return map[string][]common.KeyScoreMember{}, errNoReadStrategies
+	}
+	var (
+		results   = map[string][]common.KeyScoreMember{}
+		remaining = keys
+		read      = false // whether any strategy succeeded
+		errs      = make([]string, 0, len(f.strategies)-1)
+		err       error
+	)
+	for i, strategy := range f.strategies {
+		// Each strategy reads through its own view of the farm, which
+		// records the keys that the clusters returned.
+		var (
+			view    = *f.farm
+			reads   = &keyReads{}
+			partial map[string][]common.KeyScoreMember
+		)
+		view.reads = reads
+		if partial, err = fn(strategy(&view), remaining); err != nil {
+			if i < len(f.strategies)-1 {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+		read = true
+		for key, page := range partial {
+			results[key] = page
+		}
+		if remaining = reads.failed(remaining); len(remaining) <= 0 {
+			break
+		}
+	}
+	if read {
+		return results, nil // partial results are preferred
+	}
+	if len(errs) > 0 {
+		// The error of the last strategy is wrapped, so it's still classified
+		// by Retryable.
+		err = fmt.Errorf("%s, then %w", strings.Join(errs, ", then "), err)
+	}
+	return map[string][]common.KeyScoreMember{}, err
+}
+
+// keyReads records the keys which clusters returned without an error, for
+// the views of a Fallback. It's safe for concurrent use.
+type keyReads struct {
+	mu   sync.Mutex
+	read map[string]bool
+}
+
+// observe returns the elements of src, recording the keys read.
+func (r *keyReads) observe(src <-chan cluster.Element) <-chan cluster.Element {
+	dst := make(chan cluster.Element)
+	go func() {
+		defer close(dst)
+		for e := range src {
+			if e.Error == nil {
+				r.mu.Lock()
+				if r.read == nil {
+					r.read = map[string]bool{}
+				}
+				r.read[e.Key] = true
+				r.mu.Unlock()
+			}
+			dst <- e
+		}
+	}()
+	return dst
+}
+
+// failed returns the keys which no cluster returned so far.
+func (r *keyReads) failed(keys []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failed := []string{}
+	for _, key := range keys {
+		if !r.read[key] {
+			failed = append(failed, key)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,149 @@
+package farm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestFallback(t *testing.T) {
+	// SendOneReadOne fails whenever it picks the failing cluster, and the
+	// healthy cluster serves the fallback.
+	var (
+		failing  = clustertest.New()
+		clusters = []cluster.Cluster{failing, clustertest.New()}
+		farm     = New(clusters, 2, Fallback(SendOneReadOne, SendAllReadAll), NoRepairs, nil)
+	)
+	if err := farm.Insert([]common.KeyScoreMember{testingKeyScoreMember}); err != nil {
+		t.Fatal(err)
+	}
+	failing.FailWith(clustertest.SelectOffset, errors.New("failtown"))
+	for i := 0; i < 10; i++ {
+		result, err := farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+		if err := checkResult(result, err); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFallbackFailedKeys(t *testing.T) {
+	// SendOneReadOne fails some of the keys whenever it picks the failing
+	// cluster, and only those keys fall back.
+	var (
+		healthy  = clustertest.New()
+		failing  = keyFailingCluster{Fake: clustertest.New(), key: "bad"}
+		clusters = []cluster.Cluster{failing, healthy}
+		farm     = New(clusters, 2, Fallback(SendOneReadOne, SendAllReadAll), NoRepairs, nil)
+	)
+	if err := farm.Insert([]common.KeyScoreMember{
+		{Key: "good", Score: 1, Member: "a"},
+		{Key: "bad", Score: 1, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		result, err := farm.SelectOffset([]string{"good", "bad"}, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"good", "bad"} {
+			if expected, got := 1, len(result[key]); expected != got {
+				t.Fatalf("%s: expected %d member(s), got %d", key, expected, got)
+			}
+		}
+	}
+
+	// Each cluster is either picked for both keys, or reads the one which
+	// failed, when the failing cluster was picked.
+	selects := func(c *clustertest.Fake) map[string]int {
+		m := map[string]int{}
+		for _, call := range c.Calls() {
+			if call.Method == clustertest.SelectOffset {
+				m[fmt.Sprint(call.Keys)]++
+			}
+		}
+		return m
+	}
+	var (
+		picked       = selects(failing.Fake)["[good bad]"]
+		healthyReads = selects(healthy)
+	)
+	if expected, got := 10, picked+healthyReads["[good bad]"]; expected != got {
+		t.Errorf("expected %d picks, got %d", expected, got)
+	}
+	for _, c := range []*clustertest.Fake{failing.Fake, healthy} {
+		if expected, got := picked, selects(c)["[bad]"]; expected != got {
+			t.Errorf("expected %d fallback(s), got %d", expected, got)
+		}
+	}
+}
+
+func TestFallbackErrors(t *testing.T) {
+	var (
+		stale  = failingStrategy(ErrStale)
+		broken = failingStrategy(errors.New("broken"))
+		farm   = New([]cluster.Cluster{clustertest.New()}, 1, SendAllReadAll, NoRepairs, nil)
+	)
+	_, err := Fallback(broken, stale)(farm).SelectOffset([]string{"key"}, 0, 10)
+	if err == nil {
+		t.Fatal("expected an error, got none")
+	}
+	if expected, got := "broken, then "+ErrStale.Error(), err.Error(); expected != got {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if !Retryable(err) {
+		t.Errorf("expected the error of the last strategy to be classified")
+	}
+	if _, err := Fallback(stale)(farm).SelectOffset([]string{"key"}, 0, 10); err != ErrStale {
+		t.Errorf("expected a single strategy's error as it is, got %v", err)
+	}
+	if _, err := Fallback(broken, stale)(farm).SelectRange([]string{"key"}, common.Cursor{}, common.Cursor{}, 10); err == nil || !strings.HasPrefix(err.Error(), "broken") {
+		t.Errorf("expected SelectRange to fall back, got %v", err)
+	}
+	if _, err := Fallback()(farm).SelectOffset([]string{"key"}, 0, 10); err != errNoReadStrategies {
+		t.Errorf("expected %v, got %v", errNoReadStrategies, err)
+	}
+}
+
+func failingStrategy(err error) ReadStrategy {
+	return func(*Farm) Selecter { return failingSelecter{err} }
+}
+
+// keyFailingCluster fails the Selects of a single key.
+type keyFailingCluster struct {
+	*clustertest.Fake
+	key string
+}
+
+func (c keyFailingCluster) SelectOffset(keys []string, offset, limit int) <-chan cluster.Element {
+	dst := make(chan cluster.Element)
+	go func() {
+		defer close(dst)
+		for e := range c.Fake.SelectOffset(keys, offset, limit) {
+			if e.Key == c.key {
+				e = cluster.Element{Key: e.Key, KeyScoreMembers: []common.KeyScoreMember{}, Error: errors.New("failtown")}
+			}
+			dst <- e
+		}
+	}()
+	return dst
+}
+
+type failingSelecter struct{ err error }
+
+func (s failingSelecter) SelectOffset([]string, int, int) (map[string][]common.KeyScoreMember, error) {
+	return nil, s.err
+}
+
+func (s failingSelecter) SelectOffsetAscending([]string, int, int) (map[string][]common.KeyScoreMember, error) {
+	return nil, s.err
+}
+
+func (s failingSelecter) SelectRange([]string, common.Cursor, common.Cursor, int) (map[string][]common.KeyScoreMember, error) {
+	return nil, s.err
+}
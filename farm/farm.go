@@ -3,9 +3,10 @@ package farm
 
 import (
 	"fmt"
+	"log"
 	"math/rand"
 	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/soundcloud/roshi/cluster"
@@ -21,9 +22,65 @@ func init() {
 type Farm struct {
 	clusters        []cluster.Cluster
 	writeQuorum     int
+	readStrategy    ReadStrategy
 	selecter        Selecter
 	repairStrategy  coreRepairStrategy
 	instrumentation instrumentation.Instrumentation
+	maxMemberSize   int
+	scoreSkew       *scoreSkew // nil for no limit
+	dedupWindow     float64    // score units, 0 to disable
+	writeTransform  Transform
+	splits          *keySplits // nil for no split keys
+	health          *clusterHealth
+	preferHealthy   bool
+	convergence     *convergence
+	maintenance     *maintenance   // nil for views which exclude it already
+	serializer      *keySerializer // nil unless writes are serialized
+	workers         *selectWorkers
+	async           *asyncInserts
+	amplification   *readAmplification
+	archiver        *archiving // nil unless inserts are archived
+	notifier        Notifier
+	backfiller      Backfiller
+	cache           *Cache
+	filters         *MemberFilters
+	zones           []string     // per cluster, if configured
+	partial         *partialRead // for views returned by WithDeadline
+	reportPartial   bool         // for views returned by ReportPartial
+	reads           *keyReads    // for the views of a Fallback
+	requestID       string       // for views returned by WithRequestID
+	slowOps         *slowOps     // nil unless slow ops are logged
+	trace           *opTrace     // for views timing a single op
+	hotKeys         *hotKeys     // nil unless hot keys are warmed
+	localWrites     *localWrites // nil unless the local write concern is enabled
+	acknowledgers   []bool       // per cluster, for views returned by Local
+	unrepaired      *Farm
+	quorumRetryMin  time.Duration
+	quorumRetryMax  time.Duration
+}
+
+// Option configures optional behavior of a Farm. Options are passed to New.
+type Option func(*Farm)
+
+// MaxMemberSize causes inserts with any member longer than n bytes to be
+// rejected with a MemberTooLargeError, before any request is made to the
+// clusters. Oversized members bloat Redis memory and slow down every
+// subsequent read of the key. Zero or a negative n means no limit.
+func MaxMemberSize(n int) Option {
+	return func(f *Farm) { f.maxMemberSize = n }
+}
+
+// MemberTooLargeError is returned by inserts with at least one member longer
+// than the MaxMemberSize. None of the tuples in the write are applied.
+type MemberTooLargeError struct {
+	Count   int // how many members were too large
+	Largest int // size of the largest member, in bytes
+	Max     int // the configured maximum, in bytes
+}
+
+// Error implements the error interface.
+func (e MemberTooLargeError) Error() string {
+	return fmt.Sprintf("%d member(s) exceed the max member size of %d bytes (largest %d bytes)", e.Count, e.Max, e.Largest)
 }
 
 // New creates and returns a new Farm.
@@ -35,13 +92,15 @@ type Farm struct {
 //
 // The repair strategy will only issue repairs against the read clusters.
 //
-// Instrumentation may be nil; all other parameters are required.
+// Instrumentation may be nil; all other parameters are required. Options
+// may be passed to enable optional behavior.
 func New(
 	clusters []cluster.Cluster,
 	writeQuorum int,
 	readStrategy ReadStrategy,
 	repairStrategy RepairStrategy,
 	instr instrumentation.Instrumentation,
+	options ...Option,
 ) *Farm {
 	if instr == nil {
 		instr = instrumentation.NopInstrumentation{}
@@ -49,10 +108,32 @@ func New(
 	farm := &Farm{
 		clusters:        clusters,
 		writeQuorum:     writeQuorum,
+		readStrategy:    readStrategy,
 		repairStrategy:  repairStrategy(clusters, instr),
 		instrumentation: instr,
+		quorumRetryMin:  defaultQuorumRetryMin,
+		quorumRetryMax:  defaultQuorumRetryMax,
+		maintenance:     newMaintenance(len(clusters)),
+		amplification:   newReadAmplification(),
+		async:           newAsyncInserts(defaultAsyncWorkers, defaultAsyncQueue),
 	}
+	for _, option := range options {
+		option(farm)
+	}
+	if farm.localWrites != nil {
+		if err := farm.localWrites.resolve(farm.zones); err != nil {
+			log.Printf("local writes disabled: %s", err)
+			farm.localWrites = nil
+		}
+	}
+	farm.repairStrategy = farm.backfilled(farm.repairStrategy)
 	farm.selecter = readStrategy(farm)
+
+	// The repair-exempt view shares everything but the repair strategy.
+	unrepaired := *farm
+	unrepaired.repairStrategy = farm.backfilled(func(kms []common.KeyMember) { instr.SelectRepairExempted(len(kms)) })
+	unrepaired.selecter = readStrategy(&unrepaired)
+	farm.unrepaired = &unrepaired
 	return farm
 }
 
@@ -60,55 +141,220 @@ func New(
 // greater than the already-stored scores. As long as over half of the clusters
 // succeed to write all tuples, the overall write succeeds.
 func (f *Farm) Insert(tuples []common.KeyScoreMember) error {
-	return f.write(
-		tuples,
+	tuples, err := f.checkInsert(tuples)
+	if err != nil {
+		return err
+	}
+	_, err = f.insert(tuples, false)
+	return err
+}
+
+// insert writes the checked tuples, and archives and notifies them if the
+// write succeeds.
+func (f *Farm) insert(tuples []common.KeyScoreMember, waitAll bool) (WriteResult, error) {
+	if f.slowOps != nil && f.trace == nil {
+		var result WriteResult
+		err := f.logSlowInsert(tuples, func(f *Farm) (err error) {
+			result, err = f.insert(tuples, waitAll)
+			return err
+		})
+		return result, err
+	}
+	tuples = f.dedup(tuples)
+	result, err := f.write(
+		f.splits.split(tuples),
 		func(c cluster.Cluster, a []common.KeyScoreMember) error { return c.Insert(a) },
 		insertInstrumentation{f.instrumentation},
+		waitAll,
 	)
+	if err == nil {
+		f.archive(tuples)
+		f.notify(OpInsert, tuples)
+	}
+	return result, err
 }
 
 // Selecter defines a synchronous Select API, implemented by Farm.
 type Selecter interface {
 	SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error)
+	SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error)
 	SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error)
 }
 
+// WithoutRepairs returns a Selecter over the same clusters and with the same
+// ReadStrategy as the farm, which never issues read repairs. Divergences
+// detected by its Selects are reported to instrumentation as exempted rather
+// than repaired. Use it for hot read paths that shouldn't generate repair
+// writes; other reads, or the walker, will repair the divergences eventually.
+func (f *Farm) WithoutRepairs() Selecter {
+	return f.unrepaired
+}
+
+// WithRequestID returns a Selecter over the same clusters and with the same
+// ReadStrategy as the farm, for a single request. Its log messages, and a
+// record of every read repair it issues, carry the request ID, so repairs
+// can be traced back to the request which detected the divergence.
+func (f *Farm) WithRequestID(id string) Selecter {
+	view := *f
+	view.requestID = id
+	view.repairStrategy = func(kms []common.KeyMember) {
+		log.Printf("request %s: repairing %d key-member(s)", id, len(kms))
+		f.repairStrategy(kms)
+	}
+	view.selecter = f.readStrategy(&view)
+	if f.unrepaired != nil {
+		unrepaired := *f.unrepaired
+		unrepaired.requestID = id
+		unrepaired.selecter = f.readStrategy(&unrepaired)
+		view.unrepaired = &unrepaired
+	}
+	return &view
+}
+
+// logf logs the message, prefixed with the request ID of the view, if any.
+func (f *Farm) logf(format string, args ...interface{}) {
+	if f.requestID != "" {
+		format = "request " + f.requestID + ": " + format
+	}
+	log.Printf(format, args...)
+}
+
 // SelectOffset satisfies Selecter and invokes the ReadStrategy of the farm.
 func (f *Farm) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
 	// High performance optimization.
 	if len(keys) <= 0 {
 		return map[string][]common.KeyScoreMember{}, nil
 	}
+	if f.slowOps != nil && f.trace == nil {
+		return f.logSlowSelect(keys, limit, func(f *Farm) (map[string][]common.KeyScoreMember, error) {
+			return f.SelectOffset(keys, offset, limit)
+		})
+	}
+	if f.hotKeys != nil {
+		f.hotKeys.touch(keys, offset+limit)
+	}
+	f = f.readable()
+	return f.partialResult(f.splits.selectOffset(keys, offset, limit, false, f.selectOffset))
+}
+
+// selectOffset is SelectOffset of keys as stored, i.e. subkeys of split keys.
+func (f *Farm) selectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	if f.cache != nil {
+		return f.cache.selectOffset(f.selecter, keys, offset, limit, f.instrumentation, f.complete)
+	}
 	return f.selecter.SelectOffset(keys, offset, limit)
 }
 
+// SelectOffsetAscending satisfies Selecter and invokes the ReadStrategy of
+// the farm. Results are ordered from the lowest score to the highest.
+func (f *Farm) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	// High performance optimization.
+	if len(keys) <= 0 {
+		return map[string][]common.KeyScoreMember{}, nil
+	}
+	if f.slowOps != nil && f.trace == nil {
+		return f.logSlowSelect(keys, limit, func(f *Farm) (map[string][]common.KeyScoreMember, error) {
+			return f.SelectOffsetAscending(keys, offset, limit)
+		})
+	}
+	if f.hotKeys != nil {
+		f.hotKeys.touch(keys, offset+limit)
+	}
+	f = f.readable()
+	return f.partialResult(f.splits.selectOffset(keys, offset, limit, true, f.selecter.SelectOffsetAscending))
+}
+
 // SelectRange satisfies Selecter and invokes the ReadStrategy of the farm.
 func (f *Farm) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
 	// High performance optimization.
 	if len(keys) <= 0 {
 		return map[string][]common.KeyScoreMember{}, nil
 	}
-	return f.selecter.SelectRange(keys, start, stop, limit)
+	if f.slowOps != nil && f.trace == nil {
+		return f.logSlowSelect(keys, limit, func(f *Farm) (map[string][]common.KeyScoreMember, error) {
+			return f.SelectRange(keys, start, stop, limit)
+		})
+	}
+	if f.hotKeys != nil {
+		f.hotKeys.touch(keys, limit)
+	}
+	f = f.readable()
+	return f.partialResult(f.splits.selectRange(keys, start, stop, limit, f.selecter.SelectRange))
 }
 
 // Delete removes each tuple from the underlying clusters, if the score is
 // greater than the already-stored scores.
 func (f *Farm) Delete(tuples []common.KeyScoreMember) error {
-	return f.write(
-		tuples,
+	tuples = f.transform(tuples)
+	_, err := f.write(
+		f.splits.split(tuples),
+		func(c cluster.Cluster, a []common.KeyScoreMember) error { return c.Delete(a) },
+		deleteInstrumentation{f.instrumentation},
+		false,
+	)
+	if err == nil {
+		f.notify(OpDelete, tuples)
+	}
+	return err
+}
+
+// WriteResult describes how each cluster responded to a write. Clusters are
+// identified by their index in the slice passed to New. Since writes are sent
+// to each cluster as a single batch, the outcome applies equally to every
+// tuple in the write.
+type WriteResult struct {
+	Required     int           // the write quorum
+	Acknowledged []int         // clusters that accepted the write
+	Failed       map[int]error // clusters that returned an error
+	Quorum       bool          // whether the write quorum was reached
+}
+
+// InsertVerbose is like Insert, but waits for a response from every cluster,
+// and reports the outcome per cluster. A quorum failure is returned as both
+// a QuorumError and a WriteResult with Quorum set to false.
+func (f *Farm) InsertVerbose(tuples []common.KeyScoreMember) (WriteResult, error) {
+	tuples, err := f.checkInsert(tuples)
+	if err != nil {
+		return WriteResult{Required: f.writeQuorum, Acknowledged: []int{}, Failed: map[int]error{}}, err
+	}
+	return f.insert(tuples, true)
+}
+
+// DeleteVerbose is like Delete, but waits for a response from every cluster,
+// and reports the outcome per cluster, like InsertVerbose.
+func (f *Farm) DeleteVerbose(tuples []common.KeyScoreMember) (WriteResult, error) {
+	tuples = f.transform(tuples)
+	result, err := f.write(
+		f.splits.split(tuples),
 		func(c cluster.Cluster, a []common.KeyScoreMember) error { return c.Delete(a) },
 		deleteInstrumentation{f.instrumentation},
+		true,
 	)
+	if err == nil {
+		f.notify(OpDelete, tuples)
+	}
+	return result, err
 }
 
+// write sends the tuples to every cluster via the action. Unless waitAll is
+// set, it returns as soon as quorum is reached, and the returned WriteResult
+// only reflects the clusters that responded until then.
 func (f *Farm) write(
 	tuples []common.KeyScoreMember,
 	action func(cluster.Cluster, []common.KeyScoreMember) error,
 	instr writeInstrumentation,
-) error {
+	waitAll bool,
+) (WriteResult, error) {
+	result := WriteResult{
+		Required:     f.writeQuorum,
+		Acknowledged: []int{},
+		Failed:       map[int]error{},
+	}
+
 	// High performance optimization.
 	if len(tuples) <= 0 {
-		return nil
+		result.Quorum = true
+		return result, nil
 	}
 	instr.call()
 	instr.recordCount(len(tuples))
@@ -118,87 +364,143 @@ func (f *Farm) write(
 		instr.recordDuration(d / time.Duration(len(tuples)))
 	}(time.Now())
 
+	// Wait for earlier writes to the same keys, and hold the keys until every
+	// cluster has applied this write, even after returning on quorum.
+	var applied sync.WaitGroup
+	if f.serializer != nil {
+		release := f.serializer.acquire(keysOf(tuples))
+		defer func() { go func() { applied.Wait(); release() }() }()
+	}
+
+	// Invalidate cached keys when the write returns, so that reads
+	// which follow it don't return the cached state from before it, and
+	// again once every cluster has applied it, if any hadn't yet, as a read
+	// in between may have cached the state of a cluster which hadn't.
+	var outstanding bool
+	if f.cache != nil || f.filters != nil {
+		keys := keysOf(tuples)
+		invalidate := func() {
+			if f.cache != nil {
+				f.cache.Invalidate(keys)
+			}
+			if f.filters != nil {
+				f.filters.Invalidate(keys)
+			}
+		}
+		defer func() {
+			invalidate()
+			if outstanding {
+				go func() { applied.Wait(); invalidate() }()
+			}
+		}()
+	}
+
 	// Scatter
-	errChan := make(chan error, len(f.clusters))
-	for _, c := range f.clusters {
-		go func(c cluster.Cluster) {
-			errChan <- action(c, tuples)
-		}(c)
+	var (
+		responses = make(chan writeResponse, len(f.clusters))
+		sent      = 0
+	)
+	for i, c := range f.clusters {
+		if f.maintenance.skip(i, tuples) {
+			continue // repaired when it leaves maintenance
+		}
+		sent++
+		if f.health != nil && !f.health.allow(c) {
+			responses <- writeResponse{i, ErrBreakerOpen}
+			continue
+		}
+		applied.Add(1)
+		go func(i int, c cluster.Cluster) {
+			defer applied.Done()
+			began := time.Now()
+			err := action(c, tuples)
+			if f.health != nil {
+				f.health.observe(c, time.Since(began), err != nil)
+			}
+			if f.trace != nil {
+				f.trace.observe(c, time.Since(began), err)
+			}
+			responses <- writeResponse{i, err}
+		}(i, c)
 	}
 
-	// Gather
+	// Gather. Views returned by Local only count the acknowledgements of
+	// local clusters.
 	var (
-		errors     = []string{}
-		got        = 0
-		need       = f.writeQuorum
-		haveQuorum = func() bool { return (got - len(errors)) >= need }
+		received     = 0
+		acknowledged = 0
+		haveQuorum   = func() bool { return acknowledged >= f.writeQuorum }
 	)
-	for i := 0; i < cap(errChan); i++ {
-		err := <-errChan
-		if err != nil {
-			errors = append(errors, err.Error())
+	for received < sent {
+		resp := <-responses
+		received++
+		if resp.err != nil {
+			result.Failed[resp.index] = resp.err
+		} else {
+			result.Acknowledged = append(result.Acknowledged, resp.index)
+			if f.acknowledgers == nil || f.acknowledgers[resp.index] {
+				acknowledged++
+			}
 		}
-		got++
-		if haveQuorum() {
+		if !waitAll && haveQuorum() {
 			break
 		}
 	}
+	sort.Ints(result.Acknowledged)
+	result.Quorum = haveQuorum()
+	outstanding = received < sent
 
 	// Report
-	if !haveQuorum() {
+	if !result.Quorum {
 		instr.quorumFailure()
-		return fmt.Errorf("no quorum (%s)", strings.Join(errors, "; "))
+		return result, QuorumError{Result: result, RetryAfter: f.retryAfter(result)}
 	}
-	return nil
-}
-
-// unionDifference computes two sets of keys from the input sets. Union is
-// defined to be every key-member and its best (highest) score. Difference is
-// defined to be those key-members with imperfect agreement across all input
-// sets.
-func unionDifference(tupleSets []tupleSet) (tupleSet, keyMemberSet) {
-	var (
-		expectedCount = len(tupleSets)
-		scores        = make(map[common.KeyMember]float64, len(tupleSets)*10)
-		counts        = make(map[common.KeyScoreMember]int, len(tupleSets)*10)
-	)
-
-	for _, tupleSet := range tupleSets {
-		for tuple := range tupleSet {
-			// For union
-			keyMember := common.KeyMember{Key: tuple.Key, Member: tuple.Member}
-			if score, ok := scores[keyMember]; !ok || tuple.Score > score {
-				scores[keyMember] = tuple.Score
-			}
-
-			// For difference
-			counts[tuple]++
+	if f.acknowledgers != nil {
+		failed := make([]int, 0, len(result.Failed))
+		for index := range result.Failed {
+			failed = append(failed, index)
 		}
+		f.replicate(tuples, action, failed, responses, sent-received)
 	}
+	return result, nil
+}
 
-	var (
-		union      = make(tupleSet, len(scores))
-		difference = make(keyMemberSet, len(counts))
-	)
+// writeResponse is the response of a cluster to a write.
+type writeResponse struct {
+	index int
+	err   error
+}
 
-	for keyMember, bestScore := range scores {
-		union.add(common.KeyScoreMember{
-			Key:    keyMember.Key,
-			Score:  bestScore,
-			Member: keyMember.Member,
-		})
+// checkInsert transforms the tuples of an insert, and checks them against
+// the MaxMemberSize and MaxScoreSkew, before any request is made.
+func (f *Farm) checkInsert(tuples []common.KeyScoreMember) ([]common.KeyScoreMember, error) {
+	tuples = f.transform(tuples)
+	if err := f.checkMemberSize(tuples); err != nil {
+		return nil, err
 	}
+	return f.checkScoreSkew(tuples)
+}
 
-	for keyScoreMember, count := range counts {
-		if count < expectedCount {
-			difference.add(common.KeyMember{
-				Key:    keyScoreMember.Key,
-				Member: keyScoreMember.Member,
-			})
+// checkMemberSize returns a MemberTooLargeError if any of the tuples has a
+// member larger than the configured maximum.
+func (f *Farm) checkMemberSize(tuples []common.KeyScoreMember) error {
+	if f.maxMemberSize <= 0 {
+		return nil
+	}
+	var count, largest int
+	for _, tuple := range tuples {
+		if n := len(tuple.Member); n > f.maxMemberSize {
+			count++
+			if n > largest {
+				largest = n
+			}
 		}
 	}
-
-	return union, difference
+	if count <= 0 {
+		return nil
+	}
+	f.instrumentation.InsertMemberTooLarge(count)
+	return MemberTooLargeError{Count: count, Largest: largest, Max: f.maxMemberSize}
 }
 
 type tupleSet map[common.KeyScoreMember]struct{}
@@ -243,10 +545,19 @@ func (s tupleSet) orderedLimitedSlice(limit int) []common.KeyScoreMember {
 	return a
 }
 
+func (s tupleSet) ascendingLimitedSlice(limit int) []common.KeyScoreMember {
+	a := s.slice()
+	sort.Sort(sort.Reverse(keyScoreMembers(a)))
+	if len(a) > limit {
+		a = a[:limit]
+	}
+	return a
+}
+
 type keyScoreMembers []common.KeyScoreMember
 
 func (a keyScoreMembers) Len() int           { return len(a) }
-func (a keyScoreMembers) Less(i, j int) bool { return a[i].Score > a[j].Score }
+func (a keyScoreMembers) Less(i, j int) bool { return a[i].Before(a[j]) }
 func (a keyScoreMembers) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
 type keyMemberSet map[common.KeyMember]struct{}
@@ -1,10 +1,15 @@
 package farm
 
 import (
+	"errors"
+	"math/rand"
 	"reflect"
 	"testing"
 
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
 	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
 )
 
 func TestInsertSelect(t *testing.T) {
@@ -44,6 +49,41 @@ func TestInsertSelect(t *testing.T) {
 	}
 }
 
+func TestSelectOffsetAscending(t *testing.T) {
+	for name, readStrategy := range map[string]ReadStrategy{
+		"SendOneReadOne":         SendOneReadOne,
+		"SendAllReadAll":         SendAllReadAll,
+		"SendAllReadFirstLinger": SendAllReadFirstLinger,
+	} {
+		clusters := newMockClusters(3)
+		farm := New(clusters, len(clusters), readStrategy, NoRepairs, nil)
+
+		if err := farm.Insert([]common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 5, Member: "five"},
+			common.KeyScoreMember{Key: "foo", Score: 4, Member: "four"},
+			common.KeyScoreMember{Key: "foo", Score: 9, Member: "nine"},
+			common.KeyScoreMember{Key: "foo", Score: 1, Member: "one"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := farm.SelectOffsetAscending([]string{"foo"}, 1, 2)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		expected := map[string][]common.KeyScoreMember{
+			"foo": []common.KeyScoreMember{
+				common.KeyScoreMember{Key: "foo", Score: 4, Member: "four"},
+				common.KeyScoreMember{Key: "foo", Score: 5, Member: "five"},
+			},
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: expected\n %+v, got\n %+v", name, expected, got)
+		}
+	}
+}
+
 func TestOffsetLimit(t *testing.T) {
 	clusters := newMockClusters(3)
 	f := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil)
@@ -106,3 +146,231 @@ func TestSendAllReadAllSelectAfterNoQuorum(t *testing.T) {
 		t.Errorf("expected %+v, got %+v", expected, got)
 	}
 }
+
+func TestInsertVerbose(t *testing.T) {
+	// Build a farm of 3 clusters: 1 failing, 2 successful
+	clusters := newMockClusters(2)
+	clusters = append(clusters, newFailingMockCluster())
+	f := New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+
+	foo := common.KeyScoreMember{Key: "foo", Score: 1.0, Member: "bar"}
+	result, err := f.InsertVerbose([]common.KeyScoreMember{foo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Quorum {
+		t.Errorf("expected quorum, got none")
+	}
+	if expected, got := []int{0, 1}, result.Acknowledged; !reflect.DeepEqual(expected, got) {
+		t.Errorf("acknowledged: expected %v, got %v", expected, got)
+	}
+	if _, ok := result.Failed[2]; !ok || len(result.Failed) != 1 {
+		t.Errorf("failed: expected only cluster 2, got %v", result.Failed)
+	}
+
+	// Raising the quorum above the healthy clusters fails the write, but
+	// still reports per-cluster outcomes.
+	f = New(clusters, 3, SendAllReadAll, NoRepairs, nil)
+	result, err = f.DeleteVerbose([]common.KeyScoreMember{foo})
+	if err == nil {
+		t.Errorf("expected error, got none")
+	}
+	if result.Quorum {
+		t.Errorf("expected no quorum, got quorum")
+	}
+	if expected, got := 2, len(result.Acknowledged); expected != got {
+		t.Errorf("acknowledged: expected %d, got %d", expected, got)
+	}
+}
+
+type memberSizeCounter struct {
+	instrumentation.NopInstrumentation
+	calls, tooLarge int
+}
+
+func (c *memberSizeCounter) InsertCall()                { c.calls++ }
+func (c *memberSizeCounter) InsertMemberTooLarge(n int) { c.tooLarge += n }
+
+func TestMaxMemberSize(t *testing.T) {
+	var (
+		clusters = newMockClusters(3)
+		instr    = &memberSizeCounter{}
+		f        = New(clusters, len(clusters), SendAllReadAll, NoRepairs, instr, MaxMemberSize(4))
+	)
+
+	err := f.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "ok"},
+		common.KeyScoreMember{Key: "foo", Score: 2, Member: "too long"},
+	})
+	if err == nil {
+		t.Fatal("expected error, got none")
+	}
+	tooLarge, ok := err.(MemberTooLargeError)
+	if !ok {
+		t.Fatalf("expected MemberTooLargeError, got %T", err)
+	}
+	if expected, got := (MemberTooLargeError{Count: 1, Largest: 8, Max: 4}), tooLarge; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	// Nothing should have been written.
+	for i, c := range clusters {
+		if n := c.(*mockCluster).countInsert; n != 0 {
+			t.Errorf("cluster %d: expected no Inserts, got %d", i, n)
+		}
+	}
+	if expected, got := 0, instr.calls; expected != got {
+		t.Errorf("expected %d Insert calls, got %d", expected, got)
+	}
+	if expected, got := 1, instr.tooLarge; expected != got {
+		t.Errorf("expected %d members too large, got %d", expected, got)
+	}
+
+	// Oversized members written before the limit was set can be deleted.
+	if err := f.Delete([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 3, Member: "too long"},
+	}); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+	for i, c := range clusters {
+		if n := c.(*mockCluster).countDelete; n != 1 {
+			t.Errorf("cluster %d: expected 1 Delete, got %d", i, n)
+		}
+	}
+
+	if err := f.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "ok"},
+	}); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	clusters := []cluster.Cluster{clustertest.New(), clustertest.New(), clustertest.New()}
+	f := New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil)
+
+	if err := f.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 10, Member: "a"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Delete([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 10, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// One cluster has missed a newer write.
+	clusters[0].Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 20, Member: "c"},
+	})
+
+	tuples := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 5, Member: "a"},  // older than an insert
+		common.KeyScoreMember{Key: "foo", Score: 10, Member: "b"}, // same as a delete
+		common.KeyScoreMember{Key: "foo", Score: 15, Member: "c"}, // older than an insert in one cluster
+		common.KeyScoreMember{Key: "foo", Score: 15, Member: "d"}, // new
+	}
+	if err := f.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	rejections, err := f.Rejected(tuples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []Rejection{
+		Rejection{Tuple: tuples[0], WinningScore: 10, WinnerDeleted: false},
+		Rejection{Tuple: tuples[1], WinningScore: 10, WinnerDeleted: true},
+		Rejection{Tuple: tuples[2], WinningScore: 20, WinnerDeleted: false},
+	}, rejections; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	for _, c := range clusters {
+		c.(*clustertest.Fake).FailWith(clustertest.Score, errors.New("down"))
+	}
+	if _, err := f.Rejected(tuples); err == nil {
+		t.Error("expected error, got none")
+	}
+}
+
+func TestConvergence(t *testing.T) {
+	// Each cluster misses some inserts of the history, and sees the rest in
+	// its own order. Reads with repairs must converge every cluster to the
+	// state of the whole history, whatever the order of the clusters.
+	//
+	// Deletes reach every cluster: AllRepairs may keep an insert over a
+	// newer delete, which a cluster missed (issue 24).
+	for seed := int64(0); seed < 100; seed++ {
+		var (
+			r        = rand.New(rand.NewSource(seed))
+			h        = clustertest.RandomHistory(r, 30, 3, 4)
+			expected = h.Expected()
+			clusters = []cluster.Cluster{}
+		)
+		for _, scattered := range h.Only(clustertest.Insert).Scatter(r, 3) {
+			fake := clustertest.New()
+			scattered = append(scattered, h.Only(clustertest.Delete)...)
+			if err := scattered.Permuted(r).Apply(fake); err != nil {
+				t.Fatal(err)
+			}
+			clusters = append(clusters, fake)
+		}
+		f := New(clusters, len(clusters), SendAllReadAll, AllRepairs, nil)
+
+		// The first read issues the repairs, the second reads their result.
+		for i := 0; i < 2; i++ {
+			if _, err := f.SelectOffset(h.Keys(), 0, len(h)+1); err != nil {
+				t.Fatalf("seed %d: %s", seed, err)
+			}
+		}
+		selected, err := f.SelectOffset(h.Keys(), 0, len(h)+1)
+		if err != nil {
+			t.Fatalf("seed %d: %s", seed, err)
+		}
+		for _, key := range h.Keys() {
+			// The farm orders members with equal scores differently.
+			if expected, got := makeSet(expected[key]), makeSet(selected[key]); !reflect.DeepEqual(expected, got) {
+				t.Errorf("seed %d: farm: %s: expected\n %v, got\n %v", seed, key, expected, got)
+			}
+		}
+		for i, c := range clusters {
+			got, err := h.State(c)
+			if err != nil {
+				t.Fatalf("seed %d: cluster %d: %s", seed, i, err)
+			}
+			if !reflect.DeepEqual(expected, got) {
+				t.Errorf("seed %d: cluster %d: expected\n %v, got\n %v", seed, i, expected, got)
+			}
+		}
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	var (
+		repaired = [][]common.KeyMember{}
+		repairs  = func([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRepairStrategy {
+			return func(kms []common.KeyMember) { repaired = append(repaired, kms) }
+		}
+		clusters = []cluster.Cluster{clustertest.New(), clustertest.New()}
+		f        = New(clusters, len(clusters), SendAllReadAll, repairs, nil)
+		foo      = common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"}
+	)
+	clusters[0].Insert([]common.KeyScoreMember{foo})
+
+	view := f.WithRequestID("abc")
+	if got, err := view.SelectOffset([]string{"foo"}, 0, 10); err != nil {
+		t.Fatal(err)
+	} else if expected := []common.KeyScoreMember{foo}; !reflect.DeepEqual(expected, got["foo"]) {
+		t.Errorf("expected %v, got %v", expected, got["foo"])
+	}
+	if expected, got := [][]common.KeyMember{[]common.KeyMember{common.KeyMember{Key: "foo", Member: "a"}}}, repaired; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected repairs %v, got %v", expected, got)
+	}
+
+	// The repair-exempt view of the request doesn't repair.
+	view.(*Farm).WithoutRepairs().SelectOffset([]string{"foo"}, 0, 10)
+	if expected, got := 1, len(repaired); expected != got {
+		t.Errorf("expected %d repair(s), got %d", expected, got)
+	}
+}
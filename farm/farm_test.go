@@ -1,6 +1,7 @@
 package farm
 
 import (
+	"math"
 	"reflect"
 	"strings"
 	"testing"
@@ -8,13 +9,13 @@ import (
 	"github.com/soundcloud/roshi/common"
 )
 
-func TestUnionDifferenceOfOne(t *testing.T) {
+func TestMergeOfOne(t *testing.T) {
 	inputSet := tupleSet{
 		common.KeyScoreMember{Key: "a", Score: 5, Member: "a"}: struct{}{},
 		common.KeyScoreMember{Key: "b", Score: 3, Member: "b"}: struct{}{},
 		common.KeyScoreMember{Key: "c", Score: 1, Member: "c"}: struct{}{},
 	}
-	union, difference := unionDifference([]tupleSet{inputSet})
+	union, difference := mergeSets([]tupleSet{inputSet})
 	if expected, got := inputSet, union; !reflect.DeepEqual(expected, got) {
 		t.Errorf("union: expected %v, got %v", expected, got)
 	}
@@ -23,7 +24,7 @@ func TestUnionDifferenceOfOne(t *testing.T) {
 	}
 }
 
-func TestUnionDifferenceFullResponse(t *testing.T) {
+func TestMergeFullResponse(t *testing.T) {
 	for input, expected := range map[string]string{
 		//
 		//   cluster 1  A B C  A B C  A B C  A B -  A - -  A B -  A B -  A B -
@@ -44,7 +45,7 @@ func TestUnionDifferenceFullResponse(t *testing.T) {
 	} {
 		inputSets := s2tupleSets(t, input)
 		expectedSets := s2pair(t, expected)
-		union, difference := unionDifference(inputSets)
+		union, difference := mergeSets(inputSets)
 		if !reflect.DeepEqual(union, expectedSets.union) {
 			t.Errorf("%s: union: expected %v, got %v", input, expectedSets.union, union)
 		}
@@ -54,7 +55,7 @@ func TestUnionDifferenceFullResponse(t *testing.T) {
 	}
 }
 
-func TestUnionDifferenceMismatchedScores(t *testing.T) {
+func TestMergeMismatchedScores(t *testing.T) {
 	for input, expected := range map[string]string{
 		//
 		//   cluster 1  A5 B3 C1  A5 B3 C1  A5 B3 C1  A5 B2 C1  A5 B3 C1
@@ -72,7 +73,7 @@ func TestUnionDifferenceMismatchedScores(t *testing.T) {
 	} {
 		inputSets := s2tupleSets(t, input)
 		expectedSets := s2pair(t, expected)
-		union, difference := unionDifference(inputSets)
+		union, difference := mergeSets(inputSets)
 		if !reflect.DeepEqual(union, expectedSets.union) {
 			t.Errorf("%s: union: expected %v, got %v", input, expectedSets.union, union)
 		}
@@ -82,6 +83,16 @@ func TestUnionDifferenceMismatchedScores(t *testing.T) {
 	}
 }
 
+// mergeSets merges the sets like responses of clusters, without a limit.
+func mergeSets(tupleSets []tupleSet) (tupleSet, keyMemberSet) {
+	responses := make([][]common.KeyScoreMember, len(tupleSets))
+	for i, s := range tupleSets {
+		responses[i] = s.orderedLimitedSlice(len(s))
+	}
+	union, difference := merge(responses, math.MaxInt32, false)
+	return makeSet(union), difference
+}
+
 func s2tupleSets(t *testing.T, s string) []tupleSet {
 	a := []tupleSet{}
 	for _, s := range strings.Split(s, " ") {
@@ -0,0 +1,123 @@
+package farm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// ErrNoReadQuorum is returned by DeleteIf when too few clusters respond to
+// evaluate the guards.
+var ErrNoReadQuorum = errors.New("no read quorum")
+
+// DeleteIf is like Delete, but each delete only applies if its member is
+// still inserted with the expected score, i.e. if nobody wrote the member
+// since the deleter read it. That keeps a stale delete from clobbering a
+// newer re-insert with a lower score, e.g. from a producer with a skewed
+// clock.
+//
+// As a fast path, the guards are first evaluated by the farm, against the
+// newest version of each member across a read quorum of clusters: enough
+// clusters that at least one of them acknowledged any write which reached
+// the write quorum. Deletes whose guards fail there are never sent, so a
+// cluster which missed a re-insert can't apply a delete which read repair
+// would then propagate. The others are sent to every cluster with their
+// guards, which each cluster evaluates again atomically, with the guarded
+// delete script of cluster.GuardedDeleter, so a re-insert applied since the
+// read fails the guard there. Clusters which don't implement
+// cluster.GuardedDeleter fail the write.
+//
+// DeleteIf reports whether each delete was applied by any of the clusters
+// which responded before the write quorum was reached. If fewer clusters
+// than the read quorum respond, nothing is written, and ErrNoReadQuorum is
+// returned. If the write fails, the deletes reported as applied may or may
+// not have been applied by the other clusters, as with Delete.
+func (f *Farm) DeleteIf(deletes []cluster.GuardedDelete) ([]bool, error) {
+	applied := make([]bool, len(deletes))
+	if len(deletes) <= 0 {
+		return applied, nil
+	}
+	var (
+		tuples     = make([]common.KeyScoreMember, len(deletes))
+		keyMembers = make([]common.KeyMember, len(deletes))
+		readQuorum = len(f.clusters) - f.writeQuorum + 1
+	)
+	for i, d := range deletes {
+		tuples[i] = d.KeyScoreMember
+	}
+	tuples = f.transform(tuples)
+	stored := f.splits.split(tuples)
+	for i, tuple := range stored {
+		keyMembers[i] = common.KeyMember{Key: tuple.Key, Member: tuple.Member}
+	}
+
+	newest, err := f.newestQuorum(keyMembers, readQuorum)
+	if err != nil {
+		return applied, err
+	}
+	var (
+		guarded []cluster.GuardedDelete
+		indices []int // into deletes, of each guarded delete
+	)
+	for i, tuple := range stored {
+		current, ok := newest[keyMembers[i]]
+		if !ok || !current.Inserted || current.Score != deletes[i].Expected || tuple.Score < current.Score {
+			continue
+		}
+		guarded = append(guarded, cluster.GuardedDelete{KeyScoreMember: tuple, Expected: deletes[i].Expected})
+		indices = append(indices, i)
+	}
+	if len(guarded) <= 0 {
+		return applied, nil
+	}
+
+	var (
+		mu            sync.Mutex
+		guardedTuples = make([]common.KeyScoreMember, len(guarded))
+	)
+	for j, d := range guarded {
+		guardedTuples[j] = d.KeyScoreMember
+	}
+	_, err = f.write(
+		guardedTuples,
+		func(c cluster.Cluster, _ []common.KeyScoreMember) error {
+			d, ok := c.(cluster.GuardedDeleter)
+			if !ok {
+				return fmt.Errorf("guarded deletes not supported by %T", c)
+			}
+			a, err := d.DeleteIf(guarded)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for j := range a {
+				applied[indices[j]] = applied[indices[j]] || a[j]
+			}
+			return nil
+		},
+		deleteInstrumentation{f.instrumentation},
+		false,
+	)
+
+	// Clusters which respond after the quorum don't change the result.
+	mu.Lock()
+	result := make([]bool, len(applied))
+	copy(result, applied)
+	mu.Unlock()
+	if err != nil {
+		return result, err
+	}
+
+	var appliedTuples []common.KeyScoreMember
+	for i, ok := range result {
+		if ok {
+			appliedTuples = append(appliedTuples, tuples[i])
+		}
+	}
+	f.notify(OpDelete, appliedTuples)
+	return result, nil
+}
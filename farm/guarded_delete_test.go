@@ -0,0 +1,147 @@
+package farm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestDeleteIf(t *testing.T) {
+	fakes := []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+	for _, fake := range fakes {
+		if err := fake.Insert([]common.KeyScoreMember{
+			{Key: "foo", Score: 5, Member: "a"},
+			{Key: "foo", Score: 8, Member: "b"}, // re-inserted after the deleter read it at 5
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := New([]cluster.Cluster{fakes[0], fakes[1], fakes[2]}, 3, SendAllReadAll, NoRepairs, nil)
+	applied, err := f.DeleteIf([]cluster.GuardedDelete{
+		{KeyScoreMember: common.KeyScoreMember{Key: "foo", Score: 10, Member: "a"}, Expected: 5},
+		{KeyScoreMember: common.KeyScoreMember{Key: "foo", Score: 10, Member: "b"}, Expected: 5},
+		{KeyScoreMember: common.KeyScoreMember{Key: "foo", Score: 10, Member: "c"}, Expected: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{true, false, false}, applied; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for i, fake := range fakes {
+		presence, err := fake.Score([]common.KeyMember{{Key: "foo", Member: "a"}, {Key: "foo", Member: "b"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for keyMember, expected := range map[common.KeyMember]cluster.Presence{
+			{Key: "foo", Member: "a"}: {Present: true, Inserted: false, Score: 10},
+			{Key: "foo", Member: "b"}: {Present: true, Inserted: true, Score: 8},
+		} {
+			if got := presence[keyMember]; expected != got {
+				t.Errorf("cluster %d: %v: expected %+v, got %+v", i, keyMember, expected, got)
+			}
+		}
+	}
+
+	// A re-insert with a lower score, which reached the write quorum, but not
+	// every cluster, fails the guard on every cluster, so read repair can't
+	// propagate the stale delete.
+	fakes[2].Insert([]common.KeyScoreMember{{Key: "foo", Score: 3, Member: "c"}})
+	for _, fake := range fakes[:2] {
+		fake.Insert([]common.KeyScoreMember{{Key: "foo", Score: 4, Member: "c"}})
+	}
+	f = New([]cluster.Cluster{fakes[0], fakes[1], fakes[2]}, 2, SendAllReadAll, NoRepairs, nil)
+	applied, err = f.DeleteIf([]cluster.GuardedDelete{
+		{KeyScoreMember: common.KeyScoreMember{Key: "foo", Score: 10, Member: "c"}, Expected: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{false}, applied; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	for i, fake := range fakes {
+		presence, _ := fake.Score([]common.KeyMember{{Key: "foo", Member: "c"}})
+		if !presence[common.KeyMember{Key: "foo", Member: "c"}].Inserted {
+			t.Errorf("cluster %d: expected c to stay inserted", i)
+		}
+	}
+
+	// Without a read quorum, nothing is written.
+	deletes := fakes[0].CallCount(clustertest.DeleteIf)
+	fakes[1].FailWith(clustertest.Score, errors.New("unavailable"))
+	fakes[2].FailWith(clustertest.Score, errors.New("unavailable"))
+	if _, err := f.DeleteIf([]cluster.GuardedDelete{
+		{KeyScoreMember: common.KeyScoreMember{Key: "foo", Score: 11, Member: "b"}, Expected: 8},
+	}); !errors.Is(err, ErrNoReadQuorum) {
+		t.Errorf("expected %v, got %v", ErrNoReadQuorum, err)
+	}
+	if expected, got := deletes, fakes[0].CallCount(clustertest.DeleteIf); expected != got {
+		t.Errorf("expected %d deletes, got %d", expected, got)
+	}
+}
+
+// reinsertingCluster re-inserts a tuple right before each guarded delete, as
+// a concurrent writer would between the read and the write of DeleteIf.
+type reinsertingCluster struct {
+	*clustertest.Fake
+	tuple common.KeyScoreMember
+}
+
+func (c reinsertingCluster) DeleteIf(deletes []cluster.GuardedDelete) ([]bool, error) {
+	if err := c.Fake.Insert([]common.KeyScoreMember{c.tuple}); err != nil {
+		return nil, err
+	}
+	return c.Fake.DeleteIf(deletes)
+}
+
+func TestDeleteIfReinsertedSinceRead(t *testing.T) {
+	var (
+		tuple    = common.KeyScoreMember{Key: "foo", Score: 5, Member: "a"}
+		reinsert = common.KeyScoreMember{Key: "foo", Score: 7, Member: "a"}
+		fakes    = []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+		clusters = []cluster.Cluster{}
+	)
+	for _, fake := range fakes {
+		if err := fake.Insert([]common.KeyScoreMember{tuple}); err != nil {
+			t.Fatal(err)
+		}
+		clusters = append(clusters, reinsertingCluster{fake, reinsert})
+	}
+
+	// The guard holds when the farm reads, but no longer when the clusters
+	// write, so no cluster applies the delete.
+	f := New(clusters, 3, SendAllReadAll, NoRepairs, nil)
+	applied, err := f.DeleteIf([]cluster.GuardedDelete{
+		{KeyScoreMember: common.KeyScoreMember{Key: "foo", Score: 10, Member: "a"}, Expected: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{false}, applied; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	for i, fake := range fakes {
+		presence, err := fake.Score([]common.KeyMember{{Key: "foo", Member: "a"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := (cluster.Presence{Present: true, Inserted: true, Score: 7}), presence[common.KeyMember{Key: "foo", Member: "a"}]; expected != got {
+			t.Errorf("cluster %d: expected %+v, got %+v", i, expected, got)
+		}
+	}
+
+	// Clusters which can't guard deletes fail the write.
+	plain := New([]cluster.Cluster{struct{ cluster.Cluster }{fakes[0]}}, 1, SendAllReadAll, NoRepairs, nil)
+	if _, err := plain.DeleteIf([]cluster.GuardedDelete{
+		{KeyScoreMember: common.KeyScoreMember{Key: "foo", Score: 10, Member: "a"}, Expected: 7},
+	}); err == nil {
+		t.Errorf("expected an error, got none")
+	}
+}
@@ -0,0 +1,24 @@
+package farm
+
+import (
+	"fmt"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// Inspect returns the raw state of the key in each cluster, in order, via
+// cluster.Inspect, with up to limit members of each of its sets. Comparing
+// the states tells how the clusters diverge, and which deletes keep members
+// from being returned. Clusters which can't be read report an error in their
+// state, rather than failing the whole inspection.
+func (f *Farm) Inspect(key string, limit int) ([]cluster.KeyState, error) {
+	states := make([]cluster.KeyState, len(f.clusters))
+	for i, c := range f.clusters {
+		state, err := cluster.Inspect(c, key, limit)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %d: %s", i, err)
+		}
+		states[i] = state
+	}
+	return states, nil
+}
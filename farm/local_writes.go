@@ -0,0 +1,166 @@
+package farm
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// LocalWrites enables the local write concern, for ingest-heavy producers
+// which would rather lose a write now and then than wait for remote zones.
+// The writes of the view returned by Local are acknowledged as soon as any
+// cluster in the zone has applied them. They're still sent to every cluster
+// at once, and the clusters which haven't responded yet, or failed, are
+// written to in the background: failed writes are retried up to attempts
+// times, after a backoff which doubles with each attempt. Writes which still
+// fail are logged, and their keys passed to the Backfiller, if any, so that
+// the walker repairs them first.
+//
+// At most queue writes are completed in the background at once. Further
+// writes which need it are dropped from the background, rather than piling
+// up goroutines and their tuples while a remote zone is down: they're
+// counted as InsertLocalDropped, and their keys passed to the Backfiller,
+// like writes which still fail.
+//
+// Until every cluster has applied an acknowledged write, it's only as
+// durable as the local clusters which have. If they lose it before then,
+// e.g. in a restart, it's lost. Zones must be configured with the Zones
+// option, and the zone must have at least one cluster; check it with
+// ValidZones. Otherwise, the option is ignored, and Local returns the farm
+// itself.
+func LocalWrites(zone string, attempts int, backoff time.Duration, queue int) Option {
+	return func(f *Farm) {
+		if queue < 1 {
+			queue = 1
+		}
+		f.localWrites = &localWrites{
+			zone:     zone,
+			attempts: attempts,
+			backoff:  backoff,
+			pending:  make(chan struct{}, queue),
+		}
+	}
+}
+
+// localWrites is the configuration of the local write concern.
+type localWrites struct {
+	zone     string
+	local    []bool // per cluster, whether it's in the zone
+	attempts int
+	backoff  time.Duration
+	pending  chan struct{} // a slot per write completed in the background
+}
+
+// resolve finds the clusters of the zone, among the zones of the clusters.
+// It returns an error if there are none.
+func (l *localWrites) resolve(zones []string) error {
+	if err := ValidZones(zones, l.zone); err != nil {
+		return err
+	}
+	l.local = make([]bool, len(zones))
+	for i, zone := range zones {
+		l.local[i] = zone == l.zone
+	}
+	return nil
+}
+
+// Local returns a view of the farm whose writes have the local write
+// concern, as configured by the LocalWrites option. Its WriteResults require
+// a single acknowledgement, from a local cluster. Without the LocalWrites
+// option, the farm itself is returned, and writes wait for the quorum.
+func (f *Farm) Local() *Farm {
+	if f.localWrites == nil || f.acknowledgers != nil {
+		return f
+	}
+	view := *f
+	view.writeQuorum = 1
+	view.acknowledgers = f.localWrites.local
+	view.selecter = f.readStrategy(&view)
+	return &view
+}
+
+// replicate completes a write acknowledged by a local cluster, in the
+// background: it waits for the pending responses, and retries the clusters
+// which failed. If the background is full, the write is dropped from it.
+func (f *Farm) replicate(
+	tuples []common.KeyScoreMember,
+	action func(cluster.Cluster, []common.KeyScoreMember) error,
+	failed []int,
+	responses <-chan writeResponse,
+	pending int,
+) {
+	if pending <= 0 && len(failed) <= 0 {
+		return // every cluster applied it
+	}
+	select {
+	case f.localWrites.pending <- struct{}{}:
+	default:
+		log.Printf("local writes: dropping %d tuple(s) from the background: the queue is full", len(tuples))
+		f.instrumentation.InsertLocalDropped(len(tuples))
+		f.backfillTuples(tuples)
+		return
+	}
+	go func() {
+		defer func() { <-f.localWrites.pending }()
+		for i := 0; i < pending; i++ {
+			if resp := <-responses; resp.err != nil {
+				failed = append(failed, resp.index)
+			}
+		}
+		var wg sync.WaitGroup
+		for _, index := range failed {
+			wg.Add(1)
+			go func(index int) {
+				defer wg.Done()
+				f.retryWrite(index, tuples, action)
+			}(index)
+		}
+		wg.Wait()
+	}()
+}
+
+// retryWrite retries the write of the tuples to the cluster, with backoff,
+// until it succeeds, or the attempts of the local write concern run out.
+func (f *Farm) retryWrite(index int, tuples []common.KeyScoreMember, action func(cluster.Cluster, []common.KeyScoreMember) error) {
+	var (
+		c     = f.clusters[index]
+		delay = f.localWrites.backoff
+		err   = fmt.Errorf("no attempts")
+	)
+	for attempt := 0; attempt < f.localWrites.attempts; attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+		began := time.Now()
+		err = action(c, tuples)
+		if f.health != nil {
+			f.health.observe(c, time.Since(began), err != nil)
+		}
+		if err == nil {
+			return
+		}
+	}
+	log.Printf("local writes: cluster %d: giving up on %d tuple(s) after %d attempt(s): %s", index, len(tuples), f.localWrites.attempts, err)
+	f.backfillTuples(tuples)
+}
+
+// backfillTuples passes the keys of the tuples to the Backfiller, if any.
+func (f *Farm) backfillTuples(tuples []common.KeyScoreMember) {
+	if f.backfiller == nil {
+		return
+	}
+	var (
+		keys = []string{}
+		seen = map[string]bool{}
+	)
+	for _, tuple := range tuples {
+		if !seen[tuple.Key] {
+			seen[tuple.Key] = true
+			keys = append(keys, tuple.Key)
+		}
+	}
+	f.backfiller.Backfill(keys)
+}
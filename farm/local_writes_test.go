@@ -0,0 +1,134 @@
+package farm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func TestLocalWrites(t *testing.T) {
+	var (
+		local      = clustertest.New()
+		slow       = clustertest.New()
+		failing    = clustertest.New()
+		backfiller = &mockBackfiller{}
+		farm       = New(
+			[]cluster.Cluster{local, slow, failing}, 3, SendAllReadAll, NoRepairs, nil,
+			Zones([]string{"a", "b", "b"}),
+			LocalWrites("a", 2, time.Millisecond, 10),
+			BackfillDivergences(backfiller),
+		)
+	)
+	slow.Delay(clustertest.Insert, 50*time.Millisecond)
+	failing.FailWith(clustertest.Insert, errors.New("failtown"))
+
+	began := time.Now()
+	if err := farm.Local().Insert([]common.KeyScoreMember{testingKeyScoreMember}); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(began); took >= 50*time.Millisecond {
+		t.Errorf("expected the local cluster to acknowledge the write, but it took %s", took)
+	}
+
+	// The slow cluster is written to in the background, and the failing
+	// cluster retried, then given up on.
+	time.Sleep(100 * time.Millisecond)
+	if n := slow.CallCount(clustertest.Insert); n != 1 {
+		t.Errorf("expected 1 insert of the slow cluster, got %d", n)
+	}
+	if n := failing.CallCount(clustertest.Insert); n != 3 {
+		t.Errorf("expected 3 inserts of the failing cluster, got %d", n)
+	}
+	if expected, got := []string{testingKeyScoreMember.Key}, backfiller.backfilled(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v backfilled, got %v", expected, got)
+	}
+	for i, c := range []*clustertest.Fake{local, slow} {
+		e := <-c.SelectOffset([]string{testingKeyScoreMember.Key}, 0, 10)
+		if len(e.KeyScoreMembers) != 1 {
+			t.Errorf("cluster %d: expected the tuple, got %v", i, e.KeyScoreMembers)
+		}
+	}
+
+	// Remote acknowledgements don't count.
+	local.FailWith(clustertest.Insert, errors.New("failtown"))
+	failing.FailWith(clustertest.Insert, nil)
+	if err := farm.Local().Insert([]common.KeyScoreMember{testingKeyScoreMember}); err == nil {
+		t.Errorf("expected a quorum failure without the local cluster, got none")
+	}
+
+	// Without the option, writes wait for the quorum.
+	plain := New([]cluster.Cluster{local}, 1, SendAllReadAll, NoRepairs, nil)
+	if plain.Local() != plain {
+		t.Errorf("expected the farm itself without LocalWrites")
+	}
+}
+
+type localDropCounter struct {
+	instrumentation.NopInstrumentation
+	dropped int
+}
+
+func (i *localDropCounter) InsertLocalDropped(n int) { i.dropped += n }
+
+func TestLocalWritesDropped(t *testing.T) {
+	var (
+		local      = clustertest.New()
+		remote     = clustertest.New()
+		backfiller = &mockBackfiller{}
+		counter    = &localDropCounter{}
+		farm       = New(
+			[]cluster.Cluster{local, remote}, 2, SendAllReadAll, NoRepairs, counter,
+			Zones([]string{"a", "b"}),
+			LocalWrites("a", 0, 0, 1),
+			BackfillDivergences(backfiller),
+		)
+		first  = common.KeyScoreMember{Key: "first", Score: 1, Member: "a"}
+		second = common.KeyScoreMember{Key: "second", Score: 1, Member: "a"}
+	)
+	remote.Delay(clustertest.Insert, 50*time.Millisecond)
+
+	// The first write takes the only slot of the background, so the second
+	// is dropped from it.
+	for _, tuple := range []common.KeyScoreMember{first, second} {
+		if err := farm.Local().Insert([]common.KeyScoreMember{tuple}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected, got := 1, counter.dropped; expected != got {
+		t.Errorf("expected %d tuple dropped, got %d", expected, got)
+	}
+	if expected, got := []string{second.Key}, backfiller.backfilled(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v backfilled, got %v", expected, got)
+	}
+
+	// Once the first write is complete, the slot is free again.
+	time.Sleep(100 * time.Millisecond)
+	if err := farm.Local().Insert([]common.KeyScoreMember{first}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, counter.dropped; expected != got {
+		t.Errorf("expected still %d tuple dropped, got %d", expected, got)
+	}
+}
+
+func TestLocalWritesUnknownZone(t *testing.T) {
+	if err := ValidZones([]string{"a", "b"}, "c"); err == nil {
+		t.Errorf("expected an error for a zone without clusters, got none")
+	}
+
+	// The farm doesn't panic, and writes wait for the quorum.
+	farm := New(
+		[]cluster.Cluster{clustertest.New(), clustertest.New()}, 2, SendAllReadAll, NoRepairs, nil,
+		Zones([]string{"a", "b"}),
+		LocalWrites("c", 0, 0, 1),
+	)
+	if farm.Local() != farm {
+		t.Errorf("expected the farm itself without clusters in the zone")
+	}
+}
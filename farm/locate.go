@@ -0,0 +1,22 @@
+package farm
+
+import (
+	"fmt"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// Locate returns the Redis instance which the key maps to in each cluster, in
+// order, via cluster.Locate. It's meant for operators looking for the data of
+// a key, e.g. during an incident.
+func (f *Farm) Locate(key string) ([]cluster.Location, error) {
+	locations := make([]cluster.Location, len(f.clusters))
+	for i, c := range f.clusters {
+		location, err := cluster.Locate(c, key)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %d: %s", i, err)
+		}
+		locations[i] = location
+	}
+	return locations, nil
+}
@@ -0,0 +1,267 @@
+package farm
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+const (
+	// maxDeferredRepairs bounds the key-members recorded, per cluster, for
+	// the repair of a cluster leaving maintenance. Writes beyond it are left
+	// to the walker.
+	maxDeferredRepairs = 100000
+
+	// deferredRepairBatchSize is how many key-members are repaired at a time,
+	// when a cluster leaves maintenance, so that progress can be reported.
+	deferredRepairBatchSize = 1000
+)
+
+// MaintenanceRepair is the progress of the repair of a cluster which is
+// leaving maintenance.
+type MaintenanceRepair struct {
+	Began      time.Time `json:"began"`
+	KeyMembers int       `json:"key_members"` // written during maintenance
+	Repaired   int       `json:"repaired"`    // so far
+	Overflow   bool      `json:"overflow"`    // more were written, which the walker has to repair
+}
+
+// SetMaintenance puts the cluster with the index into maintenance, or takes
+// it out of maintenance, e.g. for a rolling upgrade of its Redis instances.
+// A cluster in maintenance is excluded from reads, and writes aren't sent to
+// it, so that it neither slows down nor fails requests. Write quorum is
+// counted over the other clusters, and a cluster can't be put into
+// maintenance if fewer than the write quorum would remain.
+//
+// The key-members written in the meantime are recorded, up to a bound, and
+// repaired in the background when the cluster leaves maintenance. Writes
+// are sent to it again at once, but it's read from only once the repair is
+// done; until then, it's reported as in maintenance, and can't be put into
+// maintenance again. If the bound was exceeded, the walker has to repair the
+// rest. With WarmHotKeys, the hot keys are repaired first.
+func (f *Farm) SetMaintenance(index int, on bool) error {
+	if index < 0 || index >= len(f.clusters) {
+		return fmt.Errorf("no cluster %d (have %d)", index, len(f.clusters))
+	}
+	if on {
+		return f.maintenance.enter(index, f.writeQuorum)
+	}
+	keyMembers, ok := f.maintenance.pending(index)
+	if ok {
+		go f.repairDeferred(index, keyMembers)
+	}
+	return nil
+}
+
+// repairDeferred repairs the key-members written while the cluster was in
+// maintenance, and then lets it be read from again.
+func (f *Farm) repairDeferred(index int, keyMembers []common.KeyMember) {
+	defer f.maintenance.leave(index)
+	f.warmup(index)
+	if len(keyMembers) > 0 {
+		log.Printf("maintenance: cluster %d: repairing %d key-member(s) written during maintenance", index, len(keyMembers))
+	}
+	repair := AllRepairs(f.clusters, f.instrumentation)
+	for len(keyMembers) > 0 {
+		n := deferredRepairBatchSize
+		if n > len(keyMembers) {
+			n = len(keyMembers)
+		}
+		repair(keyMembers[:n])
+		f.maintenance.repaired(index, n)
+		keyMembers = keyMembers[n:]
+	}
+	if f.maintenance.overflowed(index) {
+		log.Printf("maintenance: cluster %d: more than %d key-member(s) written during maintenance; the walker has to repair the rest", index, maxDeferredRepairs)
+	}
+	log.Printf("maintenance: cluster %d: repaired, reading from it again", index)
+}
+
+// Maintenance returns, for each cluster, whether it's in maintenance, or
+// still being repaired after leaving it.
+func (f *Farm) Maintenance() []bool {
+	if f.maintenance == nil {
+		return make([]bool, len(f.clusters))
+	}
+	return f.maintenance.snapshot()
+}
+
+// MaintenanceRepairs returns the progress of the repair of each cluster
+// which is leaving maintenance, by index.
+func (f *Farm) MaintenanceRepairs() map[int]MaintenanceRepair {
+	if f.maintenance == nil {
+		return map[int]MaintenanceRepair{}
+	}
+	return f.maintenance.progress()
+}
+
+// readable returns a view of the farm over the clusters which aren't in
+// maintenance, for reads, or the farm itself if none is.
+func (f *Farm) readable() *Farm {
+	if f.maintenance == nil {
+		return f
+	}
+	keep, excluded := f.maintenance.available()
+	if !excluded {
+		return f
+	}
+	view := f.restrict(keep)
+	view.maintenance = nil
+	return view
+}
+
+// maintenance tracks the clusters of a farm in maintenance, and the
+// key-members written while they were. It's safe for concurrent use.
+type maintenance struct {
+	sync.Mutex
+	on       []bool
+	draining []*MaintenanceRepair // leaving maintenance: written to, but not read from
+	drained  []chan struct{}      // closed when draining ends
+	deferred []map[common.KeyMember]struct{}
+	overflow []bool
+}
+
+func newMaintenance(n int) *maintenance {
+	return &maintenance{
+		on:       make([]bool, n),
+		draining: make([]*MaintenanceRepair, n),
+		drained:  make([]chan struct{}, n),
+		deferred: make([]map[common.KeyMember]struct{}, n),
+		overflow: make([]bool, n),
+	}
+}
+
+func (m *maintenance) enter(index, writeQuorum int) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.on[index] {
+		return nil
+	}
+	if m.draining[index] != nil {
+		return fmt.Errorf("cluster %d is still being repaired after maintenance", index)
+	}
+	remaining := 0
+	for i := range m.on {
+		if !m.on[i] && i != index {
+			remaining++
+		}
+	}
+	if remaining < writeQuorum {
+		return fmt.Errorf("only %d cluster(s) would remain out of maintenance, fewer than the write quorum of %d", remaining, writeQuorum)
+	}
+	m.on[index] = true
+	m.deferred[index] = map[common.KeyMember]struct{}{}
+	m.overflow[index] = false
+	return nil
+}
+
+// pending resumes writes to the cluster, which keeps being excluded from
+// reads until leave, and returns the key-members written in maintenance,
+// and whether it was in maintenance at all.
+func (m *maintenance) pending(index int) ([]common.KeyMember, bool) {
+	m.Lock()
+	defer m.Unlock()
+	if !m.on[index] {
+		return nil, false
+	}
+	keyMembers := make([]common.KeyMember, 0, len(m.deferred[index]))
+	for keyMember := range m.deferred[index] {
+		keyMembers = append(keyMembers, keyMember)
+	}
+	m.on[index] = false
+	m.draining[index] = &MaintenanceRepair{
+		Began:      time.Now(),
+		KeyMembers: len(keyMembers),
+		Overflow:   m.overflow[index],
+	}
+	m.drained[index] = make(chan struct{})
+	m.deferred[index], m.overflow[index] = nil, false
+	return keyMembers, true
+}
+
+// repaired records the progress of the repair of the draining cluster.
+func (m *maintenance) repaired(index, n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.draining[index].Repaired += n
+}
+
+func (m *maintenance) overflowed(index int) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.draining[index].Overflow
+}
+
+func (m *maintenance) leave(index int) {
+	m.Lock()
+	defer m.Unlock()
+	m.draining[index] = nil
+	close(m.drained[index])
+}
+
+// wait blocks until the cluster isn't draining.
+func (m *maintenance) wait(index int) {
+	m.Lock()
+	draining, drained := m.draining[index], m.drained[index]
+	m.Unlock()
+	if draining != nil {
+		<-drained
+	}
+}
+
+func (m *maintenance) progress() map[int]MaintenanceRepair {
+	m.Lock()
+	defer m.Unlock()
+	progress := map[int]MaintenanceRepair{}
+	for i, repair := range m.draining {
+		if repair != nil {
+			progress[i] = *repair
+		}
+	}
+	return progress
+}
+
+// skip returns whether writes to the cluster are deferred, and if so records
+// the key-members of the tuples for its repair.
+func (m *maintenance) skip(index int, tuples []common.KeyScoreMember) bool {
+	if m == nil {
+		return false
+	}
+	m.Lock()
+	defer m.Unlock()
+	if !m.on[index] {
+		return false
+	}
+	for _, tuple := range tuples {
+		if len(m.deferred[index]) >= maxDeferredRepairs {
+			m.overflow[index] = true
+			break
+		}
+		m.deferred[index][common.KeyMember{Key: tuple.Key, Member: tuple.Member}] = struct{}{}
+	}
+	return true
+}
+
+// available returns, for each cluster, whether it may be read from, and
+// whether any may not.
+func (m *maintenance) available() ([]bool, bool) {
+	m.Lock()
+	defer m.Unlock()
+	keep, excluded := make([]bool, len(m.on)), false
+	for i := range m.on {
+		keep[i] = !m.on[i] && m.draining[i] == nil
+		excluded = excluded || !keep[i]
+	}
+	return keep, excluded
+}
+
+func (m *maintenance) snapshot() []bool {
+	keep, _ := m.available()
+	for i := range keep {
+		keep[i] = !keep[i]
+	}
+	return keep
+}
@@ -0,0 +1,99 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestMaintenance(t *testing.T) {
+	var (
+		fakes    = []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+		clusters = []cluster.Cluster{fakes[0], fakes[1], fakes[2]}
+		farm     = New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+		tuple    = common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"}
+	)
+	if health := farm.Health(); health != nil {
+		t.Errorf("expected no health without maintenance or tracking, got %+v", health)
+	}
+	if err := farm.SetMaintenance(3, true); err == nil {
+		t.Errorf("expected an error for a cluster out of range, got none")
+	}
+	if err := farm.SetMaintenance(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := farm.SetMaintenance(2, true); err == nil {
+		t.Errorf("expected an error below the write quorum, got none")
+	}
+	if expected, got := []bool{false, true, false}, farm.Maintenance(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected maintenance %v, got %v", expected, got)
+	}
+	if health := farm.Health(); len(health) != 3 || !health[1].Maintenance || health[0].Maintenance {
+		t.Errorf("expected cluster 1 in maintenance in the health output, got %+v", health)
+	}
+
+	// Writes and reads skip the cluster, and still reach quorum.
+	result, err := farm.InsertVerbose([]common.KeyScoreMember{tuple})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{0, 2}; !reflect.DeepEqual(expected, result.Acknowledged) {
+		t.Errorf("expected acknowledgements of %v, got %v", expected, result.Acknowledged)
+	}
+	if _, err := farm.SelectOffset([]string{"foo"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	for i, fake := range fakes {
+		expected := 1
+		if i == 1 {
+			expected = 0
+		}
+		if got := fake.CallCount(clustertest.Insert); expected != got {
+			t.Errorf("cluster %d: expected %d Insert(s), got %d", i, expected, got)
+		}
+		if got := fake.CallCount(clustertest.SelectOffset); expected != got {
+			t.Errorf("cluster %d: expected %d Select(s), got %d", i, expected, got)
+		}
+	}
+
+	// Leaving maintenance repairs what was written in the meantime, in the
+	// background. The cluster is read from again once the repair is done.
+	fakes[1].Delay(clustertest.Score, 50*time.Millisecond)
+	if err := farm.SetMaintenance(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{false, true, false}, farm.Maintenance(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected maintenance %v during the repair, got %v", expected, got)
+	}
+	if repair, ok := farm.MaintenanceRepairs()[1]; !ok || repair.KeyMembers != 1 || repair.Repaired != 0 {
+		t.Errorf("expected the repair of 1 key-member in progress, got %+v", farm.MaintenanceRepairs())
+	}
+	if err := farm.SetMaintenance(1, true); err == nil {
+		t.Errorf("expected an error entering maintenance during the repair, got none")
+	}
+	calls := fakes[1].CallCount(clustertest.SelectOffset)
+	if _, err := farm.SelectOffset([]string{"foo"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if got := fakes[1].CallCount(clustertest.SelectOffset); got != calls {
+		t.Errorf("expected no Selects of cluster 1 during the repair, got %d", got-calls)
+	}
+	farm.maintenance.wait(1)
+	if repairs := farm.MaintenanceRepairs(); len(repairs) != 0 {
+		t.Errorf("expected no repairs in progress, got %+v", repairs)
+	}
+	presences, err := fakes[1].Score([]common.KeyMember{{Key: "foo", Member: "bar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presence := presences[common.KeyMember{Key: "foo", Member: "bar"}]; !presence.Present || presence.Score != 1 {
+		t.Errorf("expected the insert repaired into cluster 1, got %+v", presence)
+	}
+	if health := farm.Health(); health != nil {
+		t.Errorf("expected no health after maintenance, got %+v", health)
+	}
+}
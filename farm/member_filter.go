@@ -0,0 +1,282 @@
+package farm
+
+import (
+	"container/list"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// MemberFilters holds a bloom filter of the members of each of the most
+// recently checked keys in memory, so that Contains may report most absent
+// members without a round-trip to the clusters. A filter is built lazily,
+// from a Select of its key, the first time the key is checked. Like the
+// Cache, filters are invalidated by the clusters when their keys are
+// modified, by any client; pass Invalidate to cluster.Tracking for every
+// cluster of the farm, and the MemberFilters to the farm with MemberFilter.
+//
+// Members which pass a filter are checked against the clusters as usual, so
+// a false positive only costs the round-trip which the filter would have
+// saved. A filter reflects its key as of the Select it was built from, and
+// so may miss a member which a Select wouldn't have returned, either.
+type MemberFilters struct {
+	mu                sync.Mutex
+	max               int
+	maxMembers        int
+	falsePositiveRate float64
+	entries           map[string]*list.Element // of *filterEntry
+	lru               *list.List               // most recently used first
+	pending           map[string]uint64        // key: token of the build which may fill it
+	token             uint64
+}
+
+type filterEntry struct {
+	key    string
+	filter *bloomFilter // nil if the key has too many members
+}
+
+// NewMemberFilters returns an empty MemberFilters, which holds the filters of
+// up to maxKeys keys. When it's full, the filter of the least recently used
+// key is evicted. Keys with more than maxMembers members aren't filtered.
+// Each filter is sized for its key to yield false positives at roughly the
+// falsePositiveRate, which must be between 0 and 1.
+func NewMemberFilters(maxKeys, maxMembers int, falsePositiveRate float64) *MemberFilters {
+	return &MemberFilters{
+		max:               maxKeys,
+		maxMembers:        maxMembers,
+		falsePositiveRate: falsePositiveRate,
+		entries:           map[string]*list.Element{},
+		lru:               list.New(),
+		pending:           map[string]uint64{},
+	}
+}
+
+// MemberFilter causes Contains to consult the MemberFilters before checking
+// the clusters. Writes via the farm invalidate the filters of the keys they
+// touch before they return, so a client sees its own inserts.
+func MemberFilter(m *MemberFilters) Option {
+	return func(f *Farm) { f.filters = m }
+}
+
+// Invalidate removes the filters of the keys. Nil keys remove every filter.
+// Invalidate is safe for concurrent use; pass it to cluster.Tracking.
+func (m *MemberFilters) Invalidate(keys []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if keys == nil {
+		m.entries = map[string]*list.Element{}
+		m.lru.Init()
+		m.pending = map[string]uint64{}
+		return
+	}
+	for _, key := range keys {
+		if e, ok := m.entries[key]; ok {
+			m.lru.Remove(e)
+			delete(m.entries, key)
+		}
+		delete(m.pending, key) // a concurrent build may have read a stale value
+	}
+}
+
+// Contains reports whether each of the key-members is present, i.e. whether
+// its newest write across the clusters is an insert. With the MemberFilter
+// option, key-members absent from the filter of their key are reported
+// absent without a round-trip; the others are checked like Rejected does.
+// An error is only returned if no cluster responds.
+func (f *Farm) Contains(keyMembers []common.KeyMember) ([]bool, error) {
+	var (
+		present   = make([]bool, len(keyMembers))
+		unchecked = keyMembers
+		passed    = map[common.KeyMember]bool{} // by a filter
+	)
+	if len(keyMembers) <= 0 {
+		return present, nil
+	}
+	keyMembers = f.splits.splitKeyMembers(keyMembers)
+	unchecked = keyMembers
+	if f.filters != nil {
+		unchecked = make([]common.KeyMember, 0, len(keyMembers))
+		filters := f.filters.filtersOf(f.selecter, keyMembers, f.complete)
+		for _, keyMember := range keyMembers {
+			filter, ok := filters[keyMember.Key]
+			if !ok || filter == nil {
+				unchecked = append(unchecked, keyMember)
+				continue
+			}
+			if filter.test(keyMember.Member) {
+				unchecked = append(unchecked, keyMember)
+				passed[keyMember] = true
+			}
+		}
+		f.instrumentation.SelectMemberFilterNegatives(len(keyMembers) - len(unchecked))
+	}
+	if len(unchecked) <= 0 {
+		return present, nil
+	}
+
+	newest, err := f.newest(unchecked)
+	if err != nil {
+		return nil, err
+	}
+	falsePositives := 0
+	for keyMember := range passed {
+		if presence, ok := newest[keyMember]; !ok || !presence.Inserted {
+			falsePositives++
+		}
+	}
+	if f.filters != nil {
+		f.instrumentation.SelectMemberFilterFalsePositives(falsePositives)
+	}
+	for i, keyMember := range keyMembers {
+		presence, ok := newest[keyMember]
+		present[i] = ok && presence.Inserted
+	}
+	return present, nil
+}
+
+// filtersOf returns the filters of the keys of the key-members, building the
+// missing ones from a Select of their keys. A key which isn't filtered maps
+// to a nil filter, or is missing, if its filter couldn't be built.
+func (m *MemberFilters) filtersOf(s Selecter, keyMembers []common.KeyMember, complete func() bool) map[string]*bloomFilter {
+	filters, misses, tokens := m.lookup(keyMembers)
+	if len(misses) <= 0 {
+		return filters
+	}
+
+	// A key with more than maxMembers members isn't filtered, which the
+	// extra member tells.
+	selected, err := s.SelectOffset(misses, 0, m.maxMembers+1)
+	if err != nil || !complete() {
+		selected = nil // release the tokens, but store nothing
+	}
+	built := make(map[string]*bloomFilter, len(selected))
+	for key, tuples := range selected {
+		if len(tuples) > m.maxMembers {
+			built[key] = nil
+			continue
+		}
+		filter := newBloomFilter(len(tuples), m.falsePositiveRate)
+		for _, tuple := range tuples {
+			filter.add(tuple.Member)
+		}
+		built[key] = filter
+	}
+	m.store(built, tokens) // releases the tokens
+	for key, filter := range built {
+		filters[key] = filter
+	}
+	return filters
+}
+
+// lookup returns the filters of the keys of the key-members, and the keys
+// whose filters are missing. Each miss is registered as pending, with a
+// token that's required to store its filter, as in Cache.lookup.
+func (m *MemberFilters) lookup(keyMembers []common.KeyMember) (map[string]*bloomFilter, []string, map[string]uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var (
+		filters = map[string]*bloomFilter{}
+		misses  = []string{}
+		tokens  = map[string]uint64{}
+	)
+	for _, keyMember := range keyMembers {
+		key := keyMember.Key
+		if _, ok := filters[key]; ok {
+			continue
+		}
+		if _, ok := tokens[key]; ok {
+			continue
+		}
+		if e, ok := m.entries[key]; ok {
+			m.lru.MoveToFront(e)
+			filters[key] = e.Value.(*filterEntry).filter
+			continue
+		}
+		m.token++
+		m.pending[key], tokens[key] = m.token, m.token
+		misses = append(misses, key)
+	}
+	return filters, misses, tokens
+}
+
+// store holds the built filters of the keys whose tokens are still valid,
+// and releases all the tokens.
+func (m *MemberFilters) store(built map[string]*bloomFilter, tokens map[string]uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, token := range tokens {
+		if m.pending[key] != token {
+			continue // invalidated, or taken over by a later build
+		}
+		delete(m.pending, key)
+		filter, ok := built[key]
+		if !ok || m.max <= 0 {
+			continue
+		}
+		if e, ok := m.entries[key]; ok {
+			*e.Value.(*filterEntry) = filterEntry{key, filter}
+			m.lru.MoveToFront(e)
+			continue
+		}
+		m.entries[key] = m.lru.PushFront(&filterEntry{key, filter})
+		for m.lru.Len() > m.max {
+			oldest := m.lru.Back()
+			m.lru.Remove(oldest)
+			delete(m.entries, oldest.Value.(*filterEntry).key)
+		}
+	}
+}
+
+// bloomFilter is a fixed-size set of members, which may report members it
+// doesn't contain, but never misses one it does. It's immutable once built,
+// and safe for concurrent reads.
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// newBloomFilter returns an empty filter sized to hold n members, with the
+// false positive rate p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	var (
+		m = math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+		k = math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	)
+	return &bloomFilter{
+		bits:   make([]uint64, (uint64(m)+63)/64),
+		hashes: uint64(k),
+	}
+}
+
+func (b *bloomFilter) add(member string) {
+	h1, h2, m := b.hash(member)
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloomFilter) test(member string) bool {
+	h1, h2, m := b.hash(member)
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hash derives the hashes of the member by double hashing, from the halves
+// of its FNV-1a hash, and returns them with the size of the filter in bits.
+func (b *bloomFilter) hash(member string) (uint64, uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1, uint64(len(b.bits)) * 64
+}
@@ -0,0 +1,122 @@
+package farm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestMemberFilter(t *testing.T) {
+	var (
+		fake    = clustertest.New()
+		filters = NewMemberFilters(10, 100, 0.01)
+		farm    = New([]cluster.Cluster{fake}, 1, SendAllReadAll, NoRepairs, nil, MemberFilter(filters))
+		fooA    = common.KeyMember{Key: "foo", Member: "a"}
+		fooB    = common.KeyMember{Key: "foo", Member: "b"}
+		barC    = common.KeyMember{Key: "bar", Member: "c"}
+	)
+	if err := fake.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var selects, scores int
+	check := func(keyMembers []common.KeyMember, expected []bool, expectedSelects, expectedScores int) {
+		got, err := farm.Contains(keyMembers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%v: expected %v, got %v", keyMembers, expected, got)
+		}
+		selects, scores = selects+expectedSelects, scores+expectedScores
+		if expected, got := selects, fake.CallCount(clustertest.SelectOffset); expected != got {
+			t.Errorf("%v: expected %d Select(s) of the cluster, got %d", keyMembers, expected, got)
+		}
+		if expected, got := scores, fake.CallCount(clustertest.Score); expected != got {
+			t.Errorf("%v: expected %d Score(s) of the cluster, got %d", keyMembers, expected, got)
+		}
+	}
+
+	// Both filters are built by one Select, and only foo:a passes.
+	check([]common.KeyMember{fooA, fooB, barC}, []bool{true, false, false}, 1, 1)
+	check([]common.KeyMember{fooB, barC}, []bool{false, false}, 0, 0)
+
+	// Writes via the farm invalidate immediately.
+	if err := farm.Insert([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	check([]common.KeyMember{fooB}, []bool{true}, 1, 1)
+
+	// Another client writes, and the cluster invalidates the key.
+	if err := fake.Insert([]common.KeyScoreMember{{Key: "bar", Score: 1, Member: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	check([]common.KeyMember{barC}, []bool{false}, 0, 0) // not yet invalidated
+	filters.Invalidate([]string{"bar"})
+	check([]common.KeyMember{barC}, []bool{true}, 1, 1)
+
+	// Members deleted since the filter was built pass it, but are absent.
+	if err := fake.Delete([]common.KeyScoreMember{{Key: "foo", Score: 3, Member: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	check([]common.KeyMember{fooA}, []bool{false}, 0, 1)
+	filters.Invalidate(nil)
+	check([]common.KeyMember{fooA}, []bool{false}, 1, 0)
+}
+
+func TestMemberFilterTooManyMembers(t *testing.T) {
+	var (
+		fake = clustertest.New()
+		farm = New([]cluster.Cluster{fake}, 1, SendAllReadAll, NoRepairs, nil, MemberFilter(NewMemberFilters(10, 1, 0.01)))
+	)
+	if err := fake.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 1, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key isn't filtered, so every check goes to the cluster, but the
+	// key isn't selected again.
+	for i := 0; i < 2; i++ {
+		got, err := farm.Contains([]common.KeyMember{{Key: "foo", Member: "c"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []bool{false}; !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	}
+	if expected, got := 1, fake.CallCount(clustertest.SelectOffset); expected != got {
+		t.Errorf("expected %d Select(s) of the cluster, got %d", expected, got)
+	}
+	if expected, got := 2, fake.CallCount(clustertest.Score); expected != got {
+		t.Errorf("expected %d Score(s) of the cluster, got %d", expected, got)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	const n, p = 1000, 0.01
+	filter := newBloomFilter(n, p)
+	for i := 0; i < n; i++ {
+		filter.add(fmt.Sprintf("member-%d", i))
+	}
+	for i := 0; i < n; i++ {
+		if member := fmt.Sprintf("member-%d", i); !filter.test(member) {
+			t.Fatalf("%s: false negative", member)
+		}
+	}
+	falsePositives := 0
+	for i := n; i < 11*n; i++ {
+		if filter.test(fmt.Sprintf("member-%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / (10 * n); rate > 3*p {
+		t.Errorf("expected a false positive rate of about %.3f, got %.3f", p, rate)
+	}
+}
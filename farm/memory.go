@@ -0,0 +1,23 @@
+package farm
+
+import (
+	"fmt"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// MemoryUsage estimates the memory used by each key in each cluster, via
+// cluster.MemoryUsage, indexed by cluster and then by key, in order.
+// Clusters which can't be read report an error in the usage of their keys,
+// rather than failing the whole estimate.
+func (f *Farm) MemoryUsage(keys []string) ([][]cluster.KeyMemory, error) {
+	usage := make([][]cluster.KeyMemory, len(f.clusters))
+	for i, c := range f.clusters {
+		u, err := cluster.MemoryUsage(c, keys)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %d: %s", i, err)
+		}
+		usage[i] = u
+	}
+	return usage, nil
+}
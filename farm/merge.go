@@ -0,0 +1,127 @@
+package farm
+
+import (
+	"container/heap"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// merge computes the response for a key from the responses of the clusters,
+// each of them ordered like the Select: by descending score, or by ascending
+// score, if ascending is set. The union is every member with its best
+// (highest) score, in the same order, up to limit. The difference is every
+// member without perfect agreement across the responses.
+//
+// merge does a k-way merge of the responses with a heap, in descending order,
+// which yields equal tuples next to each other. A member is in perfect
+// agreement if every response has it with the same score, so a run of equal
+// tuples shorter than the number of responses puts its member in the
+// difference. Descending, the first run of a member has its best score, and
+// any later run means the member is in the difference already, so the union
+// needs no other bookkeeping. Ascending responses are merged from their ends,
+// and the union is reversed.
+func merge(responses [][]common.KeyScoreMember, limit int, ascending bool) ([]common.KeyScoreMember, keyMemberSet) {
+	h := &mergeHeap{}
+	total := 0
+	for _, response := range responses {
+		if len(response) <= 0 {
+			continue
+		}
+		c := mergeCursor{tuples: response, step: 1}
+		if ascending {
+			c.next, c.step = len(response)-1, -1
+		}
+		h.cursors = append(h.cursors, c)
+		total += len(response)
+	}
+	heap.Init(h)
+
+	size := total
+	if !ascending && limit < size {
+		size = limit // ascending, the union is only known at the end
+	}
+	var (
+		union      = make([]common.KeyScoreMember, 0, size)
+		difference = keyMemberSet{}
+		run        common.KeyScoreMember
+		runLength  = 0
+	)
+	endRun := func() {
+		if runLength <= 0 {
+			return
+		}
+		keyMember := common.KeyMember{Key: run.Key, Member: run.Member}
+		if _, seen := difference[keyMember]; !seen && len(union) < cap(union) {
+			union = append(union, run)
+		}
+		if runLength < len(responses) {
+			difference.add(keyMember)
+		}
+	}
+	for h.Len() > 0 {
+		c := &h.cursors[0]
+		if tuple := c.tuple(); runLength > 0 && tuple == run {
+			runLength++
+		} else {
+			endRun()
+			run, runLength = tuple, 1
+		}
+		if c.advance() {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	endRun()
+
+	if ascending {
+		for i, j := 0, len(union)-1; i < j; i, j = i+1, j-1 {
+			union[i], union[j] = union[j], union[i]
+		}
+		if len(union) > limit {
+			union = union[:limit]
+		}
+	}
+	return union, difference
+}
+
+// mergeCursor is the position of a merge in the response of one cluster,
+// which moves by step, from the end of the response if it's ascending.
+type mergeCursor struct {
+	tuples []common.KeyScoreMember
+	next   int
+	step   int
+}
+
+func (c *mergeCursor) tuple() common.KeyScoreMember { return c.tuples[c.next] }
+
+// advance moves the cursor to the next tuple, and returns false if there
+// are none left.
+func (c *mergeCursor) advance() bool {
+	c.next += c.step
+	return c.next >= 0 && c.next < len(c.tuples)
+}
+
+// mergeHeap implements heap.Interface, ordering the cursors by their next
+// tuple, in the order of common.KeyScoreMember.Before. Equal scores are
+// ordered by member, so the union is the same whichever order the responses
+// arrived in.
+type mergeHeap struct {
+	cursors []mergeCursor
+}
+
+func (h *mergeHeap) Len() int { return len(h.cursors) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	return h.cursors[i].tuple().Before(h.cursors[j].tuple())
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.cursors = append(h.cursors, x.(mergeCursor)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	c := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return c
+}
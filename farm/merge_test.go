@@ -0,0 +1,158 @@
+package farm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestMergeLimit(t *testing.T) {
+	tuple := func(member string, score float64) common.KeyScoreMember {
+		return common.KeyScoreMember{Key: "k", Score: score, Member: member}
+	}
+	ascending := [][]common.KeyScoreMember{
+		{tuple("a", 1), tuple("b", 2), tuple("c", 3)},
+		{tuple("b", 0.5), tuple("a", 1), tuple("c", 3)}, // b is stale
+	}
+	descending := [][]common.KeyScoreMember{
+		{tuple("c", 3), tuple("b", 2), tuple("a", 1)},
+		{tuple("c", 3), tuple("a", 1), tuple("b", 0.5)},
+	}
+
+	for _, testCase := range []struct {
+		responses [][]common.KeyScoreMember
+		limit     int
+		ascending bool
+		expected  []common.KeyScoreMember
+	}{
+		{descending, 2, false, []common.KeyScoreMember{tuple("c", 3), tuple("b", 2)}},
+		{descending, 10, false, []common.KeyScoreMember{tuple("c", 3), tuple("b", 2), tuple("a", 1)}},
+		{ascending, 2, true, []common.KeyScoreMember{tuple("a", 1), tuple("b", 2)}},
+		{ascending, 0, true, []common.KeyScoreMember{}},
+	} {
+		union, difference := merge(testCase.responses, testCase.limit, testCase.ascending)
+		if !reflect.DeepEqual(testCase.expected, union) {
+			t.Errorf("limit %d, ascending %v: expected %v, got %v", testCase.limit, testCase.ascending, testCase.expected, union)
+		}
+		if expected := (keyMemberSet{{Key: "k", Member: "b"}: struct{}{}}); !reflect.DeepEqual(expected, difference) {
+			t.Errorf("limit %d, ascending %v: expected difference %v, got %v", testCase.limit, testCase.ascending, expected, difference)
+		}
+	}
+}
+
+func TestMergeTies(t *testing.T) {
+	tuple := func(member string, score float64) common.KeyScoreMember {
+		return common.KeyScoreMember{Key: "k", Score: score, Member: member}
+	}
+
+	// Each response is in Redis order, with equal scores ordered by member.
+	// The union mustn't depend on which response was read first.
+	descending := [][]common.KeyScoreMember{
+		{tuple("a", 2), tuple("c", 1), tuple("b", 1)},
+		{tuple("d", 1), tuple("b", 1), tuple("a", 1)},
+	}
+	ascending := [][]common.KeyScoreMember{
+		{tuple("b", 1), tuple("c", 1), tuple("a", 2)},
+		{tuple("a", 1), tuple("b", 1), tuple("d", 1)},
+	}
+	for _, testCase := range []struct {
+		responses [][]common.KeyScoreMember
+		ascending bool
+		expected  []common.KeyScoreMember
+	}{
+		{descending, false, []common.KeyScoreMember{tuple("a", 2), tuple("d", 1), tuple("c", 1)}},
+		{ascending, true, []common.KeyScoreMember{tuple("b", 1), tuple("c", 1), tuple("d", 1)}},
+	} {
+		for _, order := range [][]int{{0, 1}, {1, 0}} {
+			reordered := [][]common.KeyScoreMember{testCase.responses[order[0]], testCase.responses[order[1]]}
+			if union, _ := merge(reordered, 3, testCase.ascending); !reflect.DeepEqual(testCase.expected, union) {
+				t.Errorf("ascending %v, responses %v: expected %v, got %v", testCase.ascending, order, testCase.expected, union)
+			}
+		}
+	}
+}
+
+func TestMergeDifference(t *testing.T) {
+	tuple := func(member string, score float64) common.KeyScoreMember {
+		return common.KeyScoreMember{Key: "k", Score: score, Member: member}
+	}
+
+	// a agrees, b has a stale score in one response, c is missing from one,
+	// and d and e share a score, but not the member.
+	responses := [][]common.KeyScoreMember{
+		{tuple("a", 5), tuple("b", 4), tuple("e", 2), tuple("c", 1)},
+		{tuple("a", 5), tuple("b", 4), tuple("e", 2), tuple("c", 1)},
+		{tuple("a", 5), tuple("d", 2), tuple("b", 1)},
+	}
+	union, difference := merge(responses, 10, false)
+	if expected := []common.KeyScoreMember{tuple("a", 5), tuple("b", 4), tuple("e", 2), tuple("d", 2), tuple("c", 1)}; !reflect.DeepEqual(expected, union) {
+		t.Errorf("expected %v, got %v", expected, union)
+	}
+	if expected := (keyMemberSet{
+		{Key: "k", Member: "b"}: struct{}{},
+		{Key: "k", Member: "c"}: struct{}{},
+		{Key: "k", Member: "d"}: struct{}{},
+		{Key: "k", Member: "e"}: struct{}{},
+	}); !reflect.DeepEqual(expected, difference) {
+		t.Errorf("expected difference %v, got %v", expected, difference)
+	}
+}
+
+// benchmarkResponses returns the responses of n clusters for a key with
+// size members, in descending order, each missing a different member.
+func benchmarkResponses(n, size int) [][]common.KeyScoreMember {
+	responses := make([][]common.KeyScoreMember, n)
+	for i := range responses {
+		for j := size; j > 0; j-- {
+			if j%n != i {
+				responses[i] = append(responses[i], common.KeyScoreMember{Key: "k", Score: float64(j), Member: fmt.Sprint(j)})
+			}
+		}
+	}
+	return responses
+}
+
+// BenchmarkMergeSort is the baseline: the union of every response, sorted,
+// as computed previously.
+func BenchmarkMergeSort(b *testing.B) {
+	responses := benchmarkResponses(3, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var (
+			scores     = map[common.KeyMember]float64{}
+			counts     = map[common.KeyScoreMember]int{}
+			union      = tupleSet{}
+			difference = keyMemberSet{}
+		)
+		for _, response := range responses {
+			for _, tuple := range response {
+				keyMember := common.KeyMember{Key: tuple.Key, Member: tuple.Member}
+				if score, ok := scores[keyMember]; !ok || tuple.Score > score {
+					scores[keyMember] = tuple.Score
+				}
+				counts[tuple]++
+			}
+		}
+		for keyMember, score := range scores {
+			union.add(common.KeyScoreMember{Key: keyMember.Key, Score: score, Member: keyMember.Member})
+		}
+		for tuple, count := range counts {
+			if count < len(responses) {
+				difference.add(common.KeyMember{Key: tuple.Key, Member: tuple.Member})
+			}
+		}
+		union.orderedLimitedSlice(10)
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	responses := benchmarkResponses(3, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		merge(responses, 10, false)
+	}
+}
@@ -2,6 +2,7 @@ package farm
 
 import (
 	"errors"
+	"math"
 	"reflect"
 	"sort"
 	"sync/atomic"
@@ -157,6 +158,29 @@ func (c *mockCluster) SelectOffset(keys []string, offset, limit int) <-chan clus
 	return ch
 }
 
+func (c *mockCluster) SelectOffsetAscending(keys []string, offset, limit int) <-chan cluster.Element {
+	ch := make(chan cluster.Element)
+	go func() {
+		defer close(ch)
+		for e := range c.SelectOffset(keys, 0, math.MaxInt32) {
+			a := e.KeyScoreMembers
+			for i, j := 0, len(a)-1; i < j; i, j = i+1, j-1 {
+				a[i], a[j] = a[j], a[i]
+			}
+			if len(a) <= offset {
+				a = []common.KeyScoreMember{}
+			} else {
+				a = a[offset:]
+			}
+			if len(a) > limit {
+				a = a[:limit]
+			}
+			ch <- cluster.Element{Key: e.Key, KeyScoreMembers: a, Error: e.Error}
+		}
+	}()
+	return ch
+}
+
 func (c *mockCluster) SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan cluster.Element {
 	ch := make(chan cluster.Element)
 	go func() { close(ch) }()
@@ -0,0 +1,58 @@
+package farm
+
+import (
+	"log"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Ops of a KeyEvent.
+const (
+	OpInsert = "insert"
+	OpDelete = "delete"
+)
+
+// KeyEvent describes a write to a single key: the op, and how many members
+// of the key it wrote. Members which lose to newer scores are counted, too.
+type KeyEvent struct {
+	Key     string
+	Op      string
+	Members int
+}
+
+// Notifier is told about the keys modified by writes, so that downstream
+// systems can react to changes without polling. See package webhook for an
+// implementation.
+type Notifier interface {
+	Notify(events []KeyEvent) error
+}
+
+// NotifyWrites causes every successful Insert and Delete to be passed to the
+// Notifier as one KeyEvent per key, after the write quorum has been reached.
+// Notify errors are logged, and don't cause the write to fail, as the tuples
+// have already been written.
+func NotifyWrites(n Notifier) Option {
+	return func(f *Farm) { f.notifier = n }
+}
+
+func (f *Farm) notify(op string, tuples []common.KeyScoreMember) {
+	if f.notifier == nil || len(tuples) <= 0 {
+		return
+	}
+	var (
+		events = []KeyEvent{}
+		index  = map[string]int{} // key: index into events
+	)
+	for _, tuple := range tuples {
+		i, ok := index[tuple.Key]
+		if !ok {
+			i = len(events)
+			index[tuple.Key] = i
+			events = append(events, KeyEvent{Key: tuple.Key, Op: op})
+		}
+		events[i].Members++
+	}
+	if err := f.notifier.Notify(events); err != nil {
+		log.Printf("notify: %d %s event(s): %s", len(events), op, err)
+	}
+}
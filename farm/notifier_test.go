@@ -0,0 +1,53 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+type mockNotifier struct{ events []KeyEvent }
+
+func (n *mockNotifier) Notify(events []KeyEvent) error {
+	n.events = append(n.events, events...)
+	return nil
+}
+
+func TestNotifyWrites(t *testing.T) {
+	var (
+		notifier = &mockNotifier{}
+		clusters = newMockClusters(3)
+		farm     = New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil, NotifyWrites(notifier))
+	)
+
+	if err := farm.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"},
+		common.KeyScoreMember{Key: "bar", Score: 1, Member: "a"},
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := farm.Delete([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 2, Member: "a"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []KeyEvent{
+		KeyEvent{Key: "foo", Op: OpInsert, Members: 2},
+		KeyEvent{Key: "bar", Op: OpInsert, Members: 1},
+		KeyEvent{Key: "foo", Op: OpDelete, Members: 1},
+	}
+	if !reflect.DeepEqual(expected, notifier.events) {
+		t.Errorf("expected %v, got %v", expected, notifier.events)
+	}
+
+	// Failed writes aren't notified.
+	failing := New(newFailingMockClusters(1), 1, SendAllReadAll, NoRepairs, nil, NotifyWrites(notifier))
+	if err := failing.Delete([]common.KeyScoreMember{common.KeyScoreMember{Key: "foo", Score: 3, Member: "a"}}); err == nil {
+		t.Fatal("expected error, got none")
+	}
+	if n := len(notifier.events); n != len(expected) {
+		t.Errorf("expected %d events, got %d", len(expected), n)
+	}
+}
@@ -15,12 +15,29 @@ import (
 // ParseFarmString parses a farm declaration string into a slice of clusters.
 // A farm string is a semicolon-separated list of cluster strings. A cluster
 // string is a comma-separated list of Redis instances. All whitespace is
-// ignored.
+// ignored. Options are passed to every cluster.
 //
 // An example farm string is:
 //
 //  "foo1:6379, foo2:6379; bar1:6379, bar2:6379, bar3:6379, bar4:6379"
 //
+// An instance may be followed by "=weight", so that it receives a share of
+// its cluster's keys proportional to the weight, e.g. to account for
+// instances with different memory sizes. The default weight is 1.
+//
+//  "foo1:6379=2, foo2:6379=1, foo3:6379=1"
+//
+// An instance may also be given as the absolute path of a Unix domain socket,
+// for Redis instances on the same host.
+//
+//  "/var/run/redis/foo1.sock, foo2:6379"
+//
+// A cluster may be followed by "@zone", to tag it with the zone, e.g. the
+// region or datacenter, it's deployed in. Zones are ignored by
+// ParseFarmString; see ParseZones.
+//
+//  "foo1:6379, foo2:6379 @ eu-west; bar1:6379, bar2:6379 @ us-east"
+//
 func ParseFarmString(
 	farmString string,
 	connectTimeout, readTimeout, writeTimeout time.Duration,
@@ -29,35 +46,59 @@ func ParseFarmString(
 	maxSize int,
 	selectGap time.Duration,
 	instr instrumentation.Instrumentation,
+	options ...cluster.Option,
 ) ([]cluster.Cluster, error) {
 	var (
 		seen     = map[string]int{}
 		clusters = []cluster.Cluster{}
 	)
 	for i, clusterString := range strings.Split(stripWhitespace(farmString), ";") {
-		hostPorts := []string{}
+		clusterString, _, err := splitZone(clusterString)
+		if err != nil {
+			return []cluster.Cluster{}, err
+		}
+		var (
+			hostPorts = []string{}
+			weights   = []int{}
+		)
 		for _, hostPort := range strings.Split(clusterString, ",") {
 			if hostPort == "" {
 				continue
 			}
-			toks := strings.Split(hostPort, ":")
-			if len(toks) != 2 {
-				return []cluster.Cluster{}, fmt.Errorf("invalid host-port %q", hostPort)
+			weight := 1
+			if toks := strings.SplitN(hostPort, "=", 2); len(toks) == 2 {
+				w, err := strconv.Atoi(toks[1])
+				if err != nil || w < 1 {
+					return []cluster.Cluster{}, fmt.Errorf("invalid weight %q in %q", toks[1], hostPort)
+				}
+				hostPort, weight = toks[0], w
 			}
-			if _, err := strconv.ParseUint(toks[1], 10, 16); err != nil {
-				return []cluster.Cluster{}, fmt.Errorf("invalid port %q in host-port %q (%s)", toks[1], hostPort, err)
+			if !strings.HasPrefix(hostPort, "/") { // Unix domain socket paths are taken as-is
+				toks := strings.Split(hostPort, ":")
+				if len(toks) != 2 {
+					return []cluster.Cluster{}, fmt.Errorf("invalid host-port %q", hostPort)
+				}
+				if _, err := strconv.ParseUint(toks[1], 10, 16); err != nil {
+					return []cluster.Cluster{}, fmt.Errorf("invalid port %q in host-port %q (%s)", toks[1], hostPort, err)
+				}
 			}
 			seen[hostPort]++
 			hostPorts = append(hostPorts, hostPort)
+			weights = append(weights, weight)
 		}
 		if len(hostPorts) <= 0 {
 			return []cluster.Cluster{}, fmt.Errorf("empty cluster %d (%q)", i+1, clusterString)
 		}
+		p, err := pool.NewWeighted(hostPorts, weights, connectTimeout, readTimeout, writeTimeout, redisMCPI, hash)
+		if err != nil {
+			return []cluster.Cluster{}, err
+		}
 		clusters = append(clusters, cluster.New(
-			pool.New(hostPorts, connectTimeout, readTimeout, writeTimeout, redisMCPI, hash),
+			p,
 			maxSize,
 			selectGap,
 			instr,
+			options...,
 		))
 		log.Printf("cluster %d: %d instance(s)", i+1, len(hostPorts))
 	}
@@ -79,6 +120,34 @@ func ParseFarmString(
 	return clusters, nil
 }
 
+// ParseZones returns the zone of each cluster declared in the farm string, in
+// the same order as the clusters returned by ParseFarmString, for the Zones
+// option. Clusters without a zone are in the zone "".
+func ParseZones(farmString string) ([]string, error) {
+	zones := []string{}
+	for _, clusterString := range strings.Split(stripWhitespace(farmString), ";") {
+		_, zone, err := splitZone(clusterString)
+		if err != nil {
+			return []string{}, err
+		}
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+// splitZone splits the optional "@zone" suffix from a cluster string.
+func splitZone(clusterString string) (string, string, error) {
+	i := strings.LastIndex(clusterString, "@")
+	if i < 0 {
+		return clusterString, "", nil
+	}
+	zone := clusterString[i+1:]
+	if zone == "" {
+		return "", "", fmt.Errorf("empty zone in %q", clusterString)
+	}
+	return clusterString[:i], zone, nil
+}
+
 func stripWhitespace(src string) string {
 	var dst []rune
 	for _, c := range src {
@@ -3,6 +3,7 @@ package farm
 import (
 	"io/ioutil"
 	"log"
+	"reflect"
 	"testing"
 	"time"
 
@@ -55,6 +56,18 @@ func TestParseFarmString(t *testing.T) {
 		"a1:1234,a2:1234,a3:1234;b1:1234,b2:1234,b3:1234": {true, 2},
 		"a1:1234,a2:1234 ; b1:1234,b2:1234 ; c1:1234":     {true, 3},
 		"a1:1234,a2:1234 ; a1:1234,b2:1234 ; c1:1234":     {false, 0}, // duplicates
+		"a1:1234=2,a2:1234;b1:1234,b2:1234=3":             {true, 2},
+		"a1:1234=1,a1:1234=2":                             {false, 0}, // duplicates
+		"a1:1234=0":                                       {false, 0}, // invalid weight
+		"a1:1234=x":                                       {false, 0}, // invalid weight
+		"a1:1234=":                                        {false, 0}, // invalid weight
+		"/tmp/a1.sock,a2:1234;b1:1234":                    {true, 2},
+		"/tmp/a1.sock=2,/tmp/a2.sock":                     {true, 1},
+		"/tmp/a1.sock,/tmp/a1.sock":                       {false, 0}, // duplicates
+		"a1:1234,a2:1234 @ eu; b1:1234 @ us":              {true, 2},
+		"a1:1234,a2:1234 @ eu; b1:1234":                   {true, 2},
+		"a1:1234 @":                                       {false, 0}, // empty zone
+		"@ eu":                                            {false, 0}, // empty cluster
 	} {
 		clusters, err := ParseFarmString(
 			farmString,
@@ -78,3 +91,22 @@ func TestParseFarmString(t *testing.T) {
 		}
 	}
 }
+
+func TestParseZones(t *testing.T) {
+	for farmString, expected := range map[string][]string{
+		"a1:1234":                            []string{""},
+		"a1:1234;b1:1234":                    []string{"", ""},
+		"a1:1234,a2:1234 @ eu; b1:1234 @ us": []string{"eu", "us"},
+		"a1:1234 @ eu; b1:1234; c1:1234@eu":  []string{"eu", "", "eu"},
+		"/tmp/a1.sock @ eu":                  []string{"eu"},
+	} {
+		got, err := ParseZones(farmString)
+		if err != nil {
+			t.Errorf("%q: %s", farmString, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%q: expected %q, got %q", farmString, expected, got)
+		}
+	}
+}
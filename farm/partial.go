@@ -0,0 +1,186 @@
+package farm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// errDeadline is the error of elements which a cluster didn't return before
+// the deadline of a PartialSelecter.
+var errDeadline = errors.New("deadline exceeded")
+
+// PartialResultError is returned, along with the results, by the Selects of
+// a view returned by ReportPartial, when clusters missed the deadline of a
+// PartialSelecter. The results are merged from the clusters which responded,
+// and may be inconsistent.
+type PartialResultError struct {
+	Missed []int // indices of the clusters which missed the deadline
+}
+
+// Error implements the error interface.
+func (e PartialResultError) Error() string {
+	return fmt.Sprintf("partial results: cluster(s) %v missed the deadline", e.Missed)
+}
+
+// PartialSelecter is a Selecter whose Selects don't wait for clusters beyond
+// a deadline. See Farm.WithDeadline.
+type PartialSelecter interface {
+	Selecter
+
+	// Missed returns the indices of the clusters which didn't respond to
+	// every key before the deadline, in ascending order. Results may be
+	// inconsistent if it's not empty.
+	Missed() []int
+}
+
+// WithDeadline returns a PartialSelecter over the same clusters and with the
+// same ReadStrategy as the farm, for a single request. Keys a cluster hasn't
+// returned by the deadline are treated as errors by the read strategy, so
+// Selects return the merged results of the clusters which did respond, as
+// with any partial error, rather than waiting for the rest; Missed reports
+// whether they are. Results which may be partial aren't cached.
+func (f *Farm) WithDeadline(deadline time.Time) PartialSelecter {
+	var (
+		view    = *f
+		partial = &partialRead{deadline: deadline}
+	)
+	view.clusters = make([]cluster.Cluster, len(f.clusters))
+	for i, c := range f.clusters {
+		view.clusters[i] = deadlineCluster{Cluster: c, index: i, partial: partial, workers: f.workers}
+	}
+	view.partial = partial
+	view.selecter = f.readStrategy(&view)
+	return &view
+}
+
+// Missed implements PartialSelecter. It's empty for farms which weren't
+// returned by WithDeadline.
+func (f *Farm) Missed() []int {
+	if f.partial == nil {
+		return []int{}
+	}
+	return f.partial.missed()
+}
+
+// ReportPartial returns a view of the farm whose Selects return partial
+// results along with a PartialResultError, rather than a nil error, for
+// callers which check errors, rather than Missed. Results are only partial
+// with a deadline, so it's meant to be combined with WithDeadline, in either
+// order.
+func (f *Farm) ReportPartial() *Farm {
+	view := *f
+	view.reportPartial = true
+	return &view
+}
+
+// partialResult returns the results of a Select, with a PartialResultError
+// if clusters missed the deadline of the farm, and it reports them.
+func (f *Farm) partialResult(results map[string][]common.KeyScoreMember, err error) (map[string][]common.KeyScoreMember, error) {
+	if err != nil || !f.reportPartial || f.complete() {
+		return results, err
+	}
+	return results, PartialResultError{Missed: f.partial.missed()}
+}
+
+// complete returns whether the Selects of the farm were complete so far,
+// i.e. whether no cluster missed a deadline.
+func (f *Farm) complete() bool {
+	return f.partial == nil || len(f.partial.missed()) <= 0
+}
+
+// partialRead records which clusters missed the deadline of a request.
+type partialRead struct {
+	deadline time.Time
+
+	mu     sync.Mutex
+	misses map[int]bool
+}
+
+func (p *partialRead) miss(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.misses == nil {
+		p.misses = map[int]bool{}
+	}
+	p.misses[index] = true
+}
+
+func (p *partialRead) missed() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	indices := make([]int, 0, len(p.misses))
+	for index := range p.misses {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// deadlineCluster ends the Selects of the cluster at the deadline, with an
+// error element for every key which wasn't returned yet.
+type deadlineCluster struct {
+	cluster.Cluster
+	index   int
+	partial *partialRead
+	workers *selectWorkers // nil unless configured by SelectWorkers
+}
+
+func (c deadlineCluster) SelectOffset(keys []string, offset, limit int) <-chan cluster.Element {
+	return c.bound(keys, c.Cluster.SelectOffset(keys, offset, limit))
+}
+
+func (c deadlineCluster) SelectOffsetAscending(keys []string, offset, limit int) <-chan cluster.Element {
+	return c.bound(keys, c.Cluster.SelectOffsetAscending(keys, offset, limit))
+}
+
+func (c deadlineCluster) SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan cluster.Element {
+	return c.bound(keys, c.Cluster.SelectRange(keys, start, stop, limit))
+}
+
+func (c deadlineCluster) bound(keys []string, src <-chan cluster.Element) <-chan cluster.Element {
+	dst := make(chan cluster.Element)
+	go func() {
+		defer close(dst)
+		remaining := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			remaining[key] = true
+		}
+		timer := time.NewTimer(c.partial.deadline.Sub(time.Now()))
+		defer timer.Stop()
+		for {
+			select {
+			case e, ok := <-src:
+				if !ok {
+					return
+				}
+				delete(remaining, e.Key)
+				dst <- e
+			case <-timer.C:
+				if len(remaining) <= 0 {
+					continue // only the close is outstanding
+				}
+				c.partial.miss(c.index)
+				for key := range remaining {
+					dst <- cluster.Element{Key: key, KeyScoreMembers: []common.KeyScoreMember{}, Error: errDeadline}
+				}
+				if c.workers != nil {
+					c.workers.abandon(c.index, src)
+					return
+				}
+				go func() {
+					for _ = range src {
+						// drain, so the cluster isn't blocked
+					}
+				}()
+				return
+			}
+		}
+	}()
+	return dst
+}
@@ -0,0 +1,94 @@
+package farm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default bounds of the retry delay suggested by a QuorumError.
+const (
+	defaultQuorumRetryMin = 1 * time.Second
+	defaultQuorumRetryMax = 30 * time.Second
+)
+
+// QuorumRetryAfter bounds the retry delay suggested by QuorumErrors. If
+// cluster health is tracked, the delay grows from min towards max with the
+// error rates of the clusters which failed the write; otherwise, it's always
+// min. By default, the delay is between 1 and 30 seconds.
+func QuorumRetryAfter(min, max time.Duration) Option {
+	return func(f *Farm) {
+		if max < min {
+			max = min
+		}
+		f.quorumRetryMin, f.quorumRetryMax = min, max
+	}
+}
+
+// QuorumError is returned by writes which didn't reach the write quorum. The
+// write may have been applied by some clusters. Since writes are idempotent,
+// it's safe to retry it, preferably after RetryAfter, so that degraded
+// clusters aren't hammered by the retries.
+type QuorumError struct {
+	Result     WriteResult   // the outcome per cluster
+	RetryAfter time.Duration // suggested delay before retrying the write
+}
+
+// Error implements the error interface.
+func (e QuorumError) Error() string {
+	messages := make([]string, 0, len(e.Result.Failed))
+	for _, err := range e.Unwrap() {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("no quorum (%s)", strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of the clusters which failed the write, in the
+// order of the clusters, so that errors.Is and errors.As find them.
+func (e QuorumError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Result.Failed))
+	for _, index := range e.Failed() {
+		errs = append(errs, e.Result.Failed[index])
+	}
+	return errs
+}
+
+// Failed returns the indices of the clusters which failed the write, in
+// order.
+func (e QuorumError) Failed() []int {
+	failed := make([]int, 0, len(e.Result.Failed))
+	for index := range e.Result.Failed {
+		failed = append(failed, index)
+	}
+	sort.Ints(failed)
+	return failed
+}
+
+// retryAfter suggests how long to wait before retrying a write which failed
+// quorum. For the write to succeed, enough of the failed clusters have to
+// recover, and the likelihood of that is bounded by the error rate of the
+// healthiest of them which would be required. The higher that error rate,
+// the longer the delay.
+func (f *Farm) retryAfter(result WriteResult) time.Duration {
+	needed := result.Required - len(result.Acknowledged)
+	if f.health == nil || needed <= 0 {
+		return f.quorumRetryMin
+	}
+	if needed > len(result.Failed) {
+		return f.quorumRetryMax // the write can't succeed until clusters are added
+	}
+
+	errorRates := make([]float64, 0, len(result.Failed))
+	for _, health := range f.health.snapshot() {
+		if _, ok := result.Failed[health.Index]; ok {
+			errorRates = append(errorRates, health.ErrorRate)
+		}
+	}
+	if len(errorRates) < needed {
+		return f.quorumRetryMin
+	}
+	sort.Float64s(errorRates)
+	spread := float64(f.quorumRetryMax - f.quorumRetryMin)
+	return f.quorumRetryMin + time.Duration(errorRates[needed-1]*spread)
+}
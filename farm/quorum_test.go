@@ -0,0 +1,51 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestQuorumError(t *testing.T) {
+	clusters := []cluster.Cluster{newMockCluster(), newFailingMockCluster(), newFailingMockCluster()}
+	foo := []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "bar"}}
+
+	// Without cluster health, the minimum delay is suggested.
+	f := New(clusters, 2, SendAllReadAll, NoRepairs, nil, QuorumRetryAfter(time.Second, 3*time.Second))
+	err := f.Insert(foo)
+	quorumErr, ok := err.(QuorumError)
+	if !ok {
+		t.Fatalf("expected QuorumError, got %T (%v)", err, err)
+	}
+	if expected, got := []int{1, 2}, quorumErr.Failed(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected failed clusters %v, got %v", expected, got)
+	}
+	if expected, got := time.Second, quorumErr.RetryAfter; expected != got {
+		t.Errorf("without health: expected %s, got %s", expected, got)
+	}
+
+	// With cluster health, the delay grows with the error rate of the
+	// healthiest failed cluster: cluster 1 succeeded before, so its error
+	// rate is 0.5, while cluster 2 only ever failed.
+	f = New(clusters, 2, SendAllReadAll, NoRepairs, nil, TrackClusterHealth(0.5), QuorumRetryAfter(time.Second, 3*time.Second))
+	f.health.observe(clusters[1], time.Millisecond, false)
+	_, err = f.DeleteVerbose(foo)
+	if quorumErr, ok = err.(QuorumError); !ok {
+		t.Fatalf("expected QuorumError, got %T (%v)", err, err)
+	}
+	if expected, got := 2*time.Second, quorumErr.RetryAfter; expected != got {
+		t.Errorf("with health: expected %s, got %s", expected, got)
+	}
+	if quorumErr.Result.Quorum {
+		t.Errorf("expected no quorum in the result")
+	}
+
+	// A quorum larger than the farm can't be reached by retrying soon.
+	f = New(clusters, 4, SendAllReadAll, NoRepairs, nil, TrackClusterHealth(0.5), QuorumRetryAfter(time.Second, 3*time.Second))
+	if quorumErr, ok = f.Insert(foo).(QuorumError); !ok || quorumErr.RetryAfter != 3*time.Second {
+		t.Errorf("expected the maximum delay, got %+v", quorumErr)
+	}
+}
@@ -2,8 +2,6 @@ package farm
 
 import (
 	"fmt"
-	"log"
-	"math/rand"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +18,8 @@ type ReadStrategy func(*Farm) Selecter
 
 // SendOneReadOne is a ReadStrategy that chooses a random cluster, sends the
 // read request exclusively there, and  returns whatever result comes back.
+// With the PreferHealthyClusters option, it chooses the healthiest cluster
+// instead.
 // It's the simplest read strategy, and has the least impact on the network,
 // but isn't resilient to stale data.
 func SendOneReadOne(farm *Farm) Selecter { return sendOneReadOne{farm} }
@@ -33,6 +33,13 @@ func (s sendOneReadOne) SelectOffset(keys []string, offset, limit int) (map[stri
 	})
 }
 
+// SelectOffsetAscending implements farm.Selecter.
+func (s sendOneReadOne) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
+		return c.SelectOffsetAscending(keys, offset, limit)
+	})
+}
+
 // SelectRange implements farm.Selecter.
 func (s sendOneReadOne) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
 	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
@@ -56,8 +63,16 @@ func (s sendOneReadOne) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 		retrieved     = 0
 		response      = map[string][]common.KeyScoreMember{}
 		errors        = []string{}
+		elements      = make(chan cluster.Element)
+		wg            = sync.WaitGroup{}
 	)
-	for e := range fn(s.Farm.clusters[rand.Intn(len(s.Farm.clusters))]) {
+	wg.Add(1)
+	go func() { wg.Wait(); close(elements) }()
+	i := s.Farm.pick()
+	if err := s.Farm.scatterSelects(s.Farm.clusters[i:i+1], s.Farm.observing(fn), &wg, elements); err != nil {
+		return map[string][]common.KeyScoreMember{}, err
+	}
+	for e := range elements {
 		if firstResponseDuration == 0 {
 			firstResponseDuration = time.Since(blockingBegan)
 		}
@@ -75,6 +90,7 @@ func (s sendOneReadOne) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 		s.Farm.instrumentation.SelectOverheadDuration(d - blockingDuration)
 		s.Farm.instrumentation.SelectRetrieved(retrieved)
 		s.Farm.instrumentation.SelectReturned(retrieved) // for this strategy, retrieved == returned
+		s.Farm.amplification.observe("SendOneReadOne", retrieved, retrieved, s.Farm.instrumentation)
 	}(time.Since(began))
 
 	if len(errors) >= numKeys {
@@ -95,17 +111,24 @@ type sendAllReadAll struct{ *Farm }
 func (s sendAllReadAll) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
 	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
 		return c.SelectOffset(keys, offset, limit)
-	}, limit)
+	}, limit, false)
+}
+
+// SelectOffsetAscending implements farm.Selecter.
+func (s sendAllReadAll) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
+		return c.SelectOffsetAscending(keys, offset, limit)
+	}, limit, true)
 }
 
 // SelectRange implements farm.Selecter.
 func (s sendAllReadAll) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
 	return s.read(len(keys), func(c cluster.Cluster) <-chan cluster.Element {
 		return c.SelectRange(keys, start, stop, limit)
-	}, limit)
+	}, limit, false)
 }
 
-func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluster.Element, limit int) (map[string][]common.KeyScoreMember, error) {
+func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluster.Element, limit int, ascending bool) (map[string][]common.KeyScoreMember, error) {
 	began := time.Now()
 	go func() {
 		s.Farm.instrumentation.SelectCall()
@@ -123,7 +146,9 @@ func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 	go func() { wg.Wait(); close(elements) }()
 
 	blockingBegan := time.Now()
-	scatterSelects(s.Farm.clusters, fn, &wg, elements)
+	if err := s.Farm.scatterSelects(s.Farm.clusters, s.Farm.observing(fn), &wg, elements); err != nil {
+		return map[string][]common.KeyScoreMember{}, err
+	}
 
 	// Gather all elements. An error implies some problem with the Redis
 	// instance or the underlying cluster, and shouldn't trigger read
@@ -132,19 +157,19 @@ func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 	// responses with inconsistent data.)
 	var (
 		firstResponseDuration time.Duration
-		responses             = map[string][]tupleSet{}
+		responses             = map[string][][]common.KeyScoreMember{}
 		retrieved             = 0
 	)
 	for e := range elements {
 		if e.Error != nil {
-			log.Printf("SendAllReadAll partial error: %s", e.Error)
+			s.Farm.logf("SendAllReadAll partial error: %s", e.Error)
 			go s.Farm.instrumentation.SelectPartialError()
 			continue
 		}
 		if firstResponseDuration == 0 {
 			firstResponseDuration = time.Since(blockingBegan)
 		}
-		responses[e.Key] = append(responses[e.Key], makeSet(e.KeyScoreMembers))
+		responses[e.Key] = append(responses[e.Key], e.KeyScoreMembers)
 		retrieved += len(e.KeyScoreMembers)
 	}
 	blockingDuration := time.Since(blockingBegan)
@@ -155,10 +180,10 @@ func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 		repairs  = keyMemberSet{}
 		returned = 0
 	)
-	for key, tupleSets := range responses {
-		union, difference := unionDifference(tupleSets)
-		response[key] = union.orderedLimitedSlice(limit)
-		returned += len(response[key])
+	for key, tupleLists := range responses {
+		union, difference := merge(tupleLists, limit, ascending)
+		response[key] = union
+		returned += len(union)
 		repairs.addMany(difference)
 	}
 
@@ -178,6 +203,7 @@ func (s sendAllReadAll) read(numKeys int, fn func(cluster.Cluster) <-chan cluste
 		s.Farm.instrumentation.SelectOverheadDuration(time.Since(began) - blockingDuration)
 		s.Farm.instrumentation.SelectRetrieved(retrieved)
 		s.Farm.instrumentation.SelectReturned(returned)
+		s.Farm.amplification.observe("SendAllReadAll", retrieved, returned, s.Farm.instrumentation)
 	}()
 	return response, nil
 }
@@ -229,17 +255,24 @@ type sendVarReadFirstLinger struct {
 func (s sendVarReadFirstLinger) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
 	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
 		return c.SelectOffset(keys, offset, limit)
-	}, limit)
+	}, limit, false)
+}
+
+// SelectOffsetAscending implements farm.Selecter.
+func (s sendVarReadFirstLinger) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return c.SelectOffsetAscending(keys, offset, limit)
+	}, limit, true)
 }
 
 // SelectRange implements farm.Selecter.
 func (s sendVarReadFirstLinger) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
 	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
 		return c.SelectRange(keys, start, stop, limit)
-	}, limit)
+	}, limit, false)
 }
 
-func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []string) <-chan cluster.Element, limit int) (map[string][]common.KeyScoreMember, error) {
+func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []string) <-chan cluster.Element, limit int, ascending bool) (map[string][]common.KeyScoreMember, error) {
 	began := time.Now()
 	go func() {
 		s.Farm.instrumentation.SelectCall()
@@ -271,7 +304,7 @@ func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []s
 		clustersNotUsed = []cluster.Cluster{}
 	} else {
 		go s.Farm.instrumentation.SelectSendAllPermitRejected()
-		i := rand.Intn(len(s.Farm.clusters))
+		i := s.Farm.pick()
 		clustersUsed = s.Farm.clusters[i : i+1]
 		clustersNotUsed = make([]cluster.Cluster, 0, len(s.Farm.clusters)-1)
 		clustersNotUsed = append(clustersNotUsed, s.Farm.clusters[:i]...)
@@ -280,7 +313,10 @@ func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []s
 
 	blockingBegan := time.Now()
 	go s.Farm.instrumentation.SelectSendTo(len(clustersUsed))
-	scatterSelects(clustersUsed, func(c cluster.Cluster) <-chan cluster.Element { return fn(c, keys) }, &wg, elements)
+	overloaded := s.Farm.scatterSelects(clustersUsed, s.Farm.observing(func(c cluster.Cluster) <-chan cluster.Element { return fn(c, keys) }), &wg, elements) != nil
+	if overloaded && len(clustersNotUsed) <= 0 {
+		return map[string][]common.KeyScoreMember{}, ErrOverloaded
+	}
 
 	// remainingKeys keeps track of all keys for which we haven't received any
 	// non-error responses yet.
@@ -298,7 +334,7 @@ func (s sendVarReadFirstLinger) read(keys []string, fn func(cluster.Cluster, []s
 
 	var (
 		firstResponseDuration time.Duration
-		responses             = map[string][]tupleSet{}
+		responses             = map[string][][]common.KeyScoreMember{}
 		retrieved             = 0
 	)
 
@@ -311,7 +347,7 @@ loop:
 			}
 			retrieved += len(e.KeyScoreMembers)
 			if e.Error != nil {
-				log.Printf("SendVarReadFirstLinger initial read partial error: %s", e.Error)
+				s.Farm.logf("SendVarReadFirstLinger initial read partial error: %s", e.Error)
 				go s.Farm.instrumentation.SelectPartialError()
 				continue
 				// It might appear tempting to immediately send a Select to
@@ -325,7 +361,7 @@ loop:
 			if firstResponseDuration == 0 {
 				firstResponseDuration = time.Since(blockingBegan)
 			}
-			responses[e.Key] = append(responses[e.Key], makeSet(e.KeyScoreMembers))
+			responses[e.Key] = append(responses[e.Key], e.KeyScoreMembers)
 			delete(remainingKeys, e.Key)
 
 		case <-timeout:
@@ -337,7 +373,9 @@ loop:
 				remainingKeysSlice = append(remainingKeysSlice, k)
 			}
 			go s.Farm.instrumentation.SelectSendTo(len(clustersNotUsed))
-			scatterSelects(clustersNotUsed, func(c cluster.Cluster) <-chan cluster.Element { return fn(c, remainingKeysSlice) }, &wg, elements)
+			if err := s.Farm.scatterSelects(clustersNotUsed, s.Farm.observing(func(c cluster.Cluster) <-chan cluster.Element { return fn(c, remainingKeysSlice) }), &wg, elements); err == nil {
+				overloaded = false
+			}
 			clustersUsed = s.Farm.clusters
 			clustersNotUsed = []cluster.Cluster{}
 		}
@@ -361,6 +399,7 @@ loop:
 			s.Farm.instrumentation.SelectOverheadDuration(duration - blockingDuration)
 			s.Farm.instrumentation.SelectRetrieved(retrieved)
 			s.Farm.instrumentation.SelectReturned(returned)
+			s.Farm.amplification.observe("SendVarReadFirstLinger", retrieved, returned, s.Farm.instrumentation)
 		}()
 	}()
 
@@ -368,7 +407,10 @@ loop:
 	// Select calls have finished but we still did not get at least one result
 	// for each key. In either case, it's time to return results.
 	if len(responses) == 0 && len(remainingKeys) > 0 {
-		// All Selects returned an error.
+		// All Selects returned an error, or were rejected.
+		if overloaded {
+			return map[string][]common.KeyScoreMember{}, ErrOverloaded
+		}
 		return map[string][]common.KeyScoreMember{}, fmt.Errorf("complete failure")
 	}
 
@@ -376,11 +418,10 @@ loop:
 		response = map[string][]common.KeyScoreMember{}
 		repairs  = keyMemberSet{}
 	)
-	for key, tupleSets := range responses {
-		union, difference := unionDifference(tupleSets)
-		a := union.orderedLimitedSlice(limit)
-		response[key] = a
-		returned += len(a)
+	for key, tupleLists := range responses {
+		union, difference := merge(tupleLists, limit, ascending)
+		response[key] = union
+		returned += len(union)
 		repairs.addMany(difference)
 	}
 
@@ -414,14 +455,14 @@ loop:
 		for e := range elements {
 			lingeringRetrievals += len(e.KeyScoreMembers)
 			if e.Error != nil {
-				log.Printf("SendVarReadFirstLinger lingering retrieval partial error: %s", e.Error)
+				s.Farm.logf("SendVarReadFirstLinger lingering retrieval partial error: %s", e.Error)
 				go s.Farm.instrumentation.SelectPartialError()
 				continue
 			}
-			responses[e.Key] = append(responses[e.Key], makeSet(e.KeyScoreMembers))
+			responses[e.Key] = append(responses[e.Key], e.KeyScoreMembers)
 		}
-		for _, tupleSets := range responses {
-			_, difference := unionDifference(tupleSets)
+		for _, tupleLists := range responses {
+			_, difference := merge(tupleLists, 0, ascending)
 			repairs.addMany(difference)
 		}
 		if len(repairs) > 0 {
@@ -431,22 +472,7 @@ loop:
 			}()
 		}
 		s.Farm.instrumentation.SelectRetrieved(lingeringRetrievals) // additive
+		s.Farm.amplification.add("SendVarReadFirstLinger", lingeringRetrievals, 0)
 	}()
 	return response, nil
 }
-
-func scatterSelects(
-	clusters []cluster.Cluster,
-	fn func(cluster.Cluster) <-chan cluster.Element,
-	wg *sync.WaitGroup,
-	dst chan cluster.Element,
-) {
-	for _, c := range clusters {
-		go func(c cluster.Cluster) {
-			defer wg.Done()
-			for e := range fn(c) {
-				dst <- e
-			}
-		}(c)
-	}
-}
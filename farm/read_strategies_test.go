@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
 	"github.com/soundcloud/roshi/common"
 	"github.com/soundcloud/roshi/instrumentation"
 )
@@ -153,6 +154,46 @@ func TestSendAllReadAll(t *testing.T) {
 	}
 }
 
+type exemptCounter struct {
+	instrumentation.NopInstrumentation
+	exempted int32
+}
+
+func (c *exemptCounter) SelectRepairExempted(n int) { atomic.AddInt32(&c.exempted, int32(n)) }
+
+func TestWithoutRepairs(t *testing.T) {
+	clusters := newMockClusters(3)
+	repairs := int32(0)
+	instr := &exemptCounter{}
+	farm := New(clusters, len(clusters), SendAllReadAll, MockRepairs(&repairs), instr)
+	farm.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+
+	// Delete the ksm from one cluster, and read it without repairs.
+	clusters[0].Delete([]common.KeyScoreMember{testingKeyScoreMember})
+	result, err := farm.WithoutRepairs().SelectOffset([]string{"key", "nokey"}, 0, 10)
+	if err := checkResult(result, err); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 0, int(atomic.LoadInt32(&repairs)); expected != got {
+		t.Fatalf("expected %d repairs, got %d", expected, got)
+	}
+	if expected, got := 1, int(atomic.LoadInt32(&instr.exempted)); expected != got {
+		t.Fatalf("expected %d exempted repairs, got %d", expected, got)
+	}
+
+	// The same read through the farm itself still repairs.
+	result, err = farm.SelectOffset([]string{"key", "nokey"}, 0, 10)
+	if err := checkResult(result, err); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, int(atomic.LoadInt32(&repairs)); expected != got {
+		t.Fatalf("expected %d repairs, got %d", expected, got)
+	}
+	if expected, got := 1, int(atomic.LoadInt32(&instr.exempted)); expected != got {
+		t.Fatalf("expected %d exempted repairs, got %d", expected, got)
+	}
+}
+
 func TestSendAllReadFirstLinger(t *testing.T) {
 	clusters := newMockClusters(3)
 	repairs := int32(0)
@@ -241,6 +282,43 @@ func TestSendAllReadFirstLinger(t *testing.T) {
 	}
 }
 
+func TestSendAllReadFirstLingerStaleFirstResponse(t *testing.T) {
+	// With scripted delays, it's deterministic which cluster responds first:
+	// here, the only one that's missing the ksm.
+	var (
+		fast    = clustertest.New()
+		slow1   = clustertest.New()
+		slow2   = clustertest.New()
+		repairs = int32(0)
+	)
+	slow1.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	slow2.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	slow1.Delay(clustertest.SelectOffset, 20*time.Millisecond)
+	slow2.Delay(clustertest.SelectOffset, 20*time.Millisecond)
+
+	farm := New([]cluster.Cluster{fast, slow1, slow2}, 2, SendAllReadFirstLinger, MockRepairs(&repairs), nil)
+	result, err := farm.SelectOffset([]string{"key"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 0, len(result["key"]); expected != got {
+		t.Errorf("expected the stale result length %d, got %d", expected, got)
+	}
+
+	// The lingering goroutine collects the slow responses, and repairs.
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&repairs) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if expected, got := 1, int(atomic.LoadInt32(&repairs)); expected != got {
+		t.Errorf("expected %d repairs, got %d", expected, got)
+	}
+	for i, c := range []*clustertest.Fake{fast, slow1, slow2} {
+		if expected, got := 1, c.CallCount(clustertest.SelectOffset); expected != got {
+			t.Errorf("cluster %d: expected %d select calls, got %d", i, expected, got)
+		}
+	}
+}
+
 func TestSendVarReadFirstLinger(t *testing.T) {
 	clusters := newMockClusters(3)
 	repairs := int32(0)
@@ -305,3 +383,130 @@ func TestSendVarReadFirstLinger(t *testing.T) {
 		t.Error("not all channels closed")
 	}
 }
+
+func TestValidZones(t *testing.T) {
+	zones := []string{"us", "eu", ""}
+	for local, valid := range map[string]bool{
+		"eu": true,
+		"":   true,
+		"EU": false, // zones are case-sensitive
+		"ap": false,
+	} {
+		if err := ValidZones(zones, local); (err == nil) != valid {
+			t.Errorf("%q: expected valid %v, got %v", local, valid, err)
+		}
+	}
+	if err := ValidZones(zones); err != nil {
+		t.Errorf("no local zones: %s", err)
+	}
+}
+
+func TestPreferLocalZone(t *testing.T) {
+	var (
+		eu1 = clustertest.New()
+		eu2 = clustertest.New()
+		us1 = clustertest.New()
+		us2 = clustertest.New()
+	)
+	for _, c := range []*clustertest.Fake{eu1, eu2, us1, us2} {
+		c.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	}
+	farm := New(
+		[]cluster.Cluster{us1, eu1, us2, eu2},
+		2,
+		PreferLocalZone("eu", 2),
+		NoRepairs,
+		nil,
+		Zones([]string{"us", "eu", "us", "eu"}),
+	)
+	selectCounts := func() []int {
+		counts := []int{}
+		for _, c := range []*clustertest.Fake{eu1, eu2, us1, us2} {
+			counts = append(counts, c.CallCount(clustertest.SelectOffset))
+		}
+		return counts
+	}
+
+	// The local zone forms a quorum by itself.
+	result, err := farm.SelectOffset([]string{"key"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, len(result["key"]); expected != got {
+		t.Errorf("expected result length %d, got %d", expected, got)
+	}
+	if expected, got := fmt.Sprint([]int{1, 1, 0, 0}), fmt.Sprint(selectCounts()); expected != got {
+		t.Errorf("expected select calls %s, got %s", expected, got)
+	}
+
+	// A local cluster fails, so the remote zone is read as well.
+	eu2.FailWith(clustertest.SelectOffset, fmt.Errorf("down"))
+	result, err = farm.SelectOffset([]string{"key"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, len(result["key"]); expected != got {
+		t.Errorf("expected result length %d, got %d", expected, got)
+	}
+	if expected, got := fmt.Sprint([]int{2, 2, 1, 1}), fmt.Sprint(selectCounts()); expected != got {
+		t.Errorf("expected select calls %s, got %s", expected, got)
+	}
+
+	// Every cluster fails.
+	for _, c := range []*clustertest.Fake{eu1, us1, us2} {
+		c.FailWith(clustertest.SelectOffset, fmt.Errorf("down"))
+	}
+	if _, err := farm.SelectOffset([]string{"key"}, 0, 10); err == nil {
+		t.Errorf("expected error, got none")
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	var (
+		fast = clustertest.New()
+		slow = clustertest.New()
+	)
+	fast.Insert([]common.KeyScoreMember{testingKeyScoreMember})
+	slow.Delay(clustertest.SelectOffset, time.Second)
+	farm := New([]cluster.Cluster{fast, slow}, 2, SendAllReadAll, NoRepairs, nil, ClientSideCache(NewCache(10)))
+
+	partial := farm.WithDeadline(time.Now().Add(20 * time.Millisecond))
+	result, err := partial.SelectOffset([]string{"key"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, len(result["key"]); expected != got {
+		t.Errorf("expected result length %d, got %d", expected, got)
+	}
+	if expected, got := fmt.Sprint([]int{1}), fmt.Sprint(partial.Missed()); expected != got {
+		t.Errorf("expected missed clusters %s, got %s", expected, got)
+	}
+
+	// The partial result wasn't cached.
+	slow.Delay(clustertest.SelectOffset, 0)
+	if _, err := farm.SelectOffset([]string{"key"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 2, fast.CallCount(clustertest.SelectOffset); expected != got {
+		t.Errorf("expected %d select calls, got %d", expected, got)
+	}
+	if expected, got := fmt.Sprint([]int{}), fmt.Sprint(farm.Missed()); expected != got {
+		t.Errorf("expected missed clusters %s, got %s", expected, got)
+	}
+
+	// Only views which report partial results return them with an error.
+	slow.Delay(clustertest.SelectOffset, time.Second)
+	farm = New([]cluster.Cluster{fast, slow}, 2, SendAllReadAll, NoRepairs, nil)
+	reported := farm.WithDeadline(time.Now().Add(20 * time.Millisecond)).(*Farm).ReportPartial()
+	result, err = reported.SelectOffset([]string{"key"}, 0, 10)
+	if expected, got := fmt.Sprint(PartialResultError{Missed: []int{1}}), fmt.Sprint(err); expected != got {
+		t.Fatalf("expected error %s, got %s", expected, got)
+	}
+	if expected, got := 1, len(result["key"]); expected != got {
+		t.Errorf("expected result length %d, got %d", expected, got)
+	}
+	slow.Delay(clustertest.SelectOffset, 0)
+	if _, err := farm.ReportPartial().SelectOffset([]string{"key"}, 0, 10); err != nil {
+		t.Errorf("expected no error without a deadline, got %v", err)
+	}
+}
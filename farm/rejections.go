@@ -0,0 +1,110 @@
+package farm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// Rejection describes a written tuple which lost to a newer write of the same
+// key-member, e.g. because the clock of the producer is behind.
+type Rejection struct {
+	Tuple         common.KeyScoreMember // as written
+	WinningScore  float64               // of the newer write
+	WinnerDeleted bool                  // whether the newer write was a delete
+}
+
+// Rejected returns the tuples which have lost to newer writes of their
+// key-members, along with the winning scores. Call it after a successful
+// Insert of the tuples, so producers can detect and log clock-skew-induced
+// rejections, rather than assuming success.
+//
+// Rejected reads the scores back from every cluster, and compares each tuple
+// against the newest. That costs an extra round-trip, and a tuple which was
+// superseded by a newer write after it was applied is reported as well. An
+// error is only returned if no cluster responds.
+func (f *Farm) Rejected(tuples []common.KeyScoreMember) ([]Rejection, error) {
+	if len(tuples) <= 0 {
+		return []Rejection{}, nil
+	}
+	var (
+		written    = f.splits.split(f.transform(tuples))
+		keyMembers = make([]common.KeyMember, len(written))
+	)
+	for i, tuple := range written {
+		keyMembers[i] = common.KeyMember{Key: tuple.Key, Member: tuple.Member}
+	}
+
+	newest, err := f.newest(keyMembers)
+	if err != nil {
+		return []Rejection{}, err
+	}
+
+	rejections := []Rejection{}
+	for i, tuple := range tuples {
+		winner, ok := newest[keyMembers[i]]
+		if !ok {
+			continue
+		}
+		if score := written[i].Score; winner.Score > score || (winner.Score == score && !winner.Inserted) {
+			rejections = append(rejections, Rejection{
+				Tuple:         tuple,
+				WinningScore:  winner.Score,
+				WinnerDeleted: !winner.Inserted,
+			})
+		}
+	}
+	return rejections, nil
+}
+
+// newest gathers the newest presence of each key-member across the clusters.
+// With equal scores, a delete wins, as it does in the clusters. Key-members
+// which no cluster has are missing. An error is only returned if no cluster
+// responds. Clusters in maintenance aren't asked.
+func (f *Farm) newest(keyMembers []common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
+	return f.newestQuorum(keyMembers, 1)
+}
+
+// newestQuorum is like newest, but returns ErrNoReadQuorum unless at least
+// required clusters respond.
+func (f *Farm) newestQuorum(keyMembers []common.KeyMember, required int) (map[common.KeyMember]cluster.Presence, error) {
+	f = f.readable()
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		newest = map[common.KeyMember]cluster.Presence{}
+		errors = []string{}
+	)
+	wg.Add(len(f.clusters))
+	for i, c := range f.clusters {
+		go func(i int, c cluster.Cluster) {
+			defer wg.Done()
+			presences, err := c.Score(keyMembers)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("cluster %d: %s", i, err))
+				return
+			}
+			for keyMember, presence := range presences {
+				if !presence.Present {
+					continue
+				}
+				current, ok := newest[keyMember]
+				if !ok || presence.Score > current.Score || (presence.Score == current.Score && !presence.Inserted) {
+					newest[keyMember] = presence
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	if responded := len(f.clusters) - len(errors); responded <= 0 {
+		return nil, fmt.Errorf("no cluster responded (%s)", strings.Join(errors, "; "))
+	} else if responded < required {
+		return nil, fmt.Errorf("%w: %d of %d cluster(s) responded (%s)", ErrNoReadQuorum, responded, required, strings.Join(errors, "; "))
+	}
+	return newest, nil
+}
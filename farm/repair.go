@@ -0,0 +1,213 @@
+package farm
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// presences gathers the presence of each key-member in each cluster, by index,
+// along with the errors of the clusters which couldn't be read.
+func presences(clusters []cluster.Cluster, keyMembers []common.KeyMember) (map[common.KeyMember][]cluster.Presence, map[int]error) {
+	// Every KeyMember has a presence in every cluster. Even if the
+	// cluster errors during Score, we keep a default (empty) presence.
+	// That means we may re-issue unnecessary writes, but that's OK!
+	presenceMap := map[common.KeyMember][]cluster.Presence{}
+	for _, keyMember := range keyMembers {
+		presenceMap[keyMember] = make([]cluster.Presence, len(clusters))
+	}
+
+	// Make Score requests sequentially. If a key is totally missing from
+	// a cluster, like when a node comes online empty and needs to be
+	// rebuilt, you'll end up asking about maxSize KeyMembers, which is
+	// probably a lot.
+	errs := map[int]error{}
+	for index := range clusters {
+		// Make single request for this cluster.
+		scoreResponse, err := clusters[index].Score(keyMembers)
+		if err != nil {
+			errs[index] = err
+			continue
+		}
+
+		// Copy this cluster's presence information into our map.
+		for keyMember, presence := range scoreResponse {
+			presenceMap[keyMember][index] = presence
+		}
+	}
+	return presenceMap, errs
+}
+
+// repairWrites determines the correct state of each key-member from its
+// presences, and returns the writes which bring each cluster to it, by
+// index.
+func repairWrites(presenceMap map[common.KeyMember][]cluster.Presence) (inserts, deletes map[int][]common.KeyScoreMember) {
+	inserts = map[int][]common.KeyScoreMember{}
+	deletes = map[int][]common.KeyScoreMember{}
+	for keyMember, presenceSlice := range presenceMap {
+		// Walk once, to determine the correct state.
+		var (
+			found        = false
+			highestScore = 0.
+			wasInserted  = false
+		)
+
+		for _, presence := range presenceSlice {
+			if presence.Present && presence.Score >= highestScore {
+				found = true
+				highestScore = presence.Score
+				wasInserted = wasInserted || presence.Inserted // https://github.com/soundcloud/roshi/issues/24
+			}
+		}
+
+		if !found {
+			// This is indeed a strange situation, but it can arise if we
+			// get errors from every cluster during Score requests, for
+			// example. We don't want to confuse that with presence in the
+			// remove set.
+			log.Printf("repair: %v not found anywhere, skipping", keyMember)
+			continue
+		}
+
+		// We now know the correct element.
+		keyScoreMember := common.KeyScoreMember{
+			Key:    keyMember.Key,
+			Score:  highestScore,
+			Member: keyMember.Member,
+		}
+
+		// Walk again, to schedule write operations.
+		for index, presence := range presenceSlice {
+			var (
+				notThere = !presence.Present
+				lowScore = presence.Score < highestScore
+				wrongSet = presence.Inserted != wasInserted
+			)
+
+			if notThere || lowScore || wrongSet {
+				if wasInserted {
+					inserts[index] = append(inserts[index], keyScoreMember)
+				} else {
+					deletes[index] = append(deletes[index], keyScoreMember)
+				}
+			}
+		}
+	}
+	return inserts, deletes
+}
+
+// ClusterRepair is the outcome of a Repair in one cluster.
+type ClusterRepair struct {
+	Inserted int   // members re-inserted in the cluster
+	Deleted  int   // members re-deleted in the cluster
+	Err      error // nil if the cluster was read and written
+}
+
+// Repair runs a full repair cycle of the keys, synchronously, and reports
+// how many members were repaired in each cluster, by index. Unlike read
+// repairs, which only cover the members a Select happened to return, Repair
+// reads up to limit members of each key from every cluster, checks the
+// presence of each of them in every cluster, and writes the newest state of
+// every member to the clusters which lack it. It's meant for resolving a
+// known inconsistency on demand, e.g. a user-reported timeline. Split keys
+// are repaired subkey by subkey.
+//
+// Members which are only in the deletes set of every cluster aren't read, and
+// so aren't repaired. Cluster errors are reported per cluster; an error is
+// only returned if no cluster could be read.
+func (f *Farm) Repair(keys []string, limit int) ([]ClusterRepair, error) {
+	repairs := make([]ClusterRepair, len(f.clusters))
+	if len(keys) <= 0 {
+		return repairs, nil
+	}
+	var physical []string
+	for _, key := range keys {
+		physical = append(physical, f.splits.physical(key)...)
+	}
+
+	// Gather every member any cluster has inserted.
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		seen       = map[common.KeyMember]bool{}
+		keyMembers = []common.KeyMember{}
+	)
+	wg.Add(len(f.clusters))
+	for i, c := range f.clusters {
+		go func(i int, c cluster.Cluster) {
+			defer wg.Done()
+			for e := range c.SelectOffset(physical, 0, limit) {
+				mu.Lock()
+				if e.Error != nil {
+					if repairs[i].Err == nil {
+						repairs[i].Err = fmt.Errorf("during Select: %s", e.Error)
+					}
+				}
+				for _, tuple := range e.KeyScoreMembers {
+					keyMember := common.KeyMember{Key: tuple.Key, Member: tuple.Member}
+					if !seen[keyMember] {
+						seen[keyMember] = true
+						keyMembers = append(keyMembers, keyMember)
+					}
+				}
+				mu.Unlock()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	failed := 0
+	for _, repair := range repairs {
+		if repair.Err != nil {
+			failed++
+		}
+	}
+	if failed >= len(f.clusters) {
+		return repairs, fmt.Errorf("no cluster could be read: %s", repairs[0].Err)
+	}
+	if len(keyMembers) <= 0 {
+		return repairs, nil
+	}
+
+	presenceMap, errs := presences(f.clusters, keyMembers)
+	for index, err := range errs {
+		if repairs[index].Err == nil {
+			repairs[index].Err = fmt.Errorf("during Score: %s", err)
+		}
+	}
+	inserts, deletes := repairWrites(presenceMap)
+	f.instrumentation.RepairCall()
+	f.instrumentation.RepairRequest(len(keyMembers))
+
+	wg.Add(len(f.clusters))
+	for i, c := range f.clusters {
+		go func(i int, c cluster.Cluster) {
+			defer wg.Done()
+			var insertErr, deleteErr error
+			if len(inserts[i]) > 0 {
+				insertErr = c.Insert(inserts[i])
+			}
+			if len(deletes[i]) > 0 {
+				deleteErr = c.Delete(deletes[i])
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case insertErr != nil:
+				repairs[i].Err = fmt.Errorf("during Insert: %s", insertErr)
+			case deleteErr != nil:
+				repairs[i].Err = fmt.Errorf("during Delete: %s", deleteErr)
+			}
+			if insertErr == nil {
+				repairs[i].Inserted = len(inserts[i])
+			}
+			if deleteErr == nil {
+				repairs[i].Deleted = len(deletes[i])
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	return repairs, nil
+}
@@ -2,6 +2,8 @@ package farm
 
 import (
 	"log"
+	"sync"
+	"time"
 
 	"github.com/soundcloud/roshi/cluster"
 	"github.com/soundcloud/roshi/common"
@@ -67,6 +69,81 @@ func RateLimited(maxElementsPerSecond int, repairStrategy RepairStrategy) Repair
 	}
 }
 
+// Batched wraps a repair strategy, so that repair requests arriving within
+// the window of each other are merged, and issued as a single request once
+// the window has passed, or as soon as maxBatch distinct key-members are
+// pending. Key-members requested more than once are only repaired once.
+//
+// During large convergence events, many reads detect divergences at once,
+// often the same ones. Batched turns their repairs into fewer, larger Score
+// and write requests, which each cluster pipelines per Redis instance.
+//
+// Unlike Nonblocking and RateLimited, Batched keeps state between requests,
+// so it must be the outermost wrapper. Wrap a Nonblocking strategy, as a
+// full batch is issued by the request which filled it.
+func Batched(window time.Duration, maxBatch int, repairStrategy RepairStrategy) RepairStrategy {
+	return func(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation) coreRepairStrategy {
+		b := &repairBatcher{
+			window:  window,
+			max:     maxBatch,
+			repair:  repairStrategy(clusters, instr),
+			pending: map[common.KeyMember]struct{}{},
+		}
+		return b.add
+	}
+}
+
+// repairBatcher accumulates the key-members of repair requests for Batched.
+type repairBatcher struct {
+	sync.Mutex
+	window    time.Duration
+	max       int
+	repair    coreRepairStrategy
+	pending   map[common.KeyMember]struct{}
+	scheduled bool // a flush is pending
+}
+
+func (b *repairBatcher) add(kms []common.KeyMember) {
+	b.Lock()
+	for _, km := range kms {
+		b.pending[km] = struct{}{}
+	}
+	var batch []common.KeyMember
+	if len(b.pending) >= b.max {
+		batch = b.take()
+	} else if len(b.pending) > 0 && !b.scheduled {
+		b.scheduled = true
+		time.AfterFunc(b.window, b.flush)
+	}
+	b.Unlock()
+
+	if len(batch) > 0 {
+		b.repair(batch)
+	}
+}
+
+func (b *repairBatcher) flush() {
+	b.Lock()
+	b.scheduled = false
+	batch := b.take()
+	b.Unlock()
+
+	if len(batch) > 0 {
+		b.repair(batch)
+	}
+}
+
+// take returns the pending key-members, and resets them. Callers must hold
+// the lock.
+func (b *repairBatcher) take() []common.KeyMember {
+	batch := make([]common.KeyMember, 0, len(b.pending))
+	for km := range b.pending {
+		batch = append(batch, km)
+	}
+	b.pending = map[common.KeyMember]struct{}{}
+	return batch
+}
+
 // NoRepairs is a no-op repair strategy.
 func NoRepairs([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRepairStrategy {
 	return func([]common.KeyMember) {}
@@ -79,105 +156,119 @@ func NoRepairs([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRep
 // control memory pressure in your process and/or load against your
 // infrastructure, respectively.
 func AllRepairs(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation) coreRepairStrategy {
+	return allRepairs(clusters, instr, 0)
+}
+
+// VerifiedRepairs is AllRepairs, which also reads the repaired key-members
+// back from each cluster after the delay, and counts those which aren't as
+// repaired, as RepairVerifyFailure. A key-member with a higher score than
+// the repair, from a newer write, is as repaired.
+//
+// A repair that doesn't stick, e.g. because a writer with a skewed clock
+// keeps overwriting it, or a trimmed key drops it again, is otherwise
+// detected by the next read, and repaired again, forever. Verification
+// costs a Score request per repaired cluster.
+func VerifiedRepairs(delay time.Duration) RepairStrategy {
+	return func(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation) coreRepairStrategy {
+		return allRepairs(clusters, instr, delay)
+	}
+}
+
+// allRepairs implements AllRepairs, and VerifiedRepairs, if verifyDelay is
+// positive.
+func allRepairs(clusters []cluster.Cluster, instr instrumentation.RepairInstrumentation, verifyDelay time.Duration) coreRepairStrategy {
 	return func(keyMembers []common.KeyMember) {
 		go func() {
 			instr.RepairCall()
 			instr.RepairRequest(len(keyMembers))
 		}()
 
-		// Every KeyMember has a presence in every cluster. Even if the
-		// cluster errors during Score, we keep a default (empty) presence.
-		// That means we may re-issue unnecessary writes, but that's OK!
-		presenceMap := map[common.KeyMember][]cluster.Presence{}
-		for _, keyMember := range keyMembers {
-			presenceMap[keyMember] = make([]cluster.Presence, len(clusters))
-		}
-
-		// Make Score requests sequentially. If a key is totally missing from
-		// a cluster, like when a node comes online empty and needs to be
-		// rebuilt, you'll end up asking about maxSize KeyMembers, which is
-		// probably a lot.
-		for index := range clusters {
-			// Make single request for this cluster.
-			scoreResponse, err := clusters[index].Score(keyMembers)
-			if err != nil {
-				log.Printf("AllRepairs: cluster %d: %s", index, err)
-				continue
-			}
-
-			// Copy this cluster's presence information into our map.
-			for keyMember, presence := range scoreResponse {
-				presenceMap[keyMember][index] = presence
-			}
+		presenceMap, errs := presences(clusters, keyMembers)
+		for index, err := range errs {
+			log.Printf("AllRepairs: cluster %d: %s", index, err)
 		}
+		inserts, deletes := repairWrites(presenceMap)
 
-		// With the collected responses, determine the correct state, and
-		// schedule write operations.
-		inserts := map[int][]common.KeyScoreMember{}
-		deletes := map[int][]common.KeyScoreMember{}
-		for keyMember, presenceSlice := range presenceMap {
-			// Walk once, to determine the correct state.
-			var (
-				found        = false
-				highestScore = 0.
-				wasInserted  = false
-			)
-
-			for _, presence := range presenceSlice {
-				if presence.Present && presence.Score >= highestScore {
-					found = true
-					highestScore = presence.Score
-					wasInserted = wasInserted || presence.Inserted // https://github.com/soundcloud/roshi/issues/24
-				}
-			}
+		// Make write operations, remembering the successful ones for
+		// verification.
 
-			if !found {
-				// This is indeed a strange situation, but it can arise if we
-				// get errors from every cluster during Score requests, for
-				// example. We don't want to confuse that with presence in the
-				// remove set.
-				log.Printf("AllRepairs: %v not found anywhere, skipping", keyMember)
-				continue
+		repaired := map[int]map[common.KeyMember]cluster.Presence{}
+		record := func(index int, keyScoreMembers []common.KeyScoreMember, inserted bool) {
+			if verifyDelay <= 0 {
+				return
 			}
-
-			// We now know the correct element.
-			keyScoreMember := common.KeyScoreMember{
-				Key:    keyMember.Key,
-				Score:  highestScore,
-				Member: keyMember.Member,
+			if repaired[index] == nil {
+				repaired[index] = map[common.KeyMember]cluster.Presence{}
 			}
-
-			// Walk again, to schedule write operations.
-			for index, presence := range presenceSlice {
-				var (
-					notThere = !presence.Present
-					lowScore = presence.Score < highestScore
-					wrongSet = presence.Inserted != wasInserted
-				)
-
-				if notThere || lowScore || wrongSet {
-					if wasInserted {
-						inserts[index] = append(inserts[index], keyScoreMember)
-					} else {
-						deletes[index] = append(deletes[index], keyScoreMember)
-					}
-				}
+			for _, ksm := range keyScoreMembers {
+				repaired[index][common.KeyMember{Key: ksm.Key, Member: ksm.Member}] = cluster.Presence{Present: true, Inserted: inserted, Score: ksm.Score}
 			}
 		}
 
-		// Make write operations.
-
 		for index, keyScoreMembers := range inserts {
 			if err := clusters[index].Insert(keyScoreMembers); err != nil {
 				log.Printf("AllRepairs: cluster %d: during Insert: %s", index, err)
+				continue
 			}
+			record(index, keyScoreMembers, true)
 		}
 
 		for index, keyScoreMembers := range deletes {
 			if err := clusters[index].Delete(keyScoreMembers); err != nil {
 				log.Printf("AllRepairs: cluster %d: during Delete: %s", index, err)
+				continue
+			}
+			record(index, keyScoreMembers, false)
+		}
+
+		if len(repaired) > 0 {
+			time.AfterFunc(verifyDelay, func() { verifyRepairs(clusters, repaired, verifyDelay, instr) })
+		}
+	}
+}
+
+// verifyRepairs reads the repaired key-members back from each cluster, and
+// counts and logs those which aren't as repaired.
+func verifyRepairs(clusters []cluster.Cluster, repaired map[int]map[common.KeyMember]cluster.Presence, delay time.Duration, instr instrumentation.RepairInstrumentation) {
+	for index, expected := range repaired {
+		keyMembers := make([]common.KeyMember, 0, len(expected))
+		for keyMember := range expected {
+			keyMembers = append(keyMembers, keyMember)
+		}
+		got, err := clusters[index].Score(keyMembers)
+		if err != nil {
+			log.Printf("VerifiedRepairs: cluster %d: during Score: %s", index, err)
+			continue
+		}
+		failed := []common.KeyMember{}
+		for _, keyMember := range keyMembers {
+			if !asRepaired(expected[keyMember], got[keyMember]) {
+				failed = append(failed, keyMember)
 			}
 		}
+		if len(failed) <= 0 {
+			continue
+		}
+		examples := failed
+		if len(examples) > 3 {
+			examples = examples[:3]
+		}
+		log.Printf("VerifiedRepairs: cluster %d: %d repaired key-member(s) not as repaired after %s, e.g. %v", index, len(failed), delay, examples)
+		instr.RepairVerifyFailure(len(failed))
+	}
+}
+
+// asRepaired returns whether the presence of a key-member is at least as
+// new as its repair. As in the scripts, a delete with the same score as an
+// insert wins.
+func asRepaired(repair, presence cluster.Presence) bool {
+	switch {
+	case !presence.Present, presence.Score < repair.Score:
+		return false
+	case presence.Score > repair.Score:
+		return true
+	default:
+		return presence.Inserted == repair.Inserted || !presence.Inserted
 	}
 }
 
@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
 	"github.com/soundcloud/roshi/common"
 	"github.com/soundcloud/roshi/instrumentation"
 )
@@ -130,3 +135,118 @@ func makeBigKey(key string, n int) []common.KeyScoreMember {
 	}
 	return tuples
 }
+
+func TestBatchedRepairs(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]common.KeyMember
+	)
+	recording := func([]cluster.Cluster, instrumentation.RepairInstrumentation) coreRepairStrategy {
+		return func(kms []common.KeyMember) {
+			mu.Lock()
+			defer mu.Unlock()
+			batches = append(batches, kms)
+		}
+	}
+	flushed := func() [][]common.KeyMember {
+		mu.Lock()
+		defer mu.Unlock()
+		b := batches
+		batches = nil
+		return b
+	}
+
+	repair := Batched(20*time.Millisecond, 3, recording)(newMockClusters(2), instrumentation.NopInstrumentation{})
+
+	// Requests within the window are merged, and duplicates dropped.
+	var (
+		a = common.KeyMember{Key: "foo", Member: "a"}
+		b = common.KeyMember{Key: "foo", Member: "b"}
+		c = common.KeyMember{Key: "bar", Member: "c"}
+		d = common.KeyMember{Key: "bar", Member: "d"}
+	)
+	repair([]common.KeyMember{a})
+	repair([]common.KeyMember{a, b})
+	if got := flushed(); len(got) != 0 {
+		t.Fatalf("expected no repairs before the window passed, got %v", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	got := flushed()
+	if len(got) != 1 || len(got[0]) != 2 {
+		t.Fatalf("expected 1 repair of 2 key-members, got %v", got)
+	}
+
+	// A full batch is issued immediately.
+	repair([]common.KeyMember{a, b, c, d})
+	if got := flushed(); len(got) != 1 || len(got[0]) != 4 {
+		t.Fatalf("expected 1 immediate repair of 4 key-members, got %v", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := flushed(); len(got) != 0 {
+		t.Fatalf("expected no further repairs, got %v", got)
+	}
+}
+
+type verifyFailureCounter struct {
+	instrumentation.NopInstrumentation
+	failures int32
+}
+
+func (c *verifyFailureCounter) RepairVerifyFailure(n int) { atomic.AddInt32(&c.failures, int32(n)) }
+
+// forgetfulCluster acknowledges inserts, but loses them.
+type forgetfulCluster struct{ *clustertest.Fake }
+
+func (forgetfulCluster) Insert([]common.KeyScoreMember) error { return nil }
+
+func TestVerifiedRepairs(t *testing.T) {
+	var (
+		good      = clustertest.New()
+		forgetful = forgetfulCluster{clustertest.New()}
+		clusters  = []cluster.Cluster{clustertest.New(), good, forgetful}
+		instr     = &verifyFailureCounter{}
+		repair    = VerifiedRepairs(10*time.Millisecond)(clusters, instr)
+		a         = common.KeyScoreMember{Key: "foo", Score: 2, Member: "a"}
+		b         = common.KeyScoreMember{Key: "foo", Score: 1, Member: "b"}
+	)
+	clusters[0].Insert([]common.KeyScoreMember{a, b})
+	good.Insert([]common.KeyScoreMember{b})
+	forgetful.Fake.Insert([]common.KeyScoreMember{b})
+
+	// The good cluster takes the repair of a, and keeps it, even if it's
+	// overwritten by a newer delete. The forgetful cluster loses it.
+	repair([]common.KeyMember{{Key: "foo", Member: "a"}, {Key: "foo", Member: "b"}})
+	good.Delete([]common.KeyScoreMember{{Key: "foo", Score: 3, Member: "a"}})
+	time.Sleep(50 * time.Millisecond)
+	if expected, got := 1, int(atomic.LoadInt32(&instr.failures)); expected != got {
+		t.Errorf("expected %d verify failure, got %d", expected, got)
+	}
+	if n := good.CallCount(clustertest.Score); n != 2 {
+		t.Errorf("expected the good cluster to be read twice, got %d", n)
+	}
+	if n := clusters[0].(*clustertest.Fake).CallCount(clustertest.Score); n != 1 {
+		t.Errorf("expected the unrepaired cluster to be read once, got %d", n)
+	}
+}
+
+func TestAsRepaired(t *testing.T) {
+	var (
+		inserted = cluster.Presence{Present: true, Inserted: true, Score: 2}
+		deleted  = cluster.Presence{Present: true, Inserted: false, Score: 2}
+	)
+	for i, testCase := range []struct {
+		repair, presence cluster.Presence
+		expected         bool
+	}{
+		{inserted, inserted, true},
+		{inserted, deleted, true}, // a delete wins
+		{deleted, inserted, false},
+		{inserted, cluster.Presence{}, false},
+		{inserted, cluster.Presence{Present: true, Inserted: true, Score: 1}, false},
+		{deleted, cluster.Presence{Present: true, Inserted: true, Score: 3}, true},
+	} {
+		if got := asRepaired(testCase.repair, testCase.presence); got != testCase.expected {
+			t.Errorf("%d: expected %v, got %v", i, testCase.expected, got)
+		}
+	}
+}
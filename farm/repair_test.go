@@ -0,0 +1,56 @@
+package farm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestRepair(t *testing.T) {
+	var (
+		fakes    = []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+		clusters = []cluster.Cluster{fakes[0], fakes[1], fakes[2]}
+		f        = New(clusters, 2, SendAllReadAll, NoRepairs, nil)
+	)
+	if err := f.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}, {Key: "foo", Score: 1, Member: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	fakes[0].Delete([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "a"}}) // only in cluster 0
+	fakes[1].Insert([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "c"}}) // only in cluster 1
+	fakes[2].Insert([]common.KeyScoreMember{{Key: "bar", Score: 1, Member: "d"}}) // other keys are left alone
+
+	repairs, err := f.Repair([]string{"foo"}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []ClusterRepair{
+		{Inserted: 1, Deleted: 0},
+		{Inserted: 0, Deleted: 1},
+		{Inserted: 1, Deleted: 1},
+	}; !reflect.DeepEqual(expected, repairs) {
+		t.Errorf("expected %+v, got %+v", expected, repairs)
+	}
+	for i, c := range clusters {
+		got := (<-c.SelectOffset([]string{"foo"}, 0, 10)).KeyScoreMembers
+		if expected := []common.KeyScoreMember{{Key: "foo", Score: 2, Member: "c"}, {Key: "foo", Score: 1, Member: "b"}}; !reflect.DeepEqual(expected, got) {
+			t.Errorf("cluster %d: expected %v, got %v", i, expected, got)
+		}
+	}
+	if got := (<-clusters[0].SelectOffset([]string{"bar"}, 0, 10)).KeyScoreMembers; len(got) != 0 {
+		t.Errorf("expected bar to be left alone, got %v", got)
+	}
+
+	// A repaired key needs no more repairs, and failing clusters are
+	// reported, but don't fail the repair.
+	fakes[2].FailWith(clustertest.SelectOffset, errors.New("unavailable"))
+	if repairs, err = f.Repair([]string{"foo"}, 100); err != nil {
+		t.Fatal(err)
+	}
+	if repairs[0] != (ClusterRepair{}) || repairs[1] != (ClusterRepair{}) || repairs[2].Err == nil {
+		t.Errorf("expected no repairs, and an error from cluster 2, got %+v", repairs)
+	}
+}
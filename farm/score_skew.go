@@ -0,0 +1,88 @@
+package farm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// MaxScoreSkew bounds how far ahead of the wall clock the scores of inserts
+// may be. Scores are taken to count units since the Unix epoch, e.g.
+// time.Second for Unix timestamps. Under last-writer-wins, a single producer
+// with a clock far ahead shadows every correct write of its members, until
+// the correct clocks catch up. Inserts with scores beyond the skew are
+// rejected with a ScoreSkewError, before any request is made to the
+// clusters, or, if clamp is set, inserted with the latest allowed score.
+// Deletes aren't bounded. Zero or a negative skew means no limit.
+func MaxScoreSkew(skew, unit time.Duration, clamp bool) Option {
+	return func(f *Farm) {
+		f.scoreSkew = nil
+		if skew > 0 && unit > 0 {
+			f.scoreSkew = &scoreSkew{max: skew, unit: unit, clamp: clamp}
+		}
+	}
+}
+
+type scoreSkew struct {
+	max   time.Duration
+	unit  time.Duration
+	clamp bool
+}
+
+// limit is the latest score allowed at the time.
+func (s *scoreSkew) limit(now time.Time) float64 {
+	return float64(now.Add(s.max).UnixNano()) / float64(s.unit)
+}
+
+// ScoreSkewError is returned by inserts with at least one score further
+// ahead of the wall clock than the MaxScoreSkew, unless scores are clamped.
+// None of the tuples in the insert are applied.
+type ScoreSkewError struct {
+	Count  int           // how many scores were too far ahead
+	Latest float64       // the latest score
+	Limit  float64       // the latest score allowed at the time of the insert
+	Max    time.Duration // the configured skew
+}
+
+// Error implements the error interface.
+func (e ScoreSkewError) Error() string {
+	return fmt.Sprintf("%d score(s) are more than %s ahead of the clock (latest %v, limit %v)", e.Count, e.Max, e.Latest, e.Limit)
+}
+
+// checkScoreSkew returns the tuples, if none of their scores is beyond the
+// MaxScoreSkew. Otherwise, the tuples with their scores clamped to the limit
+// are returned, or a ScoreSkewError. The passed slice is never modified.
+func (f *Farm) checkScoreSkew(tuples []common.KeyScoreMember) ([]common.KeyScoreMember, error) {
+	if f.scoreSkew == nil {
+		return tuples, nil
+	}
+	var (
+		limit  = f.scoreSkew.limit(time.Now())
+		count  int
+		latest float64
+	)
+	for _, tuple := range tuples {
+		if tuple.Score > limit {
+			if count <= 0 || tuple.Score > latest {
+				latest = tuple.Score
+			}
+			count++
+		}
+	}
+	if count <= 0 {
+		return tuples, nil
+	}
+	f.instrumentation.InsertScoreSkewed(count)
+	if !f.scoreSkew.clamp {
+		return nil, ScoreSkewError{Count: count, Latest: latest, Limit: limit, Max: f.scoreSkew.max}
+	}
+	clamped := make([]common.KeyScoreMember, len(tuples))
+	for i, tuple := range tuples {
+		if tuple.Score > limit {
+			tuple.Score = limit
+		}
+		clamped[i] = tuple
+	}
+	return clamped, nil
+}
@@ -0,0 +1,82 @@
+package farm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+type skewCounter struct {
+	instrumentation.NopInstrumentation
+	skewed int
+}
+
+func (i *skewCounter) InsertScoreSkewed(n int) { i.skewed += n }
+
+func TestMaxScoreSkew(t *testing.T) {
+	var (
+		now    = float64(time.Now().Unix())
+		tuples = []common.KeyScoreMember{
+			{Key: "foo", Score: now, Member: "ok"},
+			{Key: "foo", Score: now + 3600, Member: "ahead"},
+		}
+	)
+
+	// Rejected, with nothing written.
+	clusters := []cluster.Cluster{clustertest.New(), clustertest.New()}
+	instr := &skewCounter{}
+	f := New(clusters, len(clusters), SendAllReadAll, NoRepairs, instr, MaxScoreSkew(time.Minute, time.Second, false))
+	err := f.Insert(tuples)
+	skew, ok := err.(ScoreSkewError)
+	if !ok {
+		t.Fatalf("expected ScoreSkewError, got %v", err)
+	}
+	if skew.Count != 1 || skew.Latest != now+3600 || skew.Limit < now+60 || skew.Limit > now+120 {
+		t.Errorf("expected 1 score of %v beyond a limit of about %v, got %+v", now+3600, now+60, skew)
+	}
+	if _, err := f.InsertVerbose(tuples); err == nil {
+		t.Errorf("verbose: expected error, got none")
+	}
+	if expected, got := 2, instr.skewed; expected != got {
+		t.Errorf("expected %d skewed scores, got %d", expected, got)
+	}
+	pages, err := f.SelectOffset([]string{"foo"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(pages["foo"]); n != 0 {
+		t.Errorf("expected no tuples written, got %d", n)
+	}
+
+	// Clamped to the limit.
+	f = New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil, MaxScoreSkew(time.Minute, time.Second, true))
+	if err := f.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := now+3600, tuples[1].Score; expected != got {
+		t.Errorf("expected the passed tuples to be unmodified, got score %v", got)
+	}
+	pages, err = f.SelectOffset([]string{"foo"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page := pages["foo"]; len(page) != 2 || page[0].Member != "ahead" || page[0].Score < now+60 || page[0].Score > now+120 || page[1].Score != now {
+		t.Errorf("expected the score of ahead clamped to about %v, got %v", now+60, page)
+	}
+
+	// Unbounded, and scores in other units.
+	for _, option := range []Option{
+		MaxScoreSkew(0, time.Second, false),
+		MaxScoreSkew(2*time.Hour, time.Second, false),
+		MaxScoreSkew(time.Minute, time.Millisecond, false), // every score is in 1970
+	} {
+		f = New(clusters, len(clusters), SendAllReadAll, NoRepairs, nil, option)
+		if err := f.Insert(tuples); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	}
+}
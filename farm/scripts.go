@@ -0,0 +1,23 @@
+package farm
+
+import (
+	"fmt"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// LoadScripts loads the Lua scripts onto every instance of every cluster, in
+// order, via cluster.LoadScripts. Call it at startup, to detect instances
+// which can't run the scripts, and other processes running different
+// versions of them, before serving any request.
+func (f *Farm) LoadScripts() ([][]cluster.InstanceScripts, error) {
+	results := make([][]cluster.InstanceScripts, len(f.clusters))
+	for i, c := range f.clusters {
+		instances, err := cluster.LoadScripts(c)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %d: %s", i, err)
+		}
+		results[i] = instances
+	}
+	return results, nil
+}
@@ -0,0 +1,173 @@
+package farm
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// ErrOverloaded is returned by Selects which every cluster they were sent to
+// rejected, because the queues of its workers were full, and by InsertAsync
+// when its workers and their queue are full. See SelectWorkers and
+// AsyncInserts.
+var ErrOverloaded = errors.New("overloaded")
+
+// SelectWorkers bounds the concurrency of Selects against each cluster. By
+// default, every Select starts a goroutine per cluster it's sent to, so at
+// tens of thousands of concurrent Selects, memory and scheduler load grow
+// without bound. With SelectWorkers, each cluster has a fixed number of
+// long-lived workers, which serve the Selects sent to it in order, and a
+// queue of up to queue Selects waiting for them.
+//
+// A Select which finds the queue of a cluster full is rejected by that
+// cluster, which is then treated like a cluster that didn't respond. Only
+// if every cluster rejects it does the Select fail, with ErrOverloaded.
+// Queue depths and rejections are reported per cluster via instrumentation.
+// Workers run for the lifetime of the process. There's at least 1 worker
+// per cluster.
+//
+// Selects abandoned at the deadline of WithDeadline keep running on their
+// cluster until they complete, and count against its queue until then, so
+// the Selects running on each cluster, and the goroutines they start for its
+// instances, stay bounded, too.
+func SelectWorkers(workers, queue int) Option {
+	return func(f *Farm) { f.workers = newSelectWorkers(f.clusters, workers, queue, f.instrumentation) }
+}
+
+// selectWorkers serves the Selects of each cluster of a farm with a bounded
+// number of goroutines. It's safe for concurrent use.
+type selectWorkers struct {
+	clusters []cluster.Cluster
+	workers  int
+	limit    int64         // Selects per cluster, served or queued
+	pending  []int64       // per cluster, served or queued
+	tasks    []chan func() // per cluster, with capacity for limit tasks
+	instr    instrumentation.SelectInstrumentation
+}
+
+func newSelectWorkers(clusters []cluster.Cluster, workers, queue int, instr instrumentation.SelectInstrumentation) *selectWorkers {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	w := &selectWorkers{
+		clusters: clusters,
+		workers:  workers,
+		limit:    int64(workers + queue),
+		pending:  make([]int64, len(clusters)),
+		tasks:    make([]chan func(), len(clusters)),
+		instr:    instr,
+	}
+	for i := range clusters {
+		w.tasks[i] = make(chan func(), workers+queue)
+		for j := 0; j < workers; j++ {
+			go w.work(i)
+		}
+	}
+	return w
+}
+
+func (w *selectWorkers) work(index int) {
+	for task := range w.tasks[index] {
+		task()
+		atomic.AddInt64(&w.pending[index], -1)
+	}
+}
+
+// submit queues the task for a worker of the cluster. It returns false if
+// the workers of the cluster are busy, and its queue is full.
+func (w *selectWorkers) submit(c cluster.Cluster, task func()) bool {
+	index := clusterIndex(w.clusters, c)
+	if index < 0 {
+		go task() // not a cluster of the farm
+		return true
+	}
+	pending := atomic.AddInt64(&w.pending[index], 1)
+	if pending > w.limit {
+		atomic.AddInt64(&w.pending[index], -1)
+		go w.instr.SelectClusterOverloaded(index)
+		return false
+	}
+	w.tasks[index] <- task // never blocks, as there's capacity for limit tasks
+	queued := int(pending) - w.workers
+	if queued < 0 {
+		queued = 0
+	}
+	go w.instr.SelectClusterQueueDepth(index, queued)
+	return true
+}
+
+// abandon drains the Select of the cluster, which its worker abandoned, and
+// counts it against the limit of the cluster until it's complete.
+func (w *selectWorkers) abandon(index int, src <-chan cluster.Element) {
+	atomic.AddInt64(&w.pending[index], 1)
+	go func() {
+		defer atomic.AddInt64(&w.pending[index], -1)
+		for _ = range src {
+			// drain, so the cluster isn't blocked
+		}
+	}()
+}
+
+// scatterSelects sends the results of fn for each of the clusters to dst,
+// and marks each cluster done in wg. With SelectWorkers, fn is invoked by
+// the workers of each cluster; clusters which reject it are marked done
+// right away, and ErrOverloaded is returned if every cluster rejected it.
+// Clusters whose circuit breaker is open are skipped the same way, unless
+// every one is; see BreakUnhealthyClusters.
+func (f *Farm) scatterSelects(
+	clusters []cluster.Cluster,
+	fn func(cluster.Cluster) <-chan cluster.Element,
+	wg *sync.WaitGroup,
+	dst chan cluster.Element,
+) error {
+	var (
+		broken   = f.broken(clusters)
+		rejected = 0
+	)
+	for i, c := range clusters {
+		if broken != nil && broken[i] {
+			wg.Done()
+			rejected++
+			continue
+		}
+		c := c
+		task := func() {
+			defer wg.Done()
+			for e := range fn(c) {
+				dst <- e
+			}
+		}
+		if f.workers == nil {
+			go task()
+			continue
+		}
+		if !f.workers.submit(c, task) {
+			wg.Done()
+			rejected++
+		}
+	}
+	if len(clusters) > 0 && rejected >= len(clusters) {
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// clusterIndex returns the index of the cluster, or the cluster it wraps,
+// in clusters, or -1 if it's not there.
+func clusterIndex(clusters []cluster.Cluster, c cluster.Cluster) int {
+	if d, ok := c.(deadlineCluster); ok {
+		c = d.Cluster
+	}
+	for i, candidate := range clusters {
+		if candidate == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,114 @@
+package farm
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSelectWorkers(t *testing.T) {
+	var (
+		fast = clustertest.New()
+		slow = clustertest.New()
+		foo  = common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"}
+		f    = New([]cluster.Cluster{fast, slow}, 2, SendAllReadAll, NoRepairs, nil, SelectWorkers(1, 0))
+	)
+	if err := f.Insert([]common.KeyScoreMember{foo}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Occupy the only worker of the slow cluster.
+	slow.Delay(clustertest.SelectOffset, 200*time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.SelectOffset([]string{"foo"}, 0, 10)
+	}()
+	for slow.CallCount(clustertest.SelectOffset) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The slow cluster rejects further Selects, which are served by the
+	// fast one alone, without waiting.
+	began := time.Now()
+	got, err := f.SelectOffset([]string{"foo"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(began); d >= 200*time.Millisecond {
+		t.Errorf("Select took %s, waiting for the slow cluster", d)
+	}
+	if expected := []common.KeyScoreMember{foo}; !reflect.DeepEqual(expected, got["foo"]) {
+		t.Errorf("expected %v, got %v", expected, got["foo"])
+	}
+	if expected, got := 1, slow.CallCount(clustertest.SelectOffset); expected != got {
+		t.Errorf("expected %d Select(s) of the slow cluster, got %d", expected, got)
+	}
+	<-done
+}
+
+func TestSelectWorkersOverloaded(t *testing.T) {
+	var (
+		c = clustertest.New()
+		f = New([]cluster.Cluster{c}, 1, SendOneReadOne, NoRepairs, nil, SelectWorkers(1, 1))
+	)
+	c.Delay(clustertest.SelectOffset, 200*time.Millisecond)
+
+	// One Select is served, and one waits in the queue.
+	done := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			f.SelectOffset([]string{"foo"}, 0, 10)
+			done <- struct{}{}
+		}()
+		if i == 0 {
+			for c.CallCount(clustertest.SelectOffset) < 1 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	for atomic.LoadInt64(&f.workers.pending[0]) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := f.SelectOffset([]string{"foo"}, 0, 10); err != ErrOverloaded {
+		t.Errorf("expected %v, got %v", ErrOverloaded, err)
+	}
+	<-done
+	<-done
+}
+
+func TestSelectWorkersAbandoned(t *testing.T) {
+	var (
+		c = clustertest.New()
+		f = New([]cluster.Cluster{c}, 1, SendAllReadAll, NoRepairs, nil, SelectWorkers(1, 0))
+	)
+	c.Delay(clustertest.SelectOffset, 200*time.Millisecond)
+
+	// The Select misses the deadline, and its worker moves on, but the
+	// Select still runs on the cluster, so the next one is rejected.
+	partial := f.WithDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := partial.SelectOffset([]string{"foo"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(partial.Missed()) == 0 {
+		t.Fatal("expected the deadline to be missed")
+	}
+	if _, err := f.SelectOffset([]string{"foo"}, 0, 10); err != ErrOverloaded {
+		t.Errorf("expected %v, got %v", ErrOverloaded, err)
+	}
+
+	// Once it completes, Selects are served again.
+	for atomic.LoadInt64(&f.workers.pending[0]) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Delay(clustertest.SelectOffset, 0)
+	if _, err := f.SelectOffset([]string{"foo"}, 0, 10); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
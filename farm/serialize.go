@@ -0,0 +1,83 @@
+package farm
+
+import "sync"
+
+// SerializeWrites causes writes to the same key to be applied one after
+// another, in the order the farm received them. Writes are already resolved
+// by score, so the final state doesn't depend on their order, but producers
+// which rely on the order in which writes are applied, e.g. to observe each
+// intermediate state of a key, otherwise see concurrent writes interleaved
+// on the clusters. A write waits for every earlier write to any of its keys
+// to be applied by every cluster, not only by a quorum, so slow clusters
+// slow down subsequent writes to the same keys. Writes to other keys aren't
+// affected.
+func SerializeWrites() Option {
+	return func(f *Farm) { f.serializer = newKeySerializer() }
+}
+
+// keySerializer queues writes per key. The write at the head of the queue of
+// a key holds the key; the others wait for their turn. A write joins the
+// queues of all of its keys at once, so each queue is ordered by arrival, and
+// two writes can't wait for each other. It's safe for concurrent use.
+type keySerializer struct {
+	mtx    sync.Mutex
+	queues map[string][]chan struct{}
+}
+
+func newKeySerializer() *keySerializer {
+	return &keySerializer{queues: map[string][]chan struct{}{}}
+}
+
+// acquire blocks until the write holds every key, and returns the function
+// which releases them, which must be called exactly once.
+func (s *keySerializer) acquire(keys []string) func() {
+	if s == nil {
+		return func() {}
+	}
+	var (
+		unique = make([]string, 0, len(keys))
+		turns  = make([]chan struct{}, 0, len(keys))
+		seen   = make(map[string]bool, len(keys))
+	)
+	s.mtx.Lock()
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, key)
+		turn := make(chan struct{})
+		if len(s.queues[key]) <= 0 {
+			close(turn) // nobody ahead of us
+		}
+		s.queues[key] = append(s.queues[key], turn)
+		turns = append(turns, turn)
+	}
+	s.mtx.Unlock()
+
+	for _, turn := range turns {
+		<-turn
+	}
+	return func() { s.release(unique) }
+}
+
+func (s *keySerializer) release(keys []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, key := range keys {
+		queue := s.queues[key][1:]
+		if len(queue) <= 0 {
+			delete(s.queues, key)
+			continue
+		}
+		s.queues[key] = queue
+		close(queue[0]) // next in line
+	}
+}
+
+// held returns the number of keys held or waited for, for tests.
+func (s *keySerializer) held() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.queues)
+}
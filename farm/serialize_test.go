@@ -0,0 +1,146 @@
+package farm
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestKeySerializerOrder(t *testing.T) {
+	var (
+		s     = newKeySerializer()
+		mtx   sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	first := s.acquire([]string{"x", "y"})
+
+	// Each write joins its queues before the next one starts.
+	for _, w := range []struct {
+		name string
+		keys []string
+	}{
+		{"b", []string{"y"}},
+		{"c", []string{"y", "x", "y"}},
+		{"d", []string{"x"}},
+	} {
+		before := queued(s, w.keys[0])
+		wg.Add(1)
+		go func(name string, keys []string) {
+			defer wg.Done()
+			release := s.acquire(keys)
+			mtx.Lock()
+			order = append(order, name)
+			mtx.Unlock()
+			release()
+		}(w.name, w.keys)
+		for deadline := time.Now().Add(time.Second); queued(s, w.keys[0]) <= before; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %s to queue", w.name)
+			}
+		}
+	}
+
+	mtx.Lock()
+	if len(order) > 0 {
+		t.Fatalf("writes ran while the keys were held: %v", order)
+	}
+	mtx.Unlock()
+	first()
+	wg.Wait()
+
+	if expected := []string{"b", "c", "d"}; !reflect.DeepEqual(expected, order) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+	if n := s.held(); n != 0 {
+		t.Errorf("expected no held keys, got %d", n)
+	}
+}
+
+// queued returns the length of the queue of the key.
+func queued(s *keySerializer, key string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.queues[key])
+}
+
+// gatedCluster applies inserts only as they're let through the gate, and
+// records the order in which it applied them.
+type gatedCluster struct {
+	*clustertest.Fake
+	gate    chan struct{}
+	mtx     sync.Mutex
+	applied []string
+}
+
+func (c *gatedCluster) Insert(tuples []common.KeyScoreMember) error {
+	<-c.gate
+	c.mtx.Lock()
+	for _, tuple := range tuples {
+		c.applied = append(c.applied, tuple.Member)
+	}
+	c.mtx.Unlock()
+	return c.Fake.Insert(tuples)
+}
+
+func TestSerializeWrites(t *testing.T) {
+	var (
+		slow     = &gatedCluster{Fake: clustertest.New(), gate: make(chan struct{})}
+		clusters = []cluster.Cluster{clustertest.New(), slow}
+		farm     = New(clusters, 1, SendAllReadAll, NoRepairs, nil, SerializeWrites())
+	)
+
+	// The first write returns on quorum, before the slow cluster applied it.
+	if err := farm.Insert([]common.KeyScoreMember{{Key: "foo", Score: 2, Member: "first"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second write to the key waits for the slow cluster; a write to
+	// another key doesn't.
+	second := make(chan error, 1)
+	go func() { second <- farm.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "second"}}) }()
+	other := make(chan error, 1)
+	go func() { other <- farm.Insert([]common.KeyScoreMember{{Key: "bar", Score: 1, Member: "other"}}) }()
+	select {
+	case err := <-other:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a write to another key waited")
+	}
+	select {
+	case <-second:
+		t.Fatal("the second write didn't wait for the first to be applied everywhere")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(slow.gate)
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		slow.mtx.Lock()
+		applied := append([]string{}, slow.applied...)
+		slow.mtx.Unlock()
+		if len(applied) >= 3 || time.Now().After(deadline) {
+			foo := []string{}
+			for _, member := range applied {
+				if member != "other" {
+					foo = append(foo, member)
+				}
+			}
+			if expected := []string{"first", "second"}; !reflect.DeepEqual(expected, foo) {
+				t.Errorf("expected the slow cluster to apply %v, got %v", expected, foo)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,78 @@
+package farm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// Sizes returns the number of members of the key of each tuple, keyed by the
+// key as written. Call it after a successful Insert of the tuples, so
+// producers can implement "timeline is full" logic without reading the keys.
+//
+// Sizes are counted by a single cluster, chosen like SendOneReadOne chooses
+// one, or, if it fails, by each next cluster in turn. A write which
+// succeeded with a quorum may not have been applied by that cluster, so the
+// sizes are as of that cluster, not necessarily as of the write. Split keys
+// are counted across their subkeys, and keys rewritten by a WriteTransform in
+// their rewritten form. Clusters in maintenance, and clusters which don't
+// implement cluster.Counter, aren't asked. An error is only returned if no
+// cluster responds.
+func (f *Farm) Sizes(tuples []common.KeyScoreMember) (map[string]int, error) {
+	if len(tuples) <= 0 {
+		return map[string]int{}, nil
+	}
+
+	// Each key may be stored as several physical keys, via rewrites and
+	// splits.
+	var (
+		written  = f.transform(tuples)
+		physical = map[string]map[string]bool{} // key as written: physical keys
+		keys     = []string{}
+		seen     = map[string]bool{}
+	)
+	for i, tuple := range tuples {
+		if physical[tuple.Key] == nil {
+			physical[tuple.Key] = map[string]bool{}
+		}
+		for _, key := range f.splits.physical(written[i].Key) {
+			physical[tuple.Key][key] = true
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	f = f.readable()
+	if len(f.clusters) <= 0 {
+		return map[string]int{}, fmt.Errorf("no cluster to count members")
+	}
+	var (
+		first  = f.pick()
+		errors = []string{}
+	)
+	for j := range f.clusters {
+		i := (first + j) % len(f.clusters)
+		counter, ok := f.clusters[i].(cluster.Counter)
+		if !ok {
+			errors = append(errors, fmt.Sprintf("cluster %d: can't count members", i))
+			continue
+		}
+		counts, err := counter.Count(keys)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("cluster %d: %s", i, err))
+			continue
+		}
+		sizes := make(map[string]int, len(physical))
+		for key, physicalKeys := range physical {
+			for physicalKey := range physicalKeys {
+				sizes[key] += counts[physicalKey]
+			}
+		}
+		return sizes, nil
+	}
+	return map[string]int{}, fmt.Errorf("no cluster responded (%s)", strings.Join(errors, "; "))
+}
@@ -0,0 +1,47 @@
+package farm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSizes(t *testing.T) {
+	var (
+		failing  = clustertest.New()
+		clusters = []cluster.Cluster{failing, clustertest.New()}
+		farm     = New(clusters, 2, SendAllReadAll, NoRepairs, nil, SplitKeys(map[string]int{"hot:": 4}))
+		tuples   = []common.KeyScoreMember{
+			{Key: "foo", Score: 1, Member: "a"},
+			{Key: "foo", Score: 2, Member: "b"},
+			{Key: "hot:1", Score: 1, Member: "a"},
+			{Key: "hot:1", Score: 1, Member: "b"},
+			{Key: "hot:1", Score: 1, Member: "c"},
+		}
+	)
+	if err := farm.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failing cluster is skipped, whichever is picked first. Split keys
+	// are counted across their subkeys.
+	failing.FailWith(clustertest.Count, errors.New("failtown"))
+	for i := 0; i < 10; i++ {
+		sizes, err := farm.Sizes(tuples[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[string]int{"foo": 2, "hot:1": 3}; !reflect.DeepEqual(expected, sizes) {
+			t.Fatalf("expected %v, got %v", expected, sizes)
+		}
+	}
+
+	clusters[1].(*clustertest.Fake).FailWith(clustertest.Count, errors.New("failtown"))
+	if _, err := farm.Sizes(tuples); err == nil {
+		t.Error("expected an error with no cluster responding, got none")
+	}
+}
@@ -0,0 +1,229 @@
+package farm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// maxSlowOpKeys is how many keys of a slow op are recorded. Selects of many
+// keys would otherwise hold on to all of them, for every entry of the log.
+const maxSlowOpKeys = 32
+
+// LogSlowOps causes the farm to keep a log of the last size Selects and
+// Inserts which took longer than threshold, reported by SlowOps. Each entry
+// has the keys of the op, its limit and read strategy, for Selects, the
+// time each cluster took to respond, and how many tuples were returned or
+// written. With hashKeys, keys are recorded as hashes, so the log doesn't
+// expose them, while repeated ops on the same key still stand out.
+//
+// Timing the clusters of an op takes a view of the farm per op, so every
+// Select and Insert is a little more expensive, not only slow ones.
+func LogSlowOps(threshold time.Duration, size int, hashKeys bool) Option {
+	if size <= 0 {
+		panic("slow op log size must be positive")
+	}
+	return func(f *Farm) {
+		f.slowOps = &slowOps{
+			clusters:  f.clusters,
+			threshold: threshold,
+			hashKeys:  hashKeys,
+			ops:       make([]SlowOp, 0, size),
+		}
+	}
+}
+
+// SlowOp is an entry of the slow op log of a farm.
+type SlowOp struct {
+	Op        string          `json:"op"` // select or insert
+	Began     time.Time       `json:"began"`
+	Duration  time.Duration   `json:"duration"`
+	RequestID string          `json:"request_id,omitempty"` // see Farm.WithRequestID
+	Keys      []string        `json:"keys"`                 // the first maxSlowOpKeys distinct keys, hashed with hashKeys
+	NumKeys   int             `json:"num_keys"`             // distinct keys
+	Limit     int             `json:"limit,omitempty"`      // Selects only
+	Strategy  string          `json:"strategy,omitempty"`   // Selects only
+	Clusters  []ClusterTiming `json:"clusters"`             // in the order they responded
+	Results   int             `json:"results"`              // tuples returned by a Select, or written by an Insert
+	Error     string          `json:"error,omitempty"`
+}
+
+// ClusterTiming is the time a cluster took to respond to a request of a slow
+// op. A Select may send several requests to the same cluster, e.g. when a
+// read strategy falls back to another. Clusters which hadn't responded when
+// the op returned, e.g. those an Insert didn't wait for, aren't included.
+type ClusterTiming struct {
+	Index    int           `json:"index"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// SlowOps returns the entries of the slow op log, the most recent first, or
+// nil if slow ops aren't logged.
+func (f *Farm) SlowOps() []SlowOp {
+	if f.slowOps == nil {
+		return nil
+	}
+	return f.slowOps.snapshot()
+}
+
+// slowOps is the slow op log of a farm, a ring buffer of the last slow ops.
+// It's shared by all views of the farm, and safe for concurrent use.
+type slowOps struct {
+	clusters  []cluster.Cluster // of the farm, by index
+	threshold time.Duration
+	hashKeys  bool
+
+	mtx  sync.Mutex
+	ops  []SlowOp
+	next int // the oldest entry, once ops is full
+}
+
+func (s *slowOps) add(op SlowOp) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.ops) < cap(s.ops) {
+		s.ops = append(s.ops, op)
+		return
+	}
+	s.ops[s.next] = op
+	s.next = (s.next + 1) % len(s.ops)
+}
+
+func (s *slowOps) snapshot() []SlowOp {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	ops := make([]SlowOp, 0, len(s.ops))
+	for i := len(s.ops) - 1; i >= 0; i-- {
+		ops = append(ops, s.ops[(s.next+i)%len(s.ops)])
+	}
+	return ops
+}
+
+// keys returns the distinct keys to record for an op, and how many there
+// are.
+func (s *slowOps) keys(keys []string) ([]string, int) {
+	var (
+		seen     = make(map[string]bool, len(keys))
+		recorded = make([]string, 0, len(keys))
+	)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if len(recorded) >= maxSlowOpKeys {
+			continue
+		}
+		if s.hashKeys {
+			sum := sha256.Sum256([]byte(key))
+			key = hex.EncodeToString(sum[:8])
+		}
+		recorded = append(recorded, key)
+	}
+	return recorded, len(seen)
+}
+
+// opTrace records the time each cluster took to respond to the requests of
+// a single op.
+type opTrace struct {
+	log *slowOps
+
+	mtx     sync.Mutex
+	timings []ClusterTiming
+}
+
+func (t *opTrace) observe(c cluster.Cluster, d time.Duration, err error) {
+	timing := ClusterTiming{Index: clusterIndex(t.log.clusters, c), Duration: d}
+	if err != nil {
+		timing.Error = err.Error()
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.timings = append(t.timings, timing)
+}
+
+// finish adds the op to the slow op log, if it took longer than the
+// threshold.
+func (t *opTrace) finish(op SlowOp, keys []string, err error) {
+	if op.Duration = time.Since(op.Began); op.Duration <= t.log.threshold {
+		return
+	}
+	op.Keys, op.NumKeys = t.log.keys(keys)
+	if err != nil {
+		op.Error = err.Error()
+	}
+	t.mtx.Lock()
+	op.Clusters = append([]ClusterTiming{}, t.timings...)
+	t.mtx.Unlock()
+	t.log.add(op)
+}
+
+// traced returns a view of the farm whose clusters are timed in a new trace,
+// for a single op.
+func (f *Farm) traced() *Farm {
+	view := *f
+	view.trace = &opTrace{log: f.slowOps}
+	view.selecter = f.readStrategy(&view)
+	return &view
+}
+
+// logSlowSelect performs the Select via fn on a traced view of the farm, and
+// logs it if it's slow.
+func (f *Farm) logSlowSelect(
+	keys []string,
+	limit int,
+	fn func(*Farm) (map[string][]common.KeyScoreMember, error),
+) (map[string][]common.KeyScoreMember, error) {
+	var (
+		view = f.traced()
+		op   = SlowOp{Op: "select", Began: time.Now(), RequestID: f.requestID, Limit: limit, Strategy: strategyName(view.selecter)}
+	)
+	results, err := fn(view)
+	for _, tuples := range results {
+		op.Results += len(tuples)
+	}
+	view.trace.finish(op, keys, err)
+	return results, err
+}
+
+// logSlowInsert performs the write of the tuples via fn on a traced view of
+// the farm, and logs it if it's slow.
+func (f *Farm) logSlowInsert(tuples []common.KeyScoreMember, fn func(*Farm) error) error {
+	var (
+		view = f.traced()
+		op   = SlowOp{Op: "insert", Began: time.Now(), RequestID: f.requestID, Results: len(tuples)}
+	)
+	err := fn(view)
+	view.trace.finish(op, keysOf(tuples), err)
+	return err
+}
+
+// strategyName returns the name of the ReadStrategy of the Selecter.
+func strategyName(s Selecter) string {
+	switch s := s.(type) {
+	case sendOneReadOne:
+		return "SendOneReadOne"
+	case sendAllReadAll:
+		return "SendAllReadAll"
+	case sendVarReadFirstLinger:
+		return "SendVarReadFirstLinger"
+	case sendAllReadDigests:
+		return "SendAllReadDigests"
+	case preferLocalZone:
+		return "PreferLocalZone"
+	case fallback:
+		names := make([]string, len(s.strategies))
+		for i, strategy := range s.strategies {
+			names[i] = strategyName(strategy(s.farm))
+		}
+		return strings.Join(names, ",")
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,89 @@
+package farm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSlowOps(t *testing.T) {
+	var (
+		slow     = clustertest.New()
+		clusters = []cluster.Cluster{clustertest.New(), slow}
+		farm     = New(clusters, 2, SendAllReadAll, NoRepairs, nil, LogSlowOps(20*time.Millisecond, 2, false))
+	)
+	if err := farm.Insert([]common.KeyScoreMember{testingKeyScoreMember}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := farm.SelectOffset([]string{"key"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if ops := farm.SlowOps(); len(ops) != 0 {
+		t.Fatalf("expected no slow ops, got %+v", ops)
+	}
+
+	slow.Delay(clustertest.SelectOffset, 50*time.Millisecond)
+	if _, err := farm.WithRequestID("abc").SelectOffset([]string{"key", "key", "nokey"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	ops := farm.SlowOps()
+	if len(ops) != 1 {
+		t.Fatalf("expected 1 slow op, got %+v", ops)
+	}
+	op := ops[0]
+	if op.Op != "select" || op.RequestID != "abc" || op.Limit != 10 || op.Strategy != "SendAllReadAll" || op.Results != 1 {
+		t.Errorf("unexpected slow op %+v", op)
+	}
+	if op.NumKeys != 2 || len(op.Keys) != 2 || op.Keys[0] != "key" || op.Keys[1] != "nokey" {
+		t.Errorf("expected keys [key nokey], got %v (%d)", op.Keys, op.NumKeys)
+	}
+	if op.Duration < 50*time.Millisecond {
+		t.Errorf("expected a duration of at least 50ms, got %s", op.Duration)
+	}
+	if len(op.Clusters) != 2 || op.Clusters[1].Index != 1 || op.Clusters[1].Duration < 50*time.Millisecond {
+		t.Errorf("expected the slow cluster to respond last, got %+v", op.Clusters)
+	}
+
+	// The log keeps the most recent ops, the most recent first.
+	slow.Delay(clustertest.Insert, 50*time.Millisecond)
+	slow.FailWith(clustertest.Insert, errors.New("failtown"))
+	for i := 0; i < 2; i++ {
+		if err := farm.Insert([]common.KeyScoreMember{testingKeyScoreMember}); err == nil {
+			t.Fatal("expected a quorum failure, got none")
+		}
+	}
+	ops = farm.SlowOps()
+	if len(ops) != 2 || ops[0].Op != "insert" || ops[1].Op != "insert" {
+		t.Fatalf("expected 2 slow inserts, got %+v", ops)
+	}
+	if ops[0].Error == "" || ops[0].Results != 1 || !ops[0].Began.After(ops[1].Began) {
+		t.Errorf("unexpected slow ops %+v", ops)
+	}
+	if timings := ops[0].Clusters; len(timings) != 2 || timings[1].Index != 1 || timings[1].Error != "failtown" {
+		t.Errorf("expected the failure of the slow cluster, got %+v", timings)
+	}
+}
+
+func TestSlowOpsHashKeys(t *testing.T) {
+	farm := New([]cluster.Cluster{clustertest.New()}, 1, SendOneReadOne, NoRepairs, nil, LogSlowOps(0, 10, true))
+	if _, err := farm.SelectOffset([]string{"key"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	ops := farm.SlowOps()
+	if len(ops) != 1 {
+		t.Fatalf("expected 1 slow op, got %+v", ops)
+	}
+	if keys := ops[0].Keys; len(keys) != 1 || keys[0] == "key" || len(keys[0]) != 16 {
+		t.Errorf("expected a hashed key, got %v", keys)
+	}
+	if ops[0].Strategy != "SendOneReadOne" {
+		t.Errorf("expected SendOneReadOne, got %q", ops[0].Strategy)
+	}
+	if ops := New([]cluster.Cluster{clustertest.New()}, 1, SendOneReadOne, NoRepairs, nil).SlowOps(); ops != nil {
+		t.Errorf("expected no slow op log, got %+v", ops)
+	}
+}
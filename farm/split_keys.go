@@ -0,0 +1,250 @@
+package farm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// SplitKeys spreads each key with one of the prefixes across n physical
+// subkeys, so that a single extremely hot key, e.g. a popular timeline, isn't
+// bound to the throughput of the one Redis instance it hashes to. The
+// subkeys of key k are named k#0 to k#n-1, and hash independently.
+//
+// Writes of a member always go to the same subkey, chosen by a hash of the
+// member, rather than round-robin: a delete must land where the insert did,
+// and a member must live in one subkey only, for the last write to win.
+// SelectOffset, SelectOffsetAscending, and SelectRange of a split key select
+// all of its subkeys, and merge them into a single page, and DeletePrefix
+// deletes from all of them. Contains, DeleteIf, and Rejected address the
+// subkey of each member. Other methods, such as SelectStride, Inspect,
+// MemoryUsage, and Locate, and the walker, see the subkeys as separate keys.
+//
+// If several prefixes match a key, the longest wins, so a longer prefix with
+// an n of 1 exempts its keys from the split of a shorter one. Changing n for
+// a prefix which already has data strands the members in their old subkeys,
+// so split a new prefix instead, and migrate, e.g. with WriteTransform.
+func SplitKeys(prefixes map[string]int) Option {
+	return func(f *Farm) {
+		var (
+			s     = &keySplits{}
+			split = false
+		)
+		for prefix, n := range prefixes {
+			s.rules = append(s.rules, splitRule{prefix, n})
+			split = split || n > 1
+		}
+		if !split {
+			return
+		}
+		sort.Slice(s.rules, func(i, j int) bool { return len(s.rules[i].prefix) > len(s.rules[j].prefix) })
+		f.splits = s
+	}
+}
+
+// ParseSplitKeys parses a comma-separated list of rules for SplitKeys, each
+// "PREFIX=N", spreading keys with the prefix PREFIX across N subkeys.
+// Prefixes may not contain commas or equals signs.
+func ParseSplitKeys(s string) (map[string]int, error) {
+	prefixes := map[string]int{}
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		eq := strings.LastIndex(rule, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid split rule %q (must be PREFIX=N)", rule)
+		}
+		n, err := strconv.Atoi(rule[eq+1:])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid split rule %q (N must be a positive integer)", rule)
+		}
+		if _, ok := prefixes[rule[:eq]]; ok {
+			return nil, fmt.Errorf("duplicate split rule for prefix %q", rule[:eq])
+		}
+		prefixes[rule[:eq]] = n
+	}
+	return prefixes, nil
+}
+
+type splitRule struct {
+	prefix string
+	n      int
+}
+
+// keySplits maps logical keys to their subkeys. A nil keySplits splits no
+// key.
+type keySplits struct {
+	rules []splitRule // longest prefix first
+}
+
+// subkeys returns how many subkeys the key is split across, or 0 if it isn't.
+func (s *keySplits) subkeys(key string) int {
+	if s == nil {
+		return 0
+	}
+	for _, rule := range s.rules {
+		if strings.HasPrefix(key, rule.prefix) {
+			if rule.n <= 1 {
+				return 0
+			}
+			return rule.n
+		}
+	}
+	return 0
+}
+
+func splitKey(key string, i int) string {
+	return key + "#" + strconv.Itoa(i)
+}
+
+// physical returns the subkeys of the key, or the key itself, if it isn't
+// split.
+func (s *keySplits) physical(key string) []string {
+	n := s.subkeys(key)
+	if n <= 0 {
+		return []string{key}
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = splitKey(key, i)
+	}
+	return keys
+}
+
+// subkeyOf returns the subkey which holds the member of the key, or the key
+// itself, if it isn't split.
+func (s *keySplits) subkeyOf(key, member string) string {
+	n := s.subkeys(key)
+	if n <= 0 {
+		return key
+	}
+	h := fnv.New32a()
+	h.Write([]byte(member))
+	return splitKey(key, int(h.Sum32()%uint32(n)))
+}
+
+// split returns the tuples with the keys of split keys replaced by the
+// subkeys of their members. The passed slice is never modified.
+func (s *keySplits) split(tuples []common.KeyScoreMember) []common.KeyScoreMember {
+	if s == nil {
+		return tuples
+	}
+	split := make([]common.KeyScoreMember, len(tuples))
+	for i, tuple := range tuples {
+		tuple.Key = s.subkeyOf(tuple.Key, tuple.Member)
+		split[i] = tuple
+	}
+	return split
+}
+
+// splitKeyMembers is like split, for key-members.
+func (s *keySplits) splitKeyMembers(keyMembers []common.KeyMember) []common.KeyMember {
+	if s == nil {
+		return keyMembers
+	}
+	split := make([]common.KeyMember, len(keyMembers))
+	for i, keyMember := range keyMembers {
+		keyMember.Key = s.subkeyOf(keyMember.Key, keyMember.Member)
+		split[i] = keyMember
+	}
+	return split
+}
+
+// selectMerged selects the keys, or, for split keys, their subkeys, via the
+// select function, and merges the pages of the subkeys into one per key, up
+// to limit tuples, in order. The select function is told whether any key was
+// split, and so is the caller.
+func (s *keySplits) selectMerged(
+	keys []string,
+	limit int,
+	ascending bool,
+	sel func(physical []string, split bool) (map[string][]common.KeyScoreMember, error),
+) (map[string][]common.KeyScoreMember, bool, error) {
+	var (
+		physical = make([]string, 0, len(keys))
+		split    = false
+	)
+	for _, key := range keys {
+		if s.subkeys(key) > 0 {
+			split = true
+		}
+		physical = append(physical, s.physical(key)...)
+	}
+	if !split {
+		results, err := sel(keys, false)
+		return results, false, err
+	}
+
+	selected, err := sel(physical, true)
+	if err != nil {
+		return nil, true, err
+	}
+	results := make(map[string][]common.KeyScoreMember, len(keys))
+	for _, key := range keys {
+		n := s.subkeys(key)
+		if n <= 0 {
+			results[key] = selected[key]
+			continue
+		}
+		responses := make([][]common.KeyScoreMember, n)
+		for i := range responses {
+			page := selected[splitKey(key, i)]
+			responses[i] = make([]common.KeyScoreMember, len(page))
+			for j, tuple := range page {
+				tuple.Key = key
+				responses[i][j] = tuple
+			}
+		}
+		results[key], _ = merge(responses, limit, ascending)
+	}
+	return results, true, nil
+}
+
+// selectOffset is SelectOffset via the select function, merging split keys.
+// If any key is split, every key is selected from the first element, and
+// trimmed to the offset after the merge.
+func (s *keySplits) selectOffset(
+	keys []string,
+	offset, limit int,
+	ascending bool,
+	sel func(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error),
+) (map[string][]common.KeyScoreMember, error) {
+	results, split, err := s.selectMerged(keys, offset+limit, ascending, func(physical []string, split bool) (map[string][]common.KeyScoreMember, error) {
+		if !split {
+			return sel(physical, offset, limit)
+		}
+		return sel(physical, 0, offset+limit)
+	})
+	if err != nil || !split {
+		return results, err
+	}
+	for key, page := range results {
+		if offset >= len(page) {
+			results[key] = []common.KeyScoreMember{}
+			continue
+		}
+		results[key] = page[offset:]
+	}
+	return results, nil
+}
+
+// selectRange is SelectRange via the select function, merging split keys.
+// The subkeys of a key are selected with the same range, from the highest
+// score to the lowest.
+func (s *keySplits) selectRange(
+	keys []string,
+	start, stop common.Cursor,
+	limit int,
+	sel func(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error),
+) (map[string][]common.KeyScoreMember, error) {
+	results, _, err := s.selectMerged(keys, limit, false, func(physical []string, _ bool) (map[string][]common.KeyScoreMember, error) {
+		return sel(physical, start, stop, limit)
+	})
+	return results, err
+}
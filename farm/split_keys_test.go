@@ -0,0 +1,117 @@
+package farm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestParseSplitKeys(t *testing.T) {
+	prefixes, err := ParseSplitKeys("timeline:hot:=8, a=b=2,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]int{"timeline:hot:": 8, "a=b": 2}; !reflect.DeepEqual(expected, prefixes) {
+		t.Errorf("expected %v, got %v", expected, prefixes)
+	}
+	for _, invalid := range []string{"hot", "=2", "hot=", "hot=0", "hot=x", "hot=2,hot=4"} {
+		if _, err := ParseSplitKeys(invalid); err == nil {
+			t.Errorf("%q: expected error, got none", invalid)
+		}
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	fake := clustertest.New()
+	var (
+		f      = New([]cluster.Cluster{fake}, 1, SendAllReadAll, NoRepairs, nil, SplitKeys(map[string]int{"hot:": 4, "hot:cold:": 1}))
+		direct = New([]cluster.Cluster{fake}, 1, SendAllReadAll, NoRepairs, nil)
+	)
+
+	var tuples []common.KeyScoreMember
+	for i := 0; i < 20; i++ {
+		tuples = append(tuples, common.KeyScoreMember{Key: "hot:1", Score: float64(i), Member: fmt.Sprintf("m%02d", i)})
+	}
+	tuples = append(tuples, common.KeyScoreMember{Key: "hot:cold:1", Score: 1, Member: "a"})
+	if err := f.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Delete([]common.KeyScoreMember{{Key: "hot:1", Score: 20, Member: "m19"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The members are spread across the subkeys, and the longest prefix
+	// leaves hot:cold: unsplit.
+	physical, err := direct.SelectOffset([]string{"hot:1", "hot:1#0", "hot:1#1", "hot:1#2", "hot:1#3", "hot:cold:1"}, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(physical["hot:1"]); n != 0 {
+		t.Errorf("expected the logical key to be empty, got %d member(s)", n)
+	}
+	total := 0
+	for i := 0; i < 4; i++ {
+		page := physical[splitKey("hot:1", i)]
+		if len(page) <= 0 || len(page) >= 19 {
+			t.Errorf("subkey %d: expected some of the members, got %d", i, len(page))
+		}
+		total += len(page)
+	}
+	if total != 19 {
+		t.Errorf("expected 19 members across the subkeys, got %d", total)
+	}
+	if n := len(physical["hot:cold:1"]); n != 1 {
+		t.Errorf("expected hot:cold:1 to be unsplit, got %d member(s)", n)
+	}
+
+	members := func(page []common.KeyScoreMember) []string {
+		got := []string{}
+		for _, tuple := range page {
+			if tuple.Key != "hot:1" {
+				t.Errorf("expected the logical key, got %q", tuple.Key)
+			}
+			got = append(got, tuple.Member)
+		}
+		return got
+	}
+	selected, err := f.SelectOffset([]string{"hot:1", "hot:cold:1"}, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []string{"m16", "m15", "m14"}, members(selected["hot:1"]); !reflect.DeepEqual(expected, got) {
+		t.Errorf("SelectOffset: expected %v, got %v", expected, got)
+	}
+	if n := len(selected["hot:cold:1"]); n != 0 {
+		t.Errorf("SelectOffset: expected the offset to apply to hot:cold:1, got %d member(s)", n)
+	}
+	if selected, err = f.SelectOffsetAscending([]string{"hot:1"}, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []string{"m01", "m02"}, members(selected["hot:1"]); !reflect.DeepEqual(expected, got) {
+		t.Errorf("SelectOffsetAscending: expected %v, got %v", expected, got)
+	}
+	if selected, err = f.SelectOffset([]string{"hot:1"}, 30, 10); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(selected["hot:1"]); n != 0 {
+		t.Errorf("SelectOffset beyond the end: expected no members, got %d", n)
+	}
+	if selected, err = f.SelectRange([]string{"hot:1"}, common.Cursor{Score: 10, Member: "m10"}, common.Cursor{}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []string{"m09", "m08", "m07"}, members(selected["hot:1"]); !reflect.DeepEqual(expected, got) {
+		t.Errorf("SelectRange: expected %v, got %v", expected, got)
+	}
+
+	present, err := f.Contains([]common.KeyMember{{Key: "hot:1", Member: "m03"}, {Key: "hot:1", Member: "m19"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []bool{true, false}; !reflect.DeepEqual(expected, present) {
+		t.Errorf("Contains: expected %v, got %v", expected, present)
+	}
+}
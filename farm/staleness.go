@@ -0,0 +1,175 @@
+package farm
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// ErrStale is returned by strict Selects when every cluster lags behind its
+// last known convergence by more than the MaxStaleness. See Farm.Strict.
+var ErrStale = errors.New("every cluster is stale")
+
+// MaxStaleness causes the farm to poll, every refresh interval, the time each
+// cluster was last known to have converged, as recorded by the walker via
+// cluster.ConvergenceTracker. Selecters returned by Strict then only read
+// from the clusters which converged within the lag. Clusters which don't
+// implement cluster.ConvergenceTracker, or have never converged, are stale.
+// Close stops the polling.
+func MaxStaleness(lag, refresh time.Duration) Option {
+	return func(f *Farm) {
+		f.convergence = newConvergence(f.clusters, lag)
+		go f.convergence.poll(refresh)
+	}
+}
+
+// Close stops the background work of the farm, like the polling of
+// MaxStaleness. Strict Selecters then keep using the last convergence times
+// polled, so clusters grow stale. Close may be called more than once.
+func (f *Farm) Close() error {
+	if f.convergence != nil {
+		f.convergence.stop()
+	}
+	return nil
+}
+
+// Strict returns a Selecter over the clusters of the farm which converged
+// within the MaxStaleness, and aren't in maintenance, with the same
+// ReadStrategy. If every cluster is stale, its Selects fail with ErrStale.
+// Without the MaxStaleness option, the farm itself is returned.
+func (f *Farm) Strict() Selecter {
+	if f.convergence == nil {
+		return f
+	}
+	fresh := f.convergence.fresh(time.Now())
+	if f.maintenance != nil {
+		available, _ := f.maintenance.available()
+		for i := range fresh {
+			fresh[i] = fresh[i] && available[i]
+		}
+	}
+	anyFresh := false
+	for _, ok := range fresh {
+		anyFresh = anyFresh || ok
+	}
+	if !anyFresh {
+		return staleSelecter{}
+	}
+	view := f.restrict(fresh)
+	view.maintenance = nil
+	if f.unrepaired != nil {
+		view.unrepaired = f.unrepaired.restrict(fresh)
+		view.unrepaired.maintenance = nil
+	}
+	return view
+}
+
+// restrict returns a view of the farm over the clusters marked to keep, for
+// reads. Writes and repairs of the view still go to every cluster.
+func (f *Farm) restrict(keep []bool) *Farm {
+	view := *f
+	view.clusters = make([]cluster.Cluster, 0, len(f.clusters))
+	if f.zones != nil {
+		view.zones = make([]string, 0, len(f.zones))
+	}
+	for i, c := range f.clusters {
+		if !keep[i] {
+			continue
+		}
+		view.clusters = append(view.clusters, c)
+		if f.zones != nil {
+			view.zones = append(view.zones, f.zones[i])
+		}
+	}
+	view.selecter = f.readStrategy(&view)
+	return &view
+}
+
+// convergence tracks the last known convergence time of each cluster of a
+// farm. It's safe for concurrent use.
+type convergence struct {
+	sync.RWMutex
+	clusters  []cluster.Cluster
+	lag       time.Duration
+	converged []time.Time
+	quit      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newConvergence(clusters []cluster.Cluster, lag time.Duration) *convergence {
+	return &convergence{
+		clusters:  clusters,
+		lag:       lag,
+		converged: make([]time.Time, len(clusters)),
+		quit:      make(chan struct{}),
+	}
+}
+
+// poll refreshes the convergence times right away, and then every interval,
+// until stop.
+func (c *convergence) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.refresh()
+		select {
+		case <-ticker.C:
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// stop stops poll. The last convergence times are kept.
+func (c *convergence) stop() {
+	c.stopOnce.Do(func() { close(c.quit) })
+}
+
+// refresh reads the convergence time of each cluster. Clusters which fail
+// keep their previous time, and grow staler.
+func (c *convergence) refresh() {
+	for i, cl := range c.clusters {
+		tracker, ok := cl.(cluster.ConvergenceTracker)
+		if !ok {
+			continue
+		}
+		t, err := tracker.Converged()
+		if err != nil {
+			log.Printf("MaxStaleness: cluster %d: %s", i, err)
+			continue
+		}
+		c.Lock()
+		c.converged[i] = t
+		c.Unlock()
+	}
+}
+
+// fresh returns, for each cluster, whether it converged within the lag.
+func (c *convergence) fresh(now time.Time) []bool {
+	c.RLock()
+	defer c.RUnlock()
+	fresh := make([]bool, len(c.converged))
+	for i, t := range c.converged {
+		fresh[i] = !t.IsZero() && now.Sub(t) <= c.lag
+	}
+	return fresh
+}
+
+// staleSelecter fails every Select with ErrStale.
+type staleSelecter struct{}
+
+func (staleSelecter) SelectOffset([]string, int, int) (map[string][]common.KeyScoreMember, error) {
+	return map[string][]common.KeyScoreMember{}, ErrStale
+}
+
+func (staleSelecter) SelectOffsetAscending([]string, int, int) (map[string][]common.KeyScoreMember, error) {
+	return map[string][]common.KeyScoreMember{}, ErrStale
+}
+
+func (staleSelecter) SelectRange([]string, common.Cursor, common.Cursor, int) (map[string][]common.KeyScoreMember, error) {
+	return map[string][]common.KeyScoreMember{}, ErrStale
+}
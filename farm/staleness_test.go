@@ -0,0 +1,75 @@
+package farm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestStrict(t *testing.T) {
+	var (
+		fakes    = []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+		clusters = []cluster.Cluster{fakes[0], fakes[1], fakes[2]}
+		now      = time.Now()
+	)
+	fakes[0].MarkConverged(now.Add(-time.Hour)) // stale
+	fakes[1].MarkConverged(now)                 // fresh
+	// fakes[2] never converged, so it's stale
+
+	farm := New(clusters, 1, SendAllReadAll, NoRepairs, nil, MaxStaleness(time.Minute, time.Hour))
+	farm.convergence.refresh() // don't depend on the poller
+
+	tuple := common.KeyScoreMember{Key: "foo", Score: 1, Member: "bar"}
+	if err := farm.Insert([]common.KeyScoreMember{tuple}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := farm.Strict().SelectOffset([]string{"foo"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	for i, fake := range fakes {
+		expected := 0
+		if i == 1 {
+			expected = 1
+		}
+		if got := fake.CallCount(clustertest.SelectOffset); expected != got {
+			t.Errorf("cluster %d: expected %d Select(s), got %d", i, expected, got)
+		}
+	}
+
+	// Without fresh clusters, strict Selects fail.
+	stale := New(clusters[:1], 1, SendOneReadOne, NoRepairs, nil, MaxStaleness(time.Minute, time.Hour))
+	stale.convergence.refresh()
+	if _, err := stale.Strict().SelectOffset([]string{"foo"}, 0, 10); err != ErrStale {
+		t.Errorf("expected %v, got %v", ErrStale, err)
+	}
+
+	// Without the option, every cluster is read.
+	if plain := New(clusters, 1, SendAllReadAll, NoRepairs, nil); plain.Strict() != Selecter(plain) {
+		t.Errorf("expected Strict to return the farm itself without MaxStaleness")
+	}
+}
+
+func TestMaxStalenessClose(t *testing.T) {
+	fake := clustertest.New()
+	farm := New([]cluster.Cluster{fake}, 1, SendAllReadAll, NoRepairs, nil, MaxStaleness(time.Minute, time.Millisecond))
+
+	deadline := time.Now().Add(time.Second)
+	for fake.CallCount(clustertest.Converged) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout: the convergence times weren't polled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	farm.Close()
+	farm.Close()
+
+	time.Sleep(5 * time.Millisecond) // a poll in progress may complete
+	polls := fake.CallCount(clustertest.Converged)
+	time.Sleep(20 * time.Millisecond)
+	if got := fake.CallCount(clustertest.Converged); got != polls {
+		t.Errorf("expected polling to stop after %d polls, got %d", polls, got)
+	}
+}
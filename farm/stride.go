@@ -0,0 +1,79 @@
+package farm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// SelectStride returns every stride-th element of each key, from the highest
+// score to the lowest, starting at the offset, and up to limit elements per
+// key, e.g. for previews and sparklines of large keys, without reading every
+// element. Clusters must implement cluster.Sampler.
+//
+// The elements at the same ranks may differ between diverged clusters, so
+// samples aren't merged: each key is sampled from the first cluster to
+// return it without error. No read repairs are issued. An error is returned
+// if any key couldn't be sampled from any cluster.
+func (f *Farm) SelectStride(keys []string, offset, stride, limit int) (map[string][]common.KeyScoreMember, error) {
+	if stride < 1 {
+		return nil, fmt.Errorf("invalid stride %d", stride)
+	}
+	// High performance optimization.
+	if len(keys) <= 0 {
+		return map[string][]common.KeyScoreMember{}, nil
+	}
+	f = f.readable()
+
+	// Every cluster emits at most one element per key, so the channel never
+	// blocks, even once the result is complete.
+	var (
+		elements = make(chan cluster.Element, len(f.clusters)*len(keys))
+		wg       sync.WaitGroup
+	)
+	wg.Add(len(f.clusters))
+	for i, c := range f.clusters {
+		go func(i int, c cluster.Cluster) {
+			defer wg.Done()
+			s, ok := c.(cluster.Sampler)
+			if !ok {
+				err := fmt.Errorf("cluster %d: sampling not supported", i)
+				for _, key := range keys {
+					elements <- cluster.Element{Key: key, Error: err}
+				}
+				return
+			}
+			for element := range s.SelectStride(keys, offset, stride, limit) {
+				if element.Error != nil {
+					element.Error = fmt.Errorf("cluster %d: %s", i, element.Error)
+				}
+				elements <- element
+			}
+		}(i, c)
+	}
+	go func() { wg.Wait(); close(elements) }()
+
+	var (
+		result = make(map[string][]common.KeyScoreMember, len(keys))
+		wanted = map[string]bool{}
+		err    = fmt.Errorf("no sample returned")
+	)
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	for element := range elements {
+		if element.Error != nil {
+			err = element.Error
+			continue
+		}
+		if _, ok := result[element.Key]; !ok && wanted[element.Key] {
+			result[element.Key] = element.KeyScoreMembers
+		}
+		if len(result) >= len(wanted) {
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("%d key(s) not sampled: %s", len(wanted)-len(result), err)
+}
@@ -0,0 +1,48 @@
+package farm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSelectStride(t *testing.T) {
+	var (
+		failing = clustertest.New()
+		healthy = clustertest.New()
+		tuples  = []common.KeyScoreMember{}
+	)
+	for i := 1; i <= 10; i++ {
+		tuples = append(tuples, common.KeyScoreMember{Key: "foo", Score: float64(i), Member: fmt.Sprint(i)})
+	}
+	if err := healthy.Insert(tuples); err != nil {
+		t.Fatal(err)
+	}
+	failing.FailWith(clustertest.SelectStride, errors.New("unavailable"))
+
+	f := New([]cluster.Cluster{failing, healthy}, 2, SendAllReadAll, NoRepairs, nil)
+	got, err := f.SelectStride([]string{"foo", "bar"}, 1, 3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string][]common.KeyScoreMember{
+		"foo": {tuples[8], tuples[5], tuples[2]},
+		"bar": {},
+	}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// If no cluster can sample a key, the select fails.
+	healthy.FailWith(clustertest.SelectStride, errors.New("unavailable"))
+	if _, err := f.SelectStride([]string{"foo"}, 0, 2, 10); err == nil {
+		t.Errorf("expected an error, got none")
+	}
+	if _, err := f.SelectStride([]string{"foo"}, 0, 0, 10); err == nil {
+		t.Errorf("stride 0: expected an error, got none")
+	}
+}
@@ -0,0 +1,78 @@
+package farm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// Touch moves each member to the score of its tuple, if it's inserted with
+// a lower score, e.g. to bump it to the top of a timeline, without the
+// delete and insert pair which would otherwise be needed, and which would
+// re-add a member deleted in the meantime. Members which aren't inserted,
+// or already have the same or a higher score, are left alone. Each cluster
+// which implements cluster.Toucher applies the touches atomically; other
+// clusters fail the write. Scores are bounded by MaxScoreSkew, like those of
+// inserts.
+//
+// Touch reports whether each touch was applied by any of the clusters which
+// responded before the write quorum was reached. Touches are evaluated per
+// cluster, so a cluster which still has a member applies its touch even if
+// the others have deleted it, with a lower score, and read repairs then
+// propagate the touched member, as its score is higher.
+func (f *Farm) Touch(tuples []common.KeyScoreMember) ([]bool, error) {
+	tuples, err := f.checkInsert(tuples)
+	if err != nil {
+		return nil, err
+	}
+	stored := f.splits.split(tuples)
+
+	var (
+		mu      sync.Mutex
+		applied = make([]bool, len(tuples))
+	)
+	_, err = f.write(
+		stored,
+		func(c cluster.Cluster, _ []common.KeyScoreMember) error {
+			t, ok := c.(cluster.Toucher)
+			if !ok {
+				return fmt.Errorf("touches not supported by %T", c)
+			}
+			a, err := t.Touch(stored)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for i := range a {
+				applied[i] = applied[i] || a[i]
+			}
+			return nil
+		},
+		insertInstrumentation{f.instrumentation},
+		false,
+	)
+
+	// Clusters which respond after the quorum don't change the result.
+	mu.Lock()
+	result := make([]bool, len(applied))
+	copy(result, applied)
+	mu.Unlock()
+	if err != nil {
+		return result, err
+	}
+
+	var appliedTuples []common.KeyScoreMember
+	for i, ok := range result {
+		if ok {
+			appliedTuples = append(appliedTuples, tuples[i])
+		}
+	}
+	if len(appliedTuples) > 0 {
+		f.archive(appliedTuples)
+	}
+	f.notify(OpInsert, appliedTuples)
+	return result, nil
+}
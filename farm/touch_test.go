@@ -0,0 +1,62 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestTouch(t *testing.T) {
+	fakes := []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+	for _, fake := range fakes {
+		if err := fake.Insert([]common.KeyScoreMember{
+			{Key: "foo", Score: 5, Member: "a"},
+			{Key: "foo", Score: 8, Member: "b"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := fake.Delete([]common.KeyScoreMember{{Key: "foo", Score: 5, Member: "c"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := New([]cluster.Cluster{fakes[0], fakes[1], fakes[2]}, 3, SendAllReadAll, NoRepairs, nil)
+	applied, err := f.Touch([]common.KeyScoreMember{
+		{Key: "foo", Score: 10, Member: "a"}, // moved
+		{Key: "foo", Score: 7, Member: "b"},  // already newer
+		{Key: "foo", Score: 10, Member: "c"}, // deleted
+		{Key: "foo", Score: 10, Member: "d"}, // never inserted
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{true, false, false, false}, applied; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for i, fake := range fakes {
+		presence, err := fake.Score([]common.KeyMember{{Key: "foo", Member: "a"}, {Key: "foo", Member: "b"}, {Key: "foo", Member: "c"}, {Key: "foo", Member: "d"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for keyMember, expected := range map[common.KeyMember]cluster.Presence{
+			{Key: "foo", Member: "a"}: {Present: true, Inserted: true, Score: 10},
+			{Key: "foo", Member: "b"}: {Present: true, Inserted: true, Score: 8},
+			{Key: "foo", Member: "c"}: {Present: true, Inserted: false, Score: 5},
+			{Key: "foo", Member: "d"}: {Present: false},
+		} {
+			if got := presence[keyMember]; expected != got {
+				t.Errorf("cluster %d: %v: expected %+v, got %+v", i, keyMember, expected, got)
+			}
+		}
+	}
+
+	// Clusters which can't touch fail the write.
+	mixed := New([]cluster.Cluster{fakes[0], newMockCluster()}, 2, SendAllReadAll, NoRepairs, nil)
+	if _, err := mixed.Touch([]common.KeyScoreMember{{Key: "foo", Score: 11, Member: "b"}}); err == nil {
+		t.Errorf("expected error without quorum, got none")
+	}
+}
@@ -0,0 +1,104 @@
+package farm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Transform rewrites a tuple on the write path. See WriteTransform.
+type Transform func(common.KeyScoreMember) common.KeyScoreMember
+
+// WriteTransform causes every tuple written via the farm, by Insert, Delete,
+// and their verbose variants, to be rewritten by the transform first. It's
+// meant for data model migrations done in-band: during a transition window,
+// producers may keep writing the old form, while the farm stores the new one.
+// Reads and read repairs are unaffected, so readers should switch to the new
+// form when the transform is put in place. Rejected looks up tuples in their
+// transformed form, but reports them as written. If the option is passed
+// several times, the transforms are applied in order.
+func WriteTransform(t Transform) Option {
+	return func(f *Farm) {
+		if prev := f.writeTransform; prev != nil {
+			f.writeTransform = func(tuple common.KeyScoreMember) common.KeyScoreMember { return t(prev(tuple)) }
+			return
+		}
+		f.writeTransform = t
+	}
+}
+
+// RenameKeyPrefix returns a Transform which replaces the prefix old of keys
+// with new. Other keys are unchanged.
+func RenameKeyPrefix(old, new string) Transform {
+	return func(tuple common.KeyScoreMember) common.KeyScoreMember {
+		if strings.HasPrefix(tuple.Key, old) {
+			tuple.Key = new + tuple.Key[len(old):]
+		}
+		return tuple
+	}
+}
+
+// RenameMemberPrefix returns a Transform which replaces the prefix old of
+// members with new. Other members are unchanged.
+func RenameMemberPrefix(old, new string) Transform {
+	return func(tuple common.KeyScoreMember) common.KeyScoreMember {
+		if strings.HasPrefix(tuple.Member, old) {
+			tuple.Member = new + tuple.Member[len(old):]
+		}
+		return tuple
+	}
+}
+
+// ParseTransforms parses a comma-separated list of rewrite rules into a
+// single Transform, which applies them in order. Each rule is either
+// "key:OLD=NEW" or "member:OLD=NEW", renaming the prefix OLD of keys or
+// members to NEW. Prefixes may not contain commas or equals signs. An empty
+// string yields a nil Transform.
+func ParseTransforms(s string) (Transform, error) {
+	var transforms []Transform
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		colon := strings.Index(rule, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid rewrite rule %q (must be key:OLD=NEW or member:OLD=NEW)", rule)
+		}
+		renaming := strings.SplitN(rule[colon+1:], "=", 2)
+		if len(renaming) != 2 || renaming[0] == "" {
+			return nil, fmt.Errorf("invalid rewrite rule %q (must be key:OLD=NEW or member:OLD=NEW)", rule)
+		}
+		switch rule[:colon] {
+		case "key":
+			transforms = append(transforms, RenameKeyPrefix(renaming[0], renaming[1]))
+		case "member":
+			transforms = append(transforms, RenameMemberPrefix(renaming[0], renaming[1]))
+		default:
+			return nil, fmt.Errorf("invalid rewrite rule %q (must be key:OLD=NEW or member:OLD=NEW)", rule)
+		}
+	}
+	if len(transforms) <= 0 {
+		return nil, nil
+	}
+	return func(tuple common.KeyScoreMember) common.KeyScoreMember {
+		for _, t := range transforms {
+			tuple = t(tuple)
+		}
+		return tuple
+	}, nil
+}
+
+// transform returns the tuples rewritten by the write transform, if any. The
+// passed slice is never modified.
+func (f *Farm) transform(tuples []common.KeyScoreMember) []common.KeyScoreMember {
+	if f.writeTransform == nil {
+		return tuples
+	}
+	transformed := make([]common.KeyScoreMember, len(tuples))
+	for i, tuple := range tuples {
+		transformed[i] = f.writeTransform(tuple)
+	}
+	return transformed
+}
@@ -0,0 +1,80 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestParseTransforms(t *testing.T) {
+	transform, err := ParseTransforms("key:user:=account:, member:v1|=v2|")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for input, expected := range map[common.KeyScoreMember]common.KeyScoreMember{
+		{Key: "user:1", Score: 1, Member: "v1|a"}:  {Key: "account:1", Score: 1, Member: "v2|a"},
+		{Key: "user:1", Score: 1, Member: "v2|a"}:  {Key: "account:1", Score: 1, Member: "v2|a"},
+		{Key: "group:1", Score: 1, Member: "v1|a"}: {Key: "group:1", Score: 1, Member: "v2|a"},
+		{Key: "users:1", Score: 1, Member: "x"}:    {Key: "users:1", Score: 1, Member: "x"},
+	} {
+		if got := transform(input); expected != got {
+			t.Errorf("%v: expected %v, got %v", input, expected, got)
+		}
+	}
+
+	if transform, err := ParseTransforms(""); err != nil || transform != nil {
+		t.Errorf("empty: expected no transform, got %v (%v)", transform, err)
+	}
+	for _, invalid := range []string{"key", "key:=new", "key:old", "score:1=2"} {
+		if _, err := ParseTransforms(invalid); err == nil {
+			t.Errorf("%q: expected error, got none", invalid)
+		}
+	}
+}
+
+func TestWriteTransform(t *testing.T) {
+	fake := clustertest.New()
+	f := New(
+		[]cluster.Cluster{fake},
+		1,
+		SendAllReadAll,
+		NoRepairs,
+		nil,
+		WriteTransform(RenameKeyPrefix("old:", "new:")),
+		WriteTransform(RenameMemberPrefix("a", "b")),
+	)
+
+	written := []common.KeyScoreMember{{Key: "old:1", Score: 2, Member: "a1"}}
+	if err := f.Insert(written); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := (common.KeyScoreMember{Key: "old:1", Score: 2, Member: "a1"}), written[0]; expected != got {
+		t.Errorf("the written tuples were modified: expected %v, got %v", expected, got)
+	}
+	got, err := f.SelectOffset([]string{"old:1", "new:1"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string][]common.KeyScoreMember{
+		"old:1": []common.KeyScoreMember{},
+		"new:1": []common.KeyScoreMember{{Key: "new:1", Score: 2, Member: "b1"}},
+	}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// Rejections are looked up in the transformed form, and reported as
+	// written.
+	if err := f.Delete([]common.KeyScoreMember{{Key: "old:1", Score: 3, Member: "a1"}}); err != nil {
+		t.Fatal(err)
+	}
+	rejections, err := f.Rejected(written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Rejection{{Tuple: written[0], WinningScore: 3, WinnerDeleted: true}}; !reflect.DeepEqual(expected, rejections) {
+		t.Errorf("expected %v, got %v", expected, rejections)
+	}
+}
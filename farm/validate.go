@@ -0,0 +1,47 @@
+package farm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// Validate checks every instance of every cluster via cluster.Check, and
+// writes a report to w. Clusters with different numbers of instances are
+// reported, as they usually indicate a configuration mistake, but aren't an
+// error. Validate returns an error if any instance failed its check.
+func Validate(w io.Writer, clusters []cluster.Cluster) error {
+	var (
+		failures  = 0
+		instances = -1
+		uneven    = false
+	)
+	fmt.Fprintf(w, "%d cluster(s)\n", len(clusters))
+	for i, c := range clusters {
+		checks, err := cluster.Check(c)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "cluster %d: %d instance(s)\n", i+1, len(checks))
+		for _, check := range checks {
+			if check.Err != nil {
+				failures++
+				fmt.Fprintf(w, "  %s: FAIL: %s\n", check.Address, check.Err)
+				continue
+			}
+			fmt.Fprintf(w, "  %s: OK\n", check.Address)
+		}
+		if instances >= 0 && instances != len(checks) {
+			uneven = true
+		}
+		instances = len(checks)
+	}
+	if uneven {
+		fmt.Fprintf(w, "WARNING: clusters have different numbers of instances\n")
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d instance(s) failed validation", failures)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package farm
+
+import (
+	"log"
+	"sync"
+)
+
+// warmupBatchSize is how many hot keys are read in each Select of a warmup.
+const warmupBatchSize = 100
+
+// WarmHotKeys causes the farm to remember the last n distinct keys it was
+// asked to Select, in a ring buffer. When a cluster leaves maintenance, e.g.
+// after a restart which lost writes, those keys are read from every cluster
+// and repaired, the most recently selected first, before the key-members
+// written during maintenance are repaired, and before the cluster is read
+// from again. The timelines users are reading then converge first, rather
+// than whenever the walker gets to them among the long tail of cold keys.
+//
+// Each key is read as deep as the deepest Select of it, i.e. its largest
+// offset plus limit. Keys stay in the order they were first selected, so a
+// key which stays hot is dropped after n other keys, and selected again.
+func WarmHotKeys(n int) Option {
+	if n <= 0 {
+		panic("hot keys to warm must be positive")
+	}
+	return func(f *Farm) {
+		f.hotKeys = &hotKeys{
+			ring:  make([]hotKey, 0, n),
+			slots: map[string]int{},
+		}
+	}
+}
+
+// hotKeys is a ring buffer of the last distinct keys selected from a farm.
+// It's shared by all views of the farm, and safe for concurrent use.
+type hotKeys struct {
+	mtx   sync.Mutex
+	ring  []hotKey
+	next  int            // the oldest key, once ring is full
+	slots map[string]int // key: index in ring
+}
+
+type hotKey struct {
+	key   string
+	depth int // offset plus limit of its deepest Select
+}
+
+// touch records a Select of the keys, as deep as depth.
+func (h *hotKeys) touch(keys []string, depth int) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for _, key := range keys {
+		if i, ok := h.slots[key]; ok {
+			if depth > h.ring[i].depth {
+				h.ring[i].depth = depth
+			}
+			continue
+		}
+		if len(h.ring) < cap(h.ring) {
+			h.slots[key] = len(h.ring)
+			h.ring = append(h.ring, hotKey{key, depth})
+			continue
+		}
+		delete(h.slots, h.ring[h.next].key)
+		h.ring[h.next] = hotKey{key, depth}
+		h.slots[key] = h.next
+		h.next = (h.next + 1) % len(h.ring)
+	}
+}
+
+// recent returns the keys, the most recently selected first.
+func (h *hotKeys) recent() []hotKey {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	keys := make([]hotKey, 0, len(h.ring))
+	for i := len(h.ring) - 1; i >= 0; i-- {
+		keys = append(keys, h.ring[(h.next+i)%len(h.ring)])
+	}
+	return keys
+}
+
+// warmup reads the hot keys from every cluster, including those in or
+// leaving maintenance, and repairs them, blocking until the repairs are
+// done.
+func (f *Farm) warmup(index int) {
+	if f.hotKeys == nil {
+		return
+	}
+	keys := f.hotKeys.recent()
+	if len(keys) <= 0 {
+		return
+	}
+	log.Printf("maintenance: cluster %d: warming %d hot key(s)", index, len(keys))
+
+	view := *f
+	view.maintenance = nil
+	view.cache = nil
+	view.hotKeys = nil
+	view.slowOps = nil
+	view.repairStrategy = AllRepairs(f.clusters, f.instrumentation)
+	view.readStrategy = SendAllReadAll
+	view.selecter = SendAllReadAll(&view)
+	for len(keys) > 0 {
+		n := warmupBatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		byDepth := map[int][]string{}
+		for _, k := range keys[:n] {
+			byDepth[k.depth] = append(byDepth[k.depth], k.key)
+		}
+		for depth, batch := range byDepth {
+			if _, err := view.SelectOffset(batch, 0, depth); err != nil {
+				log.Printf("maintenance: cluster %d: warming %d hot key(s): %s", index, len(batch), err)
+			}
+		}
+		keys = keys[n:]
+	}
+}
@@ -0,0 +1,74 @@
+package farm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestWarmHotKeys(t *testing.T) {
+	var (
+		fakes    = []*clustertest.Fake{clustertest.New(), clustertest.New(), clustertest.New()}
+		clusters = []cluster.Cluster{fakes[0], fakes[1], fakes[2]}
+		farm     = New(clusters, 2, SendAllReadAll, NoRepairs, nil, WarmHotKeys(10))
+		hot      = common.KeyScoreMember{Key: "hot", Score: 1, Member: "a"}
+		cold     = common.KeyScoreMember{Key: "cold", Score: 1, Member: "b"}
+	)
+
+	// Cluster 1 restarts, and loses the keys the others have.
+	for _, i := range []int{0, 2} {
+		if err := fakes[i].Insert([]common.KeyScoreMember{hot, cold}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := farm.SetMaintenance(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := farm.SelectOffset([]string{"hot"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leaving maintenance repairs the hot key on it, but not the cold one.
+	if err := farm.SetMaintenance(1, false); err != nil {
+		t.Fatal(err)
+	}
+	farm.maintenance.wait(1)
+	for key, expected := range map[string][]common.KeyScoreMember{
+		"hot":  {hot},
+		"cold": {},
+	} {
+		for e := range fakes[1].SelectOffset([]string{key}, 0, 10) {
+			if e.Error != nil {
+				t.Fatal(e.Error)
+			}
+			if !reflect.DeepEqual(expected, e.KeyScoreMembers) {
+				t.Errorf("%s: expected %v, got %v", key, expected, e.KeyScoreMembers)
+			}
+		}
+	}
+
+	// Leaving maintenance again, without entering it, doesn't warm anything.
+	calls := fakes[1].CallCount(clustertest.SelectOffset)
+	if err := farm.SetMaintenance(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := fakes[1].CallCount(clustertest.SelectOffset); got != calls {
+		t.Errorf("expected no Selects outside of maintenance, got %d", got-calls)
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	h := &hotKeys{ring: make([]hotKey, 0, 2), slots: map[string]int{}}
+	h.touch([]string{"a", "b"}, 10)
+	h.touch([]string{"a"}, 20)
+	if expected, got := []hotKey{{"b", 10}, {"a", 20}}, h.recent(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	h.touch([]string{"c", "a"}, 5)
+	if expected, got := []hotKey{{"a", 5}, {"c", 5}}, h.recent(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,208 @@
+package farm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// Zones tags each cluster with the zone, e.g. the region or datacenter, it's
+// deployed in. Zones correspond to the clusters passed to New, and may be
+// parsed from a farm string with ParseZones. Zone-aware read strategies,
+// like PreferLocalZone, use them to avoid reads across zones. Clusters
+// without a zone are in the zone "".
+func Zones(zones []string) Option {
+	return func(f *Farm) { f.zones = zones }
+}
+
+// ValidZones returns an error if any of the local zones, as passed to
+// PreferLocalZone or LocalWrites, has no clusters among the zones.
+func ValidZones(zones []string, local ...string) error {
+	for _, l := range local {
+		found := false
+		for _, zone := range zones {
+			found = found || zone == l
+		}
+		if !found {
+			return fmt.Errorf("no clusters in zone %q", l)
+		}
+	}
+	return nil
+}
+
+// PreferLocalZone is a ReadStrategy for farms whose clusters span multiple
+// zones. It sends the read request to every cluster in the local zone, and
+// waits for all responses, like SendAllReadAll. Keys for which fewer than
+// quorum clusters responded without error are then read from the clusters
+// of the next zone, and so on, in the order the zones are first declared,
+// until every key has a quorum or every zone was tried. Responses from all
+// zones which were tried are merged, and read repairs are issued for any
+// differences.
+//
+// With a quorum no larger than the number of clusters in the local zone,
+// remote zones are only read when local clusters fail, so cross-zone latency
+// and traffic are only paid when necessary. Zones must be configured with
+// the Zones option.
+func PreferLocalZone(zone string, quorum int) ReadStrategy {
+	if quorum < 1 {
+		quorum = 1
+	}
+	return func(farm *Farm) Selecter {
+		return preferLocalZone{
+			Farm:   farm,
+			tiers:  farm.zoneTiers(zone),
+			quorum: quorum,
+		}
+	}
+}
+
+type preferLocalZone struct {
+	*Farm
+	tiers  [][]cluster.Cluster // local zone first, then remote zones
+	quorum int
+}
+
+// zoneTiers groups the clusters by zone, with the local zone first, and
+// remote zones in the order they're first declared.
+func (f *Farm) zoneTiers(local string) [][]cluster.Cluster {
+	var (
+		tiers = [][]cluster.Cluster{[]cluster.Cluster{}}
+		index = map[string]int{local: 0}
+	)
+	for i, c := range f.clusters {
+		zone := ""
+		if i < len(f.zones) {
+			zone = f.zones[i]
+		}
+		if _, ok := index[zone]; !ok {
+			index[zone] = len(tiers)
+			tiers = append(tiers, []cluster.Cluster{})
+		}
+		tiers[index[zone]] = append(tiers[index[zone]], c)
+	}
+	return tiers
+}
+
+// SelectOffset implements farm.Selecter.
+func (s preferLocalZone) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return c.SelectOffset(keys, offset, limit)
+	}, limit, false)
+}
+
+// SelectOffsetAscending implements farm.Selecter.
+func (s preferLocalZone) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return c.SelectOffsetAscending(keys, offset, limit)
+	}, limit, true)
+}
+
+// SelectRange implements farm.Selecter.
+func (s preferLocalZone) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	return s.read(keys, func(c cluster.Cluster, keys []string) <-chan cluster.Element {
+		return c.SelectRange(keys, start, stop, limit)
+	}, limit, false)
+}
+
+func (s preferLocalZone) read(keys []string, fn func(cluster.Cluster, []string) <-chan cluster.Element, limit int, ascending bool) (map[string][]common.KeyScoreMember, error) {
+	began := time.Now()
+	go func() {
+		s.Farm.instrumentation.SelectCall()
+		s.Farm.instrumentation.SelectKeys(len(keys))
+	}()
+	defer func() { go s.Farm.instrumentation.SelectDuration(time.Since(began)) }()
+
+	// Read each zone in turn, for the keys which don't have a quorum yet. As
+	// with SendAllReadAll, error elements aren't included in the responses.
+	var (
+		firstResponseDuration time.Duration
+
+		blockingBegan = time.Now()
+		responses     = map[string][][]common.KeyScoreMember{}
+		retrieved     = 0
+		remaining     = keys
+		tried         = 0 // tiers
+		rejected      = 0 // tiers, by every cluster
+	)
+	for i, tier := range s.tiers {
+		if len(remaining) <= 0 {
+			break
+		}
+		if len(tier) <= 0 {
+			continue
+		}
+		if i > 0 {
+			go s.Farm.instrumentation.SelectZoneFallback(len(remaining))
+		}
+		go s.Farm.instrumentation.SelectSendTo(len(tier))
+
+		elements := make(chan cluster.Element)
+		wg := sync.WaitGroup{}
+		wg.Add(len(tier))
+		go func() { wg.Wait(); close(elements) }()
+		tierKeys := remaining
+		if err := s.Farm.scatterSelects(tier, s.Farm.observing(func(c cluster.Cluster) <-chan cluster.Element { return fn(c, tierKeys) }), &wg, elements); err != nil {
+			rejected++
+		}
+		tried++
+
+		for e := range elements {
+			if e.Error != nil {
+				s.Farm.logf("PreferLocalZone partial error: %s", e.Error)
+				go s.Farm.instrumentation.SelectPartialError()
+				continue
+			}
+			if firstResponseDuration == 0 {
+				firstResponseDuration = time.Since(blockingBegan)
+			}
+			responses[e.Key] = append(responses[e.Key], e.KeyScoreMembers)
+			retrieved += len(e.KeyScoreMembers)
+		}
+
+		next := []string{}
+		for _, key := range remaining {
+			if len(responses[key]) < s.quorum {
+				next = append(next, key)
+			}
+		}
+		remaining = next
+	}
+	blockingDuration := time.Since(blockingBegan)
+
+	if len(responses) <= 0 && len(keys) > 0 {
+		if tried > 0 && rejected >= tried {
+			return map[string][]common.KeyScoreMember{}, ErrOverloaded
+		}
+		return map[string][]common.KeyScoreMember{}, fmt.Errorf("complete failure")
+	}
+
+	// Compute union and difference sets for each key.
+	var (
+		response = map[string][]common.KeyScoreMember{}
+		repairs  = keyMemberSet{}
+		returned = 0
+	)
+	for key, tupleLists := range responses {
+		union, difference := merge(tupleLists, limit, ascending)
+		response[key] = union
+		returned += len(union)
+		repairs.addMany(difference)
+	}
+	if len(repairs) > 0 {
+		s.Farm.instrumentation.SelectRepairNeeded(len(repairs))
+		s.Farm.repairStrategy(repairs.slice())
+	}
+
+	go func() {
+		s.Farm.instrumentation.SelectFirstResponseDuration(firstResponseDuration)
+		s.Farm.instrumentation.SelectBlockingDuration(blockingDuration)
+		s.Farm.instrumentation.SelectOverheadDuration(time.Since(began) - blockingDuration)
+		s.Farm.instrumentation.SelectRetrieved(retrieved)
+		s.Farm.instrumentation.SelectReturned(returned)
+		s.Farm.amplification.observe("PreferLocalZone", retrieved, returned, s.Farm.instrumentation)
+	}()
+	return response, nil
+}
@@ -13,6 +13,7 @@ type Instrumentation interface {
 	DeleteInstrumentation
 	RepairInstrumentation
 	WalkInstrumentation
+	DialInstrumentation
 }
 
 // InsertInstrumentation describes metrics for the Insert path.
@@ -22,24 +23,38 @@ type InsertInstrumentation interface {
 	InsertCallDuration(time.Duration)   // time spent per call
 	InsertRecordDuration(time.Duration) // time spent per record (average)
 	InsertQuorumFailure()               // called if the Insert failed due to lack of quorum
+	InsertMemberTooLarge(int)           // +N, where N is how many records were rejected for exceeding the max member size
+	InsertScoreSkewed(int)              // +N, where N is how many records had scores too far ahead of the clock, and were rejected or clamped
+	InsertLocalDropped(int)             // +N, where N is how many records of writes with the local write concern weren't written to the other clusters, because too many writes were pending
 }
 
 // SelectInstrumentation describes metrics for the Select path.
 type SelectInstrumentation interface {
-	SelectCall()                               // called for every invocation of Select
-	SelectKeys(int)                            // how many keys were requested
-	SelectSendTo(int)                          // how many clusters the read strategy sent the read to
-	SelectFirstResponseDuration(time.Duration) // how long until we got the first element
-	SelectPartialError()                       // called when an individual key gave an error from the cluster
-	SelectBlockingDuration(time.Duration)      // time spent waiting for everything
-	SelectOverheadDuration(time.Duration)      // time spent not waiting
-	SelectDuration(time.Duration)              // overall time performing this read (blocking + overhead)
-	SelectSendAllPermitGranted()               // called when the permitter allows SendVarReadFirstLinger to send to all clusters
-	SelectSendAllPermitRejected()              // called when the permitter doesn't allow SendVarReadFirstLinger to send to all clusters
-	SelectSendAllPromotion()                   // called when the read strategy promotes a "SendOne" to a "SendAll" because of missing results
-	SelectRetrieved(int)                       // total number of KeyScoreMembers retrieved from the backing store
-	SelectReturned(int)                        // total number of KeyScoreMembers returned to the caller
-	SelectRepairNeeded(int)                    // +N, where N is every keyMember detected in a difference set (prior to entering repair strategy)
+	SelectCall()                                     // called for every invocation of Select
+	SelectKeys(int)                                  // how many keys were requested
+	SelectSendTo(int)                                // how many clusters the read strategy sent the read to
+	SelectFirstResponseDuration(time.Duration)       // how long until we got the first element
+	SelectPartialError()                             // called when an individual key gave an error from the cluster
+	SelectBlockingDuration(time.Duration)            // time spent waiting for everything
+	SelectOverheadDuration(time.Duration)            // time spent not waiting
+	SelectDuration(time.Duration)                    // overall time performing this read (blocking + overhead)
+	SelectSendAllPermitGranted()                     // called when the permitter allows SendVarReadFirstLinger to send to all clusters
+	SelectSendAllPermitRejected()                    // called when the permitter doesn't allow SendVarReadFirstLinger to send to all clusters
+	SelectSendAllPromotion()                         // called when the read strategy promotes a "SendOne" to a "SendAll" because of missing results
+	SelectZoneFallback(int)                          // +N, where N is every key sent to remote zones, because the local zone couldn't form a read quorum
+	SelectDigestMismatch(int)                        // +N, where N is every key read in full from a cluster, because its digest disagreed
+	SelectRetrieved(int)                             // total number of KeyScoreMembers retrieved from the backing store
+	SelectReturned(int)                              // total number of KeyScoreMembers returned to the caller
+	SelectReadAmplification(string, float64)         // for read strategy S, KeyScoreMembers retrieved per KeyScoreMember returned, for every Select which returned any
+	SelectRepairNeeded(int)                          // +N, where N is every keyMember detected in a difference set (prior to entering repair strategy)
+	SelectRepairExempted(int)                        // +N, where N is every keyMember detected in a difference set of a repair-exempt Select (not repaired)
+	SelectCacheHits(int)                             // +N, where N is every key served from the client-side cache
+	SelectCacheMisses(int)                           // +N, where N is every key not in the client-side cache, and read from the clusters
+	SelectMemberFilterNegatives(int)                 // +N, where N is every key-member found absent by its member filter, without a round-trip to the clusters
+	SelectMemberFilterFalsePositives(int)            // +N, where N is every key-member which passed its member filter, but was absent in the clusters
+	SelectClusterHealth(int, time.Duration, float64) // set for cluster index I, the moving average of its latency and error rate
+	SelectClusterQueueDepth(int, int)                // set for cluster index I, the number of Selects waiting for its workers
+	SelectClusterOverloaded(int)                     // called with cluster index I when a Select is rejected because its queue is full
 }
 
 // DeleteInstrumentation describes metrics for the Delete path.
@@ -53,14 +68,28 @@ type DeleteInstrumentation interface {
 
 // RepairInstrumentation describes metrics for Repairs.
 type RepairInstrumentation interface {
-	RepairCall()            // called for every requested repair
-	RepairRequest(int)      // +N, where N is the total number of keyMembers for which repair was requested
-	RepairDiscarded(int)    // +N, where N is keyMembers requested to repair but discarded due to e.g. rate limits
-	RepairWriteSuccess(int) // +N, where N is keyMembers successfully written to a cluster as a result of a repair
-	RepairWriteFailure(int) // +N, where N is keyMembers unsuccessfully written to a cluster as a result of a repair
+	RepairCall()             // called for every requested repair
+	RepairRequest(int)       // +N, where N is the total number of keyMembers for which repair was requested
+	RepairDiscarded(int)     // +N, where N is keyMembers requested to repair but discarded due to e.g. rate limits
+	RepairWriteSuccess(int)  // +N, where N is keyMembers successfully written to a cluster as a result of a repair
+	RepairWriteFailure(int)  // +N, where N is keyMembers unsuccessfully written to a cluster as a result of a repair
+	RepairVerifyFailure(int) // +N, where N is keyMembers which a cluster didn't have as repaired when read back after a repair
 }
 
 // WalkInstrumentation describes metrics for walkers.
 type WalkInstrumentation interface {
-	WalkKeys(int) // +N, where N is the number of keys received from a Scanner and sent for Select
+	WalkKeys(int)                   // +N, where N is the number of keys received from a Scanner and sent for Select
+	WalkPassProgress(float64)       // set to the percentage of the estimated keyspace covered by the current pass
+	WalkKeysRemaining(int)          // set to the estimated number of keys the current pass has yet to walk
+	WalkPassDuration(time.Duration) // time taken by a complete pass over the keyspace
+	WalkPassComplete()              // called for every complete pass over the keyspace
+}
+
+// DialInstrumentation describes metrics for connections to Redis instances.
+type DialInstrumentation interface {
+	DialSuccess()                        // called for every connection established to a Redis instance
+	DialFailure()                        // called for every failed attempt to connect to a Redis instance
+	DialDNSDuration(time.Duration)       // time spent resolving the host name of a Redis instance
+	DialConnectDuration(time.Duration)   // time spent establishing the connection to a Redis instance
+	DialHandshakeDuration(time.Duration) // time spent setting up a new connection before use, e.g. enabling client tracking
 }
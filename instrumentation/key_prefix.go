@@ -0,0 +1,73 @@
+package instrumentation
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	// NoKeyPrefix is the KeyPrefix label of keys without the delimiter.
+	NoKeyPrefix = "_none"
+
+	// OtherKeyPrefix is the KeyPrefix label of keys whose prefix wasn't
+	// among the first max distinct prefixes seen by a KeyPrefixer.
+	OtherKeyPrefix = "_other"
+)
+
+// KeyPrefixer extracts the prefix of keys, for use as the KeyPrefix label,
+// e.g. to attribute load to the logical datasets (timelines, notifications)
+// sharing a farm. The prefix is the portion of the key before the first
+// delimiter. To bound the cardinality of the label, only the first max
+// distinct prefixes are reported as such, and any others as OtherKeyPrefix.
+// KeyPrefixer is safe for concurrent use.
+type KeyPrefixer struct {
+	mtx       sync.RWMutex
+	delimiter string
+	max       int
+	known     map[string]bool
+}
+
+// NewKeyPrefixer returns a new KeyPrefixer.
+func NewKeyPrefixer(delimiter string, max int) *KeyPrefixer {
+	return &KeyPrefixer{
+		delimiter: delimiter,
+		max:       max,
+		known:     map[string]bool{},
+	}
+}
+
+// Prefix returns the KeyPrefix label for the key.
+func (p *KeyPrefixer) Prefix(key string) string {
+	i := strings.Index(key, p.delimiter)
+	if i < 0 {
+		return NoKeyPrefix
+	}
+	prefix := key[:i]
+
+	p.mtx.RLock()
+	known, full := p.known[prefix], len(p.known) >= p.max
+	p.mtx.RUnlock()
+	switch {
+	case known:
+		return prefix
+	case full:
+		return OtherKeyPrefix
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if len(p.known) >= p.max && !p.known[prefix] {
+		return OtherKeyPrefix
+	}
+	p.known[prefix] = true
+	return prefix
+}
+
+// Count returns how many of the keys have each prefix.
+func (p *KeyPrefixer) Count(keys []string) map[string]int {
+	m := map[string]int{}
+	for _, key := range keys {
+		m[p.Prefix(key)]++
+	}
+	return m
+}
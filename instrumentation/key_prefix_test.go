@@ -0,0 +1,32 @@
+package instrumentation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyPrefixer(t *testing.T) {
+	p := NewKeyPrefixer(":", 2)
+	for key, expected := range map[string]string{
+		"timeline:1":      "timeline",
+		"timeline:2:x":    "timeline",
+		"notifications:1": "notifications",
+		"plain":           NoKeyPrefix,
+	} {
+		if got := p.Prefix(key); expected != got {
+			t.Errorf("%q: expected %q, got %q", key, expected, got)
+		}
+	}
+
+	// Two distinct prefixes are known, so any new ones are bucketed.
+	if expected, got := OtherKeyPrefix, p.Prefix("activities:1"); expected != got {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	if expected, got := map[string]int{
+		"timeline":     2,
+		OtherKeyPrefix: 1,
+	}, p.Count([]string{"timeline:1", "timeline:2", "likes:3"}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,185 @@
+// Package logging implements an InstrumentationV2 which aggregates metrics in
+// memory, and writes a summary of them periodically, for deployments too
+// small to run a metrics stack.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// Satisfaction guaranteed.
+var _ instrumentation.InstrumentationV2 = &Logging{}
+
+// Logging aggregates metrics, and writes a summary of them every interval.
+type Logging struct {
+	w        io.Writer
+	interval time.Duration
+	quit     chan chan struct{}
+
+	mu        sync.Mutex
+	began     time.Time
+	counts    map[string]int64
+	durations map[string]*durations
+	gauges    map[string]float64
+}
+
+// durations aggregates the observations of a latency distribution.
+type durations struct {
+	count         int64
+	sum, min, max time.Duration
+}
+
+// New returns a new Logging, which writes a summary to w every interval,
+// until it's stopped. Each summary is a line of JSON, with the time, the
+// length of the interval in seconds, and the metrics: the sum of each
+// counter, the count, mean, min, and max of each distribution, in
+// milliseconds, and the last value of each gauge. Counters and
+// distributions are reset after each summary; gauges keep their values.
+// Labels are appended to the metric name, e.g.
+// `select.call{cluster=0,key_prefix=user}`.
+//
+// Use instrumentation.V1 to report the metrics of an Instrumentation.
+func New(w io.Writer, interval time.Duration) *Logging {
+	l := &Logging{
+		w:         w,
+		interval:  interval,
+		quit:      make(chan chan struct{}),
+		began:     time.Now(),
+		counts:    map[string]int64{},
+		durations: map[string]*durations{},
+		gauges:    map[string]float64{},
+	}
+	go l.loop()
+	return l
+}
+
+// Count satisfies the InstrumentationV2 interface.
+func (l *Logging) Count(_ context.Context, name string, n int, labels instrumentation.Labels) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.counts[key(name, labels)] += int64(n)
+}
+
+// Observe satisfies the InstrumentationV2 interface.
+func (l *Logging) Observe(_ context.Context, name string, d time.Duration, labels instrumentation.Labels) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := key(name, labels)
+	a, ok := l.durations[k]
+	if !ok {
+		a = &durations{min: d, max: d}
+		l.durations[k] = a
+	}
+	a.count++
+	a.sum += d
+	if d < a.min {
+		a.min = d
+	}
+	if d > a.max {
+		a.max = d
+	}
+}
+
+// Gauge satisfies the InstrumentationV2 interface.
+func (l *Logging) Gauge(_ context.Context, name string, value float64, labels instrumentation.Labels) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gauges[key(name, labels)] = value
+}
+
+// Stop writes a last summary, and stops the periodic summaries.
+func (l *Logging) Stop() {
+	q := make(chan struct{})
+	l.quit <- q
+	<-q
+}
+
+func (l *Logging) loop() {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.Flush(now)
+		case q := <-l.quit:
+			l.Flush(time.Now())
+			close(q)
+			return
+		}
+	}
+}
+
+// Flush writes the summary of the metrics since the last one, as of now, and
+// resets the counters and distributions. It's called every interval, but may
+// be called at any time, e.g. before exiting.
+func (l *Logging) Flush(now time.Time) {
+	type distribution struct {
+		Count  int64   `json:"count"`
+		MeanMs float64 `json:"mean_ms"`
+		MinMs  float64 `json:"min_ms"`
+		MaxMs  float64 `json:"max_ms"`
+	}
+	summary := struct {
+		Time      time.Time               `json:"time"`
+		Interval  float64                 `json:"interval_seconds"`
+		Counts    map[string]int64        `json:"counts"`
+		Durations map[string]distribution `json:"durations"`
+		Gauges    map[string]float64      `json:"gauges"`
+	}{
+		Time:      now,
+		Durations: map[string]distribution{},
+		Gauges:    map[string]float64{},
+	}
+
+	l.mu.Lock()
+	summary.Interval = now.Sub(l.began).Seconds()
+	summary.Counts = l.counts
+	for k, a := range l.durations {
+		summary.Durations[k] = distribution{
+			Count:  a.count,
+			MeanMs: milliseconds(a.sum / time.Duration(a.count)),
+			MinMs:  milliseconds(a.min),
+			MaxMs:  milliseconds(a.max),
+		}
+	}
+	for k, v := range l.gauges {
+		summary.Gauges[k] = v
+	}
+	l.began = now
+	l.counts = map[string]int64{}
+	l.durations = map[string]*durations{}
+	l.mu.Unlock()
+
+	buf, err := json.Marshal(summary)
+	if err != nil {
+		fmt.Fprintf(l.w, "metrics summary: %s\n", err) // e.g. a NaN gauge
+		return
+	}
+	l.w.Write(append(buf, '\n'))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func key(name string, labels instrumentation.Labels) string {
+	a := []string{}
+	if labels.Cluster != "" {
+		a = append(a, "cluster="+labels.Cluster)
+	}
+	if labels.KeyPrefix != "" {
+		a = append(a, "key_prefix="+labels.KeyPrefix)
+	}
+	if len(a) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(a, ",") + "}"
+}
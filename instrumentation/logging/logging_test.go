@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// syncBuffer is a bytes.Buffer which may be written by the summary loop
+// while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+type summary struct {
+	Interval  float64                       `json:"interval_seconds"`
+	Counts    map[string]int64              `json:"counts"`
+	Durations map[string]map[string]float64 `json:"durations"`
+	Gauges    map[string]float64            `json:"gauges"`
+}
+
+func TestSummary(t *testing.T) {
+	var (
+		buf   = &syncBuffer{}
+		l     = New(buf, time.Hour)
+		instr = instrumentation.V1(l)
+		began = time.Now()
+	)
+	instr.InsertCall()
+	instr.InsertCall()
+	instr.InsertRecordCount(10)
+	instr.SelectDuration(10 * time.Millisecond)
+	instr.SelectDuration(30 * time.Millisecond)
+	l.Count(context.Background(), "select.prefix.key", 3, instrumentation.Labels{KeyPrefix: "user"})
+	l.Gauge(context.Background(), "select.cluster.error_rate", 0.5, instrumentation.Labels{Cluster: "2"})
+	l.Flush(began.Add(10 * time.Second))
+	instr.InsertCall()
+	l.Stop()
+
+	lines := buf.lines()
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 summaries, got %d: %v", len(lines), lines)
+	}
+	var first, last summary
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &last); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Interval < 10 {
+		t.Errorf("expected an interval of at least 10s, got %f", first.Interval)
+	}
+	if expected := map[string]int64{"insert.call": 2, "insert.record": 10, "select.prefix.key{key_prefix=user}": 3}; !reflect.DeepEqual(expected, first.Counts) {
+		t.Errorf("counts: expected %v, got %v", expected, first.Counts)
+	}
+	if expected := map[string]float64{"count": 2, "mean_ms": 20, "min_ms": 10, "max_ms": 30}; !reflect.DeepEqual(expected, first.Durations["select.duration"]) {
+		t.Errorf("durations: expected %v, got %v", expected, first.Durations["select.duration"])
+	}
+	if expected := map[string]float64{"select.cluster.error_rate{cluster=2}": 0.5}; !reflect.DeepEqual(expected, first.Gauges) {
+		t.Errorf("gauges: expected %v, got %v", expected, first.Gauges)
+	}
+
+	// Counters and distributions are reset, gauges are kept.
+	if expected := map[string]int64{"insert.call": 1}; !reflect.DeepEqual(expected, last.Counts) {
+		t.Errorf("counts after reset: expected %v, got %v", expected, last.Counts)
+	}
+	if len(last.Durations) != 0 {
+		t.Errorf("durations after reset: expected none, got %v", last.Durations)
+	}
+	if expected := first.Gauges; !reflect.DeepEqual(expected, last.Gauges) {
+		t.Errorf("gauges after reset: expected %v, got %v", expected, last.Gauges)
+	}
+}
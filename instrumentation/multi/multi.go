@@ -0,0 +1,16 @@
+// Package multi implements an Instrumentation that fans out to several other
+// Instrumentations, e.g. to run two metrics systems side by side during a
+// migration.
+package multi
+
+import "github.com/soundcloud/roshi/instrumentation"
+
+// MultiInstrumentation satisfies the Instrumentation interface by demuxing
+// each call to multiple instrumentation targets.
+type MultiInstrumentation = instrumentation.MultiInstrumentation
+
+// New creates a new MultiInstrumentation that will demux all calls to the
+// provided Instrumentation targets.
+func New(instrs ...instrumentation.Instrumentation) instrumentation.Instrumentation {
+	return instrumentation.NewMultiInstrumentation(instrs...)
+}
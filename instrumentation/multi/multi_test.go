@@ -0,0 +1,60 @@
+package multi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+type counting struct {
+	instrumentation.NopInstrumentation
+	calls, records int
+}
+
+func (c *counting) InsertCall()             { c.calls++ }
+func (c *counting) InsertRecordCount(n int) { c.records += n }
+
+func TestNew(t *testing.T) {
+	var (
+		a, b  = &counting{}, &counting{}
+		instr = New(a, b)
+	)
+	instr.InsertCall()
+	instr.InsertRecordCount(3)
+	instr.SelectFirstResponseDuration(time.Millisecond) // not counted, but fanned out
+	for i, c := range []*counting{a, b} {
+		if c.calls != 1 || c.records != 3 {
+			t.Errorf("%d: expected 1 call of 3 records, got %d call(s) of %d record(s)", i, c.calls, c.records)
+		}
+	}
+
+	// The old constructor is the same fan-out.
+	if _, ok := instrumentation.NewMultiInstrumentation(a).(MultiInstrumentation); !ok {
+		t.Errorf("expected a MultiInstrumentation")
+	}
+}
+
+type countingV2 struct {
+	instrumentation.NopInstrumentationV2
+	counts map[string]int
+}
+
+func (c countingV2) Count(ctx context.Context, name string, n int, labels instrumentation.Labels) {
+	c.counts[name+"/"+labels.KeyPrefix] += n
+}
+
+func TestNewV2(t *testing.T) {
+	var (
+		a, b  = countingV2{counts: map[string]int{}}, countingV2{counts: map[string]int{}}
+		instr = NewV2(a, b)
+	)
+	instr.Count(context.Background(), "insert.record", 2, instrumentation.Labels{KeyPrefix: "foo"})
+	instr.Gauge(context.Background(), "usage.stored.member", 1, instrumentation.Labels{})
+	for i, c := range []countingV2{a, b} {
+		if expected, got := 2, c.counts["insert.record/foo"]; expected != got {
+			t.Errorf("%d: expected %d, got %d", i, expected, got)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package multi
+
+import (
+	"context"
+	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// Satisfaction guaranteed.
+var _ instrumentation.InstrumentationV2 = multiInstrumentationV2{}
+
+type multiInstrumentationV2 []instrumentation.InstrumentationV2
+
+// NewV2 creates a new InstrumentationV2 that will demux all calls to the
+// provided InstrumentationV2 targets.
+func NewV2(instrs ...instrumentation.InstrumentationV2) instrumentation.InstrumentationV2 {
+	return multiInstrumentationV2(instrs)
+}
+
+func (m multiInstrumentationV2) Count(ctx context.Context, name string, n int, labels instrumentation.Labels) {
+	for _, instr := range m {
+		instr.Count(ctx, name, n, labels)
+	}
+}
+
+func (m multiInstrumentationV2) Observe(ctx context.Context, name string, d time.Duration, labels instrumentation.Labels) {
+	for _, instr := range m {
+		instr.Observe(ctx, name, d, labels)
+	}
+}
+
+func (m multiInstrumentationV2) Gauge(ctx context.Context, name string, value float64, labels instrumentation.Labels) {
+	for _, instr := range m {
+		instr.Gauge(ctx, name, value, labels)
+	}
+}
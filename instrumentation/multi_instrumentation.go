@@ -12,7 +12,8 @@ type MultiInstrumentation struct {
 var _ Instrumentation = MultiInstrumentation{}
 
 // NewMultiInstrumentation creates a new MultiInstrumentation that will demux
-// all calls to the provided Instrumentation targets.
+// all calls to the provided Instrumentation targets. Package multi exports it
+// as multi.New, alongside its InstrumentationV2 counterpart.
 func NewMultiInstrumentation(instrs ...Instrumentation) Instrumentation {
 	return MultiInstrumentation{
 		instrs: instrs,
@@ -55,6 +56,27 @@ func (i MultiInstrumentation) InsertQuorumFailure() {
 	}
 }
 
+// InsertMemberTooLarge satisfies the Instrumentation interface.
+func (i MultiInstrumentation) InsertMemberTooLarge(n int) {
+	for _, instr := range i.instrs {
+		instr.InsertMemberTooLarge(n)
+	}
+}
+
+// InsertScoreSkewed satisfies the Instrumentation interface.
+func (i MultiInstrumentation) InsertScoreSkewed(n int) {
+	for _, instr := range i.instrs {
+		instr.InsertScoreSkewed(n)
+	}
+}
+
+// InsertLocalDropped satisfies the Instrumentation interface.
+func (i MultiInstrumentation) InsertLocalDropped(n int) {
+	for _, instr := range i.instrs {
+		instr.InsertLocalDropped(n)
+	}
+}
+
 // SelectCall satisfies the Instrumentation interface.
 func (i MultiInstrumentation) SelectCall() {
 	for _, instr := range i.instrs {
@@ -136,6 +158,20 @@ func (i MultiInstrumentation) SelectSendAllPromotion() {
 	}
 }
 
+// SelectZoneFallback satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectZoneFallback(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectZoneFallback(n)
+	}
+}
+
+// SelectDigestMismatch satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectDigestMismatch(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectDigestMismatch(n)
+	}
+}
+
 // SelectRetrieved satisfies the Instrumentation interface.
 func (i MultiInstrumentation) SelectRetrieved(n int) {
 	for _, instr := range i.instrs {
@@ -150,6 +186,13 @@ func (i MultiInstrumentation) SelectReturned(n int) {
 	}
 }
 
+// SelectReadAmplification satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectReadAmplification(strategy string, ratio float64) {
+	for _, instr := range i.instrs {
+		instr.SelectReadAmplification(strategy, ratio)
+	}
+}
+
 // SelectRepairNeeded satisfies the Instrumentation interface.
 func (i MultiInstrumentation) SelectRepairNeeded(n int) {
 	for _, instr := range i.instrs {
@@ -157,6 +200,62 @@ func (i MultiInstrumentation) SelectRepairNeeded(n int) {
 	}
 }
 
+// SelectRepairExempted satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectRepairExempted(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectRepairExempted(n)
+	}
+}
+
+// SelectCacheHits satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectCacheHits(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectCacheHits(n)
+	}
+}
+
+// SelectCacheMisses satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectCacheMisses(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectCacheMisses(n)
+	}
+}
+
+// SelectMemberFilterNegatives satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectMemberFilterNegatives(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectMemberFilterNegatives(n)
+	}
+}
+
+// SelectMemberFilterFalsePositives satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectMemberFilterFalsePositives(n int) {
+	for _, instr := range i.instrs {
+		instr.SelectMemberFilterFalsePositives(n)
+	}
+}
+
+// SelectClusterHealth satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectClusterHealth(index int, latency time.Duration, errorRate float64) {
+	for _, instr := range i.instrs {
+		instr.SelectClusterHealth(index, latency, errorRate)
+	}
+}
+
+// SelectClusterQueueDepth satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectClusterQueueDepth(index, n int) {
+	for _, instr := range i.instrs {
+		instr.SelectClusterQueueDepth(index, n)
+	}
+}
+
+// SelectClusterOverloaded satisfies the Instrumentation interface.
+func (i MultiInstrumentation) SelectClusterOverloaded(index int) {
+	for _, instr := range i.instrs {
+		instr.SelectClusterOverloaded(index)
+	}
+}
+
 // DeleteCall satisfies the Instrumentation interface.
 func (i MultiInstrumentation) DeleteCall() {
 	for _, instr := range i.instrs {
@@ -228,9 +327,79 @@ func (i MultiInstrumentation) RepairWriteFailure(n int) {
 	}
 }
 
+// RepairVerifyFailure satisfies the Instrumentation interface.
+func (i MultiInstrumentation) RepairVerifyFailure(n int) {
+	for _, instr := range i.instrs {
+		instr.RepairVerifyFailure(n)
+	}
+}
+
 // WalkKeys satisfies the Instrumentation interface.
 func (i MultiInstrumentation) WalkKeys(n int) {
 	for _, instr := range i.instrs {
 		instr.WalkKeys(n)
 	}
 }
+
+// WalkPassProgress satisfies the Instrumentation interface.
+func (i MultiInstrumentation) WalkPassProgress(v float64) {
+	for _, instr := range i.instrs {
+		instr.WalkPassProgress(v)
+	}
+}
+
+// WalkKeysRemaining satisfies the Instrumentation interface.
+func (i MultiInstrumentation) WalkKeysRemaining(n int) {
+	for _, instr := range i.instrs {
+		instr.WalkKeysRemaining(n)
+	}
+}
+
+// WalkPassDuration satisfies the Instrumentation interface.
+func (i MultiInstrumentation) WalkPassDuration(d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.WalkPassDuration(d)
+	}
+}
+
+// WalkPassComplete satisfies the Instrumentation interface.
+func (i MultiInstrumentation) WalkPassComplete() {
+	for _, instr := range i.instrs {
+		instr.WalkPassComplete()
+	}
+}
+
+// DialSuccess satisfies the Instrumentation interface.
+func (i MultiInstrumentation) DialSuccess() {
+	for _, instr := range i.instrs {
+		instr.DialSuccess()
+	}
+}
+
+// DialFailure satisfies the Instrumentation interface.
+func (i MultiInstrumentation) DialFailure() {
+	for _, instr := range i.instrs {
+		instr.DialFailure()
+	}
+}
+
+// DialDNSDuration satisfies the Instrumentation interface.
+func (i MultiInstrumentation) DialDNSDuration(d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.DialDNSDuration(d)
+	}
+}
+
+// DialConnectDuration satisfies the Instrumentation interface.
+func (i MultiInstrumentation) DialConnectDuration(d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.DialConnectDuration(d)
+	}
+}
+
+// DialHandshakeDuration satisfies the Instrumentation interface.
+func (i MultiInstrumentation) DialHandshakeDuration(d time.Duration) {
+	for _, instr := range i.instrs {
+		instr.DialHandshakeDuration(d)
+	}
+}
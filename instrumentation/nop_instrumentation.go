@@ -23,6 +23,15 @@ func (i NopInstrumentation) InsertRecordDuration(time.Duration) {}
 // InsertQuorumFailure satisfies the Instrumentation interface.
 func (i NopInstrumentation) InsertQuorumFailure() {}
 
+// InsertMemberTooLarge satisfies the Instrumentation interface.
+func (i NopInstrumentation) InsertMemberTooLarge(int) {}
+
+// InsertScoreSkewed satisfies the Instrumentation interface.
+func (i NopInstrumentation) InsertScoreSkewed(int) {}
+
+// InsertLocalDropped satisfies the Instrumentation interface.
+func (i NopInstrumentation) InsertLocalDropped(int) {}
+
 // SelectCall satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectCall() {}
 
@@ -56,15 +65,48 @@ func (i NopInstrumentation) SelectSendAllPermitRejected() {}
 // SelectSendAllPromotion satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectSendAllPromotion() {}
 
+// SelectZoneFallback satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectZoneFallback(int) {}
+
+// SelectDigestMismatch satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectDigestMismatch(int) {}
+
 // SelectRetrieved satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectRetrieved(int) {}
 
 // SelectReturned satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectReturned(int) {}
 
+// SelectReadAmplification satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectReadAmplification(string, float64) {}
+
 // SelectRepairNeeded satisfies the Instrumentation interface.
 func (i NopInstrumentation) SelectRepairNeeded(int) {}
 
+// SelectRepairExempted satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectRepairExempted(int) {}
+
+// SelectCacheHits satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectCacheHits(int) {}
+
+// SelectCacheMisses satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectCacheMisses(int) {}
+
+// SelectMemberFilterNegatives satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectMemberFilterNegatives(int) {}
+
+// SelectMemberFilterFalsePositives satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectMemberFilterFalsePositives(int) {}
+
+// SelectClusterHealth satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectClusterHealth(int, time.Duration, float64) {}
+
+// SelectClusterQueueDepth satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectClusterQueueDepth(int, int) {}
+
+// SelectClusterOverloaded satisfies the Instrumentation interface.
+func (i NopInstrumentation) SelectClusterOverloaded(int) {}
+
 // DeleteCall satisfies the Instrumentation interface.
 func (i NopInstrumentation) DeleteCall() {}
 
@@ -95,5 +137,35 @@ func (i NopInstrumentation) RepairWriteSuccess(int) {}
 // RepairWriteFailure satisfies the Instrumentation interface.
 func (i NopInstrumentation) RepairWriteFailure(int) {}
 
+// RepairVerifyFailure satisfies the Instrumentation interface.
+func (i NopInstrumentation) RepairVerifyFailure(int) {}
+
 // WalkKeys satisfies the Instrumentation interface.
 func (i NopInstrumentation) WalkKeys(int) {}
+
+// WalkPassProgress satisfies the Instrumentation interface.
+func (i NopInstrumentation) WalkPassProgress(float64) {}
+
+// WalkKeysRemaining satisfies the Instrumentation interface.
+func (i NopInstrumentation) WalkKeysRemaining(int) {}
+
+// WalkPassDuration satisfies the Instrumentation interface.
+func (i NopInstrumentation) WalkPassDuration(time.Duration) {}
+
+// WalkPassComplete satisfies the Instrumentation interface.
+func (i NopInstrumentation) WalkPassComplete() {}
+
+// DialSuccess satisfies the Instrumentation interface.
+func (i NopInstrumentation) DialSuccess() {}
+
+// DialFailure satisfies the Instrumentation interface.
+func (i NopInstrumentation) DialFailure() {}
+
+// DialDNSDuration satisfies the Instrumentation interface.
+func (i NopInstrumentation) DialDNSDuration(time.Duration) {}
+
+// DialConnectDuration satisfies the Instrumentation interface.
+func (i NopInstrumentation) DialConnectDuration(time.Duration) {}
+
+// DialHandshakeDuration satisfies the Instrumentation interface.
+func (i NopInstrumentation) DialHandshakeDuration(time.Duration) {}
@@ -22,121 +22,218 @@ func New(w io.Writer) instrumentation.Instrumentation {
 }
 
 func (i plaintextInstrumentation) InsertCall() {
-	fmt.Fprintf(i, "insert.call.count 1")
+	fmt.Fprintf(i, "insert.call.count 1\n")
 }
 
 func (i plaintextInstrumentation) InsertRecordCount(n int) {
-	fmt.Fprintf(i, "insert.record.count %d", n)
+	fmt.Fprintf(i, "insert.record.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) InsertCallDuration(d time.Duration) {
-	fmt.Fprintf(i, "insert.call.duration_ms %d", d.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "insert.call.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
 
 func (i plaintextInstrumentation) InsertRecordDuration(d time.Duration) {
-	fmt.Fprintf(i, "insert.record.duration_ms %d", d.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "insert.record.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
 
 func (i plaintextInstrumentation) InsertQuorumFailure() {
-	fmt.Fprintf(i, "insert.quorum_failure.count 1")
+	fmt.Fprintf(i, "insert.quorum_failure.count 1\n")
+}
+
+func (i plaintextInstrumentation) InsertMemberTooLarge(n int) {
+	fmt.Fprintf(i, "insert.member_too_large.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) InsertScoreSkewed(n int) {
+	fmt.Fprintf(i, "insert.score_skewed.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) InsertLocalDropped(n int) {
+	fmt.Fprintf(i, "insert.local_dropped.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) SelectCall() {
-	fmt.Fprintf(i, "select.call.count 1")
+	fmt.Fprintf(i, "select.call.count 1\n")
 }
 
 func (i plaintextInstrumentation) SelectKeys(n int) {
-	fmt.Fprintf(i, "select.call.count %d", n)
+	fmt.Fprintf(i, "select.call.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) SelectSendTo(n int) {
-	fmt.Fprintf(i, "select.send_to.count %d", n)
+	fmt.Fprintf(i, "select.send_to.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) SelectFirstResponseDuration(d time.Duration) {
-	fmt.Fprintf(i, "select.first_response.duration_ms %d", d.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "select.first_response.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
 
 func (i plaintextInstrumentation) SelectPartialError() {
-	fmt.Fprintf(i, "select.partial_error.count 1")
+	fmt.Fprintf(i, "select.partial_error.count 1\n")
 }
 
 func (i plaintextInstrumentation) SelectBlockingDuration(d time.Duration) {
-	fmt.Fprintf(i, "select.blocking.duration_ms %d", d.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "select.blocking.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
 
 func (i plaintextInstrumentation) SelectOverheadDuration(d time.Duration) {
-	fmt.Fprintf(i, "select.overhead.duration_ms %d", d.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "select.overhead.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
 
 func (i plaintextInstrumentation) SelectDuration(d time.Duration) {
-	fmt.Fprintf(i, "select.duration_ms %d", d.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "select.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
 
 func (i plaintextInstrumentation) SelectSendAllPermitGranted() {
-	fmt.Fprintf(i, "select.send_all_permit_granted.count 1")
+	fmt.Fprintf(i, "select.send_all_permit_granted.count 1\n")
 }
 
 func (i plaintextInstrumentation) SelectSendAllPermitRejected() {
-	fmt.Fprintf(i, "select.send_all_permit_rejected.count 1")
+	fmt.Fprintf(i, "select.send_all_permit_rejected.count 1\n")
 }
 
 func (i plaintextInstrumentation) SelectSendAllPromotion() {
-	fmt.Fprintf(i, "select.send_all_promotion.count 1")
+	fmt.Fprintf(i, "select.send_all_promotion.count 1\n")
+}
+
+func (i plaintextInstrumentation) SelectZoneFallback(n int) {
+	fmt.Fprintf(i, "select.zone_fallback.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) SelectDigestMismatch(n int) {
+	fmt.Fprintf(i, "select.digest_mismatch.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) SelectRetrieved(n int) {
-	fmt.Fprintf(i, "select.retrieved.count %d", n)
+	fmt.Fprintf(i, "select.retrieved.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) SelectReturned(n int) {
-	fmt.Fprintf(i, "select.returned.count %d", n)
+	fmt.Fprintf(i, "select.returned.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) SelectReadAmplification(strategy string, ratio float64) {
+	fmt.Fprintf(i, "select.read_amplification.%s %f\n", strategy, ratio)
 }
 
 func (i plaintextInstrumentation) SelectRepairNeeded(n int) {
-	fmt.Fprintf(i, "select.repair_needed.count %d", n)
+	fmt.Fprintf(i, "select.repair_needed.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) SelectRepairExempted(n int) {
+	fmt.Fprintf(i, "select.repair_exempted.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) SelectCacheHits(n int) {
+	fmt.Fprintf(i, "select.cache_hits.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) SelectCacheMisses(n int) {
+	fmt.Fprintf(i, "select.cache_misses.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) SelectMemberFilterNegatives(n int) {
+	fmt.Fprintf(i, "select.member_filter_negatives %d\n", n)
+}
+
+func (i plaintextInstrumentation) SelectMemberFilterFalsePositives(n int) {
+	fmt.Fprintf(i, "select.member_filter_false_positives %d\n", n)
+}
+
+func (i plaintextInstrumentation) SelectClusterHealth(index int, latency time.Duration, errorRate float64) {
+	fmt.Fprintf(i, "select.cluster.%d.latency_ms %d\n", index, latency.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "select.cluster.%d.error_rate %f\n", index, errorRate)
+}
+
+func (i plaintextInstrumentation) SelectClusterQueueDepth(index, n int) {
+	fmt.Fprintf(i, "select.cluster.%d.queue_depth %d\n", index, n)
+}
+
+func (i plaintextInstrumentation) SelectClusterOverloaded(index int) {
+	fmt.Fprintf(i, "select.cluster.%d.overloaded.count 1\n", index)
 }
 
 func (i plaintextInstrumentation) DeleteCall() {
-	fmt.Fprintf(i, "delete.call.count 1")
+	fmt.Fprintf(i, "delete.call.count 1\n")
 }
 
 func (i plaintextInstrumentation) DeleteRecordCount(n int) {
-	fmt.Fprintf(i, "delete.record.count %d", n)
+	fmt.Fprintf(i, "delete.record.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) DeleteCallDuration(d time.Duration) {
-	fmt.Fprintf(i, "delete.call.duration_ms %d", d.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "delete.call.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
 
 func (i plaintextInstrumentation) DeleteRecordDuration(d time.Duration) {
-	fmt.Fprintf(i, "delete.record.duration_ms %d", d.Nanoseconds()/1e6)
+	fmt.Fprintf(i, "delete.record.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
 
 func (i plaintextInstrumentation) DeleteQuorumFailure() {
-	fmt.Fprintf(i, "delete.quorum_failure.count 1")
+	fmt.Fprintf(i, "delete.quorum_failure.count 1\n")
 }
 
 func (i plaintextInstrumentation) RepairCall() {
-	fmt.Fprintf(i, "repair.call.count 1")
+	fmt.Fprintf(i, "repair.call.count 1\n")
 }
 
 func (i plaintextInstrumentation) RepairRequest(n int) {
-	fmt.Fprintf(i, "repair.request.count %d", n)
+	fmt.Fprintf(i, "repair.request.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) RepairDiscarded(n int) {
-	fmt.Fprintf(i, "repair.discarded.count %d", n)
+	fmt.Fprintf(i, "repair.discarded.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) RepairWriteSuccess(n int) {
-	fmt.Fprintf(i, "repair.write_success.count %d", n)
+	fmt.Fprintf(i, "repair.write_success.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) RepairWriteFailure(n int) {
-	fmt.Fprintf(i, "repair.write_failure.count %d", n)
+	fmt.Fprintf(i, "repair.write_failure.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) RepairVerifyFailure(n int) {
+	fmt.Fprintf(i, "repair.verify_failure.count %d\n", n)
 }
 
 func (i plaintextInstrumentation) WalkKeys(n int) {
-	fmt.Fprintf(i, "walk.keys.count %d", n)
+	fmt.Fprintf(i, "walk.keys.count %d\n", n)
+}
+
+func (i plaintextInstrumentation) WalkPassProgress(v float64) {
+	fmt.Fprintf(i, "walk.pass.progress %f\n", v)
+}
+
+func (i plaintextInstrumentation) WalkKeysRemaining(n int) {
+	fmt.Fprintf(i, "walk.keys.remaining %d\n", n)
+}
+
+func (i plaintextInstrumentation) WalkPassDuration(d time.Duration) {
+	fmt.Fprintf(i, "walk.pass.duration_ms %d\n", d.Nanoseconds()/1e6)
+}
+
+func (i plaintextInstrumentation) WalkPassComplete() {
+	fmt.Fprintf(i, "walk.pass.complete 1\n")
+}
+
+func (i plaintextInstrumentation) DialSuccess() {
+	fmt.Fprintf(i, "dial.success 1\n")
+}
+
+func (i plaintextInstrumentation) DialFailure() {
+	fmt.Fprintf(i, "dial.failure 1\n")
+}
+
+func (i plaintextInstrumentation) DialDNSDuration(d time.Duration) {
+	fmt.Fprintf(i, "dial.dns.duration_ms %d\n", d.Nanoseconds()/1e6)
+}
+
+func (i plaintextInstrumentation) DialConnectDuration(d time.Duration) {
+	fmt.Fprintf(i, "dial.connect.duration_ms %d\n", d.Nanoseconds()/1e6)
+}
+
+func (i plaintextInstrumentation) DialHandshakeDuration(d time.Duration) {
+	fmt.Fprintf(i, "dial.handshake.duration_ms %d\n", d.Nanoseconds()/1e6)
 }
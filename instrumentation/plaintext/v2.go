@@ -0,0 +1,49 @@
+package plaintext
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// Satisfaction guaranteed.
+var _ instrumentation.InstrumentationV2 = plaintextInstrumentationV2{}
+
+type plaintextInstrumentationV2 struct{ io.Writer }
+
+// NewV2 returns a new InstrumentationV2 that prints metrics to the passed
+// io.Writer. Metrics take the form e.g. `insert.record.count 10`, with any
+// labels in braces, e.g. `select.call.count{cluster="0"} 1`.
+func NewV2(w io.Writer) instrumentation.InstrumentationV2 {
+	return plaintextInstrumentationV2{w}
+}
+
+func (i plaintextInstrumentationV2) Count(_ context.Context, name string, n int, labels instrumentation.Labels) {
+	fmt.Fprintf(i, "%s.count%s %d\n", name, format(labels), n)
+}
+
+func (i plaintextInstrumentationV2) Observe(_ context.Context, name string, d time.Duration, labels instrumentation.Labels) {
+	fmt.Fprintf(i, "%s_ms%s %d\n", name, format(labels), d.Nanoseconds()/1e6)
+}
+
+func (i plaintextInstrumentationV2) Gauge(_ context.Context, name string, value float64, labels instrumentation.Labels) {
+	fmt.Fprintf(i, "%s%s %f\n", name, format(labels), value)
+}
+
+func format(labels instrumentation.Labels) string {
+	a := []string{}
+	if labels.Cluster != "" {
+		a = append(a, fmt.Sprintf("cluster=%q", labels.Cluster))
+	}
+	if labels.KeyPrefix != "" {
+		a = append(a, fmt.Sprintf("key_prefix=%q", labels.KeyPrefix))
+	}
+	if len(a) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(a, ",") + "}"
+}
@@ -0,0 +1,26 @@
+package plaintext
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func TestV1Adapter(t *testing.T) {
+	buf := bytes.Buffer{}
+	instr := instrumentation.V1(NewV2(&buf))
+
+	instr.InsertRecordCount(10)
+	instr.SelectDuration(25 * time.Millisecond)
+	instr.SelectClusterHealth(2, 3*time.Millisecond, 0.5)
+
+	if expected, got := `insert.record.count 10
+select.duration_ms 25
+select.cluster.latency_nanoseconds{cluster="2"} 3000000.000000
+select.cluster.error_rate{cluster="2"} 0.500000
+`, buf.String(); expected != got {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
@@ -0,0 +1,218 @@
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matttproud/golang_protobuf_extensions/ext"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// OpenMetricsContentType is the content type of the OpenMetrics exposition
+// format, served to scrapers which ask for it.
+const OpenMetricsContentType = `application/openmetrics-text; version=1.0.0; charset=utf-8`
+
+// delimitedAccept asks the Prometheus client for its delimited protocol
+// buffer format. Its parser of Accept headers doesn't unquote parameters.
+const delimitedAccept = `application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited`
+
+// created is reported as the creation time of every counter and summary.
+// It's taken at package initialization, i.e. at startup; metrics are
+// registered later, and are zero until then, so it's a safe lower bound for
+// all of them.
+var created = time.Now()
+
+// Handler returns the handler for the metrics endpoint. Scrapers which
+// prefer the OpenMetrics format, via their Accept header, get OpenMetrics,
+// with a _created sample for every counter and summary; all others get the
+// formats of the Prometheus client, as before.
+func Handler() http.Handler {
+	return prometheus.InstrumentHandler("prometheus", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsOpenMetrics(r.Header.Get("Accept")) {
+			prometheus.UninstrumentedHandler().ServeHTTP(w, r)
+			return
+		}
+		families, err := gather()
+		if err != nil {
+			http.Error(w, "An error has occurred:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := writeOpenMetrics(&buf, families, created); err != nil {
+			http.Error(w, "An error has occurred:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", OpenMetricsContentType)
+		w.Write(buf.Bytes())
+	}))
+}
+
+// acceptsOpenMetrics returns true if the Accept header ranks OpenMetrics at
+// least as high as any other format.
+func acceptsOpenMetrics(accept string) bool {
+	var openMetrics, other float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if mediaType == "application/openmetrics-text" {
+			openMetrics = math.Max(openMetrics, q)
+		} else {
+			other = math.Max(other, q)
+		}
+	}
+	return openMetrics > 0 && openMetrics >= other
+}
+
+// gather collects the metric families of the default registry, sorted by
+// name, via its delimited protocol buffer format.
+func gather() ([]*dto.MetricFamily, error) {
+	var (
+		req, _ = http.NewRequest("GET", "/metrics", nil)
+		rec    = &recorder{header: http.Header{}, code: http.StatusOK}
+	)
+	req.Header.Set("Accept", delimitedAccept)
+	prometheus.UninstrumentedHandler().ServeHTTP(rec, req)
+	if rec.code != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", rec.code, bytes.TrimSpace(rec.body.Bytes()))
+	}
+
+	families := []*dto.MetricFamily{}
+	for rec.body.Len() > 0 {
+		family := &dto.MetricFamily{}
+		if _, err := ext.ReadDelimited(&rec.body, family); err != nil {
+			return nil, err
+		}
+		families = append(families, family)
+	}
+	sort.Sort(byName(families))
+	return families, nil
+}
+
+// recorder is an http.ResponseWriter which keeps the response in memory.
+type recorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header         { return r.header }
+func (r *recorder) Write(p []byte) (int, error) { return r.body.Write(p) }
+func (r *recorder) WriteHeader(code int)        { r.code = code }
+
+type byName []*dto.MetricFamily
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].GetName() < a[j].GetName() }
+
+// writeOpenMetrics writes the metric families in the OpenMetrics text
+// format, with the created time for every counter and summary.
+func writeOpenMetrics(w io.Writer, families []*dto.MetricFamily, created time.Time) error {
+	var (
+		bw             = bufio.NewWriter(w)
+		createdSeconds = float64(created.UnixNano()) / 1e9
+	)
+	for _, family := range families {
+		name := family.GetName()
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			name = strings.TrimSuffix(name, "_total")
+			writeMetadata(bw, name, "counter", family.GetHelp())
+			for _, m := range family.GetMetric() {
+				writeSample(bw, name+"_total", m.GetLabel(), "", "", m.GetCounter().GetValue())
+				writeSample(bw, name+"_created", m.GetLabel(), "", "", createdSeconds)
+			}
+		case dto.MetricType_GAUGE:
+			writeMetadata(bw, name, "gauge", family.GetHelp())
+			for _, m := range family.GetMetric() {
+				writeSample(bw, name, m.GetLabel(), "", "", m.GetGauge().GetValue())
+			}
+		case dto.MetricType_SUMMARY:
+			writeMetadata(bw, name, "summary", family.GetHelp())
+			for _, m := range family.GetMetric() {
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					writeSample(bw, name, m.GetLabel(), "quantile", formatFloat(q.GetQuantile()), q.GetValue())
+				}
+				writeSample(bw, name+"_sum", m.GetLabel(), "", "", s.GetSampleSum())
+				writeSample(bw, name+"_count", m.GetLabel(), "", "", float64(s.GetSampleCount()))
+				writeSample(bw, name+"_created", m.GetLabel(), "", "", createdSeconds)
+			}
+		default:
+			writeMetadata(bw, name, "unknown", family.GetHelp())
+			for _, m := range family.GetMetric() {
+				writeSample(bw, name, m.GetLabel(), "", "", m.GetUntyped().GetValue())
+			}
+		}
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+func writeMetadata(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	if help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escape(help))
+	}
+}
+
+// writeSample writes a sample with the labels, and the extra label if its
+// name isn't empty.
+func writeSample(w *bufio.Writer, name string, labels []*dto.LabelPair, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label.GetName(), escape(label.GetValue()))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, escape(extraValue))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
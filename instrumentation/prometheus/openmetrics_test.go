@@ -0,0 +1,119 @@
+package prometheus
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestHandler(t *testing.T) {
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "openmetrics_test",
+		Name:      "requests_count",
+		Help:      "Test requests.",
+	})
+	prometheus.MustRegister(c)
+	defer prometheus.Unregister(c)
+	c.Add(7)
+
+	for accept, expected := range map[string]string{
+		"application/openmetrics-text; version=1.0.0": "openmetrics_test_requests_count_total 7\n",
+		"text/plain; version=0.0.4":                   "openmetrics_test_requests_count 7\n",
+	} {
+		var (
+			rec    = httptest.NewRecorder()
+			req, _ = http.NewRequest("GET", "/metrics", nil)
+		)
+		req.Header.Set("Accept", accept)
+		Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: HTTP %d: %s", accept, rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), expected) {
+			t.Errorf("%q: expected %q in\n%s", accept, expected, rec.Body.String())
+		}
+	}
+}
+
+func TestAcceptsOpenMetrics(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                          false,
+		"text/plain; version=0.0.4": false,
+		"application/openmetrics-text; version=1.0.0":                                         true,
+		"application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1": true,
+		"text/plain;version=0.0.4,application/openmetrics-text;version=1.0.0;q=0.5":           false,
+		"application/openmetrics-text;q=0":                                                    false,
+	} {
+		if got := acceptsOpenMetrics(accept); expected != got {
+			t.Errorf("%q: expected %v, got %v", accept, expected, got)
+		}
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	families := []*dto.MetricFamily{
+		&dto.MetricFamily{
+			Name: proto.String("calls_count"),
+			Help: proto.String(`How many "calls".`),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				&dto.Metric{
+					Label:   []*dto.LabelPair{&dto.LabelPair{Name: proto.String("cluster"), Value: proto.String("0")}},
+					Counter: &dto.Counter{Value: proto.Float64(3)},
+				},
+			},
+		},
+		&dto.MetricFamily{
+			Name: proto.String("queue_depth"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				&dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(2.5)}},
+			},
+		},
+		&dto.MetricFamily{
+			Name: proto.String("call_duration_nanoseconds"),
+			Help: proto.String("Duration."),
+			Type: dto.MetricType_SUMMARY.Enum(),
+			Metric: []*dto.Metric{
+				&dto.Metric{Summary: &dto.Summary{
+					SampleCount: proto.Uint64(2),
+					SampleSum:   proto.Float64(30),
+					Quantile: []*dto.Quantile{
+						&dto.Quantile{Quantile: proto.Float64(0.5), Value: proto.Float64(10)},
+						&dto.Quantile{Quantile: proto.Float64(0.99), Value: proto.Float64(20)},
+					},
+				}},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeOpenMetrics(&buf, families, time.Unix(1500000000, 500000000)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE calls_count counter
+# HELP calls_count How many \"calls\".
+calls_count_total{cluster="0"} 3
+calls_count_created{cluster="0"} 1.5000000005e+09
+# TYPE queue_depth gauge
+queue_depth 2.5
+# TYPE call_duration_nanoseconds summary
+# HELP call_duration_nanoseconds Duration.
+call_duration_nanoseconds{quantile="0.5"} 10
+call_duration_nanoseconds{quantile="0.99"} 20
+call_duration_nanoseconds_sum 30
+call_duration_nanoseconds_count 2
+call_duration_nanoseconds_created 1.5000000005e+09
+# EOF
+`
+	if got := buf.String(); expected != got {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
@@ -4,6 +4,7 @@ package prometheus
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -15,42 +16,72 @@ var _ instrumentation.Instrumentation = PrometheusInstrumentation{}
 
 // PrometheusInstrumentation holds metrics for all instrumented methods.
 type PrometheusInstrumentation struct {
-	insertCallCount                  prometheus.Counter
-	insertRecordCount                prometheus.Counter
-	insertCallDuration               prometheus.Summary
-	insertRecordDuration             prometheus.Summary
-	insertQuorumFailureCount         prometheus.Counter
-	selectCallCount                  prometheus.Counter
-	selectKeysCount                  prometheus.Counter
-	selectSendToCount                prometheus.Counter
-	selectFirstResponseDuration      prometheus.Summary
-	selectPartialErrorCount          prometheus.Counter
-	selectBlockingDuration           prometheus.Summary
-	selectOverheadDuration           prometheus.Summary
-	selectDuration                   prometheus.Summary
-	selectSendAllPermitGrantedCount  prometheus.Counter
-	selectSendAllPermitRejectedCount prometheus.Counter
-	selectSendAllPromotionCount      prometheus.Counter
-	selectRetrievedCount             prometheus.Counter
-	selectReturnedCount              prometheus.Counter
-	selectRepairNeededCount          prometheus.Counter
-	deleteCallCount                  prometheus.Counter
-	deleteRecordCount                prometheus.Counter
-	deleteCallDuration               prometheus.Summary
-	deleteRecordDuration             prometheus.Summary
-	deleteQuorumFailureCount         prometheus.Counter
-	repairCallCount                  prometheus.Counter
-	repairRequestCount               prometheus.Counter
-	repairDiscardedCount             prometheus.Counter
-	repairWriteSuccessCount          prometheus.Counter
-	repairWriteFailureCount          prometheus.Counter
-	walkKeysCount                    prometheus.Counter
+	insertCallCount                       prometheus.Counter
+	insertRecordCount                     prometheus.Counter
+	insertCallDuration                    prometheus.Summary
+	insertRecordDuration                  prometheus.Summary
+	insertQuorumFailureCount              prometheus.Counter
+	insertMemberTooLargeCount             prometheus.Counter
+	insertScoreSkewedCount                prometheus.Counter
+	insertLocalDroppedCount               prometheus.Counter
+	selectCallCount                       prometheus.Counter
+	selectKeysCount                       prometheus.Counter
+	selectSendToCount                     prometheus.Counter
+	selectFirstResponseDuration           prometheus.Summary
+	selectPartialErrorCount               prometheus.Counter
+	selectBlockingDuration                prometheus.Summary
+	selectOverheadDuration                prometheus.Summary
+	selectDuration                        prometheus.Summary
+	selectSendAllPermitGrantedCount       prometheus.Counter
+	selectSendAllPermitRejectedCount      prometheus.Counter
+	selectSendAllPromotionCount           prometheus.Counter
+	selectZoneFallbackCount               prometheus.Counter
+	selectDigestMismatchCount             prometheus.Counter
+	selectRetrievedCount                  prometheus.Counter
+	selectReturnedCount                   prometheus.Counter
+	selectReadAmplification               *prometheus.SummaryVec
+	selectRepairNeededCount               prometheus.Counter
+	selectRepairExemptedCount             prometheus.Counter
+	selectCacheHitsCount                  prometheus.Counter
+	selectCacheMissesCount                prometheus.Counter
+	selectMemberFilterNegativesCount      prometheus.Counter
+	selectMemberFilterFalsePositivesCount prometheus.Counter
+	selectClusterLatencyGauge             *prometheus.GaugeVec
+	selectClusterErrorRateGauge           *prometheus.GaugeVec
+	selectClusterQueueDepthGauge          *prometheus.GaugeVec
+	selectClusterOverloadedCount          *prometheus.CounterVec
+	deleteCallCount                       prometheus.Counter
+	deleteRecordCount                     prometheus.Counter
+	deleteCallDuration                    prometheus.Summary
+	deleteRecordDuration                  prometheus.Summary
+	deleteQuorumFailureCount              prometheus.Counter
+	repairCallCount                       prometheus.Counter
+	repairRequestCount                    prometheus.Counter
+	repairDiscardedCount                  prometheus.Counter
+	repairWriteSuccessCount               prometheus.Counter
+	repairWriteFailureCount               prometheus.Counter
+	repairVerifyFailureCount              prometheus.Counter
+	walkKeysCount                         prometheus.Counter
+	walkPassProgressGauge                 prometheus.Gauge
+	walkKeysRemainingGauge                prometheus.Gauge
+	walkPassDuration                      prometheus.Summary
+	walkPassCompleteCount                 prometheus.Counter
+	dialSuccessCount                      prometheus.Counter
+	dialFailureCount                      prometheus.Counter
+	dialDNSDuration                       prometheus.Summary
+	dialConnectDuration                   prometheus.Summary
+	dialHandshakeDuration                 prometheus.Summary
 }
 
 // New returns a new Instrumentation that prints metrics to the passed
 // io.Writer. All metrics are prefixed with an appropriate bucket name, and
 // take the form e.g. "insert.record.count 10".
 func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
+	return newInstrumentation(prefix, maxSummaryAge, defaultRegistry{})
+}
+
+// newInstrumentation is New, registering the metrics with the registerer.
+func newInstrumentation(prefix string, maxSummaryAge time.Duration, r registerer) PrometheusInstrumentation {
 	i := PrometheusInstrumentation{
 		insertCallCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
@@ -79,6 +110,21 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "insert_quorum_failure_count",
 			Help:      "Insert quorum failure count.",
 		}),
+		insertMemberTooLargeCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "insert_member_too_large_count",
+			Help:      "How many records were rejected for exceeding the max member size.",
+		}),
+		insertScoreSkewedCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "insert_score_skewed_count",
+			Help:      "How many records had scores too far ahead of the clock, and were rejected or clamped.",
+		}),
+		insertLocalDroppedCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "insert_local_dropped_count",
+			Help:      "How many records of writes with the local write concern weren't written to the other clusters, because too many writes were pending.",
+		}),
 		selectCallCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "select_call_count",
@@ -138,6 +184,16 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "select_send_all_promotion_count",
 			Help:      "How many select requests were promoted to a send-all, in appropriate read strategies.",
 		}),
+		selectZoneFallbackCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_zone_fallback_count",
+			Help:      "Number of keys read from remote zones, because the local zone could not form a read quorum.",
+		}),
+		selectDigestMismatchCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_digest_mismatch_count",
+			Help:      "Number of keys read in full from a cluster, because its digest disagreed with the data read.",
+		}),
 		selectRetrievedCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "select_retrieved_count",
@@ -148,11 +204,62 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "select_returned_count",
 			Help:      "How many key-score-member tuples have been returned to clients by select calls.",
 		}),
+		selectReadAmplification: prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "select_read_amplification",
+			Help:      "Key-score-member tuples retrieved from clusters per tuple returned to clients, per read strategy.",
+			MaxAge:    maxSummaryAge,
+		}, []string{"strategy"}),
 		selectRepairNeededCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "select_repair_needed_count",
 			Help:      "How many repairs have been detected and requested by select calls.",
 		}),
+		selectRepairExemptedCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_repair_exempted_count",
+			Help:      "How many key-members were detected as needing repair during repair-exempt Selects, and not repaired.",
+		}),
+		selectCacheHitsCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_cache_hits_count",
+			Help:      "Number of keys served from the client-side cache.",
+		}),
+		selectCacheMissesCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_cache_misses_count",
+			Help:      "Number of keys not in the client-side cache.",
+		}),
+		selectMemberFilterNegativesCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_member_filter_negatives_count",
+			Help:      "Number of key-members found absent by their member filter.",
+		}),
+		selectMemberFilterFalsePositivesCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_member_filter_false_positives_count",
+			Help:      "Number of key-members which passed their member filter, but were absent in the clusters.",
+		}),
+		selectClusterLatencyGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "select_cluster_latency_nanoseconds",
+			Help:      "Moving average of Select latency, per cluster.",
+		}, []string{"cluster"}),
+		selectClusterErrorRateGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "select_cluster_error_rate",
+			Help:      "Moving average of the Select error rate, per cluster.",
+		}, []string{"cluster"}),
+		selectClusterQueueDepthGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "select_cluster_queue_depth",
+			Help:      "Number of Selects waiting for the workers of each cluster.",
+		}, []string{"cluster"}),
+		selectClusterOverloadedCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "select_cluster_overloaded_count",
+			Help:      "Number of Selects rejected because the queue of the cluster was full.",
+		}, []string{"cluster"}),
 		deleteCallCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "delete_call_count",
@@ -205,50 +312,155 @@ func New(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentation {
 			Name:      "repair_write_failure_count",
 			Help:      "Repair write failure count.",
 		}),
+		repairVerifyFailureCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "repair_verify_failure_count",
+			Help:      "Repaired key-members which a cluster didn't have as repaired when read back.",
+		}),
 		walkKeysCount: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: prefix,
 			Name:      "walk_keys_count",
 			Help:      "How many keys have been walked by the walker process.",
 		}),
+		walkPassProgressGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "walk_pass_progress_percent",
+			Help:      "Percentage of the estimated keyspace covered by the current walker pass.",
+		}),
+		walkKeysRemainingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: prefix,
+			Name:      "walk_keys_remaining",
+			Help:      "Estimated number of keys the current walker pass has yet to walk.",
+		}),
+		walkPassDuration: prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "walk_pass_duration_nanoseconds",
+			Help:      "Duration of complete passes over the keyspace.",
+			MaxAge:    maxSummaryAge,
+		}),
+		walkPassCompleteCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "walk_pass_complete_count",
+			Help:      "How many passes over the keyspace the walker has completed.",
+		}),
+		dialSuccessCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "dial_success_count",
+			Help:      "How many connections to Redis instances have been established.",
+		}),
+		dialFailureCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "dial_failure_count",
+			Help:      "How many attempts to connect to Redis instances have failed.",
+		}),
+		dialDNSDuration: prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "dial_dns_duration_nanoseconds",
+			Help:      "Time spent resolving the host names of Redis instances.",
+			MaxAge:    maxSummaryAge,
+		}),
+		dialConnectDuration: prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "dial_connect_duration_nanoseconds",
+			Help:      "Time spent establishing connections to Redis instances.",
+			MaxAge:    maxSummaryAge,
+		}),
+		dialHandshakeDuration: prometheus.NewSummary(prometheus.SummaryOpts{
+			Namespace: prefix,
+			Name:      "dial_handshake_duration_nanoseconds",
+			Help:      "Time spent setting up new connections to Redis instances before use.",
+			MaxAge:    maxSummaryAge,
+		}),
 	}
 
-	prometheus.MustRegister(i.insertCallCount)
-	prometheus.MustRegister(i.insertRecordCount)
-	prometheus.MustRegister(i.insertCallDuration)
-	prometheus.MustRegister(i.insertRecordDuration)
-	prometheus.MustRegister(i.insertQuorumFailureCount)
-	prometheus.MustRegister(i.selectCallCount)
-	prometheus.MustRegister(i.selectKeysCount)
-	prometheus.MustRegister(i.selectSendToCount)
-	prometheus.MustRegister(i.selectFirstResponseDuration)
-	prometheus.MustRegister(i.selectPartialErrorCount)
-	prometheus.MustRegister(i.selectBlockingDuration)
-	prometheus.MustRegister(i.selectOverheadDuration)
-	prometheus.MustRegister(i.selectDuration)
-	prometheus.MustRegister(i.selectSendAllPermitGrantedCount)
-	prometheus.MustRegister(i.selectSendAllPermitRejectedCount)
-	prometheus.MustRegister(i.selectSendAllPromotionCount)
-	prometheus.MustRegister(i.selectRetrievedCount)
-	prometheus.MustRegister(i.selectReturnedCount)
-	prometheus.MustRegister(i.selectRepairNeededCount)
-	prometheus.MustRegister(i.deleteCallCount)
-	prometheus.MustRegister(i.deleteRecordCount)
-	prometheus.MustRegister(i.deleteCallDuration)
-	prometheus.MustRegister(i.deleteRecordDuration)
-	prometheus.MustRegister(i.deleteQuorumFailureCount)
-	prometheus.MustRegister(i.repairCallCount)
-	prometheus.MustRegister(i.repairRequestCount)
-	prometheus.MustRegister(i.repairDiscardedCount)
-	prometheus.MustRegister(i.repairWriteSuccessCount)
-	prometheus.MustRegister(i.repairWriteFailureCount)
-	prometheus.MustRegister(i.walkKeysCount)
+	mustRegister(r, i.insertCallCount)
+	mustRegister(r, i.insertRecordCount)
+	mustRegister(r, i.insertCallDuration)
+	mustRegister(r, i.insertRecordDuration)
+	mustRegister(r, i.insertQuorumFailureCount)
+	mustRegister(r, i.insertMemberTooLargeCount)
+	mustRegister(r, i.insertScoreSkewedCount)
+	mustRegister(r, i.insertLocalDroppedCount)
+	mustRegister(r, i.selectCallCount)
+	mustRegister(r, i.selectKeysCount)
+	mustRegister(r, i.selectSendToCount)
+	mustRegister(r, i.selectFirstResponseDuration)
+	mustRegister(r, i.selectPartialErrorCount)
+	mustRegister(r, i.selectBlockingDuration)
+	mustRegister(r, i.selectOverheadDuration)
+	mustRegister(r, i.selectDuration)
+	mustRegister(r, i.selectSendAllPermitGrantedCount)
+	mustRegister(r, i.selectSendAllPermitRejectedCount)
+	mustRegister(r, i.selectSendAllPromotionCount)
+	mustRegister(r, i.selectZoneFallbackCount)
+	mustRegister(r, i.selectDigestMismatchCount)
+	mustRegister(r, i.selectRetrievedCount)
+	mustRegister(r, i.selectReturnedCount)
+	mustRegister(r, i.selectReadAmplification)
+	mustRegister(r, i.selectRepairNeededCount)
+	mustRegister(r, i.selectRepairExemptedCount)
+	mustRegister(r, i.selectCacheHitsCount)
+	mustRegister(r, i.selectCacheMissesCount)
+	mustRegister(r, i.selectMemberFilterNegativesCount)
+	mustRegister(r, i.selectMemberFilterFalsePositivesCount)
+	mustRegister(r, i.selectClusterLatencyGauge)
+	mustRegister(r, i.selectClusterErrorRateGauge)
+	mustRegister(r, i.selectClusterQueueDepthGauge)
+	mustRegister(r, i.selectClusterOverloadedCount)
+	mustRegister(r, i.deleteCallCount)
+	mustRegister(r, i.deleteRecordCount)
+	mustRegister(r, i.deleteCallDuration)
+	mustRegister(r, i.deleteRecordDuration)
+	mustRegister(r, i.deleteQuorumFailureCount)
+	mustRegister(r, i.repairCallCount)
+	mustRegister(r, i.repairRequestCount)
+	mustRegister(r, i.repairDiscardedCount)
+	mustRegister(r, i.repairWriteSuccessCount)
+	mustRegister(r, i.repairWriteFailureCount)
+	mustRegister(r, i.repairVerifyFailureCount)
+	mustRegister(r, i.walkKeysCount)
+	mustRegister(r, i.walkPassProgressGauge)
+	mustRegister(r, i.walkKeysRemainingGauge)
+	mustRegister(r, i.walkPassDuration)
+	mustRegister(r, i.walkPassCompleteCount)
+	mustRegister(r, i.dialSuccessCount)
+	mustRegister(r, i.dialFailureCount)
+	mustRegister(r, i.dialDNSDuration)
+	mustRegister(r, i.dialConnectDuration)
+	mustRegister(r, i.dialHandshakeDuration)
 
 	return i
 }
 
+// registerer registers collectors. Outside of tests, it's the default
+// registry, which Handler serves.
+type registerer interface {
+	Register(prometheus.Collector) (prometheus.Collector, error)
+	RegisterOrGet(prometheus.Collector) (prometheus.Collector, error)
+}
+
+// defaultRegistry is the registerer of the default registry.
+type defaultRegistry struct{}
+
+func (defaultRegistry) Register(c prometheus.Collector) (prometheus.Collector, error) {
+	return prometheus.Register(c)
+}
+
+func (defaultRegistry) RegisterOrGet(c prometheus.Collector) (prometheus.Collector, error) {
+	return prometheus.RegisterOrGet(c)
+}
+
+// mustRegister registers the collector, and panics if it can't, like
+// prometheus.MustRegister.
+func mustRegister(r registerer, c prometheus.Collector) {
+	if _, err := r.Register(c); err != nil {
+		panic(err)
+	}
+}
+
 // Install installs the Prometheus handlers, so the metrics are available.
 func (i PrometheusInstrumentation) Install(pattern string, mux *http.ServeMux) {
-	mux.Handle(pattern, prometheus.Handler())
+	mux.Handle(pattern, Handler())
 }
 
 // InsertCall satisfies the Instrumentation interface.
@@ -276,6 +488,21 @@ func (i PrometheusInstrumentation) InsertQuorumFailure() {
 	i.insertQuorumFailureCount.Inc()
 }
 
+// InsertMemberTooLarge satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) InsertMemberTooLarge(n int) {
+	i.insertMemberTooLargeCount.Add(float64(n))
+}
+
+// InsertScoreSkewed satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) InsertScoreSkewed(n int) {
+	i.insertScoreSkewedCount.Add(float64(n))
+}
+
+// InsertLocalDropped satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) InsertLocalDropped(n int) {
+	i.insertLocalDroppedCount.Add(float64(n))
+}
+
 // SelectCall satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) SelectCall() {
 	i.selectCallCount.Inc()
@@ -331,6 +558,16 @@ func (i PrometheusInstrumentation) SelectSendAllPromotion() {
 	i.selectSendAllPromotionCount.Inc()
 }
 
+// SelectZoneFallback satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectZoneFallback(n int) {
+	i.selectZoneFallbackCount.Add(float64(n))
+}
+
+// SelectDigestMismatch satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectDigestMismatch(n int) {
+	i.selectDigestMismatchCount.Add(float64(n))
+}
+
 // SelectRetrieved satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) SelectRetrieved(n int) {
 	i.selectRetrievedCount.Add(float64(n))
@@ -341,11 +578,58 @@ func (i PrometheusInstrumentation) SelectReturned(n int) {
 	i.selectReturnedCount.Add(float64(n))
 }
 
+// SelectReadAmplification satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectReadAmplification(strategy string, ratio float64) {
+	i.selectReadAmplification.WithLabelValues(strategy).Observe(ratio)
+}
+
 // SelectRepairNeeded satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) SelectRepairNeeded(n int) {
 	i.selectRepairNeededCount.Add(float64(n))
 }
 
+// SelectRepairExempted satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectRepairExempted(n int) {
+	i.selectRepairExemptedCount.Add(float64(n))
+}
+
+// SelectCacheHits satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectCacheHits(n int) {
+	i.selectCacheHitsCount.Add(float64(n))
+}
+
+// SelectCacheMisses satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectCacheMisses(n int) {
+	i.selectCacheMissesCount.Add(float64(n))
+}
+
+// SelectMemberFilterNegatives satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectMemberFilterNegatives(n int) {
+	i.selectMemberFilterNegativesCount.Add(float64(n))
+}
+
+// SelectMemberFilterFalsePositives satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectMemberFilterFalsePositives(n int) {
+	i.selectMemberFilterFalsePositivesCount.Add(float64(n))
+}
+
+// SelectClusterHealth satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectClusterHealth(index int, latency time.Duration, errorRate float64) {
+	cluster := strconv.Itoa(index)
+	i.selectClusterLatencyGauge.WithLabelValues(cluster).Set(float64(latency.Nanoseconds()))
+	i.selectClusterErrorRateGauge.WithLabelValues(cluster).Set(errorRate)
+}
+
+// SelectClusterQueueDepth satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectClusterQueueDepth(index, n int) {
+	i.selectClusterQueueDepthGauge.WithLabelValues(strconv.Itoa(index)).Set(float64(n))
+}
+
+// SelectClusterOverloaded satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) SelectClusterOverloaded(index int) {
+	i.selectClusterOverloadedCount.WithLabelValues(strconv.Itoa(index)).Inc()
+}
+
 // DeleteCall satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) DeleteCall() {
 	i.deleteCallCount.Inc()
@@ -396,7 +680,57 @@ func (i PrometheusInstrumentation) RepairWriteFailure(n int) {
 	i.repairWriteFailureCount.Add(float64(n))
 }
 
+// RepairVerifyFailure satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) RepairVerifyFailure(n int) {
+	i.repairVerifyFailureCount.Add(float64(n))
+}
+
 // WalkKeys satisfies the Instrumentation interface.
 func (i PrometheusInstrumentation) WalkKeys(n int) {
 	i.walkKeysCount.Add(float64(n))
 }
+
+// WalkPassProgress satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) WalkPassProgress(v float64) {
+	i.walkPassProgressGauge.Set(v)
+}
+
+// WalkKeysRemaining satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) WalkKeysRemaining(n int) {
+	i.walkKeysRemainingGauge.Set(float64(n))
+}
+
+// WalkPassDuration satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) WalkPassDuration(d time.Duration) {
+	i.walkPassDuration.Observe(float64(d.Nanoseconds()))
+}
+
+// WalkPassComplete satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) WalkPassComplete() {
+	i.walkPassCompleteCount.Inc()
+}
+
+// DialSuccess satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) DialSuccess() {
+	i.dialSuccessCount.Inc()
+}
+
+// DialFailure satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) DialFailure() {
+	i.dialFailureCount.Inc()
+}
+
+// DialDNSDuration satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) DialDNSDuration(d time.Duration) {
+	i.dialDNSDuration.Observe(float64(d.Nanoseconds()))
+}
+
+// DialConnectDuration satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) DialConnectDuration(d time.Duration) {
+	i.dialConnectDuration.Observe(float64(d.Nanoseconds()))
+}
+
+// DialHandshakeDuration satisfies the Instrumentation interface.
+func (i PrometheusInstrumentation) DialHandshakeDuration(d time.Duration) {
+	i.dialHandshakeDuration.Observe(float64(d.Nanoseconds()))
+}
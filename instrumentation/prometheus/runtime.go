@@ -0,0 +1,75 @@
+package prometheus
+
+import (
+	"io/ioutil"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterRuntimeCollector exports metrics of the Go runtime and the process,
+// under the conventional go_ and process_ names, alongside the metrics of
+// the instrumentation. It's opt-in, as reading them stops the world briefly.
+func RegisterRuntimeCollector() {
+	prometheus.MustRegister(newRuntimeCollector())
+}
+
+// runtimeCollector implements prometheus.Collector.
+type runtimeCollector struct {
+	goroutines  *prometheus.Desc
+	threads     *prometheus.Desc
+	allocBytes  *prometheus.Desc
+	sysBytes    *prometheus.Desc
+	heapObjects *prometheus.Desc
+	gcCount     *prometheus.Desc
+	gcPause     *prometheus.Desc
+	startTime   *prometheus.Desc
+	openFDs     *prometheus.Desc
+}
+
+func newRuntimeCollector() *runtimeCollector {
+	return &runtimeCollector{
+		goroutines:  prometheus.NewDesc("go_goroutines", "Number of goroutines that currently exist.", nil, nil),
+		threads:     prometheus.NewDesc("go_threads", "Number of OS threads created.", nil, nil),
+		allocBytes:  prometheus.NewDesc("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", nil, nil),
+		sysBytes:    prometheus.NewDesc("go_memstats_sys_bytes", "Number of bytes obtained from the system.", nil, nil),
+		heapObjects: prometheus.NewDesc("go_memstats_heap_objects", "Number of allocated objects.", nil, nil),
+		gcCount:     prometheus.NewDesc("go_gc_cycles_total", "Number of completed GC cycles.", nil, nil),
+		gcPause:     prometheus.NewDesc("go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", nil, nil),
+		startTime:   prometheus.NewDesc("process_start_time_seconds", "Start time of the process since the Unix epoch in seconds.", nil, nil),
+		openFDs:     prometheus.NewDesc("process_open_fds", "Number of open file descriptors.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *runtimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.goroutines
+	ch <- c.threads
+	ch <- c.allocBytes
+	ch <- c.sysBytes
+	ch <- c.heapObjects
+	ch <- c.gcCount
+	ch <- c.gcPause
+	ch <- c.startTime
+	ch <- c.openFDs
+}
+
+// Collect implements prometheus.Collector. The number of open file
+// descriptors is only reported where /proc is available.
+func (c *runtimeCollector) Collect(ch chan<- prometheus.Metric) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	threads, _ := runtime.ThreadCreateProfile(nil)
+
+	ch <- prometheus.MustNewConstMetric(c.goroutines, prometheus.GaugeValue, float64(runtime.NumGoroutine()))
+	ch <- prometheus.MustNewConstMetric(c.threads, prometheus.GaugeValue, float64(threads))
+	ch <- prometheus.MustNewConstMetric(c.allocBytes, prometheus.GaugeValue, float64(m.Alloc))
+	ch <- prometheus.MustNewConstMetric(c.sysBytes, prometheus.GaugeValue, float64(m.Sys))
+	ch <- prometheus.MustNewConstMetric(c.heapObjects, prometheus.GaugeValue, float64(m.HeapObjects))
+	ch <- prometheus.MustNewConstMetric(c.gcCount, prometheus.CounterValue, float64(m.NumGC))
+	ch <- prometheus.MustNewConstMetric(c.gcPause, prometheus.CounterValue, float64(m.PauseTotalNs)/1e9)
+	ch <- prometheus.MustNewConstMetric(c.startTime, prometheus.GaugeValue, float64(created.UnixNano())/1e9)
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.openFDs, prometheus.GaugeValue, float64(len(fds)))
+	}
+}
@@ -0,0 +1,123 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// Satisfaction guaranteed.
+var _ instrumentation.InstrumentationV2 = PrometheusInstrumentationV2{}
+
+// labeledSubsystem is the subsystem of every metric of
+// PrometheusInstrumentationV2, so that their names never collide with those of
+// PrometheusInstrumentation in the same namespace.
+const labeledSubsystem = "labeled"
+
+// labelNames are the Prometheus labels of every metric, corresponding to the
+// fields of instrumentation.Labels.
+var labelNames = []string{"cluster", "key_prefix"}
+
+// PrometheusInstrumentationV2 exports metrics with labels. Metrics are
+// registered the first time they're used, in the "labeled" subsystem. Counts
+// are exported as e.g. "labeled_insert_call_count", observations as
+// "labeled_insert_call_duration_nanoseconds", and gauges with just the
+// subsystem added to their name. It may be used alongside a
+// PrometheusInstrumentation with the same prefix.
+type PrometheusInstrumentationV2 struct {
+	prefix        string
+	maxSummaryAge time.Duration
+	registerer    registerer
+	mtx           *sync.Mutex
+	collectors    map[string]prometheus.Collector
+}
+
+// NewV2 returns a new PrometheusInstrumentationV2.
+func NewV2(prefix string, maxSummaryAge time.Duration) PrometheusInstrumentationV2 {
+	return newV2(prefix, maxSummaryAge, defaultRegistry{})
+}
+
+// newV2 is NewV2, registering the metrics with the registerer.
+func newV2(prefix string, maxSummaryAge time.Duration, r registerer) PrometheusInstrumentationV2 {
+	return PrometheusInstrumentationV2{
+		prefix:        prefix,
+		maxSummaryAge: maxSummaryAge,
+		registerer:    r,
+		mtx:           &sync.Mutex{},
+		collectors:    map[string]prometheus.Collector{},
+	}
+}
+
+// Install installs the Prometheus handlers, so the metrics are available.
+func (i PrometheusInstrumentationV2) Install(pattern string, mux *http.ServeMux) {
+	mux.Handle(pattern, Handler())
+}
+
+// Count satisfies the InstrumentationV2 interface.
+func (i PrometheusInstrumentationV2) Count(_ context.Context, name string, n int, labels instrumentation.Labels) {
+	fullName := metricName(name) + "_count"
+	i.get(fullName, func() prometheus.Collector {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: i.prefix,
+			Subsystem: labeledSubsystem,
+			Name:      fullName,
+			Help:      "Count of " + name + ".",
+		}, labelNames)
+	}).(*prometheus.CounterVec).WithLabelValues(labelValues(labels)...).Add(float64(n))
+}
+
+// Observe satisfies the InstrumentationV2 interface.
+func (i PrometheusInstrumentationV2) Observe(_ context.Context, name string, d time.Duration, labels instrumentation.Labels) {
+	fullName := metricName(name) + "_nanoseconds"
+	i.get(fullName, func() prometheus.Collector {
+		return prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace: i.prefix,
+			Subsystem: labeledSubsystem,
+			Name:      fullName,
+			Help:      "Distribution of " + name + ".",
+			MaxAge:    i.maxSummaryAge,
+		}, labelNames)
+	}).(*prometheus.SummaryVec).WithLabelValues(labelValues(labels)...).Observe(float64(d.Nanoseconds()))
+}
+
+// Gauge satisfies the InstrumentationV2 interface.
+func (i PrometheusInstrumentationV2) Gauge(_ context.Context, name string, value float64, labels instrumentation.Labels) {
+	fullName := metricName(name)
+	i.get(fullName, func() prometheus.Collector {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: i.prefix,
+			Subsystem: labeledSubsystem,
+			Name:      fullName,
+			Help:      "Current value of " + name + ".",
+		}, labelNames)
+	}).(*prometheus.GaugeVec).WithLabelValues(labelValues(labels)...).Set(value)
+}
+
+// get returns the collector with the full name, creating and registering it
+// if necessary.
+func (i PrometheusInstrumentationV2) get(fullName string, create func() prometheus.Collector) prometheus.Collector {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	c, ok := i.collectors[fullName]
+	if !ok {
+		var err error
+		if c, err = i.registerer.RegisterOrGet(create()); err != nil {
+			panic(err)
+		}
+		i.collectors[fullName] = c
+	}
+	return c
+}
+
+func metricName(name string) string {
+	return strings.Replace(name, ".", "_", -1)
+}
+
+func labelValues(labels instrumentation.Labels) []string {
+	return []string{labels.Cluster, labels.KeyPrefix}
+}
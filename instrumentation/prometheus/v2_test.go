@@ -0,0 +1,176 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/text"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func TestV2AlongsideV1(t *testing.T) {
+	// Both register their metrics under the same prefix; a collision panics.
+	var (
+		r  = newTestRegistry()
+		v1 = newInstrumentation("v2_test", time.Minute, r)
+		v2 = newV2("v2_test", time.Minute, r)
+	)
+	v1.InsertCall()
+	v2.Count(context.Background(), "insert.call", 3, instrumentation.Labels{KeyPrefix: "user"})
+
+	exposition, err := r.text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"v2_test_insert_call_count 1\n",
+		`v2_test_labeled_insert_call_count{cluster="",key_prefix="user"} 3`,
+	} {
+		if !strings.Contains(exposition, expected) {
+			t.Errorf("expected %q in\n%s", expected, exposition)
+		}
+	}
+}
+
+// testRegistry is a private registry, so that tests don't register with the
+// default registry, and can be repeated. Like the default registry, it
+// rejects collectors whose metric names are taken by another collector.
+type testRegistry struct {
+	mtx        sync.Mutex
+	byName     map[string]prometheus.Collector
+	collectors []prometheus.Collector
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{byName: map[string]prometheus.Collector{}}
+}
+
+func (r *testRegistry) Register(c prometheus.Collector) (prometheus.Collector, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	names, err := metricNames(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if _, ok := r.byName[name]; ok {
+			return nil, fmt.Errorf("metric %s already registered", name)
+		}
+	}
+	for _, name := range names {
+		r.byName[name] = c
+	}
+	r.collectors = append(r.collectors, c)
+	return c, nil
+}
+
+func (r *testRegistry) RegisterOrGet(c prometheus.Collector) (prometheus.Collector, error) {
+	names, err := metricNames(c)
+	if err != nil {
+		return nil, err
+	}
+	r.mtx.Lock()
+	existing, ok := r.byName[names[0]]
+	r.mtx.Unlock()
+	if ok {
+		return existing, nil
+	}
+	return r.Register(c)
+}
+
+// text returns the metrics of the registered collectors in the text
+// exposition format.
+func (r *testRegistry) text() (string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	families := map[string]*dto.MetricFamily{}
+	for _, c := range r.collectors {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			c.Collect(ch)
+			close(ch)
+		}()
+		for m := range ch {
+			name, err := fqName(m.Desc())
+			if err != nil {
+				return "", err
+			}
+			metric := &dto.Metric{}
+			m.Write(metric)
+			family, ok := families[name]
+			if !ok {
+				family = &dto.MetricFamily{Name: proto.String(name), Help: proto.String(name), Type: metricType(metric)}
+				families[name] = family
+			}
+			family.Metric = append(family.Metric, metric)
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		if _, err := text.MetricFamilyToText(&buf, families[name]); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// metricNames returns the names of the metrics of the collector.
+func metricNames(c prometheus.Collector) ([]string, error) {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	var (
+		names []string
+		err   error
+	)
+	for desc := range ch {
+		name, e := fqName(desc)
+		if e != nil {
+			err = e
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, err
+}
+
+// fqName returns the name of the metric of the descriptor, which it only
+// reveals in its string form.
+func fqName(desc *prometheus.Desc) (string, error) {
+	var name string
+	if _, err := fmt.Sscanf(desc.String(), "Desc{fqName: %q", &name); err != nil {
+		return "", fmt.Errorf("%s: %s", desc, err)
+	}
+	return name, nil
+}
+
+func metricType(m *dto.Metric) *dto.MetricType {
+	switch {
+	case m.Counter != nil:
+		return dto.MetricType_COUNTER.Enum()
+	case m.Gauge != nil:
+		return dto.MetricType_GAUGE.Enum()
+	case m.Summary != nil:
+		return dto.MetricType_SUMMARY.Enum()
+	default:
+		return dto.MetricType_UNTYPED.Enum()
+	}
+}
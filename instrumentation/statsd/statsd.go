@@ -2,6 +2,7 @@
 package statsd
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/peterbourgon/g2s"
@@ -48,6 +49,18 @@ func (i statsdInstrumentation) InsertQuorumFailure() {
 	i.statter.Counter(i.sampleRate, i.prefix+"insert.quorum_failure.count", 1)
 }
 
+func (i statsdInstrumentation) InsertMemberTooLarge(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"insert.member_too_large.count", n)
+}
+
+func (i statsdInstrumentation) InsertScoreSkewed(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"insert.score_skewed.count", n)
+}
+
+func (i statsdInstrumentation) InsertLocalDropped(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"insert.local_dropped.count", n)
+}
+
 func (i statsdInstrumentation) SelectCall() {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.call.count", 1)
 }
@@ -92,6 +105,14 @@ func (i statsdInstrumentation) SelectSendAllPromotion() {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.send_all_promotion.count", 1)
 }
 
+func (i statsdInstrumentation) SelectZoneFallback(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.zone_fallback.count", n)
+}
+
+func (i statsdInstrumentation) SelectDigestMismatch(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.digest_mismatch.count", n)
+}
+
 func (i statsdInstrumentation) SelectRetrieved(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.retrieved.count", n)
 }
@@ -100,10 +121,48 @@ func (i statsdInstrumentation) SelectReturned(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.returned.count", n)
 }
 
+func (i statsdInstrumentation) SelectReadAmplification(strategy string, ratio float64) {
+	i.statter.Gauge(i.sampleRate, i.prefix+"select.read_amplification."+strategy, strconv.FormatFloat(ratio, 'f', -1, 64))
+}
+
 func (i statsdInstrumentation) SelectRepairNeeded(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"select.repair_needed.count", n)
 }
 
+func (i statsdInstrumentation) SelectRepairExempted(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.repair_exempted.count", n)
+}
+
+func (i statsdInstrumentation) SelectCacheHits(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.cache_hits.count", n)
+}
+
+func (i statsdInstrumentation) SelectCacheMisses(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.cache_misses.count", n)
+}
+
+func (i statsdInstrumentation) SelectMemberFilterNegatives(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.member_filter_negatives", n)
+}
+
+func (i statsdInstrumentation) SelectMemberFilterFalsePositives(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.member_filter_false_positives", n)
+}
+
+func (i statsdInstrumentation) SelectClusterHealth(index int, latency time.Duration, errorRate float64) {
+	bucket := i.prefix + "select.cluster." + strconv.Itoa(index) + "."
+	i.statter.Gauge(i.sampleRate, bucket+"latency_ms", strconv.FormatInt(latency.Nanoseconds()/1e6, 10))
+	i.statter.Gauge(i.sampleRate, bucket+"error_rate", strconv.FormatFloat(errorRate, 'f', -1, 64))
+}
+
+func (i statsdInstrumentation) SelectClusterQueueDepth(index, n int) {
+	i.statter.Gauge(i.sampleRate, i.prefix+"select.cluster."+strconv.Itoa(index)+".queue_depth", strconv.Itoa(n))
+}
+
+func (i statsdInstrumentation) SelectClusterOverloaded(index int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"select.cluster."+strconv.Itoa(index)+".overloaded.count", 1)
+}
+
 func (i statsdInstrumentation) DeleteCall() {
 	i.statter.Counter(i.sampleRate, i.prefix+"delete.call.count", 1)
 }
@@ -144,6 +203,46 @@ func (i statsdInstrumentation) RepairWriteFailure(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"repair.write_failure.count", n)
 }
 
+func (i statsdInstrumentation) RepairVerifyFailure(n int) {
+	i.statter.Counter(i.sampleRate, i.prefix+"repair.verify_failure.count", n)
+}
+
 func (i statsdInstrumentation) WalkKeys(n int) {
 	i.statter.Counter(i.sampleRate, i.prefix+"walk.keys.count", n)
 }
+
+func (i statsdInstrumentation) WalkPassProgress(v float64) {
+	i.statter.Gauge(i.sampleRate, i.prefix+"walk.pass.progress", strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+func (i statsdInstrumentation) WalkKeysRemaining(n int) {
+	i.statter.Gauge(i.sampleRate, i.prefix+"walk.keys.remaining", strconv.Itoa(n))
+}
+
+func (i statsdInstrumentation) WalkPassDuration(d time.Duration) {
+	i.statter.Timing(i.sampleRate, i.prefix+"walk.pass.duration", d)
+}
+
+func (i statsdInstrumentation) WalkPassComplete() {
+	i.statter.Counter(i.sampleRate, i.prefix+"walk.pass.complete", 1)
+}
+
+func (i statsdInstrumentation) DialSuccess() {
+	i.statter.Counter(i.sampleRate, i.prefix+"dial.success", 1)
+}
+
+func (i statsdInstrumentation) DialFailure() {
+	i.statter.Counter(i.sampleRate, i.prefix+"dial.failure", 1)
+}
+
+func (i statsdInstrumentation) DialDNSDuration(d time.Duration) {
+	i.statter.Timing(i.sampleRate, i.prefix+"dial.dns.duration", d)
+}
+
+func (i statsdInstrumentation) DialConnectDuration(d time.Duration) {
+	i.statter.Timing(i.sampleRate, i.prefix+"dial.connect.duration", d)
+}
+
+func (i statsdInstrumentation) DialHandshakeDuration(d time.Duration) {
+	i.statter.Timing(i.sampleRate, i.prefix+"dial.handshake.duration", d)
+}
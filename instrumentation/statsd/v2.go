@@ -0,0 +1,54 @@
+package statsd
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/peterbourgon/g2s"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// Satisfaction guaranteed.
+var _ instrumentation.InstrumentationV2 = statsdInstrumentationV2{}
+
+type statsdInstrumentationV2 struct {
+	statter    g2s.Statter
+	sampleRate float32
+	prefix     string
+}
+
+// NewV2 returns a new InstrumentationV2 that forwards metrics to statsd.
+// Statsd has no notion of labels, so they're encoded in the bucket name,
+// which takes the form e.g. "insert.record.cluster.0.count", and is prefixed
+// with the common bucketPrefix.
+func NewV2(statter g2s.Statter, sampleRate float32, bucketPrefix string) instrumentation.InstrumentationV2 {
+	return statsdInstrumentationV2{
+		statter:    statter,
+		sampleRate: sampleRate,
+		prefix:     bucketPrefix,
+	}
+}
+
+func (i statsdInstrumentationV2) Count(_ context.Context, name string, n int, labels instrumentation.Labels) {
+	i.statter.Counter(i.sampleRate, i.bucket(name, labels)+".count", n)
+}
+
+func (i statsdInstrumentationV2) Observe(_ context.Context, name string, d time.Duration, labels instrumentation.Labels) {
+	i.statter.Timing(i.sampleRate, i.bucket(name, labels)+"_ms", d)
+}
+
+func (i statsdInstrumentationV2) Gauge(_ context.Context, name string, value float64, labels instrumentation.Labels) {
+	i.statter.Gauge(i.sampleRate, i.bucket(name, labels), strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (i statsdInstrumentationV2) bucket(name string, labels instrumentation.Labels) string {
+	bucket := i.prefix + name
+	if labels.Cluster != "" {
+		bucket += ".cluster." + labels.Cluster
+	}
+	if labels.KeyPrefix != "" {
+		bucket += ".key_prefix." + labels.KeyPrefix
+	}
+	return bucket
+}
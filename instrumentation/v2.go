@@ -0,0 +1,268 @@
+package instrumentation
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// InstrumentationV2 is an instrumentation interface which can express
+// dimensionality. Rather than one method per metric, it has one method per
+// kind of metric, each taking the metric name, the context of the call, and
+// per-call Labels. Backends may use the context to correlate metrics with
+// traces, e.g. as exemplars.
+//
+// Metric names are dot-separated, like "insert.call", and don't include a
+// suffix for the kind of metric or its unit; backends add those as required.
+type InstrumentationV2 interface {
+	Count(ctx context.Context, name string, n int, labels Labels)             // +n
+	Observe(ctx context.Context, name string, d time.Duration, labels Labels) // one observation of a latency distribution
+	Gauge(ctx context.Context, name string, value float64, labels Labels)     // set to value
+}
+
+// Labels are the per-call dimensions of a metric. Empty labels are omitted.
+type Labels struct {
+	Cluster   string // e.g. the index of the cluster in the farm
+	KeyPrefix string // e.g. the portion of the key before the first separator
+}
+
+// NopInstrumentationV2 satisfies InstrumentationV2 but does nothing.
+type NopInstrumentationV2 struct{}
+
+// Count satisfies the InstrumentationV2 interface.
+func (i NopInstrumentationV2) Count(context.Context, string, int, Labels) {}
+
+// Observe satisfies the InstrumentationV2 interface.
+func (i NopInstrumentationV2) Observe(context.Context, string, time.Duration, Labels) {}
+
+// Gauge satisfies the InstrumentationV2 interface.
+func (i NopInstrumentationV2) Gauge(context.Context, string, float64, Labels) {}
+
+// V1 adapts an InstrumentationV2 to the Instrumentation interface, so it may
+// be used wherever an Instrumentation is expected. Every method becomes a
+// call with a background context, and without labels, except where the
+// method carries a dimension itself.
+func V1(i InstrumentationV2) Instrumentation { return v1Adapter{i} }
+
+// Satisfaction guaranteed.
+var _ Instrumentation = v1Adapter{}
+
+type v1Adapter struct{ InstrumentationV2 }
+
+func (a v1Adapter) InsertCall() {
+	a.Count(context.Background(), "insert.call", 1, Labels{})
+}
+
+func (a v1Adapter) InsertRecordCount(n int) {
+	a.Count(context.Background(), "insert.record", n, Labels{})
+}
+
+func (a v1Adapter) InsertCallDuration(d time.Duration) {
+	a.Observe(context.Background(), "insert.call.duration", d, Labels{})
+}
+
+func (a v1Adapter) InsertRecordDuration(d time.Duration) {
+	a.Observe(context.Background(), "insert.record.duration", d, Labels{})
+}
+
+func (a v1Adapter) InsertQuorumFailure() {
+	a.Count(context.Background(), "insert.quorum_failure", 1, Labels{})
+}
+
+func (a v1Adapter) InsertMemberTooLarge(n int) {
+	a.Count(context.Background(), "insert.member_too_large", n, Labels{})
+}
+
+func (a v1Adapter) InsertScoreSkewed(n int) {
+	a.Count(context.Background(), "insert.score_skewed", n, Labels{})
+}
+
+func (a v1Adapter) InsertLocalDropped(n int) {
+	a.Count(context.Background(), "insert.local_dropped", n, Labels{})
+}
+
+func (a v1Adapter) SelectCall() {
+	a.Count(context.Background(), "select.call", 1, Labels{})
+}
+
+func (a v1Adapter) SelectKeys(n int) {
+	a.Count(context.Background(), "select.keys", n, Labels{})
+}
+
+func (a v1Adapter) SelectSendTo(n int) {
+	a.Count(context.Background(), "select.send_to", n, Labels{})
+}
+
+func (a v1Adapter) SelectFirstResponseDuration(d time.Duration) {
+	a.Observe(context.Background(), "select.first_response.duration", d, Labels{})
+}
+
+func (a v1Adapter) SelectPartialError() {
+	a.Count(context.Background(), "select.partial_error", 1, Labels{})
+}
+
+func (a v1Adapter) SelectBlockingDuration(d time.Duration) {
+	a.Observe(context.Background(), "select.blocking.duration", d, Labels{})
+}
+
+func (a v1Adapter) SelectOverheadDuration(d time.Duration) {
+	a.Observe(context.Background(), "select.overhead.duration", d, Labels{})
+}
+
+func (a v1Adapter) SelectDuration(d time.Duration) {
+	a.Observe(context.Background(), "select.duration", d, Labels{})
+}
+
+func (a v1Adapter) SelectSendAllPermitGranted() {
+	a.Count(context.Background(), "select.send_all_permit_granted", 1, Labels{})
+}
+
+func (a v1Adapter) SelectSendAllPermitRejected() {
+	a.Count(context.Background(), "select.send_all_permit_rejected", 1, Labels{})
+}
+
+func (a v1Adapter) SelectSendAllPromotion() {
+	a.Count(context.Background(), "select.send_all_promotion", 1, Labels{})
+}
+
+func (a v1Adapter) SelectZoneFallback(n int) {
+	a.Count(context.Background(), "select.zone_fallback", n, Labels{})
+}
+
+func (a v1Adapter) SelectDigestMismatch(n int) {
+	a.Count(context.Background(), "select.digest_mismatch", n, Labels{})
+}
+
+func (a v1Adapter) SelectRetrieved(n int) {
+	a.Count(context.Background(), "select.retrieved", n, Labels{})
+}
+
+func (a v1Adapter) SelectReturned(n int) {
+	a.Count(context.Background(), "select.returned", n, Labels{})
+}
+
+func (a v1Adapter) SelectReadAmplification(strategy string, ratio float64) {
+	a.Gauge(context.Background(), "select.read_amplification."+strategy, ratio, Labels{})
+}
+
+func (a v1Adapter) SelectRepairNeeded(n int) {
+	a.Count(context.Background(), "select.repair_needed", n, Labels{})
+}
+
+func (a v1Adapter) SelectRepairExempted(n int) {
+	a.Count(context.Background(), "select.repair_exempted", n, Labels{})
+}
+
+func (a v1Adapter) SelectCacheHits(n int) {
+	a.Count(context.Background(), "select.cache_hits", n, Labels{})
+}
+
+func (a v1Adapter) SelectCacheMisses(n int) {
+	a.Count(context.Background(), "select.cache_misses", n, Labels{})
+}
+
+func (a v1Adapter) SelectMemberFilterNegatives(n int) {
+	a.Count(context.Background(), "select.member_filter_negatives", n, Labels{})
+}
+
+func (a v1Adapter) SelectMemberFilterFalsePositives(n int) {
+	a.Count(context.Background(), "select.member_filter_false_positives", n, Labels{})
+}
+
+func (a v1Adapter) SelectClusterHealth(index int, latency time.Duration, errorRate float64) {
+	labels := Labels{Cluster: strconv.Itoa(index)}
+	a.Gauge(context.Background(), "select.cluster.latency_nanoseconds", float64(latency.Nanoseconds()), labels)
+	a.Gauge(context.Background(), "select.cluster.error_rate", errorRate, labels)
+}
+
+func (a v1Adapter) SelectClusterQueueDepth(index, n int) {
+	a.Gauge(context.Background(), "select.cluster.queue_depth", float64(n), Labels{Cluster: strconv.Itoa(index)})
+}
+
+func (a v1Adapter) SelectClusterOverloaded(index int) {
+	a.Count(context.Background(), "select.cluster.overloaded", 1, Labels{Cluster: strconv.Itoa(index)})
+}
+
+func (a v1Adapter) DeleteCall() {
+	a.Count(context.Background(), "delete.call", 1, Labels{})
+}
+
+func (a v1Adapter) DeleteRecordCount(n int) {
+	a.Count(context.Background(), "delete.record", n, Labels{})
+}
+
+func (a v1Adapter) DeleteCallDuration(d time.Duration) {
+	a.Observe(context.Background(), "delete.call.duration", d, Labels{})
+}
+
+func (a v1Adapter) DeleteRecordDuration(d time.Duration) {
+	a.Observe(context.Background(), "delete.record.duration", d, Labels{})
+}
+
+func (a v1Adapter) DeleteQuorumFailure() {
+	a.Count(context.Background(), "delete.quorum_failure", 1, Labels{})
+}
+
+func (a v1Adapter) RepairCall() {
+	a.Count(context.Background(), "repair.call", 1, Labels{})
+}
+
+func (a v1Adapter) RepairRequest(n int) {
+	a.Count(context.Background(), "repair.request", n, Labels{})
+}
+
+func (a v1Adapter) RepairDiscarded(n int) {
+	a.Count(context.Background(), "repair.discarded", n, Labels{})
+}
+
+func (a v1Adapter) RepairWriteSuccess(n int) {
+	a.Count(context.Background(), "repair.write_success", n, Labels{})
+}
+
+func (a v1Adapter) RepairWriteFailure(n int) {
+	a.Count(context.Background(), "repair.write_failure", n, Labels{})
+}
+
+func (a v1Adapter) RepairVerifyFailure(n int) {
+	a.Count(context.Background(), "repair.verify_failure", n, Labels{})
+}
+
+func (a v1Adapter) WalkKeys(n int) {
+	a.Count(context.Background(), "walk.keys", n, Labels{})
+}
+
+func (a v1Adapter) WalkPassProgress(v float64) {
+	a.Gauge(context.Background(), "walk.pass.progress", v, Labels{})
+}
+
+func (a v1Adapter) WalkKeysRemaining(n int) {
+	a.Gauge(context.Background(), "walk.keys.remaining", float64(n), Labels{})
+}
+
+func (a v1Adapter) WalkPassDuration(d time.Duration) {
+	a.Observe(context.Background(), "walk.pass.duration", d, Labels{})
+}
+
+func (a v1Adapter) WalkPassComplete() {
+	a.Count(context.Background(), "walk.pass.complete", 1, Labels{})
+}
+
+func (a v1Adapter) DialSuccess() {
+	a.Count(context.Background(), "dial.success", 1, Labels{})
+}
+
+func (a v1Adapter) DialFailure() {
+	a.Count(context.Background(), "dial.failure", 1, Labels{})
+}
+
+func (a v1Adapter) DialDNSDuration(d time.Duration) {
+	a.Observe(context.Background(), "dial.dns.duration", d, Labels{})
+}
+
+func (a v1Adapter) DialConnectDuration(d time.Duration) {
+	a.Observe(context.Background(), "dial.connect.duration", d, Labels{})
+}
+
+func (a v1Adapter) DialHandshakeDuration(d time.Duration) {
+	a.Observe(context.Background(), "dial.handshake.duration", d, Labels{})
+}
@@ -1,10 +1,15 @@
 package pool
 
 import (
+	"context"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/instrumentation"
 )
 
 type connectionPool struct {
@@ -19,6 +24,10 @@ type connectionPool struct {
 	available   []redis.Conn
 	outstanding int
 	max         int
+
+	tracker *tracker     // nil unless tracking is enabled
+	mux     *multiplexer // nil unless multiplexing is enabled
+	instr   instrumentation.DialInstrumentation
 }
 
 func newConnectionPool(
@@ -40,16 +49,44 @@ func newConnectionPool(
 		available:   []redis.Conn{},
 		outstanding: 0,
 		max:         maxConnections,
+
+		instr: instrumentation.NopInstrumentation{},
 	}
 }
 
+// get returns an available connection, or dials a new one. If every
+// connection is in use, it waits up to the connect timeout for one to be put
+// back, and then returns an ExhaustedError. Callers must put the connection
+// back, even if it's nil, unless the error is an ExhaustedError. If the pool
+// is multiplexed, the connection is a view of the multiplexed connection.
 func (p *connectionPool) get() (redis.Conn, error) {
+	if p.mux != nil {
+		return p.mux.conn(), nil
+	}
+	var (
+		began   time.Time
+		expired bool
+	)
 	p.mu.Lock()
 	for {
 		available := len(p.available)
 		switch {
 		case available <= 0 && p.outstanding >= p.max:
 			// Worst case. No connection available, and we can't dial a new one.
+			if expired {
+				p.mu.Unlock()
+				return nil, ExhaustedError{Address: p.address, Wait: time.Since(began)}
+			}
+			if began.IsZero() && p.connect > 0 {
+				began = time.Now()
+				timer := time.AfterFunc(p.connect, func() {
+					p.mu.Lock()
+					defer p.mu.Unlock()
+					expired = true
+					p.co.Broadcast()
+				})
+				defer timer.Stop()
+			}
 			p.co.Wait() // TODO starvation is possible here
 
 		case available <= 0 && p.outstanding < p.max:
@@ -64,12 +101,16 @@ func (p *connectionPool) get() (redis.Conn, error) {
 			// if it is nil. put() must handle that circumstance.
 			p.outstanding++
 			p.mu.Unlock()
-			return redis.DialTimeout("tcp", p.address, p.connect, p.read, p.write)
+			return p.dial()
 
 		case available > 0:
 			// Best case. We can directly use an available connection.
 			var conn redis.Conn
 			conn, p.available = p.available[0], p.available[1:]
+			if p.tracker != nil && !p.tracker.current(conn) {
+				go conn.Close() // no longer tracked
+				continue
+			}
 			if p.outstanding < p.max {
 				p.outstanding++
 			}
@@ -80,9 +121,17 @@ func (p *connectionPool) get() (redis.Conn, error) {
 }
 
 func (p *connectionPool) put(conn redis.Conn) {
+	if p.mux != nil {
+		return // views aren't pooled
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if conn != nil && p.tracker != nil && !p.tracker.current(conn) {
+		go conn.Close() // no longer tracked; reject it like a failed conn
+		conn = nil
+	}
+
 	if conn == nil || conn.Err() != nil {
 		// Failed to dial, closed, or some other problem
 		if p.outstanding > 0 {
@@ -104,7 +153,77 @@ func (p *connectionPool) put(conn redis.Conn) {
 	p.co.Signal()
 }
 
+func (p *connectionPool) dial() (redis.Conn, error) {
+	netConn, err := p.dialNet()
+	if err != nil {
+		p.instr.DialFailure()
+		return nil, err
+	}
+	conn := redis.NewConn(netConn, p.read, p.write)
+	if p.tracker == nil {
+		p.instr.DialSuccess()
+		return conn, nil
+	}
+	began := time.Now()
+	tracked, err := p.tracker.track(conn)
+	p.instr.DialHandshakeDuration(time.Since(began))
+	if err != nil {
+		p.instr.DialFailure()
+		conn.Close()
+		return nil, err
+	}
+	p.instr.DialSuccess()
+	return tracked, nil
+}
+
+// dialNet establishes the connection to the address, within the connect
+// timeout, and reports the time spent resolving its host name and
+// connecting separately. Resolved addresses are tried in order.
+func (p *connectionPool) dialNet() (net.Conn, error) {
+	network := network(p.address)
+	ctx := context.Background()
+	if p.connect > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.connect)
+		defer cancel()
+	}
+	dialer := &net.Dialer{}
+	if network != "tcp" {
+		began := time.Now()
+		conn, err := dialer.DialContext(ctx, network, p.address)
+		p.instr.DialConnectDuration(time.Since(began))
+		return conn, err
+	}
+
+	host, port, err := net.SplitHostPort(p.address)
+	if err != nil {
+		return nil, err
+	}
+	addrs := []string{host}
+	if net.ParseIP(host) == nil {
+		began := time.Now()
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+		p.instr.DialDNSDuration(time.Since(began))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	began := time.Now()
+	defer func() { p.instr.DialConnectDuration(time.Since(began)) }()
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
 func (p *connectionPool) closeAll() error {
+	if p.mux != nil {
+		p.mux.closeAll()
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	for _, conn := range p.available {
@@ -113,3 +232,12 @@ func (p *connectionPool) closeAll() error {
 	p.available = []redis.Conn{}
 	return nil
 }
+
+// network returns the network to dial for the address. Absolute paths are
+// Unix domain sockets; everything else is a TCP host:port.
+func network(address string) string {
+	if strings.HasPrefix(address, "/") {
+		return "unix"
+	}
+	return "tcp"
+}
@@ -1,6 +1,7 @@
 package pool
 
 import (
+	"errors"
 	"io/ioutil"
 	"log"
 	"math"
@@ -57,3 +58,41 @@ func TestMemoryRegression(t *testing.T) {
 		t.Errorf("HeapAlloc ∆ was %d", delta)
 	}
 }
+
+func TestExhausted(t *testing.T) {
+	timeout := 20 * time.Millisecond
+	p := newConnectionPool("127.0.0.1:54321", timeout, timeout, timeout, 1)
+	p.outstanding = p.max // every connection is in use
+
+	began := time.Now()
+	conn, err := p.get()
+	if conn != nil {
+		t.Errorf("expected no connection, got %v", conn)
+	}
+	exhausted, ok := err.(ExhaustedError)
+	if !ok {
+		t.Fatalf("expected ExhaustedError, got %T (%v)", err, err)
+	}
+	if d := time.Since(began); d < timeout || exhausted.Wait < timeout {
+		t.Errorf("expected to wait for %s, waited %s (reported %s)", timeout, d, exhausted.Wait)
+	}
+	if expected, got := p.max, p.outstanding; expected != got {
+		t.Errorf("expected %d outstanding connection(s), got %d", expected, got)
+	}
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	err := classify("localhost:6379", timeoutErr{})
+	if expected, got := (TimeoutError{Address: "localhost:6379", Err: timeoutErr{}}), err; expected != got {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+	if other := errors.New("ERR wrong number of arguments"); classify("localhost:6379", other) != other {
+		t.Errorf("expected other errors to be returned unmodified")
+	}
+}
@@ -0,0 +1,53 @@
+package pool
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// ExhaustedError is returned by WithIndex and With when every connection to
+// the Redis instance stayed in use for the connect timeout, so none could be
+// obtained. The instance may be healthy, but overloaded.
+type ExhaustedError struct {
+	Address string        // of the Redis instance
+	Wait    time.Duration // how long a connection was waited for
+}
+
+// Error implements the error interface.
+func (e ExhaustedError) Error() string {
+	return fmt.Sprintf("%s: no connection available after %s", e.Address, e.Wait)
+}
+
+// Temporary reports that waiting longer may yield a connection.
+func (e ExhaustedError) Temporary() bool { return true }
+
+// TimeoutError is returned by WithIndex and With when connecting to the Redis
+// instance, or a command sent to it, timed out.
+type TimeoutError struct {
+	Address string // of the Redis instance
+	Err     error  // the underlying network error
+}
+
+// Error implements the error interface.
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Address, e.Err)
+}
+
+// Unwrap returns the underlying network error.
+func (e TimeoutError) Unwrap() error { return e.Err }
+
+// Timeout reports that the error is a timeout, like net.Error.
+func (e TimeoutError) Timeout() bool { return true }
+
+// Temporary reports that the operation may succeed if retried.
+func (e TimeoutError) Temporary() bool { return true }
+
+// classify wraps network timeouts of the Redis instance as TimeoutErrors.
+// Other errors are returned unmodified.
+func classify(address string, err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return TimeoutError{Address: address, Err: err}
+	}
+	return err
+}
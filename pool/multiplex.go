@@ -0,0 +1,286 @@
+package pool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// multiplexBacklog is how many flushes may await their replies on a
+// multiplexed connection. Further flushes wait for the oldest to be read.
+const multiplexBacklog = 1024
+
+var (
+	errMultiplexClosed = errors.New("pool: multiplexed connection closed")
+	errNoPendingReply  = errors.New("pool: no pending reply")
+)
+
+// Multiplex replaces the connection pool of each Redis instance by a single
+// connection, shared by every request to the instance. Requests are written
+// to it as they arrive, without waiting for the replies of earlier ones, and
+// replies are matched to their requests by order, as Redis replies to
+// pipelined commands in the order it received them. Deployments with many
+// roshi processes then hold one connection per instance each, rather than
+// max connections per instance, sparing Redis the CPU of maintaining and
+// polling thousands of mostly idle connections.
+//
+// Every connection passed to the function of WithIndex behaves as a
+// connection of its own. The commands queued on it before a Flush, or
+// before a Do, are written together, so MULTI/EXEC transactions in a single
+// Pipeline stay intact; commands which rely on the state of a connection
+// across round trips, like WATCH or SUBSCRIBE, mustn't be used. A slow reply
+// delays the replies of every request after it, and a failed connection
+// fails every request awaiting a reply on it. The connection is dialed again
+// on the next request. Max connections per instance is ignored, and requests
+// never fail with an ExhaustedError.
+//
+// Call Multiplex before the pool is used. Instrument and Track apply to the
+// multiplexed connections as to pooled ones.
+func (p *Pool) Multiplex() {
+	for _, connections := range p.connections {
+		connections.mux = &multiplexer{pool: connections}
+	}
+}
+
+// multiplexer maintains the multiplexed connection to a single Redis
+// instance, dialing it on first use, and again whenever it failed.
+type multiplexer struct {
+	pool *connectionPool // to dial
+
+	mu     sync.Mutex // serializes writes, so their replies are read in order
+	shared *sharedConn
+}
+
+// sharedConn is a connection shared by every request to an instance. Its
+// replies are read by its own goroutine, and delivered in order to the
+// flushes awaiting them.
+type sharedConn struct {
+	conn    redis.Conn
+	pending chan *muxFlush // closed once the connection is replaced
+}
+
+// muxFlush is the commands written by a single flush, whose replies are
+// delivered to its channel, which has room for all of them. It's immutable.
+type muxFlush struct {
+	n       int
+	replies chan muxReply
+}
+
+type muxReply struct {
+	reply interface{}
+	err   error
+}
+
+// muxCommand is a queued command, with its arguments encoded as redigo
+// encodes them, so that callers may reuse them.
+type muxCommand struct {
+	name string
+	args []interface{}
+}
+
+// conn returns a connection for a single request.
+func (m *multiplexer) conn() redis.Conn {
+	return &muxConn{mux: m}
+}
+
+// write writes the commands to the multiplexed connection, dialing it if
+// necessary, and returns the flush whose replies are read for them.
+func (m *multiplexer) write(commands []muxCommand) (*muxFlush, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.shared
+	if s == nil || s.conn.Err() != nil || (m.pool.tracker != nil && !m.pool.tracker.current(s.conn)) {
+		m.close()
+		conn, err := m.pool.dial()
+		if err != nil {
+			return nil, err
+		}
+		s = &sharedConn{conn: conn, pending: make(chan *muxFlush, multiplexBacklog)}
+		go s.receive(m.pool.address)
+		m.shared = s
+	}
+
+	for _, command := range commands {
+		if err := s.conn.Send(command.name, command.args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.conn.Flush(); err != nil {
+		return nil, err
+	}
+	flush := &muxFlush{n: len(commands), replies: make(chan muxReply, len(commands))}
+	s.pending <- flush // blocks while the backlog is full
+	return flush, nil
+}
+
+// close closes the multiplexed connection, if any. Flushes awaiting their
+// replies receive errors. The caller must hold the lock.
+func (m *multiplexer) close() {
+	if m.shared == nil {
+		return
+	}
+	m.shared.conn.Close()
+	close(m.shared.pending)
+	m.shared = nil
+}
+
+func (m *multiplexer) closeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.close()
+}
+
+// receive reads the replies of each flush, in order, until the connection
+// is replaced. Once reading failed, every remaining reply is the error.
+func (s *sharedConn) receive(address string) {
+	var failed error
+	for flush := range s.pending {
+		for i := 0; i < flush.n; i++ {
+			if failed != nil {
+				flush.replies <- muxReply{err: failed}
+				continue
+			}
+			reply, err := s.conn.Receive()
+			if _, ok := err.(redis.Error); err != nil && !ok {
+				log.Printf("pool: %s: multiplexed connection lost: %s", address, err)
+				failed = err
+			}
+			flush.replies <- muxReply{reply: reply, err: err}
+		}
+	}
+}
+
+// muxConn is the connection of a single request over a multiplexed
+// connection. It implements redis.Conn, but isn't safe for concurrent use.
+type muxConn struct {
+	mux      *multiplexer
+	queued   []muxCommand
+	flushes  []*muxFlush // awaiting replies, oldest first
+	received int         // replies of the oldest flush
+	err      error       // fatal
+}
+
+func (c *muxConn) Close() error {
+	if c.err == nil {
+		c.err = errMultiplexClosed
+	}
+	return nil
+}
+
+func (c *muxConn) Err() error {
+	return c.err
+}
+
+func (c *muxConn) Send(command string, args ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	encoded := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case string, int, int64, float64, bool, nil:
+			encoded[i] = arg
+		case []byte:
+			encoded[i] = append([]byte{}, arg...)
+		default:
+			var buf bytes.Buffer
+			fmt.Fprint(&buf, arg)
+			encoded[i] = buf.Bytes()
+		}
+	}
+	c.queued = append(c.queued, muxCommand{name: command, args: encoded})
+	return nil
+}
+
+func (c *muxConn) Flush() error {
+	if c.err != nil {
+		return c.err
+	}
+	if len(c.queued) <= 0 {
+		return nil
+	}
+	flush, err := c.mux.write(c.queued)
+	c.queued = nil
+	if err != nil {
+		c.err = err
+		return err
+	}
+	c.flushes = append(c.flushes, flush)
+	return nil
+}
+
+func (c *muxConn) Receive() (interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if len(c.flushes) <= 0 {
+		return nil, errNoPendingReply
+	}
+	flush := c.flushes[0]
+	r := <-flush.replies
+	if c.received++; c.received >= flush.n {
+		c.flushes, c.received = c.flushes[1:], 0
+	}
+	if _, ok := r.err.(redis.Error); r.err != nil && !ok {
+		c.err = r.err
+	}
+	return r.reply, r.err
+}
+
+// Do behaves like the Do of redigo's connections: it flushes the queued
+// commands along with the command, and returns the reply of the command,
+// and the first error of any of the replies. With an empty command, it
+// returns every pending reply.
+func (c *muxConn) Do(command string, args ...interface{}) (interface{}, error) {
+	if command != "" {
+		if err := c.Send(command, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+
+	pending := -c.received
+	for _, flush := range c.flushes {
+		pending += flush.n
+	}
+	if command == "" {
+		replies := make([]interface{}, pending)
+		for i := range replies {
+			reply, err := c.Receive()
+			if _, ok := err.(redis.Error); err != nil && !ok {
+				return nil, err
+			}
+			replies[i] = reply
+			if err != nil {
+				replies[i] = err
+			}
+		}
+		return replies, nil
+	}
+
+	var (
+		reply    interface{}
+		firstErr error
+	)
+	for i := 0; i < pending; i++ {
+		var err error
+		reply, err = c.Receive()
+		if e, ok := err.(redis.Error); ok {
+			if firstErr == nil {
+				firstErr = e
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reply, firstErr
+}
@@ -0,0 +1,120 @@
+package pool
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestMultiplex(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 10)
+	go serveEcho(ln, accepted)
+
+	p := New([]string{ln.Addr().String()}, time.Second, time.Second, time.Second, 1, Murmur3)
+	defer p.Close()
+	p.Multiplex()
+
+	// Many more concurrent requests than max connections, each receiving
+	// its own replies.
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 100)
+	)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- p.With("foo", func(c redis.Conn) error {
+				pipeline := NewPipeline(c)
+				pipeline.Queue("ECHO", fmt.Sprint(i))
+				pipeline.Queue("FAIL")
+				pipeline.Queue("ECHO", []byte(fmt.Sprint(-i)))
+				replies, err := pipeline.Exec()
+				if _, ok := err.(redis.Error); !ok {
+					return fmt.Errorf("%d: expected the error of FAIL, got %v", i, err)
+				}
+				if got, _ := redis.String(replies[0], nil); got != fmt.Sprint(i) {
+					return fmt.Errorf("%d: expected %d, got %q", i, i, got)
+				}
+				if got, _ := redis.String(replies[2], nil); got != fmt.Sprint(-i) {
+					return fmt.Errorf("%d: expected %d, got %q", i, -i, got)
+				}
+				reply, err := redis.String(c.Do("ECHO", "done"))
+				if err != nil || reply != "done" {
+					return fmt.Errorf("%d: expected done, got %q (%v)", i, reply, err)
+				}
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := len(accepted); n != 1 {
+		t.Fatalf("expected 1 connection, got %d", n)
+	}
+
+	// Losing the connection fails the requests awaiting replies, and the
+	// next request dials again.
+	(<-accepted).Close()
+	if err := p.With("foo", func(c redis.Conn) error {
+		_, err := c.Do("ECHO", "lost")
+		return err
+	}); err == nil {
+		t.Error("expected an error on the lost connection, got none")
+	}
+	if err := p.With("foo", func(c redis.Conn) error {
+		_, err := c.Do("ECHO", "again")
+		return err
+	}); err != nil {
+		t.Error(err)
+	}
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("no new connection")
+	}
+}
+
+// serveEcho serves a minimal Redis, which supports ECHO, and FAIL, which
+// always fails. Accepted connections are sent to accepted.
+func serveEcho(ln net.Listener, accepted chan<- net.Conn) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := redis.NewConn(conn, 0, time.Second)
+			for {
+				command, err := redis.Strings(r.Receive())
+				if err != nil {
+					return
+				}
+				switch {
+				case len(command) == 2 && command[0] == "ECHO":
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(command[1]), command[1])
+				case len(command) == 1 && command[0] == "FAIL":
+					fmt.Fprintf(conn, "-ERR failed\r\n")
+				default:
+					fmt.Fprintf(conn, "-ERR unknown command %v\r\n", command)
+				}
+			}
+		}(conn)
+	}
+}
@@ -0,0 +1,75 @@
+package pool
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// Pipeline queues commands for a single connection, and sends them to the
+// Redis instance in one round trip, rather than one round trip per command.
+// Begin a pipeline on the connection passed to the function of WithIndex or
+// With. A Pipeline isn't safe for concurrent use.
+type Pipeline struct {
+	conn   redis.Conn
+	queued int
+	err    error // of the first command which couldn't be queued
+}
+
+// NewPipeline begins an empty pipeline on the connection.
+func NewPipeline(conn redis.Conn) *Pipeline {
+	return &Pipeline{conn: conn}
+}
+
+// Queue adds the command to the pipeline. The arguments are encoded before
+// Queue returns, so they may be reused for the next command. Errors encoding
+// them are returned by Exec.
+func (p *Pipeline) Queue(command string, args ...interface{}) {
+	if err := p.conn.Send(command, args...); err != nil && p.err == nil {
+		p.err = err
+	}
+	p.queued++
+}
+
+// Len returns how many commands are queued.
+func (p *Pipeline) Len() int {
+	return p.queued
+}
+
+// Exec sends the queued commands, and reads a reply for each of them, in
+// order. Commands which failed have their redis.Error as their reply, which
+// the redis package's reply helpers, like redis.Values, return as errors.
+// Every reply is read, even after a command failed, so the connection may be
+// reused, and the pipeline is empty afterwards, so it may be filled again,
+// e.g. to retry. The error is the first error queueing the commands, sending
+// them, or reading their replies, or else of the first command which failed.
+// After errors other than those of commands, the connection is unusable, and
+// the replies are nil.
+func (p *Pipeline) Exec() ([]interface{}, error) {
+	queued, err := p.queued, p.err
+	p.queued, p.err = 0, nil
+	if err != nil {
+		return nil, err
+	}
+	if err := p.conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	var (
+		replies  = make([]interface{}, queued)
+		firstErr error
+	)
+	for i := range replies {
+		reply, err := p.conn.Receive()
+		if e, ok := err.(redis.Error); ok {
+			if firstErr == nil {
+				firstErr = e
+			}
+			replies[i] = e
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, firstErr
+}
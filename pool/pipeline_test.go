@@ -0,0 +1,95 @@
+package pool
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// scriptedConn is a redis.Conn which records the commands sent, and returns
+// the scripted replies in order, with no network.
+type scriptedConn struct {
+	redis.Conn // nil; other methods panic
+	sent       []string
+	flushed    int
+	replies    []interface{} // errors are returned as such
+}
+
+func (c *scriptedConn) Send(command string, args ...interface{}) error {
+	c.sent = append(c.sent, fmt.Sprint(command, args))
+	return nil
+}
+
+func (c *scriptedConn) Flush() error {
+	c.flushed++
+	return nil
+}
+
+func (c *scriptedConn) Receive() (interface{}, error) {
+	if len(c.replies) <= 0 {
+		return nil, fmt.Errorf("no more replies")
+	}
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func TestPipeline(t *testing.T) {
+	conn := &scriptedConn{replies: []interface{}{
+		int64(1),
+		redis.Error("WRONGTYPE first"),
+		[]byte("bar"),
+		redis.Error("ERR second"),
+	}}
+	p := NewPipeline(conn)
+	p.Queue("INCR", "a")
+	p.Queue("SADD", "a", "x")
+	p.Queue("GET", "foo")
+	p.Queue("BOGUS")
+	if expected, got := 4, p.Len(); expected != got {
+		t.Fatalf("expected %d queued, got %d", expected, got)
+	}
+
+	replies, err := p.Exec()
+	if expected := redis.Error("WRONGTYPE first"); err != expected {
+		t.Errorf("expected error %v, got %v", expected, err)
+	}
+	expected := []interface{}{int64(1), redis.Error("WRONGTYPE first"), []byte("bar"), redis.Error("ERR second")}
+	if !reflect.DeepEqual(expected, replies) {
+		t.Errorf("expected replies %v, got %v", expected, replies)
+	}
+	if _, err := redis.Int(replies[1], nil); err == nil {
+		t.Errorf("expected the failed command's reply to be an error")
+	}
+	if expected, got := 1, conn.flushed; expected != got {
+		t.Errorf("expected %d flush, got %d", expected, got)
+	}
+
+	// The pipeline is empty after Exec, and may be reused.
+	if expected, got := 0, p.Len(); expected != got {
+		t.Errorf("after Exec: expected %d queued, got %d", expected, got)
+	}
+	conn.replies = []interface{}{[]byte("OK")}
+	p.Queue("SET", "foo", "baz")
+	replies, err = p.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []interface{}{[]byte("OK")}; !reflect.DeepEqual(expected, replies) {
+		t.Errorf("reused: expected replies %v, got %v", expected, replies)
+	}
+	if expected := []string{"INCR[a]", "SADD[a x]", "GET[foo]", "BOGUS[]", "SET[foo baz]"}; !reflect.DeepEqual(expected, conn.sent) {
+		t.Errorf("expected commands %q, got %q", expected, conn.sent)
+	}
+
+	// Connection errors lose every reply.
+	p.Queue("GET", "foo")
+	if replies, err := p.Exec(); err == nil || replies != nil {
+		t.Errorf("no replies: expected nil replies and an error, got %v, %v", replies, err)
+	}
+}
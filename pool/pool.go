@@ -3,20 +3,25 @@ package pool
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/instrumentation"
 )
 
 // Pool maintains a connection pool for multiple Redis instances.
 type Pool struct {
 	connections []*connectionPool
+	bounds      []uint64 // cumulative weights, per instance; nil if unweighted
 	hash        func(string) uint32
 }
 
 // New creates and returns a new Pool object.
 //
-// Addresses are host:port strings for each underlying Redis instance. The
+// Addresses are host:port strings for each underlying Redis instance, or
+// absolute paths to the Unix domain sockets of co-located instances. The
 // number and order of the addresses determines the hash slots, so be careful
 // to make that deterministic.
 //
@@ -35,6 +40,61 @@ func New(
 	maxConnectionsPerInstance int,
 	hash func(string) uint32,
 ) *Pool {
+	return &Pool{
+		connections: newConnectionPools(addresses, connectTimeout, readTimeout, writeTimeout, maxConnectionsPerInstance),
+		hash:        hash,
+	}
+}
+
+// NewWeighted is like New, but each Redis instance receives a share of the
+// keyspace proportional to its weight, e.g. its memory size. Weights
+// correspond to addresses, and must be at least 1; otherwise, an error is
+// returned. A nil weights slice, or weights which are all 1, are equivalent
+// to New. As with the addresses, changing the weights moves keys between
+// instances.
+func NewWeighted(
+	addresses []string,
+	weights []int,
+	connectTimeout, readTimeout, writeTimeout time.Duration,
+	maxConnectionsPerInstance int,
+	hash func(string) uint32,
+) (*Pool, error) {
+	if weights != nil && len(weights) != len(addresses) {
+		return nil, fmt.Errorf("%d weight(s) for %d address(es)", len(weights), len(addresses))
+	}
+
+	// Each instance owns a contiguous range of the scaled hash values, as
+	// wide as its weight. Unweighted pools map hashes modulo the number of
+	// instances, like New.
+	var (
+		bounds   = make([]uint64, len(weights))
+		total    uint64
+		weighted = false
+	)
+	for i, weight := range weights {
+		if weight < 1 {
+			return nil, fmt.Errorf("invalid weight %d for %s", weight, addresses[i])
+		}
+		total += uint64(weight)
+		bounds[i] = total
+		weighted = weighted || weight != 1
+	}
+	if !weighted {
+		bounds = nil
+	}
+
+	return &Pool{
+		connections: newConnectionPools(addresses, connectTimeout, readTimeout, writeTimeout, maxConnectionsPerInstance),
+		bounds:      bounds,
+		hash:        hash,
+	}, nil
+}
+
+func newConnectionPools(
+	addresses []string,
+	connectTimeout, readTimeout, writeTimeout time.Duration,
+	maxConnectionsPerInstance int,
+) []*connectionPool {
 	connections := make([]*connectionPool, len(addresses))
 	for i, address := range addresses {
 		connections[i] = newConnectionPool(
@@ -43,16 +103,57 @@ func New(
 			maxConnectionsPerInstance,
 		)
 	}
+	return connections
+}
+
+// Derive returns a new Pool over the same Redis instances, with the same
+// weights, but with its own connections, sized and timed out as passed.
+// Keys map to the same instances in both pools, so they may be used for
+// different classes of requests, e.g. reads and writes, without one
+// exhausting the connections of the other.
+func (p *Pool) Derive(
+	connectTimeout, readTimeout, writeTimeout time.Duration,
+	maxConnectionsPerInstance int,
+) *Pool {
+	connections := make([]*connectionPool, len(p.connections))
+	for i, c := range p.connections {
+		connections[i] = newConnectionPool(
+			c.address,
+			connectTimeout, readTimeout, writeTimeout,
+			maxConnectionsPerInstance,
+		)
+	}
 	return &Pool{
 		connections: connections,
-		hash:        hash,
+		bounds:      p.bounds,
+		hash:        p.hash,
+	}
+}
+
+// Instrument reports every attempt to connect to a Redis instance to the
+// instrumentation: whether it succeeded, and how long its steps took, i.e.
+// resolving the host name, connecting, and setting up the connection before
+// use. Connection storms and DNS issues then show in the metrics. Call
+// Instrument before the pool is used.
+func (p *Pool) Instrument(instr instrumentation.DialInstrumentation) {
+	for _, connections := range p.connections {
+		connections.instr = instr
 	}
 }
 
 // Index returns a reference to the connection pool that will be used to
 // satisfy any request for the given key. Pass that value to WithIndex.
 func (p *Pool) Index(key string) int {
-	return int(p.hash(key) % uint32(len(p.connections)))
+	h := p.hash(key)
+	if p.bounds == nil {
+		return int(h % uint32(len(p.connections)))
+	}
+
+	// Scale the hash to the total weight, rather than taking it modulo the
+	// total weight, which only depends on the low bits of the hash if the
+	// total is a power of two, and splits the hash space unevenly otherwise.
+	scaled := uint64(h) * p.bounds[len(p.bounds)-1] >> 32
+	return sort.Search(len(p.bounds), func(i int) bool { return scaled < p.bounds[i] })
 }
 
 // Size returns how many instances the pool sits over. Useful for ranging
@@ -68,19 +169,24 @@ func (p *Pool) Size() int {
 //
 // WithIndex will return an error if it wasn't able to successfully retrieve a
 // connection from the referenced connection pool, and will forward any error
-// returned by the `do` function.
+// returned by the `do` function. If every connection stays in use for the
+// connect timeout, the error is an ExhaustedError. Timeouts are returned as
+// TimeoutErrors.
 func (p *Pool) WithIndex(index int, do func(redis.Conn) error) error {
 	conn, err := p.connections[index].get() // blocking up to connectTimeout
-	defer p.connections[index].put(conn)    // always put, even if it's nil
+	if _, ok := err.(ExhaustedError); ok {
+		return err // nothing to put back
+	}
+	defer p.connections[index].put(conn) // always put, even if it's nil
 	if err != nil {
-		return err
+		return classify(p.connections[index].address, err)
 	}
 
 	err = do(conn)
 	if err != nil {
 		conn.Close() // deferred `put` will detect this, and reject the conn
 	}
-	return err
+	return classify(p.connections[index].address, err)
 }
 
 // With is a convenience function that combines Index and WithIndex, for
@@ -0,0 +1,174 @@
+package pool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestWeightedIndex(t *testing.T) {
+	var (
+		addresses   = []string{"a:6379", "b:6379", "c:6379"}
+		unweighted  = New(addresses, time.Second, time.Second, time.Second, 1, Murmur3)
+		ones, _     = NewWeighted(addresses, []int{1, 1, 1}, time.Second, time.Second, time.Second, 1, Murmur3)
+		weighted, _ = NewWeighted(addresses, []int{1, 2, 5}, time.Second, time.Second, time.Second, 1, Murmur3)
+		counts      = make([]int, len(addresses))
+		n           = 80000
+	)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if expected, got := int(Murmur3(key)%3), unweighted.Index(key); expected != got {
+			t.Fatalf("%s: unweighted: expected index %d, got %d", key, expected, got)
+		}
+		if expected, got := unweighted.Index(key), ones.Index(key); expected != got {
+			t.Fatalf("%s: weights of 1: expected index %d, got %d", key, expected, got)
+		}
+		counts[weighted.Index(key)]++
+	}
+
+	for i, expected := range []int{n / 8, 2 * n / 8, 5 * n / 8} {
+		if got := counts[i]; got < expected*9/10 || got > expected*11/10 {
+			t.Errorf("%s: expected about %d keys, got %d", addresses[i], expected, got)
+		}
+	}
+}
+
+func TestInvalidWeights(t *testing.T) {
+	addresses := []string{"a:6379", "b:6379"}
+	for _, weights := range [][]int{{1}, {1, 0}, {2, -1}} {
+		if _, err := NewWeighted(addresses, weights, time.Second, time.Second, time.Second, 1, Murmur3); err == nil {
+			t.Errorf("%v: expected error, got none", weights)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	var (
+		addresses = []string{"a:6379", "b:6379", "c:6379"}
+		writes, _ = NewWeighted(addresses, []int{1, 2, 5}, time.Second, time.Second, time.Second, 10, Murmur3)
+		reads     = writes.Derive(time.Second, 5*time.Second, time.Second, 2)
+	)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if expected, got := writes.Index(key), reads.Index(key); expected != got {
+			t.Fatalf("%s: expected index %d, got %d", key, expected, got)
+		}
+	}
+	for i := range addresses {
+		if expected, got := writes.ID(i), reads.ID(i); expected != got {
+			t.Errorf("%d: expected ID %q, got %q", i, expected, got)
+		}
+		if writes.connections[i] == reads.connections[i] {
+			t.Errorf("%d: connections are shared", i)
+		}
+		if expected, got := 2, reads.connections[i].max; expected != got {
+			t.Errorf("%d: expected %d max connections, got %d", i, expected, got)
+		}
+		if expected, got := 5*time.Second, reads.connections[i].read; expected != got {
+			t.Errorf("%d: expected read timeout %s, got %s", i, expected, got)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roshi-pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "redis.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		conn.Read(buf) // PING
+		conn.Write([]byte("+PONG\r\n"))
+	}()
+
+	p := New([]string{path}, time.Second, time.Second, time.Second, 1, Murmur3)
+	defer p.Close()
+	if err := p.With("foo", func(c redis.Conn) error {
+		reply, err := redis.String(c.Do("PING"))
+		if err != nil {
+			return err
+		}
+		if expected, got := "PONG", reply; expected != got {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		conn.Read(buf) // PING
+		conn.Write([]byte("+PONG\r\n"))
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// By name, so the host is resolved.
+	instr := &dialCounter{}
+	p := New([]string{"localhost:" + port}, time.Second, time.Second, time.Second, 1, Murmur3)
+	p.Instrument(instr)
+	if err := p.With("foo", func(c redis.Conn) error {
+		_, err := c.Do("PING")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if expected, got := (dialCounter{success: 1, dns: 1, connect: 1}), *instr; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	// Nothing listens anymore.
+	ln.Close()
+	instr = &dialCounter{}
+	p = New([]string{"127.0.0.1:" + port}, time.Second, time.Second, time.Second, 1, Murmur3)
+	p.Instrument(instr)
+	if err := p.With("foo", func(c redis.Conn) error { return nil }); err == nil {
+		t.Fatal("expected error, got none")
+	}
+	p.Close()
+	if expected, got := (dialCounter{failure: 1, connect: 1}), *instr; expected != got {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+// dialCounter counts the calls to each method of DialInstrumentation.
+type dialCounter struct {
+	success, failure, dns, connect, handshake int
+}
+
+func (c *dialCounter) DialSuccess()                        { c.success++ }
+func (c *dialCounter) DialFailure()                        { c.failure++ }
+func (c *dialCounter) DialDNSDuration(time.Duration)       { c.dns++ }
+func (c *dialCounter) DialConnectDuration(time.Duration)   { c.connect++ }
+func (c *dialCounter) DialHandshakeDuration(time.Duration) { c.handshake++ }
@@ -0,0 +1,172 @@
+package pool
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// invalidationChannel is where Redis publishes invalidation messages for
+// clients whose tracking is redirected.
+const invalidationChannel = "__redis__:invalidate"
+
+// Track enables server-assisted client-side caching, via the client tracking
+// of Redis 6 and later. Each connection of the pool asks Redis to remember
+// the keys it reads, and Redis notifies the pool when any of those keys is
+// modified, by any client. invalidate is called with the modified keys, or
+// with nil keys whenever every key may have been modified, e.g. when an
+// instance is flushed, or when the pool loses its subscription to the
+// invalidation messages of an instance.
+//
+// Invalidation messages are delivered over RESP2 pub/sub, by redirecting the
+// tracking of every connection to one subscribed connection per instance.
+// That's equivalent to the RESP3 push messages, and keeps the wire protocol
+// of the connections unchanged. While an instance can't be subscribed to,
+// connections to it can't be established, so that no read goes untracked.
+//
+// Call Track before the pool is used. invalidate must be safe for concurrent
+// use, and shouldn't block.
+func (p *Pool) Track(invalidate func(keys []string)) {
+	for _, connections := range p.connections {
+		connections.tracker = &tracker{
+			address:    connections.address,
+			connect:    connections.connect,
+			write:      connections.write,
+			invalidate: invalidate,
+		}
+	}
+}
+
+// tracker maintains the subscription to the invalidation messages of a
+// single Redis instance. Every connection to the instance redirects its
+// tracking to the subscribed connection, identified by its client ID. Each
+// subscription is a new generation; connections of previous generations are
+// no longer tracked, and must be discarded.
+type tracker struct {
+	address    string
+	connect    time.Duration
+	write      time.Duration
+	invalidate func([]string)
+
+	mu   sync.Mutex
+	gen  uint64
+	id   int64      // client ID of the subscribed connection
+	conn redis.Conn // nil when not subscribed
+}
+
+// trackedConn is a connection whose tracking is redirected to the subscribed
+// connection of a generation.
+type trackedConn struct {
+	redis.Conn
+	gen uint64
+}
+
+// track enables tracking on the freshly dialed connection, subscribing to
+// invalidation messages first, if necessary.
+func (t *tracker) track(conn redis.Conn) (redis.Conn, error) {
+	id, gen, err := t.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Do("CLIENT", "TRACKING", "on", "REDIRECT", id); err != nil {
+		t.reset(gen) // e.g. the instance was restarted, and the ID is gone
+		return nil, err
+	}
+	return trackedConn{Conn: conn, gen: gen}, nil
+}
+
+// current returns whether the connection is tracked by the subscription of
+// the current generation.
+func (t *tracker) current(conn redis.Conn) bool {
+	tc, ok := conn.(trackedConn)
+	if !ok {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil && tc.gen == t.gen
+}
+
+// subscribe returns the client ID and generation of the subscribed
+// connection, dialing and subscribing it, if necessary.
+func (t *tracker) subscribe() (int64, uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		return t.id, t.gen, nil
+	}
+
+	// No read timeout: the connection waits for messages indefinitely.
+	conn, err := redis.DialTimeout(network(t.address), t.address, t.connect, 0, t.write)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := redis.Int64(conn.Do("CLIENT", "ID"))
+	if err != nil {
+		conn.Close()
+		return 0, 0, err
+	}
+	if err := conn.Send("SUBSCRIBE", invalidationChannel); err != nil {
+		conn.Close()
+		return 0, 0, err
+	}
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return 0, 0, err
+	}
+	if _, err := conn.Receive(); err != nil { // subscribe confirmation
+		conn.Close()
+		return 0, 0, err
+	}
+
+	t.gen++
+	t.id, t.conn = id, conn
+	go t.receive(conn, t.gen)
+	return t.id, t.gen, nil
+}
+
+// receive forwards invalidation messages until the connection fails. Then,
+// every connection of the generation is untracked, so everything is
+// invalidated.
+func (t *tracker) receive(conn redis.Conn, gen uint64) {
+	for {
+		reply, err := conn.Receive()
+		if err != nil {
+			log.Printf("pool: %s: tracking lost: %s", t.address, err)
+			break
+		}
+		values, err := redis.Values(reply, nil)
+		if err != nil || len(values) != 3 {
+			continue
+		}
+		if kind, _ := redis.String(values[0], nil); kind != "message" {
+			continue
+		}
+		if values[2] == nil {
+			t.invalidate(nil) // FLUSHALL or FLUSHDB
+			continue
+		}
+		keys, err := redis.Strings(values[2], nil)
+		if err != nil {
+			t.invalidate(nil)
+			continue
+		}
+		t.invalidate(keys)
+	}
+	t.reset(gen)
+	t.invalidate(nil)
+}
+
+// reset ends the subscription of the generation, if it's still current.
+func (t *tracker) reset(gen uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil || t.gen != gen {
+		return
+	}
+	t.conn.Close()
+	t.conn = nil
+	t.gen++ // connections of the ended generation are no longer current
+}
@@ -0,0 +1,113 @@
+package pool
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestTracking(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	subscribers := make(chan net.Conn, 2)
+	go serveTracking(ln, subscribers)
+
+	invalidated := make(chan []string, 10)
+	p := New([]string{ln.Addr().String()}, time.Second, time.Second, time.Second, 1, Murmur3)
+	defer p.Close()
+	p.Track(func(keys []string) { invalidated <- keys })
+
+	get := func() {
+		if err := p.With("foo", func(c redis.Conn) error {
+			_, err := c.Do("GET", "foo")
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The fake instance modifies every key right after it's read.
+	get()
+	select {
+	case keys := <-invalidated:
+		if expected, got := []string{"foo"}, keys; !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no invalidation")
+	}
+
+	// Losing the subscription invalidates everything, and the untracked
+	// connection is replaced by a newly tracked one.
+	(<-subscribers).Close()
+	select {
+	case keys := <-invalidated:
+		if keys != nil {
+			t.Errorf("expected nil keys, got %v", keys)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no invalidation")
+	}
+	get()
+	select {
+	case <-subscribers:
+	case <-time.After(time.Second):
+		t.Fatal("no new subscription")
+	}
+}
+
+// serveTracking serves a minimal Redis, which supports client tracking with
+// redirection, and a GET command, which is followed by an invalidation of the
+// key. Connections which subscribe are sent to subscribers.
+func serveTracking(ln net.Listener, subscribers chan<- net.Conn) {
+	redirect := make(chan map[int]net.Conn, 1) // client ID: subscribed conn
+	redirect <- map[int]net.Conn{}
+	for id := 1; ; id++ {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn, id int) {
+			defer conn.Close()
+			var (
+				r      = redis.NewConn(conn, 0, time.Second)
+				target = 0
+			)
+			for {
+				reply, err := redis.Strings(r.Receive())
+				if err != nil {
+					return
+				}
+				switch cmd := fmt.Sprint(reply); cmd {
+				case "[CLIENT ID]":
+					fmt.Fprintf(conn, ":%d\r\n", id)
+				case "[SUBSCRIBE __redis__:invalidate]":
+					m := <-redirect
+					m[id] = conn
+					redirect <- m
+					fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$20\r\n__redis__:invalidate\r\n:1\r\n")
+					subscribers <- conn
+				case fmt.Sprintf("[CLIENT TRACKING on REDIRECT %s]", reply[len(reply)-1]):
+					fmt.Sscan(reply[len(reply)-1], &target)
+					fmt.Fprintf(conn, "+OK\r\n")
+				case "[GET foo]":
+					fmt.Fprintf(conn, "$3\r\nbar\r\n")
+					m := <-redirect
+					if sub, ok := m[target]; ok {
+						fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$3\r\nfoo\r\n")
+					}
+					redirect <- m
+				default:
+					fmt.Fprintf(conn, "-ERR unknown command %s\r\n", cmd)
+				}
+			}
+		}(conn, id)
+	}
+}
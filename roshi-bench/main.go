@@ -0,0 +1,14 @@
+// roshi-bench generates synthetic workloads against a farm or a roshi-server
+// and reports throughput and latency percentiles. It's the same as roshi
+// bench; see package bench.
+package main
+
+import (
+	"os"
+
+	"github.com/soundcloud/roshi/bench"
+)
+
+func main() {
+	bench.Run(os.Args[0], os.Args[1:])
+}
@@ -0,0 +1,15 @@
+// roshi-simulate replays a recorded operation log against farms of simulated
+// clusters, with configurable latency and failure distributions, and reports
+// consistency and latency outcomes per read strategy. It's the same as roshi
+// simulate; see package simulate.
+package main
+
+import (
+	"os"
+
+	"github.com/soundcloud/roshi/simulate"
+)
+
+func main() {
+	simulate.Run(os.Args[0], os.Args[1:])
+}
@@ -0,0 +1,72 @@
+// roshi runs the Roshi tools as subcommands of a single binary, which share
+// flag parsing, config files, cluster construction, and instrumentation
+// setup. Run roshi help for the list of commands.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/soundcloud/roshi/backup"
+	"github.com/soundcloud/roshi/bench"
+	"github.com/soundcloud/roshi/server"
+	"github.com/soundcloud/roshi/simulate"
+	"github.com/soundcloud/roshi/walker"
+)
+
+// command is a subcommand of roshi. Its run function gets the command name,
+// for its flag set, and the remaining arguments.
+type command struct {
+	name    string
+	summary string
+	run     func(name string, args []string)
+}
+
+// commands are ordered as listed by roshi help.
+var commands = []command{
+	{"serve", "serve the HTTP API of a farm, like roshi-server", server.Run},
+	{"walk", "walk the keyspace and repair it, like roshi-walker", walker.Run},
+	{"sync", "copy the inserts and deletes of every key from another farm", backup.RunSync},
+	{"bench", "generate a synthetic workload, like roshi-bench", bench.Run},
+	{"simulate", "replay an operation log against simulated farms, like roshi-simulate", simulate.Run},
+	{"backup", "write the inserts and deletes of every key to a file", backup.RunBackup},
+	{"restore", "write a backup, or an archive of inserts, to a farm", backup.RunRestore},
+}
+
+func main() {
+	os.Exit(dispatch(os.Args, os.Stderr))
+}
+
+// dispatch runs the command named by args[1], and returns the exit code if
+// the command returns.
+func dispatch(args []string, stderr io.Writer) int {
+	if len(args) < 2 {
+		usage(stderr)
+		return 2
+	}
+	switch name := args[1]; name {
+	case "help", "-h", "-help", "--help":
+		usage(stderr)
+		return 0
+	default:
+		for _, c := range commands {
+			if c.name == name {
+				c.run(filepath.Base(args[0])+" "+name, args[2:])
+				return 0
+			}
+		}
+		fmt.Fprintf(stderr, "roshi: unknown command %q\n\n", name)
+		usage(stderr)
+		return 2
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: roshi <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun roshi <command> -h for the flags of a command. Every command takes\n-config, a file of flags, one per line as name=value.\n")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDispatch(t *testing.T) {
+	for _, testCase := range []struct {
+		args     []string
+		code     int
+		contains string
+	}{
+		{[]string{"roshi"}, 2, "Commands:"},
+		{[]string{"roshi", "help"}, 0, "serve"},
+		{[]string{"roshi", "help"}, 0, "simulate"},
+		{[]string{"roshi", "help"}, 0, "restore"},
+		{[]string{"roshi", "compact"}, 2, `unknown command "compact"`},
+	} {
+		var stderr bytes.Buffer
+		if expected, got := testCase.code, dispatch(testCase.args, &stderr); expected != got {
+			t.Errorf("%v: expected exit code %d, got %d", testCase.args, expected, got)
+		}
+		if !strings.Contains(stderr.String(), testCase.contains) {
+			t.Errorf("%v: expected %q in %q", testCase.args, testCase.contains, stderr.String())
+		}
+	}
+}
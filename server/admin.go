@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// adminPath returns true if the path is served by an admin or debug route,
+// which can change the state of the server or the farm, or expose raw data.
+func adminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || path == "/debug" || strings.HasPrefix(path, "/debug/")
+}
+
+// withAdminAuth guards the admin and debug routes of next. If token is
+// blank, they're only served to loopback clients; otherwise only to clients
+// presenting the token as a bearer token. Other routes are served as usual.
+func withAdminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminPath(r.URL.Path) && !adminAuthorized(r, token) {
+			if token == "" {
+				respondError(w, r.Method, r.URL.String(), http.StatusForbidden, fmt.Errorf("admin routes are only served to loopback clients without -admin.token"))
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondError(w, r.Method, r.URL.String(), http.StatusUnauthorized, fmt.Errorf("admin token required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminAuthorized returns true if the request presents the token as a bearer
+// token, or, if no token is required, if it comes from a loopback address.
+func adminAuthorized(r *http.Request, token string) bool {
+	if token == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	const scheme = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, scheme) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(h[len(scheme):]), []byte(token)) == 1
+}
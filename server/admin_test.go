@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/pat"
+)
+
+func TestAdminAuth(t *testing.T) {
+	r := pat.New()
+	r.Get("/admin/readonly", handleReadOnly(&readOnly{}))
+	r.Add("GET", "/debug", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	do := func(token, remote, path, authorization string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remote
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		withAdminAuth(token, r).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, c := range []struct {
+		token, remote, path, authorization string
+		expected                           int
+	}{
+		// Without a token, only loopback clients are served.
+		{"", "127.0.0.1:1234", "/admin/readonly", "", http.StatusOK},
+		{"", "[::1]:1234", "/debug/pprof/", "", http.StatusOK},
+		{"", "10.0.0.1:1234", "/admin/readonly", "", http.StatusForbidden},
+		{"", "10.0.0.1:1234", "/debug/pprof/", "", http.StatusForbidden},
+		{"", "10.0.0.1:1234", "/", "", http.StatusOK},
+
+		// With a token, every client must present it.
+		{"secret", "127.0.0.1:1234", "/admin/readonly", "", http.StatusUnauthorized},
+		{"secret", "10.0.0.1:1234", "/admin/readonly", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "10.0.0.1:1234", "/admin/readonly", "secret", http.StatusUnauthorized},
+		{"secret", "10.0.0.1:1234", "/admin/readonly", "Bearer secret", http.StatusOK},
+		{"secret", "10.0.0.1:1234", "/", "", http.StatusOK},
+	} {
+		if got := do(c.token, c.remote, c.path, c.authorization); c.expected != got {
+			t.Errorf("token %q, %s %s %q: expected %d, got %d", c.token, c.remote, c.path, c.authorization, c.expected, got)
+		}
+	}
+}
+
+func TestAdminWritesReadOnly(t *testing.T) {
+	m := &readOnly{}
+	m.set(true)
+	r := pat.New()
+	maintenance, rep := fixedMaintainer{false, false}, &recordingRepairer{}
+	r.Post("/admin/maintenance", writable(m, handleMaintenance(maintenance)))
+	r.Post("/admin/repair", writable(m, handleRepair(rep, 10)))
+
+	for _, path := range []string{"/admin/maintenance?cluster=0&enabled=true", "/admin/repair"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+		if expected, got := http.StatusServiceUnavailable, rec.Code; expected != got {
+			t.Errorf("%s: expected %d, got %d", path, expected, got)
+		}
+	}
+	if maintenance[0] || rep.keys != nil {
+		t.Errorf("expected no maintenance or repair while read-only")
+	}
+}
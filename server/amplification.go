@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+// amplificationReporter is implemented by farms which track the read
+// amplification of their read strategies, like *farm.Farm.
+type amplificationReporter interface {
+	ReadAmplification() []farm.Amplification
+}
+
+// amplificationJSON is the response to an amplification request.
+type amplificationJSON struct {
+	Strategies []strategyAmplificationJSON `json:"strategies"`
+}
+
+// strategyAmplificationJSON is farm.Amplification, with its ratio.
+type strategyAmplificationJSON struct {
+	farm.Amplification
+	Ratio float64 `json:"ratio"`
+}
+
+var amplificationDoc = apiDoc{
+	summary:  "Report the read amplification of each read strategy",
+	response: amplificationJSON{},
+}
+
+// handleAmplification reports how many tuples each read strategy retrieved
+// from the clusters, how many it returned, and their ratio, since startup.
+func handleAmplification(a amplificationReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		strategies := []strategyAmplificationJSON{}
+		for _, amplification := range a.ReadAmplification() {
+			strategies = append(strategies, strategyAmplificationJSON{amplification, amplification.Ratio()})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(amplificationJSON{Strategies: strategies})
+	}
+}
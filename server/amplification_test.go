@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+type fixedAmplification []farm.Amplification
+
+func (a fixedAmplification) ReadAmplification() []farm.Amplification { return a }
+
+func TestAmplification(t *testing.T) {
+	r := pat.New()
+	r.Get("/admin/amplification", handleAmplification(fixedAmplification{
+		{Strategy: "SendAllReadAll", Retrieved: 30, Returned: 12},
+		{Strategy: "SendOneReadOne"},
+	}))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/admin/amplification")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+	var response struct {
+		Strategies []map[string]interface{} `json:"strategies"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{"strategy": "SendAllReadAll", "retrieved": 30.0, "returned": 12.0, "ratio": 2.5},
+		{"strategy": "SendOneReadOne", "retrieved": 0.0, "returned": 0.0, "ratio": 0.0},
+	}
+	if !reflect.DeepEqual(expected, response.Strategies) {
+		t.Errorf("expected %v, got %v", expected, response.Strategies)
+	}
+}
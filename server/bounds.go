@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+// scoreBounds are the lowest and highest scores of the page of a key, and, if
+// requested, the highest score of the whole key. A client syncing a key
+// incrementally can tell from them whether it missed anything, and where to
+// resume.
+type scoreBounds struct {
+	Min    *float64 `json:"min,omitempty"`     // nil if the page is empty
+	Max    *float64 `json:"max,omitempty"`     // likewise
+	SetMax *float64 `json:"set_max,omitempty"` // nil if not requested, or if the key is empty
+}
+
+// parseBounds validates the bounds parameter of a select: "" or "none" for no
+// bounds, "page" for the bounds of each page, or "set" for those and the
+// highest score of each key.
+func parseBounds(s string) (page, set bool, err error) {
+	switch s {
+	case "", "none":
+		return false, false, nil
+	case "page":
+		return true, false, nil
+	case "set":
+		return true, true, nil
+	default:
+		return false, false, fmt.Errorf("invalid bounds %q (must be %q, %q, or %q)", s, "none", "page", "set")
+	}
+}
+
+// pageBounds returns the score bounds of each page. With set, it also returns
+// the highest score of each key. If top is set, the pages were selected from
+// the highest score down, so that's the first score of each page. Otherwise,
+// it's read with a select of the first element of each key, whose error is
+// returned along with the bounds.
+func pageBounds(selecter farm.Selecter, pages map[string][]common.KeyScoreMember, set, top bool) (map[string]scoreBounds, error) {
+	bounds := make(map[string]scoreBounds, len(pages))
+	for key, page := range pages {
+		var b scoreBounds
+		for i := range page {
+			if b.Min == nil || page[i].Score < *b.Min {
+				b.Min = &page[i].Score
+			}
+			if b.Max == nil || page[i].Score > *b.Max {
+				b.Max = &page[i].Score
+			}
+		}
+		if set && top && len(page) > 0 {
+			b.SetMax = &page[0].Score
+		}
+		bounds[key] = b
+	}
+	if !set || top {
+		return bounds, nil
+	}
+
+	keys := make([]string, 0, len(pages))
+	for key := range pages {
+		keys = append(keys, key)
+	}
+	firsts, err := selecter.SelectOffset(keys, 0, 1) // partial results come with an error
+	for key, first := range firsts {
+		if b, ok := bounds[key]; ok && len(first) > 0 {
+			b.SetMax = &first[0].Score
+			bounds[key] = b
+		}
+	}
+	return bounds, err
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestSelectBounds(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1, Member: "a"},
+		{Key: "foo", Score: 2, Member: "b"},
+		{Key: "foo", Score: 3, Member: "c"},
+		{Key: "foo", Score: 4, Member: "d"},
+	})
+	handler := handleSelect(farm, 0, nil, nil)
+	body := `["Zm9v", "YmFy"]` // foo, bar
+
+	f := func(x float64) *float64 { return &x }
+	for query, expected := range map[string]map[string]scoreBounds{
+		"limit=2&bounds=page":          {"foo": {Min: f(3), Max: f(4)}, "bar": {}},
+		"limit=2&bounds=set":           {"foo": {Min: f(3), Max: f(4), SetMax: f(4)}, "bar": {}},
+		"offset=1&limit=2&bounds=set":  {"foo": {Min: f(2), Max: f(3), SetMax: f(4)}, "bar": {}},
+		"order=asc&limit=2&bounds=set": {"foo": {Min: f(1), Max: f(2), SetMax: f(4)}, "bar": {}},
+		"start=" + url.QueryEscape(common.Cursor{Score: 3, Member: "c"}.String()) + "&limit=5&bounds=set": {"foo": {Min: f(1), Max: f(2), SetMax: f(4)}, "bar": {}},
+		"offset=10&limit=5&bounds=set": {"foo": {SetMax: f(4)}, "bar": {}},
+		"limit=2":                      nil,
+		"limit=2&bounds=none":          nil,
+	} {
+		req, _ := http.NewRequest("POST", "/?"+query, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected %d, got %d: %s", query, http.StatusOK, w.Code, w.Body.String())
+			continue
+		}
+		var response struct {
+			Bounds map[string]scoreBounds `json:"bounds"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, response.Bounds) {
+			got, _ := json.Marshal(response.Bounds)
+			t.Errorf("%s: got bounds %s", query, got)
+		}
+	}
+
+	for _, query := range []string{"bounds=all", "bounds=page&coalesce=true"} {
+		req, _ := http.NewRequest("POST", "/?"+query, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+// maxBuckets bounds the keys a single bucketed select may fan out to.
+const maxBuckets = 1000
+
+// bucketsJSON is the JSON response to a bucketed select.
+type bucketsJSON struct {
+	Duration string                  `json:"duration"`
+	Records  []common.KeyScoreMember `json:"records"`
+}
+
+var bucketsDoc = apiDoc{
+	summary: "Select a family of time-bucketed keys as a single key",
+	params: []apiParam{
+		{"key", "string", "The family of keys, with the bucket appended"},
+		{"layout", "string", "Go time layout of the buckets (default 2006-01-02)"},
+		{"period", "string", "Duration of each bucket (default 24h)"},
+		{"window", "string", "Duration of the window, ending now (default the period)"},
+		{"offset", "integer", "Offset into the window"},
+		{"limit", "integer", "Maximum number of records (default 10)"},
+	},
+	response: bucketsJSON{},
+}
+
+// handleSelectBuckets selects a family of time-bucketed keys, covering a
+// rolling window which ends now, as a single key. See farm.Buckets.
+func handleSelectBuckets(selecter farm.Selecter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+
+		var (
+			family, familyGiven = parseStr(r.Form, "key", "")
+			layout, _           = parseStr(r.Form, "layout", "2006-01-02")
+			period, _           = parseStr(r.Form, "period", "24h")
+			window, windowGiven = parseStr(r.Form, "window", "")
+			offset, _           = parseInt(r.Form, "offset", 0)
+			limit, _            = parseInt(r.Form, "limit", 10)
+		)
+		if !familyGiven || family == "" {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("key required"))
+			return
+		}
+		periodDuration, err := time.ParseDuration(period)
+		if err != nil || periodDuration <= 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid period %q", period))
+			return
+		}
+		windowDuration := periodDuration
+		if windowGiven {
+			if windowDuration, err = time.ParseDuration(window); err != nil || windowDuration < 0 {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid window %q", window))
+				return
+			}
+		}
+		if n := windowDuration / periodDuration; n > maxBuckets {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("window spans %d buckets (max %d)", n, maxBuckets))
+			return
+		}
+		if offset < 0 || limit < 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("negative offset or limit"))
+			return
+		}
+
+		selecter := selecter // may be replaced for this request only
+		if identifier, ok := selecter.(requestIdentifier); ok && requestID(w) != "" {
+			selecter = identifier.WithRequestID(requestID(w))
+		}
+
+		var (
+			buckets = farm.Buckets{Period: periodDuration, Layout: layout}
+			to      = time.Now()
+			from    = to.Add(-windowDuration)
+		)
+		if err := requestUsage(r).admit(r.Context(), "select", buckets.Keys(family, from, to)); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusTooManyRequests, err)
+			return
+		}
+		records, err := farm.SelectBuckets(selecter, buckets, family, from, to, offset, limit)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+			return
+		}
+		respondSelected(w, r, records, time.Since(began))
+	}
+}
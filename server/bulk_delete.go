@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// bulkDeleteConcurrency is how many chunks of a bulk delete may be deleted
+// at once. Reading the body waits while they're all in flight.
+const bulkDeleteConcurrency = 4
+
+// tupleStream reads the tuples of a bulk delete from the body as they arrive,
+// so that the first chunks are deleted before the rest is read.
+type tupleStream interface {
+	next() (common.KeyScoreMember, error) // io.EOF after the last tuple
+}
+
+// newTupleStream returns a tupleStream over the body of the request: a JSON
+// array of tuples, as for a delete. Msgpack and protobuf bodies are read as
+// a whole.
+func newTupleStream(r *http.Request) (tupleStream, error) {
+	if f := bodyFormat(r); f != formatJSON {
+		tuples, err := decodeTuples(f, r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &sliceTuples{tuples}, nil
+	}
+	dec := json.NewDecoder(r.Body)
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if d, ok := t.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("expected an array of tuples, got %v", t)
+	}
+	return jsonTuples{dec}, nil
+}
+
+type jsonTuples struct{ dec *json.Decoder }
+
+func (t jsonTuples) next() (common.KeyScoreMember, error) {
+	var tuple common.KeyScoreMember
+	if !t.dec.More() {
+		if _, err := t.dec.Token(); err != nil { // the closing bracket
+			return tuple, err
+		}
+		return tuple, io.EOF
+	}
+	err := t.dec.Decode(&tuple)
+	return tuple, err
+}
+
+type sliceTuples struct{ tuples []common.KeyScoreMember }
+
+func (t *sliceTuples) next() (common.KeyScoreMember, error) {
+	if len(t.tuples) <= 0 {
+		return common.KeyScoreMember{}, io.EOF
+	}
+	tuple := t.tuples[0]
+	t.tuples = t.tuples[1:]
+	return tuple, nil
+}
+
+// bulkDeletedJSON is the response to a bulk delete. Results are in the
+// order of the tuples of the body.
+type bulkDeletedJSON struct {
+	Deleted  int                    `json:"deleted"`
+	Failed   int                    `json:"failed"`
+	Duration string                 `json:"duration"`
+	Results  []bulkDeleteResultJSON `json:"results"`
+}
+
+// bulkDeleteResultJSON is the outcome of the delete of a tuple.
+type bulkDeleteResultJSON struct {
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+var (
+	bulkDeleteDoc = apiDoc{
+		summary:  "Delete many tuples, reporting the outcome of each",
+		request:  []common.KeyScoreMember{},
+		response: bulkDeletedJSON{},
+	}
+	bulkDeleteOverrideDoc = apiDoc{
+		summary:  "Delete many tuples, with X-HTTP-Method-Override: DELETE",
+		request:  []common.KeyScoreMember{},
+		response: bulkDeletedJSON{},
+	}
+)
+
+// handleBulkDelete deletes the tuples of the body, more than a delete body
+// would reasonably hold, e.g. to clean up after a bad import. The tuples are
+// read from the body as they arrive, and deleted in chunks of chunkSize
+// tuples, a few chunks at a time. The outcome of each tuple is that of its
+// chunk, so a chunk which failed quorum fails every tuple in it, and the
+// others succeed; the response is 200 OK either way, and lists the outcome of
+// each tuple, so clients can retry only those which failed. Bulk deletes of
+// more than maxTuples tuples are rejected, unless maxTuples is 0.
+func handleBulkDelete(deleter cluster.Deleter, maxTuples, chunkSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		tuples, err := newTupleStream(r)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		var (
+			results  = []bulkDeleteResultJSON{}
+			chunk    = make([]common.KeyScoreMember, 0, chunkSize)
+			mtx      sync.Mutex
+			wg       sync.WaitGroup
+			inFlight = make(chan struct{}, bulkDeleteConcurrency)
+		)
+		flush := func() {
+			if len(chunk) <= 0 {
+				return
+			}
+			first := len(results) - len(chunk)
+			inFlight <- struct{}{}
+			wg.Add(1)
+			go func(tuples []common.KeyScoreMember, first int) {
+				defer func() { <-inFlight; wg.Done() }()
+				result := bulkDeleteResultJSON{Deleted: true}
+				if err := deleter.Delete(tuples); err != nil {
+					result = bulkDeleteResultJSON{Error: err.Error()}
+				} else {
+					requestUsage(r).written(r.Context(), tuples)
+				}
+				mtx.Lock()
+				defer mtx.Unlock()
+				for i := range tuples {
+					results[first+i] = result
+				}
+			}(chunk, first)
+			chunk = make([]common.KeyScoreMember, 0, chunkSize)
+		}
+
+		var (
+			code    int
+			readErr error
+		)
+		for {
+			tuple, err := tuples.next()
+			if err == io.EOF {
+				flush()
+				break
+			}
+			if err != nil {
+				code, readErr = http.StatusBadRequest, fmt.Errorf("tuple %d: %s", len(results), err)
+				break
+			}
+			if maxTuples > 0 && len(results) >= maxTuples {
+				code, readErr = http.StatusRequestEntityTooLarge, fmt.Errorf("more than the max of %d tuples", maxTuples)
+				break
+			}
+			mtx.Lock()
+			results = append(results, bulkDeleteResultJSON{})
+			mtx.Unlock()
+			addRequestTuples(r, tuple)
+			if chunk = append(chunk, tuple); len(chunk) >= chunkSize {
+				flush()
+			}
+		}
+		wg.Wait()
+		if readErr != nil {
+			// Chunks already deleted stay deleted; deletes are idempotent,
+			// so the whole body can be sent again.
+			respondError(w, r.Method, r.URL.String(), code, readErr)
+			return
+		}
+
+		response := bulkDeletedJSON{Duration: time.Since(began).String(), Results: results}
+		for _, result := range results {
+			if result.Deleted {
+				response.Deleted++
+			} else {
+				response.Failed++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// methodOverride serves POST requests with the method in their
+// X-HTTP-Method-Override header, for clients which can't send a body with
+// it. Requests without the header are rejected with 405 Method Not Allowed.
+func methodOverride(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if override := r.Header.Get("X-HTTP-Method-Override"); !strings.EqualFold(override, method) {
+			respondError(w, r.Method, r.URL.String(), http.StatusMethodNotAllowed, fmt.Errorf("POST requires X-HTTP-Method-Override: %s", method))
+			return
+		}
+		r.Method = method
+		next(w, r)
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// failingDeleter fails every delete of a chunk with a tuple of the key. It
+// serializes deletes, as the mockFarm isn't safe for concurrent use.
+type failingDeleter struct {
+	*mockFarm
+	key string
+	mtx sync.Mutex
+}
+
+func (d *failingDeleter) Delete(tuples []common.KeyScoreMember) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, tuple := range tuples {
+		if tuple.Key == d.key {
+			return errors.New("quorum failure")
+		}
+	}
+	return d.mockFarm.Delete(tuples)
+}
+
+func TestBulkDelete(t *testing.T) {
+	farm := newMockFarm()
+	tuples := []common.KeyScoreMember{}
+	for i := 0; i < 5; i++ {
+		tuples = append(tuples, common.KeyScoreMember{Key: fmt.Sprintf("key%d", i), Score: 2, Member: "a"})
+	}
+	farm.Insert(tuples)
+	body, _ := json.Marshal(tuples)
+
+	// Chunks of 2: key0 and key1, key2 and key3, key4. Only the second
+	// chunk fails.
+	handler := handleBulkDelete(&failingDeleter{mockFarm: farm, key: "key3"}, 5, 2)
+	req, _ := http.NewRequest("DELETE", "/bulk", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response bulkDeletedJSON
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Deleted != 3 || response.Failed != 2 || len(response.Results) != 5 {
+		t.Fatalf("expected 3 deleted and 2 failed, got %+v", response)
+	}
+	for i, result := range response.Results {
+		if failed := i == 2 || i == 3; result.Deleted == failed || (result.Error != "") != failed {
+			t.Errorf("tuple %d: got %+v", i, result)
+		}
+	}
+	for i, expected := range []int{0, 0, 1, 1, 0} {
+		selected, _ := farm.SelectOffset([]string{fmt.Sprintf("key%d", i)}, 0, 10)
+		if got := len(selected[fmt.Sprintf("key%d", i)]); expected != got {
+			t.Errorf("key%d: expected %d member(s), got %d", i, expected, got)
+		}
+	}
+
+	// Too many tuples, or a malformed body, are rejected.
+	handler = handleBulkDelete(&failingDeleter{mockFarm: farm}, 4, 2)
+	for body, expected := range map[string]int{
+		string(body):                     http.StatusRequestEntityTooLarge,
+		`{"key": "a2V5MA=="}`:            http.StatusBadRequest,
+		`[{"key": "a2V5MA=="}, "bogus"]`: http.StatusBadRequest,
+		`[]`:                             http.StatusOK,
+	} {
+		req, _ := http.NewRequest("DELETE", "/bulk", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if expected != w.Code {
+			t.Errorf("%s: expected %d, got %d", body, expected, w.Code)
+		}
+	}
+}
+
+func TestMethodOverride(t *testing.T) {
+	var method string
+	handler := methodOverride("DELETE", func(w http.ResponseWriter, r *http.Request) { method = r.Method })
+	for override, expected := range map[string]int{
+		"":       http.StatusMethodNotAllowed,
+		"PUT":    http.StatusMethodNotAllowed,
+		"delete": http.StatusOK,
+	} {
+		method = ""
+		req, _ := http.NewRequest("POST", "/bulk", nil)
+		req.Header.Set("X-HTTP-Method-Override", override)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if expected != w.Code {
+			t.Errorf("%q: expected %d, got %d", override, expected, w.Code)
+		}
+		if w.Code == http.StatusOK && method != "DELETE" {
+			t.Errorf("%q: expected the request as DELETE, got %s", override, method)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+// bulkSelect is one element of the body of a bulk select: a key, and the
+// page of it to return.
+type bulkSelect struct {
+	Key    []byte `json:"key"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"` // 0 for the default of 10
+}
+
+// page identifies the Select which serves a bulkSelect. Keys selected with
+// the same offset and limit are served by the same Select.
+type page struct{ offset, limit int }
+
+// bulkSelectedJSON is the JSON response to a bulk select.
+type bulkSelectedJSON struct {
+	Duration string                    `json:"duration"`
+	Records  [][]common.KeyScoreMember `json:"records"`
+}
+
+var bulkSelectDoc = apiDoc{
+	summary: "Select a page of each of many keys, each at its own offset",
+	params: []apiParam{
+		{"order", "string", "desc (default) or asc"},
+		{"repair", "boolean", "Whether divergences are repaired (default true)"},
+	},
+	request:  []bulkSelect{},
+	response: bulkSelectedJSON{},
+}
+
+// handleBulkSelect serves the pages of many keys, each at its own offset, in
+// one request. The body is a JSON array of bulkSelects, and the records of
+// the response are an array of the selected tuples for each of them, in the
+// same order. The repair and order parameters behave as for a select.
+func handleBulkSelect(selecter farm.Selecter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+
+		var selects []bulkSelect
+		if err := json.NewDecoder(r.Body).Decode(&selects); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		var (
+			order, _  = parseStr(r.Form, "order", "desc")
+			repair, _ = parseBool(r.Form, "repair", true)
+		)
+		if order != "asc" && order != "desc" {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid order %q (must be %q or %q)", order, "asc", "desc"))
+			return
+		}
+
+		selecter := selecter // may be replaced for this request only
+		if identifier, ok := selecter.(requestIdentifier); ok && requestID(w) != "" {
+			selecter = identifier.WithRequestID(requestID(w))
+		}
+		if !repair {
+			exempter, ok := selecter.(repairExempter)
+			if !ok {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("repair-exempt selects not supported"))
+				return
+			}
+			selecter = exempter.WithoutRepairs()
+		}
+		selectOffset := selecter.SelectOffset
+		if order == "asc" {
+			selectOffset = selecter.SelectOffsetAscending
+		}
+
+		// Group the keys by page, so each page takes one Select.
+		var (
+			pages = map[page][]string{}
+			keys  = make([]string, len(selects))
+		)
+		for i, s := range selects {
+			keys[i] = string(s.Key)
+			if s.Offset < 0 || s.Limit < 0 {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("select %d: negative offset or limit", i))
+				return
+			}
+			if s.Limit == 0 {
+				selects[i].Limit = 10
+			}
+			p := page{selects[i].Offset, selects[i].Limit}
+			pages[p] = append(pages[p], string(s.Key))
+		}
+		if err := requestUsage(r).admit(r.Context(), "select", keys); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusTooManyRequests, err)
+			return
+		}
+
+		type result struct {
+			page    page
+			records map[string][]common.KeyScoreMember
+			err     error
+		}
+		var (
+			results = make(chan result, len(pages))
+			wg      sync.WaitGroup
+		)
+		for p, keys := range pages {
+			wg.Add(1)
+			go func(p page, keys []string) {
+				defer wg.Done()
+				records, err := selectOffset(keys, p.offset, p.limit)
+				results <- result{p, records, err}
+			}(p, keys)
+		}
+		wg.Wait()
+		close(results)
+
+		byPage := make(map[page]map[string][]common.KeyScoreMember, len(pages))
+		for result := range results {
+			if result.err != nil {
+				respondError(w, r.Method, r.URL.String(), errorCode(result.err), result.err)
+				return
+			}
+			byPage[result.page] = result.records
+		}
+
+		records := make([][]common.KeyScoreMember, len(selects))
+		for i, s := range selects {
+			tuples := byPage[page{s.Offset, s.Limit}][string(s.Key)]
+			if tuples == nil {
+				tuples = []common.KeyScoreMember{}
+			}
+			records[i] = tuples
+		}
+		respondSelected(w, r, records, time.Since(began))
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// memberChecker is implemented by farms which can tell whether members are
+// present, like *farm.Farm.
+type memberChecker interface {
+	Contains([]common.KeyMember) ([]bool, error)
+}
+
+// keyMember is one element of the body of a contains request. Like the
+// tuples of writes, keys and members are base64-encoded.
+type keyMember struct {
+	Key    []byte `json:"key"`
+	Member []byte `json:"member"`
+}
+
+// containsJSON is the response to a contains request.
+type containsJSON struct {
+	Duration string `json:"duration"`
+	Records  []bool `json:"records"`
+}
+
+var containsDoc = apiDoc{
+	summary:  "Report whether each key-member is present",
+	request:  []keyMember{},
+	response: containsJSON{},
+}
+
+// handleContains reports whether each of the key-members in the body, a JSON
+// array, is present. The records of the response are a boolean for each of
+// them, in the same order.
+func handleContains(c memberChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		var body []keyMember
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		var (
+			keyMembers = make([]common.KeyMember, len(body))
+			keys       = make([]string, len(body))
+		)
+		for i, km := range body {
+			if len(km.Key) <= 0 {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("key-member %d: empty key", i))
+				return
+			}
+			keyMembers[i] = common.KeyMember{Key: string(km.Key), Member: string(km.Member)}
+			keys[i] = string(km.Key)
+		}
+		if err := requestUsage(r).admit(r.Context(), "select", keys); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusTooManyRequests, err)
+			return
+		}
+
+		present, err := c.Contains(keyMembers)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		respondSelected(w, r, present, time.Since(began))
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+type fixedMembers map[common.KeyMember]bool
+
+func (m fixedMembers) Contains(keyMembers []common.KeyMember) ([]bool, error) {
+	present := make([]bool, len(keyMembers))
+	for i, keyMember := range keyMembers {
+		present[i] = m[keyMember]
+	}
+	return present, nil
+}
+
+func TestContains(t *testing.T) {
+	r := pat.New()
+	r.Post("/select/contains", handleContains(fixedMembers{{Key: "foo", Member: "a"}: true}))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	post := func(body string) *http.Response {
+		resp, err := http.Post(server.URL+"/select/contains", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// foo:a and foo:b, base64-encoded.
+	resp := post(`[{"key":"Zm9v","member":"YQ=="},{"key":"Zm9v","member":"Yg=="}]`)
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+	var response struct {
+		Records []bool `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{true, false}, response.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	resp = post(`[{"member":"YQ=="}]`)
+	resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("without a key: expected %d, got %d", expected, got)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsExposedHeaders are the response headers which browsers let scripts of
+// other origins read.
+var corsExposedHeaders = strings.Join([]string{
+	"ETag",
+	requestIDHeader,
+	"X-Roshi-Consistency",
+	"X-Roshi-Missed-Clusters",
+}, ", ")
+
+// corsPolicy decides which other origins browsers let call the server, so
+// that dashboards may query it directly.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	headers   string // allowed request headers
+	maxAge    time.Duration
+}
+
+// newCORSPolicy returns a policy allowing the comma-separated origins, or
+// every origin, if they include "*". Browsers may send the comma-separated
+// request headers, and cache the outcome of a preflight for maxAge.
+func newCORSPolicy(origins, headers string, maxAge time.Duration) *corsPolicy {
+	p := &corsPolicy{
+		origins: map[string]bool{},
+		maxAge:  maxAge,
+	}
+	for _, origin := range strings.Split(origins, ",") {
+		switch origin = strings.TrimSpace(origin); origin {
+		case "":
+		case "*":
+			p.anyOrigin = true
+		default:
+			p.origins[origin] = true
+		}
+	}
+	var allowed []string
+	for _, header := range strings.Split(headers, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			allowed = append(allowed, header)
+		}
+	}
+	p.headers = strings.Join(allowed, ", ")
+	return p
+}
+
+// withCORS lets browsers call the next handler from the origins allowed by
+// the policy, and answers their preflight requests. Requests from other
+// origins are served without CORS headers, so browsers reject them, as are
+// the admin and debug routes, from any origin.
+func withCORS(p *corsPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !(p.anyOrigin || p.origins[origin]) || adminPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := origin
+		if p.anyOrigin {
+			allowed = "*"
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			if p.headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", p.headers)
+			}
+			if p.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
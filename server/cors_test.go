@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	serve := func(p *corsPolicy, method, origin string, preflight bool) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, "http://localhost:6302/", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflight {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		withCORS(p, next).ServeHTTP(w, r)
+		return w
+	}
+	p := newCORSPolicy("https://dash.example.com, https://other.example.com", "Content-Type, X-Request-ID", 90*time.Second)
+
+	// Preflight requests are answered by the policy.
+	w := serve(p, "OPTIONS", "https://dash.example.com", true)
+	for header, expected := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://dash.example.com",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Content-Type, X-Request-ID",
+		"Access-Control-Max-Age":       "90",
+	} {
+		if got := w.Header().Get(header); expected != got {
+			t.Errorf("preflight: expected %s %q, got %q", header, expected, got)
+		}
+	}
+	if expected, got := http.StatusNoContent, w.Code; expected != got {
+		t.Errorf("preflight: expected %d, got %d", expected, got)
+	}
+
+	// Actual requests are served, with the CORS headers.
+	w = serve(p, "GET", "https://other.example.com", false)
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Errorf("GET: expected %d, got %d", expected, got)
+	}
+	if expected, got := "https://other.example.com", w.Header().Get("Access-Control-Allow-Origin"); expected != got {
+		t.Errorf("GET: expected origin %q, got %q", expected, got)
+	}
+	if expected, got := corsExposedHeaders, w.Header().Get("Access-Control-Expose-Headers"); expected != got {
+		t.Errorf("GET: expected exposed headers %q, got %q", expected, got)
+	}
+
+	// Other origins get no CORS headers, and preflights fall through.
+	w = serve(p, "OPTIONS", "https://evil.example.com", true)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("other origin: expected no CORS headers, got origin %q", got)
+	}
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Errorf("other origin: expected %d, got %d", expected, got)
+	}
+
+	// Any origin may be allowed.
+	w = serve(newCORSPolicy("*", "", 0), "GET", "https://evil.example.com", false)
+	if expected, got := "*", w.Header().Get("Access-Control-Allow-Origin"); expected != got {
+		t.Errorf("any origin: expected origin %q, got %q", expected, got)
+	}
+
+	// The admin and debug routes are never served cross-origin.
+	for _, path := range []string{"/admin/readonly", "/debug/key"} {
+		r, _ := http.NewRequest("POST", "http://localhost:6302"+path, nil)
+		r.Header.Set("Origin", "https://dash.example.com")
+		w := httptest.NewRecorder()
+		withCORS(p, next).ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("%s: expected no CORS headers, got origin %q", path, got)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// cursorSigner signs the cursors handed to clients, and verifies the ones
+// they send back, so clients can neither forge positions, nor replay
+// ancient cursors against sets which have since been trimmed. A signed
+// cursor is the plain cursor, the order of the page it was taken from, its
+// expiry in Unix seconds, and a base64 HMAC-SHA256, separated by periods.
+// The HMAC covers the rest of the cursor and the keys of the page, so a
+// cursor is only accepted by a Select of the same keys, in the same order.
+// A nil *cursorSigner accepts plain cursors, and signs nothing.
+type cursorSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func newCursorSigner(secret string, ttl time.Duration) *cursorSigner {
+	return &cursorSigner{secret: []byte(secret), ttl: ttl}
+}
+
+// sign returns the signed cursor of a page of the keys, in ascending or
+// descending order, valid until ttl after now.
+func (s *cursorSigner) sign(c common.Cursor, keys []string, ascending bool, now time.Time) string {
+	payload := c.String() + "." + orderName(ascending) + "." + strconv.FormatInt(now.Add(s.ttl).Unix(), 10)
+	return payload + "." + s.mac(payload, keys)
+}
+
+// parse verifies and parses the signed cursor for a Select of the keys, in
+// ascending or descending order, or parses the plain cursor if s is nil.
+func (s *cursorSigner) parse(token string, keys []string, ascending bool, now time.Time) (common.Cursor, error) {
+	var c common.Cursor
+	if s == nil {
+		return c, c.Parse(token)
+	}
+
+	fields := strings.Split(token, ".")
+	if len(fields) != 4 {
+		return c, fmt.Errorf("invalid cursor (not signed)")
+	}
+	if order := orderName(ascending); fields[1] != order {
+		return c, fmt.Errorf("invalid cursor (for order=%s, not %s)", fields[1], order)
+	}
+	payload := strings.Join(fields[:3], ".")
+	if !hmac.Equal([]byte(fields[3]), []byte(s.mac(payload, keys))) {
+		return c, fmt.Errorf("invalid cursor (bad signature, or other keys)")
+	}
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor expiry (%s)", err)
+	}
+	if now.Unix() > expiry {
+		return c, fmt.Errorf("expired cursor")
+	}
+	return c, c.Parse(fields[0])
+}
+
+// mac returns the HMAC of the payload and the keys, in any order. Each key
+// is prefixed by its length, so that keys can't be split or joined.
+func (s *cursorSigner) mac(payload string, keys []string) string {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(payload))
+	for _, key := range sorted {
+		fmt.Fprintf(h, "\n%d:%s", len(key), key)
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func orderName(ascending bool) string {
+	if ascending {
+		return "asc"
+	}
+	return "desc"
+}
+
+// cursorPair holds the signed cursors of a page of tuples: next selects the
+// following page when passed as start, and newer selects the tuples above
+// the page when passed as stop.
+type cursorPair struct {
+	Next  string `json:"next"`
+	Newer string `json:"newer"`
+}
+
+// pair returns the cursors of the page of the keys, which must not be
+// empty.
+func (s *cursorSigner) pair(page []common.KeyScoreMember, keys []string, ascending bool, now time.Time) cursorPair {
+	return cursorPair{
+		Next:  s.sign(page[len(page)-1].Cursor(), keys, ascending, now),
+		Newer: s.sign(page[0].Cursor(), keys, ascending, now),
+	}
+}
+
+// pairs returns the cursors of each non-empty page, each valid for its key
+// only.
+func (s *cursorSigner) pairs(pages map[string][]common.KeyScoreMember, ascending bool, now time.Time) map[string]cursorPair {
+	pairs := make(map[string]cursorPair, len(pages))
+	for key, page := range pages {
+		if len(page) > 0 {
+			pairs[key] = s.pair(page, []string{key}, ascending, now)
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestCursorSigner(t *testing.T) {
+	var (
+		signer = newCursorSigner("secret", time.Minute)
+		cursor = common.Cursor{Score: 1.5, Member: "foo"}
+		keys   = []string{"a", "b"}
+		now    = time.Now()
+		signed = signer.sign(cursor, keys, false, now)
+	)
+
+	// The keys may be in any order.
+	got, err := signer.parse(signed, []string{"b", "a"}, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cursor, got) {
+		t.Errorf("expected %+v, got %+v", cursor, got)
+	}
+
+	for name, token := range map[string]string{
+		"plain":        cursor.String(),
+		"forged":       common.Cursor{Score: 9, Member: "foo"}.String() + signed[strings.Index(signed, "."):],
+		"other secret": newCursorSigner("other", time.Minute).sign(cursor, keys, false, now),
+		"extended":     strings.Replace(signed, ".desc.", ".desc.0", 1),
+		"other order":  strings.Replace(signed, ".desc.", ".asc.", 1),
+		"ascending":    signer.sign(cursor, keys, true, now),
+	} {
+		if _, err := signer.parse(token, keys, false, now); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	for name, other := range map[string][]string{
+		"subset":   {"a"},
+		"superset": {"a", "b", "c"},
+		"joined":   {"ab"},
+		"split":    {"a", "", "b"},
+	} {
+		if _, err := signer.parse(signed, other, false, now); err == nil {
+			t.Errorf("%s: expected an error for keys %q", name, other)
+		}
+	}
+	if _, err := signer.parse(signed, keys, false, now.Add(2*time.Minute)); err == nil {
+		t.Errorf("expected an expired cursor to be rejected")
+	}
+
+	// Without a signer, plain cursors are accepted.
+	if got, err := (*cursorSigner)(nil).parse(cursor.String(), keys, false, now); err != nil || !reflect.DeepEqual(cursor, got) {
+		t.Errorf("expected %+v, got %+v (%v)", cursor, got, err)
+	}
+}
+
+func TestSelectSignedCursors(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 3, Member: "c"},
+		common.KeyScoreMember{Key: "foo", Score: 2, Member: "b"},
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"},
+	})
+	r := pat.New()
+	r.Get("/", handleSelect(farm, time.Second, newCursorSigner("secret", time.Minute), nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	type response struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+		Cursors map[string]cursorPair              `json:"cursors"`
+	}
+	get := func(query string, keys ...string) (int, response) {
+		if len(keys) <= 0 {
+			keys = []string{"foo"}
+		}
+		body := [][]byte{}
+		for _, key := range keys {
+			body = append(body, []byte(key))
+		}
+		buf, _ := json.Marshal(body)
+		req, _ := http.NewRequest("GET", server.URL+"?"+query, bytes.NewReader(buf))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var r response
+		json.NewDecoder(resp.Body).Decode(&r)
+		return resp.StatusCode, r
+	}
+
+	code, first := get("limit=2")
+	if code != http.StatusOK {
+		t.Fatalf("HTTP %d", code)
+	}
+	next := first.Cursors["foo"].Next
+	if next == "" {
+		t.Fatalf("expected cursors, got %+v", first)
+	}
+
+	code, second := get("limit=2&start=" + url.QueryEscape(next))
+	if code != http.StatusOK {
+		t.Fatalf("HTTP %d", code)
+	}
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"},
+	}, second.Records["foo"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	// Cursors are only accepted for the key they were handed out for.
+	if code, _ := get("limit=2&start="+url.QueryEscape(next), "bar"); code != http.StatusBadRequest {
+		t.Errorf("cursor of another key: expected HTTP %d, got %d", http.StatusBadRequest, code)
+	}
+	if code, _ := get("limit=2&start="+url.QueryEscape(next), "foo", "bar"); code != http.StatusBadRequest {
+		t.Errorf("cursor of a single key: expected HTTP %d, got %d", http.StatusBadRequest, code)
+	}
+
+	// Plain cursors aren't accepted.
+	plain := common.Cursor{Score: 2, Member: "b"}.String()
+	if code, _ := get("start=" + url.QueryEscape(plain)); code != http.StatusBadRequest {
+		t.Errorf("plain cursor: expected HTTP %d, got %d", http.StatusBadRequest, code)
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Large Select responses dominated CPU via GC pressure, with encoding/json
+// allocating several times per tuple: in the MarshalJSON of each
+// KeyScoreMember, for the base64 encodings, and to validate and copy the
+// output of each MarshalJSON. recordsEncoder writes the same bytes as
+// encoding/json, into a pooled buffer, without allocating per tuple.
+
+var encoderPool = sync.Pool{
+	New: func() interface{} { return &recordsEncoder{} },
+}
+
+// recordsEncoder encodes Select results as JSON. Get one from the pool with
+// getEncoder, and return it with putEncoder.
+type recordsEncoder struct {
+	buf     bytes.Buffer
+	scratch []byte
+	keys    []string
+}
+
+func getEncoder() *recordsEncoder {
+	return encoderPool.Get().(*recordsEncoder)
+}
+
+// maxPooledSize is the largest buffer returned to the pool, so that one huge
+// response doesn't pin its memory forever.
+const maxPooledSize = 4 << 20
+
+func putEncoder(e *recordsEncoder) {
+	if e.buf.Cap() > maxPooledSize {
+		return
+	}
+	e.buf.Reset()
+	e.keys = e.keys[:0]
+	encoderPool.Put(e)
+}
+
+// encode appends the JSON encoding of records, which should be as returned by
+// a Selecter or by flatten, to the buffer. Other types are encoded with
+// encoding/json.
+func (e *recordsEncoder) encode(records interface{}) error {
+	switch records := records.(type) {
+	case map[string][]common.KeyScoreMember:
+		return e.encodeMap(records)
+	case []common.KeyScoreMember:
+		e.buf.Grow(encodedSize(records))
+		return e.encodeSlice(records)
+	default:
+		buf, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		e.buf.Write(buf)
+		return nil
+	}
+}
+
+func (e *recordsEncoder) encodeMap(m map[string][]common.KeyScoreMember) error {
+	if m == nil {
+		e.buf.WriteString("null")
+		return nil
+	}
+
+	// Like encoding/json, sort the keys, so the output is deterministic.
+	size := 2
+	for key, records := range m {
+		e.keys = append(e.keys, key)
+		size += len(key) + 4 + encodedSize(records)
+	}
+	sort.Strings(e.keys)
+	e.buf.Grow(size)
+
+	e.buf.WriteByte('{')
+	for i, key := range e.keys {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		quoted, err := json.Marshal(key) // one allocation per key, for correct escaping
+		if err != nil {
+			return err
+		}
+		e.buf.Write(quoted)
+		e.buf.WriteByte(':')
+		if err := e.encodeSlice(m[key]); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte('}')
+	return nil
+}
+
+func (e *recordsEncoder) encodeSlice(records []common.KeyScoreMember) error {
+	if records == nil {
+		e.buf.WriteString("null")
+		return nil
+	}
+	e.buf.WriteByte('[')
+	for i, ksm := range records {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		if err := e.encodeKeyScoreMember(ksm); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteByte(']')
+	return nil
+}
+
+// encodeKeyScoreMember writes the same bytes as KeyScoreMember.MarshalJSON.
+func (e *recordsEncoder) encodeKeyScoreMember(ksm common.KeyScoreMember) error {
+	if math.IsNaN(ksm.Score) || math.IsInf(ksm.Score, 0) {
+		return fmt.Errorf("unsupported score %v for %q", ksm.Score, ksm.Key)
+	}
+	e.buf.WriteString(`{"key":"`)
+	e.writeBase64(ksm.Key)
+	e.buf.WriteString(`","score":`)
+	e.writeFloat(ksm.Score)
+	e.buf.WriteString(`,"member":"`)
+	e.writeBase64(ksm.Member)
+	e.buf.WriteString(`"}`)
+	return nil
+}
+
+func (e *recordsEncoder) writeBase64(s string) {
+	n := base64.StdEncoding.EncodedLen(len(s))
+	if cap(e.scratch) < n+len(s) {
+		e.scratch = make([]byte, 0, 2*(n+len(s)))
+	}
+	src := append(e.scratch[:0], s...)
+	dst := e.scratch[len(s) : len(s)+n]
+	base64.StdEncoding.Encode(dst, src)
+	e.buf.Write(dst)
+}
+
+// writeFloat formats f the way encoding/json does.
+func (e *recordsEncoder) writeFloat(f float64) {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b := strconv.AppendFloat(e.scratch[:0], f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9, as encoding/json does.
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	e.scratch = b[:0]
+	e.buf.Write(b)
+}
+
+// encodedSize estimates the size of the JSON encoding of records, so that
+// the buffer can be grown once, up front.
+func encodedSize(records []common.KeyScoreMember) int {
+	size := 2
+	for _, ksm := range records {
+		size += 48 + base64.StdEncoding.EncodedLen(len(ksm.Key)) + base64.StdEncoding.EncodedLen(len(ksm.Member))
+	}
+	return size
+}
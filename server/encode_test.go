@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestEncodeMatchesEncodingJSON(t *testing.T) {
+	records := []common.KeyScoreMember{}
+	for _, score := range []float64{
+		0, 1, -1, 0.1, 123.456, 1e20, 1e21, 1e-6, 1e-7, -1e-7, 1.5e300,
+		math.MaxFloat64, math.SmallestNonzeroFloat64, float64(1 << 53),
+	} {
+		records = append(records, common.KeyScoreMember{Key: "foo", Score: score, Member: "abc"})
+	}
+	records = append(records,
+		common.KeyScoreMember{Key: "", Score: 1, Member: ""},
+		common.KeyScoreMember{Key: "<&>\"\\\x00\xff", Score: 2, Member: "\xfe\x00é"},
+	)
+
+	for _, input := range []interface{}{
+		records,
+		[]common.KeyScoreMember{},
+		[]common.KeyScoreMember(nil),
+		map[string][]common.KeyScoreMember{
+			"foo":           records,
+			"bar":           []common.KeyScoreMember{},
+			"nil":           nil,
+			"<&>\"\x00\xff": records[:1],
+		},
+		map[string][]common.KeyScoreMember{},
+		map[string][]common.KeyScoreMember(nil),
+		map[string]int{"other": 1}, // falls back to encoding/json
+	} {
+		expected, err := json.Marshal(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := getEncoder()
+		if err := e.encode(input); err != nil {
+			t.Fatal(err)
+		}
+		if got := e.buf.String(); string(expected) != got {
+			t.Errorf("%T:\nexpected %s\n     got %s", input, expected, got)
+		}
+		putEncoder(e)
+	}
+}
+
+func TestEncodeInvalidScore(t *testing.T) {
+	for _, score := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		e := getEncoder()
+		if err := e.encode([]common.KeyScoreMember{{Key: "foo", Score: score, Member: "abc"}}); err == nil {
+			t.Errorf("%v: expected error, got none", score)
+		}
+		putEncoder(e)
+	}
+}
+
+func benchmarkRecords(keys, perKey int) map[string][]common.KeyScoreMember {
+	m := map[string][]common.KeyScoreMember{}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("timeline:%d", i)
+		for j := 0; j < perKey; j++ {
+			m[key] = append(m[key], common.KeyScoreMember{
+				Key:    key,
+				Score:  float64(1400000000 + j),
+				Member: fmt.Sprintf("track:%d:%d", i, j),
+			})
+		}
+	}
+	return m
+}
+
+// BenchmarkEncodeJSON is the baseline: encoding/json, as used previously.
+func BenchmarkEncodeJSON(b *testing.B) {
+	records := benchmarkRecords(10, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(records); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeRecords(b *testing.B) {
+	records := benchmarkRecords(10, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := getEncoder()
+		if err := e.encode(records); err != nil {
+			b.Fatal(err)
+		}
+		putEncoder(e)
+	}
+}
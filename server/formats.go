@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// format is an encoding of request and response bodies. JSON is the default;
+// high-volume clients may use msgpack or protobuf instead, which are cheaper
+// to encode and decode, as keys and members are raw bytes.
+type format int
+
+const (
+	formatJSON format = iota
+	formatMsgpack
+	formatProtobuf
+)
+
+var formatsByMediaType = map[string]format{
+	"application/json":       formatJSON,
+	"application/msgpack":    formatMsgpack,
+	"application/x-msgpack":  formatMsgpack,
+	"application/protobuf":   formatProtobuf,
+	"application/x-protobuf": formatProtobuf,
+}
+
+func (f format) mediaType() string {
+	switch f {
+	case formatMsgpack:
+		return "application/msgpack"
+	case formatProtobuf:
+		return "application/x-protobuf"
+	default:
+		return "application/json"
+	}
+}
+
+// bodyFormat returns the format of the request body, by its Content-Type.
+// Bodies without one, or with any other, are JSON, as clients like curl send
+// form content types by default.
+func bodyFormat(r *http.Request) format {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return formatJSON
+	}
+	return formatsByMediaType[mediaType]
+}
+
+// responseFormat returns the format of the response to the request: the
+// first format the Accept header names, or else the format of the body.
+// Quality values are ignored.
+func responseFormat(r *http.Request) format {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if f, ok := formatsByMediaType[mediaType]; ok {
+			return f
+		}
+	}
+	return bodyFormat(r)
+}
+
+// decodeTuples decodes the body of inserts and deletes: tuples, in the
+// format.
+func decodeTuples(f format, body io.Reader) ([]common.KeyScoreMember, error) {
+	if f == formatJSON {
+		var tuples []common.KeyScoreMember
+		err := json.NewDecoder(body).Decode(&tuples)
+		return tuples, err
+	}
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if f == formatMsgpack {
+		return decodeMsgpackTuples(buf)
+	}
+	return decodeProtoTuples(buf)
+}
+
+// decodeKeys decodes the body of selects: keys, in the format.
+func decodeKeys(f format, body io.Reader) ([][]byte, error) {
+	if f == formatJSON {
+		var keys [][]byte
+		err := json.NewDecoder(body).Decode(&keys)
+		return keys, err
+	}
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if f == formatMsgpack {
+		return decodeMsgpackKeys(buf)
+	}
+	return decodeProtoKeys(buf)
+}
+
+// decodedBody holds the body of a request, once decoded by the handler, so
+// that middleware can inspect it without reading and decoding it again.
+type decodedBody struct {
+	decoded bool
+	tuples  []common.KeyScoreMember
+	keys    [][]byte
+	err     error
+}
+
+type decodedBodyKey struct{}
+
+// withDecodedBody returns the request with a decodedBody, which is filled in
+// by requestTuples or requestKeys. A decodedBody installed by an outer
+// middleware is reused.
+func withDecodedBody(r *http.Request) (*http.Request, *decodedBody) {
+	if body, ok := r.Context().Value(decodedBodyKey{}).(*decodedBody); ok {
+		return r, body
+	}
+	body := &decodedBody{}
+	return r.WithContext(context.WithValue(r.Context(), decodedBodyKey{}, body)), body
+}
+
+// requestTuples decodes the tuples in the body of the request, or returns
+// them if they were decoded before.
+func requestTuples(r *http.Request) ([]common.KeyScoreMember, error) {
+	body, ok := r.Context().Value(decodedBodyKey{}).(*decodedBody)
+	if !ok {
+		return decodeTuples(bodyFormat(r), r.Body)
+	}
+	if !body.decoded {
+		body.tuples, body.err = decodeTuples(bodyFormat(r), r.Body)
+		body.decoded = true
+	}
+	return body.tuples, body.err
+}
+
+// addRequestTuples records tuples which the handler decoded from the body of
+// the request by other means than requestTuples, e.g. as they stream in, so
+// that middleware can inspect them.
+func addRequestTuples(r *http.Request, tuples ...common.KeyScoreMember) {
+	if body, ok := r.Context().Value(decodedBodyKey{}).(*decodedBody); ok {
+		body.tuples = append(body.tuples, tuples...)
+		body.decoded = true
+	}
+}
+
+// requestKeys decodes the keys in the body of the request, or returns them
+// if they were decoded before.
+func requestKeys(r *http.Request) ([][]byte, error) {
+	body, ok := r.Context().Value(decodedBodyKey{}).(*decodedBody)
+	if !ok {
+		return decodeKeys(bodyFormat(r), r.Body)
+	}
+	if !body.decoded {
+		body.keys, body.err = decodeKeys(bodyFormat(r), r.Body)
+		body.decoded = true
+	}
+	return body.keys, body.err
+}
+
+// selectFormat returns the format of the response to a Select with the
+// records. Only tuples, as returned by a Selecter or by flatten, have other
+// encodings than JSON.
+func selectFormat(r *http.Request, records interface{}) format {
+	switch records.(type) {
+	case map[string][]common.KeyScoreMember, []common.KeyScoreMember:
+		return responseFormat(r)
+	default:
+		return formatJSON
+	}
+}
+
+// writeMsgpackSelected writes a Select response as a msgpack map of the
+// duration, the encoded records, and the fields, sorted by name. Fields which
+// can't be encoded are left out.
+func writeMsgpackSelected(w io.Writer, records []byte, duration time.Duration, fields map[string]interface{}) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		rest  bytes.Buffer
+		mw    = &msgpackWriter{buf: &rest}
+		count = 2
+	)
+	for _, name := range names {
+		mark := rest.Len()
+		mw.str(name)
+		if err := mw.value(fields[name]); err != nil {
+			rest.Truncate(mark)
+			continue
+		}
+		count++
+	}
+
+	var head bytes.Buffer
+	mw.buf = &head
+	mw.mapLen(count)
+	mw.str("duration")
+	mw.str(duration.String())
+	mw.str("records")
+	w.Write(head.Bytes())
+	w.Write(records)
+	w.Write(rest.Bytes())
+}
+
+// writeProtoSelected writes a Select response as a SelectResponse message
+// with the duration, the encoded records, and the fields it defines.
+func writeProtoSelected(w io.Writer, records []byte, duration time.Duration, fields map[string]interface{}) {
+	var (
+		buf bytes.Buffer
+		pw  = &protoWriter{buf: &buf}
+	)
+	pw.bytes(1, duration.String())
+	buf.Write(records)
+	pw.fields(fields)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestDecodeMsgpackTuples(t *testing.T) {
+	// [{"key": "foo", "score": 1, "member": bin("a"), "extra": [nil]}, {"score": -1.5}]
+	body := []byte{0x92,
+		0x84,
+		0xa3, 'k', 'e', 'y', 0xa3, 'f', 'o', 'o',
+		0xa5, 's', 'c', 'o', 'r', 'e', 0x01,
+		0xa6, 'm', 'e', 'm', 'b', 'e', 'r', 0xc4, 0x01, 'a',
+		0xa5, 'e', 'x', 't', 'r', 'a', 0x91, 0xc0,
+		0x81,
+		0xa5, 's', 'c', 'o', 'r', 'e', 0xcb, 0xbf, 0xf8, 0, 0, 0, 0, 0, 0,
+	}
+	tuples, err := decodeMsgpackTuples(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}, {Score: -1.5}}
+	if !reflect.DeepEqual(expected, tuples) {
+		t.Errorf("expected %v, got %v", expected, tuples)
+	}
+
+	for i := range body {
+		if _, err := decodeMsgpackTuples(body[:i]); err == nil {
+			t.Errorf("truncated to %d bytes: expected error, got none", i)
+		}
+	}
+}
+
+func TestDecodeProtoTuples(t *testing.T) {
+	// Tuples{tuples: [{key: "foo", score: 1.5, member: "a"}]}, with an
+	// unknown varint field 7.
+	body := []byte{0x0a, 0x11,
+		0x0a, 0x03, 'f', 'o', 'o',
+		0x11, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f,
+		0x1a, 0x01, 'a',
+		0x38, 0x96, 0x01,
+	}
+	tuples, err := decodeProtoTuples(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.KeyScoreMember{{Key: "foo", Score: 1.5, Member: "a"}}
+	if !reflect.DeepEqual(expected, tuples) {
+		t.Errorf("expected %v, got %v", expected, tuples)
+	}
+
+	if _, err := decodeProtoTuples(body[:10]); err == nil {
+		t.Errorf("truncated: expected error, got none")
+	}
+}
+
+func TestFormats(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	do := func(method, contentType, accept string, body []byte) []byte {
+		req, _ := http.NewRequest(method, server.URL+"?limit=2", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: expected %d, got %d", method, contentType, http.StatusOK, resp.StatusCode)
+		}
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return buf
+	}
+
+	// Insert with msgpack.
+	var buf bytes.Buffer
+	(&msgpackWriter{buf: &buf}).tuples([]common.KeyScoreMember{{Key: "baz", Score: 1, Member: "\x00\xff"}})
+	do("POST", "application/msgpack", "", buf.Bytes())
+
+	// Select with protobuf, and decode the pages.
+	buf.Reset()
+	pw := &protoWriter{buf: &buf}
+	pw.bytes(1, "foo")
+	pw.bytes(1, "baz")
+	r := &protoReader{b: do("GET", "application/x-protobuf", "", buf.Bytes())}
+	pages := map[string][]common.KeyScoreMember{}
+	truncated := map[string]bool{}
+	for len(r.b) > 0 {
+		number, wireType, err := r.field()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if number != 2 && number != 4 {
+			r.skip(wireType)
+			continue
+		}
+		message, _ := r.bytes()
+		m := &protoReader{b: message}
+		var key string
+		for len(m.b) > 0 {
+			field, wireType, _ := m.field()
+			switch {
+			case field == 1:
+				b, _ := m.bytes()
+				key = string(b)
+			case number == 2 && field == 2:
+				b, _ := m.bytes()
+				ksm, err := decodeProtoKeyScoreMember(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pages[key] = append(pages[key], ksm)
+			case number == 4 && field == 2:
+				n, _ := m.varint()
+				truncated[key] = n == 1
+			default:
+				m.skip(wireType)
+			}
+		}
+	}
+	if expected := map[string][]common.KeyScoreMember{
+		"foo": {{Key: "foo", Score: 789, Member: "ghi"}, {Key: "foo", Score: 456, Member: "def"}},
+		"baz": {{Key: "baz", Score: 1, Member: "\x00\xff"}},
+	}; !reflect.DeepEqual(expected, pages) {
+		t.Errorf("protobuf: expected %v, got %v", expected, pages)
+	}
+	if expected := map[string]bool{"foo": true, "baz": false}; !reflect.DeepEqual(expected, truncated) {
+		t.Errorf("protobuf: expected truncated %v, got %v", expected, truncated)
+	}
+
+	// Select with a JSON body, accepting msgpack, and decode the records.
+	mr := &msgpackReader{b: do("GET", "application/json", "application/msgpack", []byte(`["YmF6"]`))}
+	n, err := mr.mapLen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []common.KeyScoreMember
+	for i := 0; i < n; i++ {
+		name, _ := mr.bytes()
+		if string(name) != "records" {
+			mr.skip()
+			continue
+		}
+		keys, _ := mr.mapLen()
+		for j := 0; j < keys; j++ {
+			mr.bytes()
+			if records, err = mr.tuples(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if expected := []common.KeyScoreMember{{Key: "baz", Score: 1, Member: "\x00\xff"}}; !reflect.DeepEqual(expected, records) {
+		t.Errorf("msgpack: expected %v, got %v", expected, records)
+	}
+}
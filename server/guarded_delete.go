@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+// guardedDeleter is implemented by farm.Farm, and used for deletes with the
+// guarded parameter set.
+type guardedDeleter interface {
+	DeleteIf([]cluster.GuardedDelete) ([]bool, error)
+}
+
+// guardedTuple is one element of the body of a guarded delete: a tuple, as
+// for other deletes, and the score the member is expected to have.
+type guardedTuple struct {
+	Key      []byte   `json:"key"`
+	Score    float64  `json:"score"`
+	Member   []byte   `json:"member"`
+	Expected *float64 `json:"expected"`
+}
+
+// guardedDeletedJSON is the response to a guarded delete.
+type guardedDeletedJSON struct {
+	Deleted  int    `json:"deleted"`
+	Applied  []bool `json:"applied"`
+	Duration string `json:"duration"`
+}
+
+// deleteGuarded applies the guarded deletes in the body, a JSON array, and
+// reports whether each was applied, in the same order.
+func deleteGuarded(w http.ResponseWriter, r *http.Request, d guardedDeleter, began time.Time) {
+	var body []guardedTuple
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+		return
+	}
+	var (
+		deletes = make([]cluster.GuardedDelete, len(body))
+		tuples  = make([]common.KeyScoreMember, len(body))
+	)
+	for i, t := range body {
+		tuples[i] = common.KeyScoreMember{Key: string(t.Key), Score: t.Score, Member: string(t.Member)}
+		if t.Expected == nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("tuple %d: no expected score", i))
+			return
+		}
+		deletes[i] = cluster.GuardedDelete{KeyScoreMember: tuples[i], Expected: *t.Expected}
+	}
+	addRequestTuples(r, tuples...)
+
+	applied, err := d.DeleteIf(deletes)
+	if err != nil {
+		respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+		return
+	}
+	requestUsage(r).written(r.Context(), tuples)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(guardedDeletedJSON{
+		Deleted:  len(deletes),
+		Applied:  applied,
+		Duration: time.Since(began).String(),
+	})
+}
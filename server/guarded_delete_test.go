@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+func TestGuardedDelete(t *testing.T) {
+	fake := clustertest.New()
+	if err := fake.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 5, Member: "a"},
+		{Key: "foo", Score: 8, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r := pat.New()
+	r.Delete("/", handleDelete(farm.New([]cluster.Cluster{fake}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	del := func(url, body string) *http.Response {
+		req, err := http.NewRequest("DELETE", server.URL+url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// foo:a and foo:b, both expected at score 5.
+	resp := del("/?guarded=true", `[{"key":"Zm9v","score":10,"member":"YQ==","expected":5},{"key":"Zm9v","score":10,"member":"Yg==","expected":5}]`)
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+	var response struct {
+		Applied []bool `json:"applied"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{true, false}, response.Applied; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	for url, body := range map[string]string{
+		"/?guarded=true":              `[{"key":"Zm9v","score":10,"member":"Yg=="}]`,
+		"/?guarded=true&verbose=true": `[{"key":"Zm9v","score":10,"member":"Yg==","expected":8}]`,
+	} {
+		resp := del(url, body)
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%s %s: expected %d, got %d", url, body, expected, got)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+// healthReporter is implemented by farms with a cluster health registry,
+// like *farm.Farm.
+type healthReporter interface {
+	Health() []farm.ClusterHealth
+}
+
+// healthJSON is the response to a health request.
+type healthJSON struct {
+	Clusters []farm.ClusterHealth `json:"clusters"`
+}
+
+var healthDoc = apiDoc{
+	summary:  "Report the latency, error rate, and cost of every cluster",
+	response: healthJSON{},
+}
+
+// handleHealth reports the state of every cluster in the health registry of
+// the farm. It responds 404 if cluster health isn't tracked.
+func handleHealth(h healthReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := h.Health()
+		if health == nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusNotFound, fmt.Errorf("cluster health not tracked"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(healthJSON{Clusters: health})
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+// instanceHealthJSON is the health of a roshi-server, as seen through its
+// clusters, for external routing layers choosing between instances.
+type instanceHealthJSON struct {
+	Instance  string               `json:"instance"`
+	Time      time.Time            `json:"time"`
+	Healthy   bool                 `json:"healthy"`         // whether a write quorum of clusters is available
+	Available int                  `json:"available"`       // clusters out of maintenance, with an error rate below the max
+	Quorum    int                  `json:"quorum"`          // the write quorum
+	Score     time.Duration        `json:"score,omitempty"` // cost of the quorum-th healthiest available cluster; lower is healthier
+	Clusters  []farm.ClusterHealth `json:"clusters"`
+}
+
+var instanceHealthDoc = apiDoc{
+	summary:  "Report the health of this instance, for load balancers; 503 if fewer than a write quorum of clusters are available",
+	response: instanceHealthJSON{},
+}
+
+// healthExporter reports the health of the instance, from the health
+// registry of its farm.
+type healthExporter struct {
+	health       healthReporter
+	instance     string
+	quorum       string // as -farm.write.quorum
+	maxErrorRate float64
+}
+
+// report returns the health of the instance, or false if cluster health
+// isn't tracked. A cluster is available if it's out of maintenance and its
+// error rate is below the max. Clusters which were never observed are
+// available, with no cost. The instance is healthy if a write quorum of
+// clusters is available; its score is then the cost of the slowest of the
+// healthiest quorum of them, i.e. what a write, or a read which needs a
+// quorum, is expected to wait for.
+func (e *healthExporter) report() (instanceHealthJSON, bool) {
+	health := e.health.Health()
+	if health == nil {
+		return instanceHealthJSON{}, false
+	}
+	report := instanceHealthJSON{
+		Instance: e.instance,
+		Time:     time.Now().UTC(),
+		Clusters: health,
+	}
+	if quorum, err := evaluateScalarPercentage(e.quorum, len(health)); err == nil {
+		report.Quorum = quorum
+	}
+
+	costs := []time.Duration{}
+	for _, h := range health {
+		if !h.Maintenance && h.ErrorRate < e.maxErrorRate {
+			costs = append(costs, h.Cost)
+		}
+	}
+	sort.Slice(costs, func(i, j int) bool { return costs[i] < costs[j] })
+	report.Available = len(costs)
+	if report.Quorum > 0 && report.Available >= report.Quorum {
+		report.Healthy = true
+		report.Score = costs[report.Quorum-1]
+	}
+	return report, true
+}
+
+// handleInstanceHealth reports the health of the instance. It responds 503
+// if the instance is unhealthy, so that load balancers which only check the
+// status code can take it out of rotation, and 404 if cluster health isn't
+// tracked.
+func handleInstanceHealth(e *healthExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, ok := e.report()
+		if !ok {
+			respondError(w, r.Method, r.URL.String(), http.StatusNotFound, fmt.Errorf("cluster health not tracked"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// push POSTs the health of the instance to the URL every interval, forever.
+// Failures are logged, and the next report is pushed as usual.
+func (e *healthExporter) push(url string, interval time.Duration) {
+	client := &http.Client{Timeout: interval}
+	for range time.Tick(interval) {
+		if err := e.pushOnce(client, url); err != nil {
+			log.Printf("pushing health to %s: %s", url, err)
+		}
+	}
+}
+
+// pushOnce POSTs the current health of the instance to the URL.
+func (e *healthExporter) pushOnce(client *http.Client, url string) error {
+	report, ok := e.report()
+	if !ok {
+		return fmt.Errorf("cluster health not tracked")
+	}
+	buf, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health sink returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+)
+
+func TestInstanceHealth(t *testing.T) {
+	var (
+		healthy = fixedHealth{
+			{Index: 0, Observed: true, Latency: time.Millisecond, Cost: time.Millisecond},
+			{Index: 1, Observed: true, Latency: 3 * time.Millisecond, ErrorRate: 0.1, Cost: 4 * time.Millisecond},
+			{Index: 2, Observed: true, Latency: time.Millisecond, ErrorRate: 0.9, Cost: 10 * time.Millisecond},
+		}
+		degraded = fixedHealth{
+			{Index: 0, Observed: true, Latency: time.Millisecond, Cost: time.Millisecond},
+			{Index: 1, Maintenance: true},
+			{Index: 2, Observed: true, Latency: time.Millisecond, ErrorRate: 0.9, Cost: 10 * time.Millisecond},
+		}
+	)
+	for _, testCase := range []struct {
+		health    fixedHealth
+		expected  int
+		available int
+		score     time.Duration
+	}{
+		{healthy, http.StatusOK, 2, 4 * time.Millisecond},
+		{degraded, http.StatusServiceUnavailable, 1, 0},
+		{nil, http.StatusNotFound, 0, 0},
+	} {
+		r := pat.New()
+		r.Get("/admin/health/instance", handleInstanceHealth(&healthExporter{
+			health:       testCase.health,
+			instance:     "roshi-1",
+			quorum:       "51%",
+			maxErrorRate: 0.5,
+		}))
+		server := httptest.NewServer(r)
+		resp, err := http.Get(server.URL + "/admin/health/instance")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := testCase.expected, resp.StatusCode; expected != got {
+			t.Errorf("expected %d, got %d", expected, got)
+		}
+		if testCase.health != nil {
+			var report instanceHealthJSON
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Instance != "roshi-1" || report.Quorum != 2 || report.Available != testCase.available || report.Score != testCase.score || len(report.Clusters) != 3 {
+				t.Errorf("unexpected report %+v", report)
+			}
+		}
+		resp.Body.Close()
+		server.Close()
+	}
+}
+
+func TestPushInstanceHealth(t *testing.T) {
+	reports := make(chan instanceHealthJSON, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report instanceHealthJSON
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reports <- report
+	}))
+	defer sink.Close()
+
+	e := &healthExporter{
+		health:       fixedHealth{{Index: 0, Observed: true, Cost: time.Millisecond}},
+		instance:     "roshi-1",
+		quorum:       "1",
+		maxErrorRate: 0.5,
+	}
+	if err := e.pushOnce(http.DefaultClient, sink.URL); err != nil {
+		t.Fatal(err)
+	}
+	if report := <-reports; !report.Healthy || report.Score != time.Millisecond {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	e.health = fixedHealth(nil)
+	if err := e.pushOnce(http.DefaultClient, sink.URL); err == nil {
+		t.Errorf("expected an error without cluster health, got none")
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+type fixedHealth []farm.ClusterHealth
+
+func (h fixedHealth) Health() []farm.ClusterHealth { return h }
+
+func TestHealth(t *testing.T) {
+	health := fixedHealth{
+		{Index: 0, Observed: true, Latency: time.Millisecond, ErrorRate: 0.5, Cost: 2 * time.Millisecond},
+		{Index: 1},
+	}
+	for _, testCase := range []struct {
+		health   fixedHealth
+		expected int
+	}{
+		{health, http.StatusOK},
+		{nil, http.StatusNotFound},
+	} {
+		r := pat.New()
+		r.Get("/admin/health", handleHealth(testCase.health))
+		server := httptest.NewServer(r)
+		resp, err := http.Get(server.URL + "/admin/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := testCase.expected, resp.StatusCode; expected != got {
+			t.Errorf("expected %d, got %d", expected, got)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var response struct {
+				Clusters []farm.ClusterHealth `json:"clusters"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if expected, got := []farm.ClusterHealth(testCase.health), response.Clusters; !reflect.DeepEqual(expected, got) {
+				t.Errorf("expected %+v, got %+v", expected, got)
+			}
+		}
+		resp.Body.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+const (
+	defaultInspectLimit = 100
+	maxInspectLimit     = 1000
+)
+
+// inspector is implemented by farms which can report the raw state of a key
+// in each cluster, like *farm.Farm.
+type inspector interface {
+	Inspect(key string, limit int) ([]cluster.KeyState, error)
+}
+
+// keyStateJSON is cluster.KeyState, with the error as a string.
+type keyStateJSON struct {
+	cluster.KeyState
+	Error string `json:"error,omitempty"`
+}
+
+// inspectJSON is the response to an inspect request.
+type inspectJSON struct {
+	Key      string         `json:"key"`
+	Limit    int            `json:"limit"`
+	Clusters []keyStateJSON `json:"clusters"`
+}
+
+var inspectDoc = apiDoc{
+	summary: "Report the inserts and deletes sets of a key in each cluster",
+	params: []apiParam{
+		{"key", "string", "The key, not base64-encoded"},
+		{"limit", "integer", "Members of each set to report"},
+	},
+	response: inspectJSON{},
+}
+
+// handleInspect reports the inserts and deletes sets of the key parameter in
+// each cluster, with their scores, so engineers can diagnose divergence and
+// tombstones without access to the Redis instances. The key is taken as is,
+// not base64-encoded. Up to the limit parameter members of each set are
+// returned, default 100, at most 1000.
+func handleInspect(i inspector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		if _, ok := r.Form["key"]; !ok {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("key required"))
+			return
+		}
+		key := r.Form.Get("key")
+
+		limit := defaultInspectLimit
+		if s := r.Form.Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxInspectLimit {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid limit %q (must be 1 to %d)", s, maxInspectLimit))
+				return
+			}
+		}
+
+		states, err := i.Inspect(key, limit)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		clusters := make([]keyStateJSON, len(states))
+		for j, state := range states {
+			clusters[j] = keyStateJSON{KeyState: state}
+			if state.Err != nil {
+				clusters[j].Error = state.Err.Error()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(inspectJSON{Key: key, Limit: limit, Clusters: clusters})
+	}
+}
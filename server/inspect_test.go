@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+)
+
+type fixedStates map[string][]cluster.KeyState
+
+func (s fixedStates) Inspect(key string, limit int) ([]cluster.KeyState, error) {
+	states := make([]cluster.KeyState, len(s[key]))
+	for i, state := range s[key] {
+		if len(state.Inserts) > limit {
+			state.Inserts = state.Inserts[:limit]
+		}
+		if len(state.Deletes) > limit {
+			state.Deletes = state.Deletes[:limit]
+		}
+		states[i] = state
+	}
+	return states, nil
+}
+
+func TestInspect(t *testing.T) {
+	r := pat.New()
+	r.Get("/debug/key", handleInspect(fixedStates{"foo:bar": {
+		{
+			Address:     "10.0.0.1:6379",
+			InsertCount: 2,
+			DeleteCount: 1,
+			Inserts:     []common.KeyScoreMember{{Key: "foo:bar", Score: 3, Member: "c"}, {Key: "foo:bar", Score: 1, Member: "a"}},
+			Deletes:     []common.KeyScoreMember{{Key: "foo:bar", Score: 2, Member: "b"}},
+		},
+		{Address: "10.0.1.1:6379", Err: errors.New("connection refused")},
+	}}))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(query string) *http.Response {
+		resp, err := http.Get(server.URL + "/debug/key?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("key=" + url.QueryEscape("foo:bar") + "&limit=1")
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+	var response struct {
+		Key      string `json:"key"`
+		Clusters []struct {
+			Address     string                  `json:"address"`
+			InsertCount int                     `json:"insert_count"`
+			Inserts     []common.KeyScoreMember `json:"inserts"`
+			Deletes     []common.KeyScoreMember `json:"deletes"`
+			Error       string                  `json:"error"`
+		} `json:"clusters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "foo:bar", response.Key; expected != got {
+		t.Errorf("expected key %q, got %q", expected, got)
+	}
+	if expected, got := 2, len(response.Clusters); expected != got {
+		t.Fatalf("expected %d clusters, got %d", expected, got)
+	}
+	first := response.Clusters[0]
+	if expected, got := 2, first.InsertCount; expected != got {
+		t.Errorf("expected %d inserts, got %d", expected, got)
+	}
+	if expected, got := []common.KeyScoreMember{{Key: "foo:bar", Score: 3, Member: "c"}}, first.Inserts; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected inserts %v, got %v", expected, got)
+	}
+	if expected, got := []common.KeyScoreMember{{Key: "foo:bar", Score: 2, Member: "b"}}, first.Deletes; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected deletes %v, got %v", expected, got)
+	}
+	if expected, got := "connection refused", response.Clusters[1].Error; expected != got {
+		t.Errorf("expected error %q, got %q", expected, got)
+	}
+
+	for _, query := range []string{"", "key=foo&limit=0", "key=foo&limit=1001", "key=foo&limit=x"} {
+		resp := get(query)
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%q: expected %d, got %d", query, expected, got)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// concurrencyLimit caps the requests of an endpoint which are served at
+// once. Requests beyond the cap wait in a queue of bounded depth, in no
+// particular order, and requests beyond that are rejected. Each endpoint
+// class has its own limit, so that a storm of one class can't crowd out the
+// others. It's safe for concurrent use.
+type concurrencyLimit struct {
+	name     string
+	admitted chan struct{} // served or queued
+	inFlight chan struct{} // served
+}
+
+// newConcurrencyLimit returns a limit serving at most maxInFlight requests at
+// once, with at most queueDepth more waiting. Zero or a negative maxInFlight
+// means no limit, and nil is returned.
+func newConcurrencyLimit(name string, maxInFlight, queueDepth int) *concurrencyLimit {
+	if maxInFlight <= 0 {
+		return nil
+	}
+	if queueDepth < 0 {
+		queueDepth = 0
+	}
+	return &concurrencyLimit{
+		name:     name,
+		admitted: make(chan struct{}, maxInFlight+queueDepth),
+		inFlight: make(chan struct{}, maxInFlight),
+	}
+}
+
+// limited wraps the handler, so that its requests are subject to the limit.
+// Requests beyond the queue are rejected with 429 Too Many Requests. Queued
+// requests whose clients go away are dropped. A nil limit serves every
+// request.
+func limited(l *concurrencyLimit, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.admitted <- struct{}{}:
+			defer func() { <-l.admitted }()
+		default:
+			respondError(w, r.Method, r.URL.String(), http.StatusTooManyRequests, fmt.Errorf("too many concurrent %s requests", l.name))
+			return
+		}
+		select {
+		case l.inFlight <- struct{}{}:
+			defer func() { <-l.inFlight }()
+		case <-r.Context().Done():
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	var (
+		l       = newConcurrencyLimit("insert", 1, 1)
+		started = make(chan struct{})
+		release = make(chan struct{})
+		h       = limited(l, func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+		})
+		serve = func() int {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", "http://localhost:6302/", nil)
+			h(w, r)
+			return w.Code
+		}
+	)
+
+	// One request is served, and one queued.
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() { codes <- serve() }()
+	}
+	<-started
+	for len(l.admitted) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so further requests are rejected.
+	if expected, got := http.StatusTooManyRequests, serve(); expected != got {
+		t.Errorf("expected %d, got %d", expected, got)
+	}
+
+	// The queued request is served once the first one completes.
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if expected, got := http.StatusOK, <-codes; expected != got {
+			t.Errorf("expected %d, got %d", expected, got)
+		}
+	}
+
+	// Zero means no limit.
+	if l := newConcurrencyLimit("select", 0, 10); l != nil {
+		t.Errorf("expected no limit, got %+v", l)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation; the others follow it.
+const listenFDsStart = 3
+
+// listenFDs returns how many sockets systemd passed to this process, from
+// the LISTEN_PID and LISTEN_FDS environment variables. Sockets passed to
+// another process, e.g. the parent of a wrapper script, aren't ours.
+func listenFDs(pid, fds string, self int) (int, error) {
+	if pid == "" || pid != strconv.Itoa(self) {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	return n, nil
+}
+
+// systemdListeners returns the listeners of the sockets passed by systemd
+// socket activation, or none, if the process wasn't socket activated. The
+// environment variables are unset, so that child processes don't take the
+// sockets for their own.
+func systemdListeners() ([]net.Listener, error) {
+	n, err := listenFDs(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getpid())
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("systemd socket %d", fd))
+		l, err := net.FileListener(f) // a dup, so f can be closed
+		f.Close()
+		if err != nil {
+			closeAll(listeners)
+			return nil, fmt.Errorf("systemd socket %d: %s", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenAll listens on each of the comma-separated TCP addresses, e.g.
+// "0.0.0.0:6302,[::]:6302". If any address can't be bound, the listeners
+// already bound are closed.
+func listenAll(addresses string) ([]net.Listener, error) {
+	listeners := []net.Listener{}
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) <= 0 {
+		return nil, fmt.Errorf("no listen address")
+	}
+	return listeners, nil
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// serve serves HTTP, or HTTPS with the TLS configuration of the server, on
+// each of the listeners, until any of them fails, and returns its error. On
+// a signal from stop, it shuts the server down gracefully instead, waiting
+// at most timeout for the requests in flight, and returns nil once they're
+// done, so that the caller can close whatever the handlers write to.
+func serve(server *http.Server, listeners []net.Listener, stop <-chan os.Signal, timeout time.Duration) error {
+	var (
+		errc   = make(chan error, len(listeners))
+		useTLS = server.TLSConfig != nil // Serve may set it, for HTTP/2
+	)
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			if useTLS {
+				errc <- server.ServeTLS(l, "", "")
+				return
+			}
+			errc <- server.Serve(l)
+		}(l)
+	}
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-stop:
+		log.Printf("%s: shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
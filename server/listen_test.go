@@ -0,0 +1,81 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenFDs(t *testing.T) {
+	for i, testCase := range []struct {
+		pid, fds string
+		expected int
+		err      bool
+	}{
+		{"", "", 0, false},    // not socket activated
+		{"41", "2", 0, false}, // another process
+		{"42", "2", 2, false},
+		{"42", "0", 0, false},
+		{"42", "", 0, true},
+		{"42", "-1", 0, true},
+		{"42", "two", 0, true},
+	} {
+		n, err := listenFDs(testCase.pid, testCase.fds, 42)
+		if testCase.err != (err != nil) || n != testCase.expected {
+			t.Errorf("%d: LISTEN_PID=%q LISTEN_FDS=%q: expected %d (error %v), got %d (%v)", i, testCase.pid, testCase.fds, testCase.expected, testCase.err, n, err)
+		}
+	}
+}
+
+func TestListenAll(t *testing.T) {
+	listeners, err := listenAll("127.0.0.1:0, 127.0.0.1:0,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 2, len(listeners); expected != got {
+		t.Fatalf("expected %d listeners, got %d", expected, got)
+	}
+	defer closeAll(listeners)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	var (
+		stop    = make(chan os.Signal)
+		stopped = make(chan error)
+	)
+	go func() { stopped <- serve(server, listeners, stop, time.Second) }()
+	for _, l := range listeners {
+		resp, err := http.Get("http://" + l.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Errorf("%s: expected ok, got %q", l.Addr(), body)
+		}
+	}
+
+	// The address in use fails the whole set.
+	if _, err := listenAll("127.0.0.1:0," + listeners[0].Addr().String()); err == nil {
+		t.Errorf("expected an error for an address in use, got none")
+	}
+	for _, addresses := range []string{"", " , "} {
+		if _, err := listenAll(addresses); err == nil {
+			t.Errorf("%q: expected an error, got none", addresses)
+		}
+	}
+
+	// A signal shuts the server down, on all listeners.
+	stop <- syscall.SIGTERM
+	if err := <-stopped; err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+	if _, err := http.Get("http://" + listeners[1].Addr().String() + "/"); err == nil {
+		t.Errorf("expected the listener to be closed")
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+// maintainer is implemented by farms whose clusters can be put into
+// maintenance, like *farm.Farm.
+type maintainer interface {
+	SetMaintenance(index int, on bool) error
+	Maintenance() []bool
+	MaintenanceRepairs() map[int]farm.MaintenanceRepair
+}
+
+// maintenanceJSON is the response to a maintenance request: whether each
+// cluster is in maintenance, and the progress of the repair of each which is
+// leaving it, by index.
+type maintenanceJSON struct {
+	Maintenance []bool                         `json:"maintenance"`
+	Repairs     map[int]farm.MaintenanceRepair `json:"repairs,omitempty"`
+}
+
+var (
+	maintenanceDoc = apiDoc{
+		summary:  "Report which clusters are in maintenance",
+		response: maintenanceJSON{},
+	}
+	setMaintenanceDoc = apiDoc{
+		summary: "Put a cluster into or out of maintenance",
+		params: []apiParam{
+			{"cluster", "integer", "Index of the cluster"},
+			{"enabled", "boolean", "Whether the cluster is in maintenance"},
+		},
+		response: maintenanceJSON{},
+	}
+)
+
+// handleMaintenance reports which clusters are in maintenance on GET, and
+// puts the cluster parameter into or out of maintenance on POST via the
+// enabled parameter. A cluster taken out of maintenance is repaired in the
+// background, and reported as in maintenance until it's done.
+func handleMaintenance(m maintainer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			index, err := strconv.Atoi(r.FormValue("cluster"))
+			if err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid cluster %q", r.FormValue("cluster")))
+				return
+			}
+			enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid enabled %q", r.FormValue("enabled")))
+				return
+			}
+			if err := m.SetMaintenance(index, enabled); err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+				return
+			}
+			log.Printf("maintenance of cluster %d %s", index, map[bool]string{true: "enabled", false: "disabled"}[enabled])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(maintenanceJSON{
+			Maintenance: m.Maintenance(),
+			Repairs:     m.MaintenanceRepairs(),
+		})
+	}
+}
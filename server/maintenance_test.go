@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+type fixedMaintainer []bool
+
+func (m fixedMaintainer) SetMaintenance(index int, on bool) error {
+	if index < 0 || index >= len(m) {
+		return fmt.Errorf("no cluster %d", index)
+	}
+	m[index] = on
+	return nil
+}
+
+func (m fixedMaintainer) Maintenance() []bool { return m }
+
+func (m fixedMaintainer) MaintenanceRepairs() map[int]farm.MaintenanceRepair {
+	return map[int]farm.MaintenanceRepair{}
+}
+
+func TestMaintenance(t *testing.T) {
+	m := fixedMaintainer{false, false, false}
+	handler := handleMaintenance(m)
+
+	for _, testCase := range []struct {
+		method, query string
+		code          int
+		expected      []bool
+	}{
+		{"GET", "", http.StatusOK, []bool{false, false, false}},
+		{"POST", "cluster=1&enabled=true", http.StatusOK, []bool{false, true, false}},
+		{"POST", "cluster=3&enabled=true", http.StatusBadRequest, nil},
+		{"POST", "cluster=x&enabled=true", http.StatusBadRequest, nil},
+		{"POST", "cluster=1&enabled=maybe", http.StatusBadRequest, nil},
+		{"GET", "cluster=2&enabled=true", http.StatusOK, []bool{false, true, false}},
+		{"POST", "cluster=1&enabled=false", http.StatusOK, []bool{false, false, false}},
+	} {
+		req, _ := http.NewRequest(testCase.method, "/admin/maintenance?"+testCase.query, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != testCase.code {
+			t.Errorf("%s %s: expected %d, got %d", testCase.method, testCase.query, testCase.code, w.Code)
+			continue
+		}
+		if testCase.expected == nil {
+			continue
+		}
+		var response struct {
+			Maintenance []bool `json:"maintenance"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(testCase.expected, response.Maintenance) {
+			t.Errorf("%s %s: expected %v, got %v", testCase.method, testCase.query, testCase.expected, response.Maintenance)
+		}
+	}
+}
+
+// repairingMaintainer is a fixedMaintainer with a cluster leaving
+// maintenance.
+type repairingMaintainer struct{ fixedMaintainer }
+
+func (m repairingMaintainer) MaintenanceRepairs() map[int]farm.MaintenanceRepair {
+	return map[int]farm.MaintenanceRepair{1: {KeyMembers: 3000, Repaired: 1000}}
+}
+
+func TestMaintenanceRepairs(t *testing.T) {
+	handler := handleMaintenance(repairingMaintainer{fixedMaintainer{false, true, false}})
+	req, _ := http.NewRequest("GET", "/admin/maintenance", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	var response maintenanceJSON
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if repair, ok := response.Repairs[1]; !ok || repair.KeyMembers != 3000 || repair.Repaired != 1000 {
+		t.Errorf("expected the progress of the repair of cluster 1, got %s", w.Body)
+	}
+}
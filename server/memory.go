@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+const (
+	maxMemoryKeys    = 1000
+	defaultMemoryTop = 10
+)
+
+// memoryEstimator is implemented by farms which can estimate the memory used
+// by keys in each cluster, like *farm.Farm.
+type memoryEstimator interface {
+	MemoryUsage(keys []string) ([][]cluster.KeyMemory, error)
+}
+
+// keyMemoryJSON is the memory used by a key in each cluster, in bytes. Bytes
+// is the most used by any cluster, i.e. the footprint of a single copy.
+type keyMemoryJSON struct {
+	Key      string              `json:"key"`
+	Bytes    int64               `json:"bytes"`
+	Clusters []clusterMemoryJSON `json:"clusters"`
+}
+
+// clusterMemoryJSON is cluster.KeyMemory, with the error as a string.
+type clusterMemoryJSON struct {
+	Address     string `json:"address"`
+	InsertBytes int64  `json:"insert_bytes"`
+	DeleteBytes int64  `json:"delete_bytes"`
+	Error       string `json:"error,omitempty"`
+}
+
+// memoryJSON is the response to a memory request.
+type memoryJSON struct {
+	Keys       int             `json:"keys"`
+	TotalBytes int64           `json:"total_bytes"`
+	Top        []keyMemoryJSON `json:"top"`
+}
+
+var memoryDoc = apiDoc{
+	summary:  "Estimate the memory used by keys in each cluster",
+	params:   []apiParam{{"top", "integer", "How many of the keys using the most memory to report"}},
+	request:  [][]byte{},
+	response: memoryJSON{},
+}
+
+// handleMemory estimates the memory used by the keys of the body in each
+// cluster, and reports the top parameter keys using the most, largest first,
+// default 10, along with the total of every key. It's meant for capacity
+// planning, and for finding abusively large keys among candidates, like the
+// keys of the heaviest users. The body holds the keys like that of a select
+// of many keys, up to maxMemoryKeys.
+func handleMemory(m memoryEstimator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		top, _ := parseInt(r.Form, "top", defaultMemoryTop)
+		if top < 1 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid top %d", top))
+			return
+		}
+
+		stream, err := newKeyStream(r)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		keys := []string{}
+		for {
+			key, err := stream.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("key %d: %s", len(keys), err))
+				return
+			}
+			if len(keys) >= maxMemoryKeys {
+				respondError(w, r.Method, r.URL.String(), http.StatusRequestEntityTooLarge, fmt.Errorf("more than the max of %d keys", maxMemoryKeys))
+				return
+			}
+			keys = append(keys, string(key))
+		}
+		if len(keys) <= 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("no keys"))
+			return
+		}
+
+		usage, err := m.MemoryUsage(keys)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		var (
+			perKey = make([]keyMemoryJSON, len(keys))
+			total  int64
+		)
+		for i, key := range keys {
+			perKey[i] = keyMemoryJSON{Key: key, Clusters: make([]clusterMemoryJSON, len(usage))}
+			for j := range usage {
+				u := usage[j][i]
+				perKey[i].Clusters[j] = clusterMemoryJSON{
+					Address:     u.Address,
+					InsertBytes: u.InsertBytes,
+					DeleteBytes: u.DeleteBytes,
+				}
+				if u.Err != nil {
+					perKey[i].Clusters[j].Error = u.Err.Error()
+				}
+				if u.Bytes() > perKey[i].Bytes {
+					perKey[i].Bytes = u.Bytes()
+				}
+			}
+			total += perKey[i].Bytes
+		}
+		sort.SliceStable(perKey, func(i, j int) bool { return perKey[i].Bytes > perKey[j].Bytes })
+		if len(perKey) > top {
+			perKey = perKey[:top]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(memoryJSON{Keys: len(keys), TotalBytes: total, Top: perKey})
+	}
+}
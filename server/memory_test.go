@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// fixedMemory estimates the memory of each key as its insert and delete
+// bytes, in two clusters, the second of which is down.
+type fixedMemory map[string][2]int64
+
+func (m fixedMemory) MemoryUsage(keys []string) ([][]cluster.KeyMemory, error) {
+	usage := [][]cluster.KeyMemory{make([]cluster.KeyMemory, len(keys)), make([]cluster.KeyMemory, len(keys))}
+	for i, key := range keys {
+		usage[0][i] = cluster.KeyMemory{Key: key, Address: "a", InsertBytes: m[key][0], DeleteBytes: m[key][1]}
+		usage[1][i] = cluster.KeyMemory{Key: key, Address: "b", Err: errors.New("unavailable")}
+	}
+	return usage, nil
+}
+
+func TestMemory(t *testing.T) {
+	handler := handleMemory(fixedMemory{"small": {100, 0}, "large": {5000, 200}, "medium": {800, 300}})
+
+	req, _ := http.NewRequest("POST", "/admin/memory?top=2", strings.NewReader("small\nlarge\nmedium\nmissing\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Keys       int             `json:"keys"`
+		TotalBytes int64           `json:"total_bytes"`
+		Top        []keyMemoryJSON `json:"top"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Keys != 4 || response.TotalBytes != 6400 {
+		t.Errorf("expected 4 keys of 6400 bytes, got %d of %d", response.Keys, response.TotalBytes)
+	}
+	if expected := []keyMemoryJSON{
+		{Key: "large", Bytes: 5200, Clusters: []clusterMemoryJSON{{Address: "a", InsertBytes: 5000, DeleteBytes: 200}, {Address: "b", Error: "unavailable"}}},
+		{Key: "medium", Bytes: 1100, Clusters: []clusterMemoryJSON{{Address: "a", InsertBytes: 800, DeleteBytes: 300}, {Address: "b", Error: "unavailable"}}},
+	}; !reflect.DeepEqual(expected, response.Top) {
+		t.Errorf("expected top %+v, got %+v", expected, response.Top)
+	}
+
+	for _, testCase := range []struct {
+		path, body string
+		code       int
+	}{
+		{"/admin/memory", "small", http.StatusOK},
+		{"/admin/memory", "", http.StatusBadRequest},
+		{"/admin/memory?top=0", "small", http.StatusBadRequest},
+		{"/admin/memory", strings.Repeat("small\n", maxMemoryKeys+1), http.StatusRequestEntityTooLarge},
+	} {
+		req, _ := http.NewRequest("POST", testCase.path, strings.NewReader(testCase.body))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != testCase.code {
+			t.Errorf("%s %.20q: expected %d, got %d", testCase.path, testCase.body, testCase.code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// Only the subset of msgpack needed for tuples and keys is implemented:
+// requests may use any msgpack type but extensions, and responses use maps,
+// arrays, raw bytes (bin) for keys and members, strings, booleans, and
+// float64 scores. See http://msgpack.org for the format.
+
+// msgpackReader decodes msgpack values from a byte slice.
+type msgpackReader struct {
+	b []byte
+}
+
+var errMsgpackShort = fmt.Errorf("msgpack: unexpected end of input")
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.b) < n {
+		return nil, errMsgpackShort
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b, nil
+}
+
+func (r *msgpackReader) byte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes.
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// length reads the length of an array (fixPrefix 0x90) or map (0x80), with
+// the given 16-bit and 32-bit type bytes.
+func (r *msgpackReader) length(what string, fixPrefix, type16, type32 byte) (int, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	switch {
+	case c&0xf0 == fixPrefix:
+		n = uint64(c & 0x0f)
+	case c == type16:
+		n, err = r.uint(2)
+	case c == type32:
+		n, err = r.uint(4)
+	default:
+		return 0, fmt.Errorf("msgpack: expected %s, got type 0x%02x", what, c)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(r.b)) {
+		return 0, errMsgpackShort // every element takes at least a byte
+	}
+	return int(n), nil
+}
+
+func (r *msgpackReader) arrayLen() (int, error) { return r.length("array", 0x90, 0xdc, 0xdd) }
+func (r *msgpackReader) mapLen() (int, error)   { return r.length("map", 0x80, 0xde, 0xdf) }
+
+// bytes reads a string or bin. The returned slice aliases the input.
+func (r *msgpackReader) bytes() ([]byte, error) {
+	c, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	var n uint64
+	switch {
+	case c&0xe0 == 0xa0:
+		n = uint64(c & 0x1f)
+	case c == 0xd9, c == 0xc4:
+		n, err = r.uint(1)
+	case c == 0xda, c == 0xc5:
+		n, err = r.uint(2)
+	case c == 0xdb, c == 0xc6:
+		n, err = r.uint(4)
+	default:
+		return nil, fmt.Errorf("msgpack: expected string or bin, got type 0x%02x", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.b)) {
+		return nil, errMsgpackShort
+	}
+	return r.next(int(n))
+}
+
+// number reads a float or an integer, as a float64.
+func (r *msgpackReader) number() (float64, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c <= 0x7f:
+		return float64(c), nil
+	case c >= 0xe0:
+		return float64(int8(c)), nil
+	}
+	var n uint64
+	switch c {
+	case 0xca:
+		n, err = r.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err = r.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err = r.uint(1 << (c - 0xcc))
+		return float64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err = r.uint(size)
+		shift := uint(64 - 8*size) // sign-extend
+		return float64(int64(n<<shift) >> shift), err
+	}
+	return 0, fmt.Errorf("msgpack: expected number, got type 0x%02x", c)
+}
+
+// skip reads and discards a value of any type but extensions.
+func (r *msgpackReader) skip() error {
+	if len(r.b) <= 0 {
+		return errMsgpackShort
+	}
+	c := r.b[0]
+	switch {
+	case c <= 0x7f, c >= 0xe0, c == 0xc0, c == 0xc2, c == 0xc3:
+		_, err := r.next(1)
+		return err
+	case c&0xe0 == 0xa0, c == 0xd9, c == 0xda, c == 0xdb, c == 0xc4, c == 0xc5, c == 0xc6:
+		_, err := r.bytes()
+		return err
+	case c == 0xca, c == 0xcb, c >= 0xcc && c <= 0xd3:
+		_, err := r.number()
+		return err
+	case c&0xf0 == 0x90, c == 0xdc, c == 0xdd:
+		n, err := r.arrayLen()
+		for i := 0; err == nil && i < n; i++ {
+			err = r.skip()
+		}
+		return err
+	case c&0xf0 == 0x80, c == 0xde, c == 0xdf:
+		n, err := r.mapLen()
+		for i := 0; err == nil && i < 2*n; i++ {
+			err = r.skip()
+		}
+		return err
+	}
+	return fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
+
+// decodeMsgpackTuples decodes an array of maps with key, score, and member
+// entries, like the JSON body of inserts and deletes, but with keys and
+// members as raw strings or bins. Other entries are ignored.
+func decodeMsgpackTuples(b []byte) ([]common.KeyScoreMember, error) {
+	return (&msgpackReader{b: b}).tuples()
+}
+
+func (r *msgpackReader) tuples() ([]common.KeyScoreMember, error) {
+	n, err := r.arrayLen()
+	if err != nil {
+		return nil, err
+	}
+	tuples := make([]common.KeyScoreMember, n)
+	for i := range tuples {
+		fields, err := r.mapLen()
+		if err != nil {
+			return nil, fmt.Errorf("tuple %d: %s", i, err)
+		}
+		for j := 0; j < fields; j++ {
+			name, err := r.bytes()
+			if err != nil {
+				return nil, fmt.Errorf("tuple %d: %s", i, err)
+			}
+			var value []byte
+			switch string(name) {
+			case "key":
+				value, err = r.bytes()
+				tuples[i].Key = string(value)
+			case "member":
+				value, err = r.bytes()
+				tuples[i].Member = string(value)
+			case "score":
+				tuples[i].Score, err = r.number()
+			default:
+				err = r.skip()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("tuple %d: %s: %s", i, name, err)
+			}
+		}
+	}
+	return tuples, nil
+}
+
+// decodeMsgpackKeys decodes an array of raw strings or bins, like the JSON
+// body of selects.
+func decodeMsgpackKeys(b []byte) ([][]byte, error) {
+	r := &msgpackReader{b: b}
+	n, err := r.arrayLen()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, n)
+	for i := range keys {
+		if keys[i], err = r.bytes(); err != nil {
+			return nil, fmt.Errorf("key %d: %s", i, err)
+		}
+	}
+	return keys, nil
+}
+
+// msgpackWriter encodes msgpack values into a buffer.
+type msgpackWriter struct {
+	buf     *bytes.Buffer
+	scratch [9]byte
+}
+
+// header writes the type byte c, followed by n as a big-endian integer of
+// size bytes.
+func (w *msgpackWriter) header(c byte, n uint64, size int) {
+	w.scratch[0] = c
+	for i := size; i > 0; i-- {
+		w.scratch[i] = byte(n)
+		n >>= 8
+	}
+	w.buf.Write(w.scratch[:1+size])
+}
+
+// length writes the header of an array (fixPrefix 0x90) or map (0x80).
+func (w *msgpackWriter) length(n int, fixPrefix, type16, type32 byte) {
+	switch {
+	case n < 16:
+		w.buf.WriteByte(fixPrefix | byte(n))
+	case n <= math.MaxUint16:
+		w.header(type16, uint64(n), 2)
+	default:
+		w.header(type32, uint64(n), 4)
+	}
+}
+
+func (w *msgpackWriter) arrayLen(n int) { w.length(n, 0x90, 0xdc, 0xdd) }
+func (w *msgpackWriter) mapLen(n int)   { w.length(n, 0x80, 0xde, 0xdf) }
+
+func (w *msgpackWriter) str(s string) {
+	switch n := len(s); {
+	case n < 32:
+		w.buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		w.header(0xd9, uint64(n), 1)
+	case n <= math.MaxUint16:
+		w.header(0xda, uint64(n), 2)
+	default:
+		w.header(0xdb, uint64(n), 4)
+	}
+	w.buf.WriteString(s)
+}
+
+func (w *msgpackWriter) bin(s string) {
+	switch n := len(s); {
+	case n <= math.MaxUint8:
+		w.header(0xc4, uint64(n), 1)
+	case n <= math.MaxUint16:
+		w.header(0xc5, uint64(n), 2)
+	default:
+		w.header(0xc6, uint64(n), 4)
+	}
+	w.buf.WriteString(s)
+}
+
+func (w *msgpackWriter) float(f float64) {
+	w.scratch[0] = 0xcb
+	binary.BigEndian.PutUint64(w.scratch[1:], math.Float64bits(f))
+	w.buf.Write(w.scratch[:9])
+}
+
+func (w *msgpackWriter) tuples(records []common.KeyScoreMember) {
+	w.arrayLen(len(records))
+	for _, ksm := range records {
+		w.mapLen(3)
+		w.str("key")
+		w.bin(ksm.Key)
+		w.str("score")
+		w.float(ksm.Score)
+		w.str("member")
+		w.bin(ksm.Member)
+	}
+}
+
+// records encodes Select results, i.e. a map of keys, as bins, to their
+// tuples, or the tuples of a coalesced Select. Keys are sorted, so the
+// output is deterministic.
+func (w *msgpackWriter) records(records interface{}) error {
+	switch records := records.(type) {
+	case map[string][]common.KeyScoreMember:
+		keys := make([]string, 0, len(records))
+		for key := range records {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w.mapLen(len(keys))
+		for _, key := range keys {
+			w.bin(key)
+			w.tuples(records[key])
+		}
+	case []common.KeyScoreMember:
+		w.tuples(records)
+	default:
+		return fmt.Errorf("can't encode %T as msgpack records", records)
+	}
+	return nil
+}
+
+// value encodes other fields of responses, like truncation flags and
+// cursors, as their JSON encoding would be decoded into an interface{}:
+// maps, arrays, strings, numbers, booleans, and nil. They're small, so the
+// round trip through JSON doesn't matter.
+func (w *msgpackWriter) value(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(buf, &generic); err != nil {
+		return err
+	}
+	w.generic(generic)
+	return nil
+}
+
+func (w *msgpackWriter) generic(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w.mapLen(len(keys))
+		for _, key := range keys {
+			w.str(key)
+			w.generic(v[key])
+		}
+	case []interface{}:
+		w.arrayLen(len(v))
+		for _, e := range v {
+			w.generic(e)
+		}
+	case string:
+		w.str(v)
+	case float64:
+		w.float(v)
+	case bool:
+		if v {
+			w.buf.WriteByte(0xc3)
+		} else {
+			w.buf.WriteByte(0xc2)
+		}
+	default:
+		w.buf.WriteByte(0xc0)
+	}
+}
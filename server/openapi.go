@@ -0,0 +1,311 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// apiDoc describes a route of the API, for its OpenAPI description. Request
+// and response are values of the types of the JSON bodies, which are
+// described by reflection, as encoding/json encodes them.
+type apiDoc struct {
+	summary  string
+	params   []apiParam
+	request  interface{} // nil for no body
+	response interface{}
+}
+
+// apiParam is a query parameter of a route. Its type is a JSON schema type:
+// string, integer, number, or boolean.
+type apiParam struct {
+	name, typ, description string
+}
+
+// apiRoute is a route registered with an api.
+type apiRoute struct {
+	method, path string
+	apiDoc
+}
+
+// api registers handlers with the router, and records their routes, so that
+// the description served at /openapi.json is generated from the routes the
+// server actually serves. It isn't safe for concurrent use; routes must be
+// registered before the server starts.
+type api struct {
+	router *pat.Router
+	routes []apiRoute
+}
+
+func newAPI(router *pat.Router) *api {
+	return &api{router: router}
+}
+
+func (a *api) get(path string, handler http.HandlerFunc, doc apiDoc) {
+	a.handle("GET", path, handler, doc)
+}
+
+func (a *api) post(path string, handler http.HandlerFunc, doc apiDoc) {
+	a.handle("POST", path, handler, doc)
+}
+
+func (a *api) delete(path string, handler http.HandlerFunc, doc apiDoc) {
+	a.handle("DELETE", path, handler, doc)
+}
+
+func (a *api) handle(method, path string, handler http.HandlerFunc, doc apiDoc) {
+	a.router.Add(method, path, handler)
+	a.routes = append(a.routes, apiRoute{method: method, path: path, apiDoc: doc})
+}
+
+// handleOpenAPI serves the OpenAPI 3.0 description of the routes of the api.
+// It's generated on the first request, when every route is registered.
+func handleOpenAPI(a *api, version string) http.HandlerFunc {
+	var (
+		once sync.Once
+		buf  []byte
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { buf, _ = json.Marshal(openAPI(a.routes, version)) })
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(buf)
+	}
+}
+
+// openAPI returns the OpenAPI 3.0 description of the routes.
+func openAPI(routes []apiRoute, version string) map[string]interface{} {
+	var (
+		s     = newSchemas()
+		paths = map[string]map[string]interface{}{}
+	)
+	for _, route := range routes {
+		operation := map[string]interface{}{
+			"summary":   route.summary,
+			"responses": s.responses(route.response),
+		}
+		if len(route.params) > 0 {
+			params := make([]map[string]interface{}, len(route.params))
+			for i, param := range route.params {
+				params[i] = map[string]interface{}{
+					"name":        param.name,
+					"in":          "query",
+					"description": param.description,
+					"schema":      map[string]interface{}{"type": param.typ},
+				}
+			}
+			operation["parameters"] = params
+		}
+		if route.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": s.of(reflect.TypeOf(route.request))},
+				},
+			}
+		}
+		if paths[route.path] == nil {
+			paths[route.path] = map[string]interface{}{}
+		}
+		paths[route.path][strings.ToLower(route.method)] = operation
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "roshi-server",
+			"version": version,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": s.components},
+	}
+}
+
+// jsonSchema is a JSON schema object.
+type jsonSchema map[string]interface{}
+
+// schemas generates the JSON schemas of Go types. Structs are described once
+// as components, and referenced wherever they're used.
+type schemas struct {
+	components map[string]jsonSchema
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{
+		components: map[string]jsonSchema{},
+		names:      map[reflect.Type]string{},
+	}
+}
+
+// encodedAs maps types which implement json.Marshaler to types with the
+// same JSON encoding.
+var encodedAs = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(common.KeyScoreMember{}): reflect.TypeOf(tupleJSON{}),
+}
+
+// tupleJSON is the JSON encoding of common.KeyScoreMember.
+type tupleJSON struct {
+	Key    []byte  `json:"key"`
+	Score  float64 `json:"score"`
+	Member []byte  `json:"member"`
+}
+
+var (
+	byteSliceType = reflect.TypeOf([]byte{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	timeType      = reflect.TypeOf(time.Time{})
+)
+
+// responses returns the responses of an operation whose successful response
+// has the type of the value: either that, or an error.
+func (s *schemas) responses(response interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "OK",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": s.of(reflect.TypeOf(response))},
+			},
+		},
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": s.of(reflect.TypeOf(errorJSON{}))},
+			},
+		},
+	}
+}
+
+// of returns the schema of the type, as encoding/json encodes it.
+func (s *schemas) of(t reflect.Type) jsonSchema {
+	if t == nil {
+		return jsonSchema{}
+	}
+	if encoded, ok := encodedAs[t]; ok {
+		return s.ref(t, encoded)
+	}
+	switch t {
+	case byteSliceType:
+		return jsonSchema{"type": "string", "format": "byte"}
+	case durationType:
+		return jsonSchema{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case timeType:
+		return jsonSchema{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.of(t.Elem())
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonSchema{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return jsonSchema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number", "format": "double"}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		return s.ref(t, t)
+	default:
+		return jsonSchema{} // any value
+	}
+}
+
+// ref returns a reference to the component describing the type, whose
+// encoding is that of the struct, adding it if necessary.
+func (s *schemas) ref(t, encoded reflect.Type) jsonSchema {
+	name, ok := s.names[t]
+	if !ok {
+		name = s.name(t)
+		s.names[t] = name
+		s.components[name] = nil // reserved, for recursive types
+		s.components[name] = s.object(encoded)
+	}
+	return jsonSchema{"$ref": "#/components/schemas/" + name}
+}
+
+// name returns an unused component name for the type: its name, without a
+// JSON suffix, capitalized, and qualified by its package if it's taken.
+func (s *schemas) name(t reflect.Type) string {
+	name := capitalize(strings.TrimSuffix(t.Name(), "JSON"))
+	if name == "" {
+		name = "Anonymous"
+	}
+	if _, taken := s.components[name]; taken {
+		pkg := t.PkgPath()
+		name = capitalize(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+	}
+	base := name
+	for i := 2; ; i++ {
+		if _, taken := s.components[name]; !taken {
+			return name
+		}
+		name = base + strconv.Itoa(i)
+	}
+}
+
+// object returns the schema of the struct type, with the fields of embedded
+// structs inlined. Fields without omitempty are required.
+func (s *schemas) object(t reflect.Type) jsonSchema {
+	var (
+		properties = map[string]jsonSchema{}
+		required   = []string{}
+	)
+	var fields func(t reflect.Type)
+	fields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options := tag, ""
+			if i := strings.Index(tag, ","); i >= 0 {
+				name, options = tag[:i], tag[i+1:]
+			}
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				fields(field.Type)
+				continue
+			}
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = s.of(field.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	fields(t)
+	schema := jsonSchema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestOpenAPI(t *testing.T) {
+	var (
+		r   = pat.New()
+		api = newAPI(r)
+	)
+	r.Get("/openapi.json", handleOpenAPI(api, "1.2.3"))
+	api.get("/admin/readonly", handleReadOnly(&readOnly{}), readOnlyDoc)
+	api.post("/admin/readonly", handleReadOnly(&readOnly{}), setReadOnlyDoc)
+	api.get("/", handleSelect(newMockFarm(), 0, nil, nil), selectDoc)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// Routes registered with the api are served.
+	resp, err := http.Get(server.URL + "/admin/readonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+
+	resp, err = http.Get(server.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]struct {
+			Parameters  []map[string]interface{} `json:"parameters"`
+			RequestBody map[string]interface{}   `json:"requestBody"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+				Required   []string                          `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Info.Version != "1.2.3" {
+		t.Errorf("expected OpenAPI 3.0.3 of version 1.2.3, got %s of %s", doc.OpenAPI, doc.Info.Version)
+	}
+	if len(doc.Paths) != 2 || len(doc.Paths["/admin/readonly"]) != 2 || len(doc.Paths["/"]) != 1 {
+		t.Fatalf("expected the registered routes, got %+v", doc.Paths)
+	}
+	if expected, got := len(selectDoc.params), len(doc.Paths["/"]["get"].Parameters); expected != got {
+		t.Errorf("expected %d select parameters, got %d", expected, got)
+	}
+	if doc.Paths["/"]["get"].RequestBody == nil {
+		t.Error("expected a select request body")
+	}
+
+	// Tuples are described as they're encoded, with base64 keys and members.
+	tuple, ok := doc.Components.Schemas["KeyScoreMember"]
+	if !ok {
+		t.Fatalf("expected a KeyScoreMember schema, got %v", doc.Components.Schemas)
+	}
+	if expected, got := "byte", tuple.Properties["key"]["format"]; expected != got {
+		t.Errorf("expected key format %v, got %v", expected, got)
+	}
+	selected := doc.Components.Schemas["Selected"]
+	if expected, got := []string{"duration", "records", "truncated"}, selected.Required; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected required %v, got %v", expected, got)
+	}
+	for _, name := range []string{"ReadOnly", "Error", "Retry", "ClusterWrites", "ScoreBounds", "CursorPair"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("expected a %s schema", name)
+		}
+	}
+}
+
+func TestSelectedJSON(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{{Key: "foo", Score: 1, Member: "a"}})
+	signer := newCursorSigner("secret", 0)
+	st, _ := newScoreTimes("s")
+	req, _ := http.NewRequest("GET", "/?bounds=set&times=true", strings.NewReader(`["Zm9v"]`))
+	w := httptest.NewRecorder()
+	handleSelect(farm, 0, signer, st)(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// The response is described by selectedJSON, field for field.
+	dec := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+	dec.DisallowUnknownFields()
+	var response selectedJSON
+	if err := dec.Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Duration == "" || len(response.Records["foo"]) != 1 || response.Truncated == nil || response.Cursors == nil || response.Bounds == nil || response.Times == nil {
+		t.Errorf("expected every field, got %+v", response)
+	}
+}
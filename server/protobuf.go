@@ -0,0 +1,379 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// The protobuf messages are defined in roshi.proto. They're encoded and
+// decoded by hand, like the JSON of Select responses, so that tuples cost no
+// allocations beyond their strings. See
+// https://developers.google.com/protocol-buffers/docs/encoding for the wire
+// format.
+
+// Wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// protoReader decodes the fields of a protobuf message from a byte slice.
+type protoReader struct {
+	b []byte
+}
+
+var errProtoShort = fmt.Errorf("protobuf: unexpected end of input")
+
+func (r *protoReader) varint() (uint64, error) {
+	n, size := binary.Uvarint(r.b)
+	if size <= 0 {
+		return 0, errProtoShort
+	}
+	r.b = r.b[size:]
+	return n, nil
+}
+
+// field reads the tag of the next field, i.e. its number and wire type.
+func (r *protoReader) field() (number int, wireType int, err error) {
+	tag, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(tag >> 3), int(tag & 7), nil
+}
+
+// bytes reads a length-delimited value. The returned slice aliases the input.
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.b)) {
+		return nil, errProtoShort
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b, nil
+}
+
+func (r *protoReader) fixed64() (uint64, error) {
+	if len(r.b) < 8 {
+		return 0, errProtoShort
+	}
+	n := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return n, nil
+}
+
+// skip discards the value of a field of the wire type.
+func (r *protoReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case protoVarint:
+		_, err = r.varint()
+	case protoFixed64:
+		_, err = r.fixed64()
+	case protoBytes:
+		_, err = r.bytes()
+	case protoFixed32:
+		if len(r.b) < 4 {
+			return errProtoShort
+		}
+		r.b = r.b[4:]
+	default:
+		err = fmt.Errorf("protobuf: unsupported wire type %d", wireType)
+	}
+	return err
+}
+
+// expect returns an error unless the field has the expected wire type.
+func expect(number, wireType, expected int) error {
+	if wireType != expected {
+		return fmt.Errorf("protobuf: field %d: expected wire type %d, got %d", number, expected, wireType)
+	}
+	return nil
+}
+
+// decodeProtoTuples decodes a Tuples message, the body of inserts and
+// deletes.
+func decodeProtoTuples(b []byte) ([]common.KeyScoreMember, error) {
+	var (
+		r      = &protoReader{b: b}
+		tuples = []common.KeyScoreMember{}
+	)
+	for len(r.b) > 0 {
+		number, wireType, err := r.field()
+		if err != nil {
+			return nil, err
+		}
+		if number != 1 {
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := expect(number, wireType, protoBytes); err != nil {
+			return nil, err
+		}
+		message, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		ksm, err := decodeProtoKeyScoreMember(message)
+		if err != nil {
+			return nil, fmt.Errorf("tuple %d: %s", len(tuples), err)
+		}
+		tuples = append(tuples, ksm)
+	}
+	return tuples, nil
+}
+
+func decodeProtoKeyScoreMember(b []byte) (common.KeyScoreMember, error) {
+	var (
+		r   = &protoReader{b: b}
+		ksm common.KeyScoreMember
+	)
+	for len(r.b) > 0 {
+		number, wireType, err := r.field()
+		if err != nil {
+			return ksm, err
+		}
+		var value []byte
+		switch number {
+		case 1, 3:
+			if err = expect(number, wireType, protoBytes); err == nil {
+				value, err = r.bytes()
+			}
+			if number == 1 {
+				ksm.Key = string(value)
+			} else {
+				ksm.Member = string(value)
+			}
+		case 2:
+			var bits uint64
+			if err = expect(number, wireType, protoFixed64); err == nil {
+				bits, err = r.fixed64()
+			}
+			ksm.Score = math.Float64frombits(bits)
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return ksm, err
+		}
+	}
+	return ksm, nil
+}
+
+// decodeProtoKeys decodes a Keys message, the body of selects.
+func decodeProtoKeys(b []byte) ([][]byte, error) {
+	var (
+		r    = &protoReader{b: b}
+		keys = [][]byte{}
+	)
+	for len(r.b) > 0 {
+		number, wireType, err := r.field()
+		if err != nil {
+			return nil, err
+		}
+		if number != 1 {
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := expect(number, wireType, protoBytes); err != nil {
+			return nil, err
+		}
+		key, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// protoWriter encodes protobuf fields into a buffer.
+type protoWriter struct {
+	buf     *bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (w *protoWriter) varint(n uint64) {
+	w.buf.Write(w.scratch[:binary.PutUvarint(w.scratch[:], n)])
+}
+
+func (w *protoWriter) tag(number, wireType int) {
+	w.varint(uint64(number<<3 | wireType))
+}
+
+func (w *protoWriter) bytes(number int, s string) {
+	w.tag(number, protoBytes)
+	w.varint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *protoWriter) double(number int, f float64) {
+	w.tag(number, protoFixed64)
+	binary.LittleEndian.PutUint64(w.scratch[:8], math.Float64bits(f))
+	w.buf.Write(w.scratch[:8])
+}
+
+func (w *protoWriter) bool(number int, b bool) {
+	w.tag(number, protoVarint)
+	if b {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+// message writes an embedded message, whose fields are written by fields,
+// of the given size.
+func (w *protoWriter) message(number, size int, fields func()) {
+	w.tag(number, protoBytes)
+	w.varint(uint64(size))
+	fields()
+}
+
+func varintSize(n uint64) int {
+	size := 1
+	for n >= 0x80 {
+		n >>= 7
+		size++
+	}
+	return size
+}
+
+// bytesSize is the size of a length-delimited field with a small number.
+func bytesSize(s string) int {
+	return 1 + varintSize(uint64(len(s))) + len(s)
+}
+
+func keyScoreMemberSize(ksm common.KeyScoreMember) int {
+	return bytesSize(ksm.Key) + 9 + bytesSize(ksm.Member)
+}
+
+func (w *protoWriter) keyScoreMember(number int, ksm common.KeyScoreMember) {
+	w.message(number, keyScoreMemberSize(ksm), func() {
+		w.bytes(1, ksm.Key)
+		w.double(2, ksm.Score)
+		w.bytes(3, ksm.Member)
+	})
+}
+
+// records encodes the records of a SelectResponse: a Page per key, sorted,
+// so the output is deterministic, or the tuples of a coalesced Select.
+func (w *protoWriter) records(records interface{}) error {
+	switch records := records.(type) {
+	case map[string][]common.KeyScoreMember:
+		keys := make([]string, 0, len(records))
+		for key := range records {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			page := records[key]
+			size := bytesSize(key)
+			for _, ksm := range page {
+				size += 1 + varintSize(uint64(keyScoreMemberSize(ksm))) + keyScoreMemberSize(ksm)
+			}
+			w.message(2, size, func() {
+				w.bytes(1, key)
+				for _, ksm := range page {
+					w.keyScoreMember(2, ksm)
+				}
+			})
+		}
+	case []common.KeyScoreMember:
+		for _, ksm := range records {
+			w.keyScoreMember(3, ksm)
+		}
+	default:
+		return fmt.Errorf("can't encode %T as protobuf records", records)
+	}
+	return nil
+}
+
+func cursorsSize(pair cursorPair) int {
+	return bytesSize(pair.Next) + bytesSize(pair.Newer)
+}
+
+func (w *protoWriter) cursors(number int, pair cursorPair) {
+	w.message(number, cursorsSize(pair), func() {
+		w.bytes(1, pair.Next)
+		w.bytes(2, pair.Newer)
+	})
+}
+
+func boundsSize(b scoreBounds) int {
+	size := 0
+	for _, f := range []*float64{b.Min, b.Max, b.SetMax} {
+		if f != nil {
+			size += 9
+		}
+	}
+	return size
+}
+
+func (w *protoWriter) bounds(number int, b scoreBounds) {
+	w.message(number, boundsSize(b), func() {
+		for i, f := range []*float64{b.Min, b.Max, b.SetMax} {
+			if f != nil {
+				w.double(i+1, *f)
+			}
+		}
+	})
+}
+
+// fields encodes the fields of a SelectResponse beyond the records: the
+// truncation flags, cursors, and score bounds of each key, as PageInfos,
+// sorted by key, and the cursors of a coalesced page. Other fields aren't
+// part of the message.
+func (w *protoWriter) fields(fields map[string]interface{}) {
+	truncated, _ := fields["truncated"].(map[string]bool)
+	cursors, _ := fields["cursors"].(map[string]cursorPair)
+	bounds, _ := fields["bounds"].(map[string]scoreBounds)
+	keys := make([]string, 0, len(truncated)+len(cursors))
+	for key := range truncated {
+		keys = append(keys, key)
+	}
+	for key := range cursors {
+		if _, ok := truncated[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		size := bytesSize(key) + 2
+		pair, hasCursors := cursors[key]
+		if hasCursors {
+			size += 1 + varintSize(uint64(cursorsSize(pair))) + cursorsSize(pair)
+		}
+		b, hasBounds := bounds[key]
+		if hasBounds {
+			size += 1 + varintSize(uint64(boundsSize(b))) + boundsSize(b)
+		}
+		w.message(4, size, func() {
+			w.bytes(1, key)
+			w.bool(2, truncated[key])
+			if hasCursors {
+				w.cursors(3, pair)
+			}
+			if hasBounds {
+				w.bounds(4, b)
+			}
+		})
+	}
+	if pair, ok := fields["cursor"].(cursorPair); ok {
+		w.cursors(5, pair)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// readOnly is the standby switch of the server. While it's on, inserts and
+// deletes are rejected, and selects are served as usual. It's safe for
+// concurrent use.
+type readOnly struct{ on int32 }
+
+func (m *readOnly) enabled() bool { return atomic.LoadInt32(&m.on) != 0 }
+
+func (m *readOnly) set(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&m.on, v)
+}
+
+// writable wraps a write handler, so that its requests are rejected with 503
+// Service Unavailable while the server is read-only.
+func writable(m *readOnly, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.enabled() {
+			respondError(w, r.Method, r.URL.String(), http.StatusServiceUnavailable, fmt.Errorf("read-only mode"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// readOnlyJSON is the response to a read-only request.
+type readOnlyJSON struct {
+	ReadOnly bool `json:"readonly"`
+}
+
+var (
+	readOnlyDoc = apiDoc{
+		summary:  "Report whether the server is read-only",
+		response: readOnlyJSON{},
+	}
+	setReadOnlyDoc = apiDoc{
+		summary:  "Set whether the server is read-only",
+		params:   []apiParam{{"enabled", "boolean", "Whether inserts and deletes are rejected"}},
+		response: readOnlyJSON{},
+	}
+)
+
+// handleReadOnly reports the read-only mode on GET, and sets it on POST via
+// the enabled parameter.
+func handleReadOnly(m *readOnly) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid enabled %q", r.FormValue("enabled")))
+				return
+			}
+			if enabled != m.enabled() {
+				log.Printf("read-only mode %s", map[bool]string{true: "enabled", false: "disabled"}[enabled])
+			}
+			m.set(enabled)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(readOnlyJSON{ReadOnly: m.enabled()})
+	}
+}
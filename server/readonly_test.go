@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+)
+
+func TestReadOnly(t *testing.T) {
+	m := &readOnly{}
+	r := pat.New()
+	r.Get("/admin/readonly", handleReadOnly(m))
+	r.Post("/admin/readonly", handleReadOnly(m))
+	r.Get("/", handleSelect(newMockFarm(), time.Second, nil, nil))
+	r.Post("/", writable(m, handleInsert(newMockFarm())))
+	r.Delete("/", writable(m, handleDelete(newMockFarm())))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	tuples := `[{"key":"Zm9v","score":1,"member":"YmFy"}]`
+	keys := `["Zm9v"]`
+
+	// Writable by default.
+	for _, method := range []string{"POST", "DELETE"} {
+		if expected, got := http.StatusOK, do(method, "/", tuples); expected != got {
+			t.Errorf("%s: expected %d, got %d", method, expected, got)
+		}
+	}
+
+	// Toggled on, writes are rejected, and selects are served.
+	if expected, got := http.StatusOK, do("POST", "/admin/readonly?enabled=true", ""); expected != got {
+		t.Fatalf("toggle: expected %d, got %d", expected, got)
+	}
+	for _, method := range []string{"POST", "DELETE"} {
+		if expected, got := http.StatusServiceUnavailable, do(method, "/", tuples); expected != got {
+			t.Errorf("%s: expected %d, got %d", method, expected, got)
+		}
+	}
+	if expected, got := http.StatusOK, do("GET", "/", keys); expected != got {
+		t.Errorf("GET: expected %d, got %d", expected, got)
+	}
+
+	resp, err := http.Get(server.URL + "/admin/readonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var state map[string]bool
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if !state["readonly"] {
+		t.Errorf("expected read-only mode to be reported, got %v", state)
+	}
+
+	// Invalid toggles are rejected, and leave the mode alone.
+	if expected, got := http.StatusBadRequest, do("POST", "/admin/readonly?enabled=maybe", ""); expected != got {
+		t.Errorf("invalid toggle: expected %d, got %d", expected, got)
+	}
+	if !m.enabled() {
+		t.Errorf("expected read-only mode to stay enabled")
+	}
+
+	// Toggled off, writes are accepted again.
+	do("POST", "/admin/readonly?enabled=false", "")
+	if expected, got := http.StatusOK, do("POST", "/", tuples); expected != got {
+		t.Errorf("POST: expected %d, got %d", expected, got)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+// maxRepairKeys is how many keys a single repair may cover. Repairs are
+// synchronous, and read every member of each key from every cluster.
+const maxRepairKeys = 100
+
+// repairer is implemented by farms which can repair keys on demand, like
+// *farm.Farm.
+type repairer interface {
+	Repair(keys []string, limit int) ([]farm.ClusterRepair, error)
+}
+
+// clusterRepairJSON is farm.ClusterRepair, with the total and the error as a
+// string.
+type clusterRepairJSON struct {
+	Repaired int    `json:"repaired"`
+	Inserted int    `json:"inserted"`
+	Deleted  int    `json:"deleted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// repairJSON is the response to a repair request.
+type repairJSON struct {
+	Keys     int                 `json:"keys"`
+	Clusters []clusterRepairJSON `json:"clusters"`
+}
+
+var repairDoc = apiDoc{
+	summary:  "Repair keys synchronously",
+	params:   []apiParam{{"limit", "integer", "Members of each key read from each cluster"}},
+	request:  [][]byte{},
+	response: repairJSON{},
+}
+
+// handleRepair repairs the keys of the body synchronously, and reports how
+// many members were repaired in each cluster, for support engineers resolving
+// a reported inconsistency. The body holds the keys like that of a select of
+// many keys: a JSON array of base64 keys, or raw keys separated by newlines,
+// with Content-Type text/plain. Up to the limit parameter members of each key
+// are read from each cluster, default maxSize.
+func handleRepair(rep repairer, maxSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		limit, _ := parseInt(r.Form, "limit", maxSize)
+		if limit < 1 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid limit %d", limit))
+			return
+		}
+
+		stream, err := newKeyStream(r)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		keys := []string{}
+		for {
+			key, err := stream.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("key %d: %s", len(keys), err))
+				return
+			}
+			if len(keys) >= maxRepairKeys {
+				respondError(w, r.Method, r.URL.String(), http.StatusRequestEntityTooLarge, fmt.Errorf("more than the max of %d keys", maxRepairKeys))
+				return
+			}
+			keys = append(keys, string(key))
+		}
+		if len(keys) <= 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("no keys"))
+			return
+		}
+
+		repairs, err := rep.Repair(keys, limit)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusServiceUnavailable, err)
+			return
+		}
+		clusters := make([]clusterRepairJSON, len(repairs))
+		for i, repair := range repairs {
+			clusters[i] = clusterRepairJSON{
+				Repaired: repair.Inserted + repair.Deleted,
+				Inserted: repair.Inserted,
+				Deleted:  repair.Deleted,
+			}
+			if repair.Err != nil {
+				clusters[i].Error = repair.Err.Error()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(repairJSON{Keys: len(keys), Clusters: clusters})
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+type recordingRepairer struct {
+	keys  []string
+	limit int
+}
+
+func (r *recordingRepairer) Repair(keys []string, limit int) ([]farm.ClusterRepair, error) {
+	r.keys, r.limit = keys, limit
+	return []farm.ClusterRepair{
+		{Inserted: 2, Deleted: 1},
+		{Err: errors.New("unavailable")},
+	}, nil
+}
+
+func TestRepair(t *testing.T) {
+	rep := &recordingRepairer{}
+	handler := handleRepair(rep, 500)
+
+	req, _ := http.NewRequest("POST", "/admin/repair", strings.NewReader("user:1\nuser:2\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if expected := []string{"user:1", "user:2"}; !reflect.DeepEqual(expected, rep.keys) || rep.limit != 500 {
+		t.Errorf("expected a repair of %v with limit 500, got %v with limit %d", expected, rep.keys, rep.limit)
+	}
+	var response struct {
+		Keys     int                 `json:"keys"`
+		Clusters []clusterRepairJSON `json:"clusters"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []clusterRepairJSON{
+		{Repaired: 3, Inserted: 2, Deleted: 1},
+		{Error: "unavailable"},
+	}; response.Keys != 2 || !reflect.DeepEqual(expected, response.Clusters) {
+		t.Errorf("expected 2 keys and %+v, got %d and %+v", expected, response.Keys, response.Clusters)
+	}
+
+	for _, testCase := range []struct {
+		path, contentType, body string
+		code                    int
+	}{
+		{"/admin/repair?limit=10", "application/json", `["dXNlcjox"]`, http.StatusOK},
+		{"/admin/repair", "application/json", `[]`, http.StatusBadRequest},
+		{"/admin/repair", "application/json", `{"key": "dXNlcjox"}`, http.StatusBadRequest},
+		{"/admin/repair?limit=0", "text/plain", "user:1", http.StatusBadRequest},
+		{"/admin/repair", "text/plain", strings.Repeat("user:1\n", maxRepairKeys+1), http.StatusRequestEntityTooLarge},
+	} {
+		req, _ := http.NewRequest("POST", testCase.path, strings.NewReader(testCase.body))
+		req.Header.Set("Content-Type", testCase.contentType)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != testCase.code {
+			t.Errorf("%s %s: expected %d, got %d", testCase.path, fmt.Sprintf("%.20q", testCase.body), testCase.code, w.Code)
+		}
+	}
+	if expected := []string{"user:1"}; !reflect.DeepEqual(expected, rep.keys) || rep.limit != 10 {
+		t.Errorf("expected a repair of %v with limit 10, got %v with limit %d", expected, rep.keys, rep.limit)
+	}
+}
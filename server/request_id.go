@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+// requestIDHeader carries the ID of each request. Clients may set it, to
+// correlate their own logs with ours; otherwise an ID is generated. Either
+// way, it's returned in the response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-provided IDs, which are written to logs.
+const maxRequestIDLength = 128
+
+// withRequestID assigns an ID to every request, and sets it on the response
+// before calling the next handler, which can get it via requestID.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestID returns the ID of the request served by w, or the empty string
+// if it has none.
+func requestID(w http.ResponseWriter) string {
+	return w.Header().Get(requestIDHeader)
+}
+
+// validRequestID returns true if the client-provided ID is safe to log:
+// non-empty, not too long, and printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("generating request ID: %s", err)
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// requestIdentifier is implemented by farm.Farm, and used to attribute the
+// log messages and read repairs of selects to the request ID.
+type requestIdentifier interface {
+	WithRequestID(string) farm.Selecter
+}
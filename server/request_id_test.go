@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+)
+
+func TestRequestID(t *testing.T) {
+	r := pat.New()
+	r.Get("/", handleSelect(newMockFarm(), time.Second, nil, nil))
+	server := httptest.NewServer(withRequestID(r))
+	defer server.Close()
+
+	get := func(body, id string) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL, strings.NewReader(body))
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// A valid client-provided ID is honored.
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	resp := get(string(body), "abc-123")
+	resp.Body.Close()
+	if expected, got := "abc-123", resp.Header.Get(requestIDHeader); expected != got {
+		t.Errorf("expected request ID %q, got %q", expected, got)
+	}
+
+	// Otherwise, one is generated.
+	for _, id := range []string{"", "has spaces", strings.Repeat("x", maxRequestIDLength+1)} {
+		resp := get(string(body), id)
+		resp.Body.Close()
+		if got := resp.Header.Get(requestIDHeader); got == "" || got == id {
+			t.Errorf("%q: expected a generated request ID, got %q", id, got)
+		}
+	}
+
+	// Error responses include it.
+	resp = get("not JSON", "abc-456")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected HTTP %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "abc-456", response["request_id"]; expected != got {
+		t.Errorf("expected request ID %q in the error response, got %v", expected, got)
+	}
+}
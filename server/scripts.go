@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// instanceScriptsJSON is cluster.InstanceScripts, with the error as a string.
+type instanceScriptsJSON struct {
+	Address string                  `json:"address"`
+	Drift   []cluster.ScriptVersion `json:"drift,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+// scriptsJSON is the response to a scripts request.
+type scriptsJSON struct {
+	Scripts  []cluster.ScriptVersion `json:"scripts"`
+	Clusters [][]instanceScriptsJSON `json:"clusters"`
+}
+
+var scriptsDoc = apiDoc{
+	summary:  "Report the versions of the Lua scripts, and whether every instance loaded them",
+	response: scriptsJSON{},
+}
+
+// logScripts logs the instances which failed to load the scripts, or which
+// run different versions of them, and returns whether any instance does.
+func logScripts(loaded [][]cluster.InstanceScripts) (drift bool) {
+	for i, instances := range loaded {
+		for _, instance := range instances {
+			if instance.Err != nil {
+				log.Printf("cluster %d: %s: loading scripts: %s", i+1, instance.Address, instance.Err)
+			}
+			for _, version := range instance.Drift {
+				log.Printf("cluster %d: %s: WARNING: %s script %s loaded by another process", i+1, instance.Address, version.Name, version.SHA1)
+				drift = true
+			}
+		}
+	}
+	return drift
+}
+
+// handleScripts reports the versions of the Lua scripts run by this process,
+// and the outcome of loading them onto every instance at startup.
+func handleScripts(loaded [][]cluster.InstanceScripts) http.HandlerFunc {
+	clusters := make([][]instanceScriptsJSON, len(loaded))
+	for i, instances := range loaded {
+		clusters[i] = make([]instanceScriptsJSON, len(instances))
+		for j, instance := range instances {
+			clusters[i][j] = instanceScriptsJSON{Address: instance.Address, Drift: instance.Drift}
+			if instance.Err != nil {
+				clusters[i][j].Error = instance.Err.Error()
+			}
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scriptsJSON{Scripts: cluster.ScriptVersions(), Clusters: clusters})
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+func TestScripts(t *testing.T) {
+	drift := []cluster.ScriptVersion{{Name: "insert", SHA1: "0123456789abcdef0123456789abcdef01234567"}}
+	loaded := [][]cluster.InstanceScripts{
+		{{Address: "10.0.0.1:6379"}, {Address: "10.0.0.2:6379", Drift: drift}},
+		{{Address: "10.0.1.1:6379", Err: fmt.Errorf("connection refused")}},
+	}
+	if !logScripts(loaded) {
+		t.Errorf("expected drift to be reported")
+	}
+
+	w := httptest.NewRecorder()
+	handleScripts(loaded)(w, &http.Request{Method: "GET"})
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+	var response struct {
+		Scripts  []cluster.ScriptVersion `json:"scripts"`
+		Clusters [][]instanceScriptsJSON `json:"clusters"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := cluster.ScriptVersions(), response.Scripts; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected scripts %v, got %v", expected, got)
+	}
+	expected := [][]instanceScriptsJSON{
+		{{Address: "10.0.0.1:6379"}, {Address: "10.0.0.2:6379", Drift: drift}},
+		{{Address: "10.0.1.1:6379", Error: "connection refused"}},
+	}
+	if got := response.Clusters; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
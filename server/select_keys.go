@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// selectKeysConcurrency is how many chunks of a select of many keys may be
+// selected at once. Reading the body waits while they're all in flight.
+const selectKeysConcurrency = 4
+
+// maxKeyLineSize is the size of the longest key a newline-delimited body may
+// hold.
+const maxKeyLineSize = 1024 * 1024
+
+// keyStream reads the keys of a select of many keys from the body as they
+// arrive, so that the first chunks are selected before the rest is read.
+type keyStream interface {
+	next() ([]byte, error) // io.EOF after the last key
+}
+
+// newKeyStream returns a keyStream over the body of the request: a JSON array
+// of base64 keys, as for a select, or, with Content-Type text/plain, raw keys
+// separated by newlines, e.g. a file. Msgpack and protobuf bodies are read as
+// a whole.
+func newKeyStream(r *http.Request) (keyStream, error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/plain" {
+		s := bufio.NewScanner(r.Body)
+		s.Buffer(make([]byte, 4096), maxKeyLineSize)
+		return lineKeys{s}, nil
+	}
+	if f := bodyFormat(r); f != formatJSON {
+		keys, err := decodeKeys(f, r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &sliceKeys{keys}, nil
+	}
+	dec := json.NewDecoder(r.Body)
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if d, ok := t.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("expected an array of keys, got %v", t)
+	}
+	return jsonKeys{dec}, nil
+}
+
+type jsonKeys struct{ dec *json.Decoder }
+
+func (k jsonKeys) next() ([]byte, error) {
+	if !k.dec.More() {
+		if _, err := k.dec.Token(); err != nil { // the closing bracket
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	var key []byte
+	err := k.dec.Decode(&key)
+	return key, err
+}
+
+type lineKeys struct{ s *bufio.Scanner }
+
+func (k lineKeys) next() ([]byte, error) {
+	for k.s.Scan() {
+		if line := k.s.Bytes(); len(line) > 0 {
+			return line, nil
+		}
+	}
+	if err := k.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type sliceKeys struct{ keys [][]byte }
+
+func (k *sliceKeys) next() ([]byte, error) {
+	if len(k.keys) <= 0 {
+		return nil, io.EOF
+	}
+	key := k.keys[0]
+	k.keys = k.keys[1:]
+	return key, nil
+}
+
+var selectKeysDoc = apiDoc{
+	summary: "Select a page of each of many keys",
+	params: []apiParam{
+		{"offset", "integer", "Offset into each key"},
+		{"limit", "integer", "Maximum number of records of each key (default 10)"},
+		{"order", "string", "desc (default) or asc"},
+		{"repair", "boolean", "Whether divergences are repaired (default true)"},
+	},
+	request:  [][]byte{},
+	response: selectedJSON{},
+}
+
+// handleSelectKeys serves a page of each of many keys, more than a select
+// body would reasonably hold, e.g. to build an aggregated feed. The keys are
+// read from the body as they arrive, deduplicated, and selected in chunks of
+// chunkSize keys, a few chunks at a time. Selects of more than maxKeys keys
+// are rejected, unless maxKeys is 0. The offset, limit, order, and repair
+// parameters behave as for a select, and the response is that of a select.
+// If usage is accounted, each chunk is admitted before it's selected, so a
+// tenant which goes over its quota midway is rejected with 429 Too Many
+// Requests.
+func handleSelectKeys(selecter farm.Selecter, maxKeys, chunkSize int, instr instrumentation.InstrumentationV2) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+
+		var (
+			offset, _ = parseInt(r.Form, "offset", 0)
+			limit, _  = parseInt(r.Form, "limit", 10)
+			order, _  = parseStr(r.Form, "order", "desc")
+			repair, _ = parseBool(r.Form, "repair", true)
+		)
+		if offset < 0 || limit < 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("negative offset or limit"))
+			return
+		}
+		if order != "asc" && order != "desc" {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid order %q (must be %q or %q)", order, "asc", "desc"))
+			return
+		}
+
+		selecter := selecter // may be replaced for this request only
+		if identifier, ok := selecter.(requestIdentifier); ok && requestID(w) != "" {
+			selecter = identifier.WithRequestID(requestID(w))
+		}
+		if !repair {
+			exempter, ok := selecter.(repairExempter)
+			if !ok {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("repair-exempt selects not supported"))
+				return
+			}
+			selecter = exempter.WithoutRepairs()
+		}
+		selectOffset := selecter.SelectOffset
+		if order == "asc" {
+			selectOffset = selecter.SelectOffsetAscending
+		}
+
+		keys, err := newKeyStream(r)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		var (
+			seen      = map[string]bool{}
+			chunk     = make([]string, 0, chunkSize)
+			chunks    int
+			pages     = map[string][]common.KeyScoreMember{}
+			selectErr error
+			mtx       sync.Mutex
+			wg        sync.WaitGroup
+			inFlight  = make(chan struct{}, selectKeysConcurrency)
+		)
+		flush := func() error {
+			if len(chunk) <= 0 {
+				return nil
+			}
+			if err := requestUsage(r).admit(r.Context(), "select", chunk); err != nil {
+				return err
+			}
+			chunks++
+			inFlight <- struct{}{}
+			wg.Add(1)
+			go func(keys []string) {
+				defer func() { <-inFlight; wg.Done() }()
+				// One more element than the limit tells whether each key
+				// has more beyond the page.
+				selected, err := selectOffset(keys, offset, limit+1)
+				mtx.Lock()
+				defer mtx.Unlock()
+				if err != nil {
+					if selectErr == nil {
+						selectErr = err
+					}
+					return
+				}
+				for key, page := range selected {
+					pages[key] = page
+				}
+			}(chunk)
+			chunk = make([]string, 0, chunkSize)
+			return nil
+		}
+
+		var (
+			code    int
+			readErr error
+		)
+		for {
+			key, err := keys.next()
+			if err == io.EOF {
+				if err := flush(); err != nil {
+					code, readErr = http.StatusTooManyRequests, err
+				}
+				break
+			}
+			if err != nil {
+				code, readErr = http.StatusBadRequest, fmt.Errorf("key %d: %s", len(seen), err)
+				break
+			}
+			if seen[string(key)] {
+				continue
+			}
+			if maxKeys > 0 && len(seen) >= maxKeys {
+				instr.Count(r.Context(), "select.keys.too_many", 1, instrumentation.Labels{})
+				code, readErr = http.StatusRequestEntityTooLarge, fmt.Errorf("more than the max of %d keys", maxKeys)
+				break
+			}
+			seen[string(key)] = true
+			if chunk = append(chunk, string(key)); len(chunk) >= chunkSize {
+				if err := flush(); err != nil {
+					code, readErr = http.StatusTooManyRequests, err
+					break
+				}
+			}
+		}
+		wg.Wait()
+		if readErr != nil {
+			respondError(w, r.Method, r.URL.String(), code, readErr)
+			return
+		}
+		if selectErr != nil {
+			respondError(w, r.Method, r.URL.String(), errorCode(selectErr), selectErr)
+			return
+		}
+
+		instr.Count(r.Context(), "select.keys.key", len(seen), instrumentation.Labels{})
+		instr.Count(r.Context(), "select.keys.chunk", chunks, instrumentation.Labels{})
+		respondSelectedPages(w, r, pages, truncate(pages, limit), nil, false, nil, nil, began)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func TestSelectKeys(t *testing.T) {
+	farm := newMockFarm()
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key%d", i)
+		farm.Insert([]common.KeyScoreMember{
+			{Key: key, Score: 1, Member: "a"},
+			{Key: key, Score: 2, Member: "b"},
+		})
+	}
+	handler := handleSelectKeys(farm, 30, 4, instrumentation.NopInstrumentationV2{})
+
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, fmt.Sprintf("key%d", i%25)) // with duplicates
+	}
+	for _, testCase := range []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"newline-delimited", "text/plain; charset=utf-8", strings.Join(lines, "\n") + "\n\n"},
+		{"JSON", "application/json", `["a2V5MA==", "a2V5MjQ=", "a2V5MA==", "bm9uZQ=="]`},
+	} {
+		req, _ := http.NewRequest("POST", "/select/keys?limit=1", strings.NewReader(testCase.body))
+		req.Header.Set("Content-Type", testCase.contentType)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected %d, got %d: %s", testCase.name, http.StatusOK, w.Code, w.Body.String())
+		}
+		var response struct {
+			Records   map[string][]common.KeyScoreMember `json:"records"`
+			Truncated map[string]bool                    `json:"truncated"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		for key, page := range response.Records {
+			if key == "none" {
+				if len(page) != 0 || response.Truncated[key] {
+					t.Errorf("%s: %s: expected an empty page, got %v", testCase.name, key, page)
+				}
+				continue
+			}
+			if len(page) != 1 || page[0].Member != "b" || !response.Truncated[key] {
+				t.Errorf("%s: %s: expected a truncated page of b, got %v", testCase.name, key, page)
+			}
+		}
+		if testCase.name == "JSON" && len(response.Records) != 3 {
+			t.Errorf("%s: expected 3 keys, got %d", testCase.name, len(response.Records))
+		}
+		if testCase.name != "JSON" && len(response.Records) != 25 {
+			t.Errorf("%s: expected 25 keys, got %d", testCase.name, len(response.Records))
+		}
+	}
+
+	// Too many keys, and bad bodies.
+	for _, testCase := range []struct {
+		contentType string
+		body        string
+		code        int
+	}{
+		{"text/plain", "x\ny\nz\nw\nv\nu\n" + strings.Join(lines, "\n"), http.StatusRequestEntityTooLarge}, // 31 distinct keys
+		{"application/json", `{"key": "Zm9v"}`, http.StatusBadRequest},
+		{"application/json", `["Zm9v", 1]`, http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest("POST", "/select/keys", strings.NewReader(testCase.body))
+		req.Header.Set("Content-Type", testCase.contentType)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != testCase.code {
+			t.Errorf("%q: expected %d, got %d", testCase.body, testCase.code, w.Code)
+		}
+	}
+}
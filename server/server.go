@@ -0,0 +1,1896 @@
+// Package server implements roshi-server, which provides a REST-y HTTP
+// service to interact with a farm. It runs as the serve command of roshi.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	_ "expvar"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	_ "net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/archive"
+	"github.com/soundcloud/roshi/audit"
+	"github.com/soundcloud/roshi/backfill"
+	"github.com/soundcloud/roshi/cli"
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+	"github.com/soundcloud/roshi/instrumentation"
+	"github.com/soundcloud/roshi/pool"
+	"github.com/soundcloud/roshi/webhook"
+)
+
+// Run runs roshi-server, as the named command, with the command-line
+// arguments, which don't include the name.
+func Run(name string, args []string) {
+	var (
+		fs                         = cli.NewFlagSet(name)
+		redisFlags                 = cli.RedisFlags(fs, 10)
+		instrumentationFlags       = cli.InstrumentationFlags(fs, "roshiserver")
+		redisReadPoolMCPI          = fs.Int("redis.read.pool.mcpi", 0, "Max connections per Redis instance for selects, in a pool separate from -redis.mcpi, which then only serves writes (0 to share one pool)")
+		redisReadPoolConnect       = fs.Duration("redis.read.pool.connect.timeout", 3*time.Second, "Redis connect timeout of the read pool (with -redis.read.pool.mcpi only)")
+		redisReadPoolRead          = fs.Duration("redis.read.pool.read.timeout", 3*time.Second, "Redis read timeout of the read pool (with -redis.read.pool.mcpi only)")
+		redisReadPoolWrite         = fs.Duration("redis.read.pool.write.timeout", 3*time.Second, "Redis write timeout of the read pool (with -redis.read.pool.mcpi only)")
+		redisScriptsStrict         = fs.Bool("redis.scripts.strict", false, "Refuse to start if any Redis instance had different versions of the Lua scripts loaded by another process, e.g. another version of roshi-server")
+		redisPipelineSize          = fs.Int("redis.pipeline.size", 0, "Max tuples written to a Redis instance in one pipeline; larger writes are split (0 for unlimited)")
+		farmWriteQuorum            = fs.String("farm.write.quorum", "51%", "Write quorum, either number of clusters (2) or percentage of clusters (51%)")
+		healthInstance             = fs.String("health.instance", "", "Name of this instance in health reports at /admin/health/instance (blank for the hostname)")
+		healthMaxErrorRate         = fs.Float64("health.max.error.rate", 0.5, "Error rate (0-1] from which a cluster no longer counts towards the health of this instance")
+		healthPushURL              = fs.String("health.push.url", "", "POST the health of this instance, as served at /admin/health/instance, to this URL every -health.push.interval (blank to disable; requires -farm.health.alpha or -farm.read.prefer.healthy)")
+		healthPushInterval         = fs.Duration("health.push.interval", 10*time.Second, "Interval between health reports pushed to -health.push.url")
+		farmWriteLocalZone         = fs.String("farm.write.local.zone", "", "Local zone, as tagged with @zone in -redis.instances, whose clusters acknowledge writes with concern=local; other clusters are written to in the background (blank to wait for the quorum)")
+		farmWriteLocalAttempts     = fs.Int("farm.write.local.attempts", 5, "Retries of background writes of concern=local writes, per cluster (with -farm.write.local.zone only)")
+		farmWriteLocalBackoff      = fs.Duration("farm.write.local.backoff", time.Second, "Delay before the first retry of a background write, doubling with each retry (with -farm.write.local.zone only)")
+		farmWriteLocalQueue        = fs.Int("farm.write.local.queue", 10000, "Max concern=local writes completed in the background at once; further writes are dropped from the background, and backfilled (with -farm.write.local.zone only)")
+		farmWriteSerialize         = fs.Bool("farm.write.serialize", false, "Apply writes to the same key one after another, in the order they're received, each waiting for every cluster to apply the previous one")
+		farmReadStrategy           = fs.String("farm.read.strategy", "SendAllReadAll", "Farm read strategy: SendAllReadAll, SendOneReadOne, SendAllReadFirstLinger, SendVarReadFirstLinger, PreferLocalZone, SendAllReadDigests; a comma-separated list falls back to each next strategy when the previous one fails")
+		farmReadThresholdRate      = fs.Int("farm.read.threshold.rate", 2000, "Baseline SendAll keys read per sec, additional keys are SendOne (SendVarReadFirstLinger strategy only)")
+		farmReadThresholdLatency   = fs.Duration("farm.read.threshold.latency", 50*time.Millisecond, "If a SendOne read has not returned anything after this latency, it's promoted to SendAll (SendVarReadFirstLinger strategy only)")
+		farmReadZone               = fs.String("farm.read.zone", "", "Local zone, as tagged with @zone in -redis.instances (PreferLocalZone strategy only)")
+		farmReadZoneQuorum         = fs.Int("farm.read.zone.quorum", 1, "Clusters which must respond to a read for a key, before remote zones are read (PreferLocalZone strategy only)")
+		farmSelectWorkers          = fs.Int("farm.select.workers", 0, "Goroutines serving the Selects of each cluster; Selects beyond them wait in a queue (0 for a goroutine per Select)")
+		farmSelectQueue            = fs.Int("farm.select.queue", 1000, "Selects which may wait for the workers of each cluster; Selects beyond them are rejected with HTTP 503 (farm.select.workers only)")
+		farmWarmupKeys             = fs.Int("farm.warmup.keys", 0, "Remember this many recently selected keys, and repair them first when a cluster leaves maintenance, via /admin/maintenance (0 to disable)")
+		farmHealthAlpha            = fs.Float64("farm.health.alpha", 0, "Smoothing factor (0-1] for per-cluster latency and error rate averages of all operations, reported at /admin/health (0 to not track them, unless -farm.read.prefer.healthy is set)")
+		farmReadPreferHealthy      = fs.Float64("farm.read.prefer.healthy", 0, "Smoothing factor (0-1] for per-cluster latency and error rate averages; reads sent to one cluster pick the healthiest (0 to pick randomly)")
+		farmBreakerErrorRate       = fs.Float64("farm.breaker.error.rate", 0, "Error rate (0-1] at which a failing cluster's circuit breaker opens: writes to it fail fast, and reads skip it, for -farm.breaker.cooldown (0 to disable; requires -farm.health.alpha or -farm.read.prefer.healthy)")
+		farmBreakerCooldown        = fs.Duration("farm.breaker.cooldown", 5*time.Second, "How long a cluster's circuit breaker stays open (farm.breaker.error.rate only)")
+		farmQuorumRetryMin         = fs.Duration("farm.quorum.retry.min", 1*time.Second, "Min Retry-After suggested to clients when a write fails quorum")
+		farmQuorumRetryMax         = fs.Duration("farm.quorum.retry.max", 30*time.Second, "Max Retry-After suggested to clients when a write fails quorum, for failed clusters with an error rate of 1 (requires -farm.health.alpha or -farm.read.prefer.healthy)")
+		farmRepairStrategy         = fs.String("farm.repair.strategy", "RateLimitedRepairs", "Farm repair strategy: AllRepairs, NoRepairs, RateLimitedRepairs")
+		farmRepairBatchWindow      = fs.Duration("farm.repair.batch.window", 0, "Merge repair requests arriving within this window into one (0 to issue each immediately)")
+		farmRepairBatchMax         = fs.Int("farm.repair.batch.max", 500, "Max distinct key-members per merged repair request (with -farm.repair.batch.window only)")
+		farmRepairMaxKeysPerSecond = fs.Int("farm.repair.max.keys.per.second", 1000, "Max repaired keys per second (RateLimited repairer only)")
+		farmRepairVerifyDelay      = fs.Duration("farm.repair.verify.delay", 0, "Read repaired members back after this delay, and count those not as repaired as repair.verify_failure (0 to disable)")
+		maxSize                    = fs.Int("max.size", 10000, "Maximum number of events per key")
+		maxSizeOverrides           = fs.String("max.size.overrides", "", "Comma-separated key prefix=size pairs, overriding -max.size for keys with the prefix; the longest matching prefix applies")
+		cacheHotKeys               = fs.Int("cache.hot.keys", 0, "Cache the Selects of up to this many hot keys in memory, invalidated via the client tracking of Redis 6 and later (0 to disable)")
+		memberFilterKeys           = fs.Int("member.filter.keys", 0, "Hold bloom filters of the members of up to this many recently checked keys in memory, so /select/contains finds most absent members without a round-trip, invalidated via the client tracking of Redis 6 and later (0 to disable)")
+		memberFilterMaxMembers     = fs.Int("member.filter.max.members", 10000, "Don't filter keys with more members than this (with -member.filter.keys only)")
+		memberFilterFalsePositive  = fs.Float64("member.filter.false.positive.rate", 0.01, "Target false positive rate (0-1) of the member filters; lower rates take more memory (with -member.filter.keys only)")
+		maxMemberSize              = fs.Int("max.member.size", 0, "Maximum member size in bytes; larger writes are rejected (0 to disable)")
+		writeRewrite               = fs.String("write.rewrite", "", "Comma-separated rules rewriting written tuples, each key:OLD=NEW or member:OLD=NEW, renaming prefixes, for in-band data model migrations (blank to disable)")
+		splitKeys                  = fs.String("split.keys", "", "Comma-separated rules spreading keys with a prefix across subkeys, each PREFIX=N, for keys too hot for one Redis instance (blank to disable)")
+		archiveFile                = fs.String("archive.file", "", "Append successfully inserted tuples to this file as newline-delimited JSON (blank to disable)")
+		archiveFlushInterval       = fs.Duration("archive.flush.interval", 1*time.Second, "How often to flush buffered tuples to the archive file")
+		archiveQueue               = fs.Int("archive.queue", 1024, "How many inserts may wait to be written to the archive file, before their tuples are dropped")
+		webhookURL                 = fs.String("webhook.url", "", "POST batches of the keys modified by successful writes to this URL as JSON (blank to disable)")
+		webhookSecret              = fs.String("webhook.secret", "", "Secret to sign webhook requests with, in the "+webhook.SignatureHeader+" header (blank to not sign them)")
+		webhookTimeout             = fs.Duration("webhook.timeout", 5*time.Second, "Timeout of each webhook request")
+		webhookFlushInterval       = fs.Duration("webhook.flush.interval", 1*time.Second, "How often to send buffered key events to the webhook")
+		webhookBatchSize           = fs.Int("webhook.batch.size", 500, "Max key events per webhook request")
+		webhookRetries             = fs.Int("webhook.retries", 3, "How often to retry failed webhook requests, with exponential backoff, before dropping their events")
+		backfillRedis              = fs.String("backfill.redis", "", "Redis instance to queue the keys in which reads detect divergences, for roshi-walkers with the same flag to repair first (blank to disable)")
+		backfillKey                = fs.String("backfill.key", "roshi:backfill", "Redis key of the backfill queue")
+		backfillMaxKeys            = fs.Int("backfill.max.keys", 100000, "Max keys in the backfill queue; beyond it, the keys found divergent least often are dropped")
+		emptyKeyTTL                = fs.Duration("empty.key.ttl", 0, "Expire keys which only contain deletes after this grace period (0 to disable)")
+		insertDedupWindow          = fs.Float64("insert.dedup.window", 0, "Reject inserts whose score exceeds the stored score of the member by less than this (0 to disable)")
+		insertMaxScoreSkew         = fs.Duration("insert.max.score.skew", 0, "Reject inserts with scores further ahead of the clock than this, so that a producer with a broken clock can't shadow correct writes (0 to disable)")
+		insertScoreUnit            = fs.Duration("insert.score.unit", 1*time.Second, "Unit of scores, counted since the Unix epoch (with -insert.max.score.skew only)")
+		insertScoreSkewClamp       = fs.Bool("insert.score.skew.clamp", false, "Insert scores beyond -insert.max.score.skew with the latest allowed score, rather than rejecting them")
+		readOnlyMode               = fs.Bool("readonly", false, "Start in read-only mode: reject inserts and deletes with HTTP 503, and serve selects (toggle via /admin/readonly)")
+		cursorSecret               = fs.String("cursor.secret", "", "Secret to sign the cursors of Select responses with; start/stop must then be signed cursors (blank to accept plain cursors)")
+		scoreTimeUnit              = fs.String("score.time.unit", "", "Interpret scores as Unix timestamps in this unit (s, ms, us, or ns), for the since, until, and times parameters of Selects (blank to disable)")
+		cursorTTL                  = fs.Duration("cursor.ttl", 1*time.Hour, "How long signed cursors stay valid (with -cursor.secret only)")
+		selectMaxStaleness         = fs.Duration("select.max.staleness", 0, "Serve strict Selects only from clusters which the walker found converged within this lag, or fail them with HTTP 503 (0 to serve them from every cluster)")
+		selectStalenessRefresh     = fs.Duration("select.staleness.refresh", 10*time.Second, "How often to read the convergence time of each cluster (with -select.max.staleness only)")
+		slowOpThreshold            = fs.Duration("slow.op.threshold", 0, "Log selects and inserts which take longer than this, with their keys and the time each cluster took, served at /admin/slow (0 to disable)")
+		slowOpLogSize              = fs.Int("slow.op.log.size", 100, "Slow ops kept in the log; older ones are dropped (with -slow.op.threshold only)")
+		slowOpHashKeys             = fs.Bool("slow.op.hash.keys", false, "Record the keys of slow ops as hashes, so the log doesn't expose them (with -slow.op.threshold only)")
+		selectPartialDeadline      = fs.Duration("select.partial.deadline", 100*time.Millisecond, "How long Selects with partial=true wait for clusters, before returning the results of the clusters which responded")
+		selectGap                  = fs.Duration("select.gap", 0*time.Millisecond, "delay between pipeline read invocations when Selecting over multiple keys")
+		selectKeysMax              = fs.Int("select.keys.max", 100000, "Max keys of a select at /select/keys; more are rejected with HTTP 413 (0 for no limit)")
+		selectKeysChunk            = fs.Int("select.keys.chunk", 500, "Keys of a select at /select/keys sent to the farm in each Select")
+		deleteBulkMax              = fs.Int("delete.bulk.max", 100000, "Max tuples of a delete at /bulk; more are rejected with HTTP 413 (0 for no limit)")
+		deleteBulkChunk            = fs.Int("delete.bulk.chunk", 500, "Tuples of a delete at /bulk sent to the farm in each Delete")
+		httpAddress                = fs.String("http.address", ":6302", "Comma-separated HTTP listen addresses, e.g. 0.0.0.0:6302,[::]:6302 (ignored if sockets are passed by systemd socket activation)")
+		httpShutdownTimeout        = fs.Duration("http.shutdown.timeout", 10*time.Second, "On SIGTERM or SIGINT, how long to wait for requests in flight, before closing the webhook, archive and audit sinks and exiting")
+		httpTLSCert                = fs.String("http.tls.cert", "", "PEM file of the TLS certificate of the listener, with any intermediates (blank to serve plain HTTP)")
+		httpTLSKey                 = fs.String("http.tls.key", "", "PEM file of the private key of -http.tls.cert")
+		httpTLSClientCA            = fs.String("http.tls.client.ca", "", "PEM file of CAs to verify client certificates against; clients without a valid certificate are rejected (blank to not require client certificates)")
+		httpInsertConcurrency      = fs.Int("http.insert.concurrency", 0, "Max inserts served at once; more wait in a queue (0 for no limit)")
+		httpInsertQueue            = fs.Int("http.insert.queue", 100, "Max inserts waiting to be served; more are rejected with HTTP 429 (with -http.insert.concurrency only)")
+		httpSelectConcurrency      = fs.Int("http.select.concurrency", 0, "Max selects, of every kind, served at once; more wait in a queue (0 for no limit)")
+		httpSelectQueue            = fs.Int("http.select.queue", 100, "Max selects waiting to be served; more are rejected with HTTP 429 (with -http.select.concurrency only)")
+		httpDeleteConcurrency      = fs.Int("http.delete.concurrency", 0, "Max deletes served at once; more wait in a queue (0 for no limit)")
+		httpDeleteQueue            = fs.Int("http.delete.queue", 100, "Max deletes waiting to be served; more are rejected with HTTP 429 (with -http.delete.concurrency only)")
+		adminToken                 = fs.String("admin.token", "", "Bearer token required by the /admin and /debug routes (blank to only serve them to loopback clients)")
+		corsAllowedOrigins         = fs.String("cors.allowed.origins", "", "Comma-separated origins which browsers may query the server from, or * for any (blank to disable CORS)")
+		corsAllowedHeaders         = fs.String("cors.allowed.headers", "Content-Type, If-None-Match, X-Request-ID", "Comma-separated request headers which browsers may send (with -cors.allowed.origins only)")
+		corsMaxAge                 = fs.Duration("cors.max.age", 10*time.Minute, "How long browsers may cache the outcome of a preflight request (with -cors.allowed.origins only)")
+		auditFile                  = fs.String("audit.file", "", "Record deletes, with requester and outcome, to this file as newline-delimited JSON (blank to disable)")
+		auditFileMaxBytes          = fs.Int64("audit.file.max.bytes", 100*1024*1024, "Rotate the audit file when it exceeds this size (0 to disable)")
+		auditURL                   = fs.String("audit.url", "", "Record deletes, with requester and outcome, by POSTing them as JSON to this URL (blank to disable)")
+		auditURLQueue              = fs.Int("audit.url.queue", 1024, "How many audit entries may wait to be POSTed to -audit.url, before further entries are dropped")
+		auditRequesterHeader       = fs.String("audit.requester.header", "X-Requester", "HTTP header identifying the requester in audit entries; falls back to the basic auth user, the TLS client certificate, then the remote address")
+		keyPrefixDelimiter         = fs.String("instrumentation.key.prefix.delimiter", "", "Report insert and select metrics per key prefix, the portion of each key before this delimiter (blank to disable)")
+		keyPrefixMax               = fs.Int("instrumentation.key.prefix.max", 20, "Max distinct key prefixes to report; others are reported as "+instrumentation.OtherKeyPrefix)
+		usageTenantDelimiter       = fs.String("usage.tenant.delimiter", "", "Account inserts, selects, and stored members per tenant, the portion of each key before this delimiter, served at /admin/usage (blank to disable)")
+		usageTenantMax             = fs.Int("usage.tenant.max", 1000, "Max distinct tenants to account; others are accounted as "+instrumentation.OtherKeyPrefix+" (with -usage.tenant.delimiter only)")
+		usageMembers               = fs.Bool("usage.members", false, "Track the stored members of each tenant, by sizing each key after it's written, at the cost of a read per write (with -usage.tenant.delimiter only)")
+		usageMembersKeys           = fs.Int("usage.members.keys", 100000, "Max keys whose size is remembered to track stored members; the members of the least recently written keys beyond it aren't counted (with -usage.members only)")
+		usageQuotas                = fs.String("usage.quotas", "", "Comma-separated TENANT:KIND=N hard quotas, KIND being inserts or selects per -usage.quota.window, or stored members; requests over quota are rejected with HTTP 429 (with -usage.tenant.delimiter only)")
+		usageQuotaWindow           = fs.Duration("usage.quota.window", time.Minute, "Window of the insert and select quotas (with -usage.tenant.delimiter only)")
+		validate                   = fs.Bool("validate", false, "validate the configuration and Redis instances, print a report, and exit")
+	)
+	if err := cli.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Lmicroseconds)
+	version := versionInfo{
+		Build:          cli.CurrentBuild(),
+		Dialect:        redisFlags.Dialect,
+		ReadStrategy:   *farmReadStrategy,
+		RepairStrategy: *farmRepairStrategy,
+		WriteQuorum:    *farmWriteQuorum,
+		Features: enabledFeatures(map[string]bool{
+			"redis.read.pool.mcpi":                 *redisReadPoolMCPI > 0,
+			"redis.multiplex":                      redisFlags.Multiplex,
+			"redis.pipeline.size":                  *redisPipelineSize > 0,
+			"farm.write.local.zone":                *farmWriteLocalZone != "",
+			"health.push.url":                      *healthPushURL != "",
+			"farm.write.serialize":                 *farmWriteSerialize,
+			"farm.select.workers":                  *farmSelectWorkers > 0,
+			"farm.health.alpha":                    *farmHealthAlpha > 0,
+			"farm.read.prefer.healthy":             *farmReadPreferHealthy > 0,
+			"farm.breaker.error.rate":              *farmBreakerErrorRate > 0,
+			"farm.repair.batch.window":             *farmRepairBatchWindow > 0,
+			"farm.repair.verify.delay":             *farmRepairVerifyDelay > 0,
+			"farm.warmup.keys":                     *farmWarmupKeys > 0,
+			"cache.hot.keys":                       *cacheHotKeys > 0,
+			"member.filter.keys":                   *memberFilterKeys > 0,
+			"max.member.size":                      *maxMemberSize > 0,
+			"max.size.overrides":                   *maxSizeOverrides != "",
+			"write.rewrite":                        *writeRewrite != "",
+			"split.keys":                           *splitKeys != "",
+			"archive.file":                         *archiveFile != "",
+			"webhook.url":                          *webhookURL != "",
+			"backfill.redis":                       *backfillRedis != "",
+			"empty.key.ttl":                        *emptyKeyTTL > 0,
+			"insert.dedup.window":                  *insertDedupWindow > 0,
+			"insert.max.score.skew":                *insertMaxScoreSkew > 0,
+			"cursor.secret":                        *cursorSecret != "",
+			"score.time.unit":                      *scoreTimeUnit != "",
+			"select.max.staleness":                 *selectMaxStaleness > 0,
+			"slow.op.threshold":                    *slowOpThreshold > 0,
+			"http.tls.cert":                        *httpTLSCert != "",
+			"http.tls.client.ca":                   *httpTLSClientCA != "",
+			"cors.allowed.origins":                 *corsAllowedOrigins != "",
+			"audit.file":                           *auditFile != "",
+			"audit.url":                            *auditURL != "",
+			"instrumentation.key.prefix.delimiter": *keyPrefixDelimiter != "",
+			"usage.tenant.delimiter":               *usageTenantDelimiter != "",
+		}),
+	}
+	log.Printf("roshi-server %s", version.Build)
+	if len(version.Features) > 0 {
+		log.Printf("features: %s", strings.Join(version.Features, ", "))
+	}
+	log.Printf("GOMAXPROCS %d", runtime.GOMAXPROCS(-1))
+
+	// Set up instrumentation backends. Several may be active at once.
+	instr, instrV2, err := instrumentationFlags.Build(http.DefaultServeMux)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Parse read strategy. A comma-separated list is a fallback chain.
+	readStrategy, err := parseReadStrategy(*farmReadStrategy, *farmReadThresholdRate, *farmReadThresholdLatency, *farmReadZone, *farmReadZoneQuorum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("using %s read strategy", *farmReadStrategy)
+
+	// Parse repair strategy. Note that because this is a client-facing
+	// production server, all repair strategies get a Nonblocking wrapper!
+	repairRequestBufferSize := 100
+	var (
+		repairStrategy farm.RepairStrategy
+		allRepairs     = farm.RepairStrategy(farm.AllRepairs)
+	)
+	if *farmRepairVerifyDelay > 0 {
+		log.Printf("verifying repairs after %s", *farmRepairVerifyDelay)
+		allRepairs = farm.VerifiedRepairs(*farmRepairVerifyDelay)
+	}
+	switch strings.ToLower(*farmRepairStrategy) {
+	case "allrepairs":
+		repairStrategy = farm.Nonblocking(repairRequestBufferSize, allRepairs)
+	case "norepairs":
+		repairStrategy = farm.Nonblocking(repairRequestBufferSize, farm.NoRepairs)
+	case "ratelimitedrepairs":
+		repairStrategy = farm.Nonblocking(repairRequestBufferSize, farm.RateLimited(*farmRepairMaxKeysPerSecond, allRepairs))
+	default:
+		log.Fatalf("unknown repair strategy %q", *farmRepairStrategy)
+	}
+	log.Printf("using %s repair strategy", *farmRepairStrategy)
+	if *farmRepairBatchWindow > 0 {
+		log.Printf("merging repair requests within %s, up to %d key-member(s)", *farmRepairBatchWindow, *farmRepairBatchMax)
+		repairStrategy = farm.Batched(*farmRepairBatchWindow, *farmRepairBatchMax, repairStrategy)
+	}
+
+	// Validate the configuration, if requested.
+	if *validate {
+		clusters, err := redisFlags.Clusters(*maxSize, *selectGap, instr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writeQuorum, err := evaluateScalarPercentage(*farmWriteQuorum, len(clusters))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stdout, "write quorum %d/%d\n", writeQuorum, len(clusters))
+		if err := farm.Validate(os.Stdout, clusters); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("validation OK")
+		return
+	}
+
+	// Build the farm.
+	zones, err := farm.ParseZones(redisFlags.Instances)
+	if err != nil {
+		log.Fatal(err)
+	}
+	localZones := []string{}
+	if *farmReadZone != "" {
+		localZones = append(localZones, *farmReadZone)
+	}
+	if *farmWriteLocalZone != "" {
+		localZones = append(localZones, *farmWriteLocalZone)
+	}
+	if err := farm.ValidZones(zones, localZones...); err != nil {
+		log.Fatal(err)
+	}
+	options := []farm.Option{
+		farm.MaxMemberSize(*maxMemberSize),
+		farm.MaxScoreSkew(*insertMaxScoreSkew, *insertScoreUnit, *insertScoreSkewClamp),
+		farm.Zones(zones),
+		farm.DedupWindow(*insertDedupWindow),
+		farm.QuorumRetryAfter(*farmQuorumRetryMin, *farmQuorumRetryMax),
+	}
+	for _, alpha := range []float64{*farmReadPreferHealthy, *farmHealthAlpha} {
+		if alpha == 0 {
+			continue
+		}
+		if err := farm.ValidHealthAlpha(alpha); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *farmReadPreferHealthy > 0 {
+		options = append(options, farm.PreferHealthyClusters(*farmReadPreferHealthy))
+	} else if *farmHealthAlpha > 0 {
+		options = append(options, farm.TrackClusterHealth(*farmHealthAlpha))
+	}
+	if *farmBreakerErrorRate > 0 {
+		alpha := *farmReadPreferHealthy
+		if alpha <= 0 {
+			alpha = *farmHealthAlpha
+		}
+		if alpha <= 0 {
+			log.Fatal("-farm.breaker.error.rate requires -farm.health.alpha or -farm.read.prefer.healthy")
+		}
+		if err := farm.ValidBreaker(*farmBreakerErrorRate, *farmBreakerCooldown); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("opening the circuit breaker of clusters failing at an error rate of %v, for %s", *farmBreakerErrorRate, *farmBreakerCooldown)
+		options = append(options, farm.BreakUnhealthyClusters(alpha, *farmBreakerErrorRate, *farmBreakerCooldown))
+	}
+	if *farmSelectWorkers < 0 || *farmSelectQueue < 0 {
+		log.Fatal("select workers and queue should be non-negative")
+	}
+	if *farmSelectWorkers > 0 {
+		log.Printf("serving Selects with %d worker(s) per cluster, queueing up to %d", *farmSelectWorkers, *farmSelectQueue)
+		options = append(options, farm.SelectWorkers(*farmSelectWorkers, *farmSelectQueue))
+	}
+	if *farmWarmupKeys > 0 {
+		log.Printf("warming up to %d hot key(s) on clusters leaving maintenance", *farmWarmupKeys)
+		options = append(options, farm.WarmHotKeys(*farmWarmupKeys))
+	}
+	if *farmWriteLocalZone != "" {
+		if *farmWriteLocalQueue <= 0 {
+			log.Fatal("local write queue should be positive")
+		}
+		log.Printf("acknowledging concern=local writes from zone %q, retrying other clusters %d time(s)", *farmWriteLocalZone, *farmWriteLocalAttempts)
+		options = append(options, farm.LocalWrites(*farmWriteLocalZone, *farmWriteLocalAttempts, *farmWriteLocalBackoff, *farmWriteLocalQueue))
+	}
+	if *farmWriteSerialize {
+		log.Printf("serializing writes to the same key")
+		options = append(options, farm.SerializeWrites())
+	}
+	if *selectMaxStaleness > 0 {
+		log.Printf("serving strict Selects from clusters converged within %s", *selectMaxStaleness)
+		options = append(options, farm.MaxStaleness(*selectMaxStaleness, *selectStalenessRefresh))
+	}
+	if *slowOpThreshold > 0 {
+		if *slowOpLogSize <= 0 {
+			log.Fatal("slow op log size should be positive")
+		}
+		log.Printf("logging selects and inserts slower than %s", *slowOpThreshold)
+		options = append(options, farm.LogSlowOps(*slowOpThreshold, *slowOpLogSize, *slowOpHashKeys))
+	}
+	if *writeRewrite != "" {
+		transform, err := farm.ParseTransforms(*writeRewrite)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("rewriting written tuples: %s", *writeRewrite)
+		options = append(options, farm.WriteTransform(transform))
+	}
+	if *splitKeys != "" {
+		prefixes, err := farm.ParseSplitKeys(*splitKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("splitting keys: %s", *splitKeys)
+		options = append(options, farm.SplitKeys(prefixes))
+	}
+	if *archiveFile != "" {
+		if *archiveQueue < 0 {
+			log.Fatal("archive queue should not be negative")
+		}
+		archiver, err := archive.NewNDJSONFile(*archiveFile, *archiveFlushInterval, *archiveQueue)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer archiver.Close()
+		log.Printf("archiving inserts to %s, reopened on SIGHUP", *archiveFile)
+		reopen := make(chan os.Signal, 1)
+		signal.Notify(reopen, syscall.SIGHUP)
+		go func() {
+			for range reopen {
+				if err := archiver.Reopen(); err != nil {
+					log.Printf("archive: %s", err)
+					continue
+				}
+				log.Printf("archive: reopened %s", *archiveFile)
+			}
+		}()
+		options = append(options, farm.ArchiveInserts(archiver))
+	}
+	if *webhookURL != "" {
+		log.Printf("notifying %s of modified keys", *webhookURL)
+		dispatcher := webhook.New(*webhookURL, *webhookSecret, *webhookTimeout, *webhookFlushInterval, *webhookBatchSize, *webhookRetries)
+		defer dispatcher.Close()
+		options = append(options, farm.NotifyWrites(dispatcher))
+	}
+	if *backfillRedis != "" {
+		if *backfillMaxKeys <= 0 {
+			log.Fatal("backfill max keys should be positive")
+		}
+		log.Printf("queueing divergent keys for the walker at %s", *backfillRedis)
+		queue := backfill.New(*backfillRedis, *backfillKey, redisFlags.WriteTimeout, *backfillMaxKeys)
+		defer queue.Close()
+		options = append(options, farm.BackfillDivergences(queue))
+	}
+	clusterOptions := []cluster.Option{
+		cluster.EmptyKeyTTL(*emptyKeyTTL),
+		cluster.PipelineSize(*redisPipelineSize),
+	}
+	sizeOverrides, err := cluster.ParseMaxSizeOverrides(*maxSizeOverrides)
+	if err != nil {
+		log.Fatal(err)
+	}
+	largestMaxSize := *maxSize // the most members any key may have
+	if len(sizeOverrides) > 0 {
+		log.Printf("overriding the max size of %d key prefix(es)", len(sizeOverrides))
+		clusterOptions = append(clusterOptions, cluster.MaxSizeOverrides(sizeOverrides))
+		for _, size := range sizeOverrides {
+			if size > largestMaxSize {
+				largestMaxSize = size
+			}
+		}
+	}
+	if *redisReadPoolMCPI > 0 {
+		log.Printf("serving selects from a separate pool of %d connection(s) per Redis instance", *redisReadPoolMCPI)
+		clusterOptions = append(clusterOptions, cluster.ReadPool(*redisReadPoolConnect, *redisReadPoolRead, *redisReadPoolWrite, *redisReadPoolMCPI))
+	}
+	var invalidators []func([]string)
+	if *cacheHotKeys > 0 {
+		log.Printf("caching up to %d hot key(s), invalidated via Redis client tracking", *cacheHotKeys)
+		cache := farm.NewCache(*cacheHotKeys)
+		invalidators = append(invalidators, cache.Invalidate)
+		options = append(options, farm.ClientSideCache(cache))
+	}
+	if *memberFilterKeys > 0 {
+		if *memberFilterFalsePositive <= 0 || *memberFilterFalsePositive >= 1 {
+			log.Fatalf("invalid -member.filter.false.positive.rate %v (must be between 0 and 1)", *memberFilterFalsePositive)
+		}
+		log.Printf("filtering the members of up to %d key(s), invalidated via Redis client tracking", *memberFilterKeys)
+		filters := farm.NewMemberFilters(*memberFilterKeys, *memberFilterMaxMembers, *memberFilterFalsePositive)
+		invalidators = append(invalidators, filters.Invalidate)
+		options = append(options, farm.MemberFilter(filters))
+	}
+	if len(invalidators) > 0 {
+		dialect, err := redisFlags.RedisDialect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !dialect.Tracking() {
+			log.Fatalf("-cache.hot.keys and -member.filter.keys need Redis client tracking, which %s doesn't support", dialect)
+		}
+		clusterOptions = append(clusterOptions, cluster.Tracking(func(keys []string) {
+			for _, invalidate := range invalidators {
+				invalidate(keys)
+			}
+		}))
+	}
+	farm, err := newFarm(
+		redisFlags,
+		*farmWriteQuorum,
+		readStrategy,
+		repairStrategy,
+		*maxSize,
+		*selectGap,
+		clusterOptions,
+		instr,
+		options...,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer farm.Close()
+	scripts, err := farm.LoadScripts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if drift := logScripts(scripts); drift && *redisScriptsStrict {
+		log.Fatal("Lua script versions differ across the fleet")
+	}
+
+	if *selectKeysChunk <= 0 {
+		log.Fatal("select keys chunk should be positive")
+	}
+	if *deleteBulkChunk <= 0 {
+		log.Fatal("bulk delete chunk should be positive")
+	}
+	tlsConfig, err := newTLSConfig(*httpTLSCert, *httpTLSKey, *httpTLSClientCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Build the HTTP server.
+	r := pat.New()
+	api := newAPI(r)
+	r.Add("GET", "/metrics", http.DefaultServeMux)
+	r.Get("/openapi.json", handleOpenAPI(api, version.Version))
+	api.get("/version", handleVersion(version), versionDoc)
+	api.get("/debug/key", handleInspect(farm), inspectDoc) // before /debug, which matches it
+	r.Add("GET", "/debug", http.DefaultServeMux)
+	r.Add("POST", "/debug", http.DefaultServeMux)
+	readOnly := &readOnly{}
+	if *readOnlyMode {
+		log.Printf("read-only mode enabled")
+		readOnly.set(true)
+	}
+	api.get("/admin/readonly", handleReadOnly(readOnly), readOnlyDoc)
+	api.post("/admin/readonly", handleReadOnly(readOnly), setReadOnlyDoc)
+	api.get("/admin/maintenance", handleMaintenance(farm), maintenanceDoc)
+	api.post("/admin/maintenance", writable(readOnly, handleMaintenance(farm)), setMaintenanceDoc)
+	api.get("/admin/shard", handleShard(farm), shardDoc)
+	if *healthInstance == "" {
+		if *healthInstance, err = os.Hostname(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	exporter := &healthExporter{health: farm, instance: *healthInstance, quorum: *farmWriteQuorum, maxErrorRate: *healthMaxErrorRate}
+	if *healthPushURL != "" {
+		if *farmHealthAlpha <= 0 && *farmReadPreferHealthy <= 0 {
+			log.Fatal("-health.push.url requires -farm.health.alpha or -farm.read.prefer.healthy")
+		}
+		if *healthPushInterval <= 0 {
+			log.Fatal("health push interval should be positive")
+		}
+		log.Printf("pushing the health of %s to %s every %s", *healthInstance, *healthPushURL, *healthPushInterval)
+		go exporter.push(*healthPushURL, *healthPushInterval)
+	}
+	api.get("/admin/health/instance", handleInstanceHealth(exporter), instanceHealthDoc) // before /admin/health, which matches it
+	api.get("/admin/health", handleHealth(farm), healthDoc)
+	api.get("/admin/amplification", handleAmplification(farm), amplificationDoc)
+	api.get("/admin/slow", handleSlowOps(farm), slowOpsDoc)
+	api.get("/admin/scripts", handleScripts(scripts), scriptsDoc)
+	api.post("/admin/repair", writable(readOnly, handleRepair(farm, largestMaxSize)), repairDoc)
+	api.post("/admin/memory", handleMemory(farm), memoryDoc)
+	times, err := newScoreTimes(*scoreTimeUnit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if times != nil {
+		log.Printf("interpreting scores as Unix timestamps in %s", times.unit)
+	}
+	var signer *cursorSigner
+	if *cursorSecret != "" {
+		log.Printf("signing cursors, valid for %s", *cursorTTL)
+		signer = newCursorSigner(*cursorSecret, *cursorTTL)
+	}
+	var usage *usageAccountant
+	if *usageTenantDelimiter != "" {
+		quotas, err := parseUsageQuotas(*usageQuotas)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *usageQuotaWindow <= 0 {
+			log.Fatal("usage quota window should be positive")
+		}
+		var s sizer
+		if *usageMembers {
+			if *usageMembersKeys <= 0 {
+				log.Fatal("usage members keys should be positive")
+			}
+			s = farm
+		}
+		for tenant, quota := range quotas {
+			if quota.Members > 0 && s == nil {
+				log.Fatalf("the stored members quota of tenant %q requires -usage.members", tenant)
+			}
+		}
+		log.Printf("accounting usage per tenant, delimited by %q, with quotas for %d tenant(s)", *usageTenantDelimiter, len(quotas))
+		usage = newUsageAccountant(*usageTenantDelimiter, *usageTenantMax, quotas, *usageQuotaWindow, s, *usageMembersKeys, instrV2)
+	}
+	api.get("/admin/usage", handleUsage(usage), usageDoc)
+	var (
+		selectHandler = accounted(usage, handleSelect(farm, *selectPartialDeadline, signer, times))
+		insertHandler = writable(readOnly, accounted(usage, handleInsert(farm)))
+	)
+	if *keyPrefixDelimiter != "" {
+		log.Printf("reporting metrics per key prefix, delimited by %q", *keyPrefixDelimiter)
+		prefixer := instrumentation.NewKeyPrefixer(*keyPrefixDelimiter, *keyPrefixMax)
+		selectHandler = keyPrefixed("select", selectHandler, prefixer, instrV2)
+		insertHandler = keyPrefixed("insert", insertHandler, prefixer, instrV2)
+	}
+	var (
+		insertLimit = newConcurrencyLimit("insert", *httpInsertConcurrency, *httpInsertQueue)
+		selectLimit = newConcurrencyLimit("select", *httpSelectConcurrency, *httpSelectQueue)
+		deleteLimit = newConcurrencyLimit("delete", *httpDeleteConcurrency, *httpDeleteQueue)
+	)
+	api.post("/select/bulk", limited(selectLimit, accounted(usage, handleBulkSelect(farm))), bulkSelectDoc)
+	api.post("/select/contains", limited(selectLimit, accounted(usage, handleContains(farm))), containsDoc)
+	api.post("/select/keys", limited(selectLimit, accounted(usage, handleSelectKeys(farm, *selectKeysMax, *selectKeysChunk, instrV2))), selectKeysDoc)
+	api.get("/select/buckets", limited(selectLimit, accounted(usage, handleSelectBuckets(farm))), bucketsDoc)
+	var (
+		deleteHandler     = writable(readOnly, accounted(usage, handleDelete(farm)))
+		bulkDeleteHandler = writable(readOnly, accounted(usage, handleBulkDelete(farm, *deleteBulkMax, *deleteBulkChunk)))
+		touchHandler      = writable(readOnly, accounted(usage, handleTouch(farm)))
+	)
+	if *auditFile != "" {
+		auditor, err := audit.NewFile(*auditFile, *auditFileMaxBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer auditor.Close()
+		log.Printf("auditing deletes and touches to %s", *auditFile)
+		deleteHandler = audited("delete", deleteHandler, auditor, *auditRequesterHeader)
+		bulkDeleteHandler = audited("delete", bulkDeleteHandler, auditor, *auditRequesterHeader)
+		touchHandler = audited("touch", touchHandler, auditor, *auditRequesterHeader)
+	}
+	if *auditURL != "" {
+		if *auditURLQueue < 0 {
+			log.Fatal("audit URL queue should not be negative")
+		}
+		log.Printf("auditing deletes and touches to %s", *auditURL)
+		auditor := audit.NewHTTP(*auditURL, 5*time.Second, *auditURLQueue)
+		defer auditor.Close()
+		deleteHandler = audited("delete", deleteHandler, auditor, *auditRequesterHeader)
+		bulkDeleteHandler = audited("delete", bulkDeleteHandler, auditor, *auditRequesterHeader)
+		touchHandler = audited("touch", touchHandler, auditor, *auditRequesterHeader)
+	}
+	api.delete("/bulk", limited(deleteLimit, bulkDeleteHandler), bulkDeleteDoc) // before /, which matches it
+	api.post("/bulk", methodOverride("DELETE", limited(deleteLimit, bulkDeleteHandler)), bulkDeleteOverrideDoc)
+	api.get("/", limited(selectLimit, selectHandler), selectDoc)
+	api.post("/touch", limited(insertLimit, touchHandler), touchDoc) // before /, which matches it
+	api.post("/", limited(insertLimit, insertHandler), insertDoc)
+	api.delete("/", limited(deleteLimit, deleteHandler), deleteDoc)
+	if *adminToken == "" {
+		log.Printf("serving /admin and /debug to loopback clients only")
+	}
+	h := withRequestID(withAdminAuth(*adminToken, r))
+	if *corsAllowedOrigins != "" {
+		log.Printf("allowing cross-origin requests from %s", *corsAllowedOrigins)
+		h = withCORS(newCORSPolicy(*corsAllowedOrigins, *corsAllowedHeaders, *corsMaxAge), h)
+	}
+
+	// Go for it.
+	listeners, err := systemdListeners()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(listeners) > 0 {
+		log.Printf("using %d socket(s) passed by systemd, ignoring -http.address", len(listeners))
+	} else if listeners, err = listenAll(*httpAddress); err != nil {
+		log.Fatal(err)
+	}
+	server := &http.Server{Handler: h, TLSConfig: tlsConfig}
+	if tlsConfig != nil && *httpTLSClientCA != "" {
+		log.Printf("requiring client certificates signed by %s", *httpTLSClientCA)
+	}
+	for _, l := range listeners {
+		if tlsConfig != nil {
+			log.Printf("listening on %s with TLS", l.Addr())
+		} else {
+			log.Printf("listening on %s", l.Addr())
+		}
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	if err := serve(server, listeners, stop, *httpShutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("stopped serving")
+}
+
+func newFarm(
+	redisFlags *cli.Redis,
+	writeQuorumStr string,
+	readStrategy farm.ReadStrategy,
+	repairStrategy farm.RepairStrategy,
+	maxSize int,
+	selectGap time.Duration,
+	clusterOptions []cluster.Option,
+	instr instrumentation.Instrumentation,
+	options ...farm.Option,
+) (*farm.Farm, error) {
+	clusters, err := redisFlags.Clusters(maxSize, selectGap, instr, clusterOptions...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%d cluster(s)", len(clusters))
+
+	writeQuorum, err := evaluateScalarPercentage(
+		writeQuorumStr,
+		len(clusters),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return farm.New(
+		clusters,
+		writeQuorum,
+		readStrategy,
+		repairStrategy,
+		instr,
+		options...,
+	), nil
+}
+
+// parseReadStrategy returns the read strategy named by s, or, for a
+// comma-separated list of names, a fallback chain of them. The other
+// parameters configure the strategies which take them.
+func parseReadStrategy(s string, thresholdRate int, thresholdLatency time.Duration, zone string, zoneQuorum int) (farm.ReadStrategy, error) {
+	strategies := []farm.ReadStrategy{}
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case "sendallreadall":
+			strategies = append(strategies, farm.SendAllReadAll)
+		case "sendonereadone":
+			strategies = append(strategies, farm.SendOneReadOne)
+		case "sendallreadfirstlinger":
+			strategies = append(strategies, farm.SendAllReadFirstLinger)
+		case "sendvarreadfirstlinger":
+			strategies = append(strategies, farm.SendVarReadFirstLinger(thresholdRate, thresholdLatency))
+		case "preferlocalzone":
+			if zone == "" {
+				return nil, fmt.Errorf("PreferLocalZone read strategy requires -farm.read.zone")
+			}
+			strategies = append(strategies, farm.PreferLocalZone(zone, zoneQuorum))
+		case "sendallreaddigests":
+			strategies = append(strategies, farm.SendAllReadDigests)
+		default:
+			return nil, fmt.Errorf("unknown read strategy %q", name)
+		}
+	}
+	switch len(strategies) {
+	case 0:
+		return nil, fmt.Errorf("no read strategy in %q", s)
+	case 1:
+		return strategies[0], nil
+	default:
+		return farm.Fallback(strategies...), nil
+	}
+}
+
+// selectedJSON is the JSON response to a select, which respondSelectedPages
+// writes field by field. A coalesced select responds with a single page of
+// records, and a single cursor, instead.
+type selectedJSON struct {
+	Duration  string                             `json:"duration"`
+	Records   map[string][]common.KeyScoreMember `json:"records"`
+	Truncated map[string]bool                    `json:"truncated"`
+	Cursors   map[string]cursorPair              `json:"cursors,omitempty"` // only with -cursor.secret
+	Bounds    map[string]scoreBounds             `json:"bounds,omitempty"`  // only if requested
+	Times     map[string][]string                `json:"times,omitempty"`   // only if requested
+}
+
+var selectDoc = apiDoc{
+	summary: "Select a page of each of the keys",
+	params: []apiParam{
+		{"offset", "integer", "Offset into each key, for pagination"},
+		{"limit", "integer", "Maximum number of records of each key (default 10)"},
+		{"start", "string", "Cursor to select from, exclusive"},
+		{"stop", "string", "Cursor to select up to, exclusive"},
+		{"coalesce", "boolean", "Whether to merge the keys into a single page"},
+		{"dedupe", "string", "member, to return each member only once across the keys"},
+		{"order", "string", "desc (default) or asc"},
+		{"repair", "boolean", "Whether divergences are repaired (default true)"},
+		{"partial", "boolean", "Whether to return the results of the clusters which responded by the partial deadline"},
+		{"strict", "boolean", "Whether to only read clusters which converged recently"},
+		{"stride", "integer", "Return every Nth element of each key (default 1)"},
+		{"bounds", "string", "none (default), page, or set"},
+		{"since", "string", "RFC 3339 time to select from, inclusive, with score times"},
+		{"until", "string", "RFC 3339 time to select up to, exclusive, with score times"},
+		{"times", "boolean", "Whether to return the time of each score, with score times"},
+	},
+	request:  [][]byte{},
+	response: selectedJSON{},
+}
+
+func handleSelect(selecter farm.Selecter, partialDeadline time.Duration, signer *cursorSigner, times *scoreTimes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+
+		keys, err := requestKeys(r)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		keyStrings := make([]string, len(keys))
+		for i := range keys {
+			keyStrings[i] = string(keys[i])
+		}
+		if err := requestUsage(r).admit(r.Context(), "select", keyStrings); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusTooManyRequests, err)
+			return
+		}
+
+		var (
+			offset, offsetGiven  = parseInt(r.Form, "offset", 0)
+			startStr, startGiven = parseStr(r.Form, "start", "")
+			stopStr, stopGiven   = parseStr(r.Form, "stop", "")
+			limit, _             = parseInt(r.Form, "limit", 10)
+			coalesce, _          = parseBool(r.Form, "coalesce", false)
+			dedupe, dedupeGiven  = parseStr(r.Form, "dedupe", "")
+			order, _             = parseStr(r.Form, "order", "desc")
+			repair, _            = parseBool(r.Form, "repair", true)
+			partial, _           = parseBool(r.Form, "partial", false)
+			strict, _            = parseBool(r.Form, "strict", false)
+			stride, _            = parseInt(r.Form, "stride", 1)
+			boundsStr, _         = parseStr(r.Form, "bounds", "")
+			since, sinceGiven    = parseStr(r.Form, "since", "")
+			until, untilGiven    = parseStr(r.Form, "until", "")
+			withTimes, _         = parseBool(r.Form, "times", false)
+		)
+
+		// With scores as times, since and until stand in for the stop and
+		// start cursors.
+		var timeStart, timeStop *common.Cursor
+		if (sinceGiven || untilGiven || withTimes) && times == nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("since, until, and times are not supported (scores aren't times)"))
+			return
+		}
+		if sinceGiven || untilGiven {
+			if (sinceGiven && stopGiven) || (untilGiven && startGiven) {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("cannot specify both since and stop, or until and start"))
+				return
+			}
+			var err error
+			if timeStart, timeStop, err = times.parseRange(since, until); err != nil {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+				return
+			}
+			startGiven, stopGiven = startGiven || untilGiven, stopGiven || sinceGiven
+		}
+		var responseTimes *scoreTimes // nil unless requested
+		if withTimes {
+			responseTimes = times
+		}
+
+		selecter := selecter // may be replaced for this request only
+		if identifier, ok := selecter.(requestIdentifier); ok && requestID(w) != "" {
+			selecter = identifier.WithRequestID(requestID(w))
+		}
+		if !repair {
+			exempter, ok := selecter.(repairExempter)
+			if !ok {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("repair-exempt selects not supported"))
+				return
+			}
+			selecter = exempter.WithoutRepairs()
+		}
+		if partial {
+			deadliner, ok := selecter.(deadliner)
+			if !ok {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("partial selects not supported"))
+				return
+			}
+			selecter = deadliner.WithDeadline(began.Add(partialDeadline))
+			if reporter, ok := selecter.(partialReporter); ok {
+				selecter = reporter.ReportPartial()
+			}
+		}
+		if strict {
+			strictener, ok := selecter.(strictener)
+			if !ok {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("strict selects not supported"))
+				return
+			}
+			selecter = strictener.Strict()
+		}
+
+		if order != "asc" && order != "desc" {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid order %q (must be %q or %q)", order, "asc", "desc"))
+			return
+		}
+		ascending := order == "asc"
+
+		if dedupeGiven && dedupe != "member" {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid dedupe %q (only %q is supported)", dedupe, "member"))
+			return
+		}
+
+		if limit < 0 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid limit %d (must not be negative)", limit))
+			return
+		}
+
+		if stride < 1 {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid stride %d (must be at least 1)", stride))
+			return
+		}
+		if stride > 1 && (ascending || coalesce || startGiven || stopGiven) {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("stride is not supported with order=asc, coalesce, or start/stop"))
+			return
+		}
+
+		withBounds, withSetMax, err := parseBounds(boundsStr)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		if withBounds && coalesce {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("bounds are not supported with coalesce"))
+			return
+		}
+
+		switch {
+		case ascending && (startGiven || stopGiven):
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("order=asc is not supported with start/stop"))
+			return
+
+		case !offsetGiven && (startGiven || stopGiven):
+			// SelectRange. `coalesce` has no impact on the request, only the
+			// handling of the response.
+
+			var (
+				start = common.Cursor{Score: math.MaxFloat64}
+				stop  = common.Cursor{Score: 0}
+			)
+
+			if timeStart != nil {
+				start = *timeStart
+			} else if startGiven {
+				var err error
+				if start, err = signer.parse(startStr, keyStrings, ascending, began); err != nil {
+					respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+					return
+				}
+			}
+
+			if timeStop != nil {
+				stop = *timeStop
+			} else if stopGiven {
+				var err error
+				if stop, err = signer.parse(stopStr, keyStrings, ascending, began); err != nil {
+					respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+					return
+				}
+			}
+
+			// One more element than the limit tells whether each key has
+			// more beyond the page.
+			fetchLimit := limit + 1
+			if dedupeGiven {
+				fetchLimit *= len(keyStrings)
+			}
+			results, err := selecter.SelectRange(keyStrings, start, stop, fetchLimit)
+			if err = markPartial(w, err); err != nil {
+				respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+				return
+			}
+			var truncated map[string]bool
+			if dedupeGiven {
+				truncated = dedupeTruncate(results, limit, fetchLimit)
+			} else {
+				truncated = truncate(results, limit)
+			}
+
+			//cursorResults := addCursor(results)
+
+			if coalesce {
+				respondSelectedPage(w, r, flatten(results, 0, limit, false), keyStrings, ascending, signer, responseTimes, began)
+				return
+			}
+
+			var bounds map[string]scoreBounds
+			if withBounds {
+				bounds, err = pageBounds(selecter, results, withSetMax, !startGiven)
+				if err = markPartial(w, err); err != nil {
+					respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+					return
+				}
+			}
+
+			respondSelectedPages(w, r, results, truncated, bounds, ascending, signer, responseTimes, began)
+			return
+
+		case !startGiven && !stopGiven:
+			// SelectOffset. The offset/limit may be altered by `coalesce`.
+			var (
+				selectOffset = offset
+				selectLimit  = limit
+			)
+
+			if coalesce {
+				selectOffset = 0
+				selectLimit = offset + limit
+			}
+			selectLimit++ // tells whether each key has more beyond the page
+			fetchLimit := selectLimit
+			if dedupeGiven {
+				fetchLimit *= len(keyStrings)
+			}
+
+			selectOffsetFunc := selecter.SelectOffset
+			if ascending {
+				selectOffsetFunc = selecter.SelectOffsetAscending
+			}
+			if stride > 1 {
+				sampler, ok := selecter.(sampler)
+				if !ok {
+					respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("strided selects not supported"))
+					return
+				}
+				selectOffsetFunc = func(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+					return sampler.SelectStride(keys, offset, stride, limit)
+				}
+			}
+			results, err := selectOffsetFunc(keyStrings, selectOffset, fetchLimit)
+			if err = markPartial(w, err); err != nil {
+				respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+				return
+			}
+			var truncated map[string]bool
+			if dedupeGiven {
+				truncated = dedupeTruncate(results, selectLimit-1, fetchLimit)
+			} else {
+				truncated = truncate(results, selectLimit-1)
+			}
+
+			//cursorResults := addCursor(results)
+
+			if coalesce {
+				respondSelectedPage(w, r, flatten(results, offset, limit, ascending), keyStrings, ascending, signer, responseTimes, began)
+				return
+			}
+
+			var bounds map[string]scoreBounds
+			if withBounds {
+				bounds, err = pageBounds(selecter, results, withSetMax, !ascending && offset == 0)
+				if err = markPartial(w, err); err != nil {
+					respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+					return
+				}
+			}
+
+			respondSelectedPages(w, r, results, truncated, bounds, ascending, signer, responseTimes, began)
+			return
+
+		case offsetGiven && (startGiven || stopGiven):
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("cannot specify both offset and start/stop"))
+			return
+
+		default:
+			panic("unreachable")
+		}
+	}
+}
+
+var insertDoc = apiDoc{
+	summary: "Insert tuples",
+	params: []apiParam{
+		{"verbose", "boolean", "Whether to report the outcome in each cluster"},
+		{"rejections", "boolean", "Whether to report tuples shadowed by higher scores"},
+		{"sizes", "boolean", "Whether to report the number of members of each key after the insert"},
+		{"concern", "string", "quorum (default), or local to return once a local cluster applied the insert"},
+	},
+	request:  []common.KeyScoreMember{},
+	response: insertedJSON{},
+}
+
+func handleInsert(inserter cluster.Inserter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		inserter := inserter // may be replaced for this request only
+		concern, _ := parseStr(r.URL.Query(), "concern", "quorum")
+		switch concern {
+		case "quorum":
+		case "local":
+			localWriter, ok := inserter.(localWriter)
+			if !ok {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("local inserts not supported"))
+				return
+			}
+			inserter = localWriter.Local()
+		default:
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid concern %q (must be %q or %q)", concern, "quorum", "local"))
+			return
+		}
+
+		verbose, _ := parseBool(r.URL.Query(), "verbose", false)
+		verboseInserter, canVerbose := inserter.(verboseInserter)
+		if verbose && !canVerbose {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("verbose inserts not supported"))
+			return
+		}
+
+		rejections, _ := parseBool(r.URL.Query(), "rejections", false)
+		rejecter, canReject := inserter.(rejecter)
+		if rejections && !canReject {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("insert rejections not supported"))
+			return
+		}
+
+		sizes, _ := parseBool(r.URL.Query(), "sizes", false)
+		sizer, canSize := inserter.(sizer)
+		if sizes && !canSize {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("insert sizes not supported"))
+			return
+		}
+
+		tuples, err := requestTuples(r)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		usage := requestUsage(r)
+		if err := usage.admit(r.Context(), "insert", tupleKeys(tuples)); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusTooManyRequests, err)
+			return
+		}
+
+		var result *farm.WriteResult
+		if verbose {
+			verboseResult, err := verboseInserter.InsertVerbose(tuples)
+			if err != nil {
+				respondWriteError(w, r.Method, r.URL.String(), errorCode(err), err, verboseResult)
+				return
+			}
+			result = &verboseResult
+		} else if err := inserter.Insert(tuples); err != nil {
+			respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+			return
+		}
+		usage.written(r.Context(), tuples)
+
+		var rejected []farm.Rejection
+		if rejections {
+			var err error
+			if rejected, err = rejecter.Rejected(tuples); err != nil {
+				// The insert itself succeeded, so don't fail the request.
+				rejected = nil
+				log.Printf("%s %s: checking rejections: %s", r.Method, r.URL.String(), err)
+			}
+		}
+
+		var sized map[string]int
+		if sizes {
+			var err error
+			if sized, err = sizer.Sizes(tuples); err != nil {
+				// Likewise.
+				sized = nil
+				log.Printf("%s %s: counting members: %s", r.Method, r.URL.String(), err)
+			}
+		}
+
+		respondInserted(w, len(tuples), time.Since(began), result, rejected, sized)
+	}
+}
+
+var deleteDoc = apiDoc{
+	summary: "Delete tuples",
+	params: []apiParam{
+		{"verbose", "boolean", "Whether to report the outcome in each cluster"},
+		{"guarded", "boolean", "Whether to delete each member only if it has the expected score; the body and response differ"},
+		{"concern", "string", "quorum (default), or local to return once a local cluster applied the delete"},
+	},
+	request:  []common.KeyScoreMember{},
+	response: deletedJSON{},
+}
+
+func handleDelete(deleter cluster.Deleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		deleter := deleter // may be replaced for this request only
+		concern, _ := parseStr(r.URL.Query(), "concern", "quorum")
+		switch concern {
+		case "quorum":
+		case "local":
+			localWriter, ok := deleter.(localWriter)
+			if !ok {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("local deletes not supported"))
+				return
+			}
+			deleter = localWriter.Local()
+		default:
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("invalid concern %q (must be %q or %q)", concern, "quorum", "local"))
+			return
+		}
+
+		verbose, _ := parseBool(r.URL.Query(), "verbose", false)
+		verboseDeleter, canVerbose := deleter.(verboseDeleter)
+		if verbose && !canVerbose {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("verbose deletes not supported"))
+			return
+		}
+
+		guarded, _ := parseBool(r.URL.Query(), "guarded", false)
+		if guarded {
+			guardedDeleter, canGuard := deleter.(guardedDeleter)
+			if !canGuard || verbose {
+				respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("guarded deletes not supported"))
+				return
+			}
+			deleteGuarded(w, r, guardedDeleter, began)
+			return
+		}
+
+		tuples, err := requestTuples(r)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+
+		if verbose {
+			result, err := verboseDeleter.DeleteVerbose(tuples)
+			if err != nil {
+				respondWriteError(w, r.Method, r.URL.String(), errorCode(err), err, result)
+				return
+			}
+			requestUsage(r).written(r.Context(), tuples)
+			respondDeleted(w, len(tuples), time.Since(began), &result)
+			return
+		}
+
+		if err := deleter.Delete(tuples); err != nil {
+			respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+			return
+		}
+		requestUsage(r).written(r.Context(), tuples)
+
+		respondDeleted(w, len(tuples), time.Since(began), nil)
+	}
+}
+
+// errorCode maps an error from the farm to an HTTP status code. Errors caused
+// by the request itself are client errors. Timeouts of Redis instances are
+// gateway timeouts, and other errors which may go away if the request is
+// retried later make the service unavailable, e.g. writes which failed
+// quorum, selects rejected under overload, and strict selects while every
+// cluster is stale. Everything else is a server error.
+func errorCode(err error) int {
+	var (
+		tooLarge farm.MemberTooLargeError
+		skew     farm.ScoreSkewError
+		quorum   farm.QuorumError
+		timeout  pool.TimeoutError
+	)
+	switch {
+	case errors.As(err, &tooLarge), errors.As(err, &skew):
+		return http.StatusBadRequest
+	case errors.As(err, &quorum): // before timeouts, which it may wrap
+		return http.StatusServiceUnavailable
+	case errors.As(err, &timeout):
+		return http.StatusGatewayTimeout
+	case farm.Retryable(err):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// verboseInserter is implemented by farm.Farm, and used for inserts with the
+// verbose parameter set.
+type verboseInserter interface {
+	InsertVerbose([]common.KeyScoreMember) (farm.WriteResult, error)
+}
+
+// repairExempter is implemented by farm.Farm, and used for selects with the
+// repair parameter set to false.
+type repairExempter interface {
+	WithoutRepairs() farm.Selecter
+}
+
+// deadliner is implemented by farm.Farm, and used for selects with the
+// partial parameter set.
+type deadliner interface {
+	WithDeadline(time.Time) farm.PartialSelecter
+}
+
+// partialReporter is implemented by farm.Farm, and used for selects with
+// the partial parameter set, so that partial results are reported by a
+// PartialResultError.
+type partialReporter interface {
+	ReportPartial() *farm.Farm
+}
+
+// strictener is implemented by farm.Farm, and used for selects with the
+// strict parameter set.
+type strictener interface {
+	Strict() farm.Selecter
+}
+
+// markPartial sets the consistency headers of the response, if clusters
+// missed the deadline of a partial select, as reported by a
+// PartialResultError. The results are then merged from the clusters which
+// responded, and may be inconsistent, but they're served. Other errors are
+// returned.
+func markPartial(w http.ResponseWriter, err error) error {
+	var partial farm.PartialResultError
+	if !errors.As(err, &partial) {
+		return err
+	}
+	indices := make([]string, len(partial.Missed))
+	for i, index := range partial.Missed {
+		indices[i] = strconv.Itoa(index)
+	}
+	w.Header().Set("X-Roshi-Consistency", "partial")
+	w.Header().Set("X-Roshi-Missed-Clusters", strings.Join(indices, ","))
+	return nil
+}
+
+// rejecter is implemented by farm.Farm, and used for inserts with the
+// rejections parameter set.
+type rejecter interface {
+	Rejected([]common.KeyScoreMember) ([]farm.Rejection, error)
+}
+
+// localWriter is implemented by farm.Farm, and used for writes with the
+// local concern.
+type localWriter interface {
+	Local() *farm.Farm
+}
+
+// sizer is implemented by farm.Farm, and used for inserts with the sizes
+// parameter set.
+type sizer interface {
+	Sizes([]common.KeyScoreMember) (map[string]int, error)
+}
+
+// sampler is implemented by farm.Farm, and used for selects with a stride.
+type sampler interface {
+	SelectStride(keys []string, offset, stride, limit int) (map[string][]common.KeyScoreMember, error)
+}
+
+// verboseDeleter is implemented by farm.Farm, and used for deletes with the
+// verbose parameter set.
+type verboseDeleter interface {
+	DeleteVerbose([]common.KeyScoreMember) (farm.WriteResult, error)
+}
+
+func addCursor(in map[string][]common.KeyScoreMember) map[string][]keyScoreMemberCursor {
+	var (
+		out = make(map[string][]keyScoreMemberCursor, len(in))
+	)
+
+	// We do a little nonstandard dance with cursor encoding, here, as a
+	// result of memory profiling.
+
+	for key, keyScoreMembers := range in {
+		keyScoreMemberCursors := make([]keyScoreMemberCursor, len(keyScoreMembers))
+
+		for i, keyScoreMember := range keyScoreMembers {
+			keyScoreMemberCursors[i].KeyScoreMember = keyScoreMember
+			keyScoreMember.Cursor().Encode(keyScoreMemberCursors[i].Cursor)
+		}
+
+		out[key] = keyScoreMemberCursors
+	}
+
+	return out
+}
+
+func flatten(m map[string][]common.KeyScoreMember, offset, limit int, ascending bool) []common.KeyScoreMember {
+	n := 0
+	for _, slice := range m {
+		n += len(slice)
+	}
+	a := make([]common.KeyScoreMember, 0, n)
+	for _, slice := range m {
+		a = append(a, slice...)
+	}
+
+	if ascending {
+		sort.Sort(sort.Reverse(keyScoreMembers(a)))
+	} else {
+		sort.Sort(keyScoreMembers(a))
+	}
+
+	if len(a) < offset {
+		return []common.KeyScoreMember{}
+	}
+
+	a = a[offset:]
+
+	if len(a) > limit {
+		a = a[:limit]
+	}
+
+	return a
+}
+
+// dedupeMembers removes every instance of a member that also appears in
+// another key with a higher score, so that each member is returned at most
+// once across all selected keys. Ties are broken in favor of the
+// lexicographically smaller key. The relative order within each key is
+// preserved.
+func dedupeMembers(m map[string][]common.KeyScoreMember) map[string][]common.KeyScoreMember {
+	best := map[string]common.KeyScoreMember{} // member: winning tuple
+	for _, keyScoreMembers := range m {
+		for _, ksm := range keyScoreMembers {
+			winner, ok := best[ksm.Member]
+			if !ok || ksm.Score > winner.Score || (ksm.Score == winner.Score && ksm.Key < winner.Key) {
+				best[ksm.Member] = ksm
+			}
+		}
+	}
+
+	out := make(map[string][]common.KeyScoreMember, len(m))
+	for key, keyScoreMembers := range m {
+		kept := make([]common.KeyScoreMember, 0, len(keyScoreMembers))
+		for _, ksm := range keyScoreMembers {
+			if best[ksm.Member] == ksm {
+				kept = append(kept, ksm)
+			}
+		}
+		out[key] = kept
+	}
+	return out
+}
+
+// dedupeTruncate dedupes the pages, which were selected with fetchLimit,
+// in place, and only then truncates them to the limit, as truncate does, so
+// that duplicates don't make pages shorter than the limit. Callers over-fetch
+// by the number of keys, as each member can be a duplicate in every other
+// key. A key which filled the fetch limit may have more beyond the page,
+// even if its deduped page is shorter than the limit.
+func dedupeTruncate(pages map[string][]common.KeyScoreMember, limit, fetchLimit int) map[string]bool {
+	full := make(map[string]bool, len(pages))
+	for key, page := range pages {
+		full[key] = len(page) >= fetchLimit
+	}
+	for key, page := range dedupeMembers(pages) {
+		pages[key] = page
+	}
+	truncated := truncate(pages, limit)
+	for key := range truncated {
+		truncated[key] = truncated[key] || full[key]
+	}
+	return truncated
+}
+
+func parseInt(values url.Values, key string, defaultValue int) (int, bool) {
+	valueStr := values.Get(key)
+	if valueStr == "" {
+		return defaultValue, false
+	}
+	value, err := strconv.ParseInt(valueStr, 10, 64)
+	if err != nil {
+		return defaultValue, true
+	}
+	return int(value), true
+}
+
+func parseBool(values url.Values, key string, defaultValue bool) (bool, bool) {
+	valueStr := values.Get(key)
+	if valueStr == "" {
+		return defaultValue, false
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return defaultValue, true
+	}
+	return value, true
+}
+
+func parseStr(values url.Values, key, defaultValue string) (string, bool) {
+	value := values.Get(key)
+	if value == "" {
+		return defaultValue, false
+	}
+	return value, true
+}
+
+// insertedJSON is the response to an insert.
+type insertedJSON struct {
+	Inserted int                `json:"inserted"`
+	Duration string             `json:"duration"`
+	Clusters *clusterWritesJSON `json:"clusters,omitempty"` // only for verbose inserts
+	Rejected *[]rejectionJSON   `json:"rejected,omitempty"` // only if rejections were requested
+	Sizes    map[string]int     `json:"sizes,omitempty"`    // only if sizes were requested
+}
+
+func respondInserted(w http.ResponseWriter, n int, duration time.Duration, result *farm.WriteResult, rejected []farm.Rejection, sizes map[string]int) {
+	response := insertedJSON{Inserted: n, Duration: duration.String()}
+	if result != nil {
+		clusters := writeResultJSON(*result)
+		response.Clusters = &clusters
+	}
+	if rejected != nil {
+		rejections := rejectionsJSON(rejected)
+		response.Rejected = &rejections
+	}
+	response.Sizes = sizes
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// audited wraps a write handler, so that every request is recorded in the
+// audit log after it's served, along with the requester and the response
+// code. Guarded deletes are recorded as "guarded_delete". The tuples are
+// taken from the body as decoded by the handler. A failure to record the
+// entry is logged, but doesn't change the response, which has already been
+// written.
+func audited(operation string, next http.HandlerFunc, auditor audit.Logger, requesterHeader string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, body := withDecodedBody(r)
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next(rec, r)
+
+		entry := audit.Entry{
+			Time:      time.Now(),
+			Operation: operation,
+			Requester: requester(r, requesterHeader),
+			RequestID: requestID(w),
+			Code:      rec.code,
+		}
+		if body.err != nil {
+			entry.Error = body.err.Error()
+		}
+		entry.Tuples = body.tuples
+		if guarded, _ := parseBool(r.URL.Query(), "guarded", false); guarded {
+			entry.Operation = "guarded_" + operation
+		}
+		if entry.Error == "" && rec.code != http.StatusOK {
+			entry.Error = http.StatusText(rec.code)
+		}
+		if err := auditor.Log(entry); err != nil {
+			log.Printf("%s %s: audit: %s", r.Method, r.URL.String(), err)
+		}
+	}
+}
+
+// keyPrefixed reports the number of keys, and the latency, of each request
+// per key prefix. The operation must be "insert" or "select"; the keys are
+// taken from the body as decoded by the handler.
+func keyPrefixed(operation string, next http.HandlerFunc, prefixer *instrumentation.KeyPrefixer, instr instrumentation.InstrumentationV2) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, body := withDecodedBody(r)
+
+		began := time.Now()
+		next(w, r)
+		took := time.Since(began)
+
+		if !body.decoded || body.err != nil {
+			return // rejected by the handler
+		}
+
+		var (
+			keys []string
+			name string // of the count metric
+		)
+		switch operation {
+		case "insert":
+			name = "insert.prefix.record"
+			for _, tuple := range body.tuples {
+				keys = append(keys, tuple.Key)
+			}
+		case "select":
+			name = "select.prefix.key"
+			for _, key := range body.keys {
+				keys = append(keys, string(key))
+			}
+		}
+
+		for prefix, n := range prefixer.Count(keys) {
+			labels := instrumentation.Labels{KeyPrefix: prefix}
+			instr.Count(r.Context(), name, n, labels)
+			instr.Observe(r.Context(), operation+".prefix.call.duration", took, labels)
+		}
+	}
+}
+
+// requester identifies the requester for audit entries, by the header, the
+// basic auth user, the common name of the verified TLS client certificate,
+// or the remote address, in that order.
+func requester(r *http.Request, header string) string {
+	if v := r.Header.Get(header); v != "" {
+		return v
+	}
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && r.TLS.VerifiedChains[0][0].Subject.CommonName != "" {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return r.RemoteAddr
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// respondSelected writes the records, with an ETag computed from a digest of
+// the records alone. If the request carries a matching If-None-Match header,
+// the records are unchanged, and only a 304 Not Modified is written.
+func respondSelected(w http.ResponseWriter, r *http.Request, records interface{}, duration time.Duration) {
+	respondSelectedWith(w, r, records, duration, nil)
+}
+
+// respondSelectedPages responds with the selected pages of each key, whether
+// each key has more elements beyond its page, and, if the signer isn't nil,
+// with the signed cursors of each non-empty page, in ascending or descending
+// order. If times isn't nil, it also responds with the time of each score.
+func respondSelectedPages(w http.ResponseWriter, r *http.Request, pages map[string][]common.KeyScoreMember, truncated map[string]bool, bounds map[string]scoreBounds, ascending bool, signer *cursorSigner, times *scoreTimes, began time.Time) {
+	fields := map[string]interface{}{"truncated": truncated}
+	if signer != nil {
+		fields["cursors"] = signer.pairs(pages, ascending, began)
+	}
+	if bounds != nil {
+		fields["bounds"] = bounds
+	}
+	if times != nil {
+		fields["times"] = times.pageTimes(pages)
+	}
+	respondSelectedWith(w, r, pages, time.Since(began), fields)
+}
+
+// truncate trims each page to the limit, and reports whether each page had
+// more elements. Pages are selected with one element more than the limit,
+// so a page which had more is truncated: its key has elements beyond it.
+func truncate(pages map[string][]common.KeyScoreMember, limit int) map[string]bool {
+	truncated := make(map[string]bool, len(pages))
+	for key, page := range pages {
+		if len(page) > limit {
+			pages[key], truncated[key] = page[:limit], true
+			continue
+		}
+		truncated[key] = false
+	}
+	return truncated
+}
+
+// respondSelectedPage is like respondSelectedPages, for a coalesced page of
+// the keys.
+func respondSelectedPage(w http.ResponseWriter, r *http.Request, page []common.KeyScoreMember, keys []string, ascending bool, signer *cursorSigner, times *scoreTimes, began time.Time) {
+	fields := map[string]interface{}{}
+	if signer != nil && len(page) > 0 {
+		fields["cursor"] = signer.pair(page, keys, ascending, began)
+	}
+	if times != nil {
+		fields["times"] = times.times(page)
+	}
+	respondSelectedWith(w, r, page, time.Since(began), fields)
+}
+
+// respondSelectedWith is like respondSelected, and adds the fields to the
+// response. The ETag only covers the records.
+func respondSelectedWith(w http.ResponseWriter, r *http.Request, records interface{}, duration time.Duration, fields map[string]interface{}) {
+	e := getEncoder()
+	defer putEncoder(e)
+
+	f := selectFormat(r, records)
+	var err error
+	switch f {
+	case formatMsgpack:
+		err = (&msgpackWriter{buf: &e.buf}).records(records)
+	case formatProtobuf:
+		err = (&protoWriter{buf: &e.buf}).records(records)
+	default:
+		err = e.encode(records)
+	}
+	if err != nil {
+		respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+		return
+	}
+	h := fnv.New64a()
+	h.Write(e.buf.Bytes())
+	etag := fmt.Sprintf(`"%016x"`, h.Sum64())
+
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept, Content-Type")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", f.mediaType())
+	switch f {
+	case formatMsgpack:
+		writeMsgpackSelected(w, e.buf.Bytes(), duration, fields)
+		return
+	case formatProtobuf:
+		writeProtoSelected(w, e.buf.Bytes(), duration, fields)
+		return
+	}
+
+	// Equivalent to encoding a map of duration and records with
+	// encoding/json, without copying and validating the records again.
+	fmt.Fprintf(w, `{"duration":%q,"records":`, duration.String())
+	w.Write(e.buf.Bytes())
+	for name, value := range fields {
+		buf, err := json.Marshal(value)
+		if err != nil {
+			continue // records already written
+		}
+		fmt.Fprintf(w, `,%q:`, name)
+		w.Write(buf)
+	}
+	w.Write([]byte("}\n"))
+}
+
+// etagMatch returns true if the If-None-Match header value matches the ETag.
+// Weak validators are compared as strong ones.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// deletedJSON is the response to a delete.
+type deletedJSON struct {
+	Deleted  int                `json:"deleted"`
+	Duration string             `json:"duration"`
+	Clusters *clusterWritesJSON `json:"clusters,omitempty"` // only for verbose deletes
+}
+
+func respondDeleted(w http.ResponseWriter, n int, duration time.Duration, result *farm.WriteResult) {
+	response := deletedJSON{Deleted: n, Duration: duration.String()}
+	if result != nil {
+		clusters := writeResultJSON(*result)
+		response.Clusters = &clusters
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// errorJSON is the response to every request which failed.
+type errorJSON struct {
+	Error       string             `json:"error"`
+	Code        int                `json:"code"`
+	Description string             `json:"description"`
+	Clusters    *clusterWritesJSON `json:"clusters,omitempty"`   // only for verbose writes
+	RequestID   string             `json:"request_id,omitempty"` // only for requests with an ID
+	Retry       *retryJSON         `json:"retry,omitempty"`      // only for writes which failed quorum
+}
+
+func respondError(w http.ResponseWriter, method, url string, code int, err error) {
+	writeError(w, method, url, code, err, errorJSON{
+		Error:       err.Error(),
+		Code:        code,
+		Description: http.StatusText(code),
+	})
+}
+
+// respondWriteError is like respondError, but includes the per-cluster
+// outcome of a failed verbose write.
+func respondWriteError(w http.ResponseWriter, method, url string, code int, err error, result farm.WriteResult) {
+	clusters := writeResultJSON(result)
+	writeError(w, method, url, code, err, errorJSON{
+		Error:       err.Error(),
+		Code:        code,
+		Description: http.StatusText(code),
+		Clusters:    &clusters,
+	})
+}
+
+// writeError logs the error, and writes the error response, both with the
+// request ID, if any.
+func writeError(w http.ResponseWriter, method, url string, code int, err error, response errorJSON) {
+	if id := requestID(w); id != "" {
+		log.Printf("%s %s: request %s: HTTP %d: %s", method, url, id, code, err)
+		response.RequestID = id
+	} else {
+		log.Printf("%s %s: HTTP %d: %s", method, url, code, err)
+	}
+	if quorumErr, ok := err.(farm.QuorumError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(quorumErr.RetryAfter.Seconds()))))
+		retry := retryHint(quorumErr)
+		response.Retry = &retry
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// clusterWritesJSON is farm.WriteResult, with the errors of the failed
+// clusters as strings, by cluster index.
+type clusterWritesJSON struct {
+	Required     int               `json:"required"`
+	Acknowledged []int             `json:"acknowledged"`
+	Failed       map[string]string `json:"failed"`
+	Quorum       bool              `json:"quorum"`
+}
+
+func writeResultJSON(result farm.WriteResult) clusterWritesJSON {
+	failed := make(map[string]string, len(result.Failed))
+	for index, err := range result.Failed {
+		failed[strconv.Itoa(index)] = err.Error()
+	}
+	return clusterWritesJSON{
+		Required:     result.Required,
+		Acknowledged: result.Acknowledged,
+		Failed:       failed,
+		Quorum:       result.Quorum,
+	}
+}
+
+// retryJSON hints clients of a write which failed quorum which clusters
+// failed it, and how long to back off before retrying it.
+type retryJSON struct {
+	FailedClusters []int   `json:"failed_clusters"`
+	After          string  `json:"after"`
+	AfterSeconds   float64 `json:"after_seconds"`
+}
+
+func retryHint(err farm.QuorumError) retryJSON {
+	return retryJSON{
+		FailedClusters: err.Failed(),
+		After:          err.RetryAfter.String(),
+		AfterSeconds:   err.RetryAfter.Seconds(),
+	}
+}
+
+// rejectionJSON is a rejected tuple, with keys and members base64 encoded
+// like every other tuple in the API.
+type rejectionJSON struct {
+	Key           []byte  `json:"key"`
+	Score         float64 `json:"score"`
+	Member        []byte  `json:"member"`
+	WinningScore  float64 `json:"winning_score"`
+	WinnerDeleted bool    `json:"winner_deleted"`
+}
+
+func rejectionsJSON(rejected []farm.Rejection) []rejectionJSON {
+	a := make([]rejectionJSON, len(rejected))
+	for i, rejection := range rejected {
+		a[i] = rejectionJSON{
+			Key:           []byte(rejection.Tuple.Key),
+			Score:         rejection.Tuple.Score,
+			Member:        []byte(rejection.Tuple.Member),
+			WinningScore:  rejection.WinningScore,
+			WinnerDeleted: rejection.WinnerDeleted,
+		}
+	}
+	return a
+}
+
+// evaluateScalarPercentage takes a string of the form "P%" (percent) or "S"
+// (straight scalar value), and evaluates that against the passed total n.
+// Percentages mean at least that percent; for example, "50%" of 3 evaluates
+// to 2. It is an error if the passed string evaluates to less than 1 or more
+// than n.
+func evaluateScalarPercentage(s string, n int) (int, error) {
+	if n <= 0 {
+		return -1, fmt.Errorf("n must be at least 1")
+	}
+
+	s = strings.TrimSpace(s)
+	var value int
+	if strings.HasSuffix(s, "%") {
+		percentInt, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil || percentInt <= 0 || percentInt > 100 {
+			return -1, fmt.Errorf("bad percentage input %q", s)
+		}
+		value = int(math.Ceil((float64(percentInt) / 100.0) * float64(n)))
+	} else {
+		value64, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return -1, fmt.Errorf("bad scalar input %q", s)
+		}
+		value = int(value64)
+	}
+	if value <= 0 || value > n {
+		return -1, fmt.Errorf("with n=%d, value=%d (from %q) is invalid", n, value, s)
+	}
+	return value, nil
+}
+
+type keyScoreMemberCursor struct {
+	common.KeyScoreMember
+	Cursor myBuffer `json:"cursor"`
+}
+
+type myBuffer struct{ bytes.Buffer }
+
+func (b myBuffer) MarshalJSON() ([]byte, error) { return b.Buffer.Bytes(), nil }
+
+func (b myBuffer) Write(p []byte) (int, error) { return b.Buffer.Write(p) }
+
+type keyScoreMembers []common.KeyScoreMember
+
+func (a keyScoreMembers) Len() int { return len(a) }
+
+func (a keyScoreMembers) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func (a keyScoreMembers) Less(i, j int) bool {
+	return a[i].Before(a[j])
+}
+
+type keyScoreMemberCursors []keyScoreMemberCursor
+
+func (a keyScoreMemberCursors) Len() int { return len(a) }
+
+func (a keyScoreMemberCursors) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func (a keyScoreMemberCursors) Less(i, j int) bool {
+	return a[i].Before(a[j].KeyScoreMember)
+}
@@ -0,0 +1,1307 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/soundcloud/roshi/audit"
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+	"github.com/soundcloud/roshi/instrumentation"
+	"github.com/soundcloud/roshi/instrumentation/plaintext"
+	"github.com/soundcloud/roshi/pool"
+)
+
+func TestParseReadStrategy(t *testing.T) {
+	for _, s := range []string{"SendAllReadAll", "sendonereadone, SendAllReadAll", "SendOneReadOne,"} {
+		if _, err := parseReadStrategy(s, 0, 0, "", 1); err != nil {
+			t.Errorf("%q: %s", s, err)
+		}
+	}
+	for _, s := range []string{"", " , ", "SendNoneReadNone", "SendOneReadOne,PreferLocalZone"} {
+		if _, err := parseReadStrategy(s, 0, 0, "", 1); err == nil {
+			t.Errorf("%q: expected an error, got none", s)
+		}
+	}
+}
+
+func TestEvaluateScalarPercentage(t *testing.T) {
+	for _, tuple := range []struct {
+		s        string
+		n        int
+		expected int
+		valid    bool
+	}{
+		{"", 3, -1, false},
+		{"-1%", 3, -1, false},
+		{"abc", 3, -1, false},
+
+		{"0%", 3, -1, false},
+		{"00%", 3, -1, false},
+		{"110%", 3, -1, false},
+
+		{"100%", 1, 1, true},
+		{"100% ", 1, 1, true},
+		{" 100%", 1, 1, true},
+		{"	 100%			", 1, 1, true},
+		{"99%", 1, 1, true},
+		{"50%", 1, 1, true},
+		{"1%", 1, 1, true},
+		{"0%", 1, -1, false},
+
+		{"100%", 3, 3, true},
+		{"99%", 3, 3, true},
+		{"67%", 3, 3, true},
+		{"65%", 3, 2, true},
+		{"51%", 3, 2, true},
+		{"34%", 3, 2, true},
+		{"32%", 3, 1, true},
+
+		{"50%", 5, 3, true},
+		{"50%", 10, 5, true},
+
+		{"1", 1, 1, true},
+		{"1", 2, 1, true},
+		{"1", 3, 1, true},
+		{"1", 99, 1, true},
+		{"99", 1, -1, false},
+	} {
+		got, err := evaluateScalarPercentage(tuple.s, tuple.n)
+		if (tuple.valid && err != nil) || (!tuple.valid && err == nil) {
+			t.Errorf("%q of %d: expected valid=%v, got error '%v' (value %d)", tuple.s, tuple.n, tuple.valid, err, got)
+			continue
+		}
+		if tuple.valid && tuple.expected != got {
+			t.Errorf("%q of %d: expected %d, got %d", tuple.s, tuple.n, tuple.expected, got)
+			continue
+		}
+	}
+}
+
+func TestHandleInsert(t *testing.T) {
+	farm := newMockFarm()
+	r := pat.New()
+	r.Post("/", handleInsert(farm))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	requestBody, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		common.KeyScoreMember{Key: "bar", Score: 200, Member: "xxx"},
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		common.KeyScoreMember{Key: "bar", Score: 900, Member: "yyy"},
+	})
+	resp, err := http.Post(server.URL, "text/plain", bytes.NewReader(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	t.Log(strings.TrimSpace(string(respBody)))
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+	}, farm.m["foo"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "bar", Score: 900, Member: "yyy"},
+		common.KeyScoreMember{Key: "bar", Score: 200, Member: "xxx"},
+	}, farm.m["bar"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestHandleInsertVerbose(t *testing.T) {
+	farm := newMockFarm()
+	r := pat.New()
+	r.Post("/", handleInsert(farm))
+	r.Delete("/", handleDelete(farm))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	requestBody, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+	})
+	resp, err := http.Post(server.URL+"?verbose=true", "text/plain", bytes.NewReader(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var verboseResponse struct {
+		Inserted int `json:"inserted"`
+		Clusters struct {
+			Required     int               `json:"required"`
+			Acknowledged []int             `json:"acknowledged"`
+			Failed       map[string]string `json:"failed"`
+			Quorum       bool              `json:"quorum"`
+		} `json:"clusters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&verboseResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, verboseResponse.Inserted; expected != got {
+		t.Errorf("inserted: expected %d, got %d", expected, got)
+	}
+	if expected, got := []int{0}, verboseResponse.Clusters.Acknowledged; !reflect.DeepEqual(expected, got) {
+		t.Errorf("acknowledged: expected %v, got %v", expected, got)
+	}
+	if !verboseResponse.Clusters.Quorum {
+		t.Errorf("expected quorum, got none")
+	}
+
+	// The mock farm doesn't support verbose deletes.
+	req, _ := http.NewRequest("DELETE", server.URL+"?verbose=true", bytes.NewReader(requestBody))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("verbose delete: expected HTTP %d, got %d", expected, got)
+	}
+}
+
+func TestInsertQuorumFailure(t *testing.T) {
+	var (
+		up   = clustertest.New()
+		down = clustertest.New()
+	)
+	down.FailWith(clustertest.Insert, fmt.Errorf("connection refused"))
+	f := farm.New([]cluster.Cluster{up, down}, 2, farm.SendAllReadAll, farm.NoRepairs, nil, farm.QuorumRetryAfter(1500*time.Millisecond, time.Minute))
+	r := pat.New()
+	r.Post("/", handleInsert(f))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	requestBody, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+	})
+	resp, err := http.Post(server.URL, "text/plain", bytes.NewReader(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusServiceUnavailable, resp.StatusCode; expected != got {
+		t.Fatalf("expected HTTP %d, got %d", expected, got)
+	}
+	if expected, got := "2", resp.Header.Get("Retry-After"); expected != got {
+		t.Errorf("Retry-After: expected %q, got %q", expected, got)
+	}
+
+	var errorResponse struct {
+		Retry struct {
+			FailedClusters []int   `json:"failed_clusters"`
+			After          string  `json:"after"`
+			AfterSeconds   float64 `json:"after_seconds"`
+		} `json:"retry"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []int{1}, errorResponse.Retry.FailedClusters; !reflect.DeepEqual(expected, got) {
+		t.Errorf("failed clusters: expected %v, got %v", expected, got)
+	}
+	if expected, got := 1.5, errorResponse.Retry.AfterSeconds; expected != got {
+		t.Errorf("after: expected %v seconds, got %v", expected, got)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	timeout := pool.TimeoutError{Address: "localhost:6379", Err: fmt.Errorf("i/o timeout")}
+	for _, testCase := range []struct {
+		err  error
+		code int
+	}{
+		{farm.MemberTooLargeError{Count: 1, Largest: 8, Max: 4}, http.StatusBadRequest},
+		{farm.ScoreSkewError{Count: 1, Latest: 2e9, Limit: 1e9, Max: time.Minute}, http.StatusBadRequest},
+		{farm.QuorumError{Result: farm.WriteResult{Failed: map[int]error{0: timeout}}}, http.StatusServiceUnavailable},
+		{timeout, http.StatusGatewayTimeout},
+		{pool.ExhaustedError{}, http.StatusServiceUnavailable},
+		{farm.ErrOverloaded, http.StatusServiceUnavailable},
+		{fmt.Errorf("complete failure"), http.StatusInternalServerError},
+	} {
+		if expected, got := testCase.code, errorCode(testCase.err); expected != got {
+			t.Errorf("%v: expected %d, got %d", testCase.err, expected, got)
+		}
+	}
+}
+
+func TestSelectDefaults(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL, bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var normalResponse struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"},
+			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+			common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		},
+		"bar": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
+			common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+			common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		},
+	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestSelectOffsetLimit(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL+"?offset=1&limit=1", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var normalResponse struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		},
+		"bar": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+		},
+	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestSelectStride(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL+"?stride=2&limit=10", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var normalResponse struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"},
+			common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		},
+		"bar": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
+			common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		},
+	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	for _, query := range []string{"?stride=0", "?stride=2&order=asc", "?stride=2&coalesce=true"} {
+		req, _ := http.NewRequest("GET", server.URL+query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected %d, got %d", query, expected, got)
+		}
+	}
+}
+
+func TestSelectTruncated(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar"), []byte("baz")})
+	for _, c := range []struct {
+		query    string
+		limit    int
+		expected map[string]bool
+	}{
+		{"?limit=2", 2, map[string]bool{"foo": true, "bar": true, "baz": false}},
+		{"?limit=3", 3, map[string]bool{"foo": false, "bar": false, "baz": false}},
+		{"?offset=1&limit=2", 2, map[string]bool{"foo": false, "bar": false, "baz": false}},
+		{"?offset=0&limit=0", 0, map[string]bool{"foo": true, "bar": true, "baz": false}},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+c.query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Records   map[string][]common.KeyScoreMember `json:"records"`
+			Truncated map[string]bool                    `json:"truncated"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.expected, response.Truncated) {
+			t.Errorf("%s: expected %v, got %v", c.query, c.expected, response.Truncated)
+		}
+		for key, records := range response.Records {
+			if len(records) > c.limit {
+				t.Errorf("%s: %s: expected at most %d records, got %d", c.query, key, c.limit, len(records))
+			}
+		}
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"?limit=-1", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("negative limit: expected %d, got %d", expected, got)
+	}
+}
+
+func TestBulkSelect(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([]bulkSelect{
+		bulkSelect{Key: []byte("foo"), Offset: 1, Limit: 1},
+		bulkSelect{Key: []byte("bar")},
+		bulkSelect{Key: []byte("foo"), Offset: 2},
+		bulkSelect{Key: []byte("baz")},
+	})
+	resp, err := http.Post(server.URL+"/select/bulk", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Records [][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := [][]common.KeyScoreMember{
+		[]common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		},
+		[]common.KeyScoreMember{
+			common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
+			common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+			common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		},
+		[]common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		},
+		[]common.KeyScoreMember{},
+	}, response.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	body, _ = json.Marshal([]bulkSelect{bulkSelect{Key: []byte("foo"), Offset: -1}})
+	resp, err = http.Post(server.URL+"/select/bulk", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("negative offset: expected HTTP %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestSelectBuckets(t *testing.T) {
+	var (
+		buckets  = farm.Buckets{Period: time.Hour, Layout: "2006-01-02T15"}
+		now      = time.Now()
+		current  = buckets.Key("clicks", now)
+		previous = buckets.Key("clicks", now.Add(-time.Hour))
+		old      = buckets.Key("clicks", now.Add(-3*time.Hour))
+		mock     = newMockFarm()
+		r        = pat.New()
+	)
+	mock.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: current, Score: 3, Member: "c"},
+		common.KeyScoreMember{Key: previous, Score: 2, Member: "b"},
+		common.KeyScoreMember{Key: previous, Score: 4, Member: "d"},
+		common.KeyScoreMember{Key: old, Score: 1, Member: "a"},
+	})
+	r.Get("/select/buckets", handleSelectBuckets(mock))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(query string) (int, []common.KeyScoreMember) {
+		resp, err := http.Get(server.URL + "/select/buckets?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var response struct {
+			Records []common.KeyScoreMember `json:"records"`
+		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		return resp.StatusCode, response.Records
+	}
+
+	code, records := get("key=clicks&period=1h&layout=2006-01-02T15&window=2h&limit=2")
+	if code != http.StatusOK {
+		t.Fatalf("HTTP %d", code)
+	}
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: previous, Score: 4, Member: "d"},
+		common.KeyScoreMember{Key: current, Score: 3, Member: "c"},
+	}, records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	for _, query := range []string{
+		"period=1h",
+		"key=clicks&period=-1h",
+		"key=clicks&period=1s&window=24h",
+	} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%q: expected HTTP %d, got %d", query, http.StatusBadRequest, code)
+		}
+	}
+}
+
+func TestSelectCoalesce(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL+"?coalesce=true", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var coalescedResponse struct {
+		Records []common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&coalescedResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"},
+		common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
+		common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+	}, coalescedResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestSelectCoalesceOffsetLimit(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL+"?coalesce=true&offset=2&limit=2", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var coalescedResponse struct {
+		Records []common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&coalescedResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+	}, coalescedResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestSelectOrderAscending(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	req, _ := http.NewRequest("GET", server.URL+"?order=asc&offset=1&limit=2", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var normalResponse struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+			common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"},
+		},
+	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	for _, query := range []string{"?order=sideways", "?order=asc&start=1A"} {
+		req, _ = http.NewRequest("GET", server.URL+query, bytes.NewReader(body))
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+			t.Errorf("%s: expected HTTP %d, got %d", query, expected, got)
+		}
+	}
+}
+
+func TestInsertRejections(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 100, Member: "abc"}, // older than 123
+		common.KeyScoreMember{Key: "foo", Score: 999, Member: "new"},
+	})
+	resp, err := http.Post(server.URL+"?rejections=true", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Inserted int `json:"inserted"`
+		Rejected []struct {
+			Key           []byte  `json:"key"`
+			Score         float64 `json:"score"`
+			Member        []byte  `json:"member"`
+			WinningScore  float64 `json:"winning_score"`
+			WinnerDeleted bool    `json:"winner_deleted"`
+		} `json:"rejected"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 2, response.Inserted; expected != got {
+		t.Errorf("expected %d inserted, got %d", expected, got)
+	}
+	if expected, got := 1, len(response.Rejected); expected != got {
+		t.Fatalf("expected %d rejected, got %d", expected, got)
+	}
+	if r := response.Rejected[0]; string(r.Key) != "foo" || string(r.Member) != "abc" || r.Score != 100 || r.WinningScore != 123 || r.WinnerDeleted {
+		t.Errorf("unexpected rejection %+v", r)
+	}
+}
+
+func TestInsertSizes(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	body, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1000, Member: "new"},
+		common.KeyScoreMember{Key: "baz", Score: 1000, Member: "new"},
+	})
+	resp, err := http.Post(server.URL+"?sizes=true", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Inserted int            `json:"inserted"`
+		Sizes    map[string]int `json:"sizes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]int{"foo": 4, "baz": 1}; !reflect.DeepEqual(expected, response.Sizes) {
+		t.Errorf("expected sizes %v, got %v", expected, response.Sizes)
+	}
+}
+
+func TestInsertConcern(t *testing.T) {
+	var (
+		local  = clustertest.New()
+		remote = clustertest.New()
+		f      = farm.New([]cluster.Cluster{local, remote}, 2, farm.SendAllReadAll, farm.NoRepairs, nil, farm.Zones([]string{"a", "b"}), farm.LocalWrites("a", 0, 0, 10))
+		r      = pat.New()
+	)
+	r.Post("/", handleInsert(f))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	remote.FailWith(clustertest.Insert, fmt.Errorf("failtown"))
+	body, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1000, Member: "new"},
+	})
+	for query, expected := range map[string]int{
+		"":                http.StatusServiceUnavailable,
+		"?concern=quorum": http.StatusServiceUnavailable,
+		"?concern=local":  http.StatusOK,
+		"?concern=all":    http.StatusBadRequest,
+	} {
+		resp, err := http.Post(server.URL+query, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("%q: expected HTTP %d, got %d", query, expected, resp.StatusCode)
+		}
+	}
+}
+
+func TestSelectWithoutRepairs(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+	})
+	r := pat.New()
+	r.Get("/", handleSelect(farm, time.Second, nil, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	for query, expected := range map[string]int{
+		"":              0,
+		"?repair=true":  0,
+		"?repair=false": 1,
+	} {
+		farm.unrepaired = 0
+		req, _ := http.NewRequest("GET", server.URL+query, bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("%q: HTTP %d", query, resp.StatusCode)
+		}
+		if got := farm.unrepaired; expected != got {
+			t.Errorf("%q: expected %d repair-exempt selects, got %d", query, expected, got)
+		}
+	}
+}
+
+func TestSelectPartial(t *testing.T) {
+	var (
+		fast = clustertest.New()
+		slow = clustertest.New()
+		ksm  = common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"}
+	)
+	fast.Insert([]common.KeyScoreMember{ksm})
+	slow.Insert([]common.KeyScoreMember{ksm})
+	slow.Delay(clustertest.SelectOffset, time.Second)
+	f := farm.New([]cluster.Cluster{fast, slow}, 2, farm.SendAllReadAll, farm.NoRepairs, nil)
+
+	r := pat.New()
+	r.Get("/", handleSelect(f, 50*time.Millisecond, nil, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo")})
+	req, _ := http.NewRequest("GET", server.URL+"?partial=true", bytes.NewReader(body))
+	began := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+	if d := time.Since(began); d >= time.Second {
+		t.Errorf("partial select took %s, waiting for the slow cluster", d)
+	}
+	if expected, got := "partial", resp.Header.Get("X-Roshi-Consistency"); expected != got {
+		t.Errorf("expected consistency %q, got %q", expected, got)
+	}
+	if expected, got := "1", resp.Header.Get("X-Roshi-Missed-Clusters"); expected != got {
+		t.Errorf("expected missed clusters %q, got %q", expected, got)
+	}
+
+	var response struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []common.KeyScoreMember{ksm}, response.Records["foo"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSelectDedupeMember(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 100, Member: "abc"},
+		common.KeyScoreMember{Key: "foo", Score: 300, Member: "def"},
+		common.KeyScoreMember{Key: "bar", Score: 200, Member: "abc"},
+		common.KeyScoreMember{Key: "bar", Score: 300, Member: "def"},
+		common.KeyScoreMember{Key: "bar", Score: 400, Member: "ghi"},
+	})
+	r := pat.New()
+	r.Get("/", handleSelect(farm, time.Second, nil, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL+"?dedupe=member", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var normalResponse struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{},
+		"bar": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "bar", Score: 400, Member: "ghi"},
+			common.KeyScoreMember{Key: "bar", Score: 300, Member: "def"}, // tie: "bar" < "foo"
+			common.KeyScoreMember{Key: "bar", Score: 200, Member: "abc"},
+		},
+	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	req, _ = http.NewRequest("GET", server.URL+"?dedupe=key", bytes.NewReader(body))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("dedupe=key: expected HTTP %d, got %d", expected, got)
+	}
+}
+
+func TestSelectDedupeFillsPages(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 3, Member: "a"},
+		common.KeyScoreMember{Key: "foo", Score: 2, Member: "b"},
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "c"},
+		common.KeyScoreMember{Key: "foo", Score: 0.5, Member: "d"},
+		common.KeyScoreMember{Key: "bar", Score: 10, Member: "a"},
+		common.KeyScoreMember{Key: "bar", Score: 9, Member: "b"},
+	})
+	r := pat.New()
+	r.Get("/", handleSelect(farm, time.Second, nil, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ := http.NewRequest("GET", server.URL+"?dedupe=member&limit=2", bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Records   map[string][]common.KeyScoreMember `json:"records"`
+		Truncated map[string]bool                    `json:"truncated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	// The duplicates a and b are dropped from foo before it's truncated, so
+	// its page is still full.
+	if expected, got := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "c"},
+		common.KeyScoreMember{Key: "foo", Score: 0.5, Member: "d"},
+	}, response.Records["foo"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if expected, got := map[string]bool{"foo": false, "bar": false}, response.Truncated; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected truncated %v, got %v", expected, got)
+	}
+}
+
+func TestSelectETag(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 100, Member: "abc"},
+	})
+	r := pat.New()
+	r.Get("/", handleSelect(farm, time.Second, nil, nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	get := func(ifNoneMatch string) *http.Response {
+		body, _ := json.Marshal([][]byte{[]byte("foo")})
+		req, _ := http.NewRequest("GET", server.URL, bytes.NewReader(body))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("")
+	etag := resp.Header.Get("ETag")
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected HTTP %d, got %d", expected, got)
+	}
+	if etag == "" {
+		t.Fatal("no ETag in response")
+	}
+
+	// Unchanged records yield a 304, regardless of the duration.
+	resp = get(etag)
+	if expected, got := http.StatusNotModified, resp.StatusCode; expected != got {
+		t.Errorf("expected HTTP %d, got %d", expected, got)
+	}
+	if expected, got := etag, resp.Header.Get("ETag"); expected != got {
+		t.Errorf("expected ETag %s, got %s", expected, got)
+	}
+
+	// Changed records yield a new ETag.
+	farm.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 200, Member: "def"},
+	})
+	resp = get(etag)
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Errorf("expected HTTP %d, got %d", expected, got)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Errorf("expected a new ETag, got the same %s", etag)
+	}
+}
+
+func TestHandleDelete(t *testing.T) {
+	server := fixtureServer()
+	defer server.Close()
+
+	// Make DELETE
+	body, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 0, Member: "ghi"},
+		common.KeyScoreMember{Key: "bar", Score: 0, Member: "yyy"},
+	})
+	req, _ := http.NewRequest("DELETE", server.URL, bytes.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	// Make SELECT and confirm
+	body, _ = json.Marshal([][]byte{[]byte("foo"), []byte("bar")})
+	req, _ = http.NewRequest("GET", server.URL, bytes.NewReader(body))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	var normalResponse struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&normalResponse); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			// common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"}, // deleted
+			common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+			common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		},
+		"bar": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
+			// common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"}, // deleted
+			common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		},
+	}, normalResponse.Records; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+type mockAuditor struct{ entries []audit.Entry }
+
+func (a *mockAuditor) Log(e audit.Entry) error {
+	a.entries = append(a.entries, e)
+	return nil
+}
+
+// guardingFarm is a mockFarm which applies no guarded delete.
+type guardingFarm struct{ *mockFarm }
+
+func (f guardingFarm) DeleteIf(deletes []cluster.GuardedDelete) ([]bool, error) {
+	return make([]bool, len(deletes)), nil
+}
+
+func TestAuditedDelete(t *testing.T) {
+	var (
+		farm    = newMockFarm()
+		auditor = &mockAuditor{}
+		r       = pat.New()
+	)
+	r.Delete("/", audited("delete", handleDelete(guardingFarm{farm}), auditor, "X-Requester"))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	tuples := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "abc"},
+	}
+	body, _ := json.Marshal(tuples)
+	req, _ := http.NewRequest("DELETE", server.URL, bytes.NewReader(body))
+	req.Header.Set("X-Requester", "alice")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest("DELETE", server.URL, strings.NewReader("invalid"))
+	req.SetBasicAuth("bob", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest("DELETE", server.URL+"?guarded=true", strings.NewReader(`[{"key":"Zm9v","score":2,"member":"YWJj","expected":1}]`))
+	req.Header.Set("X-Requester", "carol")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if expected, got := 3, len(auditor.entries); expected != got {
+		t.Fatalf("expected %d audit entries, got %d", expected, got)
+	}
+	if e := auditor.entries[0]; e.Operation != "delete" || e.Requester != "alice" || e.Code != http.StatusOK || e.Error != "" || !reflect.DeepEqual(tuples, e.Tuples) {
+		t.Errorf("unexpected first entry %+v", e)
+	}
+	if e := auditor.entries[1]; e.Requester != "bob" || e.Code != http.StatusBadRequest || e.Error == "" {
+		t.Errorf("unexpected second entry %+v", e)
+	}
+	if e := auditor.entries[2]; e.Operation != "guarded_delete" || e.Requester != "carol" || len(e.Tuples) != 1 {
+		t.Errorf("unexpected third entry %+v", e)
+	}
+}
+
+func TestKeyPrefixed(t *testing.T) {
+	var (
+		farm     = newMockFarm()
+		buf      = &bytes.Buffer{}
+		prefixer = instrumentation.NewKeyPrefixer(":", 10)
+		r        = pat.New()
+	)
+	r.Post("/", keyPrefixed("insert", handleInsert(farm), prefixer, plaintext.NewV2(buf)))
+	r.Get("/", keyPrefixed("select", handleSelect(farm, time.Second, nil, nil), prefixer, plaintext.NewV2(buf)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	body, _ := json.Marshal([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "timeline:1", Score: 1, Member: "abc"},
+		common.KeyScoreMember{Key: "timeline:2", Score: 1, Member: "abc"},
+		common.KeyScoreMember{Key: "notifications:1", Score: 1, Member: "abc"},
+	})
+	resp, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	// The tuples were inserted as usual.
+	if expected, got := 2, len(farm.m["timeline:1"])+len(farm.m["notifications:1"]); expected != got {
+		t.Errorf("expected %d inserted tuples, got %d", expected, got)
+	}
+
+	body, _ = json.Marshal([][]byte{[]byte("timeline:1"), []byte("timeline:2")})
+	req, _ := http.NewRequest("GET", server.URL, bytes.NewReader(body))
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("HTTP %d", resp.StatusCode)
+	}
+
+	// A body the handler rejects isn't counted.
+	if resp, err = http.Post(server.URL, "application/json", strings.NewReader("[{")); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Fatalf("expected HTTP 400, got %d", resp.StatusCode)
+	}
+
+	for _, line := range []string{
+		`insert.prefix.record.count{key_prefix="timeline"} 2`,
+		`insert.prefix.record.count{key_prefix="notifications"} 1`,
+		`select.prefix.key.count{key_prefix="timeline"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in output %q", line, buf.String())
+		}
+	}
+}
+
+func TestFlattenOrdering(t *testing.T) {
+	// TODO(pb): need flattenOffset and flattenCursor
+}
+
+func fixtureServer() *httptest.Server {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 123, Member: "abc"},
+		common.KeyScoreMember{Key: "foo", Score: 456, Member: "def"},
+		common.KeyScoreMember{Key: "foo", Score: 789, Member: "ghi"},
+		common.KeyScoreMember{Key: "bar", Score: 250, Member: "xxx"},
+		common.KeyScoreMember{Key: "bar", Score: 500, Member: "yyy"},
+		common.KeyScoreMember{Key: "bar", Score: 750, Member: "zzz"},
+	})
+	r := pat.New()
+	r.Post("/select/bulk", handleBulkSelect(farm))
+	r.Post("/", handleInsert(farm))
+	r.Get("/", handleSelect(farm, time.Second, nil, nil))
+	r.Delete("/", handleDelete(farm))
+	return httptest.NewServer(r)
+}
+
+type mockFarm struct {
+	m          map[string][]common.KeyScoreMember
+	unrepaired int // how many times WithoutRepairs was called
+}
+
+func newMockFarm() *mockFarm {
+	return &mockFarm{
+		m: map[string][]common.KeyScoreMember{},
+	}
+}
+
+func (f *mockFarm) Insert(tuples []common.KeyScoreMember) error {
+	for _, tuple := range tuples {
+		newTuples := append(f.m[tuple.Key], tuple)
+		sort.Sort(keyScoreMembers(newTuples))
+		f.m[tuple.Key] = newTuples
+	}
+	return nil
+}
+
+func (f *mockFarm) InsertVerbose(tuples []common.KeyScoreMember) (farm.WriteResult, error) {
+	if err := f.Insert(tuples); err != nil {
+		return farm.WriteResult{Required: 1, Acknowledged: []int{}, Failed: map[int]error{0: err}}, err
+	}
+	return farm.WriteResult{Required: 1, Acknowledged: []int{0}, Failed: map[int]error{}, Quorum: true}, nil
+}
+
+func (f *mockFarm) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	m := map[string][]common.KeyScoreMember{}
+	for _, key := range keys {
+		m[key] = f.m[key]
+
+		if len(m[key]) < offset {
+			m[key] = []common.KeyScoreMember{}
+			continue
+		}
+		m[key] = m[key][offset:]
+		if len(m[key]) > limit {
+			m[key] = m[key][:limit]
+		}
+	}
+	return m, nil
+}
+
+func (f *mockFarm) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	m := map[string][]common.KeyScoreMember{}
+	for _, key := range keys {
+		a := make([]common.KeyScoreMember, 0, len(f.m[key]))
+		for i := len(f.m[key]) - 1; i >= 0; i-- {
+			a = append(a, f.m[key][i])
+		}
+
+		if len(a) < offset {
+			m[key] = []common.KeyScoreMember{}
+			continue
+		}
+		a = a[offset:]
+		if len(a) > limit {
+			a = a[:limit]
+		}
+		m[key] = a
+	}
+	return m, nil
+}
+
+func (f *mockFarm) SelectStride(keys []string, offset, stride, limit int) (map[string][]common.KeyScoreMember, error) {
+	m := map[string][]common.KeyScoreMember{}
+	for _, key := range keys {
+		m[key] = []common.KeyScoreMember{}
+		for i := offset; i < len(f.m[key]) && len(m[key]) < limit; i += stride {
+			m[key] = append(m[key], f.m[key][i])
+		}
+	}
+	return m, nil
+}
+
+func (f *mockFarm) Rejected(tuples []common.KeyScoreMember) ([]farm.Rejection, error) {
+	rejections := []farm.Rejection{}
+	for _, tuple := range tuples {
+		for _, existing := range f.m[tuple.Key] {
+			if existing.Member == tuple.Member && existing.Score > tuple.Score {
+				rejections = append(rejections, farm.Rejection{Tuple: tuple, WinningScore: existing.Score})
+				break
+			}
+		}
+	}
+	return rejections, nil
+}
+
+func (f *mockFarm) Sizes(tuples []common.KeyScoreMember) (map[string]int, error) {
+	sizes := map[string]int{}
+	for _, tuple := range tuples {
+		sizes[tuple.Key] = len(f.m[tuple.Key])
+	}
+	return sizes, nil
+}
+
+func (f *mockFarm) WithoutRepairs() farm.Selecter {
+	f.unrepaired++
+	return f
+}
+
+// SelectRange only compares scores, which suffices for distinct scores.
+func (f *mockFarm) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	m := map[string][]common.KeyScoreMember{}
+	for _, key := range keys {
+		m[key] = []common.KeyScoreMember{}
+		for _, tuple := range f.m[key] {
+			if tuple.Score < start.Score && tuple.Score > stop.Score && len(m[key]) < limit {
+				m[key] = append(m[key], tuple)
+			}
+		}
+	}
+	return m, nil
+}
+
+func (f *mockFarm) Delete(tuples []common.KeyScoreMember) error {
+	toDelete := map[string]map[string]bool{}
+	for _, tuple := range tuples {
+		if _, ok := toDelete[tuple.Key]; !ok {
+			toDelete[tuple.Key] = map[string]bool{}
+		}
+		toDelete[tuple.Key][tuple.Member] = true
+	}
+
+	replacementMap := map[string][]common.KeyScoreMember{}
+	for key, keyScoreMembers := range f.m {
+		replacements := []common.KeyScoreMember{}
+		for _, keyScoreMember := range keyScoreMembers {
+
+			if _, ok := toDelete[key]; ok && toDelete[key][keyScoreMember.Member] {
+				continue // delete
+			}
+			replacements = append(replacements, keyScoreMember)
+
+		}
+		replacementMap[key] = replacements
+	}
+	f.m = replacementMap
+
+	return nil
+}
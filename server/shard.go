@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// locator is implemented by farms which can tell where their keys are
+// stored, like *farm.Farm.
+type locator interface {
+	Locate(key string) ([]cluster.Location, error)
+}
+
+// shardJSON is the response to a shard request.
+type shardJSON struct {
+	Key      string             `json:"key"`
+	Clusters []cluster.Location `json:"clusters"`
+}
+
+var shardDoc = apiDoc{
+	summary:  "Report the Redis instance a key maps to in each cluster",
+	params:   []apiParam{{"key", "string", "The key, not base64-encoded"}},
+	response: shardJSON{},
+}
+
+// handleShard reports the Redis instance which the key parameter maps to in
+// each cluster, so operators can find the data of a key without
+// reimplementing the hash. The key is taken as is, not base64-encoded.
+func handleShard(l locator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		if _, ok := r.Form["key"]; !ok {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, fmt.Errorf("key required"))
+			return
+		}
+		key := r.Form.Get("key")
+
+		locations, err := l.Locate(key)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shardJSON{Key: key, Clusters: locations})
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+type fixedLocator map[string][]cluster.Location
+
+func (l fixedLocator) Locate(key string) ([]cluster.Location, error) { return l[key], nil }
+
+func TestShard(t *testing.T) {
+	locations := []cluster.Location{
+		{Index: 1, Address: "10.0.0.1:6379"},
+		{Index: 0, Address: "10.0.1.1:6379"},
+	}
+	r := pat.New()
+	r.Get("/admin/shard", handleShard(fixedLocator{"foo:bar": locations}))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/admin/shard?key=" + url.QueryEscape("foo:bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+	var response struct {
+		Key      string             `json:"key"`
+		Clusters []cluster.Location `json:"clusters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "foo:bar", response.Key; expected != got {
+		t.Errorf("expected key %q, got %q", expected, got)
+	}
+	if expected, got := locations, response.Clusters; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	resp, err = http.Get(server.URL + "/admin/shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("without key: expected %d, got %d", expected, got)
+	}
+}
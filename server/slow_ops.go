@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+// slowOpReporter is implemented by farms which log slow ops, like
+// *farm.Farm.
+type slowOpReporter interface {
+	SlowOps() []farm.SlowOp
+}
+
+// slowOpsJSON is the response to a slow ops request.
+type slowOpsJSON struct {
+	Ops []farm.SlowOp `json:"ops"`
+}
+
+var slowOpsDoc = apiDoc{
+	summary:  "Report the last selects and inserts slower than the threshold",
+	response: slowOpsJSON{},
+}
+
+// handleSlowOps reports the slow op log of the farm, the most recent first.
+// It responds 404 if slow ops aren't logged.
+func handleSlowOps(s slowOpReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ops := s.SlowOps()
+		if ops == nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusNotFound, fmt.Errorf("slow ops not logged"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(slowOpsJSON{Ops: ops})
+	}
+}
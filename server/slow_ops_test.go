@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+type fixedSlowOps []farm.SlowOp
+
+func (s fixedSlowOps) SlowOps() []farm.SlowOp { return s }
+
+func TestSlowOps(t *testing.T) {
+	ops := fixedSlowOps{
+		{
+			Op:       "select",
+			Began:    time.Unix(1400000000, 0).UTC(),
+			Duration: 120 * time.Millisecond,
+			Keys:     []string{"foo"},
+			NumKeys:  1,
+			Limit:    10,
+			Strategy: "SendAllReadAll",
+			Clusters: []farm.ClusterTiming{{Index: 0, Duration: time.Millisecond}, {Index: 1, Duration: 118 * time.Millisecond}},
+			Results:  10,
+		},
+	}
+	for _, testCase := range []struct {
+		ops      fixedSlowOps
+		expected int
+	}{
+		{ops, http.StatusOK},
+		{fixedSlowOps{}, http.StatusOK},
+		{nil, http.StatusNotFound},
+	} {
+		r := pat.New()
+		r.Get("/admin/slow", handleSlowOps(testCase.ops))
+		server := httptest.NewServer(r)
+		resp, err := http.Get(server.URL + "/admin/slow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := testCase.expected, resp.StatusCode; expected != got {
+			t.Errorf("expected %d, got %d", expected, got)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var response slowOpsJSON
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if expected, got := []farm.SlowOp(testCase.ops), response.Ops; !reflect.DeepEqual(expected, got) {
+				t.Errorf("expected %+v, got %+v", expected, got)
+			}
+		}
+		resp.Body.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// scoreTimes interprets scores as Unix timestamps in a unit, so that selects
+// can be bounded by times, and responses can carry the time of each score.
+// A nil *scoreTimes means scores aren't times.
+type scoreTimes struct {
+	unit time.Duration
+}
+
+// newScoreTimes returns the scoreTimes of the unit: "s", "ms", "us", or "ns".
+// A blank unit returns nil.
+func newScoreTimes(unit string) (*scoreTimes, error) {
+	switch unit {
+	case "":
+		return nil, nil
+	case "s":
+		return &scoreTimes{unit: time.Second}, nil
+	case "ms":
+		return &scoreTimes{unit: time.Millisecond}, nil
+	case "us":
+		return &scoreTimes{unit: time.Microsecond}, nil
+	case "ns":
+		return &scoreTimes{unit: time.Nanosecond}, nil
+	default:
+		return nil, fmt.Errorf("invalid score time unit %q (must be %q, %q, %q, or %q)", unit, "s", "ms", "us", "ns")
+	}
+}
+
+// score returns the score of the time.
+func (st *scoreTimes) score(t time.Time) float64 {
+	perSecond := float64(time.Second / st.unit)
+	return float64(t.Unix())*perSecond + float64(t.Nanosecond())/float64(st.unit)
+}
+
+// format returns the RFC 3339 time of the score, in UTC, or "" if the score
+// isn't a time between the Unix epoch and the end of year 9999, which RFC
+// 3339 can't represent.
+func (st *scoreTimes) format(score float64) string {
+	seconds := score / float64(time.Second/st.unit)
+	if math.IsNaN(seconds) || seconds < 0 || seconds >= maxScoreTimeSeconds {
+		return ""
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC().Format(time.RFC3339Nano)
+}
+
+// maxScoreTimeSeconds is the first second of year 10000.
+const maxScoreTimeSeconds = 253402300800
+
+// parseRange parses the since and until parameters of a select, RFC 3339
+// times of which either may be blank, into the stop and start cursors of a
+// SelectRange. Since is inclusive, and until exclusive.
+func (st *scoreTimes) parseRange(sinceStr, untilStr string) (start, stop *common.Cursor, err error) {
+	var since, until time.Time
+	if sinceStr != "" {
+		if since, err = parseScoreTime("since", sinceStr); err != nil {
+			return nil, nil, err
+		}
+		// Every member at the score is after the empty member, which is
+		// the only one the stop cursor excludes.
+		stop = &common.Cursor{Score: st.score(since), Member: ""}
+	}
+	if untilStr != "" {
+		if until, err = parseScoreTime("until", untilStr); err != nil {
+			return nil, nil, err
+		}
+		// No member is before the empty member, so the start cursor
+		// excludes every member at the score.
+		start = &common.Cursor{Score: st.score(until), Member: ""}
+	}
+	if start != nil && stop != nil && !since.Before(until) {
+		return nil, nil, fmt.Errorf("invalid time range (since %s is not before until %s)", sinceStr, untilStr)
+	}
+	return start, stop, nil
+}
+
+func parseScoreTime(name, s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid %s %q (must be an RFC 3339 time)", name, s)
+	}
+	if t.Before(time.Unix(0, 0)) {
+		return t, fmt.Errorf("invalid %s %q (must not be before the Unix epoch)", name, s)
+	}
+	return t, nil
+}
+
+// pageTimes returns the times of the scores of each page, in order.
+func (st *scoreTimes) pageTimes(pages map[string][]common.KeyScoreMember) map[string][]string {
+	times := make(map[string][]string, len(pages))
+	for key, page := range pages {
+		times[key] = st.times(page)
+	}
+	return times
+}
+
+// times returns the times of the scores of the page, in order.
+func (st *scoreTimes) times(page []common.KeyScoreMember) []string {
+	times := make([]string, len(page))
+	for i := range page {
+		times[i] = st.format(page[i].Score)
+	}
+	return times
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestScoreTimes(t *testing.T) {
+	if st, err := newScoreTimes(""); st != nil || err != nil {
+		t.Fatalf("blank unit: expected nil, nil, got %v, %v", st, err)
+	}
+	if _, err := newScoreTimes("min"); err == nil {
+		t.Fatal("invalid unit: expected error, got none")
+	}
+
+	when := time.Date(2015, 3, 2, 10, 4, 11, 500000000, time.UTC)
+	for unit, expected := range map[string]float64{
+		"s":  1425290651.5,
+		"ms": 1425290651500,
+		"us": 1425290651500000,
+		"ns": 1425290651500000000,
+	} {
+		st, err := newScoreTimes(unit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := st.score(when); got != expected {
+			t.Errorf("%s: expected score %f, got %f", unit, expected, got)
+		}
+		if expected, got := "2015-03-02T10:04:11.5Z", st.format(st.score(when)); expected != got {
+			t.Errorf("%s: expected time %s, got %s", unit, expected, got)
+		}
+	}
+
+	st, _ := newScoreTimes("s")
+	for _, score := range []float64{-1, 1e12} {
+		if got := st.format(score); got != "" {
+			t.Errorf("score %f: expected no time, got %s", score, got)
+		}
+	}
+}
+
+func TestScoreTimesParseRange(t *testing.T) {
+	st, _ := newScoreTimes("s")
+	start, stop, err := st.parseRange("2015-03-02T10:00:00Z", "2015-03-02T12:00:00+01:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (common.Cursor{Score: 1425290400}); stop == nil || *stop != expected {
+		t.Errorf("since: expected stop %+v, got %+v", expected, stop)
+	}
+	if expected := (common.Cursor{Score: 1425294000}); start == nil || *start != expected {
+		t.Errorf("until: expected start %+v, got %+v", expected, start)
+	}
+
+	if start, stop, err := st.parseRange("", "2015-03-02T10:00:00Z"); err != nil || stop != nil || start == nil {
+		t.Errorf("until only: got start %v, stop %v, error %v", start, stop, err)
+	}
+
+	for _, tc := range []struct{ since, until string }{
+		{"yesterday", ""},
+		{"", "1425290400"},
+		{"1969-12-31T23:59:59Z", ""},
+		{"2015-03-02T11:00:00Z", "2015-03-02T10:00:00Z"},
+		{"2015-03-02T10:00:00Z", "2015-03-02T10:00:00Z"},
+	} {
+		if _, _, err := st.parseRange(tc.since, tc.until); err == nil {
+			t.Errorf("since %q, until %q: expected error, got none", tc.since, tc.until)
+		}
+	}
+}
+
+func TestSelectTimes(t *testing.T) {
+	farm := newMockFarm()
+	farm.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 1425290000, Member: "a"},
+		{Key: "foo", Score: 1425290500, Member: "b"},
+		{Key: "foo", Score: 1425291000, Member: "c"},
+		{Key: "foo", Score: 1425295000, Member: "d"},
+	})
+	st, _ := newScoreTimes("s")
+	body := `["Zm9v"]` // foo
+
+	get := func(handler http.HandlerFunc, query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/?"+query, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	handler := handleSelect(farm, 0, nil, st)
+	w := get(handler, "since=2015-03-02T10:00:00Z&until=2015-03-02T11:00:00Z&times=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+		Times   map[string][]string                `json:"times"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	members := []string{}
+	for _, ksm := range response.Records["foo"] {
+		members = append(members, ksm.Member)
+	}
+	if expected, got := []string{"c", "b"}, members; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected members %v, got %v", expected, got)
+	}
+	if expected, got := []string{"2015-03-02T10:10:00Z", "2015-03-02T10:01:40Z"}, response.Times["foo"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected times %v, got %v", expected, got)
+	}
+
+	for _, query := range []string{
+		"since=yesterday",
+		"since=2015-03-02T11:00:00Z&until=2015-03-02T10:00:00Z",
+		"since=2015-03-02T10:00:00Z&stop=" + common.Cursor{Score: 1}.String(),
+		"since=2015-03-02T10:00:00Z&offset=1",
+		"until=2015-03-02T10:00:00Z&order=asc",
+	} {
+		if w := get(handler, query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	// Without score times, the parameters are rejected.
+	handler = handleSelect(farm, 0, nil, nil)
+	for _, query := range []string{"since=2015-03-02T10:00:00Z", "times=true"} {
+		if w := get(handler, query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
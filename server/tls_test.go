@@ -0,0 +1,144 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key, signed by parent, or self-signed.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, ca bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key, der}
+}
+
+// write writes the certificate and its key as PEM files into dir.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: c.der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roshi-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		ca                = newTestCert(t, "ca", nil, true)
+		caFile, _         = ca.write(t, dir, "ca")
+		certFile, keyFile = newTestCert(t, "server", ca, false).write(t, dir, "server")
+		client            = newTestCert(t, "timelines", ca, false)
+		stranger          = newTestCert(t, "stranger", nil, false)
+	)
+	config, err := newTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, requester(r, "X-Requester"))
+	}))
+	s.TLS = config
+	s.StartTLS()
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(c *testCert) (string, error) {
+		config := &tls.Config{RootCAs: roots}
+		if c != nil {
+			config.Certificates = []tls.Certificate{{Certificate: [][]byte{c.der}, PrivateKey: c.key}}
+		}
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: config}}).Get(s.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	if body, err := get(client); err != nil || body != "timelines" {
+		t.Errorf("with a client certificate: expected requester %q, got %q (%v)", "timelines", body, err)
+	}
+	if _, err := get(nil); err == nil {
+		t.Errorf("without a client certificate: expected error, got none")
+	}
+	if _, err := get(stranger); err == nil {
+		t.Errorf("with a certificate of another CA: expected error, got none")
+	}
+
+	for _, files := range [][3]string{
+		{certFile, "", ""},
+		{"", "", caFile},
+		{certFile, keyFile, filepath.Join(dir, "missing.crt")},
+		{certFile, keyFile, keyFile},
+	} {
+		if _, err := newTLSConfig(files[0], files[1], files[2]); err == nil {
+			t.Errorf("%v: expected error, got none", files)
+		}
+	}
+	if config, err := newTLSConfig("", "", ""); config != nil || err != nil {
+		t.Errorf("without files: expected no TLS, got %v (%v)", config, err)
+	}
+}
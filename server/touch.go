@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// toucher is implemented by farm.Farm, and used for touches.
+type toucher interface {
+	Touch([]common.KeyScoreMember) ([]bool, error)
+}
+
+// touchedJSON is the response to a touch.
+type touchedJSON struct {
+	Touched  int    `json:"touched"`
+	Applied  []bool `json:"applied"`
+	Duration string `json:"duration"`
+}
+
+var touchDoc = apiDoc{
+	summary:  "Move inserted members to higher scores, e.g. to the top of a timeline, without re-adding deleted members; the response reports whether each touch was applied",
+	request:  []common.KeyScoreMember{},
+	response: touchedJSON{},
+}
+
+// handleTouch moves each member in the body to the score of its tuple, if
+// it's inserted with a lower score, and reports whether each touch was
+// applied, in the same order.
+func handleTouch(t toucher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		began := time.Now()
+
+		tuples, err := requestTuples(r)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusBadRequest, err)
+			return
+		}
+		if err := requestUsage(r).admit(r.Context(), "touch", tupleKeys(tuples)); err != nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusTooManyRequests, err)
+			return
+		}
+
+		applied, err := t.Touch(tuples)
+		if err != nil {
+			respondError(w, r.Method, r.URL.String(), errorCode(err), err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(touchedJSON{
+			Touched:  len(tuples),
+			Applied:  applied,
+			Duration: time.Since(began).String(),
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+func TestTouch(t *testing.T) {
+	fake := clustertest.New()
+	if err := fake.Insert([]common.KeyScoreMember{
+		{Key: "foo", Score: 5, Member: "a"},
+		{Key: "foo", Score: 8, Member: "b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r := pat.New()
+	r.Post("/touch", handleTouch(farm.New([]cluster.Cluster{fake}, 1, farm.SendAllReadAll, farm.NoRepairs, nil)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// foo:a, foo:b, and foo:c, all touched to score 6.
+	resp, err := http.Post(server.URL+"/touch", "application/json", bytes.NewBufferString(`[{"key":"Zm9v","score":6,"member":"YQ=="},{"key":"Zm9v","score":6,"member":"Yg=="},{"key":"Zm9v","score":6,"member":"Yw=="}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("expected %d, got %d", expected, got)
+	}
+	var response touchedJSON
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := []bool{true, false, false}, response.Applied; !reflect.DeepEqual(expected, got) || response.Touched != 3 {
+		t.Errorf("expected %v of 3 touches applied, got %+v", expected, response)
+	}
+
+	resp, err = http.Post(server.URL+"/touch", "application/json", bytes.NewBufferString(`{"key":"Zm9v"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, got := http.StatusBadRequest, resp.StatusCode; expected != got {
+		t.Errorf("expected %d for an invalid body, got %d", expected, got)
+	}
+}
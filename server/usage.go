@@ -0,0 +1,357 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// usageQuota is the hard quota of a tenant. Zero fields are unlimited.
+type usageQuota struct {
+	Inserts int   `json:"inserts,omitempty"` // tuples per window
+	Selects int   `json:"selects,omitempty"` // keys per window
+	Members int64 `json:"members,omitempty"` // stored
+}
+
+// parseUsageQuotas parses comma-separated TENANT:KIND=N rules, e.g.
+// "acme:inserts=1000,acme:members=50000", where KIND is inserts, selects, or
+// members. Several rules may apply to the same tenant.
+func parseUsageQuotas(s string) (map[string]usageQuota, error) {
+	quotas := map[string]usageQuota{}
+	if s == "" {
+		return quotas, nil
+	}
+	for _, rule := range strings.Split(s, ",") {
+		eq := strings.LastIndex(rule, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid quota %q (must be TENANT:KIND=N)", rule)
+		}
+		colon := strings.LastIndex(rule[:eq], ":")
+		if colon <= 0 {
+			return nil, fmt.Errorf("invalid quota %q (must be TENANT:KIND=N)", rule)
+		}
+		n, err := strconv.ParseInt(rule[eq+1:], 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid quota %q (N must be a positive integer)", rule)
+		}
+		var (
+			tenant = rule[:colon]
+			quota  = quotas[tenant]
+		)
+		switch kind := rule[colon+1 : eq]; kind {
+		case "inserts":
+			quota.Inserts = int(n)
+		case "selects":
+			quota.Selects = int(n)
+		case "members":
+			quota.Members = n
+		default:
+			return nil, fmt.Errorf("invalid quota %q (unknown kind %q; must be inserts, selects, or members)", rule, kind)
+		}
+		quotas[tenant] = quota
+	}
+	return quotas, nil
+}
+
+// tenantUsage is the usage of a tenant, as accounted by this server.
+type tenantUsage struct {
+	Inserts       int64       `json:"inserts"`        // tuples, since the server started
+	Selects       int64       `json:"selects"`        // keys, since the server started
+	Members       int64       `json:"members"`        // stored, in the keys whose size is remembered
+	WindowInserts int         `json:"window_inserts"` // tuples, in the current window
+	WindowSelects int         `json:"window_selects"` // keys, in the current window
+	Quota         *usageQuota `json:"quota,omitempty"`
+}
+
+// usageJSON is the response to a usage request.
+type usageJSON struct {
+	WindowBegan    time.Time              `json:"window_began"`
+	Window         string                 `json:"window"`
+	MembersTracked bool                   `json:"members_tracked"`
+	Tenants        map[string]tenantUsage `json:"tenants"`
+}
+
+// usageAccountant accounts the inserts, selects, and stored members of each
+// tenant, the portion of its keys before a delimiter, and enforces their
+// quotas. Inserts and selects are counted in fixed windows, which quotas
+// apply to, as well as in total. It's safe for concurrent use.
+//
+// Usage is accounted in memory, by each server separately, from the requests
+// it serves: with N servers, a tenant may use up to N times its quota, and
+// every count starts at zero when the server starts. Stored members are
+// counted in the most recently written keys only, so they're approximate.
+type usageAccountant struct {
+	prefixer *instrumentation.KeyPrefixer
+	quotas   map[string]usageQuota
+	window   time.Duration
+	sizer    sizer // nil unless stored members are tracked
+	maxKeys  int   // whose size is remembered, with sizer only
+	instr    instrumentation.InstrumentationV2
+	now      func() time.Time
+
+	mtx         sync.Mutex
+	windowBegan time.Time
+	tenants     map[string]*tenantUsage
+	sizes       map[string]*list.Element // of *keySize, with sizer only
+	lru         *list.List               // of the sizes, most recently written first
+}
+
+// keySize is the number of members of a key, as of its last write.
+type keySize struct {
+	key    string
+	tenant string
+	n      int
+}
+
+// newUsageAccountant returns an accountant of the tenants of keys delimited
+// by delimiter. Only the first maxTenants distinct tenants are accounted
+// separately, and any others as instrumentation.OtherKeyPrefix, as are the
+// metrics per key prefix. Tenants with quotas are always accounted
+// separately. If s is non-nil, stored members are tracked by sizing each key
+// after it's written. The sizes of up to maxKeys keys are remembered; when
+// there are more, the least recently written key is forgotten, and its
+// members are no longer counted.
+func newUsageAccountant(
+	delimiter string,
+	maxTenants int,
+	quotas map[string]usageQuota,
+	window time.Duration,
+	s sizer,
+	maxKeys int,
+	instr instrumentation.InstrumentationV2,
+) *usageAccountant {
+	prefixer := instrumentation.NewKeyPrefixer(delimiter, maxTenants+len(quotas))
+	for tenant := range quotas {
+		prefixer.Prefix(tenant + delimiter) // known before any other tenant
+	}
+	return &usageAccountant{
+		prefixer:    prefixer,
+		quotas:      quotas,
+		window:      window,
+		sizer:       s,
+		maxKeys:     maxKeys,
+		instr:       instr,
+		now:         time.Now,
+		windowBegan: time.Now(),
+		tenants:     map[string]*tenantUsage{},
+		sizes:       map[string]*list.Element{},
+		lru:         list.New(),
+	}
+}
+
+// tenant returns the usage of the tenant. Callers must hold the lock.
+func (a *usageAccountant) tenant(name string) *tenantUsage {
+	u, ok := a.tenants[name]
+	if !ok {
+		u = &tenantUsage{}
+		if quota, ok := a.quotas[name]; ok {
+			u.Quota = &quota
+		}
+		a.tenants[name] = u
+	}
+	return u
+}
+
+// roll starts a new window, if the current one is over. Callers must hold
+// the lock.
+func (a *usageAccountant) roll() {
+	now := a.now()
+	if now.Sub(a.windowBegan) < a.window {
+		return
+	}
+	a.windowBegan = now
+	for _, u := range a.tenants {
+		u.WindowInserts, u.WindowSelects = 0, 0
+	}
+}
+
+// admit accounts an insert or touch of tuples with the keys, or a select of
+// the keys, to their tenants, unless it would take any of them over its
+// quota. Then nothing is accounted, and an error is returned. Touches count
+// as inserts, but don't add members, so they're admitted over the stored
+// members quota. A nil accountant admits everything.
+func (a *usageAccountant) admit(ctx context.Context, operation string, keys []string) error {
+	if a == nil {
+		return nil
+	}
+	counts := a.prefixer.Count(keys)
+
+	a.mtx.Lock()
+	a.roll()
+	for name, n := range counts {
+		var (
+			u     = a.tenant(name)
+			quota = a.quotas[name]
+			err   error
+		)
+		switch {
+		case operation != "select" && quota.Inserts > 0 && u.WindowInserts+n > quota.Inserts:
+			err = fmt.Errorf("tenant %q is over its quota of %d inserts per %s", name, quota.Inserts, a.window)
+		case operation == "insert" && quota.Members > 0 && u.Members >= quota.Members:
+			err = fmt.Errorf("tenant %q is over its quota of %d stored members", name, quota.Members)
+		case operation == "select" && quota.Selects > 0 && u.WindowSelects+n > quota.Selects:
+			err = fmt.Errorf("tenant %q is over its quota of %d selects per %s", name, quota.Selects, a.window)
+		}
+		if err != nil {
+			a.mtx.Unlock()
+			a.instr.Count(ctx, "usage."+operation+".quota_exceeded", 1, instrumentation.Labels{KeyPrefix: name})
+			return err
+		}
+	}
+	for name, n := range counts {
+		u := a.tenant(name)
+		switch operation {
+		case "select":
+			u.Selects += int64(n)
+			u.WindowSelects += n
+		default:
+			u.Inserts += int64(n)
+			u.WindowInserts += n
+		}
+	}
+	a.mtx.Unlock()
+
+	name := "usage.insert.record"
+	if operation == "select" {
+		name = "usage.select.key"
+	}
+	for tenant, n := range counts {
+		a.instr.Count(ctx, name, n, instrumentation.Labels{KeyPrefix: tenant})
+	}
+	return nil
+}
+
+// written sizes the keys of the tuples after a write, if stored members are
+// tracked, and updates the stored members of their tenants. A nil accountant
+// does nothing.
+func (a *usageAccountant) written(ctx context.Context, tuples []common.KeyScoreMember) {
+	if a == nil || a.sizer == nil || len(tuples) <= 0 {
+		return
+	}
+	sizes, err := a.sizer.Sizes(tuples)
+	if err != nil {
+		log.Printf("usage: %s", err)
+		return
+	}
+	a.resize(ctx, sizes)
+}
+
+// resize records the members of each key after a write, and updates the
+// stored members of their tenants. Keys beyond the max are forgotten, least
+// recently written first, and their members subtracted.
+func (a *usageAccountant) resize(ctx context.Context, sizes map[string]int) {
+	members := map[string]int64{}
+	a.mtx.Lock()
+	for key, n := range sizes {
+		var (
+			name    = a.prefixer.Prefix(key)
+			u       = a.tenant(name)
+			prev    int
+			e, seen = a.sizes[key]
+		)
+		if seen {
+			prev = e.Value.(*keySize).n
+		}
+		u.Members += int64(n - prev)
+		switch {
+		case n <= 0 && seen:
+			a.lru.Remove(e)
+			delete(a.sizes, key)
+		case seen:
+			e.Value.(*keySize).n = n
+			a.lru.MoveToFront(e)
+		case n > 0:
+			a.sizes[key] = a.lru.PushFront(&keySize{key: key, tenant: name, n: n})
+		}
+		members[name] = u.Members
+	}
+	for a.lru.Len() > a.maxKeys {
+		size := a.lru.Remove(a.lru.Back()).(*keySize)
+		delete(a.sizes, size.key)
+		u := a.tenant(size.tenant)
+		u.Members -= int64(size.n)
+		members[size.tenant] = u.Members
+	}
+	a.mtx.Unlock()
+
+	for tenant, n := range members {
+		a.instr.Gauge(ctx, "usage.stored.member", float64(n), instrumentation.Labels{KeyPrefix: tenant})
+	}
+}
+
+// snapshot returns the usage of every tenant.
+func (a *usageAccountant) snapshot() usageJSON {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.roll()
+	usage := usageJSON{
+		WindowBegan:    a.windowBegan,
+		Window:         a.window.String(),
+		MembersTracked: a.sizer != nil,
+		Tenants:        make(map[string]tenantUsage, len(a.tenants)),
+	}
+	for name, u := range a.tenants {
+		usage.Tenants[name] = *u
+	}
+	return usage
+}
+
+type usageAccountantKey struct{}
+
+// accounted serves each request with the accountant, so that the handler
+// accounts the keys it reads or writes to their tenants, once it's decoded
+// them; see requestUsage. Handlers reject inserts, touches, and selects
+// which would take a tenant over its quota with 429 Too Many Requests. A nil
+// accountant serves every request as is.
+func accounted(a *usageAccountant, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), usageAccountantKey{}, a)))
+	}
+}
+
+// requestUsage returns the accountant the request is served with, or nil if
+// its usage isn't accounted.
+func requestUsage(r *http.Request) *usageAccountant {
+	a, _ := r.Context().Value(usageAccountantKey{}).(*usageAccountant)
+	return a
+}
+
+// tupleKeys returns the key of each tuple.
+func tupleKeys(tuples []common.KeyScoreMember) []string {
+	keys := make([]string, len(tuples))
+	for i, tuple := range tuples {
+		keys[i] = tuple.Key
+	}
+	return keys
+}
+
+var usageDoc = apiDoc{
+	summary:  "Report the inserts, selects, and stored members of each tenant, and their quotas",
+	response: usageJSON{},
+}
+
+// handleUsage reports the usage of every tenant. It responds 404 if usage
+// isn't accounted.
+func handleUsage(a *usageAccountant) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a == nil {
+			respondError(w, r.Method, r.URL.String(), http.StatusNotFound, fmt.Errorf("usage not accounted"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.snapshot())
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/pat"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func TestParseUsageQuotas(t *testing.T) {
+	quotas, err := parseUsageQuotas("acme:inserts=10,acme:members=100,a:b:selects=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]usageQuota{
+		"acme": {Inserts: 10, Members: 100},
+		"a:b":  {Selects: 5},
+	}
+	if !reflect.DeepEqual(expected, quotas) {
+		t.Errorf("expected %v, got %v", expected, quotas)
+	}
+	for _, s := range []string{"acme", "acme=10", ":inserts=10", "acme:inserts=0", "acme:deletes=10"} {
+		if _, err := parseUsageQuotas(s); err == nil {
+			t.Errorf("%q: expected an error, got none", s)
+		}
+	}
+}
+
+type fixedSizer map[string]int
+
+func (s fixedSizer) Sizes(tuples []common.KeyScoreMember) (map[string]int, error) {
+	sizes := map[string]int{}
+	for _, tuple := range tuples {
+		sizes[tuple.Key] = s[tuple.Key]
+	}
+	return sizes, nil
+}
+
+func TestUsage(t *testing.T) {
+	var (
+		quotas = map[string]usageQuota{"a": {Inserts: 3, Selects: 2, Members: 5}}
+		sizes  = fixedSizer{"a:1": 2, "a:2": 3, "b:1": 1}
+		usage  = newUsageAccountant(":", 10, quotas, time.Minute, sizes, 100, instrumentation.NopInstrumentationV2{})
+		now    = time.Unix(1400000000, 0)
+		farm   = newMockFarm()
+	)
+	usage.now = func() time.Time { return now }
+	usage.windowBegan = now
+
+	r := pat.New()
+	r.Get("/admin/usage", handleUsage(usage))
+	r.Get("/", accounted(usage, handleSelect(farm, time.Second, nil, nil)))
+	r.Post("/", accounted(usage, handleInsert(farm)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(method string, body interface{}) int {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, server.URL+"/", bytes.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	tuples := func(keys ...string) []common.KeyScoreMember {
+		a := make([]common.KeyScoreMember, len(keys))
+		for i, key := range keys {
+			a[i] = common.KeyScoreMember{Key: key, Score: float64(i), Member: strconv.Itoa(i)}
+		}
+		return a
+	}
+	keys := func(keys ...string) [][]byte {
+		a := make([][]byte, len(keys))
+		for i, key := range keys {
+			a[i] = []byte(key)
+		}
+		return a
+	}
+	for i, testCase := range []struct {
+		method   string
+		body     interface{}
+		expected int
+	}{
+		{"POST", tuples("a:1", "a:1", "b:1"), http.StatusOK},
+		{"POST", tuples("a:2", "a:2"), http.StatusTooManyRequests}, // 4 inserts per minute
+		{"POST", tuples("a:2"), http.StatusOK},
+		{"GET", keys("a:1", "a:2", "a:3"), http.StatusTooManyRequests}, // 3 selects per minute
+		{"GET", keys("a:1", "a:2", "b:1", "b:2"), http.StatusOK},
+	} {
+		if got := do(testCase.method, testCase.body); got != testCase.expected {
+			t.Errorf("%d: %s %v: expected %d, got %d", i, testCase.method, testCase.body, testCase.expected, got)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/admin/usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got usageJSON
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]tenantUsage{
+		"a": {Inserts: 3, Selects: 2, Members: 5, WindowInserts: 3, WindowSelects: 2, Quota: &usageQuota{Inserts: 3, Selects: 2, Members: 5}},
+		"b": {Inserts: 1, Selects: 2, Members: 1, WindowInserts: 1, WindowSelects: 2},
+	}
+	if !reflect.DeepEqual(expected, got.Tenants) || !got.MembersTracked {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	// The stored members quota outlasts the window.
+	now = now.Add(time.Minute)
+	if got := do("POST", tuples("a:3")); got != http.StatusTooManyRequests {
+		t.Errorf("expected %d once tenant a stores 5 members, got %d", http.StatusTooManyRequests, got)
+	}
+	if got := do("GET", keys("a:1", "a:2")); got != http.StatusOK {
+		t.Errorf("expected %d in a new window, got %d", http.StatusOK, got)
+	}
+}
+
+type nopToucher struct{}
+
+func (nopToucher) Touch(tuples []common.KeyScoreMember) ([]bool, error) {
+	return make([]bool, len(tuples)), nil
+}
+
+func TestUsageEndpoints(t *testing.T) {
+	var (
+		quotas = map[string]usageQuota{"a": {Inserts: 2, Selects: 2}}
+		sizes  = fixedSizer{"a:1": 1, "a:2": 2}
+		usage  = newUsageAccountant(":", 10, quotas, time.Minute, sizes, 100, instrumentation.NopInstrumentationV2{})
+		farm   = newMockFarm()
+	)
+
+	r := pat.New()
+	r.Post("/select/bulk", accounted(usage, handleBulkSelect(farm)))
+	r.Post("/select/keys", accounted(usage, handleSelectKeys(farm, 0, 2, instrumentation.NopInstrumentationV2{})))
+	r.Get("/select/buckets", accounted(usage, handleSelectBuckets(farm)))
+	r.Post("/touch", accounted(usage, handleTouch(nopToucher{})))
+	r.Add("DELETE", "/bulk", accounted(usage, handleBulkDelete(&failingDeleter{mockFarm: farm}, 0, 1)))
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	do := func(method, path, body string) int {
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// "YTox" is "a:1", and "YToy" is "a:2".
+	for i, testCase := range []struct {
+		method, path, body string
+		expected           int
+	}{
+		{"POST", "/select/bulk", `[{"key":"YTox"},{"key":"YToy"},{"key":"YTox"}]`, http.StatusTooManyRequests},
+		{"POST", "/select/keys", `["YTox","YToy","YTox","YToy","YTox"]`, http.StatusOK}, // deduplicated
+		{"POST", "/select/keys", `["YTox"]`, http.StatusTooManyRequests},
+		{"GET", "/select/buckets?key=a:&window=48h", "", http.StatusTooManyRequests},
+		{"POST", "/touch", `[{"key":"YTox","score":1,"member":"bQ=="},{"key":"YToy","score":1,"member":"bQ=="}]`, http.StatusOK},
+		{"POST", "/touch", `[{"key":"YTox","score":2,"member":"bQ=="}]`, http.StatusTooManyRequests},
+		{"DELETE", "/bulk", `[{"key":"YTox","score":1,"member":"bQ=="},{"key":"YToy","score":1,"member":"bQ=="}]`, http.StatusOK},
+	} {
+		if got := do(testCase.method, testCase.path, testCase.body); got != testCase.expected {
+			t.Errorf("%d: %s %s %s: expected %d, got %d", i, testCase.method, testCase.path, testCase.body, testCase.expected, got)
+		}
+	}
+
+	expected := tenantUsage{Inserts: 2, Selects: 2, Members: 3, WindowInserts: 2, WindowSelects: 2, Quota: &usageQuota{Inserts: 2, Selects: 2}}
+	if got := usage.snapshot().Tenants["a"]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestUsageMembersBounded(t *testing.T) {
+	usage := newUsageAccountant(":", 10, nil, time.Minute, fixedSizer{}, 2, instrumentation.NopInstrumentationV2{})
+	members := func() map[string]int64 {
+		m := map[string]int64{}
+		for name, u := range usage.snapshot().Tenants {
+			m[name] = u.Members
+		}
+		return m
+	}
+
+	usage.resize(context.Background(), map[string]int{"a:1": 2})
+	usage.resize(context.Background(), map[string]int{"a:2": 3})
+	usage.resize(context.Background(), map[string]int{"a:1": 4}) // a:2 is now the least recently written
+	usage.resize(context.Background(), map[string]int{"b:1": 1})
+	if expected, got := map[string]int64{"a": 4, "b": 1}, members(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v once a:2 is forgotten, got %v", expected, got)
+	}
+	if expected, got := 2, usage.lru.Len(); expected != got {
+		t.Errorf("expected the sizes of %d keys, got %d", expected, got)
+	}
+
+	usage.resize(context.Background(), map[string]int{"a:1": 0})
+	if expected, got := map[string]int64{"a": 0, "b": 1}, members(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v once a:1 is empty, got %v", expected, got)
+	}
+}
+
+func TestUsageDisabled(t *testing.T) {
+	r := pat.New()
+	r.Get("/admin/usage", handleUsage(nil))
+	server := httptest.NewServer(r)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/admin/usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected, got := http.StatusNotFound, resp.StatusCode; expected != got {
+		t.Errorf("expected %d, got %d", expected, got)
+	}
+}
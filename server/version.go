@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/soundcloud/roshi/cli"
+)
+
+// versionInfo describes the running roshi-server: its build, and the
+// strategies and optional features it was started with, so that fleet
+// tooling can tell what each instance supports before relying on it.
+type versionInfo struct {
+	cli.Build
+	Dialect        string   `json:"redis_dialect"`
+	ReadStrategy   string   `json:"read_strategy"`
+	RepairStrategy string   `json:"repair_strategy"`
+	WriteQuorum    string   `json:"write_quorum"`
+	Features       []string `json:"features"` // names of the flags enabling them, sorted
+}
+
+// enabledFeatures returns the names of the features which are enabled,
+// sorted.
+func enabledFeatures(features map[string]bool) []string {
+	enabled := []string{}
+	for name, on := range features {
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return enabled
+}
+
+var versionDoc = apiDoc{
+	summary:  "Report the build and configuration of the server",
+	response: versionInfo{},
+}
+
+// handleVersion reports the build and configuration of this process.
+func handleVersion(info versionInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
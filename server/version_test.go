@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/soundcloud/roshi/cli"
+)
+
+func TestVersion(t *testing.T) {
+	info := versionInfo{
+		Build:          cli.CurrentBuild(),
+		Dialect:        "redis",
+		ReadStrategy:   "SendAllReadAll",
+		RepairStrategy: "NoRepairs",
+		WriteQuorum:    "51%",
+		Features: enabledFeatures(map[string]bool{
+			"split.keys":      true,
+			"cache.hot.keys":  true,
+			"score.time.unit": false,
+		}),
+	}
+	w := httptest.NewRecorder()
+	handleVersion(info)(w, httptest.NewRequest("GET", "/version", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	for field, expected := range map[string]interface{}{
+		"version":         cli.Version,
+		"commit":          cli.Commit,
+		"build_date":      cli.BuildDate,
+		"redis_dialect":   "redis",
+		"read_strategy":   "SendAllReadAll",
+		"repair_strategy": "NoRepairs",
+		"write_quorum":    "51%",
+		"features":        []interface{}{"cache.hot.keys", "split.keys"},
+	} {
+		if got := response[field]; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: expected %v, got %v", field, expected, got)
+		}
+	}
+	if response["go_version"] == "" {
+		t.Errorf("go_version: expected the Go version, got none")
+	}
+}
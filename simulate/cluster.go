@@ -0,0 +1,148 @@
+package simulate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+var errSimulated = errors.New("simulated failure")
+
+// latency samples the latency of a single call to a cluster.
+type latency func(r *rand.Rand) time.Duration
+
+// parseLatency returns the named distribution, with the mean. The standard
+// deviation only applies to the normal and lognormal distributions.
+func parseLatency(distribution string, mean, stddev time.Duration) (latency, error) {
+	switch strings.ToLower(distribution) {
+	case "fixed":
+		return func(*rand.Rand) time.Duration { return mean }, nil
+	case "uniform":
+		return func(r *rand.Rand) time.Duration { return time.Duration(r.Int63n(int64(2*mean) + 1)) }, nil
+	case "exponential":
+		return func(r *rand.Rand) time.Duration { return time.Duration(r.ExpFloat64() * float64(mean)) }, nil
+	case "normal":
+		return func(r *rand.Rand) time.Duration {
+			return time.Duration(math.Max(0, r.NormFloat64()*float64(stddev)+float64(mean)))
+		}, nil
+	case "lognormal":
+		// Parameters of the underlying normal distribution, for the mean and
+		// standard deviation of the lognormal one.
+		m, s := float64(mean), float64(stddev)
+		if m <= 0 {
+			return func(*rand.Rand) time.Duration { return 0 }, nil
+		}
+		sigma := math.Sqrt(math.Log(1 + s*s/(m*m)))
+		mu := math.Log(m) - sigma*sigma/2
+		return func(r *rand.Rand) time.Duration {
+			return time.Duration(math.Exp(r.NormFloat64()*sigma + mu))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown latency distribution %q", distribution)
+	}
+}
+
+// simCluster is an in-memory cluster, whose every call takes a latency
+// sampled from its distribution, and fails with its failure rate. A failed
+// write isn't applied, so the simulated clusters diverge, like real ones.
+type simCluster struct {
+	*clustertest.Fake
+	latency     latency
+	failureRate float64
+
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newSimCluster(l latency, failureRate float64, seed int64) *simCluster {
+	return &simCluster{
+		Fake:        clustertest.New(),
+		latency:     l,
+		failureRate: failureRate,
+		r:           rand.New(rand.NewSource(seed)),
+	}
+}
+
+// sample returns the latency of a call, and whether it fails.
+func (c *simCluster) sample() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latency(c.r), c.r.Float64() < c.failureRate
+}
+
+func (c *simCluster) call(do func() error) error {
+	d, fail := c.sample()
+	time.Sleep(d)
+	if fail {
+		return errSimulated
+	}
+	return do()
+}
+
+// Insert implements cluster.Inserter.
+func (c *simCluster) Insert(tuples []common.KeyScoreMember) error {
+	return c.call(func() error { return c.Fake.Insert(tuples) })
+}
+
+// Delete implements cluster.Deleter.
+func (c *simCluster) Delete(tuples []common.KeyScoreMember) error {
+	return c.call(func() error { return c.Fake.Delete(tuples) })
+}
+
+// Score implements cluster.Scorer.
+func (c *simCluster) Score(keyMembers []common.KeyMember) (map[common.KeyMember]cluster.Presence, error) {
+	var presence map[common.KeyMember]cluster.Presence
+	err := c.call(func() (err error) {
+		presence, err = c.Fake.Score(keyMembers)
+		return err
+	})
+	return presence, err
+}
+
+// SelectOffset implements cluster.Selecter.
+func (c *simCluster) SelectOffset(keys []string, offset, limit int) <-chan cluster.Element {
+	return c.selecting(keys, func() <-chan cluster.Element { return c.Fake.SelectOffset(keys, offset, limit) })
+}
+
+// SelectOffsetAscending implements cluster.Selecter.
+func (c *simCluster) SelectOffsetAscending(keys []string, offset, limit int) <-chan cluster.Element {
+	return c.selecting(keys, func() <-chan cluster.Element { return c.Fake.SelectOffsetAscending(keys, offset, limit) })
+}
+
+// SelectRange implements cluster.Selecter.
+func (c *simCluster) SelectRange(keys []string, start, stop common.Cursor, limit int) <-chan cluster.Element {
+	return c.selecting(keys, func() <-chan cluster.Element { return c.Fake.SelectRange(keys, start, stop, limit) })
+}
+
+// selecting returns immediately, like a real cluster, and emits the elements
+// of the Select after the sampled latency, or an error for every key.
+func (c *simCluster) selecting(keys []string, sel func() <-chan cluster.Element) <-chan cluster.Element {
+	d, fail := c.sample()
+	var elements <-chan cluster.Element
+	if !fail {
+		elements = sel()
+	}
+	ch := make(chan cluster.Element)
+	go func() {
+		defer close(ch)
+		time.Sleep(d)
+		if fail {
+			for _, key := range keys {
+				ch <- cluster.Element{Key: key, KeyScoreMembers: []common.KeyScoreMember{}, Error: errSimulated}
+			}
+			return
+		}
+		for e := range elements {
+			ch <- e
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,56 @@
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/soundcloud/roshi/common"
+)
+
+// op is a single recorded operation, one JSON object per line of the log.
+// Writes have the same operation and tuples fields as audit entries, so audit
+// logs may be replayed as is. Like in the roshi-server API, keys and members
+// are base64-encoded.
+type op struct {
+	Operation string                  `json:"operation"` // insert, delete, or select
+	Tuples    []common.KeyScoreMember `json:"tuples,omitempty"`
+	Keys      [][]byte                `json:"keys,omitempty"`
+	Offset    int                     `json:"offset,omitempty"`
+	Limit     int                     `json:"limit,omitempty"`
+}
+
+// readLog reads a log of newline-delimited ops. Selects without a limit get
+// the default limit.
+func readLog(r io.Reader, defaultLimit int) ([]op, error) {
+	var (
+		dec = json.NewDecoder(r)
+		ops = []op{}
+	)
+	for dec.More() {
+		var o op
+		if err := dec.Decode(&o); err != nil {
+			return nil, fmt.Errorf("op %d: %s", len(ops)+1, err)
+		}
+		switch o.Operation = strings.ToLower(o.Operation); o.Operation {
+		case "insert", "delete":
+		case "select":
+			if o.Limit <= 0 {
+				o.Limit = defaultLimit
+			}
+		default:
+			return nil, fmt.Errorf("op %d: unknown operation %q", len(ops)+1, o.Operation)
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+func (o op) keys() []string {
+	keys := make([]string, len(o.Keys))
+	for i, key := range o.Keys {
+		keys[i] = string(key)
+	}
+	return keys
+}
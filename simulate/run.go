@@ -0,0 +1,187 @@
+// Package simulate implements roshi-simulate, which replays a recorded
+// operation log against farms of simulated clusters, with configurable
+// latency and failure distributions, and reports consistency and latency
+// outcomes per read strategy. It runs as the simulate command of roshi.
+package simulate
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/roshi/cli"
+	"github.com/soundcloud/roshi/farm"
+)
+
+// Run runs roshi-simulate, as the named command, with the command-line
+// arguments, which don't include the name.
+func Run(name string, args []string) {
+	var (
+		fs                   = cli.NewFlagSet(name)
+		logFile              = fs.String("log", "-", "Operation log to replay, as newline-delimited JSON (- for stdin)")
+		strategies           = fs.String("strategies", "SendAllReadAll,SendOneReadOne,SendAllReadFirstLinger,SendVarReadFirstLinger", "Comma-separated read strategies to simulate: SendAllReadAll, SendOneReadOne, SendAllReadFirstLinger, SendVarReadFirstLinger")
+		clusters             = fs.Int("clusters", 3, "Number of simulated clusters")
+		writeQuorum          = fs.String("write.quorum", "51%", "Write quorum, as number of clusters or percentage (e.g. 51%)")
+		latencyDistribution  = fs.String("latency.distribution", "exponential", "Distribution of the latency of each call to a cluster: fixed, uniform, exponential, normal, lognormal")
+		latencyMeans         = fs.String("latency.mean", "1ms", "Mean latency of each call to a cluster; a comma-separated list gives one per cluster")
+		latencyStddevs       = fs.String("latency.stddev", "1ms", "Standard deviation of the latency (normal and lognormal only); a comma-separated list gives one per cluster")
+		failureRates         = fs.String("failure.rate", "0", "Probability (0-1) that a call to a cluster fails; a comma-separated list gives one per cluster")
+		repairs              = fs.Bool("repairs", true, "Issue read repairs (AllRepairs), or not (NoRepairs)")
+		readThresholdRate    = fs.Int("read.threshold.rate", 2000, "Max SendAll keys per second (SendVarReadFirstLinger only)")
+		readThresholdLatency = fs.Duration("read.threshold.latency", 50*time.Millisecond, "Max time to wait on SendOne before issuing SendAll (SendVarReadFirstLinger only)")
+		defaultLimit         = fs.Int("select.limit", 10, "Limit of logged Selects without one")
+		seed                 = fs.Int64("seed", 1, "Seed of the latencies and failures; each strategy is simulated with the same seed")
+		verbose              = fs.Bool("verbose", false, "Log the messages of the simulated farms, e.g. partial errors")
+	)
+	if err := cli.Parse(fs, args); err != nil {
+		fatalf("%s", err)
+	}
+	log.SetFlags(log.Lmicroseconds)
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	if *clusters <= 0 {
+		fatalf("clusters must be positive")
+	}
+	quorum, err := evaluateScalarPercentage(*writeQuorum, *clusters)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	p := profile{
+		latencies:    make([]latency, *clusters),
+		failureRates: make([]float64, *clusters),
+		writeQuorum:  quorum,
+		repairs:      *repairs,
+		seed:         *seed,
+	}
+	means, err := perCluster(*latencyMeans, *clusters)
+	if err != nil {
+		fatalf("-latency.mean: %s", err)
+	}
+	stddevs, err := perCluster(*latencyStddevs, *clusters)
+	if err != nil {
+		fatalf("-latency.stddev: %s", err)
+	}
+	rates, err := perCluster(*failureRates, *clusters)
+	if err != nil {
+		fatalf("-failure.rate: %s", err)
+	}
+	for i := 0; i < *clusters; i++ {
+		mean, err := time.ParseDuration(means[i])
+		if err != nil {
+			fatalf("-latency.mean: %s", err)
+		}
+		stddev, err := time.ParseDuration(stddevs[i])
+		if err != nil {
+			fatalf("-latency.stddev: %s", err)
+		}
+		if p.latencies[i], err = parseLatency(*latencyDistribution, mean, stddev); err != nil {
+			fatalf("%s", err)
+		}
+		if p.failureRates[i], err = strconv.ParseFloat(rates[i], 64); err != nil || p.failureRates[i] < 0 || p.failureRates[i] > 1 {
+			fatalf("-failure.rate: invalid rate %q", rates[i])
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if *logFile != "-" {
+		f, err := os.Open(*logFile)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	ops, err := readLog(r, *defaultLimit)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	fmt.Fprintf(os.Stderr, "replaying %d op(s) against %d cluster(s), write quorum %d\n", len(ops), *clusters, quorum)
+
+	outcomes := []outcome{}
+	for _, name := range strings.Split(*strategies, ",") {
+		name = strings.TrimSpace(name)
+		var readStrategy farm.ReadStrategy
+		switch strings.ToLower(name) {
+		case "sendallreadall":
+			readStrategy = farm.SendAllReadAll
+		case "sendonereadone":
+			readStrategy = farm.SendOneReadOne
+		case "sendallreadfirstlinger":
+			readStrategy = farm.SendAllReadFirstLinger
+		case "sendvarreadfirstlinger":
+			readStrategy = farm.SendVarReadFirstLinger(*readThresholdRate, *readThresholdLatency)
+		default:
+			fatalf("unknown read strategy %q", name)
+		}
+		fmt.Fprintf(os.Stderr, "simulating %s\n", name)
+		outcomes = append(outcomes, simulate(ops, name, readStrategy, p))
+	}
+	report(os.Stdout, outcomes)
+}
+
+// evaluateScalarPercentage takes a string of the form "P%" (percent) or "S"
+// (straight scalar value), and evaluates that against the passed total n.
+// Percentages mean at least that percent; for example, "50%" of 3 evaluates
+// to 2. It is an error if the passed string evaluates to less than 1 or more
+// than n.
+func evaluateScalarPercentage(s string, n int) (int, error) {
+	if n <= 0 {
+		return -1, fmt.Errorf("n must be at least 1")
+	}
+
+	s = strings.TrimSpace(s)
+	var value int
+	if strings.HasSuffix(s, "%") {
+		percentInt, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil || percentInt <= 0 || percentInt > 100 {
+			return -1, fmt.Errorf("bad percentage input %q", s)
+		}
+		value = int(math.Ceil((float64(percentInt) / 100.0) * float64(n)))
+	} else {
+		value64, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return -1, fmt.Errorf("bad scalar input %q", s)
+		}
+		value = int(value64)
+	}
+	if value <= 0 || value > n {
+		return -1, fmt.Errorf("with n=%d, value=%d (from %q) is invalid", n, value, s)
+	}
+	return value, nil
+}
+
+// perCluster splits the comma-separated list into one value per cluster. A
+// single value applies to every cluster.
+func perCluster(s string, clusters int) ([]string, error) {
+	values := strings.Split(s, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	switch len(values) {
+	case clusters:
+		return values, nil
+	case 1:
+		all := make([]string, clusters)
+		for i := range all {
+			all[i] = values[0]
+		}
+		return all, nil
+	default:
+		return nil, fmt.Errorf("%d value(s) for %d cluster(s)", len(values), clusters)
+	}
+}
+
+// fatalf is like log.Fatalf, but always writes to stderr, as farm messages
+// are logged only with -verbose.
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
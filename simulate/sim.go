@@ -0,0 +1,150 @@
+package simulate
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+// profile describes the simulated clusters of a farm.
+type profile struct {
+	latencies    []latency // per cluster
+	failureRates []float64 // per cluster
+	writeQuorum  int
+	repairs      bool
+	seed         int64
+}
+
+// outcome is the outcome of replaying a log with a single read strategy.
+type outcome struct {
+	strategy     string
+	selects      []time.Duration // of successful selects
+	selectErrors int
+	consistent   int             // successful selects which returned the expected tuples for every key
+	staleKeys    int             // keys which didn't
+	writes       []time.Duration // of writes which reached the quorum
+	writeErrors  int
+}
+
+// simulate replays the ops, in order, against a farm of fresh simulated
+// clusters with the read strategy. Selects are compared to the state of an
+// ideal cluster, which receives every write, whether it reached the write
+// quorum or not, as clients retry failed writes.
+func simulate(ops []op, name string, readStrategy farm.ReadStrategy, p profile) outcome {
+	var (
+		r        = rand.New(rand.NewSource(p.seed))
+		clusters = make([]cluster.Cluster, len(p.latencies))
+		ideal    = clustertest.New()
+		o        = outcome{strategy: name}
+	)
+	for i := range clusters {
+		clusters[i] = newSimCluster(p.latencies[i], p.failureRates[i], r.Int63())
+	}
+	repairStrategy := farm.NoRepairs
+	if p.repairs {
+		repairStrategy = farm.AllRepairs
+	}
+	f := farm.New(clusters, p.writeQuorum, readStrategy, repairStrategy, nil)
+
+	for _, op := range ops {
+		switch op.Operation {
+		case "insert", "delete":
+			write, idealWrite := f.Insert, ideal.Insert
+			if op.Operation == "delete" {
+				write, idealWrite = f.Delete, ideal.Delete
+			}
+			idealWrite(op.Tuples)
+			writeBegan := time.Now()
+			if err := write(op.Tuples); err != nil {
+				o.writeErrors++
+				continue
+			}
+			o.writes = append(o.writes, time.Since(writeBegan))
+
+		case "select":
+			keys := op.keys()
+			selectBegan := time.Now()
+			got, err := f.SelectOffset(keys, op.Offset, op.Limit)
+			if err != nil {
+				o.selectErrors++
+				continue
+			}
+			o.selects = append(o.selects, time.Since(selectBegan))
+			stale := 0
+			for e := range ideal.SelectOffset(keys, op.Offset, op.Limit) {
+				if !equal(e.KeyScoreMembers, got[e.Key]) {
+					stale++
+				}
+			}
+			if stale <= 0 {
+				o.consistent++
+			}
+			o.staleKeys += stale
+		}
+	}
+	return o
+}
+
+func equal(a, b []common.KeyScoreMember) bool {
+	if len(a) <= 0 && len(b) <= 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// report writes a table of the outcomes, one line per read strategy.
+func report(w io.Writer, outcomes []outcome) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "strategy\tselects\terrors\tconsistent\tstale keys\tp50\tp90\tp99\tmax\twrites\terrors\tp50\tp99\t")
+	for _, o := range outcomes {
+		consistent := 0.
+		if len(o.selects) > 0 {
+			consistent = 100 * float64(o.consistent) / float64(len(o.selects))
+		}
+		s, w := sorted(o.selects), sorted(o.writes)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t\n",
+			o.strategy,
+			len(s), o.selectErrors, consistent, o.staleKeys,
+			percentile(s, 0.50), percentile(s, 0.90), percentile(s, 0.99), percentile(s, 1),
+			len(w), o.writeErrors,
+			percentile(w, 0.50), percentile(w, 0.99),
+		)
+	}
+	tw.Flush()
+}
+
+func sorted(a []time.Duration) []time.Duration {
+	s := append([]time.Duration{}, a...)
+	sort.Sort(durations(s))
+	return s
+}
+
+// percentile expects a sorted slice. It returns 0 for an empty one.
+func percentile(a []time.Duration, p float64) time.Duration {
+	if len(a) <= 0 {
+		return 0
+	}
+	i := int(float64(len(a))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(a) {
+		i = len(a) - 1
+	}
+	return a[i]
+}
+
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
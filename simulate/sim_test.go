@@ -0,0 +1,68 @@
+package simulate
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+const testLog = `{"operation":"insert","tuples":[{"key":"Zm9v","score":1,"member":"YQ=="},{"key":"Zm9v","score":2,"member":"Yg=="}]}
+{"operation":"select","keys":["Zm9v"]}
+{"operation":"delete","tuples":[{"key":"Zm9v","score":3,"member":"YQ=="}]}
+{"operation":"select","keys":["Zm9v","YmFy"],"limit":1}
+`
+
+func TestSimulate(t *testing.T) {
+	ops, err := readLog(strings.NewReader(testLog), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed, _ := parseLatency("fixed", 0, 0)
+
+	// Healthy clusters are always consistent, once every write is complete.
+	healthy := profile{
+		latencies:    []latency{fixed, fixed, fixed},
+		failureRates: []float64{0, 0, 0},
+		writeQuorum:  3,
+	}
+	o := simulate(ops, "SendOneReadOne", farm.SendOneReadOne, healthy)
+	if expected, got := 2, o.consistent; expected != got {
+		t.Errorf("healthy: expected %d consistent selects, got %d", expected, got)
+	}
+	if expected, got := 2, len(o.writes); expected != got {
+		t.Errorf("healthy: expected %d writes, got %d", expected, got)
+	}
+
+	// A cluster failing every call fails every write without a quorum of the
+	// others, but SendAllReadAll still reads every write from them.
+	degraded := healthy
+	degraded.failureRates = []float64{0, 0, 1}
+	o = simulate(ops, "SendAllReadAll", farm.SendAllReadAll, degraded)
+	if expected, got := 2, o.writeErrors; expected != got {
+		t.Errorf("degraded: expected %d write errors, got %d", expected, got)
+	}
+	if expected, got := 2, o.consistent; expected != got {
+		t.Errorf("degraded: expected %d consistent selects, got %d", expected, got)
+	}
+}
+
+func TestLognormalLatency(t *testing.T) {
+	l, err := parseLatency("lognormal", 10*time.Millisecond, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		r     = rand.New(rand.NewSource(1))
+		n     = 10000
+		total time.Duration
+	)
+	for i := 0; i < n; i++ {
+		total += l(r)
+	}
+	if mean := total / time.Duration(n); mean < 9*time.Millisecond || mean > 11*time.Millisecond {
+		t.Errorf("expected a mean of about 10ms, got %s", mean)
+	}
+}
@@ -0,0 +1,446 @@
+package walker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsenart/tb"
+
+	"github.com/soundcloud/roshi/audit"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// controller is the waiter of the walk, which lets operators adjust a running
+// walker via the admin API: pause and resume it, and change its rate limit.
+// It also tracks the progress of the walk. It's safe for concurrent use.
+type controller struct {
+	mtx       sync.Mutex
+	cond      *sync.Cond // signaled on resume
+	paused    bool
+	limiter   *limiter
+	rate      int64 // max keys per second
+	batchSize int
+
+	walked       uint64 // keys, in total
+	perSec       uint64 // keys walked in the last full second
+	pass         int
+	passKeys     uint64
+	passEstimate uint64 // keys of the last complete pass, if any
+	lastKey      string
+	instr        instrumentation.WalkInstrumentation
+	counters     passCounters
+	reports      *reportLog // nil for no reports
+
+	triggered *triggeredWalk // nil when none is running
+}
+
+// limiter is a token bucket, which counts the waiters in it, so that it can
+// be closed once it's replaced and they've all left.
+type limiter struct {
+	*tb.Bucket
+	waiters sync.WaitGroup
+}
+
+// triggeredWalk is a walk of the keys matching a pattern, triggered via the
+// admin API, which runs alongside the regular walk.
+type triggeredWalk struct {
+	Pattern string    `json:"pattern"`
+	Began   time.Time `json:"began"`
+	Keys    uint64    `json:"keys"`
+}
+
+func newController(maxKeysPerSecond int64, batchSize int, instr instrumentation.WalkInstrumentation) *controller {
+	c := &controller{
+		limiter:   newLimiter(maxKeysPerSecond),
+		rate:      maxKeysPerSecond,
+		batchSize: batchSize,
+		instr:     instr,
+	}
+	c.cond = sync.NewCond(&c.mtx)
+	go c.measure()
+	return c
+}
+
+func newLimiter(maxKeysPerSecond int64) *limiter {
+	// Remember: it's per-key, not per-request.
+	freq := time.Duration(1/maxKeysPerSecond) * time.Second
+	return &limiter{Bucket: tb.NewBucket(maxKeysPerSecond, freq)}
+}
+
+// Wait implements waiter. It blocks while the walker is paused, and then
+// until the rate limit permits n more keys.
+func (c *controller) Wait(n int64) time.Duration {
+	began := time.Now()
+	c.mtx.Lock()
+	for c.paused {
+		c.cond.Wait()
+	}
+	l := c.limiter
+	l.waiters.Add(1)
+	c.mtx.Unlock()
+
+	l.Wait(n)
+	l.waiters.Done()
+	atomic.AddUint64(&c.walked, uint64(n))
+	return time.Since(began)
+}
+
+// measure computes the number of keys walked per second, forever.
+func (c *controller) measure() {
+	var prev uint64
+	for _ = range time.Tick(time.Second) {
+		walked := atomic.LoadUint64(&c.walked)
+		atomic.StoreUint64(&c.perSec, walked-prev)
+		prev = walked
+	}
+}
+
+// track records the progress of a walk, which is a pass over the keyspace.
+// It forwards batches from src to the returned channel, so the walk can
+// consume them as usual. The keyspace is estimated to be as large as it was
+// in the last complete pass, so progress isn't reported during the first.
+// The returned func completes the pass, and must be called once the walk
+// has consumed, and repaired, every batch, so that the pass report counts
+// the divergences, repairs, and errors of the last batch too.
+func (c *controller) track(src <-chan []string) (<-chan []string, func()) {
+	c.mtx.Lock()
+	c.pass++
+	c.passKeys = 0
+	pass, estimate := c.pass, c.passEstimate
+	c.mtx.Unlock()
+	before := c.counters.load()
+
+	began := time.Now()
+	dst := make(chan []string)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		defer close(dst)
+		for batch := range src {
+			c.mtx.Lock()
+			c.passKeys += uint64(len(batch))
+			if len(batch) > 0 {
+				c.lastKey = batch[len(batch)-1]
+			}
+			walked := c.passKeys
+			c.mtx.Unlock()
+			c.reportProgress(walked, estimate)
+			dst <- batch
+		}
+	}()
+
+	done := func() {
+		<-forwarded
+		c.mtx.Lock()
+		c.passEstimate = c.passKeys
+		walked := c.passKeys
+		c.mtx.Unlock()
+		c.instr.WalkPassComplete()
+		c.instr.WalkPassDuration(time.Since(began))
+		c.reportProgress(walked, walked)
+		if c.reports != nil {
+			ended, after := time.Now(), c.counters.load()
+			c.reports.add(passReport{
+				Pass:        pass,
+				Began:       began,
+				Ended:       ended,
+				Duration:    ended.Sub(began).Seconds(),
+				Keys:        walked,
+				Divergences: after.divergences - before.divergences,
+				Repairs:     after.repairs - before.repairs,
+				Errors:      after.errors - before.errors,
+				Failures:    after.repairFailures - before.repairFailures,
+			})
+		}
+	}
+	return dst, done
+}
+
+// reportProgress reports the progress of the pass, unless the size of the
+// keyspace isn't estimated yet. The keyspace may have grown since it was
+// estimated, so progress is capped at 100%.
+func (c *controller) reportProgress(walked, estimate uint64) {
+	if estimate <= 0 {
+		return
+	}
+	if walked > estimate {
+		walked = estimate
+	}
+	c.instr.WalkPassProgress(100 * float64(walked) / float64(estimate))
+	c.instr.WalkKeysRemaining(int(estimate - walked))
+}
+
+func (c *controller) setPaused(paused bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.paused = paused
+	if !paused {
+		c.cond.Broadcast()
+	}
+}
+
+func (c *controller) setRate(maxKeysPerSecond int64) error {
+	if maxKeysPerSecond < int64(c.batchSize) {
+		return fmt.Errorf("max keys per second should be bigger than batch size (%d)", c.batchSize)
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	old := c.limiter
+	c.limiter, c.rate = newLimiter(maxKeysPerSecond), maxKeysPerSecond
+	go func() {
+		old.waiters.Wait()
+		old.Close()
+	}()
+	return nil
+}
+
+// walkRunningError is returned when a walk is triggered while another is
+// still running.
+type walkRunningError struct{ pattern string }
+
+func (e walkRunningError) Error() string {
+	return fmt.Sprintf("a walk of %q is already running", e.pattern)
+}
+
+// trigger starts a walk of the keys matching the pattern, unless one is
+// already running. The walk function should return when the walk is
+// complete.
+func (c *controller) trigger(pattern string, matching func(string) (<-chan []string, error), walk func(<-chan []string)) error {
+	c.mtx.Lock()
+	if c.triggered != nil {
+		defer c.mtx.Unlock()
+		return walkRunningError{c.triggered.Pattern}
+	}
+	src, err := matching(pattern)
+	if err != nil {
+		c.mtx.Unlock()
+		return err
+	}
+	t := &triggeredWalk{Pattern: pattern, Began: time.Now()}
+	c.triggered = t
+	c.mtx.Unlock()
+
+	counted := make(chan []string)
+	go func() {
+		defer close(counted)
+		for batch := range src {
+			atomic.AddUint64(&t.Keys, uint64(len(batch)))
+			counted <- batch
+		}
+	}()
+	go func() {
+		log.Printf("admin: walking keys matching %q", pattern)
+		walk(counted)
+		log.Printf("admin: walked %d key(s) matching %q, %s", atomic.LoadUint64(&t.Keys), pattern, time.Since(t.Began))
+		c.mtx.Lock()
+		c.triggered = nil
+		c.mtx.Unlock()
+	}()
+	return nil
+}
+
+type status struct {
+	Paused           bool           `json:"paused"`
+	MaxKeysPerSecond int64          `json:"max_keys_per_second"`
+	KeysPerSecond    uint64         `json:"keys_per_second"`
+	KeysWalked       uint64         `json:"keys_walked"`
+	Pass             int            `json:"pass"`
+	PassKeys         uint64         `json:"pass_keys"`
+	LastKey          []byte         `json:"last_key"`
+	Triggered        *triggeredWalk `json:"triggered,omitempty"`
+}
+
+func (c *controller) status() status {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s := status{
+		Paused:           c.paused,
+		MaxKeysPerSecond: c.rate,
+		KeysPerSecond:    atomic.LoadUint64(&c.perSec),
+		KeysWalked:       atomic.LoadUint64(&c.walked),
+		Pass:             c.pass,
+		PassKeys:         c.passKeys,
+		LastKey:          []byte(c.lastKey),
+	}
+	if c.triggered != nil {
+		s.Triggered = &triggeredWalk{
+			Pattern: c.triggered.Pattern,
+			Began:   c.triggered.Began,
+			Keys:    atomic.LoadUint64(&c.triggered.Keys),
+		}
+	}
+	return s
+}
+
+// installAdmin installs the admin API of the walker on the mux. If token is
+// set, every request must present it as a bearer token. The matching
+// function returns the keys matching a pattern, for triggered walks, and
+// walk performs a walk over keys, sharing the rate limit of the regular walk.
+// deletePrefix deletes the members of a key which start with a prefix, and
+// returns them; if it's nil, the endpoint isn't installed. Every call of it
+// is recorded by the auditor.
+func installAdmin(
+	mux *http.ServeMux,
+	token string,
+	c *controller,
+	matching func(pattern string) (<-chan []string, error),
+	walk func(<-chan []string),
+	deletePrefix func(key, prefix string) ([]common.KeyScoreMember, error),
+	auditor audit.Logger,
+) {
+	post := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				respondError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+				return
+			}
+			h(w, r)
+		}
+	}
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(r, token) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				respondError(w, http.StatusUnauthorized, fmt.Errorf("admin token required"))
+				return
+			}
+			h(w, r)
+		})
+	}
+
+	handle("/admin/status", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, c.status())
+	})
+	handle("/admin/reports", func(w http.ResponseWriter, r *http.Request) {
+		if c.reports == nil {
+			respondError(w, http.StatusNotFound, fmt.Errorf("pass reports not retained"))
+			return
+		}
+		respond(w, map[string]interface{}{"reports": c.reports.recent()})
+	})
+	handle("/admin/pause", post(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("admin: pausing")
+		c.setPaused(true)
+		respond(w, c.status())
+	}))
+	handle("/admin/resume", post(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("admin: resuming")
+		c.setPaused(false)
+		respond(w, c.status())
+	}))
+	handle("/admin/rate", post(func(w http.ResponseWriter, r *http.Request) {
+		rate, err := strconv.ParseInt(r.FormValue("max.keys.per.second"), 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := c.setRate(rate); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("admin: max keys per second is now %d", rate)
+		respond(w, c.status())
+	}))
+	handle("/admin/walk", post(func(w http.ResponseWriter, r *http.Request) {
+		pattern := r.FormValue("pattern")
+		if pattern == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("pattern is required"))
+			return
+		}
+		if err := c.trigger(pattern, matching, walk); err != nil {
+			code := http.StatusBadRequest
+			if _, ok := err.(walkRunningError); ok {
+				code = http.StatusConflict
+			}
+			respondError(w, code, err)
+			return
+		}
+		respond(w, c.status())
+	}))
+	if deletePrefix == nil {
+		return
+	}
+	handle("/admin/delete-prefix", post(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			key, prefix = r.FormValue("key"), r.FormValue("prefix")
+			deleted     []common.KeyScoreMember
+			code        = http.StatusOK
+			err         error
+		)
+		defer func() {
+			entry := audit.Entry{
+				Time:      time.Now(),
+				Operation: "delete_prefix",
+				Requester: adminRequester(r),
+				Tuples:    deleted,
+				Code:      code,
+			}
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			if err := auditor.Log(entry); err != nil {
+				log.Printf("admin: audit: %s", err)
+			}
+		}()
+
+		if key == "" || prefix == "" {
+			code, err = http.StatusBadRequest, fmt.Errorf("key and prefix are required")
+			respondError(w, code, err)
+			return
+		}
+		if deleted, err = deletePrefix(key, prefix); err != nil {
+			code = http.StatusInternalServerError
+			respondError(w, code, err)
+			return
+		}
+		log.Printf("admin: %s deleted %d member(s) of %q with prefix %q", adminRequester(r), len(deleted), key, prefix)
+		respond(w, map[string]interface{}{"deleted": deleted})
+	}))
+}
+
+// adminRequester identifies the requester of an admin API call for the audit
+// log, by the X-Requester header, or else the remote address.
+func adminRequester(r *http.Request) string {
+	if requester := r.Header.Get("X-Requester"); requester != "" {
+		return requester
+	}
+	return r.RemoteAddr
+}
+
+// authorized returns true if the request presents the token as a bearer
+// token, or if no token is required.
+func authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	const scheme = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, scheme) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(h[len(scheme):]), []byte(token)) == 1
+}
+
+func respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func respondError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       err.Error(),
+		"code":        code,
+		"description": http.StatusText(code),
+	})
+}
@@ -0,0 +1,251 @@
+package walker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/audit"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func TestAdmin(t *testing.T) {
+	var (
+		ctrl   = newController(1000, 10, instrumentation.NopInstrumentation{})
+		mux     = http.NewServeMux()
+		walked  = make(chan []string)
+		auditor = &auditLog{}
+	)
+	installAdmin(
+		mux,
+		"",
+		ctrl,
+		func(pattern string) (<-chan []string, error) { return batches([]string{pattern + "1", pattern + "2"}, 10), nil },
+		func(src <-chan []string) {
+			for batch := range src {
+				walked <- batch
+			}
+		},
+		func(key, prefix string) ([]common.KeyScoreMember, error) {
+			return []common.KeyScoreMember{{Key: key, Score: 1, Member: prefix + "1"}}, nil
+		},
+		auditor,
+	)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(path string, values url.Values) (int, status) {
+		resp, err := http.PostForm(server.URL+path, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var s status
+		json.NewDecoder(resp.Body).Decode(&s)
+		return resp.StatusCode, s
+	}
+
+	// Pause, and check that Wait blocks until resumed.
+	if code, s := post("/admin/pause", nil); code != http.StatusOK || !s.Paused {
+		t.Fatalf("pause: HTTP %d, %+v", code, s)
+	}
+	waited := make(chan struct{})
+	go func() { ctrl.Wait(1); close(waited) }()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned while paused")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if code, s := post("/admin/resume", nil); code != http.StatusOK || s.Paused {
+		t.Fatalf("resume: HTTP %d, %+v", code, s)
+	}
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("Wait didn't return after resume")
+	}
+
+	// Change the rate limit.
+	if code, s := post("/admin/rate", url.Values{"max.keys.per.second": {"500"}}); code != http.StatusOK || s.MaxKeysPerSecond != 500 {
+		t.Fatalf("rate: HTTP %d, %+v", code, s)
+	}
+	if code, _ := post("/admin/rate", url.Values{"max.keys.per.second": {"5"}}); code != http.StatusBadRequest {
+		t.Fatalf("rate below batch size: expected HTTP %d, got %d", http.StatusBadRequest, code)
+	}
+
+	// Trigger a walk, which blocks until we consume it. A second one is
+	// rejected in the meantime.
+	if code, s := post("/admin/walk", url.Values{"pattern": {"foo:"}}); code != http.StatusOK || s.Triggered == nil || s.Triggered.Pattern != "foo:" {
+		t.Fatalf("walk: HTTP %d, %+v", code, s)
+	}
+	if code, _ := post("/admin/walk", url.Values{"pattern": {"bar:"}}); code != http.StatusConflict {
+		t.Fatalf("second walk: expected HTTP %d, got %d", http.StatusConflict, code)
+	}
+	if batch := <-walked; len(batch) != 2 || batch[0] != "foo:1" {
+		t.Fatalf("unexpected batch %v", batch)
+	}
+
+	// Delete by member prefix.
+	resp, err := http.PostForm(server.URL+"/admin/delete-prefix", url.Values{"key": {"foo"}, "prefix": {"bad:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var deleted struct {
+		Deleted []common.KeyScoreMember `json:"deleted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(deleted.Deleted) != 1 || deleted.Deleted[0].Member != "bad:1" {
+		t.Fatalf("delete-prefix: HTTP %d, %+v", resp.StatusCode, deleted)
+	}
+	if code, _ := post("/admin/delete-prefix", url.Values{"key": {"foo"}}); code != http.StatusBadRequest {
+		t.Fatalf("delete-prefix without prefix: expected HTTP %d, got %d", http.StatusBadRequest, code)
+	}
+
+	// Both calls of delete-prefix are audited, including the rejected one.
+	entries := auditor.get()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %+v", entries)
+	}
+	if e := entries[0]; e.Operation != "delete_prefix" || e.Code != http.StatusOK || len(e.Tuples) != 1 || e.Requester == "" {
+		t.Errorf("first audit entry: %+v", e)
+	}
+	if e := entries[1]; e.Code != http.StatusBadRequest || e.Error == "" || len(e.Tuples) != 0 {
+		t.Errorf("second audit entry: %+v", e)
+	}
+}
+
+// auditLog is an audit.Logger which records the entries in memory.
+type auditLog struct {
+	mtx     sync.Mutex
+	entries []audit.Entry
+}
+
+func (l *auditLog) Log(e audit.Entry) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.entries = append(l.entries, e)
+	return nil
+}
+
+func (l *auditLog) get() []audit.Entry {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return append([]audit.Entry{}, l.entries...)
+}
+
+func TestAdminToken(t *testing.T) {
+	var (
+		ctrl = newController(1000, 10, instrumentation.NopInstrumentation{})
+		mux  = http.NewServeMux()
+	)
+	installAdmin(mux, "secret", ctrl, nil, nil, nil, nil)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		authorization string
+		code          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	} {
+		req, _ := http.NewRequest("POST", server.URL+"/admin/pause", nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.code {
+			t.Errorf("%q: expected HTTP %d, got %d", tc.authorization, tc.code, resp.StatusCode)
+		}
+	}
+	if !ctrl.status().Paused {
+		t.Errorf("expected the authorized request to pause the walker")
+	}
+
+	// Without a deletePrefix function, delete-prefix isn't installed.
+	req, _ := http.NewRequest("POST", server.URL+"/admin/delete-prefix", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("delete-prefix: expected HTTP %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+type passInstrumentation struct {
+	instrumentation.NopInstrumentation
+	mtx       sync.Mutex
+	progress  []float64
+	remaining []int
+	completed int
+}
+
+func (i *passInstrumentation) WalkPassProgress(v float64) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.progress = append(i.progress, v)
+}
+
+func (i *passInstrumentation) WalkKeysRemaining(n int) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.remaining = append(i.remaining, n)
+}
+
+func (i *passInstrumentation) WalkPassComplete() {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.completed++
+}
+
+func TestTrackProgress(t *testing.T) {
+	var (
+		instr = &passInstrumentation{}
+		ctrl  = newController(1000, 10, instr)
+		keys  = []string{"a", "b", "c", "d"}
+	)
+	pass := func() {
+		src, done := ctrl.track(batches(keys, 2))
+		for _ = range src {
+		}
+		done()
+	}
+
+	// The first pass estimates the keyspace, so its progress is unknown
+	// until it's complete.
+	pass()
+	if expected, got := []float64{100}, instr.progress; !reflect.DeepEqual(expected, got) || instr.completed != 1 {
+		t.Fatalf("first pass: expected progress %v on completion, got %v", expected, got)
+	}
+	instr.progress, instr.remaining = nil, nil
+
+	// The second pass is measured against the first.
+	pass()
+	if expected, got := []float64{50, 100, 100}, instr.progress; !reflect.DeepEqual(expected, got) {
+		t.Errorf("progress: expected %v, got %v", expected, got)
+	}
+	if expected, got := []int{2, 0, 0}, instr.remaining; !reflect.DeepEqual(expected, got) {
+		t.Errorf("remaining: expected %v, got %v", expected, got)
+	}
+	if expected, got := 2, instr.completed; expected != got {
+		t.Errorf("completed: expected %d, got %d", expected, got)
+	}
+}
@@ -0,0 +1,40 @@
+package walker
+
+import (
+	"log"
+)
+
+// popper is implemented by queues of keys to repair first, like
+// *backfill.Queue.
+type popper interface {
+	Pop(n int) ([]string, error)
+}
+
+// prioritized forwards the batches from src, but before each of them, pops a
+// batch of up to batchSize keys from the queue, and forwards it first, until
+// the queue is empty. So keys which reads found divergent are repaired ahead
+// of the walk, as soon as they're queued. Errors popping keys are logged, and
+// the walk continues.
+func prioritized(q popper, src <-chan []string, batchSize int) <-chan []string {
+	c := make(chan []string)
+	go func() {
+		defer close(c)
+		for {
+			keys, err := q.Pop(batchSize)
+			if err != nil {
+				log.Printf("backfill: %s", err)
+			}
+			if len(keys) > 0 {
+				log.Printf("backfill: repairing %d divergent key(s) first", len(keys))
+				c <- keys
+				continue
+			}
+			batch, ok := <-src
+			if !ok {
+				return
+			}
+			c <- batch
+		}
+	}()
+	return c
+}
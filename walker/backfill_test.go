@@ -0,0 +1,47 @@
+package walker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type fixedQueue struct {
+	keys []string
+	err  error
+}
+
+func (q *fixedQueue) Pop(n int) ([]string, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	if n > len(q.keys) {
+		n = len(q.keys)
+	}
+	keys := q.keys[:n]
+	q.keys = q.keys[n:]
+	return keys, nil
+}
+
+func TestPrioritized(t *testing.T) {
+	collect := func(src <-chan []string) [][]string {
+		var got [][]string
+		for batch := range src {
+			got = append(got, batch)
+		}
+		return got
+	}
+
+	q := &fixedQueue{keys: []string{"x", "y", "z"}}
+	expected := [][]string{{"x", "y"}, {"z"}, {"a", "b"}, {"c"}}
+	if got := collect(prioritized(q, batches([]string{"a", "b", "c"}, 2), 2)); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// The walk goes on without the queue.
+	q = &fixedQueue{err: errors.New("connection refused")}
+	expected = [][]string{{"a", "b"}, {"c"}}
+	if got := collect(prioritized(q, batches([]string{"a", "b", "c"}, 2), 2)); !reflect.DeepEqual(expected, got) {
+		t.Errorf("with errors: expected %v, got %v", expected, got)
+	}
+}
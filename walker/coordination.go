@@ -0,0 +1,190 @@
+package walker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// coordinator partitions the keyspace among multiple walkers via leases in a
+// shared Redis instance. The unit of work is a single Redis instance of a
+// single cluster, i.e. one range of hash slots. A walker must hold the lease
+// on a unit to walk it. When the walk of a unit is complete, the unit is
+// marked as done for the cooldown period, so that no walker walks it again
+// until then.
+type coordinator struct {
+	pool     *redis.Pool
+	prefix   string
+	id       string
+	lease    time.Duration
+	cooldown time.Duration
+}
+
+var (
+	// acquireScript takes the lease in KEYS[1] for ARGV[1], for ARGV[2]
+	// milliseconds, unless the unit is leased by another walker, or marked as
+	// done in KEYS[2].
+	acquireScript = redis.NewScript(2, `
+		if redis.call('EXISTS', KEYS[2]) == 1 then
+			return 0
+		end
+		if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+			return 1
+		end
+		return 0
+	`)
+
+	// renewScript extends the lease in KEYS[1] by ARGV[2] milliseconds, if
+	// it's still held by ARGV[1].
+	renewScript = redis.NewScript(1, `
+		if redis.call('GET', KEYS[1]) == ARGV[1] then
+			return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		end
+		return 0
+	`)
+
+	// completeScript marks the unit as done in KEYS[2] for ARGV[2]
+	// milliseconds, and releases the lease in KEYS[1], if it's still held by
+	// ARGV[1].
+	completeScript = redis.NewScript(2, `
+		if redis.call('GET', KEYS[1]) == ARGV[1] then
+			redis.call('SET', KEYS[2], ARGV[1], 'PX', ARGV[2])
+			return redis.call('DEL', KEYS[1])
+		end
+		return 0
+	`)
+)
+
+func newCoordinator(address, prefix string, lease, cooldown time.Duration) *coordinator {
+	return &coordinator{
+		pool: &redis.Pool{
+			MaxIdle: 2,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialTimeout("tcp", address, 3*time.Second, 3*time.Second, 3*time.Second)
+			},
+		},
+		prefix:   prefix,
+		id:       fmt.Sprintf("%x", rand.Int63()),
+		lease:    lease,
+		cooldown: cooldown,
+	}
+}
+
+// scan is like the uncoordinated scan, but only emits keys from the units for
+// which this walker acquires a lease. It returns when every unit has been
+// walked or skipped once.
+func (c *coordinator) scan(clusters []cluster.Cluster, batchSize int) <-chan []string {
+	type unit struct{ cluster, instance int }
+	units := []unit{}
+	for i, cl := range clusters {
+		scanner, ok := cl.(cluster.InstanceScanner)
+		if !ok {
+			log.Fatalf("cluster %d doesn't support coordinated walks", i)
+		}
+		for j := 0; j < scanner.Instances(); j++ {
+			units = append(units, unit{i, j})
+		}
+	}
+
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		walked := 0
+		for _, index := range rand.Perm(len(units)) {
+			u := units[index]
+			name := fmt.Sprintf("%d:%d", u.cluster, u.instance)
+			ok, err := c.acquire(name)
+			if err != nil {
+				log.Printf("coordination: acquiring %s: %s", name, err)
+				continue
+			}
+			if !ok {
+				continue // leased by another walker, or recently walked
+			}
+
+			log.Printf("coordination: walking cluster %d instance %d", u.cluster+1, u.instance)
+			var (
+				done = make(chan struct{})
+				lost = make(chan struct{})
+			)
+			go c.renew(name, done, lost)
+			for batch := range clusters[u.cluster].(cluster.InstanceScanner).InstanceKeys(u.instance, batchSize, lost) {
+				ch <- batch
+			}
+			close(done)
+			select {
+			case <-lost:
+				log.Printf("coordination: abandoned the walk of %s", name)
+				continue
+			default:
+			}
+			if err := c.complete(name); err != nil {
+				log.Printf("coordination: completing %s: %s", name, err)
+				continue
+			}
+			walked++
+		}
+		log.Printf("coordination: walked %d of %d unit(s)", walked, len(units))
+	}()
+	return ch
+}
+
+func (c *coordinator) acquire(name string) (bool, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return redis.Bool(acquireScript.Do(conn, c.leaseKey(name), c.doneKey(name), c.id, ms(c.lease)))
+}
+
+// renew periodically extends the lease, until done is closed. If the lease
+// was lost, e.g. because it expired while Redis was unreachable, and another
+// walker took it, renew closes lost, and returns.
+func (c *coordinator) renew(name string, done <-chan struct{}, lost chan<- struct{}) {
+	ticker := time.NewTicker(c.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			conn := c.pool.Get()
+			held, err := redis.Bool(renewScript.Do(conn, c.leaseKey(name), c.id, ms(c.lease)))
+			conn.Close()
+			if err != nil {
+				log.Printf("coordination: renewing %s: %s", name, err)
+			} else if !held {
+				log.Printf("coordination: lost lease on %s", name)
+				close(lost)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// complete marks the unit as done, and releases the lease. If the lease was
+// lost, the unit isn't marked as done, and errLeaseLost is returned.
+func (c *coordinator) complete(name string) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	released, err := redis.Bool(completeScript.Do(conn, c.leaseKey(name), c.doneKey(name), c.id, ms(c.cooldown)))
+	if err != nil {
+		return err
+	}
+	if !released {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// errLeaseLost is returned by complete if the lease is no longer held.
+var errLeaseLost = errors.New("lease lost")
+
+func (c *coordinator) leaseKey(name string) string { return c.prefix + "lease:" + name }
+func (c *coordinator) doneKey(name string) string  { return c.prefix + "done:" + name }
+
+func ms(d time.Duration) int64 { return int64(d / time.Millisecond) }
@@ -0,0 +1,178 @@
+package walker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+)
+
+// leaseStore is an in-memory Redis which runs the scripts of the
+// coordinator, identified by their source. Leases don't expire.
+type leaseStore struct {
+	mtx  sync.Mutex
+	keys map[string]string
+}
+
+func (s *leaseStore) get(key string) (string, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	v, ok := s.keys[key]
+	return v, ok
+}
+
+func (s *leaseStore) set(key, value string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.keys[key] = value
+}
+
+// leaseConn is a redis.Conn to a leaseStore.
+type leaseConn struct {
+	redis.Conn // nil; only the methods below are used
+	store      *leaseStore
+}
+
+func (c leaseConn) Close() error { return nil }
+func (c leaseConn) Err() error   { return nil }
+
+func (c leaseConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "EVALSHA":
+		return nil, redis.Error("NOSCRIPT No matching script")
+	case "EVAL":
+	default:
+		return nil, nil
+	}
+
+	var (
+		src      = args[0].(string)
+		keyCount = args[1].(int)
+		keys     = args[2 : 2+keyCount]
+		id       = fmt.Sprint(args[2+keyCount])
+		leaseKey = keys[0].(string)
+	)
+	s := c.store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch {
+	case strings.Contains(src, "'NX'"): // acquire
+		if _, ok := s.keys[keys[1].(string)]; ok {
+			return int64(0), nil
+		}
+		if _, ok := s.keys[leaseKey]; ok {
+			return int64(0), nil
+		}
+		s.keys[leaseKey] = id
+		return int64(1), nil
+	case strings.Contains(src, "'PEXPIRE'"): // renew
+		if s.keys[leaseKey] != id {
+			return int64(0), nil
+		}
+		return int64(1), nil
+	case strings.Contains(src, "'DEL'"): // complete
+		if s.keys[leaseKey] != id {
+			return int64(0), nil
+		}
+		s.keys[keys[1].(string)] = id
+		delete(s.keys, leaseKey)
+		return int64(1), nil
+	}
+	return nil, fmt.Errorf("unknown script")
+}
+
+func newTestCoordinator(store *leaseStore, lease time.Duration) *coordinator {
+	c := newCoordinator("", "test:", lease, time.Hour)
+	c.pool = &redis.Pool{Dial: func() (redis.Conn, error) { return leaseConn{store: store}, nil }}
+	return c
+}
+
+// scanCluster is a cluster with a single instance, which has n keys, or
+// infinitely many if n is negative.
+type scanCluster struct {
+	*clustertest.Fake
+	n int
+}
+
+func (c scanCluster) Instances() int { return 1 }
+
+func (c scanCluster) InstanceKeys(index, batchSize int, stop <-chan struct{}) <-chan []string {
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		for i := 0; c.n < 0 || i < c.n; i += batchSize {
+			select {
+			case ch <- []string{fmt.Sprintf("key%d", i)}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func TestCoordinatorComplete(t *testing.T) {
+	var (
+		store = &leaseStore{keys: map[string]string{}}
+		c     = newTestCoordinator(store, time.Minute)
+	)
+	n := 0
+	for batch := range c.scan([]cluster.Cluster{scanCluster{clustertest.New(), 30}}, 10) {
+		n += len(batch)
+	}
+	if expected, got := 3, n; expected != got {
+		t.Errorf("expected %d keys, got %d", expected, got)
+	}
+	if _, ok := store.get(c.leaseKey("0:0")); ok {
+		t.Errorf("expected the lease to be released")
+	}
+	if _, ok := store.get(c.doneKey("0:0")); !ok {
+		t.Errorf("expected the unit to be marked done")
+	}
+
+	// A lease held by another walker can't be completed.
+	if ok, err := c.acquire("0:1"); err != nil || !ok {
+		t.Fatalf("expected to acquire the lease, got %v, %v", ok, err)
+	}
+	store.set(c.leaseKey("0:1"), "other")
+	if err := c.complete("0:1"); err != errLeaseLost {
+		t.Errorf("expected %v, got %v", errLeaseLost, err)
+	}
+	if _, ok := store.get(c.doneKey("0:1")); ok {
+		t.Errorf("expected the unit not to be marked done")
+	}
+}
+
+func TestCoordinatorLostLease(t *testing.T) {
+	var (
+		store = &leaseStore{keys: map[string]string{}}
+		c     = newTestCoordinator(store, 30*time.Millisecond)
+		ch    = c.scan([]cluster.Cluster{scanCluster{clustertest.New(), -1}}, 10)
+	)
+	<-ch
+
+	// Another walker took over the lease, e.g. after it expired while this
+	// walker couldn't reach Redis. The walk of the unit is abandoned on the
+	// next renewal, rather than continuing forever.
+	store.set(c.leaseKey("0:0"), "other")
+	timeout := time.After(time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-ch:
+		case <-timeout:
+			t.Fatal("timeout: the walk wasn't abandoned")
+		}
+	}
+	if holder, _ := store.get(c.leaseKey("0:0")); holder != "other" {
+		t.Errorf("expected the lease to stay with the other walker, got %q", holder)
+	}
+	if _, ok := store.get(c.doneKey("0:0")); ok {
+		t.Errorf("expected the unit not to be marked done")
+	}
+}
@@ -0,0 +1,124 @@
+package walker
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+)
+
+// walkEstimate is the estimated cost of a pass over the keyspace, from a
+// random sample of the keyspace of every cluster. Repairs aren't included;
+// they depend on how far the clusters diverged.
+type walkEstimate struct {
+	Clusters     int
+	Sampled      int           // keys read to measure the cost of a key
+	Keys         int           // walked in a pass: the keys of every cluster, once per cluster
+	Duration     time.Duration // of a pass
+	BatchLatency time.Duration // of the Select of a batch from every cluster
+	Scans        int           // SCAN commands
+	Reads        int           // commands reading a key, one per key per cluster
+	Bytes        int64         // of members and scores read
+}
+
+// estimateWalk estimates the cost of a pass over the keyspace of the
+// clusters, as walkOnce would walk it, by sampling up to sampleSize keys
+// of each cluster, and reading them from every cluster, in batches, as the
+// walk would. Nothing is repaired. The duration of a pass is bounded by the
+// rate limit, or by the latency of the Selects of its batches, which are
+// issued one after another.
+func estimateWalk(
+	clusters []cluster.Cluster,
+	sampleSize, batchSize, walkLimit int,
+	sampleRate float64,
+	maxKeysPerSecond int64,
+) (walkEstimate, error) {
+	var (
+		estimate = walkEstimate{Clusters: len(clusters)}
+		latency  time.Duration
+		selects  int
+		bytes    int64
+	)
+	for i, c := range clusters {
+		sampler, ok := c.(cluster.KeyspaceSampler)
+		if !ok {
+			return walkEstimate{}, fmt.Errorf("cluster %d doesn't support sampling its keyspace", i)
+		}
+		sample, err := sampler.SampleKeys(sampleSize)
+		if err != nil {
+			return walkEstimate{}, fmt.Errorf("cluster %d: %s", i, err)
+		}
+		estimate.Keys += int(float64(sample.Estimate) * sampleRate)
+		estimate.Scans += sample.RedisKeys/batchSize + 1
+
+		for batch := range batches(sample.Keys, batchSize) {
+			began := time.Now()
+			n, err := readBatch(clusters, batch, walkLimit)
+			if err != nil {
+				return walkEstimate{}, err
+			}
+			latency += time.Since(began)
+			selects++
+			bytes += n
+			estimate.Sampled += len(batch)
+		}
+	}
+	estimate.Reads = estimate.Keys * len(clusters)
+	if estimate.Sampled > 0 {
+		estimate.Bytes = bytes * int64(estimate.Keys) / int64(estimate.Sampled)
+		estimate.BatchLatency = latency / time.Duration(selects)
+	}
+
+	var (
+		limited   = time.Duration(float64(estimate.Keys) / float64(maxKeysPerSecond) * float64(time.Second))
+		selecting = time.Duration((estimate.Keys+batchSize-1)/batchSize) * estimate.BatchLatency
+	)
+	estimate.Duration = limited
+	if selecting > limited {
+		estimate.Duration = selecting
+	}
+	return estimate, nil
+}
+
+// readBatch selects the keys from every cluster, as the SendAllReadAll
+// Selects of the walk do, and returns the bytes of the members and scores
+// read.
+func readBatch(clusters []cluster.Cluster, keys []string, walkLimit int) (int64, error) {
+	var (
+		mtx      sync.Mutex
+		wg       sync.WaitGroup
+		bytes    int64
+		firstErr error
+	)
+	wg.Add(len(clusters))
+	for _, c := range clusters {
+		go func(c cluster.Cluster) {
+			defer wg.Done()
+			for e := range c.SelectOffset(keys, 0, walkLimit) {
+				mtx.Lock()
+				if e.Error != nil && firstErr == nil {
+					firstErr = e.Error
+				}
+				for _, ksm := range e.KeyScoreMembers {
+					bytes += int64(len(ksm.Member) + len(strconv.FormatFloat(ksm.Score, 'f', -1, 64)))
+				}
+				mtx.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	return bytes, firstErr
+}
+
+// print writes the estimate to w, for operators.
+func (e walkEstimate) print(w io.Writer, maxKeysPerSecond int64) {
+	fmt.Fprintf(w, "%d cluster(s), %d sampled key(s), each read from every cluster\n", e.Clusters, e.Sampled)
+	fmt.Fprintf(w, "keys walked per pass: %d\n", e.Keys)
+	fmt.Fprintf(w, "duration of a pass: %s (%d keys per second; Selects of a batch take %s)\n", e.Duration, maxKeysPerSecond, e.BatchLatency)
+	fmt.Fprintf(w, "Redis commands: %d SCAN, %d reads\n", e.Scans, e.Reads)
+	fmt.Fprintf(w, "data read: %d bytes of members and scores\n", e.Bytes)
+	fmt.Fprintf(w, "repairs not included\n")
+}
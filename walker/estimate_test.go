@@ -0,0 +1,60 @@
+package walker
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+)
+
+func TestEstimateWalk(t *testing.T) {
+	clusters := []cluster.Cluster{clustertest.New(), clustertest.New()}
+	for _, c := range clusters {
+		for i := 0; i < 100; i++ {
+			if err := c.Insert([]common.KeyScoreMember{{Key: fmt.Sprintf("key%d", i), Score: 1, Member: "member"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// 100 keys in each cluster, each read from both clusters, with 7 bytes
+	// of member and score.
+	e, err := estimateWalk(clusters, 10, 5, 10, 1, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Sampled != 20 || e.Keys != 200 || e.Reads != 400 || e.Scans != 42 {
+		t.Errorf("unexpected estimate %+v", e)
+	}
+	if expected, got := int64(200*2*7), e.Bytes; expected != got {
+		t.Errorf("expected %d bytes, got %d", expected, got)
+	}
+	if expected, got := 4*time.Second, e.Duration; expected != got {
+		t.Errorf("expected the rate limit to bound the duration to %s, got %s", expected, got)
+	}
+
+	// Slow Selects bound the duration, rather than the rate limit; half the
+	// keys are walked at a sample rate of 0.5.
+	clusters[1].(*clustertest.Fake).Delay(clustertest.SelectOffset, 10*time.Millisecond)
+	if e, err = estimateWalk(clusters, 10, 5, 10, 0.5, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if e.Keys != 100 || e.BatchLatency < 10*time.Millisecond || e.Duration < 20*e.BatchLatency {
+		t.Errorf("unexpected estimate %+v", e)
+	}
+	var buf bytes.Buffer
+	e.print(&buf, 1000)
+	if !strings.Contains(buf.String(), "keys walked per pass: 100\n") {
+		t.Errorf("unexpected report %q", buf.String())
+	}
+
+	clusters[0].(*clustertest.Fake).FailWith(clustertest.SampleKeys, fmt.Errorf("failtown"))
+	if _, err := estimateWalk(clusters, 10, 5, 10, 1, 50); err == nil || err.Error() != "cluster 0: failtown" {
+		t.Errorf("expected the error of cluster 0, got %v", err)
+	}
+}
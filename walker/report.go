@@ -0,0 +1,128 @@
+package walker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+// passReport summarizes a pass over the keyspace. The counts include those
+// of triggered walks running alongside the pass.
+type passReport struct {
+	Pass        int       `json:"pass"`
+	Began       time.Time `json:"began"`
+	Ended       time.Time `json:"ended"`
+	Duration    float64   `json:"duration_seconds"`
+	Keys        uint64    `json:"keys"`
+	Divergences uint64    `json:"divergences"` // key-members which differed between clusters
+	Repairs     uint64    `json:"repairs"`     // key-members sent for repair
+	Errors      uint64    `json:"errors"`      // keys which a cluster, or the walk, failed to read
+	Failures    uint64    `json:"failures"`    // key-members which failed to be repaired, or were discarded
+}
+
+// passCounters counts the outcomes of the walk, for the pass reports.
+type passCounters struct {
+	divergences    uint64
+	repairs        uint64
+	errors         uint64
+	repairFailures uint64
+}
+
+func (c *passCounters) load() passCounters {
+	return passCounters{
+		divergences:    atomic.LoadUint64(&c.divergences),
+		repairs:        atomic.LoadUint64(&c.repairs),
+		errors:         atomic.LoadUint64(&c.errors),
+		repairFailures: atomic.LoadUint64(&c.repairFailures),
+	}
+}
+
+// clean returns true if no read or repair failed between the counts before
+// and c.
+func (c passCounters) clean(before passCounters) bool {
+	return c.errors == before.errors && c.repairFailures == before.repairFailures
+}
+
+// countingInstrumentation passes everything on to the instrumentation it
+// wraps, and also counts the divergences, repairs, errors, and failed repairs
+// of the walk.
+type countingInstrumentation struct {
+	instrumentation.Instrumentation
+	counters *passCounters
+}
+
+func (i countingInstrumentation) SelectRepairNeeded(n int) {
+	atomic.AddUint64(&i.counters.divergences, uint64(n))
+	i.Instrumentation.SelectRepairNeeded(n)
+}
+
+func (i countingInstrumentation) RepairRequest(n int) {
+	atomic.AddUint64(&i.counters.repairs, uint64(n))
+	i.Instrumentation.RepairRequest(n)
+}
+
+func (i countingInstrumentation) RepairWriteFailure(n int) {
+	atomic.AddUint64(&i.counters.repairFailures, uint64(n))
+	i.Instrumentation.RepairWriteFailure(n)
+}
+
+func (i countingInstrumentation) RepairDiscarded(n int) {
+	atomic.AddUint64(&i.counters.repairFailures, uint64(n))
+	i.Instrumentation.RepairDiscarded(n)
+}
+
+func (i countingInstrumentation) SelectPartialError() {
+	atomic.AddUint64(&i.counters.errors, 1)
+	i.Instrumentation.SelectPartialError()
+}
+
+// logWriter writes to the standard logger, for pass reports without a file.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	log.Printf("pass report: %s", bytes.TrimSpace(p))
+	return len(p), nil
+}
+
+// reportLog writes each pass report as a line of JSON to its sink, and
+// retains the most recent ones for the admin API. It's safe for concurrent
+// use.
+type reportLog struct {
+	mtx     sync.Mutex
+	sink    io.Writer
+	max     int
+	reports []passReport // oldest first
+}
+
+func newReportLog(sink io.Writer, max int) *reportLog {
+	return &reportLog{sink: sink, max: max}
+}
+
+func (l *reportLog) add(r passReport) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if err := json.NewEncoder(l.sink).Encode(r); err != nil {
+		log.Printf("writing the report of pass %d: %s", r.Pass, err)
+	}
+	l.reports = append(l.reports, r)
+	if len(l.reports) > l.max {
+		l.reports = l.reports[len(l.reports)-l.max:]
+	}
+}
+
+// recent returns the retained reports, most recent first.
+func (l *reportLog) recent() []passReport {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	reports := make([]passReport, len(l.reports))
+	for i, r := range l.reports {
+		reports[len(reports)-1-i] = r
+	}
+	return reports
+}
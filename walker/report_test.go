@@ -0,0 +1,102 @@
+package walker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func TestPassReports(t *testing.T) {
+	var (
+		sink  = &bytes.Buffer{}
+		ctrl  = newController(1000, 10, instrumentation.NopInstrumentation{})
+		instr = countingInstrumentation{instrumentation.NopInstrumentation{}, &ctrl.counters}
+		keys  = []string{"a", "b", "c"}
+	)
+	ctrl.reports = newReportLog(sink, 2)
+
+	for pass := 1; pass <= 3; pass++ {
+		src, done := ctrl.track(batches(keys, 2))
+		for _ = range src {
+			instr.SelectRepairNeeded(pass)
+			instr.RepairRequest(pass)
+			instr.SelectPartialError()
+			instr.RepairWriteFailure(1)
+		}
+		done()
+	}
+
+	// Every pass is written to the sink.
+	lines := bytes.Split(bytes.TrimSpace(sink.Bytes()), []byte("\n"))
+	if expected, got := 3, len(lines); expected != got {
+		t.Fatalf("expected %d report(s) in the sink, got %d: %s", expected, got, sink)
+	}
+	var first passReport
+	if err := json.Unmarshal(lines[0], &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Pass != 1 || first.Keys != 3 || first.Divergences != 2 || first.Repairs != 2 || first.Errors != 2 || first.Failures != 2 {
+		t.Errorf("first pass: unexpected report %+v", first)
+	}
+
+	// Only the most recent are retained, newest first, and the counts are
+	// per pass.
+	mux := http.NewServeMux()
+	installAdmin(
+		mux,
+		"",
+		ctrl,
+		func(string) (<-chan []string, error) { return batches(nil, 10), nil },
+		func(<-chan []string) {},
+		nil,
+		nil,
+	)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/reports", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HTTP %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Reports []passReport `json:"reports"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 2, len(resp.Reports); expected != got {
+		t.Fatalf("expected %d retained report(s), got %d", expected, got)
+	}
+	for i, pass := range []int{3, 2} {
+		r := resp.Reports[i]
+		if r.Pass != pass || r.Divergences != uint64(2*pass) || r.Repairs != uint64(2*pass) || r.Errors != 2 {
+			t.Errorf("report %d: expected pass %d, got %+v", i, pass, r)
+		}
+	}
+}
+
+func TestPassCountersClean(t *testing.T) {
+	var (
+		counters = passCounters{}
+		instr    = countingInstrumentation{instrumentation.NopInstrumentation{}, &counters}
+		before   = counters.load()
+	)
+	instr.SelectRepairNeeded(1)
+	instr.RepairRequest(1)
+	if !counters.load().clean(before) {
+		t.Errorf("expected divergences and repairs to leave the pass clean")
+	}
+	for name, fail := range map[string]func(){
+		"read error":     func() { instr.SelectPartialError() },
+		"failed repair":  func() { instr.RepairWriteFailure(1) },
+		"dropped repair": func() { instr.RepairDiscarded(1) },
+	} {
+		before := counters.load()
+		fail()
+		if counters.load().clean(before) {
+			t.Errorf("%s: expected the pass not to be clean", name)
+		}
+	}
+}
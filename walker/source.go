@@ -0,0 +1,171 @@
+package walker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+// source is a user-provided HTTP endpoint, which serves the authoritative
+// set of each key. Requests and responses have the form of a roshi-server
+// Select: a GET with a JSON array of (base64) keys in the body and a limit
+// parameter, answered with the tuples of each key, in JSON, as records. So
+// another Roshi farm may serve as the source, too.
+type source struct {
+	url    string
+	client *http.Client
+}
+
+func newSource(url string, timeout time.Duration) *source {
+	return &source{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// fetch returns the authoritative tuples of the keys, up to limit per key.
+// Keys missing from the response are treated as empty.
+func (s *source) fetch(keys []string, limit int) (map[string][]common.KeyScoreMember, error) {
+	body := make([][]byte, len(keys))
+	for i, key := range keys {
+		body[i] = []byte(key)
+	}
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("source: HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	var response struct {
+		Records map[string][]common.KeyScoreMember `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("source: %s", err)
+	}
+	return response.Records, nil
+}
+
+// sourceRepairer is a farm.Selecter for the walk, which repairs the farm
+// toward the source. Each Select reads the keys from the farm, with read
+// repair as usual, and then from the source. Tuples of the source which the
+// farm lacks, or has with an older score, are inserted; members the source
+// lacks are deleted, with the score they have in the farm, unless they're
+// scored below the tuples the source returned. Tuples the farm has with a
+// newer score than the source are left alone, as they can't be overwritten
+// by older ones. The source must be at least as up to date as the farm, or
+// recent writes are undone.
+type sourceRepairer struct {
+	farm   *farm.Farm
+	source *source
+}
+
+// SelectOffset implements farm.Selecter. It returns the results of the farm,
+// before they were repaired toward the source.
+func (r sourceRepairer) SelectOffset(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	actual, err := r.farm.SelectOffset(keys, offset, limit)
+	if err != nil {
+		return actual, err
+	}
+	authoritative, err := r.source.fetch(keys, offset+limit)
+	if err != nil {
+		log.Printf("source repair: %s", err)
+		return actual, err
+	}
+
+	inserts, deletes := reconcile(keys, authoritative, actual, offset+limit)
+	if len(inserts) > 0 {
+		log.Printf("source repair: inserting %d tuple(s)", len(inserts))
+		if err := r.farm.Insert(inserts); err != nil {
+			log.Printf("source repair: during Insert: %s", err)
+			return actual, err
+		}
+	}
+	if len(deletes) > 0 {
+		log.Printf("source repair: deleting %d tuple(s)", len(deletes))
+		if err := r.farm.Delete(deletes); err != nil {
+			log.Printf("source repair: during Delete: %s", err)
+			return actual, err
+		}
+	}
+	return actual, nil
+}
+
+// SelectOffsetAscending implements farm.Selecter, without source repair.
+func (r sourceRepairer) SelectOffsetAscending(keys []string, offset, limit int) (map[string][]common.KeyScoreMember, error) {
+	return r.farm.SelectOffsetAscending(keys, offset, limit)
+}
+
+// SelectRange implements farm.Selecter, without source repair.
+func (r sourceRepairer) SelectRange(keys []string, start, stop common.Cursor, limit int) (map[string][]common.KeyScoreMember, error) {
+	return r.farm.SelectRange(keys, start, stop, limit)
+}
+
+// reconcile returns the writes which make the actual tuples of each key equal
+// to the authoritative ones, considering only the limit highest scored
+// tuples of the source. If the source returned limit tuples, it may have
+// more, below the lowest score it returned, so only actual tuples scored
+// above it are deleted.
+func reconcile(keys []string, authoritative, actual map[string][]common.KeyScoreMember, limit int) (inserts, deletes []common.KeyScoreMember) {
+	inserts, deletes = []common.KeyScoreMember{}, []common.KeyScoreMember{}
+	for _, key := range keys {
+		want := authoritative[key]
+		if len(want) > limit {
+			want = want[:limit]
+		}
+		var (
+			scores   = make(map[string]float64, len(want))
+			complete = len(want) < limit
+			lowest   = math.Inf(1)
+		)
+		for _, tuple := range want {
+			if score, ok := scores[tuple.Member]; !ok || tuple.Score > score {
+				scores[tuple.Member] = tuple.Score
+			}
+			lowest = math.Min(lowest, tuple.Score)
+		}
+
+		have := make(map[string]float64, len(actual[key]))
+		for _, tuple := range actual[key] {
+			have[tuple.Member] = tuple.Score
+			if _, ok := scores[tuple.Member]; !ok && (complete || tuple.Score > lowest) {
+				deletes = append(deletes, tuple)
+			}
+		}
+		for member, score := range scores {
+			if current, ok := have[member]; !ok || current < score {
+				inserts = append(inserts, common.KeyScoreMember{Key: key, Score: score, Member: member})
+			}
+		}
+	}
+	return inserts, deletes
+}
@@ -0,0 +1,143 @@
+package walker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+)
+
+func TestSourceRepairer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var keys [][]byte
+		if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if expected, got := "10", r.FormValue("limit"); expected != got {
+			t.Errorf("expected limit %s, got %s", expected, got)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"records": map[string][]common.KeyScoreMember{
+				"foo": []common.KeyScoreMember{
+					common.KeyScoreMember{Key: "foo", Score: 3, Member: "a"}, // newer
+					common.KeyScoreMember{Key: "foo", Score: 2, Member: "b"}, // missing
+					common.KeyScoreMember{Key: "foo", Score: 1, Member: "d"}, // older
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	fake := clustertest.New()
+	if err := fake.Insert([]common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"},
+		common.KeyScoreMember{Key: "foo", Score: 5, Member: "c"}, // not in the source
+		common.KeyScoreMember{Key: "foo", Score: 4, Member: "d"},
+		common.KeyScoreMember{Key: "bar", Score: 1, Member: "e"}, // not in the source
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		f = farm.New([]cluster.Cluster{fake}, 1, farm.SendAllReadAll, farm.AllRepairs, nil)
+		r = sourceRepairer{farm: f, source: newSource(server.URL, time.Second)}
+	)
+	if _, err := r.SelectOffset([]string{"foo", "bar"}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := f.SelectOffset([]string{"foo", "bar"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string][]common.KeyScoreMember{
+		"foo": []common.KeyScoreMember{
+			common.KeyScoreMember{Key: "foo", Score: 4, Member: "d"},
+			common.KeyScoreMember{Key: "foo", Score: 3, Member: "a"},
+			common.KeyScoreMember{Key: "foo", Score: 2, Member: "b"},
+		},
+		"bar": []common.KeyScoreMember{},
+	}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected\n %v, got\n %v", expected, got)
+	}
+
+	// Members the source lacks are deleted with their score, which applies
+	// over the insert with the same score.
+	var deleted []common.KeyScoreMember
+	for _, call := range fake.Calls() {
+		if call.Method == clustertest.Delete {
+			deleted = append(deleted, call.Tuples...)
+		}
+	}
+	if expected := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 5, Member: "c"},
+		common.KeyScoreMember{Key: "bar", Score: 1, Member: "e"},
+	}; !reflect.DeepEqual(expected, deleted) {
+		t.Errorf("expected deletes\n %v, got\n %v", expected, deleted)
+	}
+}
+
+func TestReconcileWindow(t *testing.T) {
+	var (
+		authoritative = map[string][]common.KeyScoreMember{
+			"foo": []common.KeyScoreMember{
+				common.KeyScoreMember{Key: "foo", Score: 5, Member: "a"},
+				common.KeyScoreMember{Key: "foo", Score: 3, Member: "b"},
+			},
+		}
+		actual = map[string][]common.KeyScoreMember{
+			"foo": []common.KeyScoreMember{
+				common.KeyScoreMember{Key: "foo", Score: 5, Member: "a"},
+				common.KeyScoreMember{Key: "foo", Score: 4, Member: "c"}, // within the window
+				common.KeyScoreMember{Key: "foo", Score: 3, Member: "b"},
+				common.KeyScoreMember{Key: "foo", Score: 3, Member: "d"}, // tied with the lowest
+				common.KeyScoreMember{Key: "foo", Score: 1, Member: "e"}, // below the window
+			},
+		}
+	)
+
+	// The source returned as many tuples as requested, so it may have more
+	// below them, and only members within its window are deleted.
+	inserts, deletes := reconcile([]string{"foo"}, authoritative, actual, 2)
+	if len(inserts) != 0 {
+		t.Errorf("expected no inserts, got %v", inserts)
+	}
+	if expected := []common.KeyScoreMember{
+		common.KeyScoreMember{Key: "foo", Score: 4, Member: "c"},
+	}; !reflect.DeepEqual(expected, deletes) {
+		t.Errorf("limited: expected deletes %v, got %v", expected, deletes)
+	}
+
+	// The source returned fewer, so it has no more, and every member it
+	// lacks is deleted.
+	if _, deletes = reconcile([]string{"foo"}, authoritative, actual, 10); len(deletes) != 3 {
+		t.Errorf("complete: expected 3 deletes, got %v", deletes)
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	fake := clustertest.New()
+	fake.Insert([]common.KeyScoreMember{common.KeyScoreMember{Key: "foo", Score: 1, Member: "a"}})
+	r := sourceRepairer{
+		farm:   farm.New([]cluster.Cluster{fake}, 1, farm.SendAllReadAll, farm.AllRepairs, nil),
+		source: newSource(server.URL, time.Second),
+	}
+	if _, err := r.SelectOffset([]string{"foo"}, 0, 10); err == nil {
+		t.Fatal("expected error, got none")
+	}
+	if n := fake.CallCount(clustertest.Delete); n != 0 {
+		t.Errorf("expected no Deletes without the source, got %d", n)
+	}
+}
@@ -0,0 +1,413 @@
+// Package walker implements roshi-walker, which walks the keyspace and
+// performs repairing Selects. It runs as the walk command of roshi.
+package walker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/roshi/audit"
+	"github.com/soundcloud/roshi/backfill"
+	"github.com/soundcloud/roshi/cli"
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/common"
+	"github.com/soundcloud/roshi/farm"
+	"github.com/soundcloud/roshi/instrumentation"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// Run runs roshi-walker, as the named command, with the command-line
+// arguments, which don't include the name.
+func Run(name string, args []string) {
+	var (
+		fs                   = cli.NewFlagSet(name)
+		redisFlags           = cli.RedisFlags(fs, 2)
+		instrumentationFlags = cli.InstrumentationFlags(fs, "roshiwalker")
+		emptyKeyTTL          = fs.Duration("empty.key.ttl", 0, "Expire keys which only contain deletes after this grace period (0 to disable); should match roshi-server")
+		selectGap            = fs.Duration("select.gap", 0*time.Millisecond, "delay between pipeline read invocations when Selecting over multiple keys")
+		maxSize              = fs.Int("max.size", 10000, "Maximum number of events per key")
+		maxSizeOverrides     = fs.String("max.size.overrides", "", "Comma-separated key prefix=size pairs, overriding -max.size for keys with the prefix; should match roshi-server")
+		batchSize            = fs.Int("batch.size", 100, "keys to select per request")
+		maxKeysPerSecond     = fs.Int64("max.keys.per.second", 1000, "max keys per second to walk")
+		scanLogInterval      = fs.Duration("scan.log.interval", 5*time.Second, "how often to report scan rates in log")
+		sampleRate           = fs.Float64("sample.rate", 1, "fraction (0-1] of walked keys to repair in each pass, chosen at random")
+		recordConvergence    = fs.Bool("record.convergence", false, "after each complete pass, record its start as the convergence time of every cluster, for roshi-server -select.max.staleness")
+		once                 = fs.Bool("once", false, "walk entire keyspace once and exit (default false, walk forever)")
+		repairKeys           = fs.String("repair.keys", "", "comma-separated keys to repair immediately, then exit (- to read newline-separated keys from stdin)")
+		backfillRedis        = fs.String("backfill.redis", "", "Redis instance of the queue of keys in which roshi-server reads detected divergences, to repair before the rest of the keyspace (blank to disable)")
+		backfillKey          = fs.String("backfill.key", "roshi:backfill", "Redis key of the backfill queue; should match roshi-server")
+		sourceURL            = fs.String("source.url", "", "HTTP endpoint serving the authoritative set of each key, to repair the farm toward (blank to only repair between clusters)")
+		sourceTimeout        = fs.Duration("source.timeout", 10*time.Second, "timeout of requests to the source")
+		repairVerifyDelay    = fs.Duration("repair.verify.delay", 0, "read repaired members back after this delay, and count those not as repaired as repair.verify_failure (0 to disable)")
+		httpAddress          = fs.String("http.address", ":6060", "HTTP listen address (profiling/metrics endpoints only)")
+		adminAddress         = fs.String("admin.address", "127.0.0.1:6061", "HTTP listen address of the admin API, which can pause the walk and delete data; loopback only by default (blank to disable)")
+		adminToken           = fs.String("admin.token", "", "bearer token required by the admin API (blank for none)")
+		adminDeletePrefix    = fs.Bool("admin.delete.prefix.enable", false, "enable /admin/delete-prefix, which deletes members by prefix; requires -admin.token and -audit.file")
+		auditFile            = fs.String("audit.file", "", "record calls of /admin/delete-prefix, with requester and outcome, to this file as newline-delimited JSON")
+		auditFileMaxBytes    = fs.Int64("audit.file.max.bytes", 100*1024*1024, "rotate the audit file when it exceeds this size (0 to disable)")
+		coordinationRedis    = fs.String("coordination.redis", "", "Redis instance shared by cooperating walkers, to partition the keyspace between them (blank to walk alone)")
+		coordinationPrefix   = fs.String("coordination.prefix", "roshi-walker:", "key prefix for coordination leases")
+		coordinationLease    = fs.Duration("coordination.lease", 30*time.Second, "lease duration; leases are renewed while walking, and expire if a walker dies")
+		coordinationCooldown = fs.Duration("coordination.cooldown", 1*time.Hour, "minimum time between walks of the same instance, across all walkers")
+		reportHistory        = fs.Int("report.history", 10, "number of recent pass reports to retain for the admin API")
+		reportFile           = fs.String("report.file", "", "file to append a JSON report of each pass to (blank to log them)")
+		validate             = fs.Bool("validate", false, "validate the configuration and Redis instances, print a report, and exit")
+		estimate             = fs.Bool("estimate", false, "estimate the duration, Redis commands, and data read of a pass under the current rate limit, from a sample of the keyspace, print a report, and exit")
+		estimateSample       = fs.Int("estimate.sample", 1000, "keys to sample from each cluster (with -estimate only)")
+	)
+	if err := cli.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Lmicroseconds)
+
+	// Validate integer arguments.
+	if *maxKeysPerSecond < int64(*batchSize) {
+		log.Fatal("max keys per second should be bigger than batch size")
+	}
+	if *sampleRate <= 0 || *sampleRate > 1 {
+		log.Fatal("sample rate should be in (0, 1]")
+	}
+
+	if *reportHistory < 0 {
+		log.Fatal("report history should be non-negative")
+	}
+
+	// Set up instrumentation backends. Several may be active at once.
+	instr, _, err := instrumentationFlags.Build(http.DefaultServeMux)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up the clusters. Keys are walked up to the largest max size, so
+	// that every member of keys with overrides is repaired.
+	sizeOverrides, err := cluster.ParseMaxSizeOverrides(*maxSizeOverrides)
+	if err != nil {
+		log.Fatal(err)
+	}
+	walkLimit := *maxSize
+	for _, size := range sizeOverrides {
+		if size > walkLimit {
+			walkLimit = size
+		}
+	}
+	clusters, err := redisFlags.Clusters(
+		*maxSize,
+		*selectGap,
+		instr,
+		cluster.EmptyKeyTTL(*emptyKeyTTL),
+		cluster.MaxSizeOverrides(sizeOverrides),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Validate the configuration, if requested.
+	if *validate {
+		if err := farm.Validate(os.Stdout, clusters); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("validation OK")
+		return
+	}
+
+	// Estimate the cost of a pass, if requested.
+	if *estimate {
+		if *estimateSample <= 0 {
+			log.Fatal("estimate sample should be positive")
+		}
+		e, err := estimateWalk(clusters, *estimateSample, *batchSize, walkLimit, *sampleRate, *maxKeysPerSecond)
+		if err != nil {
+			log.Fatal(err)
+		}
+		e.print(os.Stdout, *maxKeysPerSecond)
+		return
+	}
+
+	// Set up our rate limiter, which may be adjusted via the admin API.
+	ctrl := newController(*maxKeysPerSecond, *batchSize, instr)
+
+	// Report each pass to the log, or the report file, counting the outcomes
+	// of the walk via the instrumentation of the farm.
+	var sink io.Writer = logWriter{}
+	if *reportFile != "" {
+		file, err := os.OpenFile(*reportFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		sink = file
+	}
+	ctrl.reports = newReportLog(sink, *reportHistory)
+
+	// Build the farm.
+	repairStrategy := farm.RepairStrategy(farm.AllRepairs) // blocking
+	if *repairVerifyDelay > 0 {
+		log.Printf("verifying repairs after %s", *repairVerifyDelay)
+		repairStrategy = farm.VerifiedRepairs(*repairVerifyDelay)
+	}
+	var (
+		readStrategy = farm.SendAllReadAll
+		writeQuorum  = len(clusters) // 100%
+		farmInstr    = countingInstrumentation{instr, &ctrl.counters}
+		f            = farm.New(clusters, writeQuorum, readStrategy, repairStrategy, farmInstr)
+		dst          = farm.Selecter(f)
+	)
+	if *sourceURL != "" {
+		log.Printf("repairing toward the source of truth at %s", *sourceURL)
+		dst = sourceRepairer{farm: f, source: newSource(*sourceURL, *sourceTimeout)}
+	}
+
+	// HTTP server for profiling and metrics.
+	go func() { log.Print(http.ListenAndServe(*httpAddress, nil)) }()
+
+	// HTTP server for the admin API, on its own mux and address, so that it
+	// isn't exposed along with the metrics.
+	if *adminAddress != "" {
+		var (
+			adminMux     = http.NewServeMux()
+			deletePrefix func(key, prefix string) ([]common.KeyScoreMember, error)
+			auditor      audit.Logger
+		)
+		if *adminDeletePrefix {
+			if *adminToken == "" || *auditFile == "" {
+				log.Fatal("-admin.delete.prefix.enable requires -admin.token and -audit.file")
+			}
+			a, err := audit.NewFile(*auditFile, *auditFileMaxBytes)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer a.Close()
+			log.Printf("admin: delete-prefix enabled, auditing to %s", *auditFile)
+			deletePrefix, auditor = f.DeletePrefix, a
+		}
+		installAdmin(
+			adminMux,
+			*adminToken,
+			ctrl,
+			func(pattern string) (<-chan []string, error) { return keysMatching(clusters, pattern, *batchSize) },
+			func(src <-chan []string) { walkOnce(dst, ctrl, src, walkLimit, instr) },
+			deletePrefix,
+			auditor,
+		)
+		go func() { log.Print(http.ListenAndServe(*adminAddress, adminMux)) }()
+	}
+
+	// Repair only the specified keys, if requested.
+	if *repairKeys != "" {
+		keys, err := parseKeys(*repairKeys, os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("repairing %d key(s)", len(keys))
+		walkOnce(dst, ctrl, batches(keys, *batchSize), walkLimit, instr)
+		return
+	}
+
+	// Perform the walk.
+	defer func(t time.Time) { log.Printf("total walk complete, %s", time.Since(t)) }(time.Now())
+	var coord *coordinator
+	if *coordinationRedis != "" {
+		log.Printf("coordinating with other walkers via %s", *coordinationRedis)
+		coord = newCoordinator(*coordinationRedis, *coordinationPrefix, *coordinationLease, *coordinationCooldown)
+	}
+	if *sampleRate < 1 {
+		log.Printf("repairing a random %.2f%% of the keys in each pass", *sampleRate*100)
+	}
+	var queue *backfill.Queue
+	if *backfillRedis != "" {
+		log.Printf("repairing divergent keys queued at %s first", *backfillRedis)
+		queue = backfill.New(*backfillRedis, *backfillKey, redisFlags.ReadTimeout, 1) // only pops, so the max keys don't matter
+		defer queue.Close()
+	}
+	for {
+		began := time.Now()
+		var src <-chan []string // new key set
+		if coord != nil {
+			src = coord.scan(clusters, *batchSize)
+		} else {
+			src = scan(clusters, *batchSize, *scanLogInterval)
+		}
+		tracked, passDone := ctrl.track(src)
+		src = sample(tracked, *sampleRate)
+		if queue != nil {
+			src = prioritized(queue, src, *batchSize)
+		}
+		before := ctrl.counters.load()
+		failed := walkOnce(dst, ctrl, src, walkLimit, instr)
+		passDone()
+		if *recordConvergence && coord == nil && *sampleRate >= 1 {
+			if after := ctrl.counters.load(); failed > 0 || !after.clean(before) {
+				log.Printf("pass incomplete (%d key(s) failed to Select, %d read error(s), %d failed repair(s)), not recording convergence",
+					failed, after.errors-before.errors, after.repairFailures-before.repairFailures)
+			} else {
+				markConverged(clusters, began)
+			}
+		}
+		if *once {
+			break
+		}
+		if d := time.Since(began); coord != nil && d < *coordinationLease {
+			time.Sleep(*coordinationLease - d) // don't spin when other walkers hold every lease
+		}
+	}
+}
+
+func scan(clusters []cluster.Cluster, batchSize int, logInterval time.Duration) <-chan []string {
+	c := make(chan []string)
+	go func() {
+		defer close(c)
+		for i, index := range rand.Perm(len(clusters)) {
+			log.Printf("walking the keyspace of cluster index %d (%d/%d)", index, i+1, len(clusters))
+			for batch := range clusters[index].Keys(batchSize) {
+				c <- batch
+				// log.Printf(
+				// 	"scan: %d/%d, cluster index %d: forwarded batch of %d",
+				// 	i+1, len(clusters), index,
+				// 	len(batch),
+				// )
+			}
+		}
+	}()
+	return c
+}
+
+// markConverged records t as the convergence time of every cluster. Only a
+// complete pass by a single walker covers every key, so coordinated and
+// sampled walks don't record it, and neither do passes in which any read or
+// repair failed.
+func markConverged(clusters []cluster.Cluster, t time.Time) {
+	for i, c := range clusters {
+		tracker, ok := c.(cluster.ConvergenceTracker)
+		if !ok {
+			continue
+		}
+		if err := tracker.MarkConverged(t); err != nil {
+			log.Printf("cluster %d: recording convergence: %s", i, err)
+		}
+	}
+}
+
+// keysMatching scans every cluster for the keys matching the pattern.
+func keysMatching(clusters []cluster.Cluster, pattern string, batchSize int) (<-chan []string, error) {
+	for i, c := range clusters {
+		if _, ok := c.(cluster.PatternScanner); !ok {
+			return nil, fmt.Errorf("cluster %d doesn't support scanning by pattern", i)
+		}
+	}
+	c := make(chan []string)
+	go func() {
+		defer close(c)
+		for _, index := range rand.Perm(len(clusters)) {
+			for batch := range clusters[index].(cluster.PatternScanner).KeysMatching(pattern, batchSize) {
+				c <- batch
+			}
+		}
+	}()
+	return c, nil
+}
+
+// parseKeys returns the keys in the comma-separated list s. If s is "-", keys
+// are read from r instead, one per line. Empty keys are ignored.
+func parseKeys(s string, r io.Reader) ([]string, error) {
+	if s != "-" {
+		keys := []string{}
+		for _, key := range strings.Split(s, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		return keys, nil
+	}
+
+	keys := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// batches emits the keys over the returned channel in batches of up to
+// batchSize, and closes the channel when they're exhausted.
+func batches(keys []string, batchSize int) <-chan []string {
+	c := make(chan []string)
+	go func() {
+		defer close(c)
+		for len(keys) > 0 {
+			n := batchSize
+			if n > len(keys) {
+				n = len(keys)
+			}
+			c <- keys[:n]
+			keys = keys[n:]
+		}
+	}()
+	return c
+}
+
+// sample forwards each key from src with probability rate, in batches of the
+// remaining keys. Batches left empty aren't forwarded. Subsequent passes
+// sample independently, so over enough passes every key is repaired.
+func sample(src <-chan []string, rate float64) <-chan []string {
+	if rate >= 1 {
+		return src
+	}
+	c := make(chan []string)
+	go func() {
+		defer close(c)
+		for batch := range src {
+			sampled := make([]string, 0, int(float64(len(batch))*rate)+1)
+			for _, key := range batch {
+				if rand.Float64() < rate {
+					sampled = append(sampled, key)
+				}
+			}
+			if len(sampled) > 0 {
+				c <- sampled
+			}
+		}
+	}()
+	return c
+}
+
+// walkOnce Selects the keys from src, and returns how many of them failed
+// to Select.
+func walkOnce(
+	dst farm.Selecter,
+	wait waiter,
+	src <-chan []string,
+	maxSize int,
+	instr instrumentation.WalkInstrumentation,
+) (failed int) {
+	defer func(t time.Time) { log.Printf("single walk complete, %s", time.Since(t)) }(time.Now())
+	for batch := range src {
+		log.Printf("walk: received batch of %d, requesting tokens", len(batch))
+		wait.Wait(int64(len(batch)))
+		log.Printf("walk: received tokens, performing Select")
+		if _, err := dst.SelectOffset(batch, 0, maxSize); err != nil {
+			log.Printf("walk: Select of %d key(s): %s", len(batch), err)
+			failed += len(batch)
+		}
+		instr.WalkKeys(len(batch))
+		log.Printf("walk: performed Select, waiting for next batch")
+	}
+	return failed
+}
+
+type waiter interface {
+	Wait(int64) time.Duration
+}
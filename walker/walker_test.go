@@ -0,0 +1,50 @@
+package walker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/cluster"
+	"github.com/soundcloud/roshi/cluster/clustertest"
+)
+
+func TestSample(t *testing.T) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	count := func(src <-chan []string) (keys, batches int) {
+		for batch := range src {
+			if len(batch) <= 0 {
+				t.Errorf("received an empty batch")
+			}
+			keys, batches = keys+len(batch), batches+1
+		}
+		return keys, batches
+	}
+
+	if n, _ := count(sample(batches(keys, 100), 1)); n != len(keys) {
+		t.Errorf("rate 1: expected %d keys, got %d", len(keys), n)
+	}
+	if n, _ := count(sample(batches(keys, 100), 0.1)); n < 800 || n > 1200 {
+		t.Errorf("rate 0.1: expected about 1000 keys, got %d", n)
+	}
+
+	// Batches left empty aren't forwarded.
+	if _, b := count(sample(batches(keys, 1), 0.01)); b > 200 {
+		t.Errorf("rate 0.01: expected about 100 batches, got %d", b)
+	}
+}
+
+func TestMarkConverged(t *testing.T) {
+	var (
+		fake = clustertest.New()
+		now  = time.Now()
+	)
+	markConverged([]cluster.Cluster{fake}, now)
+	if got, err := fake.Converged(); err != nil || !got.Equal(now) {
+		t.Errorf("expected %s, got %s (%v)", now, got, err)
+	}
+}
@@ -0,0 +1,250 @@
+// Package webhook provides a farm.Notifier which POSTs the keys modified by
+// writes to a URL, so downstream systems can react to changes.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+// SignatureHeader is the request header carrying the signature of the body,
+// if the Dispatcher has a secret.
+const SignatureHeader = "X-Roshi-Signature"
+
+// Event is the notification of a write to a single key, as POSTed. Like the
+// tuples of the roshi-server API, keys are base64-encoded.
+type Event struct {
+	Key     []byte `json:"key"`
+	Op      string `json:"op"` // "insert" or "delete"
+	Members int    `json:"members"`
+}
+
+// Batch is the body of every request: the events, in the order the writes
+// succeeded.
+type Batch struct {
+	Events []Event `json:"events"`
+}
+
+// Dispatcher is a farm.Notifier which buffers events, and POSTs them to a URL
+// in batches, as JSON: when a batch is full, on every flush interval, and on
+// Close. Failed requests are retried with exponential backoff, on the first
+// flush after their backoff, so that retries don't hold up other batches;
+// batches which still fail are logged and dropped. Events beyond a bound of
+// buffered events, including those waiting to be retried, are dropped, too,
+// so a slow or unavailable endpoint never slows down writes.
+type Dispatcher struct {
+	sync.Mutex
+	url        string
+	secret     []byte
+	client     *http.Client
+	batchSize  int
+	maxPending int
+	retries    int
+	backoff    time.Duration // before the first retry; doubles with each retry
+	pending    []Event
+	retrying   int // events of the failed batches
+	full       chan struct{}
+	quit       chan chan struct{}
+	failed     []queuedBatch // waiting to be retried, oldest first; owned by the loop
+}
+
+// queuedBatch is an encoded batch, and the state of its retries.
+type queuedBatch struct {
+	body     []byte
+	events   int
+	attempts int
+	due      time.Time
+}
+
+// New returns a new Dispatcher POSTing to url, with the timeout per request.
+// Batches carry at most batchSize events, and buffered events are sent at
+// least every flushInterval. Failed requests are retried up to retries times.
+// If secret isn't empty, every request is signed with it. Callers must Close
+// the Dispatcher to send remaining events.
+func New(url, secret string, timeout, flushInterval time.Duration, batchSize, retries int) *Dispatcher {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	d := &Dispatcher{
+		url:        url,
+		secret:     []byte(secret),
+		client:     &http.Client{Timeout: timeout},
+		batchSize:  batchSize,
+		maxPending: 100 * batchSize,
+		retries:    retries,
+		backoff:    100 * time.Millisecond,
+		full:       make(chan struct{}, 1),
+		quit:       make(chan chan struct{}),
+	}
+	go d.loop(flushInterval)
+	return d
+}
+
+// Notify implements farm.Notifier. It never blocks on the endpoint.
+func (d *Dispatcher) Notify(events []farm.KeyEvent) error {
+	d.Lock()
+	defer d.Unlock()
+	dropped := 0
+	for _, e := range events {
+		if len(d.pending)+d.retrying >= d.maxPending {
+			dropped++
+			continue
+		}
+		d.pending = append(d.pending, Event{Key: []byte(e.Key), Op: e.Op, Members: e.Members})
+	}
+	if len(d.pending) >= d.batchSize {
+		select {
+		case d.full <- struct{}{}:
+		default:
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("webhook: %d event(s) dropped, %d already buffered", dropped, d.maxPending)
+	}
+	return nil
+}
+
+// Close stops the periodic flush, and sends any buffered events. Batches
+// waiting to be retried are retried once more, without waiting for their
+// backoff.
+func (d *Dispatcher) Close() {
+	c := make(chan struct{})
+	d.quit <- c
+	<-c
+}
+
+// Signature returns the value of the SignatureHeader for the body, signed
+// with the secret: "sha256=" and the hex-encoded HMAC-SHA256 of the body.
+// Receivers verify requests by computing it themselves, and comparing.
+func Signature(secret, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+func (d *Dispatcher) loop(flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.retry(now, false)
+			d.flush(false)
+		case <-d.full:
+			d.flush(true)
+		case c := <-d.quit:
+			d.retry(time.Now(), true)
+			d.flush(false)
+			d.retry(time.Now(), true) // batches which just failed
+			close(c)
+			return
+		}
+	}
+}
+
+// flush sends the buffered events, in batches. If onlyFull is true, a final
+// partial batch stays buffered.
+func (d *Dispatcher) flush(onlyFull bool) {
+	for {
+		d.Lock()
+		n := len(d.pending)
+		if n > d.batchSize {
+			n = d.batchSize
+		}
+		if n <= 0 || (onlyFull && n < d.batchSize) {
+			d.Unlock()
+			return
+		}
+		events := d.pending[:n:n]
+		d.pending = d.pending[n:]
+		d.Unlock()
+
+		body, err := json.Marshal(Batch{Events: events})
+		if err != nil {
+			log.Printf("webhook: dropping %d event(s): %s", len(events), err)
+			continue
+		}
+		d.send(queuedBatch{body: body, events: len(events)}, false)
+	}
+}
+
+// retry sends the failed batches due by now, or all of them if final.
+func (d *Dispatcher) retry(now time.Time, final bool) {
+	failed := d.failed
+	d.failed = nil
+	d.Lock()
+	d.retrying = 0
+	d.Unlock()
+	for _, b := range failed {
+		if !final && now.Before(b.due) {
+			d.requeue(b)
+			continue
+		}
+		d.send(b, final)
+	}
+}
+
+// send POSTs one batch. If it fails with a network error, HTTP 429 or 5xx,
+// it's queued to be retried after the backoff, unless this is the final
+// attempt, or the retries are used up.
+func (d *Dispatcher) send(b queuedBatch, final bool) {
+	retry, err := d.post(b.body)
+	if err == nil {
+		return
+	}
+	if !retry || final || b.attempts >= d.retries {
+		log.Printf("webhook: dropping %d event(s): %s", b.events, err)
+		return
+	}
+	b.due = time.Now().Add(d.backoff << uint(b.attempts)) // doubles with each retry
+	b.attempts++
+	d.requeue(b)
+}
+
+// requeue queues the batch to be retried, dropping the oldest batches if the
+// buffered events exceed the bound.
+func (d *Dispatcher) requeue(b queuedBatch) {
+	d.Lock()
+	defer d.Unlock()
+	d.failed = append(d.failed, b)
+	d.retrying += b.events
+	for len(d.failed) > 1 && d.retrying > d.maxPending {
+		log.Printf("webhook: dropping %d event(s): too many waiting to be retried", d.failed[0].events)
+		d.retrying -= d.failed[0].events
+		d.failed = d.failed[1:]
+	}
+}
+
+func (d *Dispatcher) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", d.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(d.secret) > 0 {
+		req.Header.Set(SignatureHeader, Signature(d.secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("webhook endpoint returned HTTP %d", resp.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/roshi/farm"
+)
+
+func TestDispatcher(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = 0
+		received = []Event{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		if expected, got := Signature([]byte("secret"), body), r.Header.Get(SignatureHeader); expected != got {
+			t.Errorf("expected signature %q, got %q", expected, got)
+		}
+		if requests++; requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // retried
+			return
+		}
+		var batch Batch
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Error(err)
+		}
+		if len(batch.Events) > 2 {
+			t.Errorf("expected batches of at most 2 events, got %d", len(batch.Events))
+		}
+		received = append(received, batch.Events...)
+	}))
+	defer server.Close()
+
+	d := New(server.URL, "secret", time.Second, time.Hour, 2, 1)
+	d.backoff = time.Millisecond
+	if err := d.Notify([]farm.KeyEvent{
+		farm.KeyEvent{Key: "foo", Op: farm.OpInsert, Members: 2},
+		farm.KeyEvent{Key: "bar", Op: farm.OpInsert, Members: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for failed := false; !failed; { // the full batch is sent right away
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		failed = requests > 0
+		mu.Unlock()
+	}
+	if err := d.Notify([]farm.KeyEvent{farm.KeyEvent{Key: "foo", Op: farm.OpDelete, Members: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []Event{
+		Event{Key: []byte("foo"), Op: "insert", Members: 2},
+		Event{Key: []byte("bar"), Op: "insert", Members: 1},
+		Event{Key: []byte("foo"), Op: "delete", Members: 1},
+	}
+	if !reflect.DeepEqual(expected, received) {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+	if expected, got := 3, requests; expected != got {
+		t.Errorf("expected %d requests, got %d", expected, got)
+	}
+}
+
+func TestDispatcherDrops(t *testing.T) {
+	d := New("http://localhost:0", "", time.Second, time.Hour, 10, 0)
+	defer d.Close()
+	d.maxPending = 1
+	if err := d.Notify([]farm.KeyEvent{farm.KeyEvent{Key: "foo"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Notify([]farm.KeyEvent{farm.KeyEvent{Key: "bar"}}); err == nil {
+		t.Error("expected an error for dropped events, got none")
+	}
+}
+
+func TestDispatcherRetryDoesntBlock(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = 0
+		received = make(chan []Event, 2)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // retried on Close
+			return
+		}
+		var batch Batch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		received <- batch.Events
+	}))
+	defer server.Close()
+
+	// The first batch waits for its backoff, and the second one is sent
+	// right away, rather than after it.
+	d := New(server.URL, "", time.Second, time.Hour, 1, 1)
+	d.backoff = time.Hour
+	d.Notify([]farm.KeyEvent{farm.KeyEvent{Key: "foo", Op: farm.OpInsert, Members: 1}})
+	d.Notify([]farm.KeyEvent{farm.KeyEvent{Key: "bar", Op: farm.OpInsert, Members: 1}})
+	select {
+	case events := <-received:
+		if expected, got := "bar", string(events[0].Key); expected != got {
+			t.Errorf("expected %s first, got %s", expected, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout: the retry blocked the next batch")
+	}
+	d.Close()
+	if expected, got := "foo", string((<-received)[0].Key); expected != got {
+		t.Errorf("expected %s to be retried on Close, got %s", expected, got)
+	}
+}